@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/archive"
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/storage"
+	"github.com/denismitr/auditbase/internal/utils/clock"
+	"github.com/denismitr/auditbase/internal/utils/env"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/denismitr/goenv"
+)
+
+const usage = `usage:
+  archive export -from 2020-01-01 -to 2020-02-01 -dir /mnt/archive
+  archive import -dir /mnt/archive/20200101-20200201 [-dsn DSN]`
+
+const dayLayout = "2006-01-02"
+
+// archive exports the actions registered within a range of days into gzip compressed NDJSON files
+// partitioned by day and microservice, or restores such an archive into a database
+func main() {
+	env.LoadFromDotEnv()
+
+	lg := logger.NewStdoutLogger(goenv.StringOrDefault("APP_ENV", "prod"), "ARCHIVE")
+
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var result interface{}
+	var err error
+
+	switch os.Args[1] {
+	case "export":
+		result, err = export(lg, os.Args[2:])
+	case "import":
+		result, err = restore(lg, os.Args[2:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		lg.Error(err)
+		os.Exit(1)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(result); err != nil {
+		lg.Error(err)
+		os.Exit(1)
+	}
+}
+
+func export(lg logger.Logger, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	from := fs.String("from", "", "first day of the range, e.g. 2020-01-01")
+	to := fs.String("to", "", "day following the last one of the range")
+	dir := fs.String("dir", "", "root directory, the archive is written into a new directory under it")
+	batch := fs.Int("batch", archive.DefaultBatchSize, "number of actions read in a single transaction")
+	_ = fs.Parse(args)
+
+	fromDay, err := time.Parse(dayLayout, *from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -from day: %w", err)
+	}
+
+	toDay, err := time.Parse(dayLayout, *to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -to day: %w", err)
+	}
+
+	if !fromDay.Before(toDay) || *dir == "" {
+		return nil, fmt.Errorf("-from has to precede -to and -dir is required\n%s", usage)
+	}
+
+	database, err := connect(lg, goenv.MustString("AUDITBASE_DB_DSN"))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+
+	w, err := archive.NewWriter(
+		filepath.Join(*dir, fromDay.Format("20060102")+"-"+toDay.Format("20060102")),
+		clock.New().CurrentTime(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// an interrupted export leaves no manifest, so it is never taken for a complete archive
+	if _, err := archive.NewExporter(database, lg, *batch).Export(ctx, w, fromDay, toDay); err != nil {
+		return nil, err
+	}
+
+	return w.Close()
+}
+
+func restore(lg logger.Logger, args []string) (interface{}, error) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dir := fs.String("dir", "", "directory of the archive, the one holding its manifest")
+	dsn := fs.String("dsn", "", "database to restore into, AUDITBASE_DB_DSN by default")
+	batch := fs.Int("batch", archive.DefaultBatchSize, "number of actions restored in a single transaction")
+	_ = fs.Parse(args)
+
+	if *dir == "" {
+		return nil, fmt.Errorf("-dir is required\n%s", usage)
+	}
+
+	if *dsn == "" {
+		*dsn = goenv.MustString("AUDITBASE_DB_DSN")
+	}
+
+	database, err := connect(lg, *dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, stop := interruptible()
+	defer stop()
+
+	return archive.NewImporter(database, lg, *batch).Import(ctx, *dir)
+}
+
+func connect(lg logger.Logger, dsn string) (db.Database, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return storage.ConnectAndMigrate(ctx, lg, dsn, 2, 1)
+}
+
+// interruptible - context canceled on SIGINT or SIGTERM, work stops between batches
+func interruptible() (context.Context, context.CancelFunc) {
+	ctx, stop := context.WithCancel(context.Background())
+
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-terminate:
+			stop()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, stop
+}
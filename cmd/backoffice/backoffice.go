@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"github.com/denismitr/auditbase/internal/auth"
 	"github.com/denismitr/auditbase/internal/service"
+	"github.com/denismitr/auditbase/internal/utils/clock"
 	"github.com/denismitr/auditbase/internal/utils/env"
 	"github.com/denismitr/goenv"
 	"github.com/labstack/echo"
@@ -13,12 +16,13 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/denismitr/auditbase/internal/db/mysql"
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/storage"
 	"github.com/denismitr/auditbase/internal/flow"
 	"github.com/denismitr/auditbase/internal/flow/queue"
+	"github.com/denismitr/auditbase/internal/health"
 	"github.com/denismitr/auditbase/internal/rest"
 	"github.com/denismitr/auditbase/internal/utils/logger"
-	"github.com/jmoiron/sqlx"
 	"github.com/pkg/profile"
 )
 
@@ -32,8 +36,9 @@ func main() {
 	port := ":" + goenv.MustString("BACK_OFFICE_API_PORT")
 
 	restCfg := rest.Config{
-		Port:      port,
-		BodyLimit: "250K",
+		Port:         port,
+		BodyLimit:    "250K",
+		TailConsumer: goenv.StringOrDefault("BACK_OFFICE_TAIL_CONSUMER", tailConsumer()),
 	}
 
 	backOffice, err := createBackOffice(lg, restCfg)
@@ -72,7 +77,7 @@ func createBackOffice(lg logger.Logger, restCfg rest.Config) (*rest.API, error)
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 
-	connCh := make(chan *sqlx.DB, 1)
+	dbCh := make(chan db.Database, 1)
 	afCh := make(chan *flow.MQActionFlow, 1)
 	errCh := make(chan error, 2)
 
@@ -82,14 +87,18 @@ func createBackOffice(lg logger.Logger, restCfg rest.Config) (*rest.API, error)
 	go func() {
 		defer wg.Done()
 
-		mq := queue.Rabbit(goenv.MustString("RABBITMQ_DSN"), lg, 3)
+		mq, err := queue.NewShared(goenv.StringOrDefault("QUEUE_DRIVER", queue.RabbitMQDriver), goenv.String("RABBITMQ_DSN"), lg, 3)
+		if err != nil {
+			errCh <- err
+			return
+		}
 
 		if err := mq.Connect(ctx); err != nil {
 			errCh <- err
 			return
 		}
 
-		ef := flow.New(mq, lg, flow.Config{
+		ef := flow.New(mq, lg, clock.New(), flow.Config{
 			ExchangeName: goenv.MustString("ACTIONS_EXCHANGE"),
 			ActionsCreateQueue: goenv.MustString("NEW_ACTIONS_QUEUE"),
 			ActionsUpdateQueue: goenv.MustString("UPDATE_ACTIONS_QUEUE"),
@@ -104,19 +113,22 @@ func createBackOffice(lg logger.Logger, restCfg rest.Config) (*rest.API, error)
 			return
 		}
 
+		// keeps the connection to the message queue alive and its status up to date
+		ef.Start()
+
 		afCh <- ef
 	}()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		dbConn, err := mysql.ConnectAndMigrate(ctx, lg, goenv.MustString("AUDITBASE_DB_DSN"), 50, 10)
+		database, err := storage.ConnectAndMigrate(ctx, lg, goenv.MustString("AUDITBASE_DB_DSN"), 50, 10)
 		if err != nil {
 			errCh <- err
 			return
 		}
 
-		connCh <- dbConn
+		dbCh <- database
 	}()
 
 	wg.Wait()
@@ -128,13 +140,51 @@ func createBackOffice(lg logger.Logger, restCfg rest.Config) (*rest.API, error)
 		lg.Debugf("Connection to DB and RabbitMQ have been established")
 	}
 
-	db := mysql.NewDatabase(<-connCh, lg)
+	database := <-dbCh
+	af := <-afCh
 
 	services := rest.BackOfficeServices{
-		Actions:       service.NewActionService(db, lg),
-		Microservices: service.NewMicroserviceService(db, lg),
-		Entities:      service.NewEntityService(db, lg),
+		Actions:       service.NewActionService(database, lg),
+		Microservices: service.NewMicroserviceService(database, lg),
+		Entities:      service.NewEntityService(database, lg),
+		Integrity:     service.NewIntegrityService(database, lg),
+		APIKeys:       service.NewAPIKeyService(database, lg, clock.New()),
+		Signing:       service.NewSigningService(database, lg, clock.New()),
+		AccessLog:     service.NewAccessLogService(database, lg),
+		Retention:     service.NewRetentionService(database, lg, clock.New()),
+		Webhooks:      service.NewWebhookService(database, lg, clock.New(), nil),
+		Timeouts:      service.NewTimeoutService(database, lg, clock.New()),
+	}
+
+	hc := health.NewChecker(health.DefaultTimeout).
+		Add("queue", health.Flow(af)).
+		Add("database", health.Database(database))
+
+	tokens, err := createTokens(lg)
+	if err != nil {
+		return nil, err
+	}
+
+	return rest.BackOfficeAPI(echo.New(), restCfg, lg, af, services, tokens, hc), nil
+}
+
+// tailConsumer - every replica of the back-office receives action events of its own
+func tailConsumer() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "backoffice"
+	}
+
+	return fmt.Sprintf("%s_%d", host, os.Getpid())
+}
+
+// createTokens - access tokens are signed with a local key, so that the back-office
+// works without an identity provider, nil disables authentication altogether
+func createTokens(lg logger.Logger) (*auth.Tokens, error) {
+	if goenv.IsTruthy("BACK_OFFICE_AUTH_DISABLED") {
+		lg.Debugf("Back-office authentication is disabled")
+		return nil, nil
 	}
 
-	return rest.BackOfficeAPI(echo.New(), restCfg, lg, <-afCh, services), nil
+	return auth.NewTokens([]byte(goenv.MustString("BACK_OFFICE_TOKEN_KEY")), clock.New())
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/archive"
+	"github.com/denismitr/auditbase/internal/db/storage"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/service"
+	"github.com/denismitr/auditbase/internal/utils/clock"
+	"github.com/denismitr/auditbase/internal/utils/env"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/denismitr/goenv"
+)
+
+// cleaner creates the upcoming action partitions, enforces the retention rules once
+// and prints the report of what it removed, it is meant to be run periodically, e.g. by cron
+func main() {
+	env.LoadFromDotEnv()
+
+	lg := logger.NewStdoutLogger(goenv.StringOrDefault("APP_ENV", "prod"), "CLEANER")
+
+	if err := run(lg); err != nil {
+		lg.Error(err)
+		os.Exit(1)
+	}
+}
+
+func run(lg logger.Logger) error {
+	var batch int
+	var dryRun bool
+	var grace time.Duration
+	var archiveDir string
+	var ahead int
+	flag.IntVar(&batch, "batch", service.DefaultCleanupBatchSize, "number of records removed in a single transaction")
+	flag.BoolVar(&dryRun, "dry-run", false, "only count the expired actions, nothing is removed")
+	flag.DurationVar(&grace, "orphan-grace", service.DefaultOrphanGrace, "entities and entity types younger than that are kept")
+	flag.StringVar(&archiveDir, "archive-dir", "", "when set, pruned actions are archived into a new directory under it")
+	flag.IntVar(&ahead, "partitions-ahead", service.DefaultPartitionsAhead, "number of months following the current one action partitions are created for")
+	flag.Parse()
+
+	connectCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	database, err := storage.ConnectAndMigrate(connectCtx, lg, goenv.MustString("AUDITBASE_DB_DSN"), 2, 1)
+	if err != nil {
+		return err
+	}
+
+	// a run may take long, but stops between batches once interrupted
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-terminate
+		stop()
+	}()
+
+	clk := clock.New()
+
+	// partitions are maintained first, so that a failing cleanup never leaves actions without them
+	if !dryRun {
+		if _, err := service.NewPartitionService(database, lg, clk).Maintain(ctx, ahead); err != nil {
+			return err
+		}
+	}
+
+	retention := service.NewRetentionService(database, lg, clk)
+	opts := service.CleanupOptions{
+		BatchSize:   batch,
+		OrphanGrace: grace,
+		DryRun:      dryRun,
+	}
+
+	var w *archive.Writer
+	if archiveDir != "" && !dryRun {
+		now := clk.CurrentTime().UTC()
+		w, err = archive.NewWriter(filepath.Join(archiveDir, "pruned-"+now.Format("20060102T150405Z")), now)
+		if err != nil {
+			return err
+		}
+
+		exporter := archive.NewExporter(database, lg, batch)
+		opts.Archive = func(ctx context.Context, IDs []model.ID) error {
+			return exporter.ExportIDs(ctx, w, IDs)
+		}
+	}
+
+	report, err := retention.Enforce(ctx, opts)
+
+	// whatever was pruned before a failure is archived already, so the manifest is written anyway
+	if w != nil {
+		manifest, closeErr := w.Close()
+		if closeErr != nil {
+			lg.Error(closeErr)
+		} else {
+			lg.Debugf("archived %d pruned actions into %s", manifest.Actions, w.Dir())
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(report)
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"github.com/denismitr/auditbase/internal/service"
 	"github.com/denismitr/goenv"
-	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"os"
 	"os/signal"
@@ -13,9 +12,13 @@ import (
 	"time"
 
 	"github.com/denismitr/auditbase/internal/consumer"
-	"github.com/denismitr/auditbase/internal/db/mysql"
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/storage"
 	"github.com/denismitr/auditbase/internal/flow"
 	"github.com/denismitr/auditbase/internal/flow/queue"
+	"github.com/denismitr/auditbase/internal/health"
+	"github.com/denismitr/auditbase/internal/metrics"
+	"github.com/denismitr/auditbase/internal/utils/clock"
 	"github.com/denismitr/auditbase/internal/utils/env"
 	"github.com/denismitr/auditbase/internal/utils/logger"
 	"github.com/pkg/profile"
@@ -46,11 +49,20 @@ func run(lg logger.Logger) error {
 
 	consumerName := goenv.StringOrDefault("CONSUMER_NAME", defaultConsumerName)
 
-	c, err := createConsumer(consumerName, lg, cfg)
+	c, hc, err := createConsumer(consumerName, lg, cfg)
 	if err != nil {
 		return err
 	}
 
+	hc.Add("consumer", c.Running)
+
+	stopHealth := health.Serve(":"+goenv.StringOrDefault("HEALTH_PORT", "3002"), hc, lg.Error)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = stopHealth(ctx)
+	}()
+
 	terminate := make(chan os.Signal, 1)
 	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM)
 	errCh := make(chan error, 1)
@@ -69,7 +81,7 @@ func run(lg logger.Logger) error {
 	for {
 		select {
 			case err := <-errCh:
-				lg.Error(errors.Errorf("consumer [%s] exiting with error %s", err))
+				lg.Error(errors.Errorf("consumer [%s] exiting with error %s", consumerName, err))
 				return err
 			case <-doneCh:
 				lg.Error(errors.Errorf("consumer [%s] is done", consumerName))
@@ -78,11 +90,11 @@ func run(lg logger.Logger) error {
 	}
 }
 
-func createConsumer(consumerName string, lg logger.Logger, cfg flow.Config) (*consumer.Consumer, error) {
+func createConsumer(consumerName string, lg logger.Logger, cfg flow.Config) (*consumer.Consumer, *health.Checker, error) {
 	startCtx, cancel := context.WithTimeout(context.Background(), 60 * time.Second)
 	defer cancel()
 
-	connCh := make(chan *sqlx.DB, 1)
+	dbCh := make(chan db.Database, 1)
 	afCh := make(chan *flow.MQActionFlow, 1)
 	errCh := make(chan error, 2)
 
@@ -91,26 +103,30 @@ func createConsumer(consumerName string, lg logger.Logger, cfg flow.Config) (*co
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		dbConn, err := mysql.ConnectAndMigrate(startCtx, lg, goenv.MustString("AUDITBASE_DB_DSN"), 200, 20)
+		database, err := storage.ConnectAndMigrate(startCtx, lg, goenv.MustString("AUDITBASE_DB_DSN"), 200, 20)
 		if err != nil {
 			errCh <- err
 			return
 		}
 
-		connCh <- dbConn
+		dbCh <- database
 	}()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		mq := queue.Rabbit(goenv.MustString("RABBITMQ_DSN"), lg, 3)
+		mq, err := queue.NewShared(goenv.StringOrDefault("QUEUE_DRIVER", queue.RabbitMQDriver), goenv.String("RABBITMQ_DSN"), lg, 3)
+		if err != nil {
+			errCh <- err
+			return
+		}
 
 		if err := mq.Connect(startCtx); err != nil {
 			errCh <- err
 			return
 		}
 
-		af := flow.New(mq, lg, cfg)
+		af := flow.New(mq, lg, clock.New(), cfg)
 
 		if err := af.Scaffold(); err != nil {
 			errCh <- err
@@ -124,18 +140,25 @@ func createConsumer(consumerName string, lg logger.Logger, cfg flow.Config) (*co
 
 	select {
 	case err := <-errCh:
-		return nil, err
+		return nil, nil, err
 	default:
 		lg.Debugf("consumer dependencies activated")
 	}
 
-	conn := <-connCh
+	database := <-dbCh
 	af := <-afCh
 
-	db := mysql.NewDatabase(conn, lg)
-	actionService := service.NewActionService(db, lg)
+	if err := metrics.RegisterQueues(af, cfg.Queues()...); err != nil {
+		return nil, nil, err
+	}
+
+	hc := health.NewChecker(health.DefaultTimeout).
+		Add("queue", health.Flow(af)).
+		Add("database", health.Database(database))
+
+	actionService := service.NewActionService(database, lg)
 
-	return consumer.New(consumerName, af, lg, actionService), nil
+	return consumer.New(consumerName, af, lg, actionService), hc, nil
 }
 
 func debug(isErrorsConsumer bool) {
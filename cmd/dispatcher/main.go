@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db/storage"
+	"github.com/denismitr/auditbase/internal/flow"
+	"github.com/denismitr/auditbase/internal/flow/queue"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/service"
+	"github.com/denismitr/auditbase/internal/utils/clock"
+	"github.com/denismitr/auditbase/internal/utils/env"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/denismitr/goenv"
+)
+
+const defaultDispatcherName = "dispatcher"
+
+// dispatcher turns action events into webhook deliveries of the rules the actions match
+// and posts the due deliveries, retrying the failed ones. Only a single instance is to be run
+func main() {
+	env.LoadFromDotEnv()
+
+	lg := logger.NewStdoutLogger(goenv.StringOrDefault("APP_ENV", "prod"), "DISPATCHER")
+
+	if err := run(lg); err != nil {
+		lg.Error(err)
+		os.Exit(1)
+	}
+}
+
+func run(lg logger.Logger) error {
+	var batch int
+	var interval time.Duration
+	var timeout time.Duration
+	flag.IntVar(&batch, "batch", service.DefaultWebhookBatchSize, "number of due deliveries posted at a time")
+	flag.DurationVar(&interval, "interval", 5*time.Second, "how often due deliveries are looked for")
+	flag.DurationVar(&timeout, "timeout", service.DefaultWebhookTimeout, "how long the receiver of a webhook is waited for")
+	flag.Parse()
+
+	connectCtx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	database, err := storage.ConnectAndMigrate(connectCtx, lg, goenv.MustString("AUDITBASE_DB_DSN"), 10, 2)
+	if err != nil {
+		return err
+	}
+
+	mq, err := queue.NewShared(goenv.StringOrDefault("QUEUE_DRIVER", queue.RabbitMQDriver), goenv.String("RABBITMQ_DSN"), lg, 3)
+	if err != nil {
+		return err
+	}
+
+	if err := mq.Connect(connectCtx); err != nil {
+		return err
+	}
+
+	af := flow.New(mq, lg, clock.New(), flow.Config{
+		ExchangeName:       goenv.MustString("ACTIONS_EXCHANGE"),
+		ActionsCreateQueue: goenv.MustString("NEW_ACTIONS_QUEUE"),
+		ActionsUpdateQueue: goenv.MustString("UPDATE_ACTIONS_QUEUE"),
+		Concurrency:        goenv.IntOrDefault("CONSUMER_CONCURRENCY", 4),
+		ExchangeType:       goenv.MustString("ACTIONS_EXCHANGE_TYPE"),
+		MaxRequeue:         goenv.IntOrDefault("ACTIONS_MAX_REQUEUE", 2),
+		IsPeristent:        true,
+	})
+
+	if err := af.Scaffold(); err != nil {
+		return err
+	}
+
+	af.Start()
+	defer func() {
+		if err := af.Stop(); err != nil {
+			lg.Error(err)
+		}
+	}()
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	webhooks := service.NewWebhookService(database, lg, clock.New(), &http.Client{Timeout: timeout})
+
+	// the events queue is durable, so that the events published while the dispatcher is down are not lost
+	go af.ConsumeActionEvents(goenv.StringOrDefault("DISPATCHER_NAME", defaultDispatcherName), func(e *model.ActionEvent) error {
+		n, err := webhooks.Dispatch(ctx, e)
+		if n > 0 {
+			lg.Debugf("%d webhook deliveries created for %s event of action %d", n, e.Type, e.ActionID)
+		}
+
+		return err
+	})
+
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n, err := webhooks.DeliverDue(ctx, batch)
+			if err != nil {
+				lg.Error(err)
+			} else if n > 0 {
+				lg.Debugf("%d webhook deliveries made", n)
+			}
+		case <-terminate:
+			lg.Debugf("dispatcher is stopping")
+			return nil
+		}
+	}
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"context"
 	"github.com/denismitr/auditbase/internal/cache"
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/storage"
 	"github.com/denismitr/auditbase/internal/receiver"
+	"github.com/denismitr/auditbase/internal/service"
 	"github.com/denismitr/auditbase/internal/utils"
 	"github.com/denismitr/auditbase/internal/utils/clock"
 	"github.com/denismitr/goenv"
@@ -17,6 +20,7 @@ import (
 
 	"github.com/denismitr/auditbase/internal/flow"
 	"github.com/denismitr/auditbase/internal/flow/queue"
+	"github.com/denismitr/auditbase/internal/health"
 	"github.com/denismitr/auditbase/internal/rest"
 	"github.com/denismitr/auditbase/internal/utils/env"
 	"github.com/denismitr/auditbase/internal/utils/logger"
@@ -54,13 +58,16 @@ func create(lg logger.Logger) (*rest.API, error) {
 	startCtx, cancel := context.WithTimeout(context.Background(), 60 * time.Second)
 	defer cancel()
 
-	mq := queue.Rabbit(goenv.MustString("RABBITMQ_DSN"), lg, 3)
+	mq, err := queue.NewShared(goenv.StringOrDefault("QUEUE_DRIVER", queue.RabbitMQDriver), goenv.String("RABBITMQ_DSN"), lg, 3)
+	if err != nil {
+		return nil, err
+	}
 
 	if err := mq.Connect(startCtx); err != nil {
 		return nil, err
 	}
 
-	af := flow.New(mq, lg, flow.Config{
+	af := flow.New(mq, lg, clock.New(), flow.Config{
 		ExchangeName: goenv.MustString("ACTIONS_EXCHANGE"),
 		ActionsCreateQueue: goenv.MustString("NEW_ACTIONS_QUEUE"),
 		ActionsUpdateQueue: goenv.MustString("UPDATE_ACTIONS_QUEUE"),
@@ -74,16 +81,62 @@ func create(lg logger.Logger) (*rest.API, error) {
 		return nil, err
 	}
 
+	// keeps the connection to the message queue alive and its status up to date
+	af.Start()
+
 	c := createRedisCache()
 
+	database, err := storage.ConnectAndMigrate(startCtx, lg, goenv.MustString("AUDITBASE_DB_DSN"), 20, 5)
+	if err != nil {
+		return nil, err
+	}
+
+	hc := health.NewChecker(health.DefaultTimeout).
+		Add("queue", health.Flow(af)).
+		Add("cache", health.Cache(c)).
+		Add("database", health.Database(database))
+
 	restCfg := rest.Config{
-		Port:      ":" + goenv.MustString("RECEIVER_API_PORT"),
-		BodyLimit: "250K",
+		Port:           ":" + goenv.MustString("RECEIVER_API_PORT"),
+		BodyLimit:      "250K",
+		BatchBodyLimit: goenv.StringOrDefault("RECEIVER_BATCH_BODY_LIMIT", "10M"),
 	}
 
 	rc := receiver.New(lg, clock.New(), af, utils.NewUUID4Generator(), c)
 	e := echo.New()
-	return rest.NewReceiverAPI(e, restCfg, lg, rc), nil
+	return rest.NewReceiverAPI(
+		e, restCfg, lg, rc, createAuthenticator(lg, database), createSignatureVerifier(lg, database), hc,
+	), nil
+}
+
+// createSignatureVerifier - signing secrets are looked up in the database and kept in memory
+// for a short while, nil disables request signing altogether
+func createSignatureVerifier(lg logger.Logger, database db.Database) *receiver.SignatureVerifier {
+	if goenv.IsTruthy("RECEIVER_SIGNING_DISABLED") {
+		lg.Debugf("Receiver request signing is disabled")
+		return nil
+	}
+
+	tolerance := time.Duration(goenv.IntOrDefault(
+		"RECEIVER_SIGNATURE_TOLERANCE_SECONDS",
+		int(receiver.DefaultSignatureTolerance.Seconds()),
+	)) * time.Second
+	ttl := time.Duration(goenv.IntOrDefault("RECEIVER_SIGNING_SECRET_CACHE_SECONDS", 30)) * time.Second
+
+	return receiver.NewSignatureVerifier(service.NewSigningService(database, lg, clock.New()), clock.New(), tolerance, ttl)
+}
+
+// createAuthenticator - api keys are looked up in the database and kept in memory
+// for a short while, nil disables authentication altogether
+func createAuthenticator(lg logger.Logger, database db.Database) service.APIKeyAuthenticator {
+	if goenv.IsTruthy("RECEIVER_AUTH_DISABLED") {
+		lg.Debugf("Receiver API key authentication is disabled")
+		return nil
+	}
+
+	ttl := time.Duration(goenv.IntOrDefault("RECEIVER_API_KEY_CACHE_SECONDS", 30)) * time.Second
+
+	return service.NewCachingAuthenticator(service.NewAPIKeyService(database, lg, clock.New()), clock.New(), ttl)
 }
 
 func createRedisCache() *cache.RedisCache {
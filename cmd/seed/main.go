@@ -2,7 +2,6 @@ package main
 
 import (
 	"flag"
-	"github.com/denismitr/auditbase/internal/model"
 	"github.com/denismitr/auditbase/internal/utils/seeder"
 	"log"
 	"os"
@@ -22,10 +21,10 @@ func run() error {
 	lg := log.New(os.Stderr, "Actions Seeder ", log.LstdFlags)
 
 	errCh := make(chan error)
-	create := seeder.GenerateNewActions(1550, model.CreateAction)
-	various := seeder.GenerateNewActions(2000, model.AnyAction)
-	del := seeder.GenerateNewActions(9000, model.DeleteAction)
-	update := seeder.GenerateNewActions(3000, model.UpdateAction)
+	create := seeder.GenerateNewActions(1550, seeder.CreateAction)
+	various := seeder.GenerateNewActions(2000, seeder.AnyAction)
+	del := seeder.GenerateNewActions(9000, seeder.DeleteAction)
+	update := seeder.GenerateNewActions(3000, seeder.UpdateAction)
 
 	sender := seeder.NewSender(endpoint, lg)
 
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/auth"
+	"github.com/denismitr/auditbase/internal/utils/clock"
+	"github.com/denismitr/auditbase/internal/utils/env"
+	"github.com/denismitr/goenv"
+)
+
+// token issues a back-office access token signed with BACK_OFFICE_TOKEN_KEY
+// and prints it, no running service is required
+func main() {
+	env.LoadFromDotEnv()
+
+	token, err := run()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Println(token)
+}
+
+func run() (string, error) {
+	var subject, role, services string
+	var ttl time.Duration
+	flag.StringVar(&subject, "sub", "", "user the token is issued to")
+	flag.StringVar(&role, "role", string(auth.RoleViewer), "viewer, auditor or admin")
+	flag.StringVar(&services, "services", "", "comma separated microservices the user sees actions of, empty means all")
+	flag.DurationVar(&ttl, "ttl", 24*time.Hour, "how long the token is valid")
+	flag.Parse()
+
+	tokens, err := auth.NewTokens([]byte(goenv.MustString("BACK_OFFICE_TOKEN_KEY")), clock.New())
+	if err != nil {
+		return "", err
+	}
+
+	var scope []string
+	for _, s := range strings.Split(services, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			scope = append(scope, s)
+		}
+	}
+
+	return tokens.Issue(subject, auth.Role(role), scope, ttl)
+}
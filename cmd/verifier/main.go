@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db/storage"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/service"
+	"github.com/denismitr/auditbase/internal/utils/env"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/denismitr/goenv"
+)
+
+// verifier walks the action hash chain and prints the verification report,
+// exits with code 1 when the chain is broken
+func main() {
+	env.LoadFromDotEnv()
+
+	lg := logger.NewStdoutLogger(goenv.StringOrDefault("APP_ENV", "prod"), "CHAIN_VERIFIER")
+
+	valid, err := run(lg)
+	if err != nil {
+		lg.Error(err)
+		os.Exit(2)
+	}
+
+	if !valid {
+		os.Exit(1)
+	}
+}
+
+func run(lg logger.Logger) (bool, error) {
+	var from, to int
+	flag.IntVar(&from, "from", 1, "ID of the first action to verify")
+	flag.IntVar(&to, "to", 0, "ID of the last action to verify, 0 means the last stored action")
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	database, err := storage.ConnectAndMigrate(ctx, lg, goenv.MustString("AUDITBASE_DB_DSN"), 2, 1)
+	if err != nil {
+		return false, err
+	}
+
+	integrity := service.NewIntegrityService(database, lg)
+
+	report := model.NewChainVerification(model.ID(from), "")
+	fromID := model.ID(from)
+
+	for {
+		v, err := integrity.VerifyChain(context.Background(), fromID, model.ID(to), model.MaxChainVerifyLimit)
+		if err != nil {
+			return false, err
+		}
+
+		report.ToID = v.ToID
+		report.Checked += v.Checked
+		report.Unchained += v.Unchained
+		report.Pruned += v.Pruned
+		report.Valid = v.Valid
+		report.BrokenLink = v.BrokenLink
+
+		if !v.Valid || !v.NextID.Valid() {
+			break
+		}
+
+		lg.Debugf("verified actions up to ID %d", v.ToID)
+		fromID = v.NextID
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return false, err
+	}
+
+	return report.Valid, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db/storage"
+	"github.com/denismitr/auditbase/internal/flow"
+	"github.com/denismitr/auditbase/internal/flow/queue"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/service"
+	"github.com/denismitr/auditbase/internal/utils/clock"
+	"github.com/denismitr/auditbase/internal/utils/env"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/denismitr/goenv"
+)
+
+// watchdog periodically moves the async actions that have not reported their completion
+// within the timeout rule matching them to TimedOut, and announces every one of them
+// with a timedOut action event, which the back-office tail and the webhook dispatcher receive
+func main() {
+	env.LoadFromDotEnv()
+
+	lg := logger.NewStdoutLogger(goenv.StringOrDefault("APP_ENV", "prod"), "WATCHDOG")
+
+	if err := run(lg); err != nil {
+		lg.Error(err)
+		os.Exit(1)
+	}
+}
+
+func run(lg logger.Logger) error {
+	var batch int
+	var interval time.Duration
+	flag.IntVar(&batch, "batch", service.DefaultTimeoutBatchSize, "number of actions in progress looked at in a single transaction")
+	flag.DurationVar(&interval, "interval", time.Minute, "how often actions in progress are swept")
+	flag.Parse()
+
+	connectCtx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	database, err := storage.ConnectAndMigrate(connectCtx, lg, goenv.MustString("AUDITBASE_DB_DSN"), 4, 1)
+	if err != nil {
+		return err
+	}
+
+	mq, err := queue.NewShared(goenv.StringOrDefault("QUEUE_DRIVER", queue.RabbitMQDriver), goenv.String("RABBITMQ_DSN"), lg, 3)
+	if err != nil {
+		return err
+	}
+
+	if err := mq.Connect(connectCtx); err != nil {
+		return err
+	}
+
+	af := flow.New(mq, lg, clock.New(), flow.Config{
+		ExchangeName:       goenv.MustString("ACTIONS_EXCHANGE"),
+		ActionsCreateQueue: goenv.MustString("NEW_ACTIONS_QUEUE"),
+		ActionsUpdateQueue: goenv.MustString("UPDATE_ACTIONS_QUEUE"),
+		Concurrency:        goenv.IntOrDefault("CONSUMER_CONCURRENCY", 4),
+		ExchangeType:       goenv.MustString("ACTIONS_EXCHANGE_TYPE"),
+		MaxRequeue:         goenv.IntOrDefault("ACTIONS_MAX_REQUEUE", 2),
+		IsPeristent:        true,
+	})
+
+	if err := af.Scaffold(); err != nil {
+		return err
+	}
+
+	af.Start()
+	defer func() {
+		if err := af.Stop(); err != nil {
+			lg.Error(err)
+		}
+	}()
+
+	// an interrupted sweep stops at its next batch
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-terminate
+		stop()
+	}()
+
+	timeouts := service.NewTimeoutService(database, lg, clock.New())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// a failed or interrupted sweep still returns the actions it has timed out
+		sweep, err := timeouts.TimeOutStuck(ctx, batch)
+		if err != nil {
+			lg.Error(err)
+		}
+
+		if sweep != nil {
+			announce(lg, af, sweep)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			lg.Debugf("watchdog is stopping")
+			return nil
+		}
+	}
+}
+
+// announce - the actions are timed out already, so an event that could not be sent is only logged
+func announce(lg logger.Logger, af flow.ActionFlow, sweep *model.TimeoutSweep) {
+	for _, t := range sweep.Timeouts {
+		if err := af.SendActionEvent(&model.ActionEvent{Type: model.ActionTimedOut, ActionID: t.ActionID}); err != nil {
+			lg.Error(err)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package archive
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/sqlite"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDatabase(t *testing.T, lg logger.Logger) *sqlite.Database {
+	t.Helper()
+
+	conn, err := sqlite.ConnectAndMigrate(context.Background(), lg, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return sqlite.NewDatabase(conn, lg)
+}
+
+func uid(c string) model.UID {
+	return model.UID(strings.Repeat(c, 32))
+}
+
+func seed(t *testing.T, database db.Database, day time.Time) {
+	t.Helper()
+
+	_, err := database.ReadWrite(context.Background(), func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		entities := make(map[string]model.ID)
+		for _, e := range []struct{ service, entityType, externalID string }{
+			{"users", "user", "1"},
+			{"articles", "article", "10"},
+			{"billing", "invoice", "20"},
+		} {
+			ms, err := tx.Microservices().FirstOrCreateByName(ctx, e.service)
+			if err != nil {
+				return nil, err
+			}
+
+			et, err := tx.EntityTypes().FirstOrCreateByNameAndServiceID(ctx, e.entityType, ms.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			entity, err := tx.Entities().FirstOrCreateByExternalIDAndEntityTypeID(ctx, e.externalID, et.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			entities[e.entityType] = entity.ID
+		}
+
+		for _, a := range []struct {
+			uid    model.UID
+			name   string
+			actor  string
+			target string
+			days   int
+			delta  model.Delta
+		}{
+			{uid("a"), "articleCreated", "user", "article", 0, model.Delta{
+				{PropertyName: "title", CurrentPropertyType: model.StringProperty, To: "Hello"},
+			}},
+			{uid("b"), "invoicePaid", "", "invoice", 0, nil},
+			{uid("c"), "articleUpdated", "user", "article", 1, nil},
+			{uid("d"), "articleUpdated", "user", "article", 5, nil},
+		} {
+			at := model.JSONTime{Time: day.AddDate(0, 0, a.days).Add(time.Hour)}
+			created, err := tx.Actions().Create(ctx, &model.Action{
+				UID:            a.uid,
+				Name:           a.name,
+				ActorEntityID:  entities[a.actor],
+				TargetEntityID: entities[a.target],
+				EmittedAt:      at,
+				RegisteredAt:   at,
+				Details:        map[string]interface{}{"n": a.days},
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			if err := tx.Deltas().Create(ctx, created.ID, created.TargetEntityID, a.delta); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	lg := logger.NewStdoutLogger(logger.Prod, "archive_test")
+	ctx := context.Background()
+	day := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	source := newTestDatabase(t, lg)
+	seed(t, source, day)
+
+	dir := filepath.Join(t.TempDir(), "march")
+	w, err := NewWriter(dir, day.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written, err := NewExporter(source, lg, 2).Export(ctx, w, day, day.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("manifest", func(t *testing.T) {
+		assert.Equal(t, 3, written)
+		assert.Equal(t, 3, manifest.Actions)
+		assert.Equal(t, day, manifest.From)
+		assert.Equal(t, day.AddDate(0, 0, 2), manifest.To)
+
+		var paths []string
+		for _, f := range manifest.Files {
+			paths = append(paths, f.Path)
+			assert.NotEmpty(t, f.SHA256)
+		}
+
+		assert.Equal(t, []string{
+			"2020-03-01/billing.ndjson.gz",
+			"2020-03-01/users.ndjson.gz",
+			"2020-03-02/users.ndjson.gz",
+		}, paths)
+
+		_, err := NewWriter(dir, day)
+		assert.Equal(t, ErrArchiveExists, errors.Cause(err))
+	})
+
+	t.Run("restored into another database", func(t *testing.T) {
+		target := newTestDatabase(t, lg)
+
+		result, err := NewImporter(target, lg, 2).Import(ctx, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 3, result.Files)
+		assert.Equal(t, 3, result.Restored)
+		assert.Equal(t, 0, result.Skipped)
+
+		_, err = target.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+			a, err := tx.Actions().FirstByUID(ctx, uid("a"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, "articleCreated", a.Name)
+			assert.Equal(t, day.Add(time.Hour), a.RegisteredAt.Time.UTC())
+
+			actor, err := tx.Entities().FirstByIDWithEntityType(ctx, a.ActorEntityID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, "1", actor.ExternalID)
+			assert.Equal(t, "user", actor.EntityType.Name)
+
+			delta, err := tx.Deltas().SelectByActionID(ctx, a.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if assert.Len(t, delta, 1) {
+				assert.Equal(t, "title", delta[0].PropertyName)
+				assert.Equal(t, "Hello", delta[0].To)
+			}
+
+			_, err = tx.Actions().FirstByUID(ctx, uid("d"))
+			assert.Equal(t, db.ErrNotFound, errors.Cause(err))
+
+			return nil, nil
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		again, err := NewImporter(target, lg, 2).Import(ctx, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 0, again.Restored)
+		assert.Equal(t, 3, again.Skipped)
+	})
+
+	t.Run("tampered archive is refused", func(t *testing.T) {
+		path := filepath.Join(dir, filepath.FromSlash(manifest.Files[0].Path))
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b[len(b)-1] ^= 0xff
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = NewImporter(newTestDatabase(t, lg), lg, 2).Import(ctx, dir)
+		assert.Equal(t, model.ErrArchiveChecksumMismatch, errors.Cause(err))
+	})
+}
+
+func TestWriter_FinishesDays(t *testing.T) {
+	day := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	dir := filepath.Join(t.TempDir(), "march")
+
+	w, err := NewWriter(dir, day.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(ID model.ID, registeredAt time.Time, service string) {
+		t.Helper()
+
+		err := w.Write(&model.ArchivedAction{
+			ID:           ID,
+			UID:          uid(string(rune('a' + ID))),
+			Name:         "articleCreated",
+			RegisteredAt: registeredAt,
+			Actor:        &model.ArchivedEntity{Service: service, EntityType: "user", ExternalID: "1"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(1, day.Add(time.Hour), "users")
+	write(2, day.Add(2*time.Hour), "billing")
+	assert.Len(t, w.files, 2)
+
+	write(3, day.Add(25*time.Hour), "users")
+	assert.Len(t, w.files, 1, "files of the first day are finished")
+
+	// registered before the day changed, but written after it
+	write(4, day.Add(23*time.Hour), "users")
+	write(5, day.Add(26*time.Hour), "users")
+	assert.Len(t, w.files, 2)
+
+	manifest, err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Empty(t, w.files)
+	assert.Equal(t, 5, manifest.Actions)
+
+	var paths []string
+	for _, f := range manifest.Files {
+		paths = append(paths, f.Path)
+	}
+
+	assert.Equal(t, []string{
+		"2020-03-01/billing.ndjson.gz",
+		"2020-03-01/users.ndjson.gz",
+		"2020-03-01/users.2.ndjson.gz",
+		"2020-03-02/users.ndjson.gz",
+	}, paths)
+
+	verified, err := Verify(dir)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, verified.Files[3].Actions)
+		assert.Equal(t, model.ID(5), verified.Files[3].LastID)
+	}
+}
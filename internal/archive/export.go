@@ -0,0 +1,195 @@
+package archive
+
+import (
+	"context"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+)
+
+// DefaultBatchSize - number of actions read in a single transaction
+const DefaultBatchSize = 500
+
+// Exporter - reads actions along with their actors and targets and writes them into archives
+type Exporter struct {
+	db    db.Database
+	lg    logger.Logger
+	batch int
+}
+
+func NewExporter(db db.Database, lg logger.Logger, batch int) *Exporter {
+	if batch <= 0 {
+		batch = DefaultBatchSize
+	}
+
+	return &Exporter{
+		db:    db,
+		lg:    lg,
+		batch: batch,
+	}
+}
+
+// Export - streams actions registered from the given time and before the other one into the writer,
+// every batch is read in a transaction of its own, returns the number of actions written
+func (e *Exporter) Export(ctx context.Context, w *Writer, from, to time.Time) (int, error) {
+	w.Range(from, to)
+
+	entities := make(map[model.ID]*model.ArchivedEntity)
+	written := 0
+
+	for afterID := model.ID(0); ; {
+		result, err := e.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+			actions, err := tx.Actions().SelectRegistered(ctx, from, to, afterID, e.batch)
+			if err != nil {
+				return nil, err
+			}
+
+			return archivedActions(ctx, tx, actions, entities)
+		})
+
+		if err != nil {
+			return written, err
+		}
+
+		archived, ok := result.([]model.ArchivedAction)
+		if !ok {
+			panic("how could result not be of type []model.ArchivedAction")
+		}
+
+		for i := range archived {
+			if err := w.Write(&archived[i]); err != nil {
+				return written, err
+			}
+		}
+
+		written += len(archived)
+
+		if len(archived) < e.batch {
+			return written, nil
+		}
+
+		afterID = archived[len(archived)-1].ID
+		e.lg.Debugf("archived actions up to ID %d", afterID)
+	}
+}
+
+// ExportIDs - writes the actions with the given IDs into the writer, e.g. right before they are removed
+func (e *Exporter) ExportIDs(ctx context.Context, w *Writer, IDs []model.ID) error {
+	result, err := e.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		actions := make([]model.Action, 0, len(IDs))
+		for _, ID := range IDs {
+			a, err := tx.Actions().FirstByID(ctx, ID)
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not load action %d to archive", ID)
+			}
+
+			actions = append(actions, *a)
+		}
+
+		return archivedActions(ctx, tx, actions, make(map[model.ID]*model.ArchivedEntity))
+	})
+
+	if err != nil {
+		return err
+	}
+
+	archived, ok := result.([]model.ArchivedAction)
+	if !ok {
+		panic("how could result not be of type []model.ArchivedAction")
+	}
+
+	for i := range archived {
+		if err := w.Write(&archived[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// archivedActions - actions with their deltas, actors and targets, entities already resolved are reused
+func archivedActions(
+	ctx context.Context,
+	tx db.Tx,
+	actions []model.Action,
+	entities map[model.ID]*model.ArchivedEntity,
+) ([]model.ArchivedAction, error) {
+	archived := make([]model.ArchivedAction, len(actions))
+
+	for i := range actions {
+		a := &actions[i]
+
+		delta, err := tx.Deltas().SelectByActionID(ctx, a.ID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not load delta of action %d", a.ID)
+		}
+
+		actor, err := archivedEntity(ctx, tx, a.ActorEntityID, entities)
+		if err != nil {
+			return nil, err
+		}
+
+		target, err := archivedEntity(ctx, tx, a.TargetEntityID, entities)
+		if err != nil {
+			return nil, err
+		}
+
+		archived[i] = model.ArchivedAction{
+			ID:            a.ID,
+			UID:           a.UID,
+			ParentUID:     a.ParentUID,
+			Hash:          a.Hash,
+			Name:          a.Name,
+			Status:        a.Status,
+			IsAsync:       a.IsAsync,
+			Actor:         actor,
+			Target:        target,
+			EmittedAt:     a.EmittedAt.UTC(),
+			RegisteredAt:  a.RegisteredAt.UTC(),
+			Details:       a.Details,
+			Delta:         delta,
+			PrevChainHash: a.PrevChainHash,
+			ChainHash:     a.ChainHash,
+		}
+	}
+
+	return archived, nil
+}
+
+func archivedEntity(
+	ctx context.Context,
+	tx db.Tx,
+	ID model.ID,
+	entities map[model.ID]*model.ArchivedEntity,
+) (*model.ArchivedEntity, error) {
+	if !ID.Valid() {
+		return nil, nil
+	}
+
+	if e, ok := entities[ID]; ok {
+		return e, nil
+	}
+
+	entity, err := tx.Entities().FirstByIDWithEntityType(ctx, ID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not load entity %d", ID)
+	}
+
+	service, err := tx.Microservices().FirstByID(ctx, entity.EntityType.ServiceID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not load service of entity %d", ID)
+	}
+
+	e := &model.ArchivedEntity{
+		Service:    service.Name,
+		EntityType: entity.EntityType.Name,
+		ExternalID: entity.ExternalID,
+	}
+
+	entities[ID] = e
+
+	return e, nil
+}
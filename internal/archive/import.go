@@ -0,0 +1,261 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+)
+
+// Importer - restores archives into a database, the restored actions get new IDs
+// and are linked into the hash chain of that database, actions it already has are skipped
+type Importer struct {
+	db    db.Database
+	lg    logger.Logger
+	batch int
+}
+
+func NewImporter(db db.Database, lg logger.Logger, batch int) *Importer {
+	if batch <= 0 {
+		batch = DefaultBatchSize
+	}
+
+	return &Importer{
+		db:    db,
+		lg:    lg,
+		batch: batch,
+	}
+}
+
+// ReadManifest - manifest of the archive in the given directory
+func ReadManifest(dir string) (*model.ArchiveManifest, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read manifest of archive %s", dir)
+	}
+
+	var m model.ArchiveManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, errors.Wrapf(err, "could not decode manifest of archive %s", dir)
+	}
+
+	if m.Version != model.ArchiveVersion {
+		return nil, errors.Wrapf(model.ErrArchiveVersionUnsupported, "version %d", m.Version)
+	}
+
+	return &m, nil
+}
+
+// Verify - checks every file of the archive against the size and checksum in its manifest
+func Verify(dir string) (*model.ArchiveManifest, error) {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range m.Files {
+		if err := verifyFile(dir, &m.Files[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Import - verifies the whole archive first, so that nothing is restored from a damaged one,
+// then restores its files one by one
+func (i *Importer) Import(ctx context.Context, dir string) (*model.ArchiveImport, error) {
+	m, err := Verify(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(model.ArchiveImport)
+
+	for n := range m.Files {
+		if err := i.importFile(ctx, dir, &m.Files[n], result); err != nil {
+			return result, err
+		}
+
+		result.Files++
+		i.lg.Debugf("restored archive file %s", m.Files[n].Path)
+	}
+
+	return result, nil
+}
+
+func (i *Importer) importFile(ctx context.Context, dir string, f *model.ArchiveFile, result *model.ArchiveImport) error {
+	file, err := os.Open(filepath.Join(dir, filepath.FromSlash(f.Path)))
+	if err != nil {
+		return errors.Wrapf(err, "could not open archive file %s", f.Path)
+	}
+
+	defer func() { _ = file.Close() }()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return errors.Wrapf(err, "could not decompress archive file %s", f.Path)
+	}
+
+	defer func() { _ = gz.Close() }()
+
+	dec := json.NewDecoder(bufio.NewReader(gz))
+	batch := make([]model.ArchivedAction, 0, i.batch)
+	read := 0
+
+	for {
+		var a model.ArchivedAction
+		if err := dec.Decode(&a); err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrapf(err, "could not decode action %d of archive file %s", read+1, f.Path)
+		}
+
+		read++
+		batch = append(batch, a)
+
+		if len(batch) == i.batch {
+			if err := i.restore(ctx, batch, result); err != nil {
+				return err
+			}
+
+			batch = batch[:0]
+		}
+	}
+
+	if read != f.Actions {
+		return errors.Wrapf(model.ErrArchiveCountMismatch, "%s holds %d actions, %d expected", f.Path, read, f.Actions)
+	}
+
+	return i.restore(ctx, batch, result)
+}
+
+func (i *Importer) restore(ctx context.Context, actions []model.ArchivedAction, result *model.ArchiveImport) error {
+	if len(actions) == 0 {
+		return nil
+	}
+
+	res, err := i.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		entities := make(map[model.ArchivedEntity]model.ID)
+		restored := 0
+
+		for n := range actions {
+			a := &actions[n]
+
+			if _, err := tx.Actions().FirstByUID(ctx, a.UID); err == nil {
+				continue
+			} else if errors.Cause(err) != db.ErrNotFound {
+				return nil, err
+			}
+
+			action := a.ToAction()
+
+			actorID, err := restoreEntity(ctx, tx, a.Actor, entities)
+			if err != nil {
+				return nil, err
+			}
+
+			targetID, err := restoreEntity(ctx, tx, a.Target, entities)
+			if err != nil {
+				return nil, err
+			}
+
+			action.ActorEntityID = actorID
+			action.TargetEntityID = targetID
+
+			created, err := tx.Actions().Create(ctx, action)
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not restore action %s", a.UID)
+			}
+
+			if err := tx.Deltas().Create(ctx, created.ID, created.TargetEntityID, a.Delta); err != nil {
+				return nil, errors.Wrapf(err, "could not restore delta of action %s", a.UID)
+			}
+
+			restored++
+		}
+
+		return restored, nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	restored, ok := res.(int)
+	if !ok {
+		panic("how could result not be of type int")
+	}
+
+	result.Restored += restored
+	result.Skipped += len(actions) - restored
+
+	return nil
+}
+
+// restoreEntity - ID of the entity in the database, it is created along with its type and service when missing
+func restoreEntity(
+	ctx context.Context,
+	tx db.Tx,
+	e *model.ArchivedEntity,
+	entities map[model.ArchivedEntity]model.ID,
+) (model.ID, error) {
+	if e == nil {
+		return 0, nil
+	}
+
+	if ID, ok := entities[*e]; ok {
+		return ID, nil
+	}
+
+	ms, err := tx.Microservices().FirstOrCreateByName(ctx, e.Service)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not restore service %s", e.Service)
+	}
+
+	et, err := tx.EntityTypes().FirstOrCreateByNameAndServiceID(ctx, e.EntityType, ms.ID)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not restore entity type %s", e.EntityType)
+	}
+
+	entity, err := tx.Entities().FirstOrCreateByExternalIDAndEntityTypeID(ctx, e.ExternalID, et.ID)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not restore entity %s", e.ExternalID)
+	}
+
+	entities[*e] = entity.ID
+
+	return entity.ID, nil
+}
+
+func verifyFile(dir string, f *model.ArchiveFile) error {
+	file, err := os.Open(filepath.Join(dir, filepath.FromSlash(f.Path)))
+	if err != nil {
+		return errors.Wrapf(err, "could not open archive file %s", f.Path)
+	}
+
+	defer func() { _ = file.Close() }()
+
+	h := sha256.New()
+	n, err := io.Copy(h, file)
+	if err != nil {
+		return errors.Wrapf(err, "could not read archive file %s", f.Path)
+	}
+
+	if n != f.Bytes || hex.EncodeToString(h.Sum(nil)) != f.SHA256 {
+		return errors.Wrap(model.ErrArchiveChecksumMismatch, f.Path)
+	}
+
+	return nil
+}
@@ -0,0 +1,244 @@
+package archive
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/errtype"
+	"github.com/pkg/errors"
+)
+
+// ManifestFile - name of the manifest in the archive directory
+const ManifestFile = "manifest.json"
+
+const ErrArchiveExists = errtype.StringError("directory already holds an archive")
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// Writer - writes archived actions into files partitioned by day and service
+// under the archive directory, the manifest is written on Close. Actions are expected
+// roughly in the order of registration, the files of a day are finished as soon as
+// an action of a later day is written, so only the files of the latest day stay open
+type Writer struct {
+	dir      string
+	files    map[string]*partition
+	parts    map[string]int
+	day      string
+	manifest *model.ArchiveManifest
+	ranged   bool
+}
+
+type partition struct {
+	file  *os.File
+	gz    *gzip.Writer
+	enc   *json.Encoder
+	hash  hash.Hash
+	bytes *countingWriter
+	entry *model.ArchiveFile
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// NewWriter - creates the archive directory, a directory already holding a manifest is refused
+func NewWriter(dir string, createdAt time.Time) (*Writer, error) {
+	if _, err := os.Stat(filepath.Join(dir, ManifestFile)); err == nil {
+		return nil, errors.Wrap(ErrArchiveExists, dir)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "could not create archive directory %s", dir)
+	}
+
+	return &Writer{
+		dir:   dir,
+		files: make(map[string]*partition),
+		parts: make(map[string]int),
+		manifest: &model.ArchiveManifest{
+			Version:   model.ArchiveVersion,
+			CreatedAt: createdAt.UTC(),
+			Files:     make([]model.ArchiveFile, 0),
+		},
+	}, nil
+}
+
+// Dir - directory of the archive
+func (w *Writer) Dir() string {
+	return w.dir
+}
+
+// Range - time range the archive covers, by default it is the one
+// between the earliest and the latest registered action written
+func (w *Writer) Range(from, to time.Time) {
+	w.manifest.From = from.UTC()
+	w.manifest.To = to.UTC()
+	w.ranged = true
+}
+
+// Write - appends the action to the file of its day and service
+func (w *Writer) Write(a *model.ArchivedAction) error {
+	day := a.Day()
+	if day > w.day {
+		if err := w.finish(day); err != nil {
+			return err
+		}
+
+		w.day = day
+	}
+
+	p, err := w.partition(day, a.Service())
+	if err != nil {
+		return err
+	}
+
+	if err := p.enc.Encode(a); err != nil {
+		return errors.Wrapf(err, "could not write action %d to %s", a.ID, p.entry.Path)
+	}
+
+	if p.entry.Actions == 0 {
+		p.entry.FirstID = a.ID
+	}
+
+	p.entry.Actions++
+	p.entry.LastID = a.ID
+	w.manifest.Actions++
+
+	if !w.ranged {
+		if w.manifest.From.IsZero() || a.RegisteredAt.Before(w.manifest.From) {
+			w.manifest.From = a.RegisteredAt.UTC()
+		}
+
+		if a.RegisteredAt.After(w.manifest.To) {
+			w.manifest.To = a.RegisteredAt.UTC()
+		}
+	}
+
+	return nil
+}
+
+// Close - finishes the files left open and writes the manifest with their checksums
+func (w *Writer) Close() (*model.ArchiveManifest, error) {
+	if err := w.finish(""); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(w.manifest.Files, func(i, j int) bool {
+		fi, fj := w.manifest.Files[i], w.manifest.Files[j]
+		if fi.Day != fj.Day {
+			return fi.Day < fj.Day
+		}
+
+		if fi.Service != fj.Service {
+			return fi.Service < fj.Service
+		}
+
+		return fi.FirstID < fj.FirstID
+	})
+
+	b, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode manifest")
+	}
+
+	// the manifest appears at once, so that an archive is either complete or has none
+	tmp := filepath.Join(w.dir, ManifestFile+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return nil, errors.Wrap(err, "could not write manifest")
+	}
+
+	if err := os.Rename(tmp, filepath.Join(w.dir, ManifestFile)); err != nil {
+		return nil, errors.Wrap(err, "could not write manifest")
+	}
+
+	return w.manifest, nil
+}
+
+// finish - flushes and closes the files of the days before the given one, of every day when it is empty,
+// and adds them to the manifest
+func (w *Writer) finish(day string) error {
+	keys := make([]string, 0, len(w.files))
+	for k, p := range w.files {
+		if day == "" || p.entry.Day < day {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := w.files[k]
+		if err := p.gz.Close(); err != nil {
+			_ = p.file.Close()
+			return errors.Wrapf(err, "could not flush %s", p.entry.Path)
+		}
+
+		if err := p.file.Close(); err != nil {
+			return errors.Wrapf(err, "could not close %s", p.entry.Path)
+		}
+
+		p.entry.Bytes = p.bytes.n
+		p.entry.SHA256 = hex.EncodeToString(p.hash.Sum(nil))
+		w.manifest.Files = append(w.manifest.Files, *p.entry)
+
+		delete(w.files, k)
+	}
+
+	return nil
+}
+
+// partition - the open file of the day and service, an action of a day that is finished already
+// goes to a file of its own following the ones written before
+func (w *Writer) partition(day, service string) (*partition, error) {
+	key := day + "/" + service
+	if p, ok := w.files[key]; ok {
+		return p, nil
+	}
+
+	w.parts[key]++
+	name := unsafeFileChars.ReplaceAllString(service, "_")
+	if w.parts[key] > 1 {
+		name = fmt.Sprintf("%s.%d", name, w.parts[key])
+	}
+
+	path := filepath.Join(day, name+".ndjson.gz")
+	if err := os.MkdirAll(filepath.Join(w.dir, day), 0755); err != nil {
+		return nil, errors.Wrapf(err, "could not create directory of day %s", day)
+	}
+
+	f, err := os.OpenFile(filepath.Join(w.dir, path), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create archive file %s", path)
+	}
+
+	p := &partition{
+		file:  f,
+		hash:  sha256.New(),
+		bytes: new(countingWriter),
+		entry: &model.ArchiveFile{Path: filepath.ToSlash(path), Day: day, Service: service},
+	}
+
+	p.gz = gzip.NewWriter(io.MultiWriter(f, p.hash, p.bytes))
+	p.enc = json.NewEncoder(p.gz)
+
+	w.files[key] = p
+
+	return p, nil
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/utils/clock"
+	"github.com/denismitr/auditbase/internal/utils/errtype"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+const ErrInvalidToken = errtype.StringError("invalid access token")
+const ErrInvalidRole = errtype.StringError("role must be one of viewer, auditor or admin")
+const ErrKeyTooShort = errtype.StringError("token signing key must be at least 32 bytes long")
+const ErrKeyPublished = errtype.StringError("token signing key is a published sample, generate a random one")
+
+// MinKeyLength - shortest HS256 signing key accepted
+const MinKeyLength = 32
+
+// publishedKeys - sample keys that have been shipped with the project,
+// anyone could sign tokens with them
+var publishedKeys = []string{
+	"change-me-to-a-random-string-of-32-bytes-or-more",
+}
+
+// Issuer - iss claim of the tokens signed by auditbase
+const Issuer = "auditbase"
+
+// Role - what a back-office user is allowed to do, every role includes the ones below it
+type Role string
+
+const (
+	// RoleViewer - reads microservices, entities and actions
+	RoleViewer Role = "viewer"
+	// RoleAuditor - also verifies the hash chain and inspects dead letters
+	RoleAuditor Role = "auditor"
+	// RoleAdmin - also changes microservices, credentials and dead letters
+	RoleAdmin Role = "admin"
+)
+
+var ranks = map[Role]int{
+	RoleViewer:  1,
+	RoleAuditor: 2,
+	RoleAdmin:   3,
+}
+
+func (r Role) Valid() bool {
+	_, ok := ranks[r]
+	return ok
+}
+
+// Includes - whether the role grants everything the other one does
+func (r Role) Includes(other Role) bool {
+	return r.Valid() && ranks[r] >= ranks[other]
+}
+
+// Claims - claims of a back-office access token, services scope the actions
+// the user sees to the ones whose actor or target service is among them,
+// an empty list means all of them
+type Claims struct {
+	jwt.StandardClaims
+	Role     Role     `json:"role"`
+	Services []string `json:"services,omitempty"`
+}
+
+// Scoped - whether the user only sees actions of some services
+func (c *Claims) Scoped() bool {
+	return len(c.Services) > 0
+}
+
+// Tokens - issues and parses HS256 signed tokens with a local key,
+// so that neither needs an identity provider to be reachable
+type Tokens struct {
+	key   []byte
+	clock clock.Clock
+}
+
+func NewTokens(key []byte, clock clock.Clock) (*Tokens, error) {
+	if len(key) < MinKeyLength {
+		return nil, ErrKeyTooShort
+	}
+
+	for _, published := range publishedKeys {
+		if string(key) == published {
+			return nil, ErrKeyPublished
+		}
+	}
+
+	return &Tokens{key: key, clock: clock}, nil
+}
+
+// Issue - signed token of the subject valid for ttl
+func (t *Tokens) Issue(subject string, role Role, services []string, ttl time.Duration) (string, error) {
+	if !role.Valid() {
+		return "", ErrInvalidRole
+	}
+
+	if strings.TrimSpace(subject) == "" {
+		return "", errors.New("token subject must not be empty")
+	}
+
+	now := t.clock.CurrentTime()
+	claims := &Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   subject,
+			Issuer:    Issuer,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+		Role:     role,
+		Services: services,
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(t.key)
+	if err != nil {
+		return "", errors.Wrap(err, "could not sign token")
+	}
+
+	return token, nil
+}
+
+// Parse - claims of a token signed with the key, that has not expired yet
+func (t *Tokens) Parse(token string) (*Claims, error) {
+	parser := &jwt.Parser{
+		ValidMethods:         []string{jwt.SigningMethodHS256.Alg()},
+		SkipClaimsValidation: true,
+	}
+
+	claims := new(Claims)
+	if _, err := parser.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return t.key, nil
+	}); err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+
+	now := t.clock.CurrentTime().Unix()
+
+	if !claims.VerifyExpiresAt(now, true) {
+		return nil, errors.Wrap(ErrInvalidToken, "token has expired")
+	}
+
+	if !claims.VerifyIssuedAt(now, false) || !claims.VerifyIssuer(Issuer, true) {
+		return nil, errors.Wrap(ErrInvalidToken, "token was not issued by auditbase")
+	}
+
+	if !claims.Role.Valid() {
+		return nil, errors.Wrap(ErrInvalidToken, ErrInvalidRole.Error())
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) CurrentTimestamp() int64 {
+	return c.now.Unix()
+}
+
+func (c *fakeClock) CurrentTime() time.Time {
+	return c.now
+}
+
+var testKey = []byte(strings.Repeat("k", MinKeyLength))
+
+func TestRole_Includes(t *testing.T) {
+	assert.True(t, RoleAdmin.Includes(RoleAuditor))
+	assert.True(t, RoleAuditor.Includes(RoleViewer))
+	assert.True(t, RoleViewer.Includes(RoleViewer))
+	assert.False(t, RoleViewer.Includes(RoleAuditor))
+	assert.False(t, RoleAuditor.Includes(RoleAdmin))
+	assert.False(t, Role("root").Includes(RoleViewer))
+}
+
+func TestTokens(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2021, 4, 1, 10, 0, 0, 0, time.UTC)}
+
+	_, err := NewTokens([]byte("short"), clock)
+	assert.Equal(t, ErrKeyTooShort, err)
+
+	_, err = NewTokens([]byte("change-me-to-a-random-string-of-32-bytes-or-more"), clock)
+	assert.Equal(t, ErrKeyPublished, err)
+
+	tokens, err := NewTokens(testKey, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := tokens.Issue("alice", RoleAuditor, []string{"billing"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("valid token", func(t *testing.T) {
+		claims, err := tokens.Parse(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "alice", claims.Subject)
+		assert.Equal(t, RoleAuditor, claims.Role)
+		assert.Equal(t, []string{"billing"}, claims.Services)
+		assert.True(t, claims.Scoped())
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		later := &Tokens{key: testKey, clock: &fakeClock{now: clock.now.Add(2 * time.Hour)}}
+
+		_, err := later.Parse(token)
+		assert.Equal(t, ErrInvalidToken, errors.Cause(err))
+	})
+
+	t.Run("token signed with another key", func(t *testing.T) {
+		other, _ := NewTokens([]byte(strings.Repeat("o", MinKeyLength)), clock)
+
+		_, err := other.Parse(token)
+		assert.Equal(t, ErrInvalidToken, errors.Cause(err))
+	})
+
+	t.Run("unsigned token", func(t *testing.T) {
+		unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, &Claims{
+			StandardClaims: jwt.StandardClaims{Subject: "mallory", Issuer: Issuer, ExpiresAt: clock.now.Add(time.Hour).Unix()},
+			Role:           RoleAdmin,
+		}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = tokens.Parse(unsigned)
+		assert.Equal(t, ErrInvalidToken, errors.Cause(err))
+	})
+
+	t.Run("unknown role", func(t *testing.T) {
+		_, err := tokens.Issue("bob", Role("root"), nil, time.Hour)
+		assert.Equal(t, ErrInvalidRole, err)
+	})
+}
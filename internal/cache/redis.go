@@ -9,6 +9,7 @@ import (
 type Cacher interface {
 	Has(key string) (bool, error)
 	CreateKey(key string, ttl time.Duration) error
+	Ping() error
 }
 
 type RedisCache struct {
@@ -39,4 +40,10 @@ func (c *RedisCache) CreateKey(key string, ttl time.Duration) error {
 	return nil
 }
 
+func (c *RedisCache) Ping() error {
+	if err := c.store.Ping().Err(); err != nil {
+		return errors.Wrap(err, "could not ping redis")
+	}
 
+	return nil
+}
\ No newline at end of file
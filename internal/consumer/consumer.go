@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"github.com/denismitr/auditbase/internal/flow"
+	"github.com/denismitr/auditbase/internal/metrics"
 	"github.com/denismitr/auditbase/internal/model"
 	"github.com/denismitr/auditbase/internal/service"
 	"github.com/denismitr/auditbase/internal/utils/logger"
@@ -12,13 +13,13 @@ import (
 	"time"
 )
 
+// Stats - state of the consumer, processed and failed actions
+// are counted by metrics.ProcessedActions
 type Stats struct {
-	mu                    sync.RWMutex
-	processedActions      int
-	failedActionCreations int
-	statusOK              bool
-	startedAt             time.Time
-	finishedAt           time.Time
+	mu         sync.RWMutex
+	statusOK   bool
+	startedAt  time.Time
+	finishedAt time.Time
 }
 
 // Consumer - consumers from the action flow and
@@ -48,6 +49,7 @@ func New(
 
 var ErrConnectionLoss = errors.New("connection to message broker was lost")
 var ErrInterrupted = errors.New("consumer was interrupted")
+var ErrNotRunning = errors.New("consumer is not running")
 
 // Start consumer
 func (c *Consumer) Start(stopCh chan os.Signal) <-chan error {
@@ -88,17 +90,33 @@ func (c *Consumer) Start(stopCh chan os.Signal) <-chan error {
 	return doneCh
 }
 
+// Running - returns an error when the consumer has not been started yet
+// or has already stopped, fits health checks
+func (c *Consumer) Running(_ context.Context) error {
+	c.stats.mu.RLock()
+	defer c.stats.mu.RUnlock()
+
+	if !c.stats.statusOK {
+		return ErrNotRunning
+	}
+
+	return nil
+}
 
 func (c *Consumer) processNewActions() {
 	h := func(na *model.NewAction) error {
 		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 		defer cancel()
 
-		if _, err := c.actionService.Create(ctx, na); err != nil {
-			return err
+		start := time.Now()
+		action, err := c.actionService.Create(ctx, na)
+		observe(createHandler, start, err)
+
+		if err == nil {
+			c.announce(model.ActionCreated, action)
 		}
 
-		return nil
+		return err
 	}
 
 	c.actionFlow.ReceiveNewActions(c.consumerName, h)
@@ -109,12 +127,40 @@ func (c *Consumer) processUpdateActions() {
 		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 		defer cancel()
 
-		if _, err := c.actionService.Update(ctx, ua); err != nil {
-			return err
+		start := time.Now()
+		action, err := c.actionService.Update(ctx, ua)
+		observe(updateHandler, start, err)
+
+		if err == nil {
+			c.announce(model.ActionUpdated, action)
 		}
 
-		return nil
+		return err
 	}
 
 	c.actionFlow.ReceiveUpdateActions(c.consumerName, h)
 }
+
+// announce - lets the back-office tail the persisted action, the action is stored already,
+// so an event that could not be sent is only logged
+func (c *Consumer) announce(t model.ActionEventType, action *model.Action) {
+	if err := c.actionFlow.SendActionEvent(&model.ActionEvent{Type: t, ActionID: action.ID}); err != nil {
+		c.lg.Error(err)
+	}
+}
+
+const (
+	createHandler = "create"
+	updateHandler = "update"
+)
+
+// observe - records latency and outcome of the handler
+func observe(handler string, start time.Time, err error) {
+	metrics.ProcessingDuration.WithLabelValues(handler).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		metrics.ProcessedActions.WithLabelValues(handler, "failed").Inc()
+	} else {
+		metrics.ProcessedActions.WithLabelValues(handler, "processed").Inc()
+	}
+}
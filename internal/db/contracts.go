@@ -2,14 +2,25 @@ package db
 
 import (
 	"context"
+	"time"
+
 	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/search"
 )
 
 type Tx interface {
 	Entities() EntityRepository
 	EntityTypes() EntityTypeRepository
 	Actions() ActionRepository
+	Deltas() ActionDeltaRepository
 	Microservices() MicroserviceRepository
+	APIKeys() APIKeyRepository
+	SigningSecrets() SigningSecretRepository
+	AccessLog() AccessLogRepository
+	RetentionRules() RetentionRuleRepository
+	ActionPartitions() ActionPartitionRepository
+	Webhooks() WebhookRepository
+	Timeouts() TimeoutRepository
 }
 
 type TxCallback func(context.Context, Tx) (interface{}, error)
@@ -17,6 +28,7 @@ type TxCallback func(context.Context, Tx) (interface{}, error)
 type Database interface {
 	ReadOnly(context.Context, TxCallback) (interface{}, error)
 	ReadWrite(context.Context, TxCallback) (interface{}, error)
+	Ping(context.Context) error
 }
 
 // EntityRepository provides entities data interactions
@@ -38,6 +50,10 @@ type EntityRepository interface {
 	FirstByID(ctx context.Context, ID model.ID) (*model.Entity, error)
 
 	FirstByIDWithEntityType(ctx context.Context, ID model.ID) (*model.Entity, error)
+
+	// DeleteOrphans - removes at most limit entities created before the given time,
+	// that are referenced by neither actions nor deltas
+	DeleteOrphans(ctx context.Context, before time.Time, limit int) (int, error)
 }
 
 // EntityTypeRepository provides entity types data interactions
@@ -63,6 +79,10 @@ type EntityTypeRepository interface {
 		name string,
 		serviceID model.ID,
 	) (*model.EntityType, error)
+
+	// DeleteOrphans - removes at most limit entity types created before the given time,
+	// that have no entities
+	DeleteOrphans(ctx context.Context, before time.Time, limit int) (int, error)
 }
 
 type MicroserviceRepository interface {
@@ -81,7 +101,130 @@ type ActionRepository interface {
 	Delete(context.Context, model.ID) error
 	FirstByID(context.Context, model.ID) (*model.Action, error)
 	FirstByUID(context.Context, model.UID) (*model.Action, error)
+	// UpdateStatus - an action the watchdog has moved to TimedOut keeps it
 	UpdateStatus(context.Context, model.ID, model.Status) error
+	SelectByParentUIDs(ctx context.Context, parentUIDs []model.UID, limit int) ([]model.Action, error)
+	CountChildren(ctx context.Context, parentUIDs []model.UID) (map[model.UID]int, error)
+	SelectChain(ctx context.Context, fromID, toID model.ID, limit int) ([]model.Action, error)
+	SelectRegistered(ctx context.Context, from, to time.Time, afterID model.ID, limit int) ([]model.Action, error)
+	ChainHashBefore(ctx context.Context, ID model.ID) (string, error)
 	Select(context.Context, *Cursor, *Filter) (*model.ActionCollection, error)
+	// Search - actions matching the search query as well as the filter, listed as Select lists them
+	Search(ctx context.Context, q *search.Query, c *Cursor, f *Filter) (*model.ActionCollection, error)
+	SelectVisibleIDs(ctx context.Context, IDs []model.ID, services []string) ([]model.ID, error)
 	CountAll(context.Context) (int, error)
-}
\ No newline at end of file
+	// Stats - numbers of the actions matching the filter registered within the time range
+	// of the query per bucket and group, buckets and groups without actions are left out
+	Stats(ctx context.Context, q *model.ActionStatsQuery, f *Filter) ([]model.ActionStatsCount, error)
+
+	// SelectExpiring - at most limit actions following afterID registered before the given time,
+	// ordered by ID, along with the service of their actor or target
+	SelectExpiring(ctx context.Context, afterID model.ID, before time.Time, limit int) ([]model.RetentionCandidate, error)
+	// Prune - removes the actions of the tombstones leaving the tombstones in their place
+	Prune(ctx context.Context, tombstones []model.ActionTombstone) error
+	// SelectTombstones - tombstones of the pruned actions from fromID up to toID, zero toID means no upper bound
+	SelectTombstones(ctx context.Context, fromID, toID model.ID) ([]model.ActionTombstone, error)
+}
+
+// ActionDeltaRepository provides normalized property changes of actions
+type ActionDeltaRepository interface {
+	Create(ctx context.Context, actionID, entityID model.ID, delta model.Delta) error
+	SelectByActionID(ctx context.Context, actionID model.ID) (model.Delta, error)
+	SelectByEntityID(ctx context.Context, entityID model.ID, until time.Time) ([]model.PropertyChange, error)
+	PropertyHistory(
+		ctx context.Context,
+		entityID model.ID,
+		propertyName string,
+		cursor *Cursor,
+	) (*model.PropertyChangeCollection, error)
+}
+
+// APIKeyRepository provides API keys of the receiver along with the services they are bound to
+type APIKeyRepository interface {
+	Create(ctx context.Context, k *model.APIKey) (*model.APIKey, error)
+	Select(ctx context.Context) ([]model.APIKey, error)
+	FirstByID(ctx context.Context, ID model.ID) (*model.APIKey, error)
+	FirstByHash(ctx context.Context, hash string) (*model.APIKey, error)
+	Rotate(ctx context.Context, ID model.ID, prefix, hash string, at time.Time) error
+	Revoke(ctx context.Context, ID model.ID, at time.Time) error
+}
+
+// SigningSecretRepository provides shared secrets microservices sign action submissions with
+type SigningSecretRepository interface {
+	FirstByServiceID(ctx context.Context, serviceID model.ID) (*model.SigningSecrets, error)
+	FirstByServiceName(ctx context.Context, name string) (*model.SigningSecrets, error)
+	Save(ctx context.Context, s *model.SigningSecrets) error
+}
+
+// AccessLogRepository - append-only log of back-office reads
+type AccessLogRepository interface {
+	Create(ctx context.Context, entry *model.AccessLogEntry) (model.ID, error)
+	Select(ctx context.Context, c *Cursor, f *Filter) (*model.AccessLogCollection, error)
+}
+
+// RetentionRuleRepository - rules telling how long actions are kept
+type RetentionRuleRepository interface {
+	Create(ctx context.Context, r *model.RetentionRule) (*model.RetentionRule, error)
+	Select(ctx context.Context) (model.RetentionRules, error)
+	Delete(ctx context.Context, ID model.ID) error
+}
+
+// WebhookRepository - rules webhooks about actions are posted by and the deliveries of those
+type WebhookRepository interface {
+	CreateRule(ctx context.Context, r *model.WebhookRule) (*model.WebhookRule, error)
+	// SelectRules - all rules ordered by ID, along with their secrets
+	SelectRules(ctx context.Context) (model.WebhookRules, error)
+	FirstRuleByID(ctx context.Context, ID model.ID) (*model.WebhookRule, error)
+	// UpdateRule - changes everything but the secret and the creation time
+	UpdateRule(ctx context.Context, r *model.WebhookRule) error
+	// DeleteRule - removes the rule along with its deliveries
+	DeleteRule(ctx context.Context, ID model.ID) error
+	// CreateDeliveries - stores the new deliveries, the ones of a rule about an action
+	// with a status it has been delivered about already are skipped
+	CreateDeliveries(ctx context.Context, deliveries []model.WebhookDelivery) error
+	// SelectDueDeliveries - at most limit pending deliveries due at the given time, most overdue first
+	SelectDueDeliveries(ctx context.Context, now time.Time, limit int) ([]model.WebhookDelivery, error)
+	// SelectDeliveries - latest deliveries first, allowed filters are ruleId, actionId and state
+	SelectDeliveries(ctx context.Context, c *Cursor, f *Filter) (*model.WebhookDeliveryCollection, error)
+	FirstDeliveryByID(ctx context.Context, ID model.ID) (*model.WebhookDelivery, error)
+	// UpdateDelivery - stores the outcome of an attempt
+	UpdateDelivery(ctx context.Context, d *model.WebhookDelivery) error
+}
+
+// TimeoutRepository - rules telling how long async actions are given to complete
+// and the sweeps of the watchdog timing out the ones that did not
+type TimeoutRepository interface {
+	CreateRule(ctx context.Context, r *model.TimeoutRule) (*model.TimeoutRule, error)
+	// SelectRules - all rules ordered by ID
+	SelectRules(ctx context.Context) (model.TimeoutRules, error)
+	DeleteRule(ctx context.Context, ID model.ID) error
+	// SelectCandidates - at most limit async actions following afterID still in progress,
+	// registered before the given time, ordered by ID, along with the service of their actor or target
+	SelectCandidates(ctx context.Context, afterID model.ID, before time.Time, limit int) ([]model.TimeoutCandidate, error)
+	// TimeOut - moves the action to TimedOut and stores the timeout, unless the action
+	// is not in progress anymore, in which case nothing is changed and false is returned
+	TimeOut(ctx context.Context, t *model.ActionTimeout) (bool, error)
+	CreateSweep(ctx context.Context, s *model.TimeoutSweep) (model.ID, error)
+	// UpdateSweep - stores the finishing time and the counts of the sweep
+	UpdateSweep(ctx context.Context, s *model.TimeoutSweep) error
+	// SelectSweeps - latest sweeps first, without their timeouts, unless services are nil only the sweeps
+	// that timed out actions visible to the services are listed, timed out counts only those actions
+	SelectSweeps(ctx context.Context, c *Cursor, services []string) (*model.TimeoutSweepCollection, error)
+	// FirstSweepByID - the sweep along with its timeouts
+	FirstSweepByID(ctx context.Context, ID model.ID) (*model.TimeoutSweep, error)
+}
+
+// ActionPartitionRepository - monthly partitions of the actions table,
+// storages that do not partition it return ErrNotPartitioned
+type ActionPartitionRepository interface {
+	// Select - partitions ordered by their bounds, the future one last
+	Select(ctx context.Context) ([]model.ActionPartition, error)
+	// Create - splits the month partitions off the future one
+	Create(ctx context.Context, partitions []model.ActionPartition) error
+	// Entomb - stores the tombstones and removes everything kept along with their actions,
+	// the actions themselves are removed with their partition
+	Entomb(ctx context.Context, tombstones []model.ActionTombstone) error
+	// Drop - removes the partition with all the actions it holds, ErrPartitionNotEntombed
+	// is returned instead when some of them have no tombstone
+	Drop(ctx context.Context, name string) error
+}
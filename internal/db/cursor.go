@@ -1,6 +1,6 @@
 package db
 
-import "time"
+import "github.com/denismitr/auditbase/internal/model"
 
 type Order string
 
@@ -64,24 +64,85 @@ func (s *Sort) GetOrDefault(k string, d Order) Order {
 
 type Cursor struct {
 	Sort *Sort
-	LastCreatedAt *time.Time
 	Page uint
 	PerPage uint
+
+	// After, Before - keyset the page follows or precedes, the page number is ignored then
+	After *Keyset
+	Before *Keyset
+
+	// SkipTotal - the total is not counted, which is what makes deep pages slow
+	SkipTotal bool
 }
 
 func (c *Cursor) Offset() uint {
-	if c.Page <= 1 {
+	if c.Page <= 1 || c.Keyed() {
 		return 0
 	}
 
 	return (c.Page - 1) * c.PerPage
 }
 
-func NewCursor(page, perPage uint, lastCreatedAt *time.Time, allowedSortColumns []string) *Cursor {
+// Keyed - whether the page is addressed by a keyset rather than by its number
+func (c *Cursor) Keyed() bool {
+	return c.After != nil || c.Before != nil
+}
+
+// Limit - one row over the page is fetched to tell whether there is another page
+func (c *Cursor) Limit() uint {
+	return c.PerPage + 1
+}
+
+// Paginate - trims the row fetched over the page, puts the rows fetched backwards, i.e. before
+// the keyset, back in order and sets the tokens of the neighbouring pages to the meta.
+// Rows are addressed by index, n is the number fetched, returns the number of rows on the page.
+// Without keyOf no tokens are handed out, e.g. when rows are not ordered by a keyset
+func (c *Cursor) Paginate(meta *model.Meta, n int, keyOf func(i int) Keyset, swap func(i, j int)) int {
+	more := n > int(c.PerPage)
+	if more {
+		n = int(c.PerPage)
+	}
+
+	if c.Before != nil {
+		for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+	}
+
+	if n == 0 || keyOf == nil {
+		return n
+	}
+
+	first, last := keyOf(0), keyOf(n-1)
+
+	switch {
+	case c.Before != nil:
+		meta.Next = last.Token()
+		if more {
+			meta.Prev = first.Token()
+		}
+	case c.After != nil:
+		meta.Prev = first.Token()
+		if more {
+			meta.Next = last.Token()
+		}
+	default:
+		if more {
+			meta.Next = last.Token()
+		}
+
+		if c.Offset() > 0 {
+			meta.Prev = first.Token()
+		}
+	}
+
+	return n
+}
+
+func NewCursor(page, perPage uint, allowedSortColumns []string) *Cursor {
 	return &Cursor{
-		Sort:          NewSort(allowedSortColumns),
-		Page:          page,
-		PerPage:       perPage,
-		LastCreatedAt: lastCreatedAt,
+		Sort:    NewSort(allowedSortColumns),
+		Page:    page,
+		PerPage: perPage,
 	}
 }
@@ -15,3 +15,5 @@ const ErrCouldNotBuildQuery = errtype.StringError("could not build query")
 const ErrEmptyWhereInList = errtype.StringError("WHERE IN clause is empty")
 
 const ErrActionNotFound = errtype.StringError("action not found")
+const ErrNotPartitioned = errtype.StringError("actions table is not partitioned")
+const ErrPartitionNotEntombed = errtype.StringError("actions of the partition have no tombstones")
@@ -3,18 +3,117 @@ package db
 import (
 	"fmt"
 	"github.com/denismitr/auditbase/internal/model"
+	"github.com/pkg/errors"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// Operator - comparison of a filtered column with the values of a condition
+type Operator string
+
+const (
+	EqOperator     Operator = "eq"
+	InOperator     Operator = "in"
+	PrefixOperator Operator = "prefix"
+	GteOperator    Operator = "gte"
+	LteOperator    Operator = "lte"
+)
+
+// LikeEscape - escape character of the patterns built by LikePrefix and LikeContains
+const LikeEscape = "!"
+
+// Condition - operator with the values it compares a filtered column to,
+// only the in operator takes more than one value
+type Condition struct {
+	Operator Operator
+	Values   []string
+}
+
+// ParseCondition - condition given as operator:values, e.g. in:1,5,6 or prefix:invoice_,
+// anything else is a plain value compared for equality
+func ParseCondition(raw string) Condition {
+	switch {
+	case strings.HasPrefix(raw, string(InOperator)+":"):
+		return Condition{Operator: InOperator, Values: strings.Split(strings.TrimPrefix(raw, string(InOperator)+":"), ",")}
+	case strings.HasPrefix(raw, string(PrefixOperator)+":"):
+		return Condition{Operator: PrefixOperator, Values: []string{strings.TrimPrefix(raw, string(PrefixOperator)+":")}}
+	default:
+		return Condition{Operator: EqOperator, Values: []string{raw}}
+	}
+}
+
+// Value - first value of the condition
+func (c Condition) Value() string {
+	if len(c.Values) == 0 {
+		return ""
+	}
+
+	return c.Values[0]
+}
+
+// ValueConverter - converts a condition value to the type of the filtered column
+type ValueConverter func(v string) (interface{}, error)
+
+func StringValue(v string) (interface{}, error) {
+	return v, nil
+}
+
+func IntValue(v string) (interface{}, error) {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidQueryInput, "%q is not an integer", v)
+	}
+
+	return n, nil
+}
+
+func BoolValue(v string) (interface{}, error) {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidQueryInput, "%q is not a boolean", v)
+	}
+
+	return b, nil
+}
+
+// TimeValue - time given in RFC 3339, in UTC
+func TimeValue(v string) (interface{}, error) {
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidQueryInput, "%q is not an RFC 3339 time", v)
+	}
+
+	return t.UTC(), nil
+}
+
+// LikePrefix - LIKE pattern matching values starting with the prefix, wildcards in the prefix
+// are escaped with LikeEscape
+func LikePrefix(prefix string) string {
+	return escapeLike(prefix) + "%"
+}
+
+// LikeContains - LIKE pattern matching values containing the text, wildcards in the text
+// are escaped with LikeEscape
+func LikeContains(text string) string {
+	return "%" + escapeLike(text) + "%"
+}
+
+func escapeLike(s string) string {
+	r := strings.NewReplacer(LikeEscape, LikeEscape+LikeEscape, "%", LikeEscape+"%", "_", LikeEscape+"_")
+	return r.Replace(s)
+}
+
 type Filter struct {
-	allowed []string
-	ids     []model.ID
-	items   map[string]string
+	allowed  []string
+	ids      []model.ID
+	services []string
+	items    map[string][]Condition
 }
 
 func NewFilter(allowed []string) *Filter {
 	return &Filter{
-		items:   make(map[string]string),
+		items:   make(map[string][]Condition),
 		allowed: allowed,
 	}
 }
@@ -33,16 +132,44 @@ func (f *Filter) Allows(k string) bool {
 	return false
 }
 
+// Add - equality condition of the key, replacing any conditions it had
 func (f *Filter) Add(k, v string) *Filter {
-	f.items[k] = v
+	f.items[k] = []Condition{{Operator: EqOperator, Values: []string{v}}}
+	return f
+}
+
+// Where - condition of the key, in addition to the ones it has,
+// e.g. a time range is a gte and an lte condition
+func (f *Filter) Where(k string, op Operator, values ...string) *Filter {
+	f.items[k] = append(f.items[k], Condition{Operator: op, Values: values})
 	return f
 }
 
+// Conditions - all conditions of the key
+func (f *Filter) Conditions(k string) []Condition {
+	return f.items[k]
+}
+
 func (f *Filter) ByIDs(ids []model.ID) *Filter {
 	f.ids = ids
 	return f
 }
 
+// ByServices - only actions whose actor or target belongs to one of the services,
+// only entities of one of the services
+func (f *Filter) ByServices(services []string) *Filter {
+	f.services = services
+	return f
+}
+
+func (f *Filter) HasServices() bool {
+	return len(f.services) > 0
+}
+
+func (f *Filter) Services() []string {
+	return f.services
+}
+
 func (f *Filter) Has(k string) bool {
 	if _, ok := f.items[k]; ok {
 		return true
@@ -61,7 +188,7 @@ func (f *Filter) IDs() []model.ID {
 
 func (f *Filter) StringOrDefault(k, d string) string {
 	if f.Has(k) {
-		return f.items[k]
+		return f.items[k][0].Value()
 	}
 
 	return d
@@ -72,7 +199,7 @@ func (f *Filter) MustString(k string) string {
 		panic(fmt.Sprintf( "no such key in filter %s", k))
 	}
 
-	return f.items[k]
+	return f.items[k][0].Value()
 }
 
 func (f *Filter) MustInt(k string) string {
@@ -80,12 +207,12 @@ func (f *Filter) MustInt(k string) string {
 		panic(fmt.Sprintf("no such key in filter %s", k))
 	}
 
-	return f.items[k]
+	return f.items[k][0].Value()
 }
 
 func (f *Filter) IntOrDefault(k string, d int) int {
 	if f.Has(k) {
-		v := f.items[k]
+		v := f.items[k][0].Value()
 		n, err := strconv.Atoi(v)
 		if err != nil {
 			return d
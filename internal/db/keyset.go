@@ -0,0 +1,54 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/errtype"
+)
+
+const ErrInvalidPageToken = errtype.StringError("page token is invalid")
+
+// Keyset - position in a listing ordered by a time column and then by ID,
+// continuation tokens handed out to clients are opaque encodings of it
+type Keyset struct {
+	At time.Time
+	ID model.ID
+}
+
+type keysetToken struct {
+	At string `json:"a"`
+	ID int64  `json:"i"`
+}
+
+// Token - opaque continuation token of the keyset
+func (k Keyset) Token() string {
+	b, err := json.Marshal(keysetToken{At: k.At.UTC().Format(time.RFC3339Nano), ID: k.ID.Int64()})
+	if err != nil {
+		panic("how could a keyset token fail to encode")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseKeyset - keyset encoded into the token
+func ParseKeyset(token string) (*Keyset, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+
+	var t keysetToken
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, ErrInvalidPageToken
+	}
+
+	at, err := time.Parse(time.RFC3339Nano, t.At)
+	if err != nil || t.ID <= 0 {
+		return nil, ErrInvalidPageToken
+	}
+
+	return &Keyset{At: at, ID: model.ID(t.ID)}, nil
+}
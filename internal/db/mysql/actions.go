@@ -7,9 +7,12 @@ import (
 	"fmt"
 	sq "github.com/Masterminds/squirrel"
 	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/sqlstore"
 	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/search"
 	"github.com/doug-martin/goqu/v9"
 	_ "github.com/doug-martin/goqu/v9/dialect/mysql"
+	"github.com/doug-martin/goqu/v9/exp"
 	"github.com/pkg/errors"
 	"time"
 )
@@ -18,6 +21,7 @@ type StringInterfaceMap map[string]interface{}
 
 type ActionRepository struct {
 	*Tx
+	*sqlstore.ActionRepository
 }
 
 type actionRecord struct {
@@ -33,11 +37,29 @@ type actionRecord struct {
 	Details        sql.NullString `db:"details"`
 	EmittedAt      time.Time      `db:"emitted_at"`
 	RegisteredAt   time.Time      `db:"registered_at"`
+	PrevChainHash  sql.NullString `db:"prev_chain_hash"`
+	ChainHash      sql.NullString `db:"chain_hash"`
+}
+
+type childrenCountRecord struct {
+	ParentUID string `db:"parent_uid"`
+	Cnt       int    `db:"cnt"`
 }
 
 var _ db.ActionRepository = (*ActionRepository)(nil)
 
+// Create - stores the action linked to the hash chain, the head of the chain
+// stays locked until the transaction is over, so concurrent writers cannot fork it
 func (r *ActionRepository) Create(ctx context.Context, action *model.Action) (*model.Action, error) {
+	prevChainHash, err := r.lastChainHash(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := action.SealChain(prevChainHash); err != nil {
+		return nil, err
+	}
+
 	q, args, err := createActionQuery(action)
 	if err != nil {
 		panic(fmt.Sprintf("how could createActionQuery func have failed? %s", err.Error()))
@@ -58,9 +80,67 @@ func (r *ActionRepository) Create(ctx context.Context, action *model.Action) (*m
 		return nil, errors.Wrapf(err, "could not retrieve last insert ID on action [%s] create", action.Name)
 	}
 
+	// uniqueness of uid and hash is kept by action_keys, the partitioned actions table can only
+	// have unique keys including registered_at
+	q, args, err = createActionKeyQuery(model.ID(newID), action)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := r.mysqlTx.ExecContext(ctx, q, args...); err != nil {
+		return nil, errors.Wrapf(err, "could not create action [%s], it might already exist", action.UID)
+	}
+
+	// words of searches are looked up in action_texts, partitioned tables cannot have FULLTEXT indexes
+	if action.Details != nil {
+		q, args, err = createActionTextQuery(model.ID(newID), action)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := r.mysqlTx.ExecContext(ctx, q, args...); err != nil {
+			return nil, errors.Wrapf(err, "could not index details of action [%s]", action.UID)
+		}
+	}
+
+	if err := r.advanceChain(ctx, action.ChainHash); err != nil {
+		return nil, err
+	}
+
 	return r.FirstByID(ctx, model.ID(newID))
 }
 
+func (r *ActionRepository) lastChainHash(ctx context.Context) (string, error) {
+	q, args, err := lastChainHashQuery()
+	if err != nil {
+		return "", err
+	}
+
+	var hash string
+	if err := r.mysqlTx.GetContext(ctx, &hash, q, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.New("action chain is not initialized")
+		}
+
+		return "", errors.Wrap(err, "could not lock action chain")
+	}
+
+	return hash, nil
+}
+
+func (r *ActionRepository) advanceChain(ctx context.Context, chainHash string) error {
+	q, args, err := advanceChainQuery(chainHash)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.mysqlTx.ExecContext(ctx, q, args...); err != nil {
+		return errors.Wrap(err, "could not advance action chain")
+	}
+
+	return nil
+}
+
 func (r *ActionRepository) UpdateStatus(ctx context.Context, id model.ID, status model.Status) error {
 	q, args, err := updateActionQuery(id, status)
 	if err != nil {
@@ -80,8 +160,8 @@ func (r *ActionRepository) UpdateStatus(ctx context.Context, id model.ID, status
 }
 
 func updateActionQuery(id model.ID, status model.Status) (string, []interface{}, error) {
-	q := "UPDATE actions SET status = ? WHERE id = ?"
-	return q, []interface{}{int64(status), id.Int64()}, nil
+	q := "UPDATE actions SET status = ? WHERE id = ? AND status <> ?"
+	return q, []interface{}{int64(status), id.Int64(), int64(model.TimedOut)}, nil
 }
 
 func (r *ActionRepository) CountAll(ctx context.Context) (int, error) {
@@ -111,41 +191,70 @@ func (r *ActionRepository) Select(
 ) (*model.ActionCollection, error) {
 	sQ, err := selectActionsQuery(c, f)
 	if err != nil {
-		panic(fmt.Sprintf("how could selectActionsQuery func fail with %s", err))
+		return nil, err
 	}
 
-	selectStmt, err := r.mysqlTx.PreparexContext(ctx, sQ.selectSQL)
+	return r.selectCollection(ctx, sQ, c)
+}
+
+// Search - actions matching the search query and the filter, conditions compare the values
+// JSON_EXTRACT finds, words are matched against the FULLTEXT index of action_texts
+func (r *ActionRepository) Search(
+	ctx context.Context,
+	q *search.Query,
+	c *db.Cursor,
+	f *db.Filter,
+) (*model.ActionCollection, error) {
+	sQ, err := selectActionsQuery(c, f, searchExpressions(goqu.Dialect(MySQL8), q)...)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not prepare select actions query")
+		return nil, err
 	}
 
-	countStmt, err := r.mysqlTx.PreparexContext(ctx, sQ.countSQL)
+	return r.selectCollection(ctx, sQ, c)
+}
+
+func (r *ActionRepository) selectCollection(ctx context.Context, sQ *sqlstore.SelectQuery, c *db.Cursor) (*model.ActionCollection, error) {
+	selectStmt, err := r.mysqlTx.PreparexContext(ctx, sQ.SelectSQL)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not prepare select actions query")
 	}
 
-	var total int
-	if err := countStmt.GetContext(ctx, &total); err != nil {
-		return nil, errors.Wrap(err, "could not execute count actions query")
+	defer func() { _ = selectStmt.Close() }()
+
+	var total *int
+	if !c.SkipTotal {
+		countStmt, err := r.mysqlTx.PreparexContext(ctx, sQ.CountSQL)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not prepare count actions query")
+		}
+
+		defer func() { _ = countStmt.Close() }()
+
+		total = new(int)
+		if err := countStmt.GetContext(ctx, total, sQ.CountArgs...); err != nil {
+			return nil, errors.Wrap(err, "could not execute count actions query")
+		}
 	}
 
 	var ars []actionRecord
-	if err := selectStmt.SelectContext(ctx, &ars); err != nil {
+	if err := selectStmt.SelectContext(ctx, &ars, sQ.SelectArgs...); err != nil {
 		return nil, errors.Wrap(err, "could not execute select actions query")
 	}
 
-	return mapActionRecordsToCollection(ars, total, c.Page, c.PerPage), nil
+	return mapActionRecordsToCollection(ars, total, c, sQ.Keyed), nil
 }
 
-func selectActionsQuery(c *db.Cursor, f *db.Filter) (*selectQuery, error) {
-	dialectCq := goqu.Dialect(MySQL8)
-	dialectSq := goqu.Dialect(MySQL8)
+// selectActionsQuery - actions are listed by registration time and then by ID, so that pages can
+// follow keysets, ordering by name allows numbered pages only. Expressions given narrow down
+// the actions in addition to the filter
+func selectActionsQuery(c *db.Cursor, f *db.Filter, expressions ...exp.Expression) (*sqlstore.SelectQuery, error) {
+	dialect := goqu.Dialect(MySQL8)
 
-	countQ := dialectCq.
+	countQ := dialect.
 		From("actions").
 		Select(goqu.L("count(*)").As("cnt"))
 
-	q := dialectSq.Select(
+	q := dialect.Select(
 		"id", "uid", "name",
 		goqu.L("HEX(`hash`)").As("hash"),
 		"parent_uid", "actor_entity_id", "target_entity_id",
@@ -153,31 +262,24 @@ func selectActionsQuery(c *db.Cursor, f *db.Filter) (*selectQuery, error) {
 		"emitted_at", "registered_at",
 	).From("actions")
 
-	if f.Has("uid") {
-		exp := goqu.L("`uid` = ?", f.MustString("uid"))
-		countQ = countQ.Where(exp)
-		q = q.Where(exp)
-	}
-
-	if f.Has("parentUid") {
-		exp := goqu.L("`parent_uid` = ?", f.MustString("parentUid"))
-		countQ = countQ.Where(exp)
-		q = q.Where(exp)
+	where, err := sqlstore.ActionFilterExpressions(dialect, f)
+	if err != nil {
+		return nil, err
 	}
 
-	if f.Has("actorEntityId") {
-		exp := goqu.L("`actor_entity_id` = ?", f.MustInt("actor_entity_id"))
-		countQ = countQ.Where(exp)
-		q = q.Where(exp)
+	where = append(where, expressions...)
+	if len(where) > 0 {
+		countQ = countQ.Where(where...)
+		q = q.Where(where...)
 	}
 
-	if f.Has("targetEntityId") {
-		exp := goqu.L("target_entity_id = ?", f.MustInt("target_entity_id"))
-		countQ = countQ.Where(exp)
-		q = q.Where(exp)
-	}
+	sQ := sqlstore.SelectQuery{}
 
 	if c.Sort.Has("name") {
+		if c.Keyed() {
+			return nil, errors.Wrap(db.ErrInvalidQueryInput, "actions ordered by name cannot follow page tokens")
+		}
+
 		expr := goqu.I("name")
 		if c.Sort.GetOrDefault("name", db.DESCOrder) == db.ASCOrder {
 			q = q.Order(expr.Asc())
@@ -185,30 +287,32 @@ func selectActionsQuery(c *db.Cursor, f *db.Filter) (*selectQuery, error) {
 			q = q.Order(expr.Desc())
 		}
 	} else {
-		expr := goqu.I("registered_at")
-		if c.Sort.GetOrDefault("registered_at", db.DESCOrder) == db.ASCOrder {
-			q = q.Order(expr.Asc())
-		} else {
-			q = q.Order(expr.Desc())
+		column, id := goqu.C("registered_at"), goqu.C("id")
+		order := c.Sort.GetOrDefault("registered_at", db.DESCOrder)
+
+		if c.Keyed() {
+			q = q.Where(sqlstore.KeysetExpression(column, id, order, c))
 		}
+
+		q = q.Order(sqlstore.KeysetOrder(column, id, order, c)...)
+		sQ.Keyed = true
 	}
 
-	q = q.Limit(c.PerPage)
+	q = q.Limit(c.Limit())
 	q = q.Offset(c.Offset())
 
-	sQ := selectQuery{}
-	if query, args, err := q.ToSQL(); err != nil {
-		return nil, errors.Wrap(err, "invalid select SQL for entities")
+	if query, args, err := q.Prepared(true).ToSQL(); err != nil {
+		return nil, errors.Wrap(err, "invalid select SQL for actions")
 	} else {
-		sQ.selectSQL = query
-		sQ.selectArgs = args
+		sQ.SelectSQL = query
+		sQ.SelectArgs = args
 	}
 
-	if query, args, err := countQ.ToSQL(); err != nil {
-		return nil, errors.Wrap(err, "invalid count SQL for entities")
+	if query, args, err := countQ.Prepared(true).ToSQL(); err != nil {
+		return nil, errors.Wrap(err, "invalid count SQL for actions")
 	} else {
-		sQ.countSQL = query
-		sQ.countArgs = args
+		sQ.CountSQL = query
+		sQ.CountArgs = args
 	}
 
 	return &sQ, nil
@@ -281,7 +385,7 @@ func (r *ActionRepository) Delete(ctx context.Context, ID model.ID) error {
 		return errors.Wrapf(err, "could not delete action with ID [%d]", ID)
 	}
 
-	return nil
+	return r.store().DeleteActionDependents(ctx, []int{int(ID)})
 }
 
 func (r *ActionRepository) Names(ctx context.Context) ([]string, error) {
@@ -320,7 +424,9 @@ func firstActionByIDQuery(ID model.ID) (string, []interface{}, error) {
 
 	return dialect.From("actions").Select(
 		"id", "uid", "parent_uid", "status", "is_async",
+		"actor_entity_id", "target_entity_id",
 		goqu.L("HEX(`hash`)").As("hash"), "name", "details", "emitted_at", "registered_at",
+		"prev_chain_hash", "chain_hash",
 	).Where(
 		goqu.L("`id` = ?", int(ID)),
 	).Limit(1).ToSQL()
@@ -337,6 +443,7 @@ func firstActionByUIDQuery(UID model.UID) (string, []interface{}, error) {
 		"id", "uid", "parent_uid", "is_async", "status",
 		"actor_entity_id", "target_entity_id",
 		goqu.L("HEX(`hash`)").As("hash"), "name", "details", "emitted_at", "registered_at",
+		"prev_chain_hash", "chain_hash",
 	).Where(
 		goqu.L("`uid` = ?", UID.String()),
 	).Limit(1).ToSQL()
@@ -372,6 +479,8 @@ func createActionQuery(action *model.Action) (string, []interface{}, error) {
 	row["is_async"] = action.IsAsync
 	row["emitted_at"] = action.EmittedAt.Time
 	row["registered_at"] = action.RegisteredAt.Time
+	row["prev_chain_hash"] = action.PrevChainHash
+	row["chain_hash"] = action.ChainHash
 
 	if action.Details != nil {
 		b, err := json.Marshal(action.Details)
@@ -383,3 +492,350 @@ func createActionQuery(action *model.Action) (string, []interface{}, error) {
 
 	return dialect.Insert("actions").Rows(row).Prepared(true).ToSQL()
 }
+
+func createActionKeyQuery(ID model.ID, action *model.Action) (string, []interface{}, error) {
+	dialect := goqu.Dialect(MySQL8)
+
+	return dialect.Insert("action_keys").Rows(goqu.Record{
+		"action_id": ID.Int64(),
+		"uid":       action.UID.String(),
+		"hash":      goqu.L("UNHEX(?)", action.Hash),
+	}).Prepared(true).ToSQL()
+}
+
+func createActionTextQuery(ID model.ID, action *model.Action) (string, []interface{}, error) {
+	b, err := json.Marshal(action.Details)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "could not create details json string")
+	}
+
+	return goqu.Dialect(MySQL8).Insert("action_texts").Rows(goqu.Record{
+		"action_id": ID.Int64(),
+		"body":      string(b),
+	}).Prepared(true).ToSQL()
+}
+
+// SelectByParentUIDs - direct children of the given actions ordered by emitted at time
+func (r *ActionRepository) SelectByParentUIDs(
+	ctx context.Context,
+	parentUIDs []model.UID,
+	limit int,
+) ([]model.Action, error) {
+	q, args, err := selectActionsByParentUIDsQuery(parentUIDs, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := r.mysqlTx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare select actions by parent uids query")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	var ars []actionRecord
+	if err := stmt.SelectContext(ctx, &ars, args...); err != nil {
+		return nil, errors.Wrap(err, "could not select actions by parent uids")
+	}
+
+	actions := make([]model.Action, len(ars))
+	for i := range ars {
+		actions[i] = *mapActionRecordToModel(ars[i])
+	}
+
+	return actions, nil
+}
+
+// CountChildren - number of direct children of the given actions,
+// actions without children are not present in the result
+func (r *ActionRepository) CountChildren(ctx context.Context, parentUIDs []model.UID) (map[model.UID]int, error) {
+	q, args, err := countChildrenQuery(parentUIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := r.mysqlTx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare count children query")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	var records []childrenCountRecord
+	if err := stmt.SelectContext(ctx, &records, args...); err != nil {
+		return nil, errors.Wrap(err, "could not count children of actions")
+	}
+
+	result := make(map[model.UID]int, len(records))
+	for i := range records {
+		result[model.UID(records[i].ParentUID)] = records[i].Cnt
+	}
+
+	return result, nil
+}
+
+func selectActionsByParentUIDsQuery(parentUIDs []model.UID, limit int) (string, []interface{}, error) {
+	if len(parentUIDs) == 0 || limit <= 0 {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(MySQL8)
+
+	return dialect.Select(
+		"id", "uid", "name",
+		goqu.L("HEX(`hash`)").As("hash"),
+		"parent_uid", "actor_entity_id", "target_entity_id",
+		"is_async", "status",
+		"emitted_at", "registered_at",
+	).
+		From("actions").
+		Where(goqu.C("parent_uid").In(uidsToStrings(parentUIDs))).
+		Order(goqu.C("emitted_at").Asc(), goqu.C("id").Asc()).
+		Limit(uint(limit)).
+		Prepared(true).
+		ToSQL()
+}
+
+func countChildrenQuery(parentUIDs []model.UID) (string, []interface{}, error) {
+	if len(parentUIDs) == 0 {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(MySQL8)
+
+	return dialect.Select("parent_uid", goqu.L("count(*)").As("cnt")).
+		From("actions").
+		Where(goqu.C("parent_uid").In(uidsToStrings(parentUIDs))).
+		GroupBy("parent_uid").
+		Prepared(true).
+		ToSQL()
+}
+
+func uidsToStrings(uids []model.UID) []string {
+	result := make([]string, len(uids))
+	for i := range uids {
+		result[i] = uids[i].String()
+	}
+
+	return result
+}
+
+// SelectChain - actions with IDs from fromID up to toID in the order they were chained,
+// toID of zero means up to the last action
+func (r *ActionRepository) SelectChain(ctx context.Context, fromID, toID model.ID, limit int) ([]model.Action, error) {
+	q, args, err := selectChainQuery(fromID, toID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := r.mysqlTx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare select chain query")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	var ars []actionRecord
+	if err := stmt.SelectContext(ctx, &ars, args...); err != nil {
+		return nil, errors.Wrapf(err, "could not select chain of actions from ID %d", fromID)
+	}
+
+	actions := make([]model.Action, len(ars))
+	for i := range ars {
+		actions[i] = *mapActionRecordToModel(ars[i])
+	}
+
+	return actions, nil
+}
+
+// SelectRegistered - at most limit actions following afterID registered from the given time
+// and before the other one, ordered by ID
+func (r *ActionRepository) SelectRegistered(
+	ctx context.Context,
+	from, to time.Time,
+	afterID model.ID,
+	limit int,
+) ([]model.Action, error) {
+	q, args, err := selectRegisteredActionsQuery(from, to, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := r.mysqlTx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare select registered actions query")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	var ars []actionRecord
+	if err := stmt.SelectContext(ctx, &ars, args...); err != nil {
+		return nil, errors.Wrapf(err, "could not select actions registered from %s after ID %d", from, afterID)
+	}
+
+	actions := make([]model.Action, len(ars))
+	for i := range ars {
+		actions[i] = *mapActionRecordToModel(ars[i])
+	}
+
+	return actions, nil
+}
+
+// ChainHashBefore - chain hash of the action preceding the given ID,
+// empty when there is no such action or it is not chained,
+// an action pruned by retention still precedes through its tombstone
+func (r *ActionRepository) ChainHashBefore(ctx context.Context, ID model.ID) (string, error) {
+	link, err := r.chainLinkBefore(ctx, "actions", "id", ID)
+	if err != nil {
+		return "", err
+	}
+
+	pruned, err := r.chainLinkBefore(ctx, "action_tombstones", "action_id", ID)
+	if err != nil {
+		return "", err
+	}
+
+	if pruned.ID > link.ID {
+		return pruned.ChainHash.String, nil
+	}
+
+	return link.ChainHash.String, nil
+}
+
+type chainLinkRecord struct {
+	ID        int            `db:"id"`
+	ChainHash sql.NullString `db:"chain_hash"`
+}
+
+func (r *ActionRepository) chainLinkBefore(ctx context.Context, table, column string, ID model.ID) (chainLinkRecord, error) {
+	var link chainLinkRecord
+
+	q, args, err := chainHashBeforeQuery(table, column, ID)
+	if err != nil {
+		return link, err
+	}
+
+	if err := r.mysqlTx.GetContext(ctx, &link, q, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return link, nil
+		}
+
+		return link, errors.Wrapf(err, "could not get chain hash preceding ID %d in %s", ID, table)
+	}
+
+	return link, nil
+}
+
+func lastChainHashQuery() (string, []interface{}, error) {
+	dialect := goqu.Dialect(MySQL8)
+
+	return dialect.From("action_chain").
+		Select("last_hash").
+		Where(goqu.C("id").Eq(1)).
+		ForUpdate(exp.Wait).
+		Prepared(true).
+		ToSQL()
+}
+
+func advanceChainQuery(chainHash string) (string, []interface{}, error) {
+	dialect := goqu.Dialect(MySQL8)
+
+	return dialect.Update("action_chain").
+		Set(goqu.Record{"last_hash": chainHash}).
+		Where(goqu.C("id").Eq(1)).
+		Prepared(true).
+		ToSQL()
+}
+
+func selectChainQuery(fromID, toID model.ID, limit int) (string, []interface{}, error) {
+	if limit <= 0 {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(MySQL8)
+
+	q := dialect.From("actions").Select(
+		"id", "uid", "parent_uid", "status", "is_async",
+		"actor_entity_id", "target_entity_id",
+		goqu.L("HEX(`hash`)").As("hash"), "name", "details", "emitted_at", "registered_at",
+		"prev_chain_hash", "chain_hash",
+	).Where(goqu.C("id").Gte(fromID.Int64()))
+
+	if toID.Valid() {
+		q = q.Where(goqu.C("id").Lte(toID.Int64()))
+	}
+
+	return q.Order(goqu.C("id").Asc()).Limit(uint(limit)).Prepared(true).ToSQL()
+}
+
+func selectRegisteredActionsQuery(from, to time.Time, afterID model.ID, limit int) (string, []interface{}, error) {
+	if limit <= 0 || !from.Before(to) {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(MySQL8)
+
+	q := dialect.From("actions").Select(
+		"id", "uid", "parent_uid", "status", "is_async",
+		"actor_entity_id", "target_entity_id",
+		goqu.L("HEX(`hash`)").As("hash"), "name", "details", "emitted_at", "registered_at",
+		"prev_chain_hash", "chain_hash",
+	).Where(
+		goqu.C("id").Gt(afterID.Int64()),
+		goqu.C("registered_at").Gte(from.UTC()),
+		goqu.C("registered_at").Lt(to.UTC()),
+	)
+
+	return q.Order(goqu.C("id").Asc()).Limit(uint(limit)).Prepared(true).ToSQL()
+}
+
+func chainHashBeforeQuery(table, column string, ID model.ID) (string, []interface{}, error) {
+	dialect := goqu.Dialect(MySQL8)
+
+	return dialect.From(table).
+		Select(goqu.C(column).As("id"), goqu.C("chain_hash")).
+		Where(goqu.C(column).Lt(ID.Int64())).
+		Order(goqu.C(column).Desc()).
+		Limit(1).
+		Prepared(true).
+		ToSQL()
+}
+
+// SelectVisibleIDs - IDs among the given ones of actions whose actor or target
+// entity belongs to one of the services
+func (r *ActionRepository) SelectVisibleIDs(ctx context.Context, IDs []model.ID, services []string) ([]model.ID, error) {
+	q, args, err := selectVisibleActionIDsQuery(IDs, services)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	if err := r.mysqlTx.SelectContext(ctx, &ids, q, args...); err != nil {
+		return nil, errors.Wrap(err, "could not select visible actions")
+	}
+
+	result := make([]model.ID, len(ids))
+	for i := range ids {
+		result[i] = model.ID(ids[i])
+	}
+
+	return result, nil
+}
+
+func selectVisibleActionIDsQuery(IDs []model.ID, services []string) (string, []interface{}, error) {
+	if len(IDs) == 0 || len(services) == 0 {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	ids := make([]int, len(IDs))
+	for i := range IDs {
+		ids[i] = int(IDs[i])
+	}
+
+	dialect := goqu.Dialect(MySQL8)
+
+	return dialect.From("actions").
+		Select("id").
+		Where(goqu.C("id").In(ids), sqlstore.ActionServicesExpression(dialect, services)).
+		Prepared(true).ToSQL()
+}
@@ -3,6 +3,8 @@ package mysql
 import (
 	"github.com/denismitr/auditbase/internal/db"
 	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/search"
+	"github.com/doug-martin/goqu/v9"
 	"github.com/stretchr/testify/assert"
 	"testing"
 	"time"
@@ -34,7 +36,7 @@ func Test_createActionQuery(t *testing.T) {
 				EmittedAt:    model.JSONTime{Time: emitted},
 				RegisteredAt: model.JSONTime{Time: registered},
 			},
-			expected: "INSERT INTO `actions` (`actor_entity_id`, `emitted_at`, `hash`, `is_async`, `name`, `parent_uid`, `registered_at`, `status`, `target_entity_id`, `uid`) VALUES (?, ?, UNHEX(?), ?, ?, ?, ?, ?, ?, ?)",
+			expected: "INSERT INTO `actions` (`actor_entity_id`, `chain_hash`, `emitted_at`, `hash`, `is_async`, `name`, `parent_uid`, `prev_chain_hash`, `registered_at`, `status`, `target_entity_id`, `uid`) VALUES (?, ?, ?, UNHEX(?), ?, ?, ?, ?, ?, ?, ?, ?)",
 		},
 		{
 			action: &model.Action{
@@ -48,7 +50,7 @@ func Test_createActionQuery(t *testing.T) {
 				EmittedAt:     model.JSONTime{Time: emitted},
 				RegisteredAt:  model.JSONTime{Time: registered},
 			},
-			expected: "INSERT INTO `actions` (`actor_entity_id`, `emitted_at`, `hash`, `is_async`, `name`, `parent_uid`, `registered_at`, `status`, `target_entity_id`, `uid`) VALUES (?, ?, UNHEX(?), ?, ?, ?, ?, ?, ?, ?)",
+			expected: "INSERT INTO `actions` (`actor_entity_id`, `chain_hash`, `emitted_at`, `hash`, `is_async`, `name`, `parent_uid`, `prev_chain_hash`, `registered_at`, `status`, `target_entity_id`, `uid`) VALUES (?, ?, ?, UNHEX(?), ?, ?, ?, ?, ?, ?, ?, ?)",
 		},
 		{
 			action: &model.Action{
@@ -62,7 +64,7 @@ func Test_createActionQuery(t *testing.T) {
 				EmittedAt:      model.JSONTime{Time: emitted},
 				RegisteredAt:   model.JSONTime{Time: registered},
 			},
-			expected: "INSERT INTO `actions` (`actor_entity_id`, `emitted_at`, `hash`, `is_async`, `name`, `parent_uid`, `registered_at`, `status`, `target_entity_id`, `uid`) VALUES (?, ?, UNHEX(?), ?, ?, ?, ?, ?, ?, ?)",
+			expected: "INSERT INTO `actions` (`actor_entity_id`, `chain_hash`, `emitted_at`, `hash`, `is_async`, `name`, `parent_uid`, `prev_chain_hash`, `registered_at`, `status`, `target_entity_id`, `uid`) VALUES (?, ?, ?, UNHEX(?), ?, ?, ?, ?, ?, ?, ?, ?)",
 		},
 	}
 
@@ -81,19 +83,73 @@ func Test_selectActionsQuery(t *testing.T) {
 	t.Run("no filters", func(t *testing.T) {
 		t.Log("testing select actions query with no filters")
 
-		c := db.NewCursor(1, 100, nil, []string{"id"})
+		c := db.NewCursor(1, 100, []string{"id"})
 		f := db.NewFilter([]string{})
 		selectQuery, err := selectActionsQuery(c, f)
 		if ! assert.NoError(t, err) {
 			t.Fatal(err)
 		}
 
-		expectedSelectSQL := "SELECT `id`, `uid`, `name`, HEX(`hash`) AS `hash`, `parent_uid`, `actor_entity_id`, `target_entity_id`, `is_async`, `status`, `emitted_at`, `registered_at` FROM `actions` ORDER BY `registered_at` DESC LIMIT 100"
-		assert.Equal(t, expectedSelectSQL, selectQuery.selectSQL)
-		assert.Len(t, selectQuery.selectArgs, 0)
+		expectedSelectSQL := "SELECT `id`, `uid`, `name`, HEX(`hash`) AS `hash`, `parent_uid`, `actor_entity_id`, `target_entity_id`, `is_async`, `status`, `emitted_at`, `registered_at` FROM `actions` ORDER BY `registered_at` DESC, `id` DESC LIMIT ?"
+		assert.Equal(t, expectedSelectSQL, selectQuery.SelectSQL)
+		assert.Equal(t, []interface{}{int64(101)}, selectQuery.SelectArgs)
 
 		expectedCountSQL := "SELECT count(*) AS `cnt` FROM `actions`"
-		assert.Equal(t, expectedCountSQL, selectQuery.countSQL)
-		assert.Len(t, selectQuery.countArgs, 0)
+		assert.Equal(t, expectedCountSQL, selectQuery.CountSQL)
+		assert.Len(t, selectQuery.CountArgs, 0)
 	})
 }
+
+func Test_chainQueries(t *testing.T) {
+	t.Run("last chain hash is locked", func(t *testing.T) {
+		q, args, err := lastChainHashQuery()
+
+		assert.NoError(t, err)
+		assert.Equal(t, "SELECT `last_hash` FROM `action_chain` WHERE (`id` = ?) FOR UPDATE ", q)
+		assert.Equal(t, []interface{}{int64(1)}, args)
+	})
+
+	t.Run("select chain range", func(t *testing.T) {
+		q, args, err := selectChainQuery(10, 20, 500)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "SELECT `id`, `uid`, `parent_uid`, `status`, `is_async`, `actor_entity_id`, `target_entity_id`, "+
+			"HEX(`hash`) AS `hash`, `name`, `details`, `emitted_at`, `registered_at`, `prev_chain_hash`, `chain_hash` "+
+			"FROM `actions` WHERE ((`id` >= ?) AND (`id` <= ?)) ORDER BY `id` ASC LIMIT ?", q)
+		assert.Equal(t, []interface{}{int64(10), int64(20), int64(500)}, args)
+	})
+
+	t.Run("select chain without upper bound", func(t *testing.T) {
+		q, args, err := selectChainQuery(10, 0, 500)
+
+		assert.NoError(t, err)
+		assert.Contains(t, q, "WHERE (`id` >= ?) ORDER BY `id` ASC LIMIT ?")
+		assert.Equal(t, []interface{}{int64(10), int64(500)}, args)
+	})
+}
+
+func Test_searchActionsQuery(t *testing.T) {
+	q, err := search.Parse(`details.order.id = 48213 delta.price.to > 100 "paid in full"`)
+	if ! assert.NoError(t, err) {
+		t.Fatal(err)
+	}
+
+	selectQuery, err := selectActionsQuery(db.NewCursor(1, 10, nil), db.NewFilter(nil), searchExpressions(goqu.Dialect(MySQL8), q)...)
+	if ! assert.NoError(t, err) {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "SELECT count(*) AS `cnt` FROM `actions` WHERE (JSON_EXTRACT(`actions`.`details`, ?) = CAST(? AS JSON) AND "+
+		"`actions`.`id` IN (SELECT `action_id` FROM `action_deltas` WHERE ((`property_name` = ?) AND "+
+		"(JSON_TYPE(JSON_EXTRACT(`to_value`, '$')) IN ('INTEGER', 'UNSIGNED INTEGER', 'DOUBLE', 'DECIMAL') AND JSON_EXTRACT(`to_value`, '$') > CAST(? AS JSON)))) AND "+
+		"`actions`.`id` IN (SELECT `action_id` FROM `action_texts` WHERE MATCH(`body`) AGAINST(? IN BOOLEAN MODE)))", selectQuery.CountSQL)
+	assert.Equal(t, []interface{}{`$."order"."id"`, "48213", "price", "100", `"paid in full"`}, selectQuery.CountArgs)
+}
+
+func Test_createActionTextQuery(t *testing.T) {
+	q, args, err := createActionTextQuery(model.ID(7), &model.Action{Details: map[string]interface{}{"order": 48213}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO `action_texts` (`action_id`, `body`) VALUES (?, ?)", q)
+	assert.Equal(t, []interface{}{int64(7), `{"order":48213}`}, args)
+}
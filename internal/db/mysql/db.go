@@ -4,9 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/sqlstore"
+	"github.com/denismitr/auditbase/internal/metrics"
 	"github.com/denismitr/auditbase/internal/utils/logger"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
+	"time"
 )
 
 const MySQL8 = "mysql8"
@@ -30,13 +33,25 @@ type Tx struct {
 
 var _ db.Tx = (*Tx)(nil)
 
-func (db *Database) ReadOnly(ctx context.Context, cb db.TxCallback) (interface{}, error) {
+// Ping - verifies the connection to the database is still alive
+func (db *Database) Ping(ctx context.Context) error {
+	if err := db.conn.PingContext(ctx); err != nil {
+		return errors.Wrap(err, "could not ping mysql")
+	}
+
+	return nil
+}
+
+func (db *Database) ReadOnly(ctx context.Context, cb db.TxCallback) (result interface{}, err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveTx(MySQL8, metrics.ReadOnly, start, err) }()
+
 	mysqlTx, err := db.conn.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelSerializable})
 	if err != nil {
 		return nil, errors.Wrap(err, "could not start read only Tx")
 	}
 
-	result, err := cb(ctx, &Tx{mysqlTx: mysqlTx, lg: db.lg});
+	result, err = cb(ctx, &Tx{mysqlTx: mysqlTx, lg: db.lg});
 	if err != nil {
 		if rbErr := mysqlTx.Rollback(); rbErr != nil {
 			return nil, errors.Wrap(err, rbErr.Error())
@@ -52,13 +67,16 @@ func (db *Database) ReadOnly(ctx context.Context, cb db.TxCallback) (interface{}
 	return result, nil
 }
 
-func (db *Database) ReadWrite(ctx context.Context, cb db.TxCallback) (interface{}, error) {
+func (db *Database) ReadWrite(ctx context.Context, cb db.TxCallback) (result interface{}, err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveTx(MySQL8, metrics.ReadWrite, start, err) }()
+
 	mysqlTx, err := db.conn.BeginTxx(ctx, &sql.TxOptions{ReadOnly: false, Isolation: sql.LevelSerializable})
 	if err != nil {
 		return nil, errors.Wrap(err, "could not start read write Tx")
 	}
 
-	result, err := cb(ctx, &Tx{mysqlTx: mysqlTx, lg: db.lg});
+	result, err = cb(ctx, &Tx{mysqlTx: mysqlTx, lg: db.lg});
 	if err != nil {
 		if rbErr := mysqlTx.Rollback(); rbErr != nil {
 			return nil, errors.Wrap(err, rbErr.Error())
@@ -74,6 +92,11 @@ func (db *Database) ReadWrite(ctx context.Context, cb db.TxCallback) (interface{
 	return result, nil
 }
 
+// store - the transaction for the repositories shared by the SQL backends
+func (tx *Tx) store() *sqlstore.Tx {
+	return sqlstore.NewTx(tx.mysqlTx, sqlstore.MySQL8)
+}
+
 func (tx *Tx) Entities() db.EntityRepository {
 	return &EntityRepository{Tx: tx}
 }
@@ -87,5 +110,37 @@ func (tx *Tx) Microservices() db.MicroserviceRepository {
 }
 
 func (tx *Tx) Actions() db.ActionRepository {
-	return &ActionRepository{Tx: tx}
+	return &ActionRepository{Tx: tx, ActionRepository: &sqlstore.ActionRepository{Tx: tx.store()}}
+}
+
+func (tx *Tx) Deltas() db.ActionDeltaRepository {
+	return &sqlstore.ActionDeltaRepository{Tx: tx.store()}
+}
+
+func (tx *Tx) APIKeys() db.APIKeyRepository {
+	return &sqlstore.APIKeyRepository{Tx: tx.store()}
+}
+
+func (tx *Tx) SigningSecrets() db.SigningSecretRepository {
+	return &sqlstore.SigningSecretRepository{Tx: tx.store()}
+}
+
+func (tx *Tx) AccessLog() db.AccessLogRepository {
+	return &sqlstore.AccessLogRepository{Tx: tx.store()}
+}
+
+func (tx *Tx) RetentionRules() db.RetentionRuleRepository {
+	return &sqlstore.RetentionRuleRepository{Tx: tx.store()}
+}
+
+func (tx *Tx) ActionPartitions() db.ActionPartitionRepository {
+	return &ActionPartitionRepository{Tx: tx}
+}
+
+func (tx *Tx) Webhooks() db.WebhookRepository {
+	return &sqlstore.WebhookRepository{Tx: tx.store()}
+}
+
+func (tx *Tx) Timeouts() db.TimeoutRepository {
+	return &sqlstore.TimeoutRepository{Tx: tx.store()}
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	sq "github.com/Masterminds/squirrel"
 	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/sqlstore"
 	"github.com/denismitr/auditbase/internal/model"
 	"github.com/doug-martin/goqu/v9"
 	"github.com/jmoiron/sqlx"
@@ -62,29 +63,33 @@ func (r *EntityRepository) Select(
 		return nil, err
 	}
 
-	stmt, err := r.mysqlTx.Preparex(sQ.selectSQL)
+	stmt, err := r.mysqlTx.PreparexContext(ctx, sQ.SelectSQL)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not prepare selectSql to select entities")
 	}
 
-	cntStmt, err := r.mysqlTx.Preparex(sQ.countSQL)
-	if err != nil {
-		return nil, errors.Wrap(err, "could not prepare selectSql to count entities")
-	}
-
 	defer func() { _ = stmt.Close() }()
-	defer func() { _ = cntStmt.Close() }()
 
-	if err := stmt.SelectContext(ctx, &entities, sQ.selectArgs...); err != nil {
+	if err := stmt.SelectContext(ctx, &entities, sQ.SelectArgs...); err != nil {
 		return nil, errors.Wrap(err, "could not execute selectSql to select entities")
 	}
 
-	var cnt int
-	if err := cntStmt.Get(ctx, &cnt); err != nil {
-		return nil, errors.Wrap(err, "could not execute selectSql to count entities")
+	var cnt *int
+	if !cursor.SkipTotal {
+		cntStmt, err := r.mysqlTx.PreparexContext(ctx, sQ.CountSQL)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not prepare selectSql to count entities")
+		}
+
+		defer func() { _ = cntStmt.Close() }()
+
+		cnt = new(int)
+		if err := cntStmt.GetContext(ctx, cnt, sQ.CountArgs...); err != nil {
+			return nil, errors.Wrap(err, "could not execute selectSql to count entities")
+		}
 	}
 
-	return mapEntitiesToCollection(entities, cnt, cursor.Page, cursor.PerPage), nil
+	return mapEntitiesToCollection(entities, cnt, cursor, sQ.Keyed), nil
 }
 
 // MakeNewActions an entities
@@ -223,7 +228,7 @@ func firstEntityByID(ctx context.Context, tx *sqlx.Tx, ID model.ID) (*model.Enti
 	return mapEntityRecordAllJoinedToModel(ent), nil
 }
 
-func selectEntitiesQuery(c *db.Cursor, f *db.Filter) (*selectQuery, error) {
+func selectEntitiesQuery(c *db.Cursor, f *db.Filter) (*sqlstore.SelectQuery, error) {
 	dialect := goqu.Dialect(MySQL8)
 
 	countQ := dialect.Select(goqu.L("count(*)").As("cnt")).From(goqu.I("entities").As("e"))
@@ -241,7 +246,18 @@ func selectEntitiesQuery(c *db.Cursor, f *db.Filter) (*selectQuery, error) {
 		q = q.Where(goqu.I(`e.entity_type_id`).Eq(f.MustInt("entityTypeId")))
 	}
 
+	if f.HasServices() {
+		countQ = countQ.Where(sqlstore.EntityServicesExpression(dialect, f.Services()))
+		q = q.Where(sqlstore.EntityServicesExpression(dialect, f.Services()))
+	}
+
+	sQ := sqlstore.SelectQuery{}
+
 	if c.Sort.Has("externalId") {
+		if c.Keyed() {
+			return nil, errors.Wrap(db.ErrInvalidQueryInput, "entities ordered by external ID cannot follow page tokens")
+		}
+
 		ind := goqu.I("e.external_id")
 		order := c.Sort.GetOrDefault("externalId", db.ASCOrder)
 		if order == db.ASCOrder {
@@ -250,31 +266,31 @@ func selectEntitiesQuery(c *db.Cursor, f *db.Filter) (*selectQuery, error) {
 			q = q.OrderAppend(ind.Desc())
 		}
 	} else {
-		ind := goqu.I("e.updated_at")
+		column, id := goqu.I("e.updated_at"), goqu.I("e.id")
 		order := c.Sort.GetOrDefault("updatedAt", db.DESCOrder)
-		if order == db.DESCOrder {
-			q = q.OrderAppend(ind.Desc())
-		} else {
-			q = q.OrderAppend(ind.Asc())
+
+		if c.Keyed() {
+			q = q.Where(sqlstore.KeysetExpression(column, id, order, c))
 		}
+
+		q = q.Order(sqlstore.KeysetOrder(column, id, order, c)...)
+		sQ.Keyed = true
 	}
 
-	q = q.Limit(c.PerPage)
+	q = q.Limit(c.Limit())
 	q = q.Offset(c.Offset())
-
-	sQ := selectQuery{}
 	if q, args, err := q.Prepared(true).ToSQL(); err != nil {
 		return nil, errors.Wrap(err, "invalid select SQL for entities")
 	} else {
-		sQ.selectSQL = q
-		sQ.selectArgs = args
+		sQ.SelectSQL = q
+		sQ.SelectArgs = args
 	}
 
 	if q, args, err := countQ.Prepared(true).ToSQL(); err != nil {
 		return nil, errors.Wrap(err, "invalid count SQL for entities")
 	} else {
-		sQ.countSQL = q
-		sQ.countArgs = args
+		sQ.CountSQL = q
+		sQ.CountArgs = args
 	}
 
 	return &sQ, nil
@@ -337,3 +353,78 @@ func createEntityQuery(id, entityTypeID model.ID, externalID string) (string, []
 		Values(externalID, sq.Expr("?", int(entityTypeID))).
 		ToSql() // fixme: refactor to goqu
 }
+
+// DeleteOrphans - removes at most limit entities created before the given time,
+// that are referenced by neither actions nor deltas, references are checked again
+// on delete, so that an entity referenced in the meantime is kept
+func (r *EntityRepository) DeleteOrphans(ctx context.Context, before time.Time, limit int) (int, error) {
+	q, args, err := selectOrphanEntitiesQuery(before, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var IDs []int
+	if err := r.mysqlTx.SelectContext(ctx, &IDs, q, args...); err != nil {
+		return 0, errors.Wrap(err, "could not select orphaned entities")
+	}
+
+	if len(IDs) == 0 {
+		return 0, nil
+	}
+
+	q, args, err = deleteOrphanEntitiesQuery(IDs)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := r.mysqlTx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not delete orphaned entities")
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "could not get number of deleted entities")
+	}
+
+	return int(n), nil
+}
+
+// orphanEntityExpression - entity is referenced by neither actions nor deltas
+func orphanEntityExpression() goqu.Expression {
+	dialect := goqu.Dialect(MySQL8)
+	referencedBy := func(table, column string) goqu.Expression {
+		return goqu.L("NOT EXISTS ?", dialect.From(table).
+			Select(goqu.L("1")).
+			Where(goqu.I(table+"."+column).Eq(goqu.I("entities.id"))))
+	}
+
+	return goqu.And(
+		referencedBy("actions", "actor_entity_id"),
+		referencedBy("actions", "target_entity_id"),
+		referencedBy("action_deltas", "entity_id"),
+	)
+}
+
+func selectOrphanEntitiesQuery(before time.Time, limit int) (string, []interface{}, error) {
+	if limit <= 0 {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(MySQL8)
+
+	return dialect.From("entities").
+		Select("id").
+		Where(goqu.I("entities.created_at").Lt(before.UTC()), orphanEntityExpression()).
+		Order(goqu.C("id").Asc()).
+		Limit(uint(limit)).
+		Prepared(true).ToSQL()
+}
+
+func deleteOrphanEntitiesQuery(IDs []int) (string, []interface{}, error) {
+	dialect := goqu.Dialect(MySQL8)
+
+	return dialect.Delete("entities").
+		Where(goqu.I("entities.id").In(IDs), orphanEntityExpression()).
+		Prepared(true).ToSQL()
+}
@@ -16,6 +16,7 @@ func TestSelectEntitiesQuery(t *testing.T) {
 		page         uint
 		selectSql    string
 		sort         *db.Sort
+		services     []string
 		args         []interface{}
 	}{
 		{
@@ -24,8 +25,8 @@ func TestSelectEntitiesQuery(t *testing.T) {
 			perPage:      10,
 			page:         0,
 			selectSql: "SELECT `e`.`id`, `e`.`entity_type_id`, `e`.`external_id`, `e`.`created_at`, `e`.`updated_at` " +
-				"FROM `entities` AS `e` ORDER BY `e`.`updated_at` DESC LIMIT ?",
-			args: []interface{}{int64(10)},
+				"FROM `entities` AS `e` ORDER BY `e`.`updated_at` DESC, `e`.`id` DESC LIMIT ?",
+			args: []interface{}{int64(11)},
 		},
 		{
 			name:         "entityTypeID",
@@ -34,8 +35,8 @@ func TestSelectEntitiesQuery(t *testing.T) {
 			page:         0,
 			selectSql: "SELECT `e`.`id`, `e`.`entity_type_id`, `e`.`external_id`, `e`.`created_at`, `e`.`updated_at` " +
 				"FROM `entities` AS `e` WHERE (`e`.`entity_type_id` = ?) " +
-				"ORDER BY `e`.`updated_at` DESC LIMIT ?",
-			args: []interface{}{"124", int64(10)},
+				"ORDER BY `e`.`updated_at` DESC, `e`.`id` DESC LIMIT ?",
+			args: []interface{}{"124", int64(11)},
 		},
 		{
 			name:         "order-external-id",
@@ -46,7 +47,7 @@ func TestSelectEntitiesQuery(t *testing.T) {
 			selectSql:    "SELECT `e`.`id`, `e`.`entity_type_id`, `e`.`external_id`, `e`.`created_at`, `e`.`updated_at` " +
 				"FROM `entities` AS `e` WHERE (`e`.`entity_type_id` = ?) " +
 				"ORDER BY `e`.`external_id` DESC LIMIT ?",
-			args:         []interface{}{"124", int64(18)},
+			args:         []interface{}{"124", int64(19)},
 		},
 		{
 			name:         "order-name-pagination",
@@ -54,8 +55,19 @@ func TestSelectEntitiesQuery(t *testing.T) {
 			perPage:      25,
 			page:         3,
 			selectSql:    "SELECT `e`.`id`, `e`.`entity_type_id`, `e`.`external_id`, `e`.`created_at`, `e`.`updated_at` " +
-				"FROM `entities` AS `e` ORDER BY `e`.`updated_at` DESC LIMIT ? OFFSET ?",
-			args:         []interface{}{int64(25), int64(50)},
+				"FROM `entities` AS `e` ORDER BY `e`.`updated_at` DESC, `e`.`id` DESC LIMIT ? OFFSET ?",
+			args:         []interface{}{int64(26), int64(50)},
+		},
+		{
+			name:     "services",
+			perPage:  10,
+			page:     0,
+			services: []string{"billing", "orders"},
+			selectSql: "SELECT `e`.`id`, `e`.`entity_type_id`, `e`.`external_id`, `e`.`created_at`, `e`.`updated_at` " +
+				"FROM `entities` AS `e` WHERE `e`.`entity_type_id` IN (SELECT `scope_et`.`id` FROM `entity_types` AS `scope_et` " +
+				"INNER JOIN `microservices` AS `scope_ms` ON (`scope_ms`.`id` = `scope_et`.`service_id`) " +
+				"WHERE (`scope_ms`.`name` IN (?, ?))) ORDER BY `e`.`updated_at` DESC, `e`.`id` DESC LIMIT ?",
+			args: []interface{}{"billing", "orders", int64(11)},
 		},
 	}
 
@@ -63,7 +75,7 @@ func TestSelectEntitiesQuery(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			t.Logf("Testting %s", tc.name)
 
-			c := db.NewCursor(tc.page, tc.perPage, nil, []string{"name"})
+			c := db.NewCursor(tc.page, tc.perPage, []string{"name"})
 			if tc.sort != nil {
 				c.Sort = tc.sort
 			}
@@ -73,19 +85,21 @@ func TestSelectEntitiesQuery(t *testing.T) {
 				f.Add("entityTypeId", strconv.Itoa(int(tc.entityTypeID)))
 			}
 
+			f.ByServices(tc.services)
+
 			selectQuery, err := selectEntitiesQuery(c, f)
 			if !assert.NoError(t, err) {
 				t.Fatalf("unexpected error %s", err.Error())
 			}
 
-			assert.Equal(t, tc.selectSql, selectQuery.selectSQL)
+			assert.Equal(t, tc.selectSql, selectQuery.SelectSQL)
 
-			if !assert.Equal(t, len(tc.args), len(selectQuery.selectArgs)) {
-				t.Fatalf("expected query arguments count %d != actual %d", len(tc.args), len(selectQuery.selectArgs))
+			if !assert.Equal(t, len(tc.args), len(selectQuery.SelectArgs)) {
+				t.Fatalf("expected query arguments count %d != actual %d", len(tc.args), len(selectQuery.SelectArgs))
 			}
 
 			for i := range tc.args {
-				assert.Equal(t, tc.args[i], selectQuery.selectArgs[i])
+				assert.Equal(t, tc.args[i], selectQuery.SelectArgs[i])
 			}
 		})
 	}
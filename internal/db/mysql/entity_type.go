@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	sq "github.com/Masterminds/squirrel"
 	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/sqlstore"
 	"github.com/denismitr/auditbase/internal/model"
 	"github.com/doug-martin/goqu/v9"
 	"github.com/jmoiron/sqlx"
@@ -42,12 +43,12 @@ func (r *EntityTypeRepository) Select(
 		return nil, err
 	}
 
-	stmt, err := r.mysqlTx.Preparex(sQ.selectSQL)
+	stmt, err := r.mysqlTx.Preparex(sQ.SelectSQL)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not prepare selectSql to select entityTypes")
 	}
 
-	cntStmt, err := r.mysqlTx.Preparex(sQ.countSQL)
+	cntStmt, err := r.mysqlTx.Preparex(sQ.CountSQL)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not prepare selectSql to count entityTypes")
 	}
@@ -55,7 +56,7 @@ func (r *EntityTypeRepository) Select(
 	defer func() { _ = stmt.Close() }()
 	defer func() { _ = cntStmt.Close() }()
 
-	if err := stmt.SelectContext(ctx, &entityTypes, sQ.selectArgs...); err != nil {
+	if err := stmt.SelectContext(ctx, &entityTypes, sQ.SelectArgs...); err != nil {
 		switch err {
 		case sql.ErrNoRows:
 			return nil, db.ErrNotFound
@@ -219,7 +220,7 @@ func firstEntityTypeByIDQuery(ID model.ID) (string, []interface{}, error) {
 	return q.Prepared(true).ToSQL()
 }
 
-func selectEntityTypesQuery(c *db.Cursor, f *db.Filter) (*selectQuery, error) {
+func selectEntityTypesQuery(c *db.Cursor, f *db.Filter) (*sqlstore.SelectQuery, error) {
 	countQ := sq.Select("count(*) as cnt").From("entity_types")
 
 	q := sq.Select(
@@ -258,19 +259,19 @@ func selectEntityTypesQuery(c *db.Cursor, f *db.Filter) (*selectQuery, error) {
 		q = q.OrderBy("updated_at ?", db.DESCOrder.String())
 	}
 
-	sQ := selectQuery{}
+	sQ := sqlstore.SelectQuery{}
 	if queryStr, args, err := q.ToSql(); err != nil {
 		return nil, errors.Wrap(err, "invalid select SQL for entities")
 	} else {
-		sQ.selectSQL = queryStr
-		sQ.selectArgs = args
+		sQ.SelectSQL = queryStr
+		sQ.SelectArgs = args
 	}
 
 	if queryStr, args, err := countQ.ToSql(); err != nil {
 		return nil, errors.Wrap(err, "invalid count SQL for entities")
 	} else {
-		sQ.countSQL = queryStr
-		sQ.countArgs = args
+		sQ.CountSQL = queryStr
+		sQ.CountArgs = args
 	}
 
 	return &sQ, nil
@@ -326,3 +327,70 @@ func firstEntityTypeByNameAndServiceIDQuery(name string, serviceID model.ID) (st
 
 	return q.Prepared(true).ToSQL()
 }
+
+// DeleteOrphans - removes at most limit entity types created before the given time,
+// that have no entities, which is checked again on delete
+func (r *EntityTypeRepository) DeleteOrphans(ctx context.Context, before time.Time, limit int) (int, error) {
+	q, args, err := selectOrphanEntityTypesQuery(before, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var IDs []int
+	if err := r.mysqlTx.SelectContext(ctx, &IDs, q, args...); err != nil {
+		return 0, errors.Wrap(err, "could not select orphaned entity types")
+	}
+
+	if len(IDs) == 0 {
+		return 0, nil
+	}
+
+	q, args, err = deleteOrphanEntityTypesQuery(IDs)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := r.mysqlTx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not delete orphaned entity types")
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "could not get number of deleted entity types")
+	}
+
+	return int(n), nil
+}
+
+// orphanEntityTypeExpression - entity type has no entities
+func orphanEntityTypeExpression() goqu.Expression {
+	dialect := goqu.Dialect(MySQL8)
+
+	return goqu.L("NOT EXISTS ?", dialect.From("entities").
+		Select(goqu.L("1")).
+		Where(goqu.I("entities.entity_type_id").Eq(goqu.I("entity_types.id"))))
+}
+
+func selectOrphanEntityTypesQuery(before time.Time, limit int) (string, []interface{}, error) {
+	if limit <= 0 {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(MySQL8)
+
+	return dialect.From("entity_types").
+		Select("id").
+		Where(goqu.I("entity_types.created_at").Lt(before.UTC()), orphanEntityTypeExpression()).
+		Order(goqu.C("id").Asc()).
+		Limit(uint(limit)).
+		Prepared(true).ToSQL()
+}
+
+func deleteOrphanEntityTypesQuery(IDs []int) (string, []interface{}, error) {
+	dialect := goqu.Dialect(MySQL8)
+
+	return dialect.Delete("entity_types").
+		Where(goqu.I("entity_types.id").In(IDs), orphanEntityTypeExpression()).
+		Prepared(true).ToSQL()
+}
@@ -3,18 +3,30 @@ package mysql
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/denismitr/auditbase/internal/db"
 	"github.com/denismitr/auditbase/internal/model"
 )
 
-func mapEntitiesToCollection(items []entityRecord, cnt int, page, perPage uint) *model.EntityCollection {
+// mapEntitiesToCollection - total is nil when it was not counted, tokens of the neighbouring pages
+// are only handed out when the entities are keyed by update time
+func mapEntitiesToCollection(items []entityRecord, total *int, c *db.Cursor, keyed bool) *model.EntityCollection {
 	result := model.EntityCollection{}
-	for _, e := range items {
+
+	var keyOf func(i int) db.Keyset
+	if keyed {
+		keyOf = func(i int) db.Keyset {
+			return db.Keyset{At: items[i].UpdatedAt, ID: model.ID(items[i].ID)}
+		}
+	}
+
+	n := c.Paginate(&result.Meta, len(items), keyOf, func(i, j int) { items[i], items[j] = items[j], items[i] })
+	for _, e := range items[:n] {
 		result.Items = append(result.Items, *mapEntityRecordToModel(e))
 	}
 
-	result.Meta.Total = cnt
-	result.Meta.Page = int(page)
-	result.Meta.PerPage = int(perPage)
+	result.Meta.Total = total
+	result.Meta.Page = int(c.Page)
+	result.Meta.PerPage = int(c.PerPage)
 
 	return &result
 }
@@ -72,22 +84,33 @@ func mapEntityTypesToCollection(items []entityTypeRecord, cnt int, page, perPage
 		result.Items = append(result.Items, *mapEntityTypeRecordToModel(e))
 	}
 
-	result.Meta.Total = cnt
+	result.Meta.Total = &cnt
 	result.Meta.Page = int(page)
 	result.Meta.PerPage = int(perPage)
 
 	return &result
 }
 
-func mapActionRecordsToCollection(items []actionRecord, total int, page uint, perPage uint) *model.ActionCollection {
+// mapActionRecordsToCollection - total is nil when it was not counted, tokens of the neighbouring pages
+// are only handed out when the actions are keyed by registration time
+func mapActionRecordsToCollection(items []actionRecord, total *int, c *db.Cursor, keyed bool) *model.ActionCollection {
 	result := model.ActionCollection{}
-	for _, a := range items {
+
+	var keyOf func(i int) db.Keyset
+	if keyed {
+		keyOf = func(i int) db.Keyset {
+			return db.Keyset{At: items[i].RegisteredAt, ID: model.ID(items[i].ID)}
+		}
+	}
+
+	n := c.Paginate(&result.Meta, len(items), keyOf, func(i, j int) { items[i], items[j] = items[j], items[i] })
+	for _, a := range items[:n] {
 		result.Items = append(result.Items, *mapActionRecordToModel(a))
 	}
 
 	result.Meta.Total = total
-	result.Meta.Page = int(page)
-	result.Meta.PerPage = int(perPage)
+	result.Meta.Page = int(c.Page)
+	result.Meta.PerPage = int(c.PerPage)
 
 	return &result
 }
@@ -108,6 +131,14 @@ func mapActionRecordToModel(ar actionRecord) *model.Action {
 		a.ParentUID = model.UID(ar.ParentUID.String)
 	}
 
+	if ar.PrevChainHash.Valid {
+		a.PrevChainHash = ar.PrevChainHash.String
+	}
+
+	if ar.ChainHash.Valid {
+		a.ChainHash = ar.ChainHash.String
+	}
+
 	if ar.Details.Valid {
 		if err := json.Unmarshal([]byte(ar.Details.String), &a.Details); err != nil {
 			panic(fmt.Sprintf("how could we not unmarshal details of retrieved action [%d]: %v?", ar.ID, err))
@@ -88,7 +88,8 @@ func (r *MicroserviceRepository) SelectAll(ctx context.Context) (*model.Microser
 		})
 	}
 
-	result.Meta.Total = len(result.Items)
+	total := len(result.Items)
+	result.Meta.Total = &total
 	result.Meta.Page = 1
 	result.Meta.PerPage = 10000
 
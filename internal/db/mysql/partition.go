@@ -0,0 +1,205 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/sqlstore"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/pkg/errors"
+)
+
+var monthPartitionName = regexp.MustCompile(`^p[0-9]{6}$`)
+
+type actionPartitionRecord struct {
+	Name        sql.NullString `db:"name"`
+	Description sql.NullString `db:"description"`
+	Rows        sql.NullInt64  `db:"rows"`
+}
+
+// ActionPartitionRepository - actions are partitioned by month of registered_at,
+// every partition statement commits the transaction it runs in, as any DDL does in MySQL
+type ActionPartitionRepository struct {
+	*Tx
+}
+
+var _ db.ActionPartitionRepository = (*ActionPartitionRepository)(nil)
+
+// Select - partitions of the actions table, rows are an estimate
+func (r *ActionPartitionRepository) Select(ctx context.Context) ([]model.ActionPartition, error) {
+	q, args, err := selectActionPartitionsQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []actionPartitionRecord
+	if err := r.mysqlTx.SelectContext(ctx, &records, q, args...); err != nil {
+		return nil, errors.Wrap(err, "could not select action partitions")
+	}
+
+	return mapActionPartitionRecordsToModels(records)
+}
+
+// Create - splits the month partitions off the future one, actions already in it are moved
+func (r *ActionPartitionRepository) Create(ctx context.Context, partitions []model.ActionPartition) error {
+	if len(partitions) == 0 {
+		return nil
+	}
+
+	q, err := reorganizeFutureActionPartitionQuery(partitions)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.mysqlTx.ExecContext(ctx, q); err != nil {
+		return errors.Wrap(err, "could not create action partitions")
+	}
+
+	return nil
+}
+
+// Entomb - stores the tombstones, tombstones stored by an earlier interrupted run are kept,
+// and removes the deltas and keys of their actions
+func (r *ActionPartitionRepository) Entomb(ctx context.Context, tombstones []model.ActionTombstone) error {
+	if len(tombstones) == 0 {
+		return nil
+	}
+
+	q, args, err := entombActionsQuery(tombstones)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.mysqlTx.ExecContext(ctx, q, args...); err != nil {
+		return errors.Wrap(err, "could not insert action tombstones")
+	}
+
+	IDs := make([]int, len(tombstones))
+	for i := range tombstones {
+		IDs[i] = int(tombstones[i].ActionID)
+	}
+
+	return r.store().DeleteActionDependents(ctx, IDs)
+}
+
+// Drop - removes the month partition with all its actions, the actions are locked against writes
+// while it is checked that every one of them has a tombstone, so that the ones stored after
+// the partition was entombed are not dropped without one
+func (r *ActionPartitionRepository) Drop(ctx context.Context, name string) (err error) {
+	if !monthPartitionName.MatchString(name) {
+		return errors.Wrapf(db.ErrInvalidQueryInput, "%s is not a month partition", name)
+	}
+
+	if _, err := r.mysqlTx.ExecContext(ctx, "LOCK TABLES actions WRITE, action_tombstones READ"); err != nil {
+		return errors.Wrapf(err, "could not lock actions of partition %s", name)
+	}
+
+	defer func() {
+		// the connection goes back to the pool afterwards, so the tables are unlocked even when ctx is done
+		if _, unlockErr := r.mysqlTx.ExecContext(context.Background(), "UNLOCK TABLES"); unlockErr != nil && err == nil {
+			err = errors.Wrapf(unlockErr, "could not unlock actions of partition %s", name)
+		}
+	}()
+
+	var untombed int
+	if err := r.mysqlTx.GetContext(ctx, &untombed, countUntombedActionsQuery(name)); err != nil {
+		return errors.Wrapf(err, "could not count actions of partition %s without tombstones", name)
+	}
+
+	if untombed > 0 {
+		return errors.Wrapf(db.ErrPartitionNotEntombed, "%d actions of partition %s", untombed, name)
+	}
+
+	if _, err := r.mysqlTx.ExecContext(ctx, "ALTER TABLE actions DROP PARTITION "+name); err != nil {
+		return errors.Wrapf(err, "could not drop action partition %s", name)
+	}
+
+	return nil
+}
+
+// countUntombedActionsQuery - tables are referred to without aliases, since only
+// the tables themselves are locked, the name is checked to be a month partition already
+func countUntombedActionsQuery(name string) string {
+	return "SELECT COUNT(*) FROM actions PARTITION (" + name + ") " +
+		"WHERE NOT EXISTS (SELECT 1 FROM action_tombstones WHERE action_tombstones.action_id = actions.id)"
+}
+
+func entombActionsQuery(tombstones []model.ActionTombstone) (string, []interface{}, error) {
+	rows, err := sqlstore.ActionTombstoneRows(tombstones)
+	if err != nil {
+		return "", nil, err
+	}
+
+	dialect := goqu.Dialect(MySQL8)
+
+	return dialect.Insert("action_tombstones").Rows(rows...).
+		OnConflict(goqu.DoNothing()).
+		Prepared(true).ToSQL()
+}
+
+func selectActionPartitionsQuery() (string, []interface{}, error) {
+	dialect := goqu.Dialect(MySQL8)
+
+	return dialect.From(goqu.S("information_schema").Table("PARTITIONS")).
+		Select(
+			goqu.C("PARTITION_NAME").As("name"),
+			goqu.C("PARTITION_DESCRIPTION").As("description"),
+			goqu.C("TABLE_ROWS").As("rows"),
+		).
+		Where(
+			goqu.C("TABLE_SCHEMA").Eq(goqu.L("DATABASE()")),
+			goqu.C("TABLE_NAME").Eq("actions"),
+		).
+		Order(goqu.C("PARTITION_ORDINAL_POSITION").Asc()).
+		Prepared(true).ToSQL()
+}
+
+// reorganizeFutureActionPartitionQuery - bounds are unix timestamps, since the table
+// is partitioned by UNIX_TIMESTAMP(registered_at), the only function allowed on a TIMESTAMP column
+func reorganizeFutureActionPartitionQuery(partitions []model.ActionPartition) (string, error) {
+	var sb strings.Builder
+	sb.WriteString("ALTER TABLE actions REORGANIZE PARTITION " + model.FutureActionPartition + " INTO (")
+
+	for _, p := range partitions {
+		if !monthPartitionName.MatchString(p.Name) || !p.Bounded() {
+			return "", errors.Wrapf(db.ErrInvalidQueryInput, "%s is not a month partition", p.Name)
+		}
+
+		sb.WriteString("PARTITION " + p.Name + " VALUES LESS THAN (" + strconv.FormatInt(p.Until.Unix(), 10) + "), ")
+	}
+
+	sb.WriteString("PARTITION " + model.FutureActionPartition + " VALUES LESS THAN MAXVALUE)")
+
+	return sb.String(), nil
+}
+
+func mapActionPartitionRecordsToModels(records []actionPartitionRecord) ([]model.ActionPartition, error) {
+	// a table that is not partitioned has a single row without a name
+	if len(records) == 0 || !records[0].Name.Valid {
+		return nil, db.ErrNotPartitioned
+	}
+
+	partitions := make([]model.ActionPartition, len(records))
+	for i, r := range records {
+		partitions[i] = model.ActionPartition{Name: r.Name.String, Rows: r.Rows.Int64}
+
+		if r.Description.String == "MAXVALUE" {
+			continue
+		}
+
+		until, err := strconv.ParseInt(r.Description.String, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not parse bound of action partition %s", r.Name.String)
+		}
+
+		partitions[i].Until = time.Unix(until, 0).UTC()
+	}
+
+	return partitions, nil
+}
@@ -0,0 +1,95 @@
+package mysql
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_actionPartitionQueries(t *testing.T) {
+	t.Run("reorganize future partition", func(t *testing.T) {
+		q, err := reorganizeFutureActionPartitionQuery([]model.ActionPartition{
+			{Name: "p202111", Until: time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)},
+			{Name: "p202112", Until: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "ALTER TABLE actions REORGANIZE PARTITION p_future INTO ("+
+			"PARTITION p202111 VALUES LESS THAN (1638316800), "+
+			"PARTITION p202112 VALUES LESS THAN (1640995200), "+
+			"PARTITION p_future VALUES LESS THAN MAXVALUE)", q)
+	})
+
+	t.Run("only month partitions are created", func(t *testing.T) {
+		_, err := reorganizeFutureActionPartitionQuery([]model.ActionPartition{
+			{Name: "p202111; DROP TABLE actions", Until: time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)},
+		})
+
+		assert.Equal(t, db.ErrInvalidQueryInput, errors.Cause(err))
+	})
+
+	t.Run("select partitions", func(t *testing.T) {
+		q, args, err := selectActionPartitionsQuery()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "SELECT `PARTITION_NAME` AS `name`, `PARTITION_DESCRIPTION` AS `description`, "+
+			"`TABLE_ROWS` AS `rows` FROM `information_schema`.`PARTITIONS` "+
+			"WHERE ((`TABLE_SCHEMA` = DATABASE()) AND (`TABLE_NAME` = ?)) "+
+			"ORDER BY `PARTITION_ORDINAL_POSITION` ASC", q)
+		assert.Equal(t, []interface{}{"actions"}, args)
+	})
+
+	t.Run("count actions without tombstones", func(t *testing.T) {
+		assert.Equal(t, "SELECT COUNT(*) FROM actions PARTITION (p202111) "+
+			"WHERE NOT EXISTS (SELECT 1 FROM action_tombstones WHERE action_tombstones.action_id = actions.id)",
+			countUntombedActionsQuery("p202111"))
+	})
+
+	t.Run("entomb ignores stored tombstones", func(t *testing.T) {
+		q, _, err := entombActionsQuery([]model.ActionTombstone{{ActionID: 7, UID: "a", Name: "foo"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Contains(t, q, "INSERT IGNORE INTO `action_tombstones`")
+	})
+}
+
+func Test_mapActionPartitionRecordsToModels(t *testing.T) {
+	t.Run("partitioned", func(t *testing.T) {
+		partitions, err := mapActionPartitionRecordsToModels([]actionPartitionRecord{
+			{
+				Name:        sql.NullString{String: "p202111", Valid: true},
+				Description: sql.NullString{String: "1638316800", Valid: true},
+				Rows:        sql.NullInt64{Int64: 42, Valid: true},
+			},
+			{
+				Name:        sql.NullString{String: "p_future", Valid: true},
+				Description: sql.NullString{String: "MAXVALUE", Valid: true},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []model.ActionPartition{
+			{Name: "p202111", Until: time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC), Rows: 42},
+			{Name: "p_future"},
+		}, partitions)
+	})
+
+	t.Run("not partitioned", func(t *testing.T) {
+		_, err := mapActionPartitionRecordsToModels([]actionPartitionRecord{{Rows: sql.NullInt64{Int64: 5, Valid: true}}})
+
+		assert.Equal(t, db.ErrNotPartitioned, err)
+	})
+}
@@ -3,6 +3,7 @@ package mysql
 import (
 	"context"
 	"database/sql"
+	"github.com/denismitr/auditbase/internal/model"
 	"github.com/denismitr/auditbase/internal/utils/logger"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
@@ -26,6 +27,30 @@ func Migrator(conn *sqlx.DB, lg logger.Logger) *SQLMigrator {
 	}
 
 	m.up["001_initial"] = []string{microservicesSchema, entityTypesSchema, entitiesSchema, actionsSchema}
+	m.up["002_action_deltas"] = []string{actionDeltasSchema}
+	m.up["003_action_chain"] = []string{actionsChainColumns, actionChainSchema, actionChainGenesis}
+	m.up["004_api_keys"] = []string{apiKeysSchema, apiKeyServicesSchema}
+	m.up["005_microservice_secrets"] = []string{microserviceSecretsSchema}
+	m.up["006_access_log"] = []string{
+		accessLogSchema,
+		accessLogRecordsSchema,
+		appendOnlyTrigger("access_log_no_update", "UPDATE", "access_log"),
+		appendOnlyTrigger("access_log_no_delete", "DELETE", "access_log"),
+		appendOnlyTrigger("access_log_records_no_update", "UPDATE", "access_log_records"),
+		appendOnlyTrigger("access_log_records_no_delete", "DELETE", "access_log_records"),
+	}
+	m.up["007_retention"] = []string{retentionRulesSchema, actionTombstonesSchema}
+	m.up["008_actions_partitioning"] = []string{
+		actionKeysSchema,
+		actionKeysBackfill,
+		actionDeltasDropActionForeignKey,
+		actionsPartitioningKeys,
+		actionsPartitioning,
+	}
+	m.up["009_action_search"] = []string{actionTextsSchema, actionTextsBackfill, actionDeltasPropertyIndex}
+	m.up["010_action_stats"] = []string{actionsRegisteredAtIndex}
+	m.up["011_webhooks"] = []string{webhookRulesSchema, webhookDeliveriesSchema}
+	m.up["012_action_timeouts"] = []string{timeoutRulesSchema, timeoutSweepsSchema, actionTimeoutsSchema, actionsStatusIndex}
 
 	return m
 }
@@ -115,9 +140,353 @@ const entitiesSchema = `
 	) ENGINE=INNODB;
 `
 
+const actionDeltasSchema = `
+	CREATE TABLE IF NOT EXISTS action_deltas (
+		id BIGINT UNSIGNED AUTO_INCREMENT,
+		action_id BIGINT UNSIGNED NOT NULL,
+		entity_id BIGINT UNSIGNED,
+		property_name VARCHAR(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci NOT NULL,
+		property_type VARCHAR(16) NOT NULL,
+		from_value JSON,
+		to_value JSON,
+
+		PRIMARY KEY (id),
+
+		INDEX entity_property_idx (entity_id, property_name),
+
+		FOREIGN KEY (action_id)
+		REFERENCES actions(id)
+		ON DELETE CASCADE,
+
+		FOREIGN KEY (entity_id)
+		REFERENCES entities(id)
+		ON DELETE CASCADE
+	) ENGINE=INNODB;
+`
+
+const actionsChainColumns = `
+	ALTER TABLE actions
+		ADD COLUMN prev_chain_hash CHAR(64) NULL,
+		ADD COLUMN chain_hash CHAR(64) NULL
+`
+
+// actionChainSchema - single row table holding the head of the action hash chain,
+// the row is locked by every action insert, so the chain never forks
+const actionChainSchema = `
+	CREATE TABLE IF NOT EXISTS action_chain (
+		id TINYINT UNSIGNED NOT NULL,
+		last_hash CHAR(64) NOT NULL,
+
+		PRIMARY KEY (id)
+	) ENGINE=INNODB;
+`
+
+const actionChainGenesis = "INSERT IGNORE INTO action_chain (id, last_hash) VALUES (1, '" + model.GenesisChainHash + "')"
+
+const apiKeysSchema = `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id BIGINT UNSIGNED AUTO_INCREMENT,
+		name VARCHAR(64) NOT NULL,
+		prefix CHAR(8) NOT NULL,
+		key_hash CHAR(64) NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		rotated_at TIMESTAMP NULL,
+		revoked_at TIMESTAMP NULL,
+
+		UNIQUE KEY unique_key_hash (key_hash),
+
+		PRIMARY KEY (id)
+	) ENGINE=INNODB;
+`
+
+const apiKeyServicesSchema = `
+	CREATE TABLE IF NOT EXISTS api_key_services (
+		api_key_id BIGINT UNSIGNED NOT NULL,
+		microservice_id BIGINT UNSIGNED NOT NULL,
+
+		PRIMARY KEY (api_key_id, microservice_id),
+
+		FOREIGN KEY (api_key_id)
+		REFERENCES api_keys(id)
+		ON DELETE CASCADE,
+
+		FOREIGN KEY (microservice_id)
+		REFERENCES microservices(id)
+		ON DELETE CASCADE
+	) ENGINE=INNODB;
+`
+
+// microserviceSecretsSchema - shared secrets microservices sign action submissions with,
+// kept apart from microservices, so that they never leak through the microservices API
+const microserviceSecretsSchema = `
+	CREATE TABLE IF NOT EXISTS microservice_secrets (
+		microservice_id BIGINT UNSIGNED NOT NULL,
+		secret VARCHAR(64) NOT NULL,
+		previous_secret VARCHAR(64) NULL,
+		previous_expires_at TIMESTAMP NULL,
+		rotated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+		PRIMARY KEY (microservice_id),
+
+		FOREIGN KEY (microservice_id)
+		REFERENCES microservices(id)
+		ON DELETE CASCADE
+	) ENGINE=INNODB;
+`
+
+// accessLogSchema - who read what through the back-office API
+const accessLogSchema = `
+	CREATE TABLE IF NOT EXISTS access_log (
+		id BIGINT UNSIGNED AUTO_INCREMENT,
+		principal VARCHAR(64) NOT NULL,
+		role VARCHAR(16) NOT NULL DEFAULT '',
+		method VARCHAR(8) NOT NULL,
+		route VARCHAR(128) NOT NULL,
+		path VARCHAR(255) NOT NULL,
+		filters TEXT NULL,
+		status SMALLINT UNSIGNED NOT NULL,
+		requested_at TIMESTAMP NOT NULL,
+
+		INDEX access_log_principal_idx (principal),
+
+		PRIMARY KEY (id)
+	) ENGINE=INNODB;
+`
+
+const accessLogRecordsSchema = `
+	CREATE TABLE IF NOT EXISTS access_log_records (
+		access_log_id BIGINT UNSIGNED NOT NULL,
+		record_type VARCHAR(16) NOT NULL,
+		record_id BIGINT UNSIGNED NOT NULL,
+
+		PRIMARY KEY (access_log_id, record_type, record_id),
+		INDEX access_log_records_record_idx (record_type, record_id),
+
+		FOREIGN KEY (access_log_id)
+		REFERENCES access_log(id)
+	) ENGINE=INNODB;
+`
+
+// appendOnlyTrigger - rejects the event on the table, so that its rows can be neither changed nor removed
+func appendOnlyTrigger(name, event, table string) string {
+	return "CREATE TRIGGER " + name + " BEFORE " + event + " ON " + table +
+		" FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = '" + table + " is append-only'"
+}
+
+// retentionRulesSchema - how long actions are kept, an empty service or action name matches any
+const retentionRulesSchema = `
+	CREATE TABLE IF NOT EXISTS retention_rules (
+		id BIGINT UNSIGNED AUTO_INCREMENT,
+		service VARCHAR(36) NOT NULL DEFAULT '',
+		action_name VARCHAR(36) NOT NULL DEFAULT '',
+		keep_days INT UNSIGNED NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+		UNIQUE KEY unique_service_and_action_name (service, action_name),
+
+		PRIMARY KEY (id)
+	) ENGINE=INNODB;
+`
+
+// actionTombstonesSchema - what is left of the actions removed by retention,
+// their chain hashes keep the hash chain verifiable
+const actionTombstonesSchema = `
+	CREATE TABLE IF NOT EXISTS action_tombstones (
+		action_id BIGINT UNSIGNED NOT NULL,
+		uid VARCHAR(32) NOT NULL,
+		name VARCHAR(36) NOT NULL,
+		prev_chain_hash CHAR(64) NULL,
+		chain_hash CHAR(64) NULL,
+		rule_id BIGINT UNSIGNED NULL,
+		pruned_at TIMESTAMP NOT NULL,
+
+		PRIMARY KEY (action_id)
+	) ENGINE=INNODB;
+`
+
+// actionKeysSchema - keeps uid and hash of actions unique, the partitioned actions table
+// can only have unique keys that include registered_at
+const actionKeysSchema = `
+	CREATE TABLE IF NOT EXISTS action_keys (
+		action_id BIGINT UNSIGNED NOT NULL,
+		uid VARCHAR(32) NOT NULL,
+		hash BINARY(32),
+
+		PRIMARY KEY (action_id),
+		UNIQUE KEY action_keys_uid_key (uid),
+		UNIQUE KEY action_keys_hash_key (hash)
+	) ENGINE=INNODB;
+`
+
+const actionKeysBackfill = "INSERT INTO action_keys (action_id, uid, hash) SELECT id, uid, hash FROM actions"
+
+// actionDeltasDropActionForeignKey - a partitioned table can be neither referenced by nor refer to
+// foreign keys, deltas and keys of actions are removed along with them by the repositories
+const actionDeltasDropActionForeignKey = "ALTER TABLE action_deltas DROP FOREIGN KEY action_deltas_ibfk_1"
+
+// actionsPartitioningKeys - every unique key of a partitioned table, the primary one included,
+// has to contain the column it is partitioned by
+const actionsPartitioningKeys = `
+	ALTER TABLE actions
+		DROP FOREIGN KEY actions_ibfk_1,
+		DROP FOREIGN KEY actions_ibfk_2,
+		DROP INDEX hash_key,
+		DROP INDEX uuid_key,
+		ADD INDEX uid_idx (uid),
+		ADD INDEX hash_idx (hash),
+		DROP PRIMARY KEY,
+		ADD PRIMARY KEY (id, registered_at)
+`
+
+// actionsPartitioning - partitions by month of registration, every action lands in the future
+// partition until the month partitions are split off it by the partition maintenance
+const actionsPartitioning = `
+	ALTER TABLE actions
+		PARTITION BY RANGE (UNIX_TIMESTAMP(registered_at)) (
+			PARTITION ` + model.FutureActionPartition + ` VALUES LESS THAN MAXVALUE
+		)
+`
+
+// actionTextsSchema - details of actions as text, words of searches are looked up in its FULLTEXT index,
+// which the partitioned actions table cannot have
+const actionTextsSchema = `
+	CREATE TABLE IF NOT EXISTS action_texts (
+		action_id BIGINT UNSIGNED NOT NULL,
+		body MEDIUMTEXT NOT NULL,
+
+		PRIMARY KEY (action_id),
+		FULLTEXT INDEX action_texts_body_idx (body)
+	) ENGINE=INNODB;
+`
+
+const actionTextsBackfill = `
+	INSERT INTO action_texts (action_id, body)
+	SELECT id, CAST(details AS CHAR) FROM actions WHERE details IS NOT NULL
+`
+
+const actionDeltasPropertyIndex = "ALTER TABLE action_deltas ADD INDEX property_idx (property_name)"
+
+const actionsRegisteredAtIndex = "ALTER TABLE actions ADD INDEX registered_at_idx (registered_at)"
+
+// webhookRulesSchema - rules webhooks about actions are posted by, an empty criterion matches any
+const webhookRulesSchema = `
+	CREATE TABLE IF NOT EXISTS webhook_rules (
+		id BIGINT UNSIGNED AUTO_INCREMENT,
+		name VARCHAR(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci NOT NULL,
+		url VARCHAR(2048) NOT NULL,
+		secret VARCHAR(64) NOT NULL,
+		service VARCHAR(36) NOT NULL DEFAULT '',
+		entity_type VARCHAR(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+		name_pattern VARCHAR(36) NOT NULL DEFAULT '',
+		status TINYINT UNSIGNED,
+		details_condition TEXT CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci NOT NULL,
+		enabled TINYINT (1) NOT NULL DEFAULT 1,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+		PRIMARY KEY (id)
+	) ENGINE=INNODB;
+`
+
+// webhookDeliveriesSchema - webhooks posted or to be posted, a rule is delivered
+// at most once for every status an action reaches
+const webhookDeliveriesSchema = `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id BIGINT UNSIGNED AUTO_INCREMENT,
+		rule_id BIGINT UNSIGNED NOT NULL,
+		action_id BIGINT UNSIGNED NOT NULL,
+		action_status TINYINT UNSIGNED NOT NULL,
+		event VARCHAR(16) NOT NULL,
+		payload MEDIUMTEXT CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci NOT NULL,
+		state VARCHAR(16) NOT NULL,
+		attempts INT UNSIGNED NOT NULL DEFAULT 0,
+		response_code INT UNSIGNED NOT NULL DEFAULT 0,
+		last_error VARCHAR(1024) CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci NOT NULL DEFAULT '',
+		next_attempt_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		delivered_at TIMESTAMP NULL,
+
+		UNIQUE KEY unique_rule_action_status (rule_id, action_id, action_status),
+		KEY due_idx (state, next_attempt_at),
+
+		PRIMARY KEY (id),
+		FOREIGN KEY (rule_id) REFERENCES webhook_rules (id) ON DELETE CASCADE
+	) ENGINE=INNODB;
+`
+
+// timeoutRulesSchema - how long async actions are given to complete, an empty service or action name matches any
+const timeoutRulesSchema = `
+	CREATE TABLE IF NOT EXISTS timeout_rules (
+		id BIGINT UNSIGNED AUTO_INCREMENT,
+		service VARCHAR(36) NOT NULL DEFAULT '',
+		action_name VARCHAR(36) NOT NULL DEFAULT '',
+		timeout_seconds INT UNSIGNED NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+		UNIQUE KEY unique_service_and_action_name (service, action_name),
+
+		PRIMARY KEY (id)
+	) ENGINE=INNODB;
+`
+
+// timeoutSweepsSchema - runs of the watchdog that timed out actions
+const timeoutSweepsSchema = `
+	CREATE TABLE IF NOT EXISTS timeout_sweeps (
+		id BIGINT UNSIGNED AUTO_INCREMENT,
+		started_at TIMESTAMP NOT NULL,
+		finished_at TIMESTAMP NULL,
+		scanned INT UNSIGNED NOT NULL DEFAULT 0,
+		timed_out INT UNSIGNED NOT NULL DEFAULT 0,
+
+		PRIMARY KEY (id)
+	) ENGINE=INNODB;
+`
+
+// actionTimeoutsSchema - async actions moved to TimedOut, along with the status they were stuck in,
+// the rule is kept as a plain ID, so that deleting the rule leaves the history alone
+const actionTimeoutsSchema = `
+	CREATE TABLE IF NOT EXISTS action_timeouts (
+		id BIGINT UNSIGNED AUTO_INCREMENT,
+		sweep_id BIGINT UNSIGNED NOT NULL,
+		action_id BIGINT UNSIGNED NOT NULL,
+		action_uid VARCHAR(32) NOT NULL,
+		action_name VARCHAR(36) NOT NULL,
+		service VARCHAR(36) NOT NULL DEFAULT '',
+		rule_id BIGINT UNSIGNED NULL,
+		previous_status TINYINT UNSIGNED NOT NULL,
+		registered_at TIMESTAMP NOT NULL,
+		timed_out_at TIMESTAMP NOT NULL,
+
+		KEY sweep_idx (sweep_id),
+
+		PRIMARY KEY (id),
+		FOREIGN KEY (sweep_id) REFERENCES timeout_sweeps (id) ON DELETE CASCADE
+	) ENGINE=INNODB;
+`
+
+// actionsStatusIndex - the watchdog looks for async actions still in progress
+const actionsStatusIndex = "ALTER TABLE actions ADD INDEX status_idx (status)"
+
 const flush = `
 	SET FOREIGN_KEY_CHECKS=0;
 
+	DROP TABLE IF EXISTS action_timeouts;
+	DROP TABLE IF EXISTS timeout_sweeps;
+	DROP TABLE IF EXISTS timeout_rules;
+	DROP TABLE IF EXISTS webhook_deliveries;
+	DROP TABLE IF EXISTS webhook_rules;
+
+	DROP TABLE IF EXISTS action_texts;
+	DROP TABLE IF EXISTS action_keys;
+	DROP TABLE IF EXISTS action_tombstones;
+	DROP TABLE IF EXISTS retention_rules;
+	DROP TABLE IF EXISTS access_log_records;
+	DROP TABLE IF EXISTS access_log;
+	DROP TABLE IF EXISTS microservice_secrets;
+	DROP TABLE IF EXISTS api_key_services;
+	DROP TABLE IF EXISTS api_keys;
+	DROP TABLE IF EXISTS action_chain;
+	DROP TABLE IF EXISTS action_deltas;
 	DROP TABLE IF EXISTS microservices;
 	DROP TABLE IF EXISTS actions; 
 
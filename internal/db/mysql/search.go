@@ -0,0 +1,85 @@
+package mysql
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/denismitr/auditbase/internal/db/sqlstore"
+	"github.com/denismitr/auditbase/internal/search"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+)
+
+// searchExpressions - expressions of the conditions and the words of the search query,
+// words are phrases looked up in the FULLTEXT index of action_texts
+func searchExpressions(dialect goqu.DialectWrapper, q *search.Query) []exp.Expression {
+	var result []exp.Expression
+
+	for _, c := range q.Conditions {
+		if c.Source == search.Details {
+			result = append(result, jsonComparison(goqu.L("JSON_EXTRACT(?, ?)", goqu.I("actions.details"), jsonPath(c.Path)), c))
+			continue
+		}
+
+		column := "from_value"
+		if c.Source == search.DeltaTo {
+			column = "to_value"
+		}
+
+		deltas := dialect.From("action_deltas").
+			Select("action_id").
+			Where(goqu.C("property_name").Eq(c.Property()), jsonComparison(goqu.L("JSON_EXTRACT(?, '$')", goqu.C(column)), c))
+
+		result = append(result, sqlstore.InSubquery(goqu.I("actions.id"), deltas))
+	}
+
+	for _, w := range q.Words {
+		texts := dialect.From("action_texts").
+			Select("action_id").
+			Where(goqu.L("MATCH(?) AGAINST(? IN BOOLEAN MODE)", goqu.C("body"), `"`+strings.Replace(w, `"`, " ", -1)+`"`))
+
+		result = append(result, sqlstore.InSubquery(goqu.I("actions.id"), texts))
+	}
+
+	return result
+}
+
+// jsonComparison - comparison of the JSON value with the value of the condition, JSON null and
+// missing values both equal null, only numbers are ordered
+func jsonComparison(value exp.Expression, c search.Condition) exp.Expression {
+	if c.Value == nil {
+		if c.Operator == search.Neq {
+			return goqu.L("COALESCE(JSON_TYPE(?), 'NULL') <> 'NULL'", value)
+		}
+
+		return goqu.L("COALESCE(JSON_TYPE(?), 'NULL') = 'NULL'", value)
+	}
+
+	switch c.Operator {
+	case search.Eq:
+		return goqu.L("? = CAST(? AS JSON)", value, encodeSearchValue(c.Value))
+	case search.Neq:
+		return goqu.L("(JSON_TYPE(?) <> 'NULL' AND ? <> CAST(? AS JSON))", value, value, encodeSearchValue(c.Value))
+	default:
+		return goqu.L(
+			"(JSON_TYPE(?) IN ('INTEGER', 'UNSIGNED INTEGER', 'DOUBLE', 'DECIMAL') AND ? "+string(c.Operator)+" CAST(? AS JSON))",
+			value,
+			value,
+			encodeSearchValue(c.Value),
+		)
+	}
+}
+
+// jsonPath - path of the keys within a JSON document, keys are quoted, so they may contain dashes
+func jsonPath(keys []string) string {
+	return `$."` + strings.Join(keys, `"."`) + `"`
+}
+
+func encodeSearchValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic("how could a search value fail to encode")
+	}
+
+	return string(b)
+}
@@ -0,0 +1,201 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/search"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createActionQuery(t *testing.T) {
+	emitted, err := time.Parse(model.DefaultTimeFormat, "2021-02-23 16:51:35")
+	if err != nil {
+		panic(err)
+	}
+
+	t.Run("without details", func(t *testing.T) {
+		q, args, err := createActionQuery(&model.Action{
+			UID:           "23a02edbf207452eae7ec258271ee92d",
+			ParentUID:     "69502edbf207452eae7ec258271ee98c",
+			Name:          "foo-bar",
+			Hash:          "ab12",
+			Status:        model.Processing,
+			EmittedAt:     model.JSONTime{Time: emitted},
+			PrevChainHash: model.GenesisChainHash,
+			ChainHash:     "5f2c",
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, `INSERT INTO "actions" ("actor_entity_id", "chain_hash", "emitted_at", "hash", "is_async", "name", "parent_uid", `+
+			`"prev_chain_hash", "registered_at", "status", "target_entity_id", "uid") `+
+			`VALUES ($1, $2, $3, decode($4, 'hex'), $5, $6, $7, $8, $9, $10, $11, $12) RETURNING "id"`, q)
+		assert.Len(t, args, 12)
+		assert.Nil(t, args[0])
+		assert.Equal(t, "5f2c", args[1])
+		assert.Equal(t, "ab12", args[3])
+		assert.Equal(t, model.GenesisChainHash, args[7])
+	})
+
+	t.Run("with details and entities", func(t *testing.T) {
+		q, args, err := createActionQuery(&model.Action{
+			UID:            "23a02edbf207452eae7ec258271ee92d",
+			ActorEntityID:  11,
+			TargetEntityID: 12,
+			Name:           "foo-bar-2",
+			Hash:           "ab12",
+			Status:         model.Failed,
+			EmittedAt:      model.JSONTime{Time: emitted},
+			Details:        map[string]string{"foo": "bar"},
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, `INSERT INTO "actions" ("actor_entity_id", "chain_hash", "details", "emitted_at", "hash", "is_async", "name", "parent_uid", `+
+			`"prev_chain_hash", "registered_at", "status", "target_entity_id", "uid") `+
+			`VALUES ($1, $2, $3::jsonb, $4, decode($5, 'hex'), $6, $7, $8, $9, $10, $11, $12, $13) RETURNING "id"`, q)
+		assert.Equal(t, int64(11), args[0])
+		assert.Equal(t, `{"foo":"bar"}`, args[2])
+		assert.Equal(t, int64(12), args[11])
+	})
+}
+
+func Test_selectActionsQuery(t *testing.T) {
+	t.Run("no filters", func(t *testing.T) {
+		c := db.NewCursor(1, 100, []string{"id"})
+		f := db.NewFilter([]string{})
+		selectQuery, err := selectActionsQuery(c, f)
+		if !assert.NoError(t, err) {
+			t.Fatal(err)
+		}
+
+		expectedSelectSQL := `SELECT "id", "uid", "name", upper(encode("hash", 'hex')) AS "hash", "parent_uid", ` +
+			`"actor_entity_id", "target_entity_id", "is_async", "status", "emitted_at", "registered_at" ` +
+			`FROM "actions" ORDER BY "registered_at" DESC, "id" DESC LIMIT $1`
+		assert.Equal(t, expectedSelectSQL, selectQuery.SelectSQL)
+		assert.Equal(t, []interface{}{int64(101)}, selectQuery.SelectArgs)
+
+		assert.Equal(t, `SELECT count(*) AS "cnt" FROM "actions"`, selectQuery.CountSQL)
+		assert.Len(t, selectQuery.CountArgs, 0)
+	})
+
+	t.Run("after keyset", func(t *testing.T) {
+		at := time.Date(2021, 2, 23, 16, 54, 49, 0, time.UTC)
+		c := db.NewCursor(1, 10, nil)
+		c.After = &db.Keyset{At: at, ID: 42}
+
+		selectQuery, err := selectActionsQuery(c, db.NewFilter(nil))
+		if !assert.NoError(t, err) {
+			t.Fatal(err)
+		}
+
+		expectedSelectSQL := `SELECT "id", "uid", "name", upper(encode("hash", 'hex')) AS "hash", "parent_uid", ` +
+			`"actor_entity_id", "target_entity_id", "is_async", "status", "emitted_at", "registered_at" ` +
+			`FROM "actions" WHERE (("registered_at" < $1) OR (("registered_at" = $2) AND ("id" < $3))) ` +
+			`ORDER BY "registered_at" DESC, "id" DESC LIMIT $4`
+		assert.Equal(t, expectedSelectSQL, selectQuery.SelectSQL)
+		assert.Equal(t, []interface{}{at, at, int64(42), int64(11)}, selectQuery.SelectArgs)
+	})
+
+	t.Run("before keyset", func(t *testing.T) {
+		at := time.Date(2021, 2, 23, 16, 54, 49, 0, time.UTC)
+		c := db.NewCursor(1, 10, nil)
+		c.Before = &db.Keyset{At: at, ID: 42}
+
+		selectQuery, err := selectActionsQuery(c, db.NewFilter(nil))
+		if !assert.NoError(t, err) {
+			t.Fatal(err)
+		}
+
+		assert.Contains(t, selectQuery.SelectSQL, `WHERE (("registered_at" > $1) OR (("registered_at" = $2) AND ("id" > $3))) `+
+			`ORDER BY "registered_at" ASC, "id" ASC LIMIT $4`)
+	})
+
+	t.Run("keyset cannot follow name order", func(t *testing.T) {
+		c := db.NewCursor(1, 10, nil)
+		c.Sort.Add("name", db.ASCOrder)
+		c.After = &db.Keyset{At: time.Now(), ID: 1}
+
+		_, err := selectActionsQuery(c, db.NewFilter(nil))
+		assert.Equal(t, db.ErrInvalidQueryInput, errors.Cause(err))
+	})
+
+	t.Run("filter operators", func(t *testing.T) {
+		c := db.NewCursor(1, 10, nil)
+		f := db.NewFilter(nil).
+			Where("status", db.InOperator, "1", "5").
+			Where("name", db.PrefixOperator, "invoice_").
+			Where("emittedAt", db.GteOperator, "2021-02-01T00:00:00Z").
+			Where("emittedAt", db.LteOperator, "2021-02-28T23:59:59Z").
+			Add("actorService", "billing")
+
+		selectQuery, err := selectActionsQuery(c, f)
+		if !assert.NoError(t, err) {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, `SELECT count(*) AS "cnt" FROM "actions" WHERE (`+
+			`"name" LIKE $1 ESCAPE '!' AND ("status" IN ($2, $3)) AND ("emitted_at" >= $4) AND ("emitted_at" <= $5) AND `+
+			`"actions"."actor_entity_id" IN (SELECT "filtered_e"."id" FROM "entities" AS "filtered_e" `+
+			`INNER JOIN "entity_types" AS "filtered_et" ON ("filtered_et"."id" = "filtered_e"."entity_type_id") `+
+			`INNER JOIN "microservices" AS "filtered_ms" ON ("filtered_ms"."id" = "filtered_et"."service_id") `+
+			`WHERE ("filtered_ms"."name" = $6)))`, selectQuery.CountSQL)
+		assert.Equal(t, []interface{}{
+			"invoice!_%",
+			int64(1),
+			int64(5),
+			time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2021, 2, 28, 23, 59, 59, 0, time.UTC),
+			"billing",
+		}, selectQuery.CountArgs)
+	})
+
+	t.Run("invalid filter value", func(t *testing.T) {
+		_, err := selectActionsQuery(db.NewCursor(1, 10, nil), db.NewFilter(nil).Add("isAsync", "maybe"))
+		assert.Equal(t, db.ErrInvalidQueryInput, errors.Cause(err))
+	})
+
+	t.Run("filters and pagination", func(t *testing.T) {
+		c := db.NewCursor(3, 10, []string{"name"})
+		c.Sort.Add("name", db.ASCOrder)
+		f := db.NewFilter([]string{"parentUid", "actorEntityId"}).
+			Add("parentUid", "69502edbf207452eae7ec258271ee98c").
+			Add("actorEntityId", "5")
+
+		selectQuery, err := selectActionsQuery(c, f)
+		if !assert.NoError(t, err) {
+			t.Fatal(err)
+		}
+
+		expectedSelectSQL := `SELECT "id", "uid", "name", upper(encode("hash", 'hex')) AS "hash", "parent_uid", ` +
+			`"actor_entity_id", "target_entity_id", "is_async", "status", "emitted_at", "registered_at" ` +
+			`FROM "actions" WHERE (("parent_uid" = $1) AND ("actor_entity_id" = $2)) ORDER BY "name" ASC LIMIT $3 OFFSET $4`
+		assert.Equal(t, expectedSelectSQL, selectQuery.SelectSQL)
+		assert.Equal(t, []interface{}{"69502edbf207452eae7ec258271ee98c", int64(5), int64(11), int64(20)}, selectQuery.SelectArgs)
+
+		assert.Equal(t, `SELECT count(*) AS "cnt" FROM "actions" WHERE (("parent_uid" = $1) AND ("actor_entity_id" = $2))`, selectQuery.CountSQL)
+		assert.Equal(t, []interface{}{"69502edbf207452eae7ec258271ee98c", int64(5)}, selectQuery.CountArgs)
+	})
+}
+
+func Test_searchActionsQuery(t *testing.T) {
+	q, err := search.Parse(`details.order.id = 48213 details.note = null delta.price.from != 'n/a' paid`)
+	if !assert.NoError(t, err) {
+		t.Fatal(err)
+	}
+
+	selectQuery, err := selectActionsQuery(db.NewCursor(1, 10, nil), db.NewFilter(nil), searchExpressions(goqu.Dialect(Postgres), q)...)
+	if !assert.NoError(t, err) {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, `SELECT count(*) AS "cnt" FROM "actions" WHERE ("actions"."details" @> $1::jsonb AND `+
+		`coalesce(jsonb_typeof("actions"."details" #> $2::text[]), 'null') = 'null' AND `+
+		`"actions"."id" IN (SELECT "action_id" FROM "action_deltas" WHERE (("property_name" = $3) AND `+
+		`(jsonb_typeof("from_value") <> 'null' AND "from_value" <> $4::jsonb))) AND `+
+		`to_tsvector('simple', "actions"."details"::text) @@ phraseto_tsquery('simple', $5))`, selectQuery.CountSQL)
+	assert.Equal(t, []interface{}{`{"order":{"id":48213}}`, "{note}", "price", `"n/a"`, "paid"}, selectQuery.CountArgs)
+}
@@ -0,0 +1,41 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/denismitr/auditbase/internal/utils/retry"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+func ConnectAndMigrate(ctx context.Context, lg logger.Logger, dsn string, maxOpenConnection, maxIdleConnections int) (*sqlx.DB, error) {
+	var conn *sqlx.DB
+	if err := retry.Incremental(ctx, 2*time.Second, 100, func(attempt int) (err error) {
+		conn, err = sqlx.Connect("postgres", dsn)
+		if err != nil {
+			lg.Debugf("could not connect to DB on attempt %d", attempt)
+			return retry.Error(err, attempt)
+		}
+
+		if err = conn.PingContext(ctx); err != nil {
+			lg.Debugf("could not ping DB connection on attempt %d", attempt)
+			return retry.Error(err, attempt)
+		}
+
+		lg.Debugf("connection with DB established")
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	conn.SetMaxOpenConns(maxOpenConnection)
+	conn.SetMaxIdleConns(maxIdleConnections)
+
+	if err := Migrator(conn, lg).Up(); err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,431 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/sqlstore"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+type entityRecord struct {
+	ID               int       `db:"id"`
+	ExternalID       string    `db:"external_id"`
+	EntityTypeID     int       `db:"entity_type_id"`
+	IsActor          bool      `db:"is_actor"`
+	TargetActionsCnt int       `db:"target_actions_cnt"`
+	CreatedAt        time.Time `db:"created_at"`
+	UpdatedAt        time.Time `db:"updated_at"`
+}
+
+type entityRecordAllJoined struct {
+	EntityID              int       `db:"entity_id"`
+	EntityExternalID      string    `db:"entity_external_id"`
+	EntityTypeID          int       `db:"entity_type_id"`
+	IsActor               bool      `db:"is_actor"`
+	TargetActionsCnt      int       `db:"target_actions_cnt"`
+	EntityCreatedAt       time.Time `db:"entity_created_at"`
+	EntityUpdatedAt       time.Time `db:"entity_updated_at"`
+	EntityTypeName        string    `db:"entity_type_name"`
+	ServiceID             int       `db:"service_id"`
+	EntityTypeDescription string    `db:"entity_type_description"`
+	EntityTypeCreatedAt   time.Time `db:"entity_type_created_at"`
+	EntityTypeUpdatedAt   time.Time `db:"entity_type_updated_at"`
+	ServiceName           string    `db:"service_name"`
+	ServiceDescription    string    `db:"service_description"`
+	ServiceCreatedAt      time.Time `db:"service_created_at"`
+	ServiceUpdatedAt      time.Time `db:"service_updated_at"`
+}
+
+type EntityRepository struct {
+	*Tx
+}
+
+// static check of correct interface implementation
+var _ db.EntityRepository = (*EntityRepository)(nil)
+
+// Select all entities
+func (r *EntityRepository) Select(
+	ctx context.Context,
+	cursor *db.Cursor,
+	filter *db.Filter,
+) (*model.EntityCollection, error) {
+	var entities []entityRecord
+
+	sQ, err := selectEntitiesQuery(cursor, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := r.pgTx.PreparexContext(ctx, sQ.SelectSQL)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare selectSql to select entities")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	if err := stmt.SelectContext(ctx, &entities, sQ.SelectArgs...); err != nil {
+		return nil, errors.Wrap(err, "could not execute selectSql to select entities")
+	}
+
+	var cnt *int
+	if !cursor.SkipTotal {
+		cntStmt, err := r.pgTx.PreparexContext(ctx, sQ.CountSQL)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not prepare selectSql to count entities")
+		}
+
+		defer func() { _ = cntStmt.Close() }()
+
+		cnt = new(int)
+		if err := cntStmt.GetContext(ctx, cnt, sQ.CountArgs...); err != nil {
+			return nil, errors.Wrap(err, "could not execute selectSql to count entities")
+		}
+	}
+
+	return mapEntitiesToCollection(entities, cnt, cursor, sQ.Keyed), nil
+}
+
+// Create an entity
+func (r *EntityRepository) Create(ctx context.Context, e *model.Entity) (*model.Entity, error) {
+	q, args, err := createEntityQuery(e.EntityTypeID, e.ExternalID)
+	if err != nil {
+		panic(errors.Wrap(err, "how could query builder fail?"))
+	}
+
+	stmt, err := r.pgTx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare tx to create entity")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	var newID int64
+	if err := stmt.GetContext(ctx, &newID, args...); err != nil {
+		return nil, errors.Wrapf(err, "could not create new entities with externalID %s", e.ExternalID)
+	}
+
+	return r.FirstByID(ctx, model.ID(newID))
+}
+
+func (r *EntityRepository) FirstByExternalIDAndTypeID(
+	ctx context.Context,
+	externalID string,
+	entityTypeID model.ID,
+) (*model.Entity, error) {
+	q, args, err := firstByExternalIDAndTypeIDQuery(externalID, entityTypeID)
+	if err != nil {
+		panic(fmt.Sprintf("could not build firstByExternalIDAndTypeIDQuery query for %s - %d", externalID, entityTypeID))
+	}
+
+	stmt, err := r.pgTx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not prepare query %s", q)
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	ent := entityRecord{}
+
+	if err := stmt.GetContext(ctx, &ent, args...); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, db.ErrNotFound
+		default:
+			return nil, errors.Wrapf(
+				err,
+				"could not find entities with externalID [%s] and entityTypeID [%d]",
+				externalID,
+				entityTypeID,
+			)
+		}
+	}
+
+	return mapEntityRecordToModel(ent), nil
+}
+
+func (r *EntityRepository) FirstByID(ctx context.Context, ID model.ID) (*model.Entity, error) {
+	return firstEntityByID(ctx, r.pgTx, ID)
+}
+
+func (r *EntityRepository) FirstByIDWithEntityType(ctx context.Context, ID model.ID) (*model.Entity, error) {
+	entity, err := firstEntityByID(ctx, r.pgTx, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	entityType, err := firstEntityTypeByID(ctx, r.pgTx, entity.EntityTypeID)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not join entity type to entity")
+	}
+
+	entity.EntityType = entityType
+
+	return entity, nil
+}
+
+// FirstOrCreateByExternalIDAndEntityTypeID ...
+func (r *EntityRepository) FirstOrCreateByExternalIDAndEntityTypeID(
+	ctx context.Context,
+	externalID string,
+	entityTypeID model.ID,
+) (*model.Entity, error) {
+	if ent, err := r.FirstByExternalIDAndTypeID(ctx, externalID, entityTypeID); err == nil {
+		return ent, nil
+	} else if err != db.ErrNotFound {
+		return nil, err
+	}
+
+	ent := &model.Entity{
+		EntityTypeID: entityTypeID,
+		ExternalID:   externalID,
+	}
+
+	created, err := r.Create(ctx, ent)
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"entity with external ID [%s] and entity type ID [%d] does not exist and could not be created",
+			externalID,
+			entityTypeID,
+		)
+	}
+
+	return created, nil
+}
+
+func firstEntityByID(ctx context.Context, tx *sqlx.Tx, ID model.ID) (*model.Entity, error) {
+	q, args, err := firstEntityByIDQuery(ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ent := entityRecordAllJoined{}
+
+	stmt, err := tx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not prepare selectSql statement %s to get entityRecord by id", q)
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	if err := stmt.GetContext(ctx, &ent, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, db.ErrNotFound
+		}
+
+		return nil, errors.Wrapf(err, "could not find entities with ID %d", ID)
+	}
+
+	return mapEntityRecordAllJoinedToModel(ent), nil
+}
+
+func selectEntitiesQuery(c *db.Cursor, f *db.Filter) (*sqlstore.SelectQuery, error) {
+	dialect := goqu.Dialect(Postgres)
+
+	countQ := dialect.Select(goqu.L("count(*)").As("cnt")).From(goqu.T("entities").As("e"))
+
+	q := dialect.Select(
+		goqu.I("e.id"),
+		goqu.I("e.entity_type_id"),
+		goqu.I("e.external_id"),
+		goqu.I("e.created_at"),
+		goqu.I("e.updated_at"),
+	).From(goqu.T("entities").As("e"))
+
+	if f.Has("entityTypeId") {
+		countQ = countQ.Where(goqu.I("e.entity_type_id").Eq(f.IntOrDefault("entityTypeId", 0)))
+		q = q.Where(goqu.I("e.entity_type_id").Eq(f.IntOrDefault("entityTypeId", 0)))
+	}
+
+	if f.HasServices() {
+		countQ = countQ.Where(sqlstore.EntityServicesExpression(dialect, f.Services()))
+		q = q.Where(sqlstore.EntityServicesExpression(dialect, f.Services()))
+	}
+
+	sQ := sqlstore.SelectQuery{}
+
+	if c.Sort.Has("externalId") {
+		if c.Keyed() {
+			return nil, errors.Wrap(db.ErrInvalidQueryInput, "entities ordered by external ID cannot follow page tokens")
+		}
+
+		ind := goqu.I("e.external_id")
+		order := c.Sort.GetOrDefault("externalId", db.ASCOrder)
+		if order == db.ASCOrder {
+			q = q.OrderAppend(ind.Asc())
+		} else {
+			q = q.OrderAppend(ind.Desc())
+		}
+	} else {
+		column, id := goqu.I("e.updated_at"), goqu.I("e.id")
+		order := c.Sort.GetOrDefault("updatedAt", db.DESCOrder)
+
+		if c.Keyed() {
+			q = q.Where(sqlstore.KeysetExpression(column, id, order, c))
+		}
+
+		q = q.Order(sqlstore.KeysetOrder(column, id, order, c)...)
+		sQ.Keyed = true
+	}
+
+	q = q.Limit(c.Limit())
+	q = q.Offset(c.Offset())
+	if q, args, err := q.Prepared(true).ToSQL(); err != nil {
+		return nil, errors.Wrap(err, "invalid select SQL for entities")
+	} else {
+		sQ.SelectSQL = q
+		sQ.SelectArgs = args
+	}
+
+	if q, args, err := countQ.Prepared(true).ToSQL(); err != nil {
+		return nil, errors.Wrap(err, "invalid count SQL for entities")
+	} else {
+		sQ.CountSQL = q
+		sQ.CountArgs = args
+	}
+
+	return &sQ, nil
+}
+
+func firstEntityByIDQuery(ID model.ID) (string, []interface{}, error) {
+	dialect := goqu.Dialect(Postgres)
+
+	return dialect.Select(
+		goqu.I("e.id").As("entity_id"),
+		goqu.I("e.entity_type_id").As("entity_type_id"),
+		goqu.I("et.service_id").As("service_id"),
+		goqu.I("e.external_id").As("entity_external_id"),
+		goqu.I("et.name").As("entity_type_name"),
+		goqu.I("et.description").As("entity_type_description"),
+		goqu.I("ms.name").As("service_name"),
+		goqu.I("ms.description").As("service_description"),
+		goqu.I("e.created_at").As("entity_created_at"),
+		goqu.I("et.created_at").As("entity_type_created_at"),
+		goqu.I("ms.created_at").As("service_created_at"),
+		goqu.I("e.updated_at").As("entity_updated_at"),
+		goqu.I("et.updated_at").As("entity_type_updated_at"),
+		goqu.I("ms.updated_at").As("service_updated_at"),
+	).From(goqu.T("entities").As("e")).InnerJoin(
+		goqu.T("entity_types").As("et"),
+		goqu.On(goqu.Ex{"e.entity_type_id": goqu.I("et.id")}),
+	).InnerJoin(
+		goqu.T("microservices").As("ms"),
+		goqu.On(goqu.Ex{"et.service_id": goqu.I("ms.id")}),
+	).Where(
+		goqu.I("e.id").Eq(int(ID)),
+	).Limit(1).Prepared(true).ToSQL()
+}
+
+func firstByExternalIDAndTypeIDQuery(externalID string, entityTypeID model.ID) (string, []interface{}, error) {
+	if externalID == "" {
+		panic("how can external id be empty?")
+	}
+
+	dialect := goqu.Dialect(Postgres)
+
+	return dialect.Select(
+		"id", "entity_type_id", "external_id", "created_at", "updated_at",
+	).
+		From("entities").
+		Where(goqu.C("entity_type_id").Eq(int(entityTypeID))).
+		Where(goqu.C("external_id").Eq(externalID)).
+		Limit(1).
+		Prepared(true).
+		ToSQL()
+}
+
+func createEntityQuery(entityTypeID model.ID, externalID string) (string, []interface{}, error) {
+	if externalID == "" {
+		panic("how can external id be empty?")
+	}
+
+	dialect := goqu.Dialect(Postgres)
+
+	return dialect.Insert("entities").
+		Rows(goqu.Record{"external_id": externalID, "entity_type_id": int(entityTypeID)}).
+		Returning("id").
+		Prepared(true).
+		ToSQL()
+}
+
+// DeleteOrphans - removes at most limit entities created before the given time,
+// that are referenced by neither actions nor deltas, references are checked again
+// on delete, so that an entity referenced in the meantime is kept
+func (r *EntityRepository) DeleteOrphans(ctx context.Context, before time.Time, limit int) (int, error) {
+	q, args, err := selectOrphanEntitiesQuery(before, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var IDs []int
+	if err := r.pgTx.SelectContext(ctx, &IDs, q, args...); err != nil {
+		return 0, errors.Wrap(err, "could not select orphaned entities")
+	}
+
+	if len(IDs) == 0 {
+		return 0, nil
+	}
+
+	q, args, err = deleteOrphanEntitiesQuery(IDs)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := r.pgTx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not delete orphaned entities")
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "could not get number of deleted entities")
+	}
+
+	return int(n), nil
+}
+
+// orphanEntityExpression - entity is referenced by neither actions nor deltas
+func orphanEntityExpression() goqu.Expression {
+	dialect := goqu.Dialect(Postgres)
+	referencedBy := func(table, column string) goqu.Expression {
+		return goqu.L("NOT EXISTS ?", dialect.From(table).
+			Select(goqu.L("1")).
+			Where(goqu.I(table+"."+column).Eq(goqu.I("entities.id"))))
+	}
+
+	return goqu.And(
+		referencedBy("actions", "actor_entity_id"),
+		referencedBy("actions", "target_entity_id"),
+		referencedBy("action_deltas", "entity_id"),
+	)
+}
+
+func selectOrphanEntitiesQuery(before time.Time, limit int) (string, []interface{}, error) {
+	if limit <= 0 {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(Postgres)
+
+	return dialect.From("entities").
+		Select("id").
+		Where(goqu.I("entities.created_at").Lt(before.UTC()), orphanEntityExpression()).
+		Order(goqu.C("id").Asc()).
+		Limit(uint(limit)).
+		Prepared(true).ToSQL()
+}
+
+func deleteOrphanEntitiesQuery(IDs []int) (string, []interface{}, error) {
+	dialect := goqu.Dialect(Postgres)
+
+	return dialect.Delete("entities").
+		Where(goqu.I("entities.id").In(IDs), orphanEntityExpression()).
+		Prepared(true).ToSQL()
+}
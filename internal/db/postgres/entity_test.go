@@ -0,0 +1,30 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_firstEntityByIDQuery(t *testing.T) {
+	expected := `SELECT "e"."id" AS "entity_id", "e"."entity_type_id" AS "entity_type_id", "et"."service_id" AS "service_id", ` +
+		`"e"."external_id" AS "entity_external_id", "et"."name" AS "entity_type_name", "et"."description" AS "entity_type_description", ` +
+		`"ms"."name" AS "service_name", "ms"."description" AS "service_description", "e"."created_at" AS "entity_created_at", ` +
+		`"et"."created_at" AS "entity_type_created_at", "ms"."created_at" AS "service_created_at", "e"."updated_at" AS "entity_updated_at", ` +
+		`"et"."updated_at" AS "entity_type_updated_at", "ms"."updated_at" AS "service_updated_at" FROM "entities" AS "e" ` +
+		`INNER JOIN "entity_types" AS "et" ON ("e"."entity_type_id" = "et"."id") ` +
+		`INNER JOIN "microservices" AS "ms" ON ("et"."service_id" = "ms"."id") WHERE ("e"."id" = $1) LIMIT $2`
+
+	sql, args, err := firstEntityByIDQuery(model.ID(123))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, sql)
+	assert.Equal(t, []interface{}{int64(123), int64(1)}, args)
+}
+
+func Test_createEntityQuery(t *testing.T) {
+	sql, args, err := createEntityQuery(model.ID(5), "ext-1")
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "entities" ("entity_type_id", "external_id") VALUES ($1, $2) RETURNING "id"`, sql)
+	assert.Equal(t, []interface{}{int64(5), "ext-1"}, args)
+}
@@ -0,0 +1,158 @@
+package postgres
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+)
+
+// mapEntitiesToCollection - total is nil when it was not counted, tokens of the neighbouring pages
+// are only handed out when the entities are keyed by update time
+func mapEntitiesToCollection(items []entityRecord, total *int, c *db.Cursor, keyed bool) *model.EntityCollection {
+	result := model.EntityCollection{}
+
+	var keyOf func(i int) db.Keyset
+	if keyed {
+		keyOf = func(i int) db.Keyset {
+			return db.Keyset{At: items[i].UpdatedAt, ID: model.ID(items[i].ID)}
+		}
+	}
+
+	n := c.Paginate(&result.Meta, len(items), keyOf, func(i, j int) { items[i], items[j] = items[j], items[i] })
+	for _, e := range items[:n] {
+		result.Items = append(result.Items, *mapEntityRecordToModel(e))
+	}
+
+	result.Meta.Total = total
+	result.Meta.Page = int(c.Page)
+	result.Meta.PerPage = int(c.PerPage)
+
+	return &result
+}
+
+func mapEntityRecordToModel(e entityRecord) *model.Entity {
+	return &model.Entity{
+		ID:           model.ID(e.ID),
+		ExternalID:   e.ExternalID,
+		EntityTypeID: model.ID(e.EntityTypeID),
+		CreatedAt:    e.CreatedAt,
+		UpdatedAt:    e.UpdatedAt,
+	}
+}
+
+func mapEntityRecordAllJoinedToModel(e entityRecordAllJoined) *model.Entity {
+	return &model.Entity{
+		ID:           model.ID(e.EntityID),
+		ExternalID:   e.EntityExternalID,
+		EntityTypeID: model.ID(e.EntityTypeID),
+		CreatedAt:    e.EntityCreatedAt,
+		UpdatedAt:    e.EntityUpdatedAt,
+		EntityType: &model.EntityType{
+			ID:          model.ID(e.EntityTypeID),
+			Name:        e.EntityTypeName,
+			Description: e.EntityTypeDescription,
+			ServiceID:   model.ID(e.ServiceID),
+			CreatedAt:   e.EntityTypeCreatedAt,
+			UpdatedAt:   e.EntityTypeUpdatedAt,
+			Service: &model.Microservice{
+				ID:          model.ID(e.ServiceID),
+				Name:        e.ServiceName,
+				Description: e.ServiceDescription,
+				CreatedAt:   model.JSONTime{Time: e.ServiceCreatedAt},
+				UpdatedAt:   model.JSONTime{Time: e.ServiceUpdatedAt},
+			},
+		},
+	}
+}
+
+func mapEntityTypeRecordToModel(e entityTypeRecord) *model.EntityType {
+	return &model.EntityType{
+		ID:          model.ID(e.ID),
+		Name:        e.Name,
+		Description: e.Description,
+		ServiceID:   model.ID(e.ServiceID),
+		EntitiesCnt: e.EntitiesCnt,
+		CreatedAt:   e.CreatedAt,
+		UpdatedAt:   e.UpdatedAt,
+	}
+}
+
+func mapEntityTypesToCollection(items []entityTypeRecord, cnt int, page, perPage uint) *model.EntityTypeCollection {
+	result := model.EntityTypeCollection{}
+	for _, e := range items {
+		result.Items = append(result.Items, *mapEntityTypeRecordToModel(e))
+	}
+
+	result.Meta.Total = &cnt
+	result.Meta.Page = int(page)
+	result.Meta.PerPage = int(perPage)
+
+	return &result
+}
+
+// mapActionRecordsToCollection - total is nil when it was not counted, tokens of the neighbouring pages
+// are only handed out when the actions are keyed by registration time
+func mapActionRecordsToCollection(items []actionRecord, total *int, c *db.Cursor, keyed bool) *model.ActionCollection {
+	result := model.ActionCollection{}
+
+	var keyOf func(i int) db.Keyset
+	if keyed {
+		keyOf = func(i int) db.Keyset {
+			return db.Keyset{At: items[i].RegisteredAt, ID: model.ID(items[i].ID)}
+		}
+	}
+
+	n := c.Paginate(&result.Meta, len(items), keyOf, func(i, j int) { items[i], items[j] = items[j], items[i] })
+	for _, a := range items[:n] {
+		result.Items = append(result.Items, *mapActionRecordToModel(a))
+	}
+
+	result.Meta.Total = total
+	result.Meta.Page = int(c.Page)
+	result.Meta.PerPage = int(c.PerPage)
+
+	return &result
+}
+
+func mapActionRecordToModel(ar actionRecord) *model.Action {
+	a := model.Action{
+		ID:           model.ID(ar.ID),
+		UID:          model.UID(ar.UID),
+		IsAsync:      ar.IsAsync,
+		Status:       model.Status(ar.Status),
+		Name:         ar.Name,
+		Hash:         ar.Hash,
+		EmittedAt:    model.JSONTime{Time: ar.EmittedAt},
+		RegisteredAt: model.JSONTime{Time: ar.RegisteredAt},
+	}
+
+	if ar.ParentUID.Valid {
+		a.ParentUID = model.UID(ar.ParentUID.String)
+	}
+
+	if ar.ActorEntityID.Valid {
+		a.ActorEntityID = model.ID(ar.ActorEntityID.Int64)
+	}
+
+	if ar.TargetEntityID.Valid {
+		a.TargetEntityID = model.ID(ar.TargetEntityID.Int64)
+	}
+
+	if ar.PrevChainHash.Valid {
+		a.PrevChainHash = ar.PrevChainHash.String
+	}
+
+	if ar.ChainHash.Valid {
+		a.ChainHash = ar.ChainHash.String
+	}
+
+	if ar.Details.Valid {
+		if err := json.Unmarshal([]byte(ar.Details.String), &a.Details); err != nil {
+			panic(fmt.Sprintf("how could we not unmarshal details of retrieved action [%d]: %v?", ar.ID, err))
+		}
+	}
+
+	return &a
+}
@@ -0,0 +1,282 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/pkg/errors"
+)
+
+type microserviceRecord struct {
+	ID          int       `db:"id"`
+	Name        string    `db:"name"`
+	Description string    `db:"description"`
+	CreatedAt   time.Time `db:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at"`
+}
+
+func (m *microserviceRecord) ToModel() *model.Microservice {
+	return &model.Microservice{
+		ID:          model.ID(m.ID),
+		Name:        m.Name,
+		Description: m.Description,
+		CreatedAt:   model.JSONTime{Time: m.CreatedAt},
+		UpdatedAt:   model.JSONTime{Time: m.UpdatedAt},
+	}
+}
+
+type MicroserviceRepository struct {
+	*Tx
+}
+
+// static check of correct interface implementation
+var _ db.MicroserviceRepository = (*MicroserviceRepository)(nil)
+
+// Create microservice in Postgres DB and return a newly created instance
+func (r *MicroserviceRepository) Create(ctx context.Context, m *model.Microservice) (*model.Microservice, error) {
+	createSQL, createArgs, err := createMicroserviceQuery(m)
+	if err != nil {
+		return nil, err
+	}
+
+	createStmt, err := r.pgTx.PreparexContext(ctx, createSQL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not prepare insert statement %s", createSQL)
+	}
+
+	defer func() { _ = createStmt.Close() }()
+
+	var newID int64
+	if err := createStmt.GetContext(ctx, &newID, createArgs...); err != nil {
+		return nil, errors.Wrapf(err, "cannot insert record into microservices table")
+	}
+
+	return r.FirstByID(ctx, model.ID(newID))
+}
+
+// SelectAll microservices
+// fixme: pagination
+func (r *MicroserviceRepository) SelectAll(ctx context.Context) (*model.MicroserviceCollection, error) {
+	q, args, err := selectAllMicroservicesQuery()
+	if err != nil {
+		panic(fmt.Sprintf("how could selectAllMicroservicesQuery func fail? %s", err))
+	}
+
+	stmt, err := r.pgTx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare select all microservices query")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	var msr []microserviceRecord
+	if err := stmt.SelectContext(ctx, &msr, args...); err != nil {
+		return nil, errors.Wrap(err, "could not select all microservices")
+	}
+
+	var result model.MicroserviceCollection
+
+	for i := range msr {
+		result.Items = append(result.Items, *msr[i].ToModel())
+	}
+
+	total := len(result.Items)
+	result.Meta.Total = &total
+	result.Meta.Page = 1
+	result.Meta.PerPage = 10000
+
+	return &result, nil
+}
+
+func selectAllMicroservicesQuery() (string, []interface{}, error) {
+	dialect := goqu.Dialect(Postgres)
+
+	return dialect.From("microservices").Select(
+		"id", "name", "description",
+		"created_at", "updated_at",
+	).Order(goqu.C("name").Asc()).Prepared(true).ToSQL()
+}
+
+// Delete microservice by ID
+func (r *MicroserviceRepository) Delete(ctx context.Context, ID model.ID) error {
+	q, args, err := deleteMicroserviceQuery(ID)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := r.pgTx.PreparexContext(ctx, q)
+	if err != nil {
+		return errors.Wrap(err, "could not prepare delete microservice query")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	if _, err := stmt.ExecContext(ctx, args...); err != nil {
+		return errors.Wrapf(err, "could not delete microservices with ID %d", ID)
+	}
+
+	return nil
+}
+
+func deleteMicroserviceQuery(ID model.ID) (string, []interface{}, error) {
+	if ID <= 0 {
+		return "", nil, model.NewValidationError(
+			model.ErrInvalidID,
+			model.ErrField{Name: "id", Error: fmt.Sprintf("%d is invalid value for ID", ID)},
+		)
+	}
+
+	dialect := goqu.Dialect(Postgres)
+	return dialect.Delete("microservices").Where(goqu.C("id").Eq(int(ID))).Prepared(true).ToSQL()
+}
+
+// Update microservice by ID
+func (r *MicroserviceRepository) Update(ctx context.Context, ID model.ID, m *model.Microservice) (*model.Microservice, error) {
+	q, args, err := updateMicroserviceQuery(ID, m)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := r.pgTx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare update microservices query")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	if _, err := stmt.ExecContext(ctx, args...); err != nil {
+		return nil, errors.Wrapf(err, "could not update microservices with ID %d", ID)
+	}
+
+	return r.FirstByID(ctx, ID)
+}
+
+func updateMicroserviceQuery(ID model.ID, m *model.Microservice) (string, []interface{}, error) {
+	if m.Name == "" {
+		return "", nil, errors.New("how can microservice name be empty on update?")
+	}
+
+	if m.UpdatedAt.IsZero() {
+		return "", nil, errors.New("how can microservice updated at time be zero?")
+	}
+
+	dialect := goqu.Dialect(Postgres)
+	return dialect.Update("microservices").Where(goqu.C("id").Eq(int(ID))).Set(goqu.Record{
+		"name":        m.Name,
+		"description": m.Description,
+		"updated_at":  m.UpdatedAt.Time,
+	}).Prepared(true).ToSQL()
+}
+
+// FirstByID - find one microservice by ID
+func (r *MicroserviceRepository) FirstByID(ctx context.Context, ID model.ID) (*model.Microservice, error) {
+	q, args, err := firstMicroserviceByIDQuery(ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.first(ctx, q, args)
+}
+
+// FirstByName - gets first microservice by its name
+func (r *MicroserviceRepository) FirstByName(ctx context.Context, name string) (*model.Microservice, error) {
+	q, args, err := firstMicroserviceByNameQuery(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.first(ctx, q, args)
+}
+
+func (r *MicroserviceRepository) first(ctx context.Context, q string, args []interface{}) (*model.Microservice, error) {
+	var m microserviceRecord
+
+	stmt, err := r.pgTx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not prepare select statement %s", q)
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	if err := stmt.GetContext(ctx, &m, args...); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, db.ErrNotFound
+		default:
+			return nil, errors.Wrap(err, "could not get microservice")
+		}
+	}
+
+	return m.ToModel(), nil
+}
+
+// FirstOrCreateByName - gets first microservice with given name or tries to create a new one
+func (r *MicroserviceRepository) FirstOrCreateByName(ctx context.Context, name string) (*model.Microservice, error) {
+	m, err := r.FirstByName(ctx, name)
+	if err == nil {
+		return m, nil
+	} else if err != db.ErrNotFound {
+		return nil, err
+	}
+
+	m = &model.Microservice{
+		Name:        name,
+		Description: "",
+	}
+
+	createdMicroservice, err := r.Create(ctx, m)
+	if err != nil {
+		return nil, errors.Wrapf(err, "microservices with name %s does not exist and cannot be created", name)
+	}
+
+	return createdMicroservice, nil
+}
+
+func createMicroserviceQuery(m *model.Microservice) (string, []interface{}, error) {
+	if m.Name == "" || len(m.Name) > model.MaxServiceNameLen {
+		return "", nil, model.NewValidationError(
+			model.ErrServiceNameInvalid,
+			model.ErrField{Name: "name", Error: fmt.Sprintf("value '%s' is invalid", m.Name)},
+		)
+	}
+
+	dialect := goqu.Dialect(Postgres)
+
+	return dialect.Insert("microservices").
+		Rows(goqu.Record{"name": m.Name, "description": m.Description}).
+		Returning("id").
+		Prepared(true).
+		ToSQL()
+}
+
+func firstMicroserviceByIDQuery(ID model.ID) (string, []interface{}, error) {
+	if ID <= 0 {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(Postgres)
+
+	return dialect.From("microservices").
+		Select("id", "name", "description", "created_at", "updated_at").
+		Where(goqu.C("id").Eq(int(ID))).
+		Prepared(true).ToSQL()
+}
+
+func firstMicroserviceByNameQuery(name string) (string, []interface{}, error) {
+	if name == "" {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(Postgres)
+
+	return dialect.From("microservices").
+		Select("id", "name", "description", "created_at", "updated_at").
+		Where(goqu.C("name").Eq(name)).
+		Limit(1).
+		Prepared(true).ToSQL()
+}
@@ -0,0 +1,29 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createMicroserviceQuery(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		sql, args, err := createMicroserviceQuery(&model.Microservice{Name: "foo", Description: "bar"})
+		assert.NoError(t, err)
+		assert.Equal(t, `INSERT INTO "microservices" ("description", "name") VALUES ($1, $2) RETURNING "id"`, sql)
+		assert.Equal(t, []interface{}{"bar", "foo"}, args)
+	})
+
+	t.Run("empty name", func(t *testing.T) {
+		_, _, err := createMicroserviceQuery(&model.Microservice{})
+		assert.Error(t, err)
+	})
+}
+
+func Test_firstMicroserviceByNameQuery(t *testing.T) {
+	sql, args, err := firstMicroserviceByNameQuery("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, `SELECT "id", "name", "description", "created_at", "updated_at" FROM "microservices" WHERE ("name" = $1) LIMIT $2`, sql)
+	assert.Equal(t, []interface{}{"foo", int64(1)}, args)
+}
@@ -0,0 +1,31 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+)
+
+// ActionPartitionRepository - actions are not partitioned here, retention removes them row by row
+type ActionPartitionRepository struct {
+	*Tx
+}
+
+var _ db.ActionPartitionRepository = (*ActionPartitionRepository)(nil)
+
+func (r *ActionPartitionRepository) Select(ctx context.Context) ([]model.ActionPartition, error) {
+	return nil, db.ErrNotPartitioned
+}
+
+func (r *ActionPartitionRepository) Create(ctx context.Context, partitions []model.ActionPartition) error {
+	return db.ErrNotPartitioned
+}
+
+func (r *ActionPartitionRepository) Entomb(ctx context.Context, tombstones []model.ActionTombstone) error {
+	return db.ErrNotPartitioned
+}
+
+func (r *ActionPartitionRepository) Drop(ctx context.Context, name string) error {
+	return db.ErrNotPartitioned
+}
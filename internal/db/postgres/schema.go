@@ -0,0 +1,444 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// migrationsLockKey - arbitrary application wide key for pg_advisory_xact_lock
+const migrationsLockKey = 7306125
+
+type SQLMigrator struct {
+	conn *sqlx.DB
+	lg   logger.Logger
+
+	up      map[string][]string
+	applied map[string]bool
+}
+
+func Migrator(conn *sqlx.DB, lg logger.Logger) *SQLMigrator {
+	m := &SQLMigrator{
+		conn:    conn,
+		lg:      lg,
+		up:      make(map[string][]string),
+		applied: make(map[string]bool),
+	}
+
+	m.up["001_initial"] = []string{microservicesSchema, entityTypesSchema, entitiesSchema, actionsSchema}
+	m.up["002_action_deltas"] = []string{actionDeltasSchema}
+	m.up["003_action_chain"] = []string{actionsChainColumns, actionChainSchema, actionChainGenesis}
+	m.up["004_api_keys"] = []string{apiKeysSchema, apiKeyServicesSchema}
+	m.up["005_microservice_secrets"] = []string{microserviceSecretsSchema}
+	m.up["006_access_log"] = []string{accessLogSchema, accessLogRecordsSchema, accessLogAppendOnly}
+	m.up["007_retention"] = []string{retentionRulesSchema, actionTombstonesSchema, actionsEntityIndexes}
+	m.up["008_action_search"] = []string{actionsSearchIndexes}
+	m.up["009_action_stats"] = []string{actionsRegisteredAtIndex}
+	m.up["010_webhooks"] = []string{webhookRulesSchema, webhookDeliveriesSchema}
+	m.up["011_action_timeouts"] = []string{timeoutRulesSchema, timeoutSweepsSchema, actionTimeoutsSchema, actionsStatusIndex}
+
+	return m
+}
+
+const migrationsSchema = `
+CREATE TABLE IF NOT EXISTS migrations (
+	name VARCHAR(36) PRIMARY KEY,
+	created_at TIMESTAMP default CURRENT_TIMESTAMP
+);
+`
+
+const microservicesSchema = `
+	CREATE TABLE IF NOT EXISTS microservices (
+		id BIGSERIAL PRIMARY KEY,
+		name VARCHAR(36) NOT NULL,
+		description VARCHAR(255) NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+		CONSTRAINT microservices_unique_name UNIQUE (name)
+	);
+`
+
+const actionsSchema = `
+	CREATE TABLE IF NOT EXISTS actions (
+		id BIGSERIAL PRIMARY KEY,
+		uid VARCHAR(32) NOT NULL,
+		parent_uid VARCHAR(32),
+		status SMALLINT NOT NULL DEFAULT 0,
+		is_async BOOLEAN NOT NULL DEFAULT FALSE,
+		hash BYTEA,
+		actor_entity_id BIGINT REFERENCES entities (id) ON DELETE CASCADE,
+		target_entity_id BIGINT REFERENCES entities (id) ON DELETE CASCADE,
+		name VARCHAR(36) NOT NULL,
+		emitted_at TIMESTAMP NOT NULL,
+		registered_at TIMESTAMP NOT NULL,
+		details JSONB,
+
+		CONSTRAINT actions_hash_key UNIQUE (hash),
+		CONSTRAINT actions_uid_key UNIQUE (uid)
+	);
+
+	CREATE INDEX IF NOT EXISTS actions_name_idx ON actions (name);
+`
+
+const entityTypesSchema = `
+	CREATE TABLE IF NOT EXISTS entity_types (
+		id BIGSERIAL PRIMARY KEY,
+		service_id BIGINT NOT NULL REFERENCES microservices (id) ON DELETE CASCADE,
+		name VARCHAR(64) NOT NULL,
+		description VARCHAR(255) NOT NULL DEFAULT '',
+		is_actor BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+		CONSTRAINT entity_types_unique_service_and_name UNIQUE (service_id, name)
+	);
+`
+
+const entitiesSchema = `
+	CREATE TABLE IF NOT EXISTS entities (
+		id BIGSERIAL PRIMARY KEY,
+		entity_type_id BIGINT NOT NULL,
+		external_id VARCHAR(36) NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+		CONSTRAINT entities_unique_idx UNIQUE (entity_type_id, external_id)
+	);
+`
+
+const actionDeltasSchema = `
+	CREATE TABLE IF NOT EXISTS action_deltas (
+		id BIGSERIAL PRIMARY KEY,
+		action_id BIGINT NOT NULL REFERENCES actions (id) ON DELETE CASCADE,
+		entity_id BIGINT REFERENCES entities (id) ON DELETE CASCADE,
+		property_name VARCHAR(64) NOT NULL,
+		property_type VARCHAR(16) NOT NULL,
+		from_value JSONB,
+		to_value JSONB
+	);
+
+	CREATE INDEX IF NOT EXISTS action_deltas_action_idx ON action_deltas (action_id);
+	CREATE INDEX IF NOT EXISTS action_deltas_entity_property_idx ON action_deltas (entity_id, property_name);
+`
+
+const actionsChainColumns = `
+	ALTER TABLE actions
+		ADD COLUMN IF NOT EXISTS prev_chain_hash CHAR(64),
+		ADD COLUMN IF NOT EXISTS chain_hash CHAR(64);
+`
+
+// actionChainSchema - single row table holding the head of the action hash chain,
+// the row is locked by every action insert, so the chain never forks
+const actionChainSchema = `
+	CREATE TABLE IF NOT EXISTS action_chain (
+		id SMALLINT PRIMARY KEY,
+		last_hash CHAR(64) NOT NULL
+	);
+`
+
+const actionChainGenesis = `
+	INSERT INTO action_chain (id, last_hash) VALUES (1, '` + model.GenesisChainHash + `')
+	ON CONFLICT (id) DO NOTHING;
+`
+
+const apiKeysSchema = `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id BIGSERIAL PRIMARY KEY,
+		name VARCHAR(64) NOT NULL,
+		prefix CHAR(8) NOT NULL,
+		key_hash CHAR(64) NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		rotated_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+
+		CONSTRAINT api_keys_key_hash_key UNIQUE (key_hash)
+	);
+`
+
+const apiKeyServicesSchema = `
+	CREATE TABLE IF NOT EXISTS api_key_services (
+		api_key_id BIGINT NOT NULL REFERENCES api_keys (id) ON DELETE CASCADE,
+		microservice_id BIGINT NOT NULL REFERENCES microservices (id) ON DELETE CASCADE,
+
+		PRIMARY KEY (api_key_id, microservice_id)
+	);
+`
+
+// microserviceSecretsSchema - shared secrets microservices sign action submissions with,
+// kept apart from microservices, so that they never leak through the microservices API
+const microserviceSecretsSchema = `
+	CREATE TABLE IF NOT EXISTS microservice_secrets (
+		microservice_id BIGINT PRIMARY KEY REFERENCES microservices (id) ON DELETE CASCADE,
+		secret VARCHAR(64) NOT NULL,
+		previous_secret VARCHAR(64),
+		previous_expires_at TIMESTAMP,
+		rotated_at TIMESTAMP NOT NULL
+	);
+`
+
+// accessLogSchema - who read what through the back-office API
+const accessLogSchema = `
+	CREATE TABLE IF NOT EXISTS access_log (
+		id BIGSERIAL PRIMARY KEY,
+		principal VARCHAR(64) NOT NULL,
+		role VARCHAR(16) NOT NULL DEFAULT '',
+		method VARCHAR(8) NOT NULL,
+		route VARCHAR(128) NOT NULL,
+		path VARCHAR(255) NOT NULL,
+		filters TEXT,
+		status SMALLINT NOT NULL,
+		requested_at TIMESTAMP NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS access_log_principal_idx ON access_log (principal);
+`
+
+const accessLogRecordsSchema = `
+	CREATE TABLE IF NOT EXISTS access_log_records (
+		access_log_id BIGINT NOT NULL REFERENCES access_log (id),
+		record_type VARCHAR(16) NOT NULL,
+		record_id BIGINT NOT NULL,
+
+		PRIMARY KEY (access_log_id, record_type, record_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS access_log_records_record_idx ON access_log_records (record_type, record_id);
+`
+
+// accessLogAppendOnly - entries of the access log can be neither changed nor removed
+const accessLogAppendOnly = `
+	CREATE OR REPLACE FUNCTION access_log_append_only() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'access log is append-only';
+	END;
+	$$ LANGUAGE plpgsql;
+
+	CREATE TRIGGER access_log_append_only BEFORE UPDATE OR DELETE ON access_log
+	FOR EACH ROW EXECUTE PROCEDURE access_log_append_only();
+
+	CREATE TRIGGER access_log_records_append_only BEFORE UPDATE OR DELETE ON access_log_records
+	FOR EACH ROW EXECUTE PROCEDURE access_log_append_only();
+`
+
+// retentionRulesSchema - how long actions are kept, an empty service or action name matches any
+const retentionRulesSchema = `
+	CREATE TABLE IF NOT EXISTS retention_rules (
+		id BIGSERIAL PRIMARY KEY,
+		service VARCHAR(36) NOT NULL DEFAULT '',
+		action_name VARCHAR(36) NOT NULL DEFAULT '',
+		keep_days INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+		CONSTRAINT retention_rules_unique_service_and_action_name UNIQUE (service, action_name)
+	);
+`
+
+// actionTombstonesSchema - what is left of the actions removed by retention,
+// their chain hashes keep the hash chain verifiable
+const actionTombstonesSchema = `
+	CREATE TABLE IF NOT EXISTS action_tombstones (
+		action_id BIGINT PRIMARY KEY,
+		uid VARCHAR(32) NOT NULL,
+		name VARCHAR(36) NOT NULL,
+		prev_chain_hash CHAR(64),
+		chain_hash CHAR(64),
+		rule_id BIGINT,
+		pruned_at TIMESTAMP NOT NULL
+	);
+`
+
+// actionsEntityIndexes - orphaned entities are looked up by the actions referencing them
+const actionsEntityIndexes = `
+	CREATE INDEX IF NOT EXISTS actions_actor_entity_idx ON actions (actor_entity_id);
+	CREATE INDEX IF NOT EXISTS actions_target_entity_idx ON actions (target_entity_id);
+`
+
+// actionsSearchIndexes - jsonb_path_ops index serves containment of details, the text search one
+// the words of searches, the expression has to be repeated verbatim by queries to use it
+const actionsSearchIndexes = `
+	CREATE INDEX IF NOT EXISTS actions_details_idx ON actions USING GIN (details jsonb_path_ops);
+	CREATE INDEX IF NOT EXISTS actions_details_text_idx ON actions USING GIN (to_tsvector('simple', details::text));
+	CREATE INDEX IF NOT EXISTS action_deltas_property_idx ON action_deltas (property_name);
+`
+
+const actionsRegisteredAtIndex = `CREATE INDEX IF NOT EXISTS actions_registered_at_idx ON actions (registered_at)`
+
+// webhookRulesSchema - rules webhooks about actions are posted by, an empty criterion matches any
+const webhookRulesSchema = `
+	CREATE TABLE IF NOT EXISTS webhook_rules (
+		id BIGSERIAL PRIMARY KEY,
+		name VARCHAR(64) NOT NULL,
+		url VARCHAR(2048) NOT NULL,
+		secret VARCHAR(64) NOT NULL,
+		service VARCHAR(36) NOT NULL DEFAULT '',
+		entity_type VARCHAR(64) NOT NULL DEFAULT '',
+		name_pattern VARCHAR(36) NOT NULL DEFAULT '',
+		status SMALLINT,
+		details_condition TEXT NOT NULL DEFAULT '',
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+`
+
+// webhookDeliveriesSchema - webhooks posted or to be posted, a rule is delivered
+// at most once for every status an action reaches
+const webhookDeliveriesSchema = `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id BIGSERIAL PRIMARY KEY,
+		rule_id BIGINT NOT NULL REFERENCES webhook_rules (id) ON DELETE CASCADE,
+		action_id BIGINT NOT NULL,
+		action_status SMALLINT NOT NULL,
+		event VARCHAR(16) NOT NULL,
+		payload TEXT NOT NULL,
+		state VARCHAR(16) NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		response_code INTEGER NOT NULL DEFAULT 0,
+		last_error VARCHAR(1024) NOT NULL DEFAULT '',
+		next_attempt_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		delivered_at TIMESTAMP,
+
+		CONSTRAINT webhook_deliveries_unique_rule_action_status UNIQUE (rule_id, action_id, action_status)
+	);
+
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (state, next_attempt_at);
+`
+
+// timeoutRulesSchema - how long async actions are given to complete, an empty service or action name matches any
+const timeoutRulesSchema = `
+	CREATE TABLE IF NOT EXISTS timeout_rules (
+		id BIGSERIAL PRIMARY KEY,
+		service VARCHAR(36) NOT NULL DEFAULT '',
+		action_name VARCHAR(36) NOT NULL DEFAULT '',
+		timeout_seconds INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+		CONSTRAINT timeout_rules_unique_service_and_action_name UNIQUE (service, action_name)
+	);
+`
+
+// timeoutSweepsSchema - runs of the watchdog that timed out actions
+const timeoutSweepsSchema = `
+	CREATE TABLE IF NOT EXISTS timeout_sweeps (
+		id BIGSERIAL PRIMARY KEY,
+		started_at TIMESTAMP NOT NULL,
+		finished_at TIMESTAMP,
+		scanned INTEGER NOT NULL DEFAULT 0,
+		timed_out INTEGER NOT NULL DEFAULT 0
+	);
+`
+
+// actionTimeoutsSchema - async actions moved to TimedOut, along with the status they were stuck in,
+// the rule is kept as a plain ID, so that deleting the rule leaves the history alone
+const actionTimeoutsSchema = `
+	CREATE TABLE IF NOT EXISTS action_timeouts (
+		id BIGSERIAL PRIMARY KEY,
+		sweep_id BIGINT NOT NULL REFERENCES timeout_sweeps (id) ON DELETE CASCADE,
+		action_id BIGINT NOT NULL,
+		action_uid VARCHAR(32) NOT NULL,
+		action_name VARCHAR(36) NOT NULL,
+		service VARCHAR(36) NOT NULL DEFAULT '',
+		rule_id BIGINT,
+		previous_status SMALLINT NOT NULL,
+		registered_at TIMESTAMP NOT NULL,
+		timed_out_at TIMESTAMP NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS action_timeouts_sweep_idx ON action_timeouts (sweep_id);
+`
+
+// actionsStatusIndex - the watchdog looks for async actions still in progress
+const actionsStatusIndex = `CREATE INDEX IF NOT EXISTS actions_status_idx ON actions (status)`
+
+const flush = `
+	DROP TABLE IF EXISTS action_timeouts, timeout_sweeps, timeout_rules, webhook_deliveries, webhook_rules, action_tombstones, retention_rules, access_log_records, access_log, microservice_secrets, api_key_services, api_keys, action_chain, action_deltas, actions, entities, entity_types, microservices, migrations CASCADE;
+	DROP FUNCTION IF EXISTS access_log_append_only();
+`
+
+// Up - applies all migrations that were not applied yet,
+// Postgres supports transactional DDL, so migrations are applied in a single transaction
+// guarded by a transaction level advisory lock
+func (m *SQLMigrator) Up() error {
+	tx, err := m.conn.BeginTxx(context.Background(), &sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  false,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	m.lg.Debugf("Acquiring exclusive lock for migrations...")
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationsLockKey); err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, "could not obtain 'migrations' exclusive DB lock")
+	}
+
+	if _, err := tx.Exec(migrationsSchema); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	var applied []string
+	if err := tx.Select(&applied, "SELECT name FROM migrations"); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	for _, name := range applied {
+		m.applied[name] = true
+	}
+
+	migrations := make([]string, 0, len(m.up))
+	for name := range m.up {
+		migrations = append(migrations, name)
+	}
+
+	sort.Strings(migrations)
+
+	for _, name := range migrations {
+		if _, ok := m.applied[name]; ok {
+			m.lg.Debugf("Migration %s already applied", name)
+			continue
+		}
+
+		m.lg.Debugf("Running migration %s...", name)
+
+		for _, query := range m.up[name] {
+			m.lg.Debugf("Running SQL %s...", query)
+			if _, err := tx.Exec(query); err != nil {
+				_ = tx.Rollback()
+				return errors.Wrapf(err, "could not apply migration %s", name)
+			}
+		}
+
+		if _, err := tx.Exec("INSERT INTO migrations (name) VALUES ($1)", name); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	m.lg.Debugf("Migrations are finished...")
+	m.lg.Debugf("All locks are released...")
+
+	return nil
+}
+
+func (m *SQLMigrator) Down() error {
+	if _, err := m.conn.Exec(flush); err != nil {
+		return errors.Wrap(err, "could not drop tables")
+	}
+
+	return nil
+}
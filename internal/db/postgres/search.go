@@ -0,0 +1,92 @@
+package postgres
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/denismitr/auditbase/internal/db/sqlstore"
+	"github.com/denismitr/auditbase/internal/search"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+)
+
+// searchExpressions - expressions of the conditions and the words of the search query,
+// equality with details is a containment the GIN index of details serves,
+// words are phrases looked up in the text search index of details
+func searchExpressions(dialect goqu.DialectWrapper, q *search.Query) []exp.Expression {
+	var result []exp.Expression
+
+	for _, c := range q.Conditions {
+		if c.Source == search.Details {
+			details := goqu.I("actions.details")
+			if c.Operator == search.Eq && c.Value != nil {
+				result = append(result, goqu.L("? @> ?::jsonb", details, encodeSearchValue(containment(c.Path, c.Value))))
+			} else {
+				result = append(result, jsonComparison(goqu.L("? #> ?::text[]", details, textArray(c.Path)), c))
+			}
+
+			continue
+		}
+
+		column := "from_value"
+		if c.Source == search.DeltaTo {
+			column = "to_value"
+		}
+
+		deltas := dialect.From("action_deltas").
+			Select("action_id").
+			Where(goqu.C("property_name").Eq(c.Property()), jsonComparison(goqu.C(column), c))
+
+		result = append(result, sqlstore.InSubquery(goqu.I("actions.id"), deltas))
+	}
+
+	for _, w := range q.Words {
+		result = append(result, goqu.L("to_tsvector('simple', ?::text) @@ phraseto_tsquery('simple', ?)", goqu.I("actions.details"), w))
+	}
+
+	return result
+}
+
+// jsonComparison - comparison of the jsonb value with the value of the condition, JSON null and
+// missing values both equal null, only numbers are ordered
+func jsonComparison(value exp.Expression, c search.Condition) exp.Expression {
+	if c.Value == nil {
+		if c.Operator == search.Neq {
+			return goqu.L("coalesce(jsonb_typeof(?), 'null') <> 'null'", value)
+		}
+
+		return goqu.L("coalesce(jsonb_typeof(?), 'null') = 'null'", value)
+	}
+
+	switch c.Operator {
+	case search.Eq:
+		return goqu.L("? = ?::jsonb", value, encodeSearchValue(c.Value))
+	case search.Neq:
+		return goqu.L("(jsonb_typeof(?) <> 'null' AND ? <> ?::jsonb)", value, value, encodeSearchValue(c.Value))
+	default:
+		return goqu.L("(jsonb_typeof(?) = 'number' AND ? "+string(c.Operator)+" ?::jsonb)", value, value, encodeSearchValue(c.Value))
+	}
+}
+
+// containment - JSON document with the value at the path of the keys
+func containment(path []string, value interface{}) interface{} {
+	for i := len(path) - 1; i >= 0; i-- {
+		value = map[string]interface{}{path[i]: value}
+	}
+
+	return value
+}
+
+// textArray - text[] literal of the keys, which consist of letters, digits, dashes and underscores only
+func textArray(keys []string) string {
+	return "{" + strings.Join(keys, ",") + "}"
+}
+
+func encodeSearchValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic("how could a search value fail to encode")
+	}
+
+	return string(b)
+}
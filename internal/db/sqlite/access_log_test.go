@@ -0,0 +1,81 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLogRepository(t *testing.T) {
+	database := newTestDatabase(t)
+	requestedAt := time.Date(2021, 4, 1, 10, 0, 0, 0, time.UTC)
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		_, err := tx.AccessLog().Create(ctx, &model.AccessLogEntry{
+			Principal:   "alice",
+			Role:        "auditor",
+			Method:      "GET",
+			Route:       "/api/v1/actions",
+			Path:        "/api/v1/actions",
+			Filters:     map[string]string{"name": "invoice_paid"},
+			Status:      200,
+			ActionIDs:   []model.ID{3, 1, 3},
+			RequestedAt: model.JSONTime{Time: requestedAt},
+		})
+		if !assert.NoError(t, err) {
+			return err
+		}
+
+		_, err = tx.AccessLog().Create(ctx, &model.AccessLogEntry{
+			Principal:   "bob",
+			Method:      "GET",
+			Route:       "/api/v1/entities/:id",
+			Path:        "/api/v1/entities/7",
+			Status:      200,
+			EntityIDs:   []model.ID{7},
+			RequestedAt: model.JSONTime{Time: requestedAt.Add(time.Minute)},
+		})
+
+		return err
+	})
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		c := &db.Cursor{Page: 1, PerPage: 10}
+
+		all, err := tx.AccessLog().Select(ctx, c, db.NewFilter(nil))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, *all.Meta.Total)
+		if assert.Len(t, all.Items, 2) {
+			assert.Equal(t, "bob", all.Items[0].Principal)
+			assert.Equal(t, []model.ID{7}, all.Items[0].EntityIDs)
+			assert.Equal(t, "alice", all.Items[1].Principal)
+			assert.Equal(t, []model.ID{1, 3}, all.Items[1].ActionIDs)
+			assert.Equal(t, map[string]string{"name": "invoice_paid"}, all.Items[1].Filters)
+			assert.Equal(t, requestedAt, all.Items[1].RequestedAt.UTC())
+		}
+
+		readers, err := tx.AccessLog().Select(ctx, c, db.NewFilter([]string{"actionId"}).Add("actionId", "3"))
+		assert.NoError(t, err)
+		if assert.Len(t, readers.Items, 1) {
+			assert.Equal(t, "alice", readers.Items[0].Principal)
+		}
+
+		byPrincipal, err := tx.AccessLog().Select(ctx, c, db.NewFilter([]string{"principal"}).Add("principal", "bob"))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, *byPrincipal.Meta.Total)
+
+		return nil
+	})
+
+	t.Run("entries cannot be changed or removed", func(t *testing.T) {
+		_, err := database.conn.Exec("UPDATE access_log SET principal = 'mallory'")
+		assert.Error(t, err)
+
+		_, err = database.conn.Exec("DELETE FROM access_log_records")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,125 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActionDeltaRepository(t *testing.T) {
+	database := newTestDatabase(t)
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		ms, err := tx.Microservices().Create(ctx, &model.Microservice{Name: "blog"})
+		if err != nil {
+			return err
+		}
+
+		et, err := tx.EntityTypes().FirstOrCreateByNameAndServiceID(ctx, "article", ms.ID)
+		if err != nil {
+			return err
+		}
+
+		article, err := tx.Entities().FirstOrCreateByExternalIDAndEntityTypeID(ctx, "7", et.ID)
+		if err != nil {
+			return err
+		}
+
+		emittedAt := time.Date(2021, 2, 23, 16, 0, 0, 0, time.UTC)
+
+		// created later but emitted earlier, history must follow emitted at time
+		updated, err := tx.Actions().Create(ctx, &model.Action{
+			UID:            "22222222222222222222222222222222",
+			Name:           "article_updated",
+			TargetEntityID: article.ID,
+			EmittedAt:      model.JSONTime{Time: emittedAt.Add(time.Hour)},
+			RegisteredAt:   model.JSONTime{Time: emittedAt.Add(time.Hour)},
+		})
+		if err != nil {
+			return err
+		}
+
+		created, err := tx.Actions().Create(ctx, &model.Action{
+			UID:            "11111111111111111111111111111111",
+			Name:           "article_created",
+			TargetEntityID: article.ID,
+			EmittedAt:      model.JSONTime{Time: emittedAt},
+			RegisteredAt:   model.JSONTime{Time: emittedAt},
+		})
+		if err != nil {
+			return err
+		}
+
+		createdDelta := model.Delta{
+			{PropertyName: "title", CurrentPropertyType: model.StringProperty, From: nil, To: "foo"},
+			{PropertyName: "views", CurrentPropertyType: model.IntegerProperty, From: nil, To: float64(1)},
+		}
+
+		updatedDelta := model.Delta{
+			{PropertyName: "title", CurrentPropertyType: model.StringProperty, From: "foo", To: "bar"},
+			{PropertyName: "published", CurrentPropertyType: model.BooleanProperty, From: nil, To: true},
+		}
+
+		assert.NoError(t, tx.Deltas().Create(ctx, updated.ID, article.ID, updatedDelta))
+		assert.NoError(t, tx.Deltas().Create(ctx, created.ID, article.ID, createdDelta))
+		assert.NoError(t, tx.Deltas().Create(ctx, created.ID, article.ID, nil))
+
+		delta, err := tx.Deltas().SelectByActionID(ctx, created.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, createdDelta, delta)
+
+		c := db.NewCursor(1, 10, []string{"emittedAt"})
+		history, err := tx.Deltas().PropertyHistory(ctx, article.ID, "title", c)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, *history.Meta.Total)
+		if assert.Len(t, history.Items, 2) {
+			assert.Equal(t, created.ID, history.Items[0].ActionID)
+			assert.Equal(t, "article_created", history.Items[0].ActionName)
+			assert.Nil(t, history.Items[0].From)
+			assert.Equal(t, "foo", history.Items[0].To)
+			assert.Equal(t, updated.UID, history.Items[1].ActionUID)
+			assert.Equal(t, "foo", history.Items[1].From)
+			assert.Equal(t, "bar", history.Items[1].To)
+			assert.True(t, emittedAt.Add(time.Hour).Equal(history.Items[1].EmittedAt.Time))
+		}
+
+		c.Sort.Add("emittedAt", db.DESCOrder)
+		history, err = tx.Deltas().PropertyHistory(ctx, article.ID, "title", c)
+		assert.NoError(t, err)
+		if assert.Len(t, history.Items, 2) {
+			assert.Equal(t, updated.ID, history.Items[0].ActionID)
+		}
+
+		empty, err := tx.Deltas().PropertyHistory(ctx, article.ID, "body", c)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, *empty.Meta.Total)
+		assert.Len(t, empty.Items, 0)
+
+		changes, err := tx.Deltas().SelectByEntityID(ctx, article.ID, emittedAt.Add(30*time.Minute))
+		assert.NoError(t, err)
+		if assert.Len(t, changes, 2) {
+			assert.Equal(t, "title", changes[0].PropertyName)
+			assert.Equal(t, "views", changes[1].PropertyName)
+			assert.Equal(t, created.ID, changes[1].ActionID)
+		}
+
+		changes, err = tx.Deltas().SelectByEntityID(ctx, article.ID, emittedAt.Add(time.Hour))
+		assert.NoError(t, err)
+		if assert.Len(t, changes, 4) {
+			assert.Equal(t, updated.ID, changes[2].ActionID)
+			assert.Equal(t, "published", changes[3].PropertyName)
+		}
+
+		// deltas are removed together with the action
+		assert.NoError(t, tx.Actions().Delete(ctx, updated.ID))
+		history, err = tx.Deltas().PropertyHistory(ctx, article.ID, "title", c)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, *history.Meta.Total)
+
+		return nil
+	})
+}
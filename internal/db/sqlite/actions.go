@@ -0,0 +1,817 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/sqlstore"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/search"
+	"github.com/doug-martin/goqu/v9"
+	_ "github.com/doug-martin/goqu/v9/dialect/sqlite3"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/pkg/errors"
+)
+
+type ActionRepository struct {
+	*Tx
+	*sqlstore.ActionRepository
+}
+
+type actionRecord struct {
+	ID             int            `db:"id"`
+	ParentUID      sql.NullString `db:"parent_uid"`
+	UID            string         `db:"uid"`
+	Hash           string         `db:"hash"`
+	ActorEntityID  sql.NullInt64  `db:"actor_entity_id"`
+	TargetEntityID sql.NullInt64  `db:"target_entity_id"`
+	Name           string         `db:"name"`
+	Status         int8           `db:"status"`
+	IsAsync        bool           `db:"is_async"`
+	Details        sql.NullString `db:"details"`
+	EmittedAt      time.Time      `db:"emitted_at"`
+	RegisteredAt   time.Time      `db:"registered_at"`
+	PrevChainHash  sql.NullString `db:"prev_chain_hash"`
+	ChainHash      sql.NullString `db:"chain_hash"`
+}
+
+type childrenCountRecord struct {
+	ParentUID string `db:"parent_uid"`
+	Cnt       int    `db:"cnt"`
+}
+
+var _ db.ActionRepository = (*ActionRepository)(nil)
+
+// hexHash - selects binary hash as upper case hex string, the same way MySQL HEX() does
+var hexHash = goqu.L("upper(hex(`hash`))").As("hash")
+
+// Create - stores the action linked to the hash chain, SQLite serializes writers,
+// so the last chain hash cannot change until the transaction is over
+func (r *ActionRepository) Create(ctx context.Context, action *model.Action) (*model.Action, error) {
+	prevChainHash, err := r.lastChainHash(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := action.SealChain(prevChainHash); err != nil {
+		return nil, err
+	}
+
+	q, args, err := createActionQuery(action)
+	if err != nil {
+		panic(fmt.Sprintf("how could createActionQuery func have failed? %s", err.Error()))
+	}
+
+	stmt, err := r.sqliteTx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not prepare query %s", q)
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	result, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create action")
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not retrieve last insert ID")
+	}
+
+	if err := r.advanceChain(ctx, action.ChainHash); err != nil {
+		return nil, err
+	}
+
+	return r.FirstByID(ctx, model.ID(newID))
+}
+
+func (r *ActionRepository) lastChainHash(ctx context.Context) (string, error) {
+	q, args, err := lastChainHashQuery()
+	if err != nil {
+		return "", err
+	}
+
+	var hash string
+	if err := r.sqliteTx.GetContext(ctx, &hash, q, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.New("action chain is not initialized")
+		}
+
+		return "", errors.Wrap(err, "could not get last chain hash")
+	}
+
+	return hash, nil
+}
+
+func (r *ActionRepository) advanceChain(ctx context.Context, chainHash string) error {
+	q, args, err := advanceChainQuery(chainHash)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.sqliteTx.ExecContext(ctx, q, args...); err != nil {
+		return errors.Wrap(err, "could not advance action chain")
+	}
+
+	return nil
+}
+
+func (r *ActionRepository) UpdateStatus(ctx context.Context, id model.ID, status model.Status) error {
+	q, args, err := updateActionQuery(id, status)
+	if err != nil {
+		return errors.Wrap(err, "how could updateActionQuery func have failed?")
+	}
+
+	stmt, err := r.sqliteTx.PreparexContext(ctx, q)
+	if err != nil {
+		return errors.Wrapf(err, "could not prepare query %s", q)
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	if _, err := stmt.ExecContext(ctx, args...); err != nil {
+		return errors.Wrap(err, "could not update action status")
+	}
+
+	return nil
+}
+
+func updateActionQuery(id model.ID, status model.Status) (string, []interface{}, error) {
+	dialect := goqu.Dialect(SQLite)
+
+	return dialect.Update("actions").
+		Set(goqu.Record{"status": int64(status)}).
+		Where(goqu.C("id").Eq(id.Int64()), goqu.C("status").Neq(int64(model.TimedOut))).
+		Prepared(true).
+		ToSQL()
+}
+
+func (r *ActionRepository) CountAll(ctx context.Context) (int, error) {
+	q := `select count(*) as cnt from actions`
+
+	stmt, err := r.sqliteTx.PreparexContext(ctx, q)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not prepare count all actions")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	var count int
+	row := stmt.QueryRowContext(ctx)
+	switch err := row.Scan(&count); err {
+	case sql.ErrNoRows:
+		return 0, nil
+	case nil:
+		return count, nil
+	default:
+		return 0, errors.Wrap(err, "could not count actions")
+	}
+}
+
+func (r *ActionRepository) Select(
+	ctx context.Context,
+	c *db.Cursor,
+	f *db.Filter,
+) (*model.ActionCollection, error) {
+	sQ, err := selectActionsQuery(c, f)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.selectCollection(ctx, sQ, c)
+}
+
+// Search - actions matching the search query and the filter, conditions are evaluated
+// by json_match, words are looked up with LIKE
+func (r *ActionRepository) Search(
+	ctx context.Context,
+	q *search.Query,
+	c *db.Cursor,
+	f *db.Filter,
+) (*model.ActionCollection, error) {
+	sQ, err := selectActionsQuery(c, f, searchExpressions(goqu.Dialect(SQLite), q)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.selectCollection(ctx, sQ, c)
+}
+
+func (r *ActionRepository) selectCollection(ctx context.Context, sQ *sqlstore.SelectQuery, c *db.Cursor) (*model.ActionCollection, error) {
+	selectStmt, err := r.sqliteTx.PreparexContext(ctx, sQ.SelectSQL)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare select actions query")
+	}
+
+	defer func() { _ = selectStmt.Close() }()
+
+	var total *int
+	if !c.SkipTotal {
+		countStmt, err := r.sqliteTx.PreparexContext(ctx, sQ.CountSQL)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not prepare count actions query")
+		}
+
+		defer func() { _ = countStmt.Close() }()
+
+		total = new(int)
+		if err := countStmt.GetContext(ctx, total, sQ.CountArgs...); err != nil {
+			return nil, errors.Wrap(err, "could not execute count actions query")
+		}
+	}
+
+	var ars []actionRecord
+	if err := selectStmt.SelectContext(ctx, &ars, sQ.SelectArgs...); err != nil {
+		return nil, errors.Wrap(err, "could not execute select actions query")
+	}
+
+	return mapActionRecordsToCollection(ars, total, c, sQ.Keyed), nil
+}
+
+// selectActionsQuery - actions are listed by registration time and then by ID, so that pages can
+// follow keysets, ordering by name allows numbered pages only. Expressions given narrow down
+// the actions in addition to the filter
+func selectActionsQuery(c *db.Cursor, f *db.Filter, expressions ...exp.Expression) (*sqlstore.SelectQuery, error) {
+	dialect := goqu.Dialect(SQLite)
+
+	countQ := dialect.
+		From("actions").
+		Select(goqu.L("count(*)").As("cnt"))
+
+	q := dialect.Select(
+		"id", "uid", "name",
+		hexHash,
+		"parent_uid", "actor_entity_id", "target_entity_id",
+		"is_async", "status",
+		"emitted_at", "registered_at",
+	).From("actions")
+
+	where, err := sqlstore.ActionFilterExpressions(dialect, f)
+	if err != nil {
+		return nil, err
+	}
+
+	where = append(where, expressions...)
+	if len(where) > 0 {
+		countQ = countQ.Where(where...)
+		q = q.Where(where...)
+	}
+
+	sQ := sqlstore.SelectQuery{}
+
+	if c.Sort.Has("name") {
+		if c.Keyed() {
+			return nil, errors.Wrap(db.ErrInvalidQueryInput, "actions ordered by name cannot follow page tokens")
+		}
+
+		expr := goqu.I("name")
+		if c.Sort.GetOrDefault("name", db.DESCOrder) == db.ASCOrder {
+			q = q.Order(expr.Asc())
+		} else {
+			q = q.Order(expr.Desc())
+		}
+	} else {
+		column, id := goqu.C("registered_at"), goqu.C("id")
+		order := c.Sort.GetOrDefault("registered_at", db.DESCOrder)
+
+		if c.Keyed() {
+			q = q.Where(sqlstore.KeysetExpression(column, id, order, c))
+		}
+
+		q = q.Order(sqlstore.KeysetOrder(column, id, order, c)...)
+		sQ.Keyed = true
+	}
+
+	q = q.Limit(c.Limit())
+	q = q.Offset(c.Offset())
+
+	if query, args, err := q.Prepared(true).ToSQL(); err != nil {
+		return nil, errors.Wrap(err, "invalid select SQL for actions")
+	} else {
+		sQ.SelectSQL = query
+		sQ.SelectArgs = args
+	}
+
+	if query, args, err := countQ.Prepared(true).ToSQL(); err != nil {
+		return nil, errors.Wrap(err, "invalid count SQL for actions")
+	} else {
+		sQ.CountSQL = query
+		sQ.CountArgs = args
+	}
+
+	return &sQ, nil
+}
+
+func (r *ActionRepository) FirstByID(ctx context.Context, ID model.ID) (*model.Action, error) {
+	q, args, err := firstActionByIDQuery(ID)
+	if err != nil {
+		panic("how could firstActionByIDQuery func fail?")
+	}
+
+	return r.first(ctx, q, args)
+}
+
+func (r *ActionRepository) FirstByUID(ctx context.Context, UID model.UID) (*model.Action, error) {
+	q, args, err := firstActionByUIDQuery(UID)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.first(ctx, q, args)
+}
+
+func (r *ActionRepository) first(ctx context.Context, q string, args []interface{}) (*model.Action, error) {
+	stmt, err := r.sqliteTx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare first action query")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	var ar actionRecord
+
+	if err := stmt.GetContext(ctx, &ar, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, db.ErrNotFound
+		}
+
+		return nil, errors.Wrap(err, "could not get action")
+	}
+
+	return mapActionRecordToModel(ar), nil
+}
+
+func (r *ActionRepository) Delete(ctx context.Context, ID model.ID) error {
+	q, args, err := deleteActionQuery(ID)
+	if err != nil {
+		panic("how could deleteActionQuery fail?")
+	}
+
+	stmt, err := r.sqliteTx.PreparexContext(ctx, q)
+	if err != nil {
+		return errors.Wrap(err, "could not prepare delete action query")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	result, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return errors.Wrapf(err, "could not delete action with ID [%d]", ID)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return errors.Wrapf(db.ErrActionNotFound, "id [%d]", ID)
+	}
+
+	return nil
+}
+
+func (r *ActionRepository) Names(ctx context.Context) ([]string, error) {
+	q, _, err := actionNamesQuery()
+	if err != nil {
+		panic(fmt.Sprintf("how could actionNamesQuery func fail? %s", err))
+	}
+
+	stmt, err := r.sqliteTx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare action names query")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	var names []string
+	if err := stmt.SelectContext(ctx, &names); err != nil {
+		return nil, errors.Wrap(err, "could not select action names")
+	}
+
+	return names, nil
+}
+
+func actionNamesQuery() (string, []interface{}, error) {
+	dialect := goqu.Dialect(SQLite)
+
+	return dialect.From("actions").Select(
+		goqu.L("distinct name"),
+	).ToSQL()
+}
+
+func deleteActionQuery(ID model.ID) (string, []interface{}, error) {
+	dialect := goqu.Dialect(SQLite)
+
+	return dialect.Delete("actions").Where(goqu.C("id").Eq(int(ID))).Prepared(true).ToSQL()
+}
+
+func firstActionByIDQuery(ID model.ID) (string, []interface{}, error) {
+	dialect := goqu.Dialect(SQLite)
+
+	return dialect.From("actions").Select(
+		"id", "uid", "parent_uid", "status", "is_async",
+		"actor_entity_id", "target_entity_id",
+		hexHash, "name", "details", "emitted_at", "registered_at",
+		"prev_chain_hash", "chain_hash",
+	).Where(
+		goqu.C("id").Eq(int(ID)),
+	).Limit(1).Prepared(true).ToSQL()
+}
+
+func firstActionByUIDQuery(UID model.UID) (string, []interface{}, error) {
+	dialect := goqu.Dialect(SQLite)
+
+	if !UID.Valid() {
+		return "", nil, errors.Wrap(db.ErrInvalidQueryInput, "action uid is invalid")
+	}
+
+	return dialect.From("actions").Select(
+		"id", "uid", "parent_uid", "is_async", "status",
+		"actor_entity_id", "target_entity_id",
+		hexHash, "name", "details", "emitted_at", "registered_at",
+		"prev_chain_hash", "chain_hash",
+	).Where(
+		goqu.C("uid").Eq(UID.String()),
+	).Limit(1).Prepared(true).ToSQL()
+}
+
+func createActionQuery(action *model.Action) (string, []interface{}, error) {
+	dialect := goqu.Dialect(SQLite)
+
+	row := goqu.Record{}
+
+	if action.ParentUID.Valid() {
+		row["parent_uid"] = action.ParentUID.String()
+	} else {
+		row["parent_uid"] = nil
+	}
+
+	if action.ActorEntityID.Valid() {
+		row["actor_entity_id"] = action.ActorEntityID.Int64()
+	} else {
+		row["actor_entity_id"] = nil
+	}
+
+	if action.TargetEntityID.Valid() {
+		row["target_entity_id"] = action.TargetEntityID.Int64()
+	} else {
+		row["target_entity_id"] = nil
+	}
+
+	row["uid"] = action.UID.String()
+	row["name"] = action.Name
+	row["hash"] = unhex(action.Hash)
+	row["status"] = action.Status
+	row["is_async"] = action.IsAsync
+	row["emitted_at"] = action.EmittedAt.Time
+	row["registered_at"] = action.RegisteredAt.Time
+	row["prev_chain_hash"] = action.PrevChainHash
+	row["chain_hash"] = action.ChainHash
+
+	if action.Details != nil {
+		b, err := json.Marshal(action.Details)
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "could not create details json string")
+		}
+		row["details"] = string(b)
+	}
+
+	return dialect.Insert("actions").Rows(row).Prepared(true).ToSQL()
+}
+
+// unhex - SQLite has no UNHEX function, so the hash is decoded before insert,
+// invalid hex string becomes NULL just like MySQL UNHEX does with it
+func unhex(hash string) interface{} {
+	b, err := hex.DecodeString(hash)
+	if err != nil || len(b) == 0 {
+		return nil
+	}
+
+	return b
+}
+
+// SelectByParentUIDs - direct children of the given actions ordered by emitted at time
+func (r *ActionRepository) SelectByParentUIDs(
+	ctx context.Context,
+	parentUIDs []model.UID,
+	limit int,
+) ([]model.Action, error) {
+	q, args, err := selectActionsByParentUIDsQuery(parentUIDs, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := r.sqliteTx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare select actions by parent uids query")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	var ars []actionRecord
+	if err := stmt.SelectContext(ctx, &ars, args...); err != nil {
+		return nil, errors.Wrap(err, "could not select actions by parent uids")
+	}
+
+	actions := make([]model.Action, len(ars))
+	for i := range ars {
+		actions[i] = *mapActionRecordToModel(ars[i])
+	}
+
+	return actions, nil
+}
+
+// CountChildren - number of direct children of the given actions,
+// actions without children are not present in the result
+func (r *ActionRepository) CountChildren(ctx context.Context, parentUIDs []model.UID) (map[model.UID]int, error) {
+	q, args, err := countChildrenQuery(parentUIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := r.sqliteTx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare count children query")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	var records []childrenCountRecord
+	if err := stmt.SelectContext(ctx, &records, args...); err != nil {
+		return nil, errors.Wrap(err, "could not count children of actions")
+	}
+
+	result := make(map[model.UID]int, len(records))
+	for i := range records {
+		result[model.UID(records[i].ParentUID)] = records[i].Cnt
+	}
+
+	return result, nil
+}
+
+func selectActionsByParentUIDsQuery(parentUIDs []model.UID, limit int) (string, []interface{}, error) {
+	if len(parentUIDs) == 0 || limit <= 0 {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(SQLite)
+
+	return dialect.Select(
+		"id", "uid", "name",
+		hexHash,
+		"parent_uid", "actor_entity_id", "target_entity_id",
+		"is_async", "status",
+		"emitted_at", "registered_at",
+	).
+		From("actions").
+		Where(goqu.C("parent_uid").In(uidsToStrings(parentUIDs))).
+		Order(goqu.C("emitted_at").Asc(), goqu.C("id").Asc()).
+		Limit(uint(limit)).
+		Prepared(true).
+		ToSQL()
+}
+
+func countChildrenQuery(parentUIDs []model.UID) (string, []interface{}, error) {
+	if len(parentUIDs) == 0 {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(SQLite)
+
+	return dialect.Select("parent_uid", goqu.L("count(*)").As("cnt")).
+		From("actions").
+		Where(goqu.C("parent_uid").In(uidsToStrings(parentUIDs))).
+		GroupBy("parent_uid").
+		Prepared(true).
+		ToSQL()
+}
+
+func uidsToStrings(uids []model.UID) []string {
+	result := make([]string, len(uids))
+	for i := range uids {
+		result[i] = uids[i].String()
+	}
+
+	return result
+}
+
+// SelectChain - actions with IDs from fromID up to toID in the order they were chained,
+// toID of zero means up to the last action
+func (r *ActionRepository) SelectChain(ctx context.Context, fromID, toID model.ID, limit int) ([]model.Action, error) {
+	q, args, err := selectChainQuery(fromID, toID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := r.sqliteTx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare select chain query")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	var ars []actionRecord
+	if err := stmt.SelectContext(ctx, &ars, args...); err != nil {
+		return nil, errors.Wrapf(err, "could not select chain of actions from ID %d", fromID)
+	}
+
+	actions := make([]model.Action, len(ars))
+	for i := range ars {
+		actions[i] = *mapActionRecordToModel(ars[i])
+	}
+
+	return actions, nil
+}
+
+// SelectRegistered - at most limit actions following afterID registered from the given time
+// and before the other one, ordered by ID
+func (r *ActionRepository) SelectRegistered(
+	ctx context.Context,
+	from, to time.Time,
+	afterID model.ID,
+	limit int,
+) ([]model.Action, error) {
+	q, args, err := selectRegisteredActionsQuery(from, to, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := r.sqliteTx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare select registered actions query")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	var ars []actionRecord
+	if err := stmt.SelectContext(ctx, &ars, args...); err != nil {
+		return nil, errors.Wrapf(err, "could not select actions registered from %s after ID %d", from, afterID)
+	}
+
+	actions := make([]model.Action, len(ars))
+	for i := range ars {
+		actions[i] = *mapActionRecordToModel(ars[i])
+	}
+
+	return actions, nil
+}
+
+// ChainHashBefore - chain hash of the action preceding the given ID,
+// empty when there is no such action or it is not chained,
+// an action pruned by retention still precedes through its tombstone
+func (r *ActionRepository) ChainHashBefore(ctx context.Context, ID model.ID) (string, error) {
+	link, err := r.chainLinkBefore(ctx, "actions", "id", ID)
+	if err != nil {
+		return "", err
+	}
+
+	pruned, err := r.chainLinkBefore(ctx, "action_tombstones", "action_id", ID)
+	if err != nil {
+		return "", err
+	}
+
+	if pruned.ID > link.ID {
+		return pruned.ChainHash.String, nil
+	}
+
+	return link.ChainHash.String, nil
+}
+
+type chainLinkRecord struct {
+	ID        int            `db:"id"`
+	ChainHash sql.NullString `db:"chain_hash"`
+}
+
+func (r *ActionRepository) chainLinkBefore(ctx context.Context, table, column string, ID model.ID) (chainLinkRecord, error) {
+	var link chainLinkRecord
+
+	q, args, err := chainHashBeforeQuery(table, column, ID)
+	if err != nil {
+		return link, err
+	}
+
+	if err := r.sqliteTx.GetContext(ctx, &link, q, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return link, nil
+		}
+
+		return link, errors.Wrapf(err, "could not get chain hash preceding ID %d in %s", ID, table)
+	}
+
+	return link, nil
+}
+
+func lastChainHashQuery() (string, []interface{}, error) {
+	dialect := goqu.Dialect(SQLite)
+
+	return dialect.From("action_chain").
+		Select("last_hash").
+		Where(goqu.C("id").Eq(1)).
+		Prepared(true).
+		ToSQL()
+}
+
+func advanceChainQuery(chainHash string) (string, []interface{}, error) {
+	dialect := goqu.Dialect(SQLite)
+
+	return dialect.Update("action_chain").
+		Set(goqu.Record{"last_hash": chainHash}).
+		Where(goqu.C("id").Eq(1)).
+		Prepared(true).
+		ToSQL()
+}
+
+func selectChainQuery(fromID, toID model.ID, limit int) (string, []interface{}, error) {
+	if limit <= 0 {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(SQLite)
+
+	q := dialect.From("actions").Select(
+		"id", "uid", "parent_uid", "status", "is_async",
+		"actor_entity_id", "target_entity_id",
+		hexHash, "name", "details", "emitted_at", "registered_at",
+		"prev_chain_hash", "chain_hash",
+	).Where(goqu.C("id").Gte(fromID.Int64()))
+
+	if toID.Valid() {
+		q = q.Where(goqu.C("id").Lte(toID.Int64()))
+	}
+
+	return q.Order(goqu.C("id").Asc()).Limit(uint(limit)).Prepared(true).ToSQL()
+}
+
+func selectRegisteredActionsQuery(from, to time.Time, afterID model.ID, limit int) (string, []interface{}, error) {
+	if limit <= 0 || !from.Before(to) {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(SQLite)
+
+	q := dialect.From("actions").Select(
+		"id", "uid", "parent_uid", "status", "is_async",
+		"actor_entity_id", "target_entity_id",
+		hexHash, "name", "details", "emitted_at", "registered_at",
+		"prev_chain_hash", "chain_hash",
+	).Where(
+		goqu.C("id").Gt(afterID.Int64()),
+		goqu.C("registered_at").Gte(from.UTC()),
+		goqu.C("registered_at").Lt(to.UTC()),
+	)
+
+	return q.Order(goqu.C("id").Asc()).Limit(uint(limit)).Prepared(true).ToSQL()
+}
+
+func chainHashBeforeQuery(table, column string, ID model.ID) (string, []interface{}, error) {
+	dialect := goqu.Dialect(SQLite)
+
+	return dialect.From(table).
+		Select(goqu.C(column).As("id"), goqu.C("chain_hash")).
+		Where(goqu.C(column).Lt(ID.Int64())).
+		Order(goqu.C(column).Desc()).
+		Limit(1).
+		Prepared(true).
+		ToSQL()
+}
+
+// SelectVisibleIDs - IDs among the given ones of actions whose actor or target
+// entity belongs to one of the services
+func (r *ActionRepository) SelectVisibleIDs(ctx context.Context, IDs []model.ID, services []string) ([]model.ID, error) {
+	q, args, err := selectVisibleActionIDsQuery(IDs, services)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	if err := r.sqliteTx.SelectContext(ctx, &ids, q, args...); err != nil {
+		return nil, errors.Wrap(err, "could not select visible actions")
+	}
+
+	result := make([]model.ID, len(ids))
+	for i := range ids {
+		result[i] = model.ID(ids[i])
+	}
+
+	return result, nil
+}
+
+func selectVisibleActionIDsQuery(IDs []model.ID, services []string) (string, []interface{}, error) {
+	if len(IDs) == 0 || len(services) == 0 {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	ids := make([]int, len(IDs))
+	for i := range IDs {
+		ids[i] = int(IDs[i])
+	}
+
+	dialect := goqu.Dialect(SQLite)
+
+	return dialect.From("actions").
+		Select("id").
+		Where(goqu.C("id").In(ids), sqlstore.ActionServicesExpression(dialect, services)).
+		Prepared(true).ToSQL()
+}
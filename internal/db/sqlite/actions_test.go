@@ -0,0 +1,565 @@
+package sqlite
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/search"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActionRepository(t *testing.T) {
+	database := newTestDatabase(t)
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		ms, err := tx.Microservices().Create(ctx, &model.Microservice{Name: "billing"})
+		if err != nil {
+			return err
+		}
+
+		et, err := tx.EntityTypes().FirstOrCreateByNameAndServiceID(ctx, "invoice", ms.ID)
+		if err != nil {
+			return err
+		}
+
+		target, err := tx.Entities().FirstOrCreateByExternalIDAndEntityTypeID(ctx, "42", et.ID)
+		if err != nil {
+			return err
+		}
+
+		emittedAt := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+
+		parent, err := tx.Actions().Create(ctx, &model.Action{
+			UID:            "11111111111111111111111111111111",
+			Hash:           "9D5ED678FE57BCCA610140957AFAB571",
+			Name:           "invoice_created",
+			Status:         model.Pending,
+			IsAsync:        true,
+			TargetEntityID: target.ID,
+			EmittedAt:      model.JSONTime{Time: emittedAt},
+			RegisteredAt:   model.JSONTime{Time: emittedAt.Add(time.Second)},
+			Details:        map[string]interface{}{"amount": 100},
+		})
+		if !assert.NoError(t, err) {
+			return err
+		}
+
+		assert.True(t, parent.ID > 0)
+		assert.Equal(t, model.UID("11111111111111111111111111111111"), parent.UID)
+		assert.Equal(t, "9D5ED678FE57BCCA610140957AFAB571", parent.Hash)
+		assert.Equal(t, model.Pending, parent.Status)
+		assert.True(t, parent.IsAsync)
+		assert.Equal(t, target.ID, parent.TargetEntityID)
+		assert.True(t, emittedAt.Equal(parent.EmittedAt.Time))
+		assert.Equal(t, map[string]interface{}{"amount": float64(100)}, parent.Details)
+
+		child, err := tx.Actions().Create(ctx, &model.Action{
+			UID:          "22222222222222222222222222222222",
+			ParentUID:    parent.UID,
+			Name:         "invoice_paid",
+			Status:       model.Success,
+			EmittedAt:    model.JSONTime{Time: emittedAt.Add(time.Minute)},
+			RegisteredAt: model.JSONTime{Time: emittedAt.Add(time.Minute)},
+		})
+		if !assert.NoError(t, err) {
+			return err
+		}
+
+		assert.Equal(t, "", child.Hash)
+		assert.Nil(t, child.Details)
+
+		byUID, err := tx.Actions().FirstByUID(ctx, child.UID)
+		assert.NoError(t, err)
+		assert.Equal(t, child.ID, byUID.ID)
+		assert.Equal(t, parent.UID, byUID.ParentUID)
+
+		assert.NoError(t, tx.Actions().UpdateStatus(ctx, parent.ID, model.Success))
+		updated, err := tx.Actions().FirstByID(ctx, parent.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, model.Success, updated.Status)
+
+		names, err := tx.Actions().Names(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"invoice_created", "invoice_paid"}, names)
+
+		cnt, err := tx.Actions().CountAll(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, cnt)
+
+		c := db.NewCursor(1, 10, []string{"name"})
+		f := db.NewFilter([]string{"targetEntityId"}).Add("targetEntityId", strconv.Itoa(target.ID.Int()))
+
+		actions, err := tx.Actions().Select(ctx, c, f)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, *actions.Meta.Total)
+		if assert.Len(t, actions.Items, 1) {
+			assert.Equal(t, parent.ID, actions.Items[0].ID)
+		}
+
+		assert.NoError(t, tx.Actions().Delete(ctx, child.ID))
+		_, err = tx.Actions().FirstByID(ctx, child.ID)
+		assert.Equal(t, db.ErrNotFound, err)
+
+		return nil
+	})
+}
+
+func TestActionRepository_KeysetPagination(t *testing.T) {
+	database := newTestDatabase(t)
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		registeredAt := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+
+		var IDs []model.ID
+		for i := 0; i < 5; i++ {
+			// the last two actions share the registration time, the ID breaks the tie
+			at := registeredAt.Add(time.Duration(i) * time.Minute)
+			if i == 4 {
+				at = registeredAt.Add(3 * time.Minute)
+			}
+
+			a, err := tx.Actions().Create(ctx, &model.Action{
+				UID:          model.UID(strconv.Itoa(i) + "1111111111111111111111111111111"),
+				Name:         "invoice_created",
+				Status:       model.Success,
+				EmittedAt:    model.JSONTime{Time: at},
+				RegisteredAt: model.JSONTime{Time: at},
+			})
+			if !assert.NoError(t, err) {
+				return err
+			}
+
+			IDs = append(IDs, a.ID)
+		}
+
+		idsOf := func(items []model.Action) []model.ID {
+			var result []model.ID
+			for i := range items {
+				result = append(result, items[i].ID)
+			}
+			return result
+		}
+
+		c := db.NewCursor(1, 2, nil)
+		first, err := tx.Actions().Select(ctx, c, db.NewFilter(nil))
+		if !assert.NoError(t, err) {
+			return err
+		}
+
+		assert.Equal(t, []model.ID{IDs[4], IDs[3]}, idsOf(first.Items))
+		assert.Equal(t, 5, *first.Meta.Total)
+		assert.NotEmpty(t, first.Meta.Next)
+		assert.Empty(t, first.Meta.Prev)
+
+		after, err := db.ParseKeyset(first.Meta.Next)
+		if !assert.NoError(t, err) {
+			return err
+		}
+
+		c = db.NewCursor(1, 2, nil)
+		c.After = after
+		c.SkipTotal = true
+		second, err := tx.Actions().Select(ctx, c, db.NewFilter(nil))
+		if !assert.NoError(t, err) {
+			return err
+		}
+
+		assert.Equal(t, []model.ID{IDs[2], IDs[1]}, idsOf(second.Items))
+		assert.Nil(t, second.Meta.Total)
+		assert.NotEmpty(t, second.Meta.Next)
+		assert.NotEmpty(t, second.Meta.Prev)
+
+		after, err = db.ParseKeyset(second.Meta.Next)
+		if !assert.NoError(t, err) {
+			return err
+		}
+
+		c = db.NewCursor(1, 2, nil)
+		c.After = after
+		last, err := tx.Actions().Select(ctx, c, db.NewFilter(nil))
+		if !assert.NoError(t, err) {
+			return err
+		}
+
+		assert.Equal(t, []model.ID{IDs[0]}, idsOf(last.Items))
+		assert.Empty(t, last.Meta.Next)
+
+		before, err := db.ParseKeyset(second.Meta.Prev)
+		if !assert.NoError(t, err) {
+			return err
+		}
+
+		c = db.NewCursor(1, 2, nil)
+		c.Before = before
+		back, err := tx.Actions().Select(ctx, c, db.NewFilter(nil))
+		if !assert.NoError(t, err) {
+			return err
+		}
+
+		assert.Equal(t, []model.ID{IDs[4], IDs[3]}, idsOf(back.Items))
+		assert.Empty(t, back.Meta.Prev)
+		assert.NotEmpty(t, back.Meta.Next)
+
+		return nil
+	})
+}
+
+func TestActionRepository_Chain(t *testing.T) {
+	database := newTestDatabase(t)
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		emittedAt := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+		uids := []model.UID{
+			"11111111111111111111111111111111",
+			"22222222222222222222222222222222",
+			"33333333333333333333333333333333",
+		}
+
+		created := make([]*model.Action, len(uids))
+		for i := range uids {
+			a, err := tx.Actions().Create(ctx, &model.Action{
+				UID:          uids[i],
+				Name:         "invoice_updated",
+				Status:       model.Success,
+				EmittedAt:    model.JSONTime{Time: emittedAt.Add(time.Duration(i) * time.Minute)},
+				RegisteredAt: model.JSONTime{Time: emittedAt.Add(time.Duration(i)*time.Minute + 250*time.Millisecond)},
+				Details:      map[string]interface{}{"step": i},
+			})
+			if err != nil {
+				return err
+			}
+
+			created[i] = a
+		}
+
+		assert.Equal(t, model.GenesisChainHash, created[0].PrevChainHash)
+		assert.Equal(t, created[0].ChainHash, created[1].PrevChainHash)
+		assert.Equal(t, created[1].ChainHash, created[2].PrevChainHash)
+
+		expected, err := model.ComputeChainHash(created[1].PrevChainHash, created[1])
+		assert.NoError(t, err)
+		assert.Equal(t, expected, created[1].ChainHash)
+
+		chain, err := tx.Actions().SelectChain(ctx, created[1].ID, 0, 10)
+		assert.NoError(t, err)
+		if assert.Len(t, chain, 2) {
+			assert.Equal(t, created[1].ID, chain[0].ID)
+			assert.Equal(t, created[2].ChainHash, chain[1].ChainHash)
+			assert.Equal(t, map[string]interface{}{"step": float64(2)}, chain[1].Details)
+		}
+
+		chain, err = tx.Actions().SelectChain(ctx, created[0].ID, created[1].ID, 10)
+		assert.NoError(t, err)
+		assert.Len(t, chain, 2)
+
+		before, err := tx.Actions().ChainHashBefore(ctx, created[2].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, created[1].ChainHash, before)
+
+		before, err = tx.Actions().ChainHashBefore(ctx, created[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "", before)
+
+		return nil
+	})
+}
+
+func TestActionRepository_ScopedByServices(t *testing.T) {
+	database := newTestDatabase(t)
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		entityOf := func(service, entityType string) *model.Entity {
+			ms, err := tx.Microservices().Create(ctx, &model.Microservice{Name: service})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			et, err := tx.EntityTypes().FirstOrCreateByNameAndServiceID(ctx, entityType, ms.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			e, err := tx.Entities().FirstOrCreateByExternalIDAndEntityTypeID(ctx, "1", et.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return e
+		}
+
+		user := entityOf("accounts", "user")
+		invoice := entityOf("billing", "invoice")
+		order := entityOf("orders", "order")
+
+		emittedAt := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+		create := func(uid string, actor, target *model.Entity) model.ID {
+			a, err := tx.Actions().Create(ctx, &model.Action{
+				UID:            model.UID(uid),
+				Name:           "changed",
+				ActorEntityID:  actor.ID,
+				TargetEntityID: target.ID,
+				EmittedAt:      model.JSONTime{Time: emittedAt},
+				RegisteredAt:   model.JSONTime{Time: emittedAt},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return a.ID
+		}
+
+		paid := create("11111111111111111111111111111111", user, invoice)
+		shipped := create("22222222222222222222222222222222", user, order)
+		billed := create("33333333333333333333333333333333", order, invoice)
+
+		c := &db.Cursor{Page: 1, PerPage: 10, Sort: db.NewSort(nil)}
+		billing, err := tx.Actions().Select(ctx, c, db.NewFilter(nil).ByServices([]string{"billing"}))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, *billing.Meta.Total)
+
+		visible, err := tx.Actions().SelectVisibleIDs(ctx, []model.ID{paid, shipped, billed}, []string{"billing"})
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []model.ID{paid, billed}, visible)
+
+		visible, err = tx.Actions().SelectVisibleIDs(ctx, []model.ID{shipped}, []string{"accounts"})
+		assert.NoError(t, err)
+		assert.Equal(t, []model.ID{shipped}, visible)
+
+		return nil
+	})
+}
+
+func TestActionRepository_Filters(t *testing.T) {
+	database := newTestDatabase(t)
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		entityOf := func(service, entityType string) *model.Entity {
+			ms, err := tx.Microservices().FirstOrCreateByName(ctx, service)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			et, err := tx.EntityTypes().FirstOrCreateByNameAndServiceID(ctx, entityType, ms.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			e, err := tx.Entities().FirstOrCreateByExternalIDAndEntityTypeID(ctx, "1", et.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return e
+		}
+
+		user := entityOf("accounts", "user")
+		invoice := entityOf("billing", "invoice")
+		refund := entityOf("billing", "refund")
+
+		emittedAt := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+		create := func(uid, name string, status model.Status, async bool, target *model.Entity, day int) model.ID {
+			a, err := tx.Actions().Create(ctx, &model.Action{
+				UID:            model.UID(uid),
+				Name:           name,
+				Status:         status,
+				IsAsync:        async,
+				ActorEntityID:  user.ID,
+				TargetEntityID: target.ID,
+				EmittedAt:      model.JSONTime{Time: emittedAt.AddDate(0, 0, day)},
+				RegisteredAt:   model.JSONTime{Time: emittedAt.AddDate(0, 0, day)},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return a.ID
+		}
+
+		created := create("11111111111111111111111111111111", "invoice_created", model.Success, false, invoice, 0)
+		paid := create("22222222222222222222222222222222", "invoice_paid", model.Failed, true, invoice, 1)
+		refunded := create("33333333333333333333333333333333", "invoiceXrefunded", model.Pending, true, refund, 2)
+
+		selectIDs := func(f *db.Filter) []model.ID {
+			actions, err := tx.Actions().Select(ctx, db.NewCursor(1, 10, nil), f)
+			if !assert.NoError(t, err) {
+				return nil
+			}
+
+			var IDs []model.ID
+			for i := range actions.Items {
+				IDs = append(IDs, actions.Items[i].ID)
+			}
+			return IDs
+		}
+
+		success, pending := strconv.Itoa(int(model.Success)), strconv.Itoa(int(model.Pending))
+		assert.Equal(t, []model.ID{refunded, created}, selectIDs(db.NewFilter(nil).Where("status", db.InOperator, success, pending)))
+		assert.Equal(t, []model.ID{paid, created}, selectIDs(db.NewFilter(nil).Where("name", db.PrefixOperator, "invoice_")))
+		assert.Equal(t, []model.ID{refunded, paid}, selectIDs(db.NewFilter(nil).Add("isAsync", "true")))
+		assert.Equal(t, []model.ID{refunded}, selectIDs(db.NewFilter(nil).Add("targetEntityType", "refund")))
+		assert.Equal(t, []model.ID{refunded, paid, created}, selectIDs(db.NewFilter(nil).Add("targetService", "billing")))
+		assert.Nil(t, selectIDs(db.NewFilter(nil).Add("actorService", "billing")))
+
+		from := emittedAt.AddDate(0, 0, 1).Format(time.RFC3339Nano)
+		assert.Equal(t, []model.ID{paid}, selectIDs(db.NewFilter(nil).
+			Where("emittedAt", db.GteOperator, from).
+			Where("registeredAt", db.LteOperator, from)))
+
+		_, err := tx.Actions().Select(ctx, db.NewCursor(1, 10, nil), db.NewFilter(nil).Where("status", db.InOperator, "1", "x"))
+		assert.Equal(t, db.ErrInvalidQueryInput, errors.Cause(err))
+
+		return nil
+	})
+}
+
+func TestActionRepository_Search(t *testing.T) {
+	database := newTestDatabase(t)
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		emittedAt := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+		create := func(uid string, details map[string]interface{}, delta model.Delta) model.ID {
+			a, err := tx.Actions().Create(ctx, &model.Action{
+				UID:          model.UID(uid),
+				Name:         "order_changed",
+				Status:       model.Success,
+				Details:      details,
+				EmittedAt:    model.JSONTime{Time: emittedAt},
+				RegisteredAt: model.JSONTime{Time: emittedAt},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tx.Deltas().Create(ctx, a.ID, 0, delta); err != nil {
+				t.Fatal(err)
+			}
+
+			return a.ID
+		}
+
+		placed := create(
+			"11111111111111111111111111111111",
+			map[string]interface{}{"order": map[string]interface{}{"id": 48213, "note": "gift_wrap"}, "paid": false},
+			model.Delta{{PropertyName: "price", CurrentPropertyType: model.FloatProperty, From: nil, To: 120.5}},
+		)
+		repriced := create(
+			"22222222222222222222222222222222",
+			map[string]interface{}{"order": map[string]interface{}{"id": 48214}, "paid": true},
+			model.Delta{{PropertyName: "price", CurrentPropertyType: model.FloatProperty, From: 120.5, To: 99}},
+		)
+
+		searchIDs := func(query string) []model.ID {
+			q, err := search.Parse(query)
+			if !assert.NoError(t, err) {
+				return nil
+			}
+
+			actions, err := tx.Actions().Search(ctx, q, db.NewCursor(1, 10, nil), db.NewFilter(nil))
+			if !assert.NoError(t, err) {
+				return nil
+			}
+
+			var IDs []model.ID
+			for i := range actions.Items {
+				IDs = append(IDs, actions.Items[i].ID)
+			}
+			return IDs
+		}
+
+		assert.Equal(t, []model.ID{placed}, searchIDs("48213"))
+		assert.Equal(t, []model.ID{placed}, searchIDs("gift_"))
+		assert.Nil(t, searchIDs("gift%"))
+		assert.Equal(t, []model.ID{repriced}, searchIDs("details.order.id = 48214"))
+		assert.Equal(t, []model.ID{repriced, placed}, searchIDs("details.order.id >= 48213"))
+		assert.Equal(t, []model.ID{repriced}, searchIDs("details.paid = true"))
+		assert.Equal(t, []model.ID{repriced}, searchIDs("details.order.note = null"))
+		assert.Equal(t, []model.ID{placed}, searchIDs("delta.price.to > 100"))
+		assert.Equal(t, []model.ID{repriced}, searchIDs("delta.price.from = 120.5 and details.order.id < 50000"))
+		assert.Nil(t, searchIDs("delta.price.to > 100 details.paid = true"))
+
+		return nil
+	})
+}
+
+func TestActionRepository_Stats(t *testing.T) {
+	database := newTestDatabase(t)
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		ms, err := tx.Microservices().FirstOrCreateByName(ctx, "billing")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		et, err := tx.EntityTypes().FirstOrCreateByNameAndServiceID(ctx, "invoice", ms.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		invoice, err := tx.Entities().FirstOrCreateByExternalIDAndEntityTypeID(ctx, "1", et.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+		create := func(uid, name string, status model.Status, actor model.ID, registeredAt time.Time) {
+			if _, err := tx.Actions().Create(ctx, &model.Action{
+				UID:           model.UID(uid),
+				Name:          name,
+				Status:        status,
+				ActorEntityID: actor,
+				EmittedAt:     model.JSONTime{Time: registeredAt},
+				RegisteredAt:  model.JSONTime{Time: registeredAt},
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		create("11111111111111111111111111111111", "invoice_created", model.Success, invoice.ID, start.Add(5*time.Minute))
+		create("22222222222222222222222222222222", "invoice_paid", model.Success, invoice.ID, start.Add(55*time.Minute+500*time.Millisecond))
+		create("33333333333333333333333333333333", "invoice_created", model.Failed, 0, start.Add(2*time.Hour))
+		create("44444444444444444444444444444444", "invoice_created", model.Success, invoice.ID, start.Add(3*time.Hour))
+
+		q := &model.ActionStatsQuery{From: start, To: start.Add(3 * time.Hour), Interval: model.HourInterval}
+
+		counts, err := tx.Actions().Stats(ctx, q, db.NewFilter(nil))
+		if !assert.NoError(t, err) {
+			return nil
+		}
+
+		assert.ElementsMatch(t, []model.ActionStatsCount{
+			{Bucket: start, Count: 2},
+			{Bucket: start.Add(2 * time.Hour), Count: 1},
+		}, counts)
+
+		q.GroupBy = model.GroupByActorService
+		counts, err = tx.Actions().Stats(ctx, q, db.NewFilter(nil).Add("name", "invoice_created"))
+		if !assert.NoError(t, err) {
+			return nil
+		}
+
+		assert.ElementsMatch(t, []model.ActionStatsCount{
+			{Bucket: start, Group: "billing", Count: 1},
+			{Bucket: start.Add(2 * time.Hour), Group: "", Count: 1},
+		}, counts)
+
+		q.GroupBy, q.Interval = model.GroupByStatus, model.DayInterval
+		counts, err = tx.Actions().Stats(ctx, q, db.NewFilter(nil))
+		if !assert.NoError(t, err) {
+			return nil
+		}
+
+		success, failed := strconv.Itoa(int(model.Success)), strconv.Itoa(int(model.Failed))
+		day := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+		assert.ElementsMatch(t, []model.ActionStatsCount{
+			{Bucket: day, Group: success, Count: 2},
+			{Bucket: day, Group: failed, Count: 1},
+		}, counts)
+
+		return nil
+	})
+}
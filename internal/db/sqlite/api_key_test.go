@@ -0,0 +1,73 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyRepository(t *testing.T) {
+	database := newTestDatabase(t)
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		foo, err := tx.Microservices().Create(ctx, &model.Microservice{Name: "foo"})
+		if err != nil {
+			return err
+		}
+
+		bar, err := tx.Microservices().Create(ctx, &model.Microservice{Name: "bar"})
+		if err != nil {
+			return err
+		}
+
+		created, err := tx.APIKeys().Create(ctx, &model.APIKey{
+			Name:     "billing",
+			Prefix:   "0a1b2c3d",
+			Hash:     model.HashAPIKey("0a1b2c3d-secret"),
+			Services: []model.Microservice{*foo, *bar},
+		})
+		if !assert.NoError(t, err) {
+			return err
+		}
+
+		assert.True(t, created.ID > 0)
+		assert.Equal(t, "0a1b2c3d", created.Prefix)
+		assert.Equal(t, []string{"bar", "foo"}, created.ServiceNames())
+		assert.False(t, created.CreatedAt.IsZero())
+		assert.False(t, created.Revoked())
+
+		byHash, err := tx.APIKeys().FirstByHash(ctx, model.HashAPIKey("0a1b2c3d-secret"))
+		assert.NoError(t, err)
+		assert.Equal(t, created.ID, byHash.ID)
+		assert.True(t, byHash.Allows("foo"))
+		assert.False(t, byHash.Allows("baz"))
+
+		rotatedAt := time.Date(2021, 4, 1, 10, 0, 0, 0, time.UTC)
+		assert.NoError(t, tx.APIKeys().Rotate(ctx, created.ID, "9f8e7d6c", model.HashAPIKey("9f8e7d6c-secret"), rotatedAt))
+
+		_, err = tx.APIKeys().FirstByHash(ctx, model.HashAPIKey("0a1b2c3d-secret"))
+		assert.Equal(t, db.ErrNotFound, err)
+
+		rotated, err := tx.APIKeys().FirstByHash(ctx, model.HashAPIKey("9f8e7d6c-secret"))
+		assert.NoError(t, err)
+		assert.Equal(t, "9f8e7d6c", rotated.Prefix)
+		assert.Equal(t, rotatedAt, rotated.RotatedAt.UTC())
+
+		assert.NoError(t, tx.APIKeys().Revoke(ctx, created.ID, rotatedAt.Add(time.Hour)))
+		assert.Equal(t, db.ErrNotFound, tx.APIKeys().Revoke(ctx, created.ID, rotatedAt.Add(time.Hour)))
+		assert.Equal(t, db.ErrNotFound, tx.APIKeys().Rotate(ctx, created.ID, "11111111", model.HashAPIKey("1"), rotatedAt))
+
+		all, err := tx.APIKeys().Select(ctx)
+		assert.NoError(t, err)
+		if assert.Len(t, all, 1) {
+			assert.True(t, all[0].Revoked())
+			assert.Len(t, all[0].Services, 2)
+		}
+
+		return nil
+	})
+}
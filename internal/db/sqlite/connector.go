@@ -0,0 +1,50 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ConnectAndMigrate - opens SQLite database file, or an in-memory database for :memory: DSN,
+// SQLite allows a single writer at a time, so the pool is limited to one connection,
+// that also keeps in-memory database alive for the whole life of the connection
+func ConnectAndMigrate(ctx context.Context, lg logger.Logger, dsn string) (*sqlx.DB, error) {
+	sqlConn, err := sql.Open(driverName, withForeignKeys(dsn))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open SQLite database %s", dsn)
+	}
+
+	conn := sqlx.NewDb(sqlConn, SQLite)
+
+	conn.SetMaxOpenConns(1)
+	conn.SetMaxIdleConns(1)
+
+	if err := conn.PingContext(ctx); err != nil {
+		return nil, errors.Wrapf(err, "could not connect to SQLite database %s", dsn)
+	}
+
+	lg.Debugf("connection with DB established")
+
+	if err := Migrator(conn, lg).Up(); err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func withForeignKeys(dsn string) string {
+	if strings.Contains(dsn, "_foreign_keys=") || strings.Contains(dsn, "_fk=") {
+		return dsn
+	}
+
+	if strings.Contains(dsn, "?") {
+		return dsn + "&_foreign_keys=1"
+	}
+
+	return dsn + "?_foreign_keys=1"
+}
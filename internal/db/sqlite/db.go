@@ -0,0 +1,149 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/sqlstore"
+	"github.com/denismitr/auditbase/internal/metrics"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+const SQLite = "sqlite3"
+
+type Database struct {
+	conn *sqlx.DB
+	lg   logger.Logger
+}
+
+var _ db.Database = (*Database)(nil)
+
+func NewDatabase(conn *sqlx.DB, lg logger.Logger) *Database {
+	return &Database{
+		conn: conn,
+		lg:   lg,
+	}
+}
+
+type Tx struct {
+	sqliteTx *sqlx.Tx
+	lg       logger.Logger
+}
+
+var _ db.Tx = (*Tx)(nil)
+
+// Ping - verifies the connection to the database is still alive
+func (db *Database) Ping(ctx context.Context) error {
+	if err := db.conn.PingContext(ctx); err != nil {
+		return errors.Wrap(err, "could not ping sqlite")
+	}
+
+	return nil
+}
+
+func (db *Database) ReadOnly(ctx context.Context, cb db.TxCallback) (result interface{}, err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveTx(SQLite, metrics.ReadOnly, start, err) }()
+
+	sqliteTx, err := db.conn.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelSerializable})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not start read only Tx")
+	}
+
+	result, err = cb(ctx, &Tx{sqliteTx: sqliteTx, lg: db.lg})
+	if err != nil {
+		if rbErr := sqliteTx.Rollback(); rbErr != nil {
+			return nil, errors.Wrap(err, rbErr.Error())
+		}
+
+		return nil, err
+	}
+
+	if err := sqliteTx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "could not commit read only Tx")
+	}
+
+	return result, nil
+}
+
+func (db *Database) ReadWrite(ctx context.Context, cb db.TxCallback) (result interface{}, err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveTx(SQLite, metrics.ReadWrite, start, err) }()
+
+	sqliteTx, err := db.conn.BeginTxx(ctx, &sql.TxOptions{ReadOnly: false, Isolation: sql.LevelSerializable})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not start read write Tx")
+	}
+
+	result, err = cb(ctx, &Tx{sqliteTx: sqliteTx, lg: db.lg})
+	if err != nil {
+		if rbErr := sqliteTx.Rollback(); rbErr != nil {
+			return nil, errors.Wrap(err, rbErr.Error())
+		}
+
+		return nil, err
+	}
+
+	if err := sqliteTx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "could not commit read write Tx")
+	}
+
+	return result, nil
+}
+
+// store - the transaction for the repositories shared by the SQL backends
+func (tx *Tx) store() *sqlstore.Tx {
+	return sqlstore.NewTx(tx.sqliteTx, sqlstore.SQLite)
+}
+
+func (tx *Tx) Entities() db.EntityRepository {
+	return &EntityRepository{Tx: tx}
+}
+
+func (tx *Tx) EntityTypes() db.EntityTypeRepository {
+	return &EntityTypeRepository{Tx: tx}
+}
+
+func (tx *Tx) Microservices() db.MicroserviceRepository {
+	return &MicroserviceRepository{Tx: tx}
+}
+
+func (tx *Tx) Actions() db.ActionRepository {
+	return &ActionRepository{Tx: tx, ActionRepository: &sqlstore.ActionRepository{Tx: tx.store()}}
+}
+
+func (tx *Tx) Deltas() db.ActionDeltaRepository {
+	return &sqlstore.ActionDeltaRepository{Tx: tx.store()}
+}
+
+func (tx *Tx) APIKeys() db.APIKeyRepository {
+	return &sqlstore.APIKeyRepository{Tx: tx.store()}
+}
+
+func (tx *Tx) SigningSecrets() db.SigningSecretRepository {
+	return &sqlstore.SigningSecretRepository{Tx: tx.store()}
+}
+
+func (tx *Tx) AccessLog() db.AccessLogRepository {
+	return &sqlstore.AccessLogRepository{Tx: tx.store()}
+}
+
+func (tx *Tx) RetentionRules() db.RetentionRuleRepository {
+	return &sqlstore.RetentionRuleRepository{Tx: tx.store()}
+}
+
+func (tx *Tx) ActionPartitions() db.ActionPartitionRepository {
+	return &ActionPartitionRepository{Tx: tx}
+}
+
+func (tx *Tx) Webhooks() db.WebhookRepository {
+	return &sqlstore.WebhookRepository{Tx: tx.store()}
+}
+
+func (tx *Tx) Timeouts() db.TimeoutRepository {
+	return &sqlstore.TimeoutRepository{Tx: tx.store()}
+}
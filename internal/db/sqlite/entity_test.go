@@ -0,0 +1,70 @@
+package sqlite
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntityRepository(t *testing.T) {
+	database := newTestDatabase(t)
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		ms, err := tx.Microservices().Create(ctx, &model.Microservice{Name: "users"})
+		if err != nil {
+			return err
+		}
+
+		et, err := tx.EntityTypes().FirstOrCreateByNameAndServiceID(ctx, "user", ms.ID)
+		if err != nil {
+			return err
+		}
+
+		first, err := tx.Entities().FirstOrCreateByExternalIDAndEntityTypeID(ctx, "1", et.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "1", first.ExternalID)
+		assert.Equal(t, et.ID, first.EntityTypeID)
+		assert.Equal(t, "user", first.EntityType.Name)
+		assert.Equal(t, "users", first.EntityType.Service.Name)
+
+		same, err := tx.Entities().FirstOrCreateByExternalIDAndEntityTypeID(ctx, "1", et.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, first.ID, same.ID)
+
+		_, err = tx.Entities().Create(ctx, &model.Entity{ExternalID: "2", EntityTypeID: et.ID})
+		assert.NoError(t, err)
+
+		withType, err := tx.Entities().FirstByIDWithEntityType(ctx, first.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, withType.EntityType.EntitiesCnt)
+
+		_, err = tx.Entities().FirstByID(ctx, model.ID(1000))
+		assert.Equal(t, db.ErrNotFound, err)
+
+		c := db.NewCursor(1, 1, []string{"externalId"})
+		c.Sort.Add("externalId", db.DESCOrder)
+		f := db.NewFilter([]string{"entityTypeId"}).Add("entityTypeId", strconv.Itoa(et.ID.Int()))
+
+		entities, err := tx.Entities().Select(ctx, c, f)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, *entities.Meta.Total)
+		if assert.Len(t, entities.Items, 1) {
+			assert.Equal(t, "2", entities.Items[0].ExternalID)
+		}
+
+		scoped, err := tx.Entities().Select(ctx, db.NewCursor(1, 10, nil), db.NewFilter(nil).ByServices([]string{"users"}))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, *scoped.Meta.Total)
+
+		scoped, err = tx.Entities().Select(ctx, db.NewCursor(1, 10, nil), db.NewFilter(nil).ByServices([]string{"orders"}))
+		assert.NoError(t, err)
+		assert.Equal(t, 0, *scoped.Meta.Total)
+		assert.Empty(t, scoped.Items)
+
+		return nil
+	})
+}
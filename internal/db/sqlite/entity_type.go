@@ -0,0 +1,373 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"unicode/utf8"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/sqlstore"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+type entityTypeRecord struct {
+	ID          int       `db:"id"`
+	Name        string    `db:"name"`
+	ServiceID   int       `db:"service_id"`
+	Description string    `db:"description"`
+	EntitiesCnt int       `db:"entities_cnt"`
+	CreatedAt   time.Time `db:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at"`
+}
+
+type EntityTypeRepository struct {
+	*Tx
+}
+
+// static check of correct interface implementation
+var _ db.EntityTypeRepository = (*EntityTypeRepository)(nil)
+
+func (r *EntityTypeRepository) Select(
+	ctx context.Context,
+	cursor *db.Cursor,
+	filter *db.Filter,
+) (*model.EntityTypeCollection, error) {
+	var entityTypes []entityTypeRecord
+
+	sQ, err := selectEntityTypesQuery(cursor, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := r.sqliteTx.PreparexContext(ctx, sQ.SelectSQL)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare selectSql to select entityTypes")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	cntStmt, err := r.sqliteTx.PreparexContext(ctx, sQ.CountSQL)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare selectSql to count entityTypes")
+	}
+
+	defer func() { _ = cntStmt.Close() }()
+
+	if err := stmt.SelectContext(ctx, &entityTypes, sQ.SelectArgs...); err != nil {
+		return nil, errors.Wrap(err, "could not execute selectSql to select entityTypes")
+	}
+
+	var cnt int
+	if err := cntStmt.GetContext(ctx, &cnt, sQ.CountArgs...); err != nil {
+		return nil, errors.Wrap(err, "could not execute selectSql to count entityTypes")
+	}
+
+	var page, perPage uint
+	if cursor != nil {
+		page, perPage = cursor.Page, cursor.PerPage
+	}
+
+	return mapEntityTypesToCollection(entityTypes, cnt, page, perPage), nil
+}
+
+func (r *EntityTypeRepository) Create(ctx context.Context, e *model.EntityType) (*model.EntityType, error) {
+	q, args, err := createEntityTypeQuery(e.ServiceID, e.Name, e.Description, e.IsActor)
+	if err != nil {
+		panic(errors.Wrap(err, "how could createEntityTypeQuery func fail?"))
+	}
+
+	stmt, err := r.sqliteTx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare tx to create entity type")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	result, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create new entity type with name %s", e.Name)
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not retrieve last insert ID")
+	}
+
+	return r.FirstByID(ctx, model.ID(newID))
+}
+
+func (r *EntityTypeRepository) FirstOrCreateByNameAndServiceID(
+	ctx context.Context,
+	name string,
+	serviceID model.ID,
+) (*model.EntityType, error) {
+	if ent, err := r.FirstByNameAndServiceID(ctx, name, serviceID); err == nil {
+		return ent, nil
+	} else if err != db.ErrNotFound {
+		return nil, err
+	}
+
+	newEntity := &model.EntityType{
+		Name:      name,
+		ServiceID: serviceID,
+	}
+
+	created, err := r.Create(ctx, newEntity)
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"entityTypeRecord with name [%s] and service ID [%d] does not exist and could not be created",
+			name,
+			serviceID,
+		)
+	}
+
+	return created, nil
+}
+
+func (r *EntityTypeRepository) FirstByID(ctx context.Context, ID model.ID) (*model.EntityType, error) {
+	return firstEntityTypeByID(ctx, r.sqliteTx, ID)
+}
+
+func firstEntityTypeByID(ctx context.Context, tx *sqlx.Tx, ID model.ID) (*model.EntityType, error) {
+	q, args, err := firstEntityTypeByIDQuery(ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ent := entityTypeRecord{}
+
+	stmt, err := tx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not prepare selectSql statement %s to get entityRecord by id", q)
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	if err := stmt.GetContext(ctx, &ent, args...); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, db.ErrNotFound
+		default:
+			return nil, errors.Wrapf(err, "could not find entities with ID %d", ID)
+		}
+	}
+
+	return mapEntityTypeRecordToModel(ent), nil
+}
+
+func (r *EntityTypeRepository) FirstByNameAndServiceID(
+	ctx context.Context,
+	name string,
+	serviceID model.ID,
+) (*model.EntityType, error) {
+	q, args, err := firstEntityTypeByNameAndServiceIDQuery(name, serviceID)
+	if err != nil {
+		panic("how could firstEntityTypeByNameAndServiceIDQuery func fail?")
+	}
+
+	ent := entityTypeRecord{}
+
+	stmt, err := r.sqliteTx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not prepare selectSql statement %s to get entityRecord by name and service ID", q)
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	if err := stmt.GetContext(ctx, &ent, args...); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, db.ErrNotFound
+		default:
+			return nil, errors.Wrapf(err, "could not find entity with name %s and serviceID %d", name, serviceID)
+		}
+	}
+
+	return mapEntityTypeRecordToModel(ent), nil
+}
+
+func entityTypeWithCountSelect() *goqu.SelectDataset {
+	dialect := goqu.Dialect(SQLite)
+
+	return dialect.Select(
+		goqu.I("et.id").As("id"),
+		goqu.I("et.service_id").As("service_id"),
+		goqu.I("et.name").As("name"),
+		goqu.I("et.description").As("description"),
+		goqu.I("et.created_at").As("created_at"),
+		goqu.I("et.updated_at").As("updated_at"),
+		goqu.L("count(distinct `e`.`id`)").As("entities_cnt"),
+	).
+		From(goqu.T("entity_types").As("et")).
+		LeftJoin(goqu.T("entities").As("e"), goqu.On(goqu.Ex{"e.entity_type_id": goqu.I("et.id")})).
+		GroupBy(
+			goqu.I("et.id"),
+			goqu.I("et.service_id"),
+			goqu.I("et.name"),
+			goqu.I("et.description"),
+			goqu.I("et.created_at"),
+			goqu.I("et.updated_at"),
+		)
+}
+
+func firstEntityTypeByIDQuery(ID model.ID) (string, []interface{}, error) {
+	return entityTypeWithCountSelect().
+		Where(goqu.I("et.id").Eq(int(ID))).
+		Limit(1).
+		Prepared(true).
+		ToSQL()
+}
+
+func selectEntityTypesQuery(c *db.Cursor, f *db.Filter) (*sqlstore.SelectQuery, error) {
+	dialect := goqu.Dialect(SQLite)
+
+	countQ := dialect.Select(goqu.L("count(*)").As("cnt")).From(goqu.T("entity_types").As("et"))
+	q := entityTypeWithCountSelect()
+
+	if f.Has("serviceId") {
+		exp := goqu.I("et.service_id").Eq(f.IntOrDefault("serviceId", 0))
+		countQ = countQ.Where(exp)
+		q = q.Where(exp)
+	}
+
+	if f.Has("name") {
+		exp := goqu.I("et.name").Eq(f.MustString("name"))
+		countQ = countQ.Where(exp)
+		q = q.Where(exp)
+	}
+
+	if c != nil {
+		if c.Sort.Has("name") {
+			if c.Sort.GetOrDefault("name", db.ASCOrder) == db.ASCOrder {
+				q = q.Order(goqu.I("et.name").Asc())
+			} else {
+				q = q.Order(goqu.I("et.name").Desc())
+			}
+		} else {
+			q = q.Order(goqu.I("et.updated_at").Desc())
+		}
+
+		q = q.Limit(c.PerPage)
+		q = q.Offset(c.Offset())
+	} else {
+		q = q.Order(goqu.I("et.updated_at").Desc())
+	}
+
+	sQ := sqlstore.SelectQuery{}
+	if queryStr, args, err := q.Prepared(true).ToSQL(); err != nil {
+		return nil, errors.Wrap(err, "invalid select SQL for entity types")
+	} else {
+		sQ.SelectSQL = queryStr
+		sQ.SelectArgs = args
+	}
+
+	if queryStr, args, err := countQ.Prepared(true).ToSQL(); err != nil {
+		return nil, errors.Wrap(err, "invalid count SQL for entity types")
+	} else {
+		sQ.CountSQL = queryStr
+		sQ.CountArgs = args
+	}
+
+	return &sQ, nil
+}
+
+func createEntityTypeQuery(serviceID model.ID, name, description string, isActor bool) (string, []interface{}, error) {
+	if !utf8.ValidString(name) {
+		panic("how could name not be a valid ut8 string")
+	}
+
+	dialect := goqu.Dialect(SQLite)
+
+	q := dialect.Insert(goqu.T("entity_types")).Cols(
+		"service_id", "name", "description", "is_actor",
+	).Vals(
+		goqu.Vals{int(serviceID), name, description, isActor},
+	)
+
+	return q.Prepared(true).ToSQL()
+}
+
+func firstEntityTypeByNameAndServiceIDQuery(name string, serviceID model.ID) (string, []interface{}, error) {
+	if name == "" {
+		panic("how can name be empty?")
+	}
+
+	return entityTypeWithCountSelect().
+		Where(goqu.I("et.service_id").Eq(int(serviceID))).
+		Where(goqu.I("et.name").Eq(name)).
+		Limit(1).
+		Prepared(true).
+		ToSQL()
+}
+
+// DeleteOrphans - removes at most limit entity types created before the given time,
+// that have no entities, which is checked again on delete
+func (r *EntityTypeRepository) DeleteOrphans(ctx context.Context, before time.Time, limit int) (int, error) {
+	q, args, err := selectOrphanEntityTypesQuery(before, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var IDs []int
+	if err := r.sqliteTx.SelectContext(ctx, &IDs, q, args...); err != nil {
+		return 0, errors.Wrap(err, "could not select orphaned entity types")
+	}
+
+	if len(IDs) == 0 {
+		return 0, nil
+	}
+
+	q, args, err = deleteOrphanEntityTypesQuery(IDs)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := r.sqliteTx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not delete orphaned entity types")
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "could not get number of deleted entity types")
+	}
+
+	return int(n), nil
+}
+
+// orphanEntityTypeExpression - entity type has no entities
+func orphanEntityTypeExpression() goqu.Expression {
+	dialect := goqu.Dialect(SQLite)
+
+	return goqu.L("NOT EXISTS ?", dialect.From("entities").
+		Select(goqu.L("1")).
+		Where(goqu.I("entities.entity_type_id").Eq(goqu.I("entity_types.id"))))
+}
+
+func selectOrphanEntityTypesQuery(before time.Time, limit int) (string, []interface{}, error) {
+	if limit <= 0 {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(SQLite)
+
+	return dialect.From("entity_types").
+		Select("id").
+		Where(goqu.I("entity_types.created_at").Lt(before.UTC()), orphanEntityTypeExpression()).
+		Order(goqu.C("id").Asc()).
+		Limit(uint(limit)).
+		Prepared(true).ToSQL()
+}
+
+func deleteOrphanEntityTypesQuery(IDs []int) (string, []interface{}, error) {
+	dialect := goqu.Dialect(SQLite)
+
+	return dialect.Delete("entity_types").
+		Where(goqu.I("entity_types.id").In(IDs), orphanEntityTypeExpression()).
+		Prepared(true).ToSQL()
+}
@@ -0,0 +1,54 @@
+package sqlite
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntityTypeRepository(t *testing.T) {
+	database := newTestDatabase(t)
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		ms, err := tx.Microservices().Create(ctx, &model.Microservice{Name: "orders"})
+		if err != nil {
+			return err
+		}
+
+		order, err := tx.EntityTypes().Create(ctx, &model.EntityType{Name: "order", ServiceID: ms.ID, Description: "an order"})
+		assert.NoError(t, err)
+		assert.Equal(t, "order", order.Name)
+		assert.Equal(t, "an order", order.Description)
+		assert.Equal(t, ms.ID, order.ServiceID)
+		assert.Equal(t, 0, order.EntitiesCnt)
+
+		_, err = tx.EntityTypes().FirstByNameAndServiceID(ctx, "invoice", ms.ID)
+		assert.Equal(t, db.ErrNotFound, err)
+
+		invoice, err := tx.EntityTypes().FirstOrCreateByNameAndServiceID(ctx, "invoice", ms.ID)
+		assert.NoError(t, err)
+		assert.NotEqual(t, order.ID, invoice.ID)
+
+		found, err := tx.EntityTypes().FirstByID(ctx, invoice.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "invoice", found.Name)
+
+		c := db.NewCursor(1, 10, []string{"name"})
+		c.Sort.Add("name", db.ASCOrder)
+		f := db.NewFilter([]string{"serviceId"}).Add("serviceId", strconv.Itoa(ms.ID.Int()))
+
+		types, err := tx.EntityTypes().Select(ctx, c, f)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, *types.Meta.Total)
+		if assert.Len(t, types.Items, 2) {
+			assert.Equal(t, "invoice", types.Items[0].Name)
+			assert.Equal(t, "order", types.Items[1].Name)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,46 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/denismitr/auditbase/internal/search"
+	"github.com/mattn/go-sqlite3"
+)
+
+// driverName - SQLite driver registering the functions queries rely on with every connection,
+// SQLite is not always built with the JSON1 extension, so json_extract is not among them
+const driverName = "sqlite3_auditbase"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("json_match", jsonMatch, true)
+		},
+	})
+}
+
+// jsonMatch - json_match(document, operator, value, key...) compares the value at the path of the keys
+// within the JSON document with the JSON encoded value, as the search operator does.
+// Missing values equal null, only numbers are ordered, values of different types are never equal
+func jsonMatch(document interface{}, op string, value string, keys ...string) bool {
+	var text []byte
+	switch d := document.(type) {
+	case string:
+		text = []byte(d)
+	case []byte:
+		text = d
+	}
+
+	var doc interface{}
+	if len(text) > 0 && json.Unmarshal(text, &doc) != nil {
+		return false
+	}
+
+	var expected interface{}
+	if err := json.Unmarshal([]byte(value), &expected); err != nil {
+		return false
+	}
+
+	return search.Condition{Path: keys, Operator: search.Operator(op), Value: expected}.Matches(doc)
+}
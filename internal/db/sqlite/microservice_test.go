@@ -0,0 +1,59 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMicroserviceRepository(t *testing.T) {
+	database := newTestDatabase(t)
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		created, err := tx.Microservices().Create(ctx, &model.Microservice{Name: "foo", Description: "foo service"})
+		if !assert.NoError(t, err) {
+			return err
+		}
+
+		assert.True(t, created.ID > 0)
+		assert.Equal(t, "foo", created.Name)
+		assert.Equal(t, "foo service", created.Description)
+		assert.False(t, created.CreatedAt.IsZero())
+
+		byName, err := tx.Microservices().FirstByName(ctx, "foo")
+		assert.NoError(t, err)
+		assert.Equal(t, created.ID, byName.ID)
+
+		_, err = tx.Microservices().FirstByName(ctx, "bar")
+		assert.Equal(t, db.ErrNotFound, err)
+
+		bar, err := tx.Microservices().FirstOrCreateByName(ctx, "bar")
+		assert.NoError(t, err)
+		again, err := tx.Microservices().FirstOrCreateByName(ctx, "bar")
+		assert.NoError(t, err)
+		assert.Equal(t, bar.ID, again.ID)
+
+		updated, err := tx.Microservices().Update(ctx, bar.ID, &model.Microservice{
+			Name:        "baz",
+			Description: "renamed",
+			UpdatedAt:   model.JSONTime{Time: time.Now()},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "baz", updated.Name)
+		assert.Equal(t, "renamed", updated.Description)
+
+		all, err := tx.Microservices().SelectAll(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, *all.Meta.Total)
+
+		assert.NoError(t, tx.Microservices().Delete(ctx, created.ID))
+		_, err = tx.Microservices().FirstByID(ctx, created.ID)
+		assert.Equal(t, db.ErrNotFound, err)
+
+		return nil
+	})
+}
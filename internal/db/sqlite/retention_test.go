@@ -0,0 +1,126 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetentionRuleRepository(t *testing.T) {
+	database := newTestDatabase(t)
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		created, err := tx.RetentionRules().Create(ctx, &model.RetentionRule{ActionName: "pageViewed", KeepDays: 30})
+		if err != nil {
+			return err
+		}
+
+		assert.True(t, created.ID.Valid())
+
+		if _, err := tx.RetentionRules().Create(ctx, &model.RetentionRule{Service: "billing", KeepDays: 2555}); err != nil {
+			return err
+		}
+
+		rules, err := tx.RetentionRules().Select(ctx)
+		if err != nil {
+			return err
+		}
+
+		if assert.Len(t, rules, 2) {
+			assert.Equal(t, "pageViewed", rules[0].ActionName)
+			assert.Equal(t, "", rules[0].Service)
+			assert.Equal(t, "billing", rules[1].Service)
+			assert.Equal(t, 2555, rules[1].KeepDays)
+		}
+
+		assert.NoError(t, tx.RetentionRules().Delete(ctx, created.ID))
+		assert.Equal(t, db.ErrNotFound, tx.RetentionRules().Delete(ctx, created.ID))
+
+		return nil
+	})
+}
+
+func TestActionRepository_Prune(t *testing.T) {
+	database := newTestDatabase(t)
+	registeredAt := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		ms, err := tx.Microservices().Create(ctx, &model.Microservice{Name: "articles"})
+		if err != nil {
+			return err
+		}
+
+		et, err := tx.EntityTypes().FirstOrCreateByNameAndServiceID(ctx, "page", ms.ID)
+		if err != nil {
+			return err
+		}
+
+		page, err := tx.Entities().FirstOrCreateByExternalIDAndEntityTypeID(ctx, "1", et.ID)
+		if err != nil {
+			return err
+		}
+
+		for i, c := range []string{"a", "b", "c"} {
+			at := model.JSONTime{Time: registeredAt.Add(time.Duration(i) * time.Hour)}
+			if _, err := tx.Actions().Create(ctx, &model.Action{
+				UID:            model.UID(c + "0000000000000000000000000000000"),
+				Name:           "pageViewed",
+				TargetEntityID: page.ID,
+				EmittedAt:      at,
+				RegisteredAt:   at,
+			}); err != nil {
+				return err
+			}
+		}
+
+		candidates, err := tx.Actions().SelectExpiring(ctx, 0, registeredAt.Add(90*time.Minute), 10)
+		if err != nil {
+			return err
+		}
+
+		if !assert.Len(t, candidates, 2) {
+			return nil
+		}
+
+		assert.Equal(t, "articles", candidates[0].Service)
+		assert.NotEmpty(t, candidates[1].ChainHash)
+
+		rule := &model.RetentionRule{ID: 1}
+		tombstone := model.NewActionTombstone(&candidates[1], rule, registeredAt)
+		if err := tx.Actions().Prune(ctx, []model.ActionTombstone{tombstone}); err != nil {
+			return err
+		}
+
+		_, err = tx.Actions().FirstByID(ctx, candidates[1].ID)
+		assert.Error(t, err)
+
+		tombstones, err := tx.Actions().SelectTombstones(ctx, 1, 0)
+		if err != nil {
+			return err
+		}
+
+		if assert.Len(t, tombstones, 1) {
+			assert.Equal(t, candidates[1].ID, tombstones[0].ActionID)
+			assert.Equal(t, candidates[1].PrevChainHash, tombstones[0].PrevChainHash)
+			assert.Equal(t, model.ID(1), tombstones[0].RuleID)
+		}
+
+		// the pruned action still precedes the next one through its tombstone
+		hash, err := tx.Actions().ChainHashBefore(ctx, candidates[1].ID+1)
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, candidates[1].ChainHash, hash)
+
+		n, err := tx.Entities().DeleteOrphans(ctx, time.Now().Add(time.Minute), 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n, "page is still referenced by the remaining actions")
+
+		return nil
+	})
+}
@@ -0,0 +1,442 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+type SQLMigrator struct {
+	conn *sqlx.DB
+	lg   logger.Logger
+
+	up      map[string][]string
+	applied map[string]bool
+}
+
+func Migrator(conn *sqlx.DB, lg logger.Logger) *SQLMigrator {
+	m := &SQLMigrator{
+		conn:    conn,
+		lg:      lg,
+		up:      make(map[string][]string),
+		applied: make(map[string]bool),
+	}
+
+	m.up["001_initial"] = []string{microservicesSchema, entityTypesSchema, entitiesSchema, actionsSchema}
+	m.up["002_action_deltas"] = []string{actionDeltasSchema}
+	m.up["003_action_chain"] = []string{
+		actionsPrevChainHashColumn,
+		actionsChainHashColumn,
+		actionChainSchema,
+		actionChainGenesis,
+	}
+	m.up["004_api_keys"] = []string{apiKeysSchema, apiKeyServicesSchema}
+	m.up["005_microservice_secrets"] = []string{microserviceSecretsSchema}
+	m.up["006_access_log"] = []string{accessLogSchema, accessLogRecordsSchema, accessLogAppendOnly}
+	m.up["007_retention"] = []string{retentionRulesSchema, actionTombstonesSchema, actionsEntityIndexes}
+	m.up["008_action_search"] = []string{actionDeltasPropertyIndex}
+	m.up["009_action_stats"] = []string{actionsRegisteredAtIndex}
+	m.up["010_webhooks"] = []string{webhookRulesSchema, webhookDeliveriesSchema}
+	m.up["011_action_timeouts"] = []string{timeoutRulesSchema, timeoutSweepsSchema, actionTimeoutsSchema, actionsStatusIndex}
+
+	return m
+}
+
+const migrationsSchema = `
+CREATE TABLE IF NOT EXISTS migrations (
+	name VARCHAR(36) PRIMARY KEY,
+	created_at TIMESTAMP default CURRENT_TIMESTAMP
+);
+`
+
+const microservicesSchema = `
+	CREATE TABLE IF NOT EXISTS microservices (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name VARCHAR(36) NOT NULL,
+		description VARCHAR(255) NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+		CONSTRAINT microservices_unique_name UNIQUE (name)
+	);
+`
+
+const actionsSchema = `
+	CREATE TABLE IF NOT EXISTS actions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		uid VARCHAR(32) NOT NULL,
+		parent_uid VARCHAR(32),
+		status TINYINT NOT NULL DEFAULT 0,
+		is_async BOOLEAN NOT NULL DEFAULT 0,
+		hash BLOB,
+		actor_entity_id INTEGER REFERENCES entities (id) ON DELETE CASCADE,
+		target_entity_id INTEGER REFERENCES entities (id) ON DELETE CASCADE,
+		name VARCHAR(36) NOT NULL,
+		emitted_at TIMESTAMP NOT NULL,
+		registered_at TIMESTAMP NOT NULL,
+		details TEXT,
+
+		CONSTRAINT actions_hash_key UNIQUE (hash),
+		CONSTRAINT actions_uid_key UNIQUE (uid)
+	);
+
+	CREATE INDEX IF NOT EXISTS actions_name_idx ON actions (name);
+`
+
+const entityTypesSchema = `
+	CREATE TABLE IF NOT EXISTS entity_types (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		service_id INTEGER NOT NULL REFERENCES microservices (id) ON DELETE CASCADE,
+		name VARCHAR(64) NOT NULL,
+		description VARCHAR(255) NOT NULL DEFAULT '',
+		is_actor BOOLEAN NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+		CONSTRAINT entity_types_unique_service_and_name UNIQUE (service_id, name)
+	);
+`
+
+const entitiesSchema = `
+	CREATE TABLE IF NOT EXISTS entities (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		entity_type_id INTEGER NOT NULL,
+		external_id VARCHAR(36) NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+		CONSTRAINT entities_unique_idx UNIQUE (entity_type_id, external_id)
+	);
+`
+
+const actionDeltasSchema = `
+	CREATE TABLE IF NOT EXISTS action_deltas (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		action_id INTEGER NOT NULL REFERENCES actions (id) ON DELETE CASCADE,
+		entity_id INTEGER REFERENCES entities (id) ON DELETE CASCADE,
+		property_name VARCHAR(64) NOT NULL,
+		property_type VARCHAR(16) NOT NULL,
+		from_value TEXT,
+		to_value TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS action_deltas_action_idx ON action_deltas (action_id);
+	CREATE INDEX IF NOT EXISTS action_deltas_entity_property_idx ON action_deltas (entity_id, property_name);
+`
+
+const actionsPrevChainHashColumn = `ALTER TABLE actions ADD COLUMN prev_chain_hash CHAR(64)`
+
+const actionsChainHashColumn = `ALTER TABLE actions ADD COLUMN chain_hash CHAR(64)`
+
+// actionChainSchema - single row table holding the head of the action hash chain
+const actionChainSchema = `
+	CREATE TABLE IF NOT EXISTS action_chain (
+		id INTEGER PRIMARY KEY,
+		last_hash CHAR(64) NOT NULL
+	);
+`
+
+const actionChainGenesis = `INSERT OR IGNORE INTO action_chain (id, last_hash) VALUES (1, '` + model.GenesisChainHash + `')`
+
+const apiKeysSchema = `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name VARCHAR(64) NOT NULL,
+		prefix CHAR(8) NOT NULL,
+		key_hash CHAR(64) NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		rotated_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+
+		CONSTRAINT api_keys_key_hash_key UNIQUE (key_hash)
+	);
+`
+
+const apiKeyServicesSchema = `
+	CREATE TABLE IF NOT EXISTS api_key_services (
+		api_key_id INTEGER NOT NULL REFERENCES api_keys (id) ON DELETE CASCADE,
+		microservice_id INTEGER NOT NULL REFERENCES microservices (id) ON DELETE CASCADE,
+
+		PRIMARY KEY (api_key_id, microservice_id)
+	);
+`
+
+// microserviceSecretsSchema - shared secrets microservices sign action submissions with,
+// kept apart from microservices, so that they never leak through the microservices API
+const microserviceSecretsSchema = `
+	CREATE TABLE IF NOT EXISTS microservice_secrets (
+		microservice_id INTEGER PRIMARY KEY REFERENCES microservices (id) ON DELETE CASCADE,
+		secret VARCHAR(64) NOT NULL,
+		previous_secret VARCHAR(64),
+		previous_expires_at TIMESTAMP,
+		rotated_at TIMESTAMP NOT NULL
+	);
+`
+
+// accessLogSchema - who read what through the back-office API
+const accessLogSchema = `
+	CREATE TABLE IF NOT EXISTS access_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		principal VARCHAR(64) NOT NULL,
+		role VARCHAR(16) NOT NULL DEFAULT '',
+		method VARCHAR(8) NOT NULL,
+		route VARCHAR(128) NOT NULL,
+		path VARCHAR(255) NOT NULL,
+		filters TEXT,
+		status SMALLINT NOT NULL,
+		requested_at TIMESTAMP NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS access_log_principal_idx ON access_log (principal);
+`
+
+const accessLogRecordsSchema = `
+	CREATE TABLE IF NOT EXISTS access_log_records (
+		access_log_id INTEGER NOT NULL REFERENCES access_log (id),
+		record_type VARCHAR(16) NOT NULL,
+		record_id INTEGER NOT NULL,
+
+		PRIMARY KEY (access_log_id, record_type, record_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS access_log_records_record_idx ON access_log_records (record_type, record_id);
+`
+
+// accessLogAppendOnly - entries of the access log can be neither changed nor removed
+const accessLogAppendOnly = `
+	CREATE TRIGGER IF NOT EXISTS access_log_no_update BEFORE UPDATE ON access_log
+	BEGIN SELECT RAISE(ABORT, 'access log is append-only'); END;
+
+	CREATE TRIGGER IF NOT EXISTS access_log_no_delete BEFORE DELETE ON access_log
+	BEGIN SELECT RAISE(ABORT, 'access log is append-only'); END;
+
+	CREATE TRIGGER IF NOT EXISTS access_log_records_no_update BEFORE UPDATE ON access_log_records
+	BEGIN SELECT RAISE(ABORT, 'access log is append-only'); END;
+
+	CREATE TRIGGER IF NOT EXISTS access_log_records_no_delete BEFORE DELETE ON access_log_records
+	BEGIN SELECT RAISE(ABORT, 'access log is append-only'); END;
+`
+
+// retentionRulesSchema - how long actions are kept, an empty service or action name matches any
+const retentionRulesSchema = `
+	CREATE TABLE IF NOT EXISTS retention_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		service VARCHAR(36) NOT NULL DEFAULT '',
+		action_name VARCHAR(36) NOT NULL DEFAULT '',
+		keep_days INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+		CONSTRAINT retention_rules_unique_service_and_action_name UNIQUE (service, action_name)
+	);
+`
+
+// actionTombstonesSchema - what is left of the actions removed by retention,
+// their chain hashes keep the hash chain verifiable
+const actionTombstonesSchema = `
+	CREATE TABLE IF NOT EXISTS action_tombstones (
+		action_id INTEGER PRIMARY KEY,
+		uid VARCHAR(32) NOT NULL,
+		name VARCHAR(36) NOT NULL,
+		prev_chain_hash CHAR(64),
+		chain_hash CHAR(64),
+		rule_id INTEGER,
+		pruned_at TIMESTAMP NOT NULL
+	);
+`
+
+// actionsEntityIndexes - orphaned entities are looked up by the actions referencing them
+const actionsEntityIndexes = `
+	CREATE INDEX IF NOT EXISTS actions_actor_entity_idx ON actions (actor_entity_id);
+	CREATE INDEX IF NOT EXISTS actions_target_entity_idx ON actions (target_entity_id);
+`
+
+const actionDeltasPropertyIndex = `CREATE INDEX IF NOT EXISTS action_deltas_property_idx ON action_deltas (property_name)`
+
+const actionsRegisteredAtIndex = `CREATE INDEX IF NOT EXISTS actions_registered_at_idx ON actions (registered_at)`
+
+// webhookRulesSchema - rules webhooks about actions are posted by, an empty criterion matches any
+const webhookRulesSchema = `
+	CREATE TABLE IF NOT EXISTS webhook_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name VARCHAR(64) NOT NULL,
+		url VARCHAR(2048) NOT NULL,
+		secret VARCHAR(64) NOT NULL,
+		service VARCHAR(36) NOT NULL DEFAULT '',
+		entity_type VARCHAR(64) NOT NULL DEFAULT '',
+		name_pattern VARCHAR(36) NOT NULL DEFAULT '',
+		status TINYINT,
+		details_condition TEXT NOT NULL DEFAULT '',
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+`
+
+// webhookDeliveriesSchema - webhooks posted or to be posted, a rule is delivered
+// at most once for every status an action reaches
+const webhookDeliveriesSchema = `
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		rule_id INTEGER NOT NULL REFERENCES webhook_rules (id) ON DELETE CASCADE,
+		action_id INTEGER NOT NULL,
+		action_status TINYINT NOT NULL,
+		event VARCHAR(16) NOT NULL,
+		payload TEXT NOT NULL,
+		state VARCHAR(16) NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		response_code INTEGER NOT NULL DEFAULT 0,
+		last_error VARCHAR(1024) NOT NULL DEFAULT '',
+		next_attempt_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		delivered_at TIMESTAMP,
+
+		CONSTRAINT webhook_deliveries_unique_rule_action_status UNIQUE (rule_id, action_id, action_status)
+	);
+
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (state, next_attempt_at);
+`
+
+// timeoutRulesSchema - how long async actions are given to complete, an empty service or action name matches any
+const timeoutRulesSchema = `
+	CREATE TABLE IF NOT EXISTS timeout_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		service VARCHAR(36) NOT NULL DEFAULT '',
+		action_name VARCHAR(36) NOT NULL DEFAULT '',
+		timeout_seconds INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+		CONSTRAINT timeout_rules_unique_service_and_action_name UNIQUE (service, action_name)
+	);
+`
+
+// timeoutSweepsSchema - runs of the watchdog that timed out actions
+const timeoutSweepsSchema = `
+	CREATE TABLE IF NOT EXISTS timeout_sweeps (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		started_at TIMESTAMP NOT NULL,
+		finished_at TIMESTAMP,
+		scanned INTEGER NOT NULL DEFAULT 0,
+		timed_out INTEGER NOT NULL DEFAULT 0
+	);
+`
+
+// actionTimeoutsSchema - async actions moved to TimedOut, along with the status they were stuck in,
+// the rule is kept as a plain ID, so that deleting the rule leaves the history alone
+const actionTimeoutsSchema = `
+	CREATE TABLE IF NOT EXISTS action_timeouts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		sweep_id INTEGER NOT NULL REFERENCES timeout_sweeps (id) ON DELETE CASCADE,
+		action_id INTEGER NOT NULL,
+		action_uid VARCHAR(32) NOT NULL,
+		action_name VARCHAR(36) NOT NULL,
+		service VARCHAR(36) NOT NULL DEFAULT '',
+		rule_id INTEGER,
+		previous_status TINYINT NOT NULL,
+		registered_at TIMESTAMP NOT NULL,
+		timed_out_at TIMESTAMP NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS action_timeouts_sweep_idx ON action_timeouts (sweep_id);
+`
+
+// actionsStatusIndex - the watchdog looks for async actions still in progress
+const actionsStatusIndex = `CREATE INDEX IF NOT EXISTS actions_status_idx ON actions (status)`
+
+const flush = `
+	DROP TABLE IF EXISTS action_timeouts;
+	DROP TABLE IF EXISTS timeout_sweeps;
+	DROP TABLE IF EXISTS timeout_rules;
+	DROP TABLE IF EXISTS webhook_deliveries;
+	DROP TABLE IF EXISTS webhook_rules;
+	DROP TABLE IF EXISTS action_tombstones;
+	DROP TABLE IF EXISTS retention_rules;
+	DROP TABLE IF EXISTS access_log_records;
+	DROP TABLE IF EXISTS access_log;
+	DROP TABLE IF EXISTS microservice_secrets;
+	DROP TABLE IF EXISTS api_key_services;
+	DROP TABLE IF EXISTS api_keys;
+	DROP TABLE IF EXISTS action_chain;
+	DROP TABLE IF EXISTS action_deltas;
+	DROP TABLE IF EXISTS actions;
+	DROP TABLE IF EXISTS entities;
+	DROP TABLE IF EXISTS entity_types;
+	DROP TABLE IF EXISTS microservices;
+	DROP TABLE IF EXISTS migrations;
+`
+
+// Up - applies all migrations that were not applied yet in a single transaction,
+// SQLite serializes writers, so there is no need for an explicit lock
+func (m *SQLMigrator) Up() error {
+	if _, err := m.conn.Exec(migrationsSchema); err != nil {
+		return err
+	}
+
+	tx, err := m.conn.BeginTxx(context.Background(), &sql.TxOptions{
+		Isolation: sql.LevelSerializable,
+		ReadOnly:  false,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	var applied []string
+	if err := tx.Select(&applied, "SELECT name FROM migrations"); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	for _, name := range applied {
+		m.applied[name] = true
+	}
+
+	migrations := make([]string, 0, len(m.up))
+	for name := range m.up {
+		migrations = append(migrations, name)
+	}
+
+	sort.Strings(migrations)
+
+	for _, name := range migrations {
+		if _, ok := m.applied[name]; ok {
+			m.lg.Debugf("Migration %s already applied", name)
+			continue
+		}
+
+		m.lg.Debugf("Running migration %s...", name)
+
+		for _, query := range m.up[name] {
+			m.lg.Debugf("Running SQL %s...", query)
+			if _, err := tx.Exec(query); err != nil {
+				_ = tx.Rollback()
+				return errors.Wrapf(err, "could not apply migration %s", name)
+			}
+		}
+
+		if _, err := tx.Exec("INSERT INTO migrations (name) VALUES (?)", name); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	m.lg.Debugf("Migrations are finished...")
+
+	return nil
+}
+
+func (m *SQLMigrator) Down() error {
+	if _, err := m.conn.Exec(flush); err != nil {
+		return errors.Wrap(err, "could not drop tables")
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package sqlite
+
+import (
+	"encoding/json"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/sqlstore"
+	"github.com/denismitr/auditbase/internal/search"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+)
+
+// searchExpressions - expressions of the conditions and the words of the search query,
+// there are no indexes on details, so searches scan the actions left by the filter
+func searchExpressions(dialect goqu.DialectWrapper, q *search.Query) []exp.Expression {
+	var result []exp.Expression
+
+	for _, c := range q.Conditions {
+		if c.Source == search.Details {
+			result = append(result, jsonMatchExpression(goqu.I("actions.details"), c, c.Path))
+			continue
+		}
+
+		column := "from_value"
+		if c.Source == search.DeltaTo {
+			column = "to_value"
+		}
+
+		deltas := dialect.From("action_deltas").
+			Select("action_id").
+			Where(goqu.C("property_name").Eq(c.Property()), jsonMatchExpression(goqu.C(column), c, nil))
+
+		result = append(result, sqlstore.InSubquery(goqu.I("actions.id"), deltas))
+	}
+
+	for _, w := range q.Words {
+		result = append(result, goqu.L("? LIKE ? ESCAPE '"+db.LikeEscape+"'", goqu.I("actions.details"), db.LikeContains(w)))
+	}
+
+	return result
+}
+
+// jsonMatchExpression - json_match of the value at the path within the JSON column with the condition
+func jsonMatchExpression(column exp.IdentifierExpression, c search.Condition, path []string) exp.Expression {
+	value, err := json.Marshal(c.Value)
+	if err != nil {
+		panic("how could a search value fail to encode")
+	}
+
+	args := []interface{}{column, string(c.Operator), string(value)}
+	placeholders := "?, ?, ?"
+	for _, k := range path {
+		args = append(args, k)
+		placeholders += ", ?"
+	}
+
+	return goqu.L("json_match("+placeholders+")", args...)
+}
@@ -0,0 +1,54 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigningSecretRepository(t *testing.T) {
+	database := newTestDatabase(t)
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		ms, err := tx.Microservices().Create(ctx, &model.Microservice{Name: "billing"})
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.SigningSecrets().FirstByServiceID(ctx, ms.ID)
+		assert.Equal(t, db.ErrNotFound, err)
+
+		first := time.Date(2021, 4, 1, 10, 0, 0, 0, time.UTC)
+		secrets := &model.SigningSecrets{ServiceID: ms.ID}
+		secrets.Rotate("first-secret", first, time.Hour)
+
+		if !assert.NoError(t, tx.SigningSecrets().Save(ctx, secrets)) {
+			return nil
+		}
+
+		created, err := tx.SigningSecrets().FirstByServiceName(ctx, "billing")
+		assert.NoError(t, err)
+		assert.Equal(t, "first-secret", created.Secret)
+		assert.Equal(t, "", created.PreviousSecret)
+		assert.Equal(t, first, created.RotatedAt.UTC())
+
+		second := first.Add(2 * time.Hour)
+		created.Rotate("second-secret", second, time.Hour)
+		assert.NoError(t, tx.SigningSecrets().Save(ctx, created))
+
+		rotated, err := tx.SigningSecrets().FirstByServiceID(ctx, ms.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "second-secret", rotated.Secret)
+		assert.Equal(t, "first-secret", rotated.PreviousSecret)
+		assert.Equal(t, second.Add(time.Hour), rotated.PreviousExpiresAt.UTC())
+
+		_, err = tx.SigningSecrets().FirstByServiceName(ctx, "orders")
+		assert.Equal(t, db.ErrNotFound, err)
+
+		return nil
+	})
+}
@@ -0,0 +1,35 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+)
+
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+
+	lg := logger.NewStdoutLogger(logger.Prod, "sqlite_test")
+
+	conn, err := ConnectAndMigrate(context.Background(), lg, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return NewDatabase(conn, lg)
+}
+
+// readWrite - runs cb in read write transaction and fails the test on error
+func readWrite(t *testing.T, database *Database, cb func(ctx context.Context, tx db.Tx) error) {
+	t.Helper()
+
+	if _, err := database.ReadWrite(context.Background(), func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return nil, cb(ctx, tx)
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
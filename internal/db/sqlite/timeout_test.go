@@ -0,0 +1,208 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeoutRepository(t *testing.T) {
+	database := newTestDatabase(t)
+	registeredAt := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		rule, err := tx.Timeouts().CreateRule(ctx, &model.TimeoutRule{Service: "billing", TimeoutSeconds: 600})
+		if err != nil {
+			return err
+		}
+
+		rules, err := tx.Timeouts().SelectRules(ctx)
+		if err != nil {
+			return err
+		}
+
+		if assert.Len(t, rules, 1) {
+			assert.Equal(t, "billing", rules[0].Service)
+			assert.Equal(t, 600, rules[0].TimeoutSeconds)
+		}
+
+		ms, err := tx.Microservices().Create(ctx, &model.Microservice{Name: "billing"})
+		if err != nil {
+			return err
+		}
+
+		et, err := tx.EntityTypes().FirstOrCreateByNameAndServiceID(ctx, "refund", ms.ID)
+		if err != nil {
+			return err
+		}
+
+		refund, err := tx.Entities().FirstOrCreateByExternalIDAndEntityTypeID(ctx, "1", et.ID)
+		if err != nil {
+			return err
+		}
+
+		for i, a := range []struct {
+			uid     string
+			status  model.Status
+			isAsync bool
+		}{
+			{"a", model.Pending, true},
+			{"b", model.Success, true},
+			{"c", model.Retrying, true},
+			{"d", model.Pending, false},
+			{"e", model.Processing, true},
+		} {
+			at := model.JSONTime{Time: registeredAt.Add(time.Duration(i) * time.Minute)}
+			if _, err := tx.Actions().Create(ctx, &model.Action{
+				UID:            model.UID(a.uid + "0000000000000000000000000000000"),
+				Name:           "refundIssued",
+				TargetEntityID: refund.ID,
+				Status:         a.status,
+				IsAsync:        a.isAsync,
+				EmittedAt:      at,
+				RegisteredAt:   at,
+			}); err != nil {
+				return err
+			}
+		}
+
+		candidates, err := tx.Timeouts().SelectCandidates(ctx, 0, registeredAt.Add(3*time.Minute), 10)
+		if err != nil {
+			return err
+		}
+
+		if !assert.Len(t, candidates, 2) {
+			return nil
+		}
+
+		assert.Equal(t, model.UID("a0000000000000000000000000000000"), candidates[0].UID)
+		assert.Equal(t, model.Retrying, candidates[1].Status)
+		assert.Equal(t, "billing", candidates[1].Service)
+
+		next, err := tx.Timeouts().SelectCandidates(ctx, candidates[0].ID, registeredAt.Add(time.Hour), 1)
+		if err != nil {
+			return err
+		}
+
+		if assert.Len(t, next, 1) {
+			assert.Equal(t, candidates[1].ID, next[0].ID)
+		}
+
+		now := registeredAt.Add(time.Hour)
+		sweep := &model.TimeoutSweep{StartedAt: model.JSONTime{Time: now}}
+		if sweep.ID, err = tx.Timeouts().CreateSweep(ctx, sweep); err != nil {
+			return err
+		}
+
+		timeout := model.NewActionTimeout(&candidates[1], rule, sweep.ID, now)
+		timedOut, err := tx.Timeouts().TimeOut(ctx, &timeout)
+		if err != nil {
+			return err
+		}
+
+		assert.True(t, timedOut)
+
+		action, err := tx.Actions().FirstByID(ctx, candidates[1].ID)
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, model.TimedOut, action.Status)
+
+		// a completion reported after the timeout does not change it
+		if err := tx.Actions().UpdateStatus(ctx, candidates[1].ID, model.Success); err != nil {
+			return err
+		}
+
+		if action, err = tx.Actions().FirstByID(ctx, candidates[1].ID); err != nil {
+			return err
+		}
+
+		assert.Equal(t, model.TimedOut, action.Status)
+
+		// a completion reported in the meantime is left alone
+		if err := tx.Actions().UpdateStatus(ctx, candidates[0].ID, model.Success); err != nil {
+			return err
+		}
+
+		timeout = model.NewActionTimeout(&candidates[0], rule, sweep.ID, now)
+		timedOut, err = tx.Timeouts().TimeOut(ctx, &timeout)
+		if err != nil {
+			return err
+		}
+
+		assert.False(t, timedOut)
+
+		sweep.Scanned, sweep.TimedOut = 2, 1
+		sweep.FinishedAt = model.JSONTime{Time: now.Add(time.Second)}
+		if err := tx.Timeouts().UpdateSweep(ctx, sweep); err != nil {
+			return err
+		}
+
+		sweeps, err := tx.Timeouts().SelectSweeps(ctx, &db.Cursor{Page: 1, PerPage: 10}, nil)
+		if err != nil {
+			return err
+		}
+
+		if assert.Len(t, sweeps.Items, 1) {
+			assert.Equal(t, 1, sweeps.Items[0].TimedOut)
+			assert.Equal(t, 1, *sweeps.Meta.Total)
+			assert.Empty(t, sweeps.Items[0].Timeouts)
+		}
+
+		// scoped listings only see the sweeps that timed out actions of their services
+		sweeps, err = tx.Timeouts().SelectSweeps(ctx, &db.Cursor{Page: 1, PerPage: 10}, []string{"billing"})
+		if err != nil {
+			return err
+		}
+
+		if assert.Len(t, sweeps.Items, 1) {
+			assert.Equal(t, sweep.ID, sweeps.Items[0].ID)
+			assert.Equal(t, 1, sweeps.Items[0].TimedOut)
+			assert.Equal(t, 2, sweeps.Items[0].Scanned)
+			assert.Equal(t, 1, *sweeps.Meta.Total)
+		}
+
+		sweeps, err = tx.Timeouts().SelectSweeps(ctx, &db.Cursor{Page: 1, PerPage: 10}, []string{"orders"})
+		if err != nil {
+			return err
+		}
+
+		assert.Empty(t, sweeps.Items)
+		assert.Equal(t, 0, *sweeps.Meta.Total)
+
+		found, err := tx.Timeouts().FirstSweepByID(ctx, sweep.ID)
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, 2, found.Scanned)
+		assert.True(t, now.Add(time.Second).Equal(found.FinishedAt.Time))
+		if assert.Len(t, found.Timeouts, 1) {
+			assert.Equal(t, candidates[1].ID, found.Timeouts[0].ActionID)
+			assert.Equal(t, model.Retrying, found.Timeouts[0].PreviousStatus)
+			assert.Equal(t, rule.ID, found.Timeouts[0].RuleID)
+			assert.Equal(t, "billing", found.Timeouts[0].Service)
+		}
+
+		_, err = tx.Timeouts().FirstSweepByID(ctx, sweep.ID+1)
+		assert.Equal(t, db.ErrNotFound, err)
+
+		// the history outlives the rule
+		assert.NoError(t, tx.Timeouts().DeleteRule(ctx, rule.ID))
+		assert.Equal(t, db.ErrNotFound, tx.Timeouts().DeleteRule(ctx, rule.ID))
+
+		found, err = tx.Timeouts().FirstSweepByID(ctx, sweep.ID)
+		if err != nil {
+			return err
+		}
+
+		assert.Len(t, found.Timeouts, 1)
+
+		return nil
+	})
+}
@@ -0,0 +1,120 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookRepository(t *testing.T) {
+	database := newTestDatabase(t)
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	failed := model.Failed
+
+	readWrite(t, database, func(ctx context.Context, tx db.Tx) error {
+		rule, err := tx.Webhooks().CreateRule(ctx, &model.WebhookRule{
+			Name:        "incidents",
+			URL:         "https://incidents.local/hooks",
+			Secret:      "s3cr3t",
+			Service:     "billing",
+			NamePattern: "*Deleted",
+			Status:      &failed,
+			Enabled:     true,
+			CreatedAt:   model.JSONTime{Time: now},
+			UpdatedAt:   model.JSONTime{Time: now},
+		})
+		if err != nil {
+			return err
+		}
+
+		assert.True(t, rule.ID.Valid())
+
+		rule.Enabled = false
+		rule.Status = nil
+		if err := tx.Webhooks().UpdateRule(ctx, rule); err != nil {
+			return err
+		}
+
+		found, err := tx.Webhooks().FirstRuleByID(ctx, rule.ID)
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, "s3cr3t", found.Secret)
+		assert.Equal(t, "*Deleted", found.NamePattern)
+		assert.False(t, found.Enabled)
+		assert.Nil(t, found.Status)
+
+		pending := func(actionID model.ID, status model.Status, dueAt time.Time) model.WebhookDelivery {
+			return model.WebhookDelivery{
+				RuleID:        rule.ID,
+				ActionID:      actionID,
+				ActionStatus:  status,
+				Event:         model.ActionCreated,
+				Payload:       []byte(`{"event":"created"}`),
+				State:         model.DeliveryPending,
+				NextAttemptAt: model.JSONTime{Time: dueAt},
+				CreatedAt:     model.JSONTime{Time: now},
+			}
+		}
+
+		if err := tx.Webhooks().CreateDeliveries(ctx, []model.WebhookDelivery{
+			pending(1, model.Pending, now),
+			pending(1, model.Failed, now.Add(-time.Minute)),
+			pending(2, model.Pending, now.Add(time.Minute)),
+		}); err != nil {
+			return err
+		}
+
+		// the rule has been delivered about the first action with that status already
+		if err := tx.Webhooks().CreateDeliveries(ctx, []model.WebhookDelivery{pending(1, model.Failed, now)}); err != nil {
+			return err
+		}
+
+		due, err := tx.Webhooks().SelectDueDeliveries(ctx, now, 10)
+		if err != nil {
+			return err
+		}
+
+		if assert.Len(t, due, 2) {
+			assert.Equal(t, model.Failed, due[0].ActionStatus)
+			assert.Equal(t, model.Pending, due[1].ActionStatus)
+			assert.JSONEq(t, `{"event":"created"}`, string(due[0].Payload))
+		}
+
+		due[0].Delivered(now, 204)
+		if err := tx.Webhooks().UpdateDelivery(ctx, &due[0]); err != nil {
+			return err
+		}
+
+		delivered, err := tx.Webhooks().FirstDeliveryByID(ctx, due[0].ID)
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, model.DeliveryDelivered, delivered.State)
+		assert.Equal(t, 204, delivered.ResponseCode)
+		assert.Equal(t, now, delivered.DeliveredAt.UTC())
+
+		f := db.NewFilter([]string{"ruleId", "actionId", "state"}).Add("actionId", "1")
+		log, err := tx.Webhooks().SelectDeliveries(ctx, &db.Cursor{Page: 1, PerPage: 10}, f)
+		if err != nil {
+			return err
+		}
+
+		assert.Len(t, log.Items, 2)
+		assert.Equal(t, 2, *log.Meta.Total)
+
+		assert.NoError(t, tx.Webhooks().DeleteRule(ctx, rule.ID))
+		assert.Equal(t, db.ErrNotFound, tx.Webhooks().DeleteRule(ctx, rule.ID))
+
+		_, err = tx.Webhooks().FirstDeliveryByID(ctx, due[0].ID)
+		assert.Equal(t, db.ErrNotFound, err)
+
+		return nil
+	})
+}
@@ -0,0 +1,255 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/pkg/errors"
+)
+
+type accessLogRecord struct {
+	ID          int            `db:"id"`
+	Principal   string         `db:"principal"`
+	Role        string         `db:"role"`
+	Method      string         `db:"method"`
+	Route       string         `db:"route"`
+	Path        string         `db:"path"`
+	Filters     sql.NullString `db:"filters"`
+	Status      int            `db:"status"`
+	RequestedAt time.Time      `db:"requested_at"`
+}
+
+type accessedRecord struct {
+	AccessLogID int    `db:"access_log_id"`
+	RecordType  string `db:"record_type"`
+	RecordID    int    `db:"record_id"`
+}
+
+func (r *accessLogRecord) ToModel() (*model.AccessLogEntry, error) {
+	e := &model.AccessLogEntry{
+		ID:          model.ID(r.ID),
+		Principal:   r.Principal,
+		Role:        r.Role,
+		Method:      r.Method,
+		Route:       r.Route,
+		Path:        r.Path,
+		Filters:     make(map[string]string),
+		Status:      r.Status,
+		ActionIDs:   make([]model.ID, 0),
+		EntityIDs:   make([]model.ID, 0),
+		RequestedAt: model.JSONTime{Time: r.RequestedAt},
+	}
+
+	if r.Filters.Valid && r.Filters.String != "" {
+		if err := json.Unmarshal([]byte(r.Filters.String), &e.Filters); err != nil {
+			return nil, errors.Wrapf(err, "could not unmarshal filters of access log entry %d", r.ID)
+		}
+	}
+
+	return e, nil
+}
+
+type AccessLogRepository struct {
+	*Tx
+}
+
+// static check of correct interface implementation
+var _ db.AccessLogRepository = (*AccessLogRepository)(nil)
+
+// Create - appends the entry along with the records it returned
+func (r *AccessLogRepository) Create(ctx context.Context, entry *model.AccessLogEntry) (model.ID, error) {
+	q, args, err := r.dialect.createAccessLogQuery(entry)
+	if err != nil {
+		return 0, err
+	}
+
+	newID, err := r.insert(ctx, q, args)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not insert access log entry")
+	}
+
+	if len(entry.ActionIDs)+len(entry.EntityIDs) == 0 {
+		return model.ID(newID), nil
+	}
+
+	q, args, err = r.dialect.createAccessedRecordsQuery(model.ID(newID), entry)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := r.tx.ExecContext(ctx, q, args...); err != nil {
+		return 0, errors.Wrapf(err, "could not insert records of access log entry %d", newID)
+	}
+
+	return model.ID(newID), nil
+}
+
+// Select - latest entries first, allowed filters are principal, method, route,
+// actionId and entityId, the latter two find everyone who read the record
+func (r *AccessLogRepository) Select(ctx context.Context, c *db.Cursor, f *db.Filter) (*model.AccessLogCollection, error) {
+	selectSQL, selectArgs, countSQL, countArgs, err := r.dialect.selectAccessLogQuery(c, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int
+	if err := r.tx.GetContext(ctx, &total, countSQL, countArgs...); err != nil {
+		return nil, errors.Wrap(err, "could not count access log entries")
+	}
+
+	var records []accessLogRecord
+	if err := r.tx.SelectContext(ctx, &records, selectSQL, selectArgs...); err != nil {
+		return nil, errors.Wrap(err, "could not select access log entries")
+	}
+
+	collection := &model.AccessLogCollection{
+		Items: make([]model.AccessLogEntry, 0, len(records)),
+		Meta:  model.Meta{Page: int(c.Page), PerPage: int(c.PerPage), Total: &total},
+	}
+
+	if len(records) == 0 {
+		return collection, nil
+	}
+
+	IDs := make([]int, len(records))
+	for i := range records {
+		IDs[i] = records[i].ID
+	}
+
+	q, args, err := r.dialect.selectAccessedRecordsQuery(IDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var accessed []accessedRecord
+	if err := r.tx.SelectContext(ctx, &accessed, q, args...); err != nil {
+		return nil, errors.Wrap(err, "could not select records of access log entries")
+	}
+
+	byEntry := make(map[int][]accessedRecord, len(records))
+	for _, a := range accessed {
+		byEntry[a.AccessLogID] = append(byEntry[a.AccessLogID], a)
+	}
+
+	for i := range records {
+		entry, err := records[i].ToModel()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, a := range byEntry[records[i].ID] {
+			entry.Add(model.AccessedRecord(a.RecordType), model.ID(a.RecordID))
+		}
+
+		collection.Items = append(collection.Items, *entry)
+	}
+
+	return collection, nil
+}
+
+func (d *Dialect) createAccessLogQuery(entry *model.AccessLogEntry) (string, []interface{}, error) {
+	if entry.Principal == "" || entry.Method == "" {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	filters, err := json.Marshal(entry.Filters)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "could not marshal access log filters")
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	return d.returningID(dialect.Insert("access_log").Rows(goqu.Record{
+		"principal":    entry.Principal,
+		"role":         entry.Role,
+		"method":       entry.Method,
+		"route":        entry.Route,
+		"path":         entry.Path,
+		"filters":      string(filters),
+		"status":       entry.Status,
+		"requested_at": entry.RequestedAt.UTC(),
+	})).Prepared(true).ToSQL()
+}
+
+func (d *Dialect) createAccessedRecordsQuery(ID model.ID, entry *model.AccessLogEntry) (string, []interface{}, error) {
+	seen := make(map[accessedRecord]bool)
+	rows := make([]interface{}, 0, len(entry.ActionIDs)+len(entry.EntityIDs))
+
+	add := func(kind model.AccessedRecord, IDs []model.ID) {
+		for _, recordID := range IDs {
+			r := accessedRecord{AccessLogID: int(ID), RecordType: string(kind), RecordID: int(recordID)}
+			if !seen[r] {
+				seen[r] = true
+				rows = append(rows, goqu.Record{
+					"access_log_id": r.AccessLogID,
+					"record_type":   r.RecordType,
+					"record_id":     r.RecordID,
+				})
+			}
+		}
+	}
+
+	add(model.AccessedAction, entry.ActionIDs)
+	add(model.AccessedEntity, entry.EntityIDs)
+
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.Insert("access_log_records").Rows(rows...).Prepared(true).ToSQL()
+}
+
+func (d *Dialect) selectAccessLogQuery(c *db.Cursor, f *db.Filter) (string, []interface{}, string, []interface{}, error) {
+	dialect := goqu.Dialect(d.Name)
+
+	var where []goqu.Expression
+	for param, column := range map[string]string{"principal": "principal", "method": "method", "route": "route"} {
+		if f.Has(param) {
+			where = append(where, goqu.C(column).Eq(f.MustString(param)))
+		}
+	}
+
+	for param, kind := range map[string]model.AccessedRecord{"actionId": model.AccessedAction, "entityId": model.AccessedEntity} {
+		if f.Has(param) {
+			where = append(where, goqu.C("id").In(
+				dialect.From("access_log_records").
+					Select("access_log_id").
+					Where(goqu.C("record_type").Eq(string(kind)), goqu.C("record_id").Eq(f.IntOrDefault(param, 0))),
+			))
+		}
+	}
+
+	selectSQL, selectArgs, err := dialect.From("access_log").
+		Select("id", "principal", "role", "method", "route", "path", "filters", "status", "requested_at").
+		Where(where...).
+		Order(goqu.C("id").Desc()).
+		Limit(c.PerPage).
+		Offset(c.Offset()).
+		Prepared(true).ToSQL()
+	if err != nil {
+		return "", nil, "", nil, errors.Wrap(err, "invalid select SQL for access log")
+	}
+
+	countSQL, countArgs, err := dialect.From("access_log").
+		Select(goqu.COUNT("*")).
+		Where(where...).
+		Prepared(true).ToSQL()
+	if err != nil {
+		return "", nil, "", nil, errors.Wrap(err, "invalid count SQL for access log")
+	}
+
+	return selectSQL, selectArgs, countSQL, countArgs, nil
+}
+
+func (d *Dialect) selectAccessedRecordsQuery(IDs []int) (string, []interface{}, error) {
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.From("access_log_records").
+		Select("access_log_id", "record_type", "record_id").
+		Where(goqu.C("access_log_id").In(IDs)).
+		Order(goqu.C("record_id").Asc()).
+		Prepared(true).ToSQL()
+}
@@ -0,0 +1,273 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/pkg/errors"
+)
+
+type actionDeltaRecord struct {
+	PropertyName string         `db:"property_name"`
+	PropertyType string         `db:"property_type"`
+	FromValue    sql.NullString `db:"from_value"`
+	ToValue      sql.NullString `db:"to_value"`
+}
+
+type propertyChangeRecord struct {
+	ActionID     int            `db:"action_id"`
+	ActionUID    string         `db:"action_uid"`
+	ActionName   string         `db:"action_name"`
+	EmittedAt    time.Time      `db:"emitted_at"`
+	PropertyName string         `db:"property_name"`
+	PropertyType string         `db:"property_type"`
+	FromValue    sql.NullString `db:"from_value"`
+	ToValue      sql.NullString `db:"to_value"`
+}
+
+type ActionDeltaRepository struct {
+	*Tx
+}
+
+// static check of correct interface implementation
+var _ db.ActionDeltaRepository = (*ActionDeltaRepository)(nil)
+
+// Create - stores every property change of the action,
+// entityID is the target entity of the action and can be empty
+func (r *ActionDeltaRepository) Create(ctx context.Context, actionID, entityID model.ID, delta model.Delta) error {
+	if len(delta) == 0 {
+		return nil
+	}
+
+	q, args, err := r.dialect.createActionDeltaQuery(actionID, entityID, delta)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := r.tx.PreparexContext(ctx, q)
+	if err != nil {
+		return errors.Wrap(err, "could not prepare insert statement for action deltas")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	if _, err := stmt.ExecContext(ctx, args...); err != nil {
+		return errors.Wrapf(err, "could not insert deltas of action with ID %d", actionID)
+	}
+
+	return nil
+}
+
+// SelectByActionID - property changes of the action in the order they were sent
+func (r *ActionDeltaRepository) SelectByActionID(ctx context.Context, actionID model.ID) (model.Delta, error) {
+	q, args, err := r.dialect.selectActionDeltaQuery(actionID)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := r.tx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare select statement for action deltas")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	var records []actionDeltaRecord
+	if err := stmt.SelectContext(ctx, &records, args...); err != nil {
+		return nil, errors.Wrapf(err, "could not select deltas of action with ID %d", actionID)
+	}
+
+	return mapActionDeltaRecordsToModel(records)
+}
+
+// PropertyHistory - all changes of the entity property ordered by action emitted at time
+func (r *ActionDeltaRepository) PropertyHistory(
+	ctx context.Context,
+	entityID model.ID,
+	propertyName string,
+	cursor *db.Cursor,
+) (*model.PropertyChangeCollection, error) {
+	sQ, err := r.dialect.propertyHistoryQuery(entityID, propertyName, cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := r.tx.PreparexContext(ctx, sQ.SelectSQL)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare select statement for property history")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	cntStmt, err := r.tx.PreparexContext(ctx, sQ.CountSQL)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare count statement for property history")
+	}
+
+	defer func() { _ = cntStmt.Close() }()
+
+	var records []propertyChangeRecord
+	if err := stmt.SelectContext(ctx, &records, sQ.SelectArgs...); err != nil {
+		return nil, errors.Wrapf(err, "could not select history of property %s of entity %d", propertyName, entityID)
+	}
+
+	var cnt int
+	if err := cntStmt.GetContext(ctx, &cnt, sQ.CountArgs...); err != nil {
+		return nil, errors.Wrapf(err, "could not count history of property %s of entity %d", propertyName, entityID)
+	}
+
+	return mapPropertyChangesToCollection(records, cnt, cursor.Page, cursor.PerPage)
+}
+
+// SelectByEntityID - property changes made by actions targeting the entity
+// that were emitted not later than until, in the order they should be replayed
+func (r *ActionDeltaRepository) SelectByEntityID(
+	ctx context.Context,
+	entityID model.ID,
+	until time.Time,
+) ([]model.PropertyChange, error) {
+	q, args, err := r.dialect.selectEntityPropertyChangesQuery(entityID, until)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := r.tx.PreparexContext(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare select statement for entity property changes")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	var records []propertyChangeRecord
+	if err := stmt.SelectContext(ctx, &records, args...); err != nil {
+		return nil, errors.Wrapf(err, "could not select property changes of entity %d", entityID)
+	}
+
+	return mapPropertyChangeRecordsToModel(records)
+}
+
+func (d *Dialect) createActionDeltaQuery(actionID, entityID model.ID, delta model.Delta) (string, []interface{}, error) {
+	if !actionID.Valid() {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	var entity interface{}
+	if entityID.Valid() {
+		entity = entityID.Int64()
+	}
+
+	rows := make([]interface{}, len(delta))
+	for i := range delta {
+		from, err := encodePropertyValue(delta[i].From)
+		if err != nil {
+			return "", nil, err
+		}
+
+		to, err := encodePropertyValue(delta[i].To)
+		if err != nil {
+			return "", nil, err
+		}
+
+		rows[i] = goqu.Record{
+			"action_id":     actionID.Int64(),
+			"entity_id":     entity,
+			"property_name": delta[i].PropertyName,
+			"property_type": string(delta[i].CurrentPropertyType),
+			"from_value":    d.json(from),
+			"to_value":      d.json(to),
+		}
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.Insert("action_deltas").Rows(rows...).Prepared(true).ToSQL()
+}
+
+func (d *Dialect) selectActionDeltaQuery(actionID model.ID) (string, []interface{}, error) {
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.From("action_deltas").
+		Select("property_name", "property_type", "from_value", "to_value").
+		Where(goqu.C("action_id").Eq(actionID.Int64())).
+		Order(goqu.C("id").Asc()).
+		Prepared(true).
+		ToSQL()
+}
+
+func (d *Dialect) propertyHistoryQuery(entityID model.ID, propertyName string, c *db.Cursor) (*SelectQuery, error) {
+	if propertyName == "" {
+		return nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	exp := goqu.Ex{
+		"d.entity_id":     entityID.Int64(),
+		"d.property_name": propertyName,
+	}
+
+	countQ := dialect.Select(goqu.L("count(*)").As("cnt")).
+		From(goqu.T("action_deltas").As("d")).
+		Where(exp)
+
+	q := d.propertyChangesSelect().Where(exp)
+
+	if c.Sort.GetOrDefault("emittedAt", db.ASCOrder) == db.ASCOrder {
+		q = q.Order(goqu.I("a.emitted_at").Asc(), goqu.I("d.id").Asc())
+	} else {
+		q = q.Order(goqu.I("a.emitted_at").Desc(), goqu.I("d.id").Desc())
+	}
+
+	q = q.Limit(c.PerPage).Offset(c.Offset())
+
+	sQ := SelectQuery{}
+	if query, args, err := q.Prepared(true).ToSQL(); err != nil {
+		return nil, errors.Wrap(err, "invalid select SQL for property history")
+	} else {
+		sQ.SelectSQL = query
+		sQ.SelectArgs = args
+	}
+
+	if query, args, err := countQ.Prepared(true).ToSQL(); err != nil {
+		return nil, errors.Wrap(err, "invalid count SQL for property history")
+	} else {
+		sQ.CountSQL = query
+		sQ.CountArgs = args
+	}
+
+	return &sQ, nil
+}
+
+func (d *Dialect) propertyChangesSelect() *goqu.SelectDataset {
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.Select(
+		goqu.I("a.id").As("action_id"),
+		goqu.I("a.uid").As("action_uid"),
+		goqu.I("a.name").As("action_name"),
+		goqu.I("a.emitted_at").As("emitted_at"),
+		goqu.I("d.property_name").As("property_name"),
+		goqu.I("d.property_type").As("property_type"),
+		goqu.I("d.from_value").As("from_value"),
+		goqu.I("d.to_value").As("to_value"),
+	).
+		From(goqu.T("action_deltas").As("d")).
+		InnerJoin(goqu.T("actions").As("a"), goqu.On(goqu.Ex{"d.action_id": goqu.I("a.id")}))
+}
+
+func (d *Dialect) selectEntityPropertyChangesQuery(entityID model.ID, until time.Time) (string, []interface{}, error) {
+	if !entityID.Valid() {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	return d.propertyChangesSelect().
+		Where(goqu.I("d.entity_id").Eq(entityID.Int64())).
+		Where(goqu.I("a.emitted_at").Lte(until)).
+		Order(goqu.I("a.emitted_at").Asc(), goqu.I("d.id").Asc()).
+		Prepared(true).
+		ToSQL()
+}
@@ -0,0 +1,63 @@
+package sqlstore
+
+import (
+	"testing"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createActionDeltaQuery(t *testing.T) {
+	delta := model.Delta{
+		{PropertyName: "title", CurrentPropertyType: model.StringProperty, From: nil, To: "foo"},
+		{PropertyName: "rating", CurrentPropertyType: model.FloatProperty, From: 1, To: 1.5},
+	}
+
+	q, args, err := MySQL8.createActionDeltaQuery(model.ID(3), model.ID(0), delta)
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		"INSERT INTO `action_deltas` (`action_id`, `entity_id`, `from_value`, `property_name`, `property_type`, `to_value`) VALUES (?, ?, ?, ?, ?, ?), (?, ?, ?, ?, ?, ?)",
+		q,
+	)
+	assert.Equal(t, []interface{}{
+		int64(3), nil, nil, "title", "string", `"foo"`,
+		int64(3), nil, "1", "rating", "float", "1.5",
+	}, args)
+
+	_, _, err = MySQL8.createActionDeltaQuery(model.ID(0), model.ID(0), delta)
+	assert.Equal(t, db.ErrInvalidQueryInput, err)
+
+	// values are cast to the jsonb columns
+	q, args, err = Postgres.createActionDeltaQuery(model.ID(3), model.ID(4), delta[:1])
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		`INSERT INTO "action_deltas" ("action_id", "entity_id", "from_value", "property_name", "property_type", "to_value") VALUES ($1, $2, $3::jsonb, $4, $5, $6::jsonb)`,
+		q,
+	)
+	assert.Equal(t, []interface{}{int64(3), int64(4), nil, "title", "string", `"foo"`}, args)
+}
+
+func Test_propertyHistoryQuery(t *testing.T) {
+	c := db.NewCursor(2, 10, []string{"emittedAt"})
+
+	sQ, err := MySQL8.propertyHistoryQuery(model.ID(5), "title", c)
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		"SELECT `a`.`id` AS `action_id`, `a`.`uid` AS `action_uid`, `a`.`name` AS `action_name`, `a`.`emitted_at` AS `emitted_at`, `d`.`property_name` AS `property_name`, `d`.`property_type` AS `property_type`, `d`.`from_value` AS `from_value`, `d`.`to_value` AS `to_value` FROM `action_deltas` AS `d` INNER JOIN `actions` AS `a` ON (`d`.`action_id` = `a`.`id`) WHERE ((`d`.`entity_id` = ?) AND (`d`.`property_name` = ?)) ORDER BY `a`.`emitted_at` ASC, `d`.`id` ASC LIMIT ? OFFSET ?",
+		sQ.SelectSQL,
+	)
+	assert.Equal(t, []interface{}{int64(5), "title", int64(10), int64(10)}, sQ.SelectArgs)
+	assert.Equal(
+		t,
+		"SELECT count(*) AS `cnt` FROM `action_deltas` AS `d` WHERE ((`d`.`entity_id` = ?) AND (`d`.`property_name` = ?))",
+		sQ.CountSQL,
+	)
+	assert.Equal(t, []interface{}{int64(5), "title"}, sQ.CountArgs)
+
+	_, err = MySQL8.propertyHistoryQuery(model.ID(5), "", c)
+	assert.Equal(t, db.ErrInvalidQueryInput, err)
+}
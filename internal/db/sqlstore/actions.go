@@ -0,0 +1,7 @@
+package sqlstore
+
+// ActionRepository - queries of actions the SQL backends share,
+// their own action repositories embed it
+type ActionRepository struct {
+	*Tx
+}
@@ -0,0 +1,294 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/pkg/errors"
+)
+
+type apiKeyRecord struct {
+	ID        int          `db:"id"`
+	Name      string       `db:"name"`
+	Prefix    string       `db:"prefix"`
+	KeyHash   string       `db:"key_hash"`
+	CreatedAt time.Time    `db:"created_at"`
+	RotatedAt sql.NullTime `db:"rotated_at"`
+	RevokedAt sql.NullTime `db:"revoked_at"`
+}
+
+type apiKeyServiceRecord struct {
+	APIKeyID    int    `db:"api_key_id"`
+	ServiceID   int    `db:"id"`
+	ServiceName string `db:"name"`
+}
+
+type APIKeyRepository struct {
+	*Tx
+}
+
+// static check of correct interface implementation
+var _ db.APIKeyRepository = (*APIKeyRepository)(nil)
+
+// Create - stores the key and binds it to its services, services must already exist
+func (r *APIKeyRepository) Create(ctx context.Context, k *model.APIKey) (*model.APIKey, error) {
+	q, args, err := r.dialect.createAPIKeyQuery(k)
+	if err != nil {
+		return nil, err
+	}
+
+	newID, err := r.insert(ctx, q, args)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not insert api key [%s]", k.Name)
+	}
+
+	bindQ, bindArgs, err := r.dialect.bindAPIKeyServicesQuery(model.ID(newID), k.Services)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := r.tx.ExecContext(ctx, bindQ, bindArgs...); err != nil {
+		return nil, errors.Wrapf(err, "could not bind api key [%s] to its services", k.Name)
+	}
+
+	return r.FirstByID(ctx, model.ID(newID))
+}
+
+// Select - all keys, revoked ones included, ordered by ID
+func (r *APIKeyRepository) Select(ctx context.Context) ([]model.APIKey, error) {
+	q, args, err := r.dialect.selectAPIKeysQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []apiKeyRecord
+	if err := r.tx.SelectContext(ctx, &records, q, args...); err != nil {
+		return nil, errors.Wrap(err, "could not select api keys")
+	}
+
+	return r.withServices(ctx, records)
+}
+
+// FirstByID - key with its services
+func (r *APIKeyRepository) FirstByID(ctx context.Context, ID model.ID) (*model.APIKey, error) {
+	q, args, err := r.dialect.firstAPIKeyQuery(goqu.C("id").Eq(int(ID)))
+	if err != nil {
+		return nil, err
+	}
+
+	return r.first(ctx, q, args)
+}
+
+// FirstByHash - key with its services by the hash of the key
+func (r *APIKeyRepository) FirstByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	if hash == "" {
+		return nil, db.ErrInvalidQueryInput
+	}
+
+	q, args, err := r.dialect.firstAPIKeyQuery(goqu.C("key_hash").Eq(hash))
+	if err != nil {
+		return nil, err
+	}
+
+	return r.first(ctx, q, args)
+}
+
+// Rotate - replaces the key, the previous one stops working at once
+func (r *APIKeyRepository) Rotate(ctx context.Context, ID model.ID, prefix, hash string, at time.Time) error {
+	q, args, err := r.dialect.rotateAPIKeyQuery(ID, prefix, hash, at)
+	if err != nil {
+		return err
+	}
+
+	return r.update(ctx, ID, q, args)
+}
+
+// Revoke - revoked key is kept, but never authenticates again
+func (r *APIKeyRepository) Revoke(ctx context.Context, ID model.ID, at time.Time) error {
+	q, args, err := r.dialect.revokeAPIKeyQuery(ID, at)
+	if err != nil {
+		return err
+	}
+
+	return r.update(ctx, ID, q, args)
+}
+
+func (r *APIKeyRepository) update(ctx context.Context, ID model.ID, q string, args []interface{}) error {
+	result, err := r.tx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return errors.Wrapf(err, "could not update api key with ID %d", ID)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "could not get number of affected rows")
+	}
+
+	if affected == 0 {
+		return db.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *APIKeyRepository) first(ctx context.Context, q string, args []interface{}) (*model.APIKey, error) {
+	var record apiKeyRecord
+	if err := r.tx.GetContext(ctx, &record, q, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, db.ErrNotFound
+		}
+
+		return nil, errors.Wrap(err, "could not get api key")
+	}
+
+	keys, err := r.withServices(ctx, []apiKeyRecord{record})
+	if err != nil {
+		return nil, err
+	}
+
+	return &keys[0], nil
+}
+
+// withServices - maps records to keys and loads their services in one query
+func (r *APIKeyRepository) withServices(ctx context.Context, records []apiKeyRecord) ([]model.APIKey, error) {
+	keys := make([]model.APIKey, len(records))
+	if len(records) == 0 {
+		return keys, nil
+	}
+
+	IDs := make([]int, len(records))
+	for i := range records {
+		IDs[i] = records[i].ID
+	}
+
+	q, args, err := r.dialect.selectAPIKeyServicesQuery(IDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var services []apiKeyServiceRecord
+	if err := r.tx.SelectContext(ctx, &services, q, args...); err != nil {
+		return nil, errors.Wrap(err, "could not select services of api keys")
+	}
+
+	return mapAPIKeyRecordsToModel(records, services), nil
+}
+
+func mapAPIKeyRecordsToModel(records []apiKeyRecord, services []apiKeyServiceRecord) []model.APIKey {
+	byKey := make(map[int][]model.Microservice)
+	for _, s := range services {
+		byKey[s.APIKeyID] = append(byKey[s.APIKeyID], model.Microservice{ID: model.ID(s.ServiceID), Name: s.ServiceName})
+	}
+
+	keys := make([]model.APIKey, len(records))
+	for i, r := range records {
+		keys[i] = model.APIKey{
+			ID:        model.ID(r.ID),
+			Name:      r.Name,
+			Prefix:    r.Prefix,
+			Hash:      r.KeyHash,
+			Services:  byKey[r.ID],
+			CreatedAt: model.JSONTime{Time: r.CreatedAt},
+		}
+
+		if r.RotatedAt.Valid {
+			keys[i].RotatedAt = model.JSONTime{Time: r.RotatedAt.Time}
+		}
+
+		if r.RevokedAt.Valid {
+			keys[i].RevokedAt = model.JSONTime{Time: r.RevokedAt.Time}
+		}
+	}
+
+	return keys
+}
+
+func (d *Dialect) createAPIKeyQuery(k *model.APIKey) (string, []interface{}, error) {
+	if k.Name == "" || k.Prefix == "" || k.Hash == "" || len(k.Services) == 0 {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	return d.returningID(dialect.Insert("api_keys").Rows(goqu.Record{
+		"name":     k.Name,
+		"prefix":   k.Prefix,
+		"key_hash": k.Hash,
+	})).Prepared(true).ToSQL()
+}
+
+func (d *Dialect) bindAPIKeyServicesQuery(ID model.ID, services []model.Microservice) (string, []interface{}, error) {
+	rows := make([]interface{}, len(services))
+	for i := range services {
+		if !services[i].ID.Valid() {
+			return "", nil, db.ErrInvalidQueryInput
+		}
+
+		rows[i] = goqu.Record{"api_key_id": int(ID), "microservice_id": int(services[i].ID)}
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.Insert("api_key_services").Rows(rows...).Prepared(true).ToSQL()
+}
+
+func (d *Dialect) selectAPIKeysQuery() (string, []interface{}, error) {
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.From("api_keys").
+		Select("id", "name", "prefix", "key_hash", "created_at", "rotated_at", "revoked_at").
+		Order(goqu.C("id").Asc()).
+		Prepared(true).ToSQL()
+}
+
+func (d *Dialect) firstAPIKeyQuery(where goqu.Expression) (string, []interface{}, error) {
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.From("api_keys").
+		Select("id", "name", "prefix", "key_hash", "created_at", "rotated_at", "revoked_at").
+		Where(where).
+		Limit(1).
+		Prepared(true).ToSQL()
+}
+
+func (d *Dialect) selectAPIKeyServicesQuery(IDs []int) (string, []interface{}, error) {
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.From(goqu.T("api_key_services").As("aks")).
+		Join(goqu.T("microservices").As("ms"), goqu.On(goqu.I("ms.id").Eq(goqu.I("aks.microservice_id")))).
+		Select("aks.api_key_id", "ms.id", "ms.name").
+		Where(goqu.I("aks.api_key_id").In(IDs)).
+		Order(goqu.I("ms.name").Asc()).
+		Prepared(true).ToSQL()
+}
+
+func (d *Dialect) rotateAPIKeyQuery(ID model.ID, prefix, hash string, at time.Time) (string, []interface{}, error) {
+	if !ID.Valid() || prefix == "" || hash == "" {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.Update("api_keys").Set(goqu.Record{
+		"prefix":     prefix,
+		"key_hash":   hash,
+		"rotated_at": at.UTC(),
+	}).Where(goqu.C("id").Eq(int(ID)), goqu.C("revoked_at").IsNull()).Prepared(true).ToSQL()
+}
+
+func (d *Dialect) revokeAPIKeyQuery(ID model.ID, at time.Time) (string, []interface{}, error) {
+	if !ID.Valid() {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.Update("api_keys").
+		Set(goqu.Record{"revoked_at": at.UTC()}).
+		Where(goqu.C("id").Eq(int(ID)), goqu.C("revoked_at").IsNull()).
+		Prepared(true).ToSQL()
+}
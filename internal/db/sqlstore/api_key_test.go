@@ -0,0 +1,40 @@
+package sqlstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_apiKeyQueries(t *testing.T) {
+	t.Run("bind services", func(t *testing.T) {
+		q, args, err := MySQL8.bindAPIKeyServicesQuery(3, []model.Microservice{{ID: 1}, {ID: 2}})
+		assert.NoError(t, err)
+		assert.Equal(t, "INSERT INTO `api_key_services` (`api_key_id`, `microservice_id`) VALUES (?, ?), (?, ?)", q)
+		assert.Equal(t, []interface{}{int64(3), int64(1), int64(3), int64(2)}, args)
+	})
+
+	t.Run("service without ID", func(t *testing.T) {
+		_, _, err := MySQL8.bindAPIKeyServicesQuery(3, []model.Microservice{{Name: "foo"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("rotate only active key", func(t *testing.T) {
+		at := time.Date(2021, 4, 1, 10, 0, 0, 0, time.UTC)
+		q, args, err := MySQL8.rotateAPIKeyQuery(3, "0a1b2c3d", "hash", at)
+		assert.NoError(t, err)
+		assert.Equal(t, "UPDATE `api_keys` SET `key_hash`=?,`prefix`=?,`rotated_at`=? WHERE ((`id` = ?) AND (`revoked_at` IS NULL))", q)
+		assert.Equal(t, []interface{}{"hash", "0a1b2c3d", at, int64(3)}, args)
+	})
+
+	t.Run("services of keys", func(t *testing.T) {
+		q, args, err := MySQL8.selectAPIKeyServicesQuery([]int{1, 2})
+		assert.NoError(t, err)
+		assert.Equal(t, "SELECT `aks`.`api_key_id`, `ms`.`id`, `ms`.`name` FROM `api_key_services` AS `aks` "+
+			"INNER JOIN `microservices` AS `ms` ON (`ms`.`id` = `aks`.`microservice_id`) "+
+			"WHERE (`aks`.`api_key_id` IN (?, ?)) ORDER BY `ms`.`name` ASC", q)
+		assert.Equal(t, []interface{}{int64(1), int64(2)}, args)
+	})
+}
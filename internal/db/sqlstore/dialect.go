@@ -0,0 +1,97 @@
+// Package sqlstore - repositories the SQL backends share, they differ only by the dialect
+// of their queries, everything else specific to a backend is kept in its own package
+package sqlstore
+
+import (
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+
+	_ "github.com/doug-martin/goqu/v9/dialect/mysql"
+	_ "github.com/doug-martin/goqu/v9/dialect/postgres"
+	_ "github.com/doug-martin/goqu/v9/dialect/sqlite3"
+)
+
+// Dialect - what sets the SQL backends apart in the queries they share
+type Dialect struct {
+	// Name - name of the goqu dialect
+	Name string
+	// Returning - inserts return the ID of the new row, instead of reporting it as the last insert ID
+	Returning bool
+	// JSON - cast of the JSON encoded value to the JSON column type, the value is stored as is when nil
+	JSON func(value interface{}) interface{}
+	// Text - cast of the integer expression to text
+	Text func(e exp.Expression) exp.Expression
+	// StatsBuckets - expressions truncating the time column to the start of its bucket of each interval
+	StatsBuckets map[model.StatsInterval]func(column exp.IdentifierExpression) exp.LiteralExpression
+	// ActionDependents - tables referring to actions by action_id that are not cleared
+	// by foreign keys, when actions are removed
+	ActionDependents []string
+}
+
+var SQLite = &Dialect{
+	Name: "sqlite3",
+	Text: func(e exp.Expression) exp.Expression {
+		return goqu.L("CAST(? AS TEXT)", e)
+	},
+	StatsBuckets: map[model.StatsInterval]func(column exp.IdentifierExpression) exp.LiteralExpression{
+		model.MinuteInterval: formatBucket("strftime('%Y-%m-%d %H:%M:00', ?)"),
+		model.HourInterval:   formatBucket("strftime('%Y-%m-%d %H:00:00', ?)"),
+		model.DayInterval:    formatBucket("strftime('%Y-%m-%d 00:00:00', ?)"),
+	},
+}
+
+var Postgres = &Dialect{
+	Name:      "postgres",
+	Returning: true,
+	JSON: func(value interface{}) interface{} {
+		return goqu.L("?::jsonb", value)
+	},
+	Text: func(e exp.Expression) exp.Expression {
+		return goqu.L("?::text", e)
+	},
+	StatsBuckets: map[model.StatsInterval]func(column exp.IdentifierExpression) exp.LiteralExpression{
+		model.MinuteInterval: formatBucket("date_trunc('minute', ?)"),
+		model.HourInterval:   formatBucket("date_trunc('hour', ?)"),
+		model.DayInterval:    formatBucket("date_trunc('day', ?)"),
+	},
+}
+
+// MySQL8 - the partitioned actions table cannot be referenced by foreign keys,
+// so the rows referring to actions are removed along with them one table after another
+var MySQL8 = &Dialect{
+	Name: "mysql8",
+	Text: func(e exp.Expression) exp.Expression {
+		return goqu.L("CAST(? AS CHAR)", e)
+	},
+	StatsBuckets: map[model.StatsInterval]func(column exp.IdentifierExpression) exp.LiteralExpression{
+		model.MinuteInterval: formatBucket("DATE_FORMAT(?, '%Y-%m-%d %H:%i:00')"),
+		model.HourInterval:   formatBucket("DATE_FORMAT(?, '%Y-%m-%d %H:00:00')"),
+		model.DayInterval:    formatBucket("DATE_FORMAT(?, '%Y-%m-%d 00:00:00')"),
+	},
+	ActionDependents: []string{"action_deltas", "action_keys", "action_texts"},
+}
+
+func formatBucket(format string) func(column exp.IdentifierExpression) exp.LiteralExpression {
+	return func(column exp.IdentifierExpression) exp.LiteralExpression {
+		return goqu.L(format, column)
+	}
+}
+
+// json - the JSON encoded value cast to the JSON column type of the dialect
+func (d *Dialect) json(value interface{}) interface{} {
+	if d.JSON == nil {
+		return value
+	}
+
+	return d.JSON(value)
+}
+
+// returningID - the insert returns the ID of the new row, when the dialect reports it that way
+func (d *Dialect) returningID(ds *goqu.InsertDataset) *goqu.InsertDataset {
+	if d.Returning {
+		return ds.Returning("id")
+	}
+
+	return ds
+}
@@ -0,0 +1,151 @@
+package sqlstore
+
+import (
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/pkg/errors"
+)
+
+// FilterColumn - column a filter key applies to, with the converter of its values
+type FilterColumn struct {
+	Key     string
+	Column  exp.IdentifierExpression
+	Convert db.ValueConverter
+}
+
+// ConditionExpressions - expressions of the conditions on the column, values are converted
+// to the type of the column first, so that invalid ones are reported as invalid query input
+func ConditionExpressions(column exp.IdentifierExpression, conditions []db.Condition, convert db.ValueConverter) ([]exp.Expression, error) {
+	var result []exp.Expression
+
+	for _, c := range conditions {
+		if len(c.Values) == 0 {
+			return nil, errors.Wrapf(db.ErrInvalidQueryInput, "no values to compare %s with", c.Operator)
+		}
+
+		values := make([]interface{}, 0, len(c.Values))
+		for _, v := range c.Values {
+			value, err := convert(v)
+			if err != nil {
+				return nil, err
+			}
+
+			values = append(values, value)
+		}
+
+		switch c.Operator {
+		case db.EqOperator:
+			result = append(result, column.Eq(values[0]))
+		case db.InOperator:
+			result = append(result, column.In(values))
+		case db.GteOperator:
+			result = append(result, column.Gte(values[0]))
+		case db.LteOperator:
+			result = append(result, column.Lte(values[0]))
+		case db.PrefixOperator:
+			result = append(result, goqu.L("? LIKE ? ESCAPE '"+db.LikeEscape+"'", column, db.LikePrefix(c.Value())))
+		default:
+			return nil, errors.Wrapf(db.ErrInvalidQueryInput, "unsupported filter operator %s", c.Operator)
+		}
+	}
+
+	return result, nil
+}
+
+// InSubquery - column IN (subquery), goqu wraps subqueries given to In in a second pair of parentheses,
+// which turns them into scalar subqueries matching their first row only
+func InSubquery(column exp.IdentifierExpression, subquery *goqu.SelectDataset) exp.Expression {
+	return goqu.L("? IN ?", column, subquery)
+}
+
+// actionFilterColumns - filters of the action listing compared with the columns of actions,
+// time ranges are gte and lte conditions of emittedAt and registeredAt
+var actionFilterColumns = []FilterColumn{
+	{Key: "id", Column: goqu.C("id"), Convert: db.IntValue},
+	{Key: "uid", Column: goqu.C("uid"), Convert: db.StringValue},
+	{Key: "parentUid", Column: goqu.C("parent_uid"), Convert: db.StringValue},
+	{Key: "name", Column: goqu.C("name"), Convert: db.StringValue},
+	{Key: "status", Column: goqu.C("status"), Convert: db.IntValue},
+	{Key: "isAsync", Column: goqu.C("is_async"), Convert: db.BoolValue},
+	{Key: "actorEntityId", Column: goqu.C("actor_entity_id"), Convert: db.IntValue},
+	{Key: "targetEntityId", Column: goqu.C("target_entity_id"), Convert: db.IntValue},
+	{Key: "emittedAt", Column: goqu.C("emitted_at"), Convert: db.TimeValue},
+	{Key: "registeredAt", Column: goqu.C("registered_at"), Convert: db.TimeValue},
+}
+
+// actionEntityFilters - filters of the action listing by the entity type or the service
+// of the actor or the target, the column is the one of the filtered_* tables of filteredEntities
+var actionEntityFilters = []struct {
+	FilterColumn
+	entity string
+}{
+	{FilterColumn{Key: "actorService", Column: goqu.I("filtered_ms.name"), Convert: db.StringValue}, "actions.actor_entity_id"},
+	{FilterColumn{Key: "targetService", Column: goqu.I("filtered_ms.name"), Convert: db.StringValue}, "actions.target_entity_id"},
+	{FilterColumn{Key: "actorEntityType", Column: goqu.I("filtered_et.name"), Convert: db.StringValue}, "actions.actor_entity_id"},
+	{FilterColumn{Key: "targetEntityType", Column: goqu.I("filtered_et.name"), Convert: db.StringValue}, "actions.target_entity_id"},
+}
+
+// ActionFilterExpressions - expressions of all the filters of the action listing
+func ActionFilterExpressions(dialect goqu.DialectWrapper, f *db.Filter) ([]exp.Expression, error) {
+	var where []exp.Expression
+
+	for _, fc := range actionFilterColumns {
+		expressions, err := ConditionExpressions(fc.Column, f.Conditions(fc.Key), fc.Convert)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s filter", fc.Key)
+		}
+
+		where = append(where, expressions...)
+	}
+
+	for _, ef := range actionEntityFilters {
+		expressions, err := ConditionExpressions(ef.Column, f.Conditions(ef.Key), ef.Convert)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s filter", ef.Key)
+		}
+
+		if len(expressions) > 0 {
+			where = append(where, InSubquery(goqu.I(ef.entity), filteredEntities(dialect, expressions)))
+		}
+	}
+
+	if f.HasServices() {
+		where = append(where, ActionServicesExpression(dialect, f.Services()))
+	}
+
+	return where, nil
+}
+
+// filteredEntities - IDs of the entities matching the expressions on them, their types or services
+func filteredEntities(dialect goqu.DialectWrapper, expressions []exp.Expression) *goqu.SelectDataset {
+	return dialect.From(goqu.T("entities").As("filtered_e")).
+		Join(goqu.T("entity_types").As("filtered_et"), goqu.On(goqu.I("filtered_et.id").Eq(goqu.I("filtered_e.entity_type_id")))).
+		Join(goqu.T("microservices").As("filtered_ms"), goqu.On(goqu.I("filtered_ms.id").Eq(goqu.I("filtered_et.service_id")))).
+		Select("filtered_e.id").
+		Where(expressions...)
+}
+
+// ActionServicesExpression - actions whose actor or target entity belongs to one of the services
+func ActionServicesExpression(dialect goqu.DialectWrapper, services []string) goqu.Expression {
+	entities := dialect.From(goqu.T("entities").As("scope_e")).
+		Join(goqu.T("entity_types").As("scope_et"), goqu.On(goqu.I("scope_et.id").Eq(goqu.I("scope_e.entity_type_id")))).
+		Join(goqu.T("microservices").As("scope_ms"), goqu.On(goqu.I("scope_ms.id").Eq(goqu.I("scope_et.service_id")))).
+		Select("scope_e.id").
+		Where(goqu.I("scope_ms.name").In(services))
+
+	return goqu.Or(
+		InSubquery(goqu.I("actions.actor_entity_id"), entities),
+		InSubquery(goqu.I("actions.target_entity_id"), entities),
+	)
+}
+
+// EntityServicesExpression - entities whose entity type belongs to one of the services
+func EntityServicesExpression(dialect goqu.DialectWrapper, services []string) goqu.Expression {
+	types := dialect.From(goqu.T("entity_types").As("scope_et")).
+		Join(goqu.T("microservices").As("scope_ms"), goqu.On(goqu.I("scope_ms.id").Eq(goqu.I("scope_et.service_id")))).
+		Select("scope_et.id").
+		Where(goqu.I("scope_ms.name").In(services))
+
+	return InSubquery(goqu.I("e.entity_type_id"), types)
+}
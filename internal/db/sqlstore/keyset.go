@@ -0,0 +1,32 @@
+package sqlstore
+
+import (
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+)
+
+// KeysetExpression - rows following the keyset of the cursor in the order of the column and then of ID,
+// or preceding it, when the cursor goes backwards
+func KeysetExpression(column, id exp.IdentifierExpression, order db.Order, c *db.Cursor) exp.Expression {
+	k, forward := c.After, true
+	if c.Before != nil {
+		k, forward = c.Before, false
+	}
+
+	if (order == db.DESCOrder) == forward {
+		return goqu.Or(column.Lt(k.At), goqu.And(column.Eq(k.At), id.Lt(k.ID.Int64())))
+	}
+
+	return goqu.Or(column.Gt(k.At), goqu.And(column.Eq(k.At), id.Gt(k.ID.Int64())))
+}
+
+// KeysetOrder - order of the column and then of ID, reversed when the cursor goes backwards,
+// so that the rows nearest to the keyset come first
+func KeysetOrder(column, id exp.IdentifierExpression, order db.Order, c *db.Cursor) []exp.OrderedExpression {
+	if (order == db.DESCOrder) != (c.Before != nil) {
+		return []exp.OrderedExpression{column.Desc(), id.Desc()}
+	}
+
+	return []exp.OrderedExpression{column.Asc(), id.Asc()}
+}
@@ -0,0 +1,121 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/pkg/errors"
+)
+
+func mapActionDeltaRecordsToModel(records []actionDeltaRecord) (model.Delta, error) {
+	delta := make(model.Delta, 0, len(records))
+	for i := range records {
+		from, err := decodePropertyValue(records[i].FromValue)
+		if err != nil {
+			return nil, err
+		}
+
+		to, err := decodePropertyValue(records[i].ToValue)
+		if err != nil {
+			return nil, err
+		}
+
+		delta = append(delta, model.PropertyDelta{
+			PropertyName:        records[i].PropertyName,
+			CurrentPropertyType: model.PropertyType(records[i].PropertyType),
+			From:                from,
+			To:                  to,
+		})
+	}
+
+	return delta, nil
+}
+
+func mapPropertyChangesToCollection(
+	records []propertyChangeRecord,
+	cnt int,
+	page, perPage uint,
+) (*model.PropertyChangeCollection, error) {
+	items, err := mapPropertyChangeRecordsToModel(records)
+	if err != nil {
+		return nil, err
+	}
+
+	result := model.PropertyChangeCollection{Items: items}
+	result.Meta.Total = &cnt
+	result.Meta.Page = int(page)
+	result.Meta.PerPage = int(perPage)
+
+	return &result, nil
+}
+
+func mapPropertyChangeRecordsToModel(records []propertyChangeRecord) ([]model.PropertyChange, error) {
+	changes := make([]model.PropertyChange, 0, len(records))
+
+	for i := range records {
+		from, err := decodePropertyValue(records[i].FromValue)
+		if err != nil {
+			return nil, err
+		}
+
+		to, err := decodePropertyValue(records[i].ToValue)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, model.PropertyChange{
+			ActionID:     model.ID(records[i].ActionID),
+			ActionUID:    model.UID(records[i].ActionUID),
+			ActionName:   records[i].ActionName,
+			PropertyName: records[i].PropertyName,
+			PropertyType: model.PropertyType(records[i].PropertyType),
+			From:         from,
+			To:           to,
+			EmittedAt:    model.JSONTime{Time: records[i].EmittedAt},
+		})
+	}
+
+	return changes, nil
+}
+
+// encodePropertyValue - property values are kept as JSON, null becomes NULL
+func encodePropertyValue(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode property value")
+	}
+
+	return string(b), nil
+}
+
+func decodePropertyValue(v sql.NullString) (interface{}, error) {
+	if !v.Valid {
+		return nil, nil
+	}
+
+	var result interface{}
+	if err := json.Unmarshal([]byte(v.String), &result); err != nil {
+		return nil, errors.Wrapf(err, "could not decode property value %s", v.String)
+	}
+
+	return result, nil
+}
+
+func mapActionStatsRecordsToModel(records []actionStatsRecord) []model.ActionStatsCount {
+	counts := make([]model.ActionStatsCount, 0, len(records))
+
+	for i := range records {
+		counts = append(counts, model.ActionStatsCount{
+			Bucket: records[i].Bucket.Time,
+			Group:  records[i].Group,
+			Count:  records[i].Cnt,
+		})
+	}
+
+	return counts
+}
@@ -0,0 +1,11 @@
+package sqlstore
+
+// SelectQuery - select of a page of a listing along with the count of all its rows
+type SelectQuery struct {
+	SelectSQL  string
+	SelectArgs []interface{}
+	CountSQL   string
+	CountArgs  []interface{}
+	// Keyed - rows are ordered by a keyset, so continuation tokens can be handed out
+	Keyed bool
+}
@@ -0,0 +1,318 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/pkg/errors"
+)
+
+type retentionRuleRecord struct {
+	ID         int       `db:"id"`
+	Service    string    `db:"service"`
+	ActionName string    `db:"action_name"`
+	KeepDays   int       `db:"keep_days"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+type retentionCandidateRecord struct {
+	ID            int            `db:"id"`
+	UID           string         `db:"uid"`
+	Name          string         `db:"name"`
+	Service       string         `db:"service"`
+	RegisteredAt  time.Time      `db:"registered_at"`
+	PrevChainHash sql.NullString `db:"prev_chain_hash"`
+	ChainHash     sql.NullString `db:"chain_hash"`
+}
+
+type actionTombstoneRecord struct {
+	ActionID      int            `db:"action_id"`
+	UID           string         `db:"uid"`
+	Name          string         `db:"name"`
+	PrevChainHash sql.NullString `db:"prev_chain_hash"`
+	ChainHash     sql.NullString `db:"chain_hash"`
+	RuleID        sql.NullInt64  `db:"rule_id"`
+	PrunedAt      time.Time      `db:"pruned_at"`
+}
+
+type RetentionRuleRepository struct {
+	*Tx
+}
+
+// static check of correct interface implementation
+var _ db.RetentionRuleRepository = (*RetentionRuleRepository)(nil)
+
+func (r *RetentionRuleRepository) Create(ctx context.Context, rule *model.RetentionRule) (*model.RetentionRule, error) {
+	q, args, err := r.dialect.createRetentionRuleQuery(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	newID, err := r.insert(ctx, q, args)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not insert retention rule")
+	}
+
+	created := *rule
+	created.ID = model.ID(newID)
+
+	return &created, nil
+}
+
+// Select - all rules ordered by ID
+func (r *RetentionRuleRepository) Select(ctx context.Context) (model.RetentionRules, error) {
+	q, args, err := r.dialect.selectRetentionRulesQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []retentionRuleRecord
+	if err := r.tx.SelectContext(ctx, &records, q, args...); err != nil {
+		return nil, errors.Wrap(err, "could not select retention rules")
+	}
+
+	rules := make(model.RetentionRules, len(records))
+	for i := range records {
+		rules[i] = model.RetentionRule{
+			ID:         model.ID(records[i].ID),
+			Service:    records[i].Service,
+			ActionName: records[i].ActionName,
+			KeepDays:   records[i].KeepDays,
+			CreatedAt:  model.JSONTime{Time: records[i].CreatedAt},
+		}
+	}
+
+	return rules, nil
+}
+
+// Delete - removes the rule, actions it pruned are left with their tombstones
+func (r *RetentionRuleRepository) Delete(ctx context.Context, ID model.ID) error {
+	q, args, err := goqu.Dialect(r.dialect.Name).Delete("retention_rules").
+		Where(goqu.C("id").Eq(int(ID))).
+		Prepared(true).ToSQL()
+	if err != nil {
+		return errors.Wrap(err, "could not build delete retention rule query")
+	}
+
+	result, err := r.tx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return errors.Wrapf(err, "could not delete retention rule with ID %d", ID)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return db.ErrNotFound
+	}
+
+	return nil
+}
+
+// SelectExpiring - at most limit actions following afterID registered before the given time,
+// the service is the one of the actor or of the target, when there is no actor
+func (r *ActionRepository) SelectExpiring(
+	ctx context.Context,
+	afterID model.ID,
+	before time.Time,
+	limit int,
+) ([]model.RetentionCandidate, error) {
+	q, args, err := r.dialect.selectExpiringActionsQuery(afterID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []retentionCandidateRecord
+	if err := r.tx.SelectContext(ctx, &records, q, args...); err != nil {
+		return nil, errors.Wrapf(err, "could not select expiring actions after ID %d", afterID)
+	}
+
+	candidates := make([]model.RetentionCandidate, len(records))
+	for i := range records {
+		candidates[i] = model.RetentionCandidate{
+			ID:            model.ID(records[i].ID),
+			UID:           model.UID(records[i].UID),
+			Name:          records[i].Name,
+			Service:       records[i].Service,
+			RegisteredAt:  records[i].RegisteredAt,
+			PrevChainHash: records[i].PrevChainHash.String,
+			ChainHash:     records[i].ChainHash.String,
+		}
+	}
+
+	return candidates, nil
+}
+
+// Prune - stores the tombstones and removes their actions along with the rows referring to them
+func (r *ActionRepository) Prune(ctx context.Context, tombstones []model.ActionTombstone) error {
+	if len(tombstones) == 0 {
+		return nil
+	}
+
+	q, args, err := r.dialect.createActionTombstonesQuery(tombstones)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.tx.ExecContext(ctx, q, args...); err != nil {
+		return errors.Wrap(err, "could not insert action tombstones")
+	}
+
+	IDs := make([]int, len(tombstones))
+	for i := range tombstones {
+		IDs[i] = int(tombstones[i].ActionID)
+	}
+
+	q, args, err = goqu.Dialect(r.dialect.Name).Delete("actions").
+		Where(goqu.C("id").In(IDs)).
+		Prepared(true).ToSQL()
+	if err != nil {
+		return errors.Wrap(err, "could not build prune actions query")
+	}
+
+	if _, err := r.tx.ExecContext(ctx, q, args...); err != nil {
+		return errors.Wrap(err, "could not delete pruned actions")
+	}
+
+	return r.DeleteActionDependents(ctx, IDs)
+}
+
+// SelectTombstones - tombstones from fromID up to toID ordered by ID, zero toID means no upper bound
+func (r *ActionRepository) SelectTombstones(ctx context.Context, fromID, toID model.ID) ([]model.ActionTombstone, error) {
+	q, args, err := r.dialect.selectActionTombstonesQuery(fromID, toID)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []actionTombstoneRecord
+	if err := r.tx.SelectContext(ctx, &records, q, args...); err != nil {
+		return nil, errors.Wrapf(err, "could not select action tombstones from ID %d", fromID)
+	}
+
+	tombstones := make([]model.ActionTombstone, len(records))
+	for i := range records {
+		tombstones[i] = model.ActionTombstone{
+			ActionID:      model.ID(records[i].ActionID),
+			UID:           model.UID(records[i].UID),
+			Name:          records[i].Name,
+			PrevChainHash: records[i].PrevChainHash.String,
+			ChainHash:     records[i].ChainHash.String,
+			RuleID:        model.ID(records[i].RuleID.Int64),
+			PrunedAt:      model.JSONTime{Time: records[i].PrunedAt},
+		}
+	}
+
+	return tombstones, nil
+}
+
+func (d *Dialect) createRetentionRuleQuery(rule *model.RetentionRule) (string, []interface{}, error) {
+	if rule.KeepDays < 1 {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	return d.returningID(dialect.Insert("retention_rules").Rows(goqu.Record{
+		"service":     rule.Service,
+		"action_name": rule.ActionName,
+		"keep_days":   rule.KeepDays,
+		"created_at":  rule.CreatedAt.UTC(),
+	})).Prepared(true).ToSQL()
+}
+
+func (d *Dialect) selectRetentionRulesQuery() (string, []interface{}, error) {
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.From("retention_rules").
+		Select("id", "service", "action_name", "keep_days", "created_at").
+		Order(goqu.C("id").Asc()).
+		Prepared(true).ToSQL()
+}
+
+func (d *Dialect) selectExpiringActionsQuery(afterID model.ID, before time.Time, limit int) (string, []interface{}, error) {
+	if limit <= 0 {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.From("actions").
+		Select(
+			goqu.I("actions.id"), goqu.I("actions.uid"), goqu.I("actions.name"),
+			goqu.I("actions.registered_at"), goqu.I("actions.prev_chain_hash"), goqu.I("actions.chain_hash"),
+			goqu.COALESCE(goqu.I("actor_ms.name"), goqu.I("target_ms.name"), "").As("service"),
+		).
+		LeftJoin(goqu.T("entities").As("actor_e"), goqu.On(goqu.I("actor_e.id").Eq(goqu.I("actions.actor_entity_id")))).
+		LeftJoin(goqu.T("entity_types").As("actor_et"), goqu.On(goqu.I("actor_et.id").Eq(goqu.I("actor_e.entity_type_id")))).
+		LeftJoin(goqu.T("microservices").As("actor_ms"), goqu.On(goqu.I("actor_ms.id").Eq(goqu.I("actor_et.service_id")))).
+		LeftJoin(goqu.T("entities").As("target_e"), goqu.On(goqu.I("target_e.id").Eq(goqu.I("actions.target_entity_id")))).
+		LeftJoin(goqu.T("entity_types").As("target_et"), goqu.On(goqu.I("target_et.id").Eq(goqu.I("target_e.entity_type_id")))).
+		LeftJoin(goqu.T("microservices").As("target_ms"), goqu.On(goqu.I("target_ms.id").Eq(goqu.I("target_et.service_id")))).
+		Where(
+			goqu.I("actions.id").Gt(afterID.Int64()),
+			goqu.I("actions.registered_at").Lt(before.UTC()),
+		).
+		Order(goqu.I("actions.id").Asc()).
+		Limit(uint(limit)).
+		Prepared(true).ToSQL()
+}
+
+func (d *Dialect) createActionTombstonesQuery(tombstones []model.ActionTombstone) (string, []interface{}, error) {
+	rows, err := ActionTombstoneRows(tombstones)
+	if err != nil {
+		return "", nil, err
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.Insert("action_tombstones").Rows(rows...).Prepared(true).ToSQL()
+}
+
+// ActionTombstoneRows - rows of the action_tombstones table, the chain hashes are only
+// stored for the actions that were chained
+func ActionTombstoneRows(tombstones []model.ActionTombstone) ([]interface{}, error) {
+	rows := make([]interface{}, len(tombstones))
+	for i, t := range tombstones {
+		if !t.ActionID.Valid() {
+			return nil, db.ErrInvalidQueryInput
+		}
+
+		row := goqu.Record{
+			"action_id":       t.ActionID.Int64(),
+			"uid":             t.UID.String(),
+			"name":            t.Name,
+			"prev_chain_hash": nil,
+			"chain_hash":      nil,
+			"rule_id":         nil,
+			"pruned_at":       t.PrunedAt.UTC(),
+		}
+
+		if t.ChainHash != "" {
+			row["prev_chain_hash"] = t.PrevChainHash
+			row["chain_hash"] = t.ChainHash
+		}
+
+		if t.RuleID.Valid() {
+			row["rule_id"] = t.RuleID.Int64()
+		}
+
+		rows[i] = row
+	}
+
+	return rows, nil
+}
+
+func (d *Dialect) selectActionTombstonesQuery(fromID, toID model.ID) (string, []interface{}, error) {
+	dialect := goqu.Dialect(d.Name)
+
+	q := dialect.From("action_tombstones").
+		Select("action_id", "uid", "name", "prev_chain_hash", "chain_hash", "rule_id", "pruned_at").
+		Where(goqu.C("action_id").Gte(fromID.Int64()))
+
+	if toID.Valid() {
+		q = q.Where(goqu.C("action_id").Lte(toID.Int64()))
+	}
+
+	return q.Order(goqu.C("action_id").Asc()).Prepared(true).ToSQL()
+}
@@ -0,0 +1,172 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/pkg/errors"
+)
+
+type signingSecretRecord struct {
+	ServiceID         int            `db:"microservice_id"`
+	Secret            string         `db:"secret"`
+	PreviousSecret    sql.NullString `db:"previous_secret"`
+	PreviousExpiresAt sql.NullTime   `db:"previous_expires_at"`
+	RotatedAt         time.Time      `db:"rotated_at"`
+}
+
+func (r *signingSecretRecord) ToModel() *model.SigningSecrets {
+	s := &model.SigningSecrets{
+		ServiceID:      model.ID(r.ServiceID),
+		Secret:         r.Secret,
+		PreviousSecret: r.PreviousSecret.String,
+		RotatedAt:      r.RotatedAt,
+	}
+
+	if r.PreviousExpiresAt.Valid {
+		s.PreviousExpiresAt = r.PreviousExpiresAt.Time
+	}
+
+	return s
+}
+
+type SigningSecretRepository struct {
+	*Tx
+}
+
+// static check of correct interface implementation
+var _ db.SigningSecretRepository = (*SigningSecretRepository)(nil)
+
+// FirstByServiceID - secrets of the microservice, db.ErrNotFound when none were issued yet
+func (r *SigningSecretRepository) FirstByServiceID(ctx context.Context, serviceID model.ID) (*model.SigningSecrets, error) {
+	if !serviceID.Valid() {
+		return nil, db.ErrInvalidQueryInput
+	}
+
+	q, args, err := r.dialect.firstSigningSecretQuery(goqu.I("ss.microservice_id").Eq(int(serviceID)))
+	if err != nil {
+		return nil, err
+	}
+
+	return r.first(ctx, q, args)
+}
+
+// FirstByServiceName - secrets of the microservice, db.ErrNotFound when none were issued yet
+func (r *SigningSecretRepository) FirstByServiceName(ctx context.Context, name string) (*model.SigningSecrets, error) {
+	if name == "" {
+		return nil, db.ErrInvalidQueryInput
+	}
+
+	q, args, err := r.dialect.firstSigningSecretQuery(goqu.I("ms.name").Eq(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return r.first(ctx, q, args)
+}
+
+// Save - stores secrets of the microservice, replacing the previous ones
+func (r *SigningSecretRepository) Save(ctx context.Context, s *model.SigningSecrets) error {
+	q, args, err := r.dialect.updateSigningSecretQuery(s)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.tx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return errors.Wrapf(err, "could not update signing secret of microservice %d", s.ServiceID)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "could not get number of affected rows")
+	}
+
+	if affected > 0 {
+		return nil
+	}
+
+	q, args, err = r.dialect.createSigningSecretQuery(s)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.tx.ExecContext(ctx, q, args...); err != nil {
+		return errors.Wrapf(err, "could not insert signing secret of microservice %d", s.ServiceID)
+	}
+
+	return nil
+}
+
+func (r *SigningSecretRepository) first(ctx context.Context, q string, args []interface{}) (*model.SigningSecrets, error) {
+	var record signingSecretRecord
+	if err := r.tx.GetContext(ctx, &record, q, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, db.ErrNotFound
+		}
+
+		return nil, errors.Wrap(err, "could not get signing secret")
+	}
+
+	return record.ToModel(), nil
+}
+
+func signingSecretRecordFrom(s *model.SigningSecrets) goqu.Record {
+	r := goqu.Record{
+		"secret":              s.Secret,
+		"previous_secret":     nil,
+		"previous_expires_at": nil,
+		"rotated_at":          s.RotatedAt.UTC(),
+	}
+
+	if s.PreviousSecret != "" {
+		r["previous_secret"] = s.PreviousSecret
+		r["previous_expires_at"] = s.PreviousExpiresAt.UTC()
+	}
+
+	return r
+}
+
+func (d *Dialect) createSigningSecretQuery(s *model.SigningSecrets) (string, []interface{}, error) {
+	if !s.ServiceID.Valid() || s.Secret == "" {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	record := signingSecretRecordFrom(s)
+	record["microservice_id"] = int(s.ServiceID)
+
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.Insert("microservice_secrets").Rows(record).Prepared(true).ToSQL()
+}
+
+func (d *Dialect) updateSigningSecretQuery(s *model.SigningSecrets) (string, []interface{}, error) {
+	if !s.ServiceID.Valid() || s.Secret == "" {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.Update("microservice_secrets").
+		Set(signingSecretRecordFrom(s)).
+		Where(goqu.C("microservice_id").Eq(int(s.ServiceID))).
+		Prepared(true).ToSQL()
+}
+
+func (d *Dialect) firstSigningSecretQuery(where goqu.Expression) (string, []interface{}, error) {
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.From(goqu.T("microservice_secrets").As("ss")).
+		Join(goqu.T("microservices").As("ms"), goqu.On(goqu.I("ms.id").Eq(goqu.I("ss.microservice_id")))).
+		Select(
+			"ss.microservice_id", "ss.secret", "ss.previous_secret",
+			"ss.previous_expires_at", "ss.rotated_at",
+		).
+		Where(where).
+		Limit(1).
+		Prepared(true).ToSQL()
+}
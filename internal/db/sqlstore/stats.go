@@ -0,0 +1,139 @@
+package sqlstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/pkg/errors"
+)
+
+type actionStatsRecord struct {
+	Bucket statsBucket `db:"bucket"`
+	Group  string      `db:"grp"`
+	Cnt    int         `db:"cnt"`
+}
+
+// statsBucket - start of the bucket, truncated times are formatted as text
+// by the dialects without a truncating function that keeps their type
+type statsBucket struct {
+	time.Time
+}
+
+func (b *statsBucket) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case time.Time:
+		b.Time = v.UTC()
+		return nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return errors.Errorf("unsupported stats bucket %v", src)
+	}
+
+	t, err := time.ParseInLocation(model.DefaultTimeFormat, s, time.UTC)
+	if err != nil {
+		return errors.Wrapf(err, "could not parse stats bucket %s", s)
+	}
+
+	b.Time = t
+
+	return nil
+}
+
+// Stats - numbers of the actions matching the filter and the time range per bucket and group
+func (r *ActionRepository) Stats(ctx context.Context, q *model.ActionStatsQuery, f *db.Filter) ([]model.ActionStatsCount, error) {
+	query, args, err := r.dialect.actionStatsQuery(q, f)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := r.tx.PreparexContext(ctx, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare action stats query")
+	}
+
+	defer func() { _ = stmt.Close() }()
+
+	var records []actionStatsRecord
+	if err := stmt.SelectContext(ctx, &records, args...); err != nil {
+		return nil, errors.Wrap(err, "could not select action stats")
+	}
+
+	return mapActionStatsRecordsToModel(records), nil
+}
+
+// actionStatsQuery - the filtered actions are selected first, so that the columns the filter
+// refers to are not confused with the ones of the entity types and services of the group
+func (d *Dialect) actionStatsQuery(q *model.ActionStatsQuery, f *db.Filter) (string, []interface{}, error) {
+	dialect := goqu.Dialect(d.Name)
+
+	bucket, ok := d.StatsBuckets[q.Interval]
+	if !ok {
+		return "", nil, errors.Wrapf(db.ErrInvalidQueryInput, "unsupported stats interval %s", q.Interval)
+	}
+
+	where, err := ActionFilterExpressions(dialect, f)
+	if err != nil {
+		return "", nil, err
+	}
+
+	where = append(where, goqu.C("registered_at").Gte(q.From.UTC()), goqu.C("registered_at").Lt(q.To.UTC()))
+
+	actions := dialect.From("actions").
+		Select("id", "name", "status", "actor_entity_id", "target_entity_id", "registered_at").
+		Where(where...)
+
+	ds := dialect.From(actions.As("stats_a"))
+
+	var group exp.Expression
+	switch q.GroupBy {
+	case model.NoGroup:
+		group = goqu.L("''")
+	case model.GroupByName:
+		group = goqu.I("stats_a.name")
+	case model.GroupByStatus:
+		group = d.Text(goqu.I("stats_a.status"))
+	case model.GroupByActorService, model.GroupByActorEntityType:
+		ds = joinStatsEntities(ds, "stats_a.actor_entity_id")
+		group = statsEntityGroup(q.GroupBy)
+	case model.GroupByTargetService, model.GroupByTargetEntityType:
+		ds = joinStatsEntities(ds, "stats_a.target_entity_id")
+		group = statsEntityGroup(q.GroupBy)
+	default:
+		return "", nil, errors.Wrapf(db.ErrInvalidQueryInput, "unsupported stats group %s", q.GroupBy)
+	}
+
+	return ds.
+		Select(
+			bucket(goqu.I("stats_a.registered_at")).As("bucket"),
+			goqu.L("COALESCE(?, '')", group).As("grp"),
+			goqu.COUNT("*").As("cnt"),
+		).
+		GroupBy(goqu.I("bucket"), goqu.I("grp")).
+		Prepared(true).
+		ToSQL()
+}
+
+// joinStatsEntities - the entity of the column along with its type and service,
+// actions without the entity are kept
+func joinStatsEntities(ds *goqu.SelectDataset, column string) *goqu.SelectDataset {
+	return ds.
+		LeftJoin(goqu.T("entities").As("stats_e"), goqu.On(goqu.I("stats_e.id").Eq(goqu.I(column)))).
+		LeftJoin(goqu.T("entity_types").As("stats_et"), goqu.On(goqu.I("stats_et.id").Eq(goqu.I("stats_e.entity_type_id")))).
+		LeftJoin(goqu.T("microservices").As("stats_ms"), goqu.On(goqu.I("stats_ms.id").Eq(goqu.I("stats_et.service_id"))))
+}
+
+func statsEntityGroup(group model.StatsGroup) exp.Expression {
+	if group == model.GroupByActorService || group == model.GroupByTargetService {
+		return goqu.I("stats_ms.name")
+	}
+
+	return goqu.I("stats_et.name")
+}
@@ -0,0 +1,68 @@
+package sqlstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_actionStatsQuery(t *testing.T) {
+	from := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	f := db.NewFilter(nil).Add("name", "invoice_paid")
+
+	t.Run("mysql by status", func(t *testing.T) {
+		q := &model.ActionStatsQuery{From: from, To: from.AddDate(0, 0, 1), Interval: model.MinuteInterval, GroupBy: model.GroupByStatus}
+
+		query, args, err := MySQL8.actionStatsQuery(q, f)
+		if !assert.NoError(t, err) {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "SELECT DATE_FORMAT(`stats_a`.`registered_at`, '%Y-%m-%d %H:%i:00') AS `bucket`, COALESCE(CAST(`stats_a`.`status` AS CHAR), '') AS `grp`, COUNT(*) AS `cnt` "+
+			"FROM (SELECT `id`, `name`, `status`, `actor_entity_id`, `target_entity_id`, `registered_at` FROM `actions` "+
+			"WHERE ((`name` = ?) AND (`registered_at` >= ?) AND (`registered_at` < ?))) AS `stats_a` "+
+			"GROUP BY `bucket`, `grp`", query)
+		assert.Equal(t, []interface{}{"invoice_paid", from, from.AddDate(0, 0, 1)}, args)
+	})
+
+	t.Run("postgres by target service", func(t *testing.T) {
+		q := &model.ActionStatsQuery{From: from, To: from.AddDate(0, 0, 1), Interval: model.HourInterval, GroupBy: model.GroupByTargetService}
+
+		query, args, err := Postgres.actionStatsQuery(q, f)
+		if !assert.NoError(t, err) {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, `SELECT date_trunc('hour', "stats_a"."registered_at") AS "bucket", COALESCE("stats_ms"."name", '') AS "grp", COUNT(*) AS "cnt" `+
+			`FROM (SELECT "id", "name", "status", "actor_entity_id", "target_entity_id", "registered_at" FROM "actions" `+
+			`WHERE (("name" = $1) AND ("registered_at" >= $2) AND ("registered_at" < $3))) AS "stats_a" `+
+			`LEFT JOIN "entities" AS "stats_e" ON ("stats_e"."id" = "stats_a"."target_entity_id") `+
+			`LEFT JOIN "entity_types" AS "stats_et" ON ("stats_et"."id" = "stats_e"."entity_type_id") `+
+			`LEFT JOIN "microservices" AS "stats_ms" ON ("stats_ms"."id" = "stats_et"."service_id") `+
+			`GROUP BY "bucket", "grp"`, query)
+		assert.Equal(t, []interface{}{"invoice_paid", from, from.AddDate(0, 0, 1)}, args)
+	})
+
+	t.Run("unsupported interval", func(t *testing.T) {
+		_, _, err := Postgres.actionStatsQuery(&model.ActionStatsQuery{From: from, To: from.AddDate(0, 0, 1), Interval: "week"}, db.NewFilter(nil))
+		assert.Equal(t, db.ErrInvalidQueryInput, errors.Cause(err))
+	})
+}
+
+func Test_statsBucket(t *testing.T) {
+	expected := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	for _, src := range []interface{}{expected, "2020-05-01 10:00:00", []byte("2020-05-01 10:00:00")} {
+		var b statsBucket
+		if assert.NoError(t, b.Scan(src)) {
+			assert.Equal(t, expected, b.Time)
+		}
+	}
+
+	var b statsBucket
+	assert.Error(t, b.Scan(42))
+}
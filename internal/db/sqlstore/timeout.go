@@ -0,0 +1,465 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/pkg/errors"
+)
+
+type timeoutRuleRecord struct {
+	ID             int       `db:"id"`
+	Service        string    `db:"service"`
+	ActionName     string    `db:"action_name"`
+	TimeoutSeconds int       `db:"timeout_seconds"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
+type timeoutCandidateRecord struct {
+	ID           int       `db:"id"`
+	UID          string    `db:"uid"`
+	Name         string    `db:"name"`
+	Service      string    `db:"service"`
+	Status       int       `db:"status"`
+	RegisteredAt time.Time `db:"registered_at"`
+}
+
+type timeoutSweepRecord struct {
+	ID         int          `db:"id"`
+	StartedAt  time.Time    `db:"started_at"`
+	FinishedAt sql.NullTime `db:"finished_at"`
+	Scanned    int          `db:"scanned"`
+	TimedOut   int          `db:"timed_out"`
+}
+
+func (r *timeoutSweepRecord) ToModel() model.TimeoutSweep {
+	s := model.TimeoutSweep{
+		ID:        model.ID(r.ID),
+		StartedAt: model.JSONTime{Time: r.StartedAt},
+		Scanned:   r.Scanned,
+		TimedOut:  r.TimedOut,
+	}
+
+	if r.FinishedAt.Valid {
+		s.FinishedAt = model.JSONTime{Time: r.FinishedAt.Time}
+	}
+
+	return s
+}
+
+type actionTimeoutRecord struct {
+	ID             int           `db:"id"`
+	SweepID        int           `db:"sweep_id"`
+	ActionID       int           `db:"action_id"`
+	ActionUID      string        `db:"action_uid"`
+	ActionName     string        `db:"action_name"`
+	Service        string        `db:"service"`
+	RuleID         sql.NullInt64 `db:"rule_id"`
+	PreviousStatus int           `db:"previous_status"`
+	RegisteredAt   time.Time     `db:"registered_at"`
+	TimedOutAt     time.Time     `db:"timed_out_at"`
+}
+
+var timeoutSweepColumns = []interface{}{"id", "started_at", "finished_at", "scanned", "timed_out"}
+
+type TimeoutRepository struct {
+	*Tx
+}
+
+// static check of correct interface implementation
+var _ db.TimeoutRepository = (*TimeoutRepository)(nil)
+
+func (r *TimeoutRepository) CreateRule(ctx context.Context, rule *model.TimeoutRule) (*model.TimeoutRule, error) {
+	q, args, err := r.dialect.createTimeoutRuleQuery(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	newID, err := r.insert(ctx, q, args)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not insert timeout rule")
+	}
+
+	created := *rule
+	created.ID = model.ID(newID)
+
+	return &created, nil
+}
+
+// SelectRules - all rules ordered by ID
+func (r *TimeoutRepository) SelectRules(ctx context.Context) (model.TimeoutRules, error) {
+	q, args, err := goqu.Dialect(r.dialect.Name).From("timeout_rules").
+		Select("id", "service", "action_name", "timeout_seconds", "created_at").
+		Order(goqu.C("id").Asc()).
+		Prepared(true).ToSQL()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not build select timeout rules query")
+	}
+
+	var records []timeoutRuleRecord
+	if err := r.tx.SelectContext(ctx, &records, q, args...); err != nil {
+		return nil, errors.Wrap(err, "could not select timeout rules")
+	}
+
+	rules := make(model.TimeoutRules, len(records))
+	for i := range records {
+		rules[i] = model.TimeoutRule{
+			ID:             model.ID(records[i].ID),
+			Service:        records[i].Service,
+			ActionName:     records[i].ActionName,
+			TimeoutSeconds: records[i].TimeoutSeconds,
+			CreatedAt:      model.JSONTime{Time: records[i].CreatedAt},
+		}
+	}
+
+	return rules, nil
+}
+
+// DeleteRule - removes the rule, timeouts it caused are kept
+func (r *TimeoutRepository) DeleteRule(ctx context.Context, ID model.ID) error {
+	q, args, err := goqu.Dialect(r.dialect.Name).Delete("timeout_rules").
+		Where(goqu.C("id").Eq(int(ID))).
+		Prepared(true).ToSQL()
+	if err != nil {
+		return errors.Wrap(err, "could not build delete timeout rule query")
+	}
+
+	result, err := r.tx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return errors.Wrapf(err, "could not delete timeout rule with ID %d", ID)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return db.ErrNotFound
+	}
+
+	return nil
+}
+
+// SelectCandidates - at most limit async actions following afterID still in progress, registered
+// before the given time, the service is the one of the actor or of the target, when there is no actor
+func (r *TimeoutRepository) SelectCandidates(
+	ctx context.Context,
+	afterID model.ID,
+	before time.Time,
+	limit int,
+) ([]model.TimeoutCandidate, error) {
+	q, args, err := r.dialect.selectTimeoutCandidatesQuery(afterID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []timeoutCandidateRecord
+	if err := r.tx.SelectContext(ctx, &records, q, args...); err != nil {
+		return nil, errors.Wrapf(err, "could not select actions in progress after ID %d", afterID)
+	}
+
+	candidates := make([]model.TimeoutCandidate, len(records))
+	for i := range records {
+		candidates[i] = model.TimeoutCandidate{
+			ID:           model.ID(records[i].ID),
+			UID:          model.UID(records[i].UID),
+			Name:         records[i].Name,
+			Service:      records[i].Service,
+			Status:       model.Status(records[i].Status),
+			RegisteredAt: records[i].RegisteredAt,
+		}
+	}
+
+	return candidates, nil
+}
+
+// TimeOut - the status is only changed while the action is still in progress,
+// so that a completion reported in the meantime is not overwritten
+func (r *TimeoutRepository) TimeOut(ctx context.Context, t *model.ActionTimeout) (bool, error) {
+	q, args, err := r.dialect.timeOutActionQuery(t.ActionID)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := r.tx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return false, errors.Wrapf(err, "could not time out action with ID %d", t.ActionID)
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return false, errors.Wrap(err, "could not count timed out actions")
+	} else if n == 0 {
+		return false, nil
+	}
+
+	q, args, err = r.dialect.createActionTimeoutQuery(t)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := r.tx.ExecContext(ctx, q, args...); err != nil {
+		return false, errors.Wrapf(err, "could not insert timeout of action with ID %d", t.ActionID)
+	}
+
+	return true, nil
+}
+
+func (r *TimeoutRepository) CreateSweep(ctx context.Context, s *model.TimeoutSweep) (model.ID, error) {
+	dialect := goqu.Dialect(r.dialect.Name)
+
+	q, args, err := r.dialect.returningID(dialect.Insert("timeout_sweeps").Rows(goqu.Record{
+		"started_at": s.StartedAt.UTC(),
+		"scanned":    s.Scanned,
+		"timed_out":  s.TimedOut,
+	})).Prepared(true).ToSQL()
+	if err != nil {
+		return 0, errors.Wrap(err, "could not build create timeout sweep query")
+	}
+
+	newID, err := r.insert(ctx, q, args)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not insert timeout sweep")
+	}
+
+	return model.ID(newID), nil
+}
+
+func (r *TimeoutRepository) UpdateSweep(ctx context.Context, s *model.TimeoutSweep) error {
+	q, args, err := r.dialect.updateTimeoutSweepQuery(s)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.tx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return errors.Wrapf(err, "could not update timeout sweep with ID %d", s.ID)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return db.ErrNotFound
+	}
+
+	return nil
+}
+
+// SelectSweeps - latest sweeps first, without their timeouts, nil services means all of them
+func (r *TimeoutRepository) SelectSweeps(ctx context.Context, c *db.Cursor, services []string) (*model.TimeoutSweepCollection, error) {
+	dialect := goqu.Dialect(r.dialect.Name)
+
+	q := dialect.From("timeout_sweeps").Select(timeoutSweepColumns...)
+	countQ := dialect.From("timeout_sweeps").Select(goqu.COUNT("*"))
+
+	if services != nil {
+		visible := dialect.From("action_timeouts").
+			InnerJoin(goqu.T("actions"), goqu.On(goqu.I("actions.id").Eq(goqu.I("action_timeouts.action_id")))).
+			Where(ActionServicesExpression(dialect, services))
+
+		timedOut := visible.Select(goqu.COUNT("*")).
+			Where(goqu.I("action_timeouts.sweep_id").Eq(goqu.I("timeout_sweeps.id")))
+
+		q = dialect.From("timeout_sweeps").
+			Select("id", "started_at", "finished_at", "scanned", timedOut.As("timed_out")).
+			Where(InSubquery(goqu.I("timeout_sweeps.id"), visible.Select("action_timeouts.sweep_id")))
+		countQ = countQ.Where(InSubquery(goqu.I("timeout_sweeps.id"), visible.Select("action_timeouts.sweep_id")))
+	}
+
+	selectSQL, selectArgs, err := q.Order(goqu.C("id").Desc()).
+		Limit(c.PerPage).
+		Offset(c.Offset()).
+		Prepared(true).ToSQL()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid select SQL for timeout sweeps")
+	}
+
+	countSQL, countArgs, err := countQ.Prepared(true).ToSQL()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid count SQL for timeout sweeps")
+	}
+
+	var total int
+	if err := r.tx.GetContext(ctx, &total, countSQL, countArgs...); err != nil {
+		return nil, errors.Wrap(err, "could not count timeout sweeps")
+	}
+
+	var records []timeoutSweepRecord
+	if err := r.tx.SelectContext(ctx, &records, selectSQL, selectArgs...); err != nil {
+		return nil, errors.Wrap(err, "could not select timeout sweeps")
+	}
+
+	collection := &model.TimeoutSweepCollection{
+		Items: make([]model.TimeoutSweep, len(records)),
+		Meta:  model.Meta{Page: int(c.Page), PerPage: int(c.PerPage), Total: &total},
+	}
+
+	for i := range records {
+		collection.Items[i] = records[i].ToModel()
+	}
+
+	return collection, nil
+}
+
+// FirstSweepByID - the sweep along with its timeouts ordered by action ID
+func (r *TimeoutRepository) FirstSweepByID(ctx context.Context, ID model.ID) (*model.TimeoutSweep, error) {
+	dialect := goqu.Dialect(r.dialect.Name)
+
+	q, args, err := dialect.From("timeout_sweeps").
+		Select(timeoutSweepColumns...).
+		Where(goqu.C("id").Eq(int(ID))).
+		Prepared(true).ToSQL()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not build first timeout sweep query")
+	}
+
+	var record timeoutSweepRecord
+	if err := r.tx.GetContext(ctx, &record, q, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, db.ErrNotFound
+		}
+
+		return nil, errors.Wrapf(err, "could not get timeout sweep with ID %d", ID)
+	}
+
+	q, args, err = dialect.From("action_timeouts").
+		Select(
+			"id", "sweep_id", "action_id", "action_uid", "action_name", "service",
+			"rule_id", "previous_status", "registered_at", "timed_out_at",
+		).
+		Where(goqu.C("sweep_id").Eq(int(ID))).
+		Order(goqu.C("action_id").Asc()).
+		Prepared(true).ToSQL()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not build select action timeouts query")
+	}
+
+	var records []actionTimeoutRecord
+	if err := r.tx.SelectContext(ctx, &records, q, args...); err != nil {
+		return nil, errors.Wrapf(err, "could not select action timeouts of sweep with ID %d", ID)
+	}
+
+	sweep := record.ToModel()
+	sweep.Timeouts = make([]model.ActionTimeout, len(records))
+	for i := range records {
+		sweep.Timeouts[i] = model.ActionTimeout{
+			ID:             model.ID(records[i].ID),
+			SweepID:        model.ID(records[i].SweepID),
+			ActionID:       model.ID(records[i].ActionID),
+			ActionUID:      model.UID(records[i].ActionUID),
+			ActionName:     records[i].ActionName,
+			Service:        records[i].Service,
+			RuleID:         model.ID(records[i].RuleID.Int64),
+			PreviousStatus: model.Status(records[i].PreviousStatus),
+			RegisteredAt:   model.JSONTime{Time: records[i].RegisteredAt},
+			TimedOutAt:     model.JSONTime{Time: records[i].TimedOutAt},
+		}
+	}
+
+	return &sweep, nil
+}
+
+func (d *Dialect) createTimeoutRuleQuery(rule *model.TimeoutRule) (string, []interface{}, error) {
+	if rule.TimeoutSeconds < 1 {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	return d.returningID(dialect.Insert("timeout_rules").Rows(goqu.Record{
+		"service":         rule.Service,
+		"action_name":     rule.ActionName,
+		"timeout_seconds": rule.TimeoutSeconds,
+		"created_at":      rule.CreatedAt.UTC(),
+	})).Prepared(true).ToSQL()
+}
+
+func (d *Dialect) selectTimeoutCandidatesQuery(afterID model.ID, before time.Time, limit int) (string, []interface{}, error) {
+	if limit <= 0 {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.From("actions").
+		Select(
+			goqu.I("actions.id"), goqu.I("actions.uid"), goqu.I("actions.name"),
+			goqu.I("actions.status"), goqu.I("actions.registered_at"),
+			goqu.COALESCE(goqu.I("actor_ms.name"), goqu.I("target_ms.name"), "").As("service"),
+		).
+		LeftJoin(goqu.T("entities").As("actor_e"), goqu.On(goqu.I("actor_e.id").Eq(goqu.I("actions.actor_entity_id")))).
+		LeftJoin(goqu.T("entity_types").As("actor_et"), goqu.On(goqu.I("actor_et.id").Eq(goqu.I("actor_e.entity_type_id")))).
+		LeftJoin(goqu.T("microservices").As("actor_ms"), goqu.On(goqu.I("actor_ms.id").Eq(goqu.I("actor_et.service_id")))).
+		LeftJoin(goqu.T("entities").As("target_e"), goqu.On(goqu.I("target_e.id").Eq(goqu.I("actions.target_entity_id")))).
+		LeftJoin(goqu.T("entity_types").As("target_et"), goqu.On(goqu.I("target_et.id").Eq(goqu.I("target_e.entity_type_id")))).
+		LeftJoin(goqu.T("microservices").As("target_ms"), goqu.On(goqu.I("target_ms.id").Eq(goqu.I("target_et.service_id")))).
+		Where(
+			goqu.I("actions.id").Gt(afterID.Int64()),
+			goqu.I("actions.is_async").Eq(true),
+			goqu.I("actions.status").In(inProgressStatuses()...),
+			goqu.I("actions.registered_at").Lt(before.UTC()),
+		).
+		Order(goqu.I("actions.id").Asc()).
+		Limit(uint(limit)).
+		Prepared(true).ToSQL()
+}
+
+func (d *Dialect) timeOutActionQuery(ID model.ID) (string, []interface{}, error) {
+	if !ID.Valid() {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.Update("actions").
+		Set(goqu.Record{"status": int64(model.TimedOut)}).
+		Where(
+			goqu.C("id").Eq(ID.Int64()),
+			goqu.C("status").In(inProgressStatuses()...),
+		).
+		Prepared(true).ToSQL()
+}
+
+func (d *Dialect) createActionTimeoutQuery(t *model.ActionTimeout) (string, []interface{}, error) {
+	if !t.SweepID.Valid() || !t.ActionID.Valid() {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	record := goqu.Record{
+		"sweep_id":        t.SweepID.Int64(),
+		"action_id":       t.ActionID.Int64(),
+		"action_uid":      t.ActionUID.String(),
+		"action_name":     t.ActionName,
+		"service":         t.Service,
+		"rule_id":         nil,
+		"previous_status": int64(t.PreviousStatus),
+		"registered_at":   t.RegisteredAt.UTC(),
+		"timed_out_at":    t.TimedOutAt.UTC(),
+	}
+
+	if t.RuleID.Valid() {
+		record["rule_id"] = t.RuleID.Int64()
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.Insert("action_timeouts").Rows(record).Prepared(true).ToSQL()
+}
+
+func (d *Dialect) updateTimeoutSweepQuery(s *model.TimeoutSweep) (string, []interface{}, error) {
+	if !s.ID.Valid() {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.Update("timeout_sweeps").
+		Set(goqu.Record{
+			"finished_at": s.FinishedAt.UTC(),
+			"scanned":     s.Scanned,
+			"timed_out":   s.TimedOut,
+		}).
+		Where(goqu.C("id").Eq(s.ID.Int64())).
+		Prepared(true).ToSQL()
+}
+
+func inProgressStatuses() []interface{} {
+	return []interface{}{int64(model.Pending), int64(model.Processing), int64(model.Retrying)}
+}
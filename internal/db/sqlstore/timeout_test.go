@@ -0,0 +1,33 @@
+package sqlstore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_timeOutActionQuery(t *testing.T) {
+	// a completion reported in the meantime is not overwritten
+	tt := []struct {
+		dialect *Dialect
+		query   string
+	}{
+		{MySQL8, "UPDATE `actions` SET `status`=? WHERE ((`id` = ?) AND (`status` IN (?, ?, ?)))"},
+		{Postgres, "UPDATE \"actions\" SET \"status\"=$1 WHERE ((\"id\" = $2) AND (\"status\" IN ($3, $4, $5)))"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.dialect.Name, func(t *testing.T) {
+			query, args, err := tc.dialect.timeOutActionQuery(5)
+			if !assert.NoError(t, err) {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, tc.query, query)
+			assert.Equal(t, []interface{}{int64(8), int64(5), int64(1), int64(2), int64(3)}, args)
+
+			_, _, err = tc.dialect.timeOutActionQuery(0)
+			assert.Error(t, err)
+		})
+	}
+}
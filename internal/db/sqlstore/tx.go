@@ -0,0 +1,65 @@
+package sqlstore
+
+import (
+	"context"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Tx - transaction of one of the SQL backends along with the dialect of its queries
+type Tx struct {
+	tx      *sqlx.Tx
+	dialect *Dialect
+}
+
+func NewTx(tx *sqlx.Tx, dialect *Dialect) *Tx {
+	return &Tx{
+		tx:      tx,
+		dialect: dialect,
+	}
+}
+
+// insert - runs the insert query built by returningID and reports the ID of the new row
+func (tx *Tx) insert(ctx context.Context, q string, args []interface{}) (int64, error) {
+	if tx.dialect.Returning {
+		var newID int64
+		if err := tx.tx.GetContext(ctx, &newID, q, args...); err != nil {
+			return 0, err
+		}
+
+		return newID, nil
+	}
+
+	result, err := tx.tx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, errors.Wrap(err, "could not retrieve last insert ID")
+	}
+
+	return newID, nil
+}
+
+// DeleteActionDependents - removes the rows referring to the actions
+// that the dialect does not remove along with them
+func (tx *Tx) DeleteActionDependents(ctx context.Context, IDs []int) error {
+	for _, table := range tx.dialect.ActionDependents {
+		q, args, err := goqu.Dialect(tx.dialect.Name).Delete(table).
+			Where(goqu.C("action_id").In(IDs)).
+			Prepared(true).ToSQL()
+		if err != nil {
+			return errors.Wrapf(err, "could not build delete %s query", table)
+		}
+
+		if _, err := tx.tx.ExecContext(ctx, q, args...); err != nil {
+			return errors.Wrapf(err, "could not delete %s of actions", table)
+		}
+	}
+
+	return nil
+}
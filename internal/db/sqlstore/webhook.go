@@ -0,0 +1,477 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/pkg/errors"
+)
+
+type webhookRuleRecord struct {
+	ID          int           `db:"id"`
+	Name        string        `db:"name"`
+	URL         string        `db:"url"`
+	Secret      string        `db:"secret"`
+	Service     string        `db:"service"`
+	EntityType  string        `db:"entity_type"`
+	NamePattern string        `db:"name_pattern"`
+	Status      sql.NullInt64 `db:"status"`
+	Condition   string        `db:"details_condition"`
+	Enabled     bool          `db:"enabled"`
+	CreatedAt   time.Time     `db:"created_at"`
+	UpdatedAt   time.Time     `db:"updated_at"`
+}
+
+func (r *webhookRuleRecord) ToModel() *model.WebhookRule {
+	rule := &model.WebhookRule{
+		ID:          model.ID(r.ID),
+		Name:        r.Name,
+		URL:         r.URL,
+		Secret:      r.Secret,
+		Service:     r.Service,
+		EntityType:  r.EntityType,
+		NamePattern: r.NamePattern,
+		Condition:   r.Condition,
+		Enabled:     r.Enabled,
+		CreatedAt:   model.JSONTime{Time: r.CreatedAt},
+		UpdatedAt:   model.JSONTime{Time: r.UpdatedAt},
+	}
+
+	if r.Status.Valid {
+		status := model.Status(r.Status.Int64)
+		rule.Status = &status
+	}
+
+	return rule
+}
+
+type webhookDeliveryRecord struct {
+	ID            int          `db:"id"`
+	RuleID        int          `db:"rule_id"`
+	ActionID      int          `db:"action_id"`
+	ActionStatus  int          `db:"action_status"`
+	Event         string       `db:"event"`
+	Payload       string       `db:"payload"`
+	State         string       `db:"state"`
+	Attempts      int          `db:"attempts"`
+	ResponseCode  int          `db:"response_code"`
+	LastError     string       `db:"last_error"`
+	NextAttemptAt time.Time    `db:"next_attempt_at"`
+	CreatedAt     time.Time    `db:"created_at"`
+	DeliveredAt   sql.NullTime `db:"delivered_at"`
+}
+
+func (r *webhookDeliveryRecord) ToModel() model.WebhookDelivery {
+	d := model.WebhookDelivery{
+		ID:            model.ID(r.ID),
+		RuleID:        model.ID(r.RuleID),
+		ActionID:      model.ID(r.ActionID),
+		ActionStatus:  model.Status(r.ActionStatus),
+		Event:         model.ActionEventType(r.Event),
+		Payload:       []byte(r.Payload),
+		State:         model.WebhookDeliveryState(r.State),
+		Attempts:      r.Attempts,
+		ResponseCode:  r.ResponseCode,
+		LastError:     r.LastError,
+		NextAttemptAt: model.JSONTime{Time: r.NextAttemptAt},
+		CreatedAt:     model.JSONTime{Time: r.CreatedAt},
+	}
+
+	if r.DeliveredAt.Valid {
+		d.DeliveredAt = model.JSONTime{Time: r.DeliveredAt.Time}
+	}
+
+	return d
+}
+
+var webhookRuleColumns = []interface{}{
+	"id", "name", "url", "secret", "service", "entity_type", "name_pattern",
+	"status", "details_condition", "enabled", "created_at", "updated_at",
+}
+
+var webhookDeliveryColumns = []interface{}{
+	"id", "rule_id", "action_id", "action_status", "event", "payload", "state", "attempts",
+	"response_code", "last_error", "next_attempt_at", "created_at", "delivered_at",
+}
+
+type WebhookRepository struct {
+	*Tx
+}
+
+// static check of correct interface implementation
+var _ db.WebhookRepository = (*WebhookRepository)(nil)
+
+func (r *WebhookRepository) CreateRule(ctx context.Context, rule *model.WebhookRule) (*model.WebhookRule, error) {
+	q, args, err := r.dialect.createWebhookRuleQuery(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	newID, err := r.insert(ctx, q, args)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not insert webhook rule")
+	}
+
+	created := *rule
+	created.ID = model.ID(newID)
+
+	return &created, nil
+}
+
+// SelectRules - all rules ordered by ID
+func (r *WebhookRepository) SelectRules(ctx context.Context) (model.WebhookRules, error) {
+	q, args, err := goqu.Dialect(r.dialect.Name).From("webhook_rules").
+		Select(webhookRuleColumns...).
+		Order(goqu.C("id").Asc()).
+		Prepared(true).ToSQL()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not build select webhook rules query")
+	}
+
+	var records []webhookRuleRecord
+	if err := r.tx.SelectContext(ctx, &records, q, args...); err != nil {
+		return nil, errors.Wrap(err, "could not select webhook rules")
+	}
+
+	rules := make(model.WebhookRules, len(records))
+	for i := range records {
+		rules[i] = *records[i].ToModel()
+	}
+
+	return rules, nil
+}
+
+func (r *WebhookRepository) FirstRuleByID(ctx context.Context, ID model.ID) (*model.WebhookRule, error) {
+	q, args, err := goqu.Dialect(r.dialect.Name).From("webhook_rules").
+		Select(webhookRuleColumns...).
+		Where(goqu.C("id").Eq(int(ID))).
+		Prepared(true).ToSQL()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not build first webhook rule query")
+	}
+
+	var record webhookRuleRecord
+	if err := r.tx.GetContext(ctx, &record, q, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, db.ErrNotFound
+		}
+
+		return nil, errors.Wrapf(err, "could not get webhook rule with ID %d", ID)
+	}
+
+	return record.ToModel(), nil
+}
+
+func (r *WebhookRepository) UpdateRule(ctx context.Context, rule *model.WebhookRule) error {
+	q, args, err := r.dialect.updateWebhookRuleQuery(rule)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.tx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return errors.Wrapf(err, "could not update webhook rule with ID %d", rule.ID)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return db.ErrNotFound
+	}
+
+	return nil
+}
+
+// DeleteRule - removes the rule along with its deliveries
+func (r *WebhookRepository) DeleteRule(ctx context.Context, ID model.ID) error {
+	dialect := goqu.Dialect(r.dialect.Name)
+
+	q, args, err := dialect.Delete("webhook_deliveries").
+		Where(goqu.C("rule_id").Eq(int(ID))).
+		Prepared(true).ToSQL()
+	if err != nil {
+		return errors.Wrap(err, "could not build delete webhook deliveries query")
+	}
+
+	if _, err := r.tx.ExecContext(ctx, q, args...); err != nil {
+		return errors.Wrapf(err, "could not delete deliveries of webhook rule with ID %d", ID)
+	}
+
+	q, args, err = dialect.Delete("webhook_rules").
+		Where(goqu.C("id").Eq(int(ID))).
+		Prepared(true).ToSQL()
+	if err != nil {
+		return errors.Wrap(err, "could not build delete webhook rule query")
+	}
+
+	result, err := r.tx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return errors.Wrapf(err, "could not delete webhook rule with ID %d", ID)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return db.ErrNotFound
+	}
+
+	return nil
+}
+
+// CreateDeliveries - deliveries of a rule about an action with a status
+// it has been delivered about already are skipped
+func (r *WebhookRepository) CreateDeliveries(ctx context.Context, deliveries []model.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	q, args, err := r.dialect.createWebhookDeliveriesQuery(deliveries)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.tx.ExecContext(ctx, q, args...); err != nil {
+		return errors.Wrap(err, "could not insert webhook deliveries")
+	}
+
+	return nil
+}
+
+// SelectDueDeliveries - at most limit pending deliveries due at the given time, most overdue first
+func (r *WebhookRepository) SelectDueDeliveries(ctx context.Context, now time.Time, limit int) ([]model.WebhookDelivery, error) {
+	if limit <= 0 {
+		return nil, db.ErrInvalidQueryInput
+	}
+
+	q, args, err := goqu.Dialect(r.dialect.Name).From("webhook_deliveries").
+		Select(webhookDeliveryColumns...).
+		Where(
+			goqu.C("state").Eq(string(model.DeliveryPending)),
+			goqu.C("next_attempt_at").Lte(now.UTC()),
+		).
+		Order(goqu.C("next_attempt_at").Asc(), goqu.C("id").Asc()).
+		Limit(uint(limit)).
+		Prepared(true).ToSQL()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not build select due webhook deliveries query")
+	}
+
+	var records []webhookDeliveryRecord
+	if err := r.tx.SelectContext(ctx, &records, q, args...); err != nil {
+		return nil, errors.Wrap(err, "could not select due webhook deliveries")
+	}
+
+	deliveries := make([]model.WebhookDelivery, len(records))
+	for i := range records {
+		deliveries[i] = records[i].ToModel()
+	}
+
+	return deliveries, nil
+}
+
+// SelectDeliveries - latest deliveries first, allowed filters are ruleId, actionId and state
+func (r *WebhookRepository) SelectDeliveries(ctx context.Context, c *db.Cursor, f *db.Filter) (*model.WebhookDeliveryCollection, error) {
+	selectSQL, selectArgs, countSQL, countArgs, err := r.dialect.selectWebhookDeliveriesQuery(c, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int
+	if err := r.tx.GetContext(ctx, &total, countSQL, countArgs...); err != nil {
+		return nil, errors.Wrap(err, "could not count webhook deliveries")
+	}
+
+	var records []webhookDeliveryRecord
+	if err := r.tx.SelectContext(ctx, &records, selectSQL, selectArgs...); err != nil {
+		return nil, errors.Wrap(err, "could not select webhook deliveries")
+	}
+
+	collection := &model.WebhookDeliveryCollection{
+		Items: make([]model.WebhookDelivery, len(records)),
+		Meta:  model.Meta{Page: int(c.Page), PerPage: int(c.PerPage), Total: &total},
+	}
+
+	for i := range records {
+		collection.Items[i] = records[i].ToModel()
+	}
+
+	return collection, nil
+}
+
+func (r *WebhookRepository) FirstDeliveryByID(ctx context.Context, ID model.ID) (*model.WebhookDelivery, error) {
+	q, args, err := goqu.Dialect(r.dialect.Name).From("webhook_deliveries").
+		Select(webhookDeliveryColumns...).
+		Where(goqu.C("id").Eq(int(ID))).
+		Prepared(true).ToSQL()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not build first webhook delivery query")
+	}
+
+	var record webhookDeliveryRecord
+	if err := r.tx.GetContext(ctx, &record, q, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, db.ErrNotFound
+		}
+
+		return nil, errors.Wrapf(err, "could not get webhook delivery with ID %d", ID)
+	}
+
+	d := record.ToModel()
+
+	return &d, nil
+}
+
+// UpdateDelivery - stores the outcome of an attempt
+func (r *WebhookRepository) UpdateDelivery(ctx context.Context, d *model.WebhookDelivery) error {
+	q, args, err := r.dialect.updateWebhookDeliveryQuery(d)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.tx.ExecContext(ctx, q, args...)
+	if err != nil {
+		return errors.Wrapf(err, "could not update webhook delivery with ID %d", d.ID)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return db.ErrNotFound
+	}
+
+	return nil
+}
+
+func webhookRuleRecordOf(rule *model.WebhookRule) goqu.Record {
+	record := goqu.Record{
+		"name":              rule.Name,
+		"url":               rule.URL,
+		"service":           rule.Service,
+		"entity_type":       rule.EntityType,
+		"name_pattern":      rule.NamePattern,
+		"status":            nil,
+		"details_condition": rule.Condition,
+		"enabled":           rule.Enabled,
+		"updated_at":        rule.UpdatedAt.UTC(),
+	}
+
+	if rule.Status != nil {
+		record["status"] = int(*rule.Status)
+	}
+
+	return record
+}
+
+func (d *Dialect) createWebhookRuleQuery(rule *model.WebhookRule) (string, []interface{}, error) {
+	if rule.URL == "" || rule.Secret == "" {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	record := webhookRuleRecordOf(rule)
+	record["secret"] = rule.Secret
+	record["created_at"] = rule.CreatedAt.UTC()
+
+	dialect := goqu.Dialect(d.Name)
+
+	return d.returningID(dialect.Insert("webhook_rules").Rows(record)).Prepared(true).ToSQL()
+}
+
+func (d *Dialect) updateWebhookRuleQuery(rule *model.WebhookRule) (string, []interface{}, error) {
+	if !rule.ID.Valid() || rule.URL == "" {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.Update("webhook_rules").
+		Set(webhookRuleRecordOf(rule)).
+		Where(goqu.C("id").Eq(rule.ID.Int64())).
+		Prepared(true).ToSQL()
+}
+
+func (d *Dialect) createWebhookDeliveriesQuery(deliveries []model.WebhookDelivery) (string, []interface{}, error) {
+	rows := make([]interface{}, len(deliveries))
+	for i, d := range deliveries {
+		if !d.RuleID.Valid() || !d.ActionID.Valid() {
+			return "", nil, db.ErrInvalidQueryInput
+		}
+
+		rows[i] = goqu.Record{
+			"rule_id":         d.RuleID.Int64(),
+			"action_id":       d.ActionID.Int64(),
+			"action_status":   int(d.ActionStatus),
+			"event":           string(d.Event),
+			"payload":         string(d.Payload),
+			"state":           string(d.State),
+			"attempts":        d.Attempts,
+			"next_attempt_at": d.NextAttemptAt.UTC(),
+			"created_at":      d.CreatedAt.UTC(),
+		}
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.Insert("webhook_deliveries").Rows(rows...).
+		OnConflict(goqu.DoNothing()).
+		Prepared(true).ToSQL()
+}
+
+func (d *Dialect) updateWebhookDeliveryQuery(delivery *model.WebhookDelivery) (string, []interface{}, error) {
+	if !delivery.ID.Valid() {
+		return "", nil, db.ErrInvalidQueryInput
+	}
+
+	record := goqu.Record{
+		"state":           string(delivery.State),
+		"attempts":        delivery.Attempts,
+		"response_code":   delivery.ResponseCode,
+		"last_error":      delivery.LastError,
+		"next_attempt_at": delivery.NextAttemptAt.UTC(),
+		"delivered_at":    nil,
+	}
+
+	if !delivery.DeliveredAt.IsZero() {
+		record["delivered_at"] = delivery.DeliveredAt.UTC()
+	}
+
+	dialect := goqu.Dialect(d.Name)
+
+	return dialect.Update("webhook_deliveries").
+		Set(record).
+		Where(goqu.C("id").Eq(delivery.ID.Int64())).
+		Prepared(true).ToSQL()
+}
+
+func (d *Dialect) selectWebhookDeliveriesQuery(c *db.Cursor, f *db.Filter) (string, []interface{}, string, []interface{}, error) {
+	dialect := goqu.Dialect(d.Name)
+
+	var where []goqu.Expression
+	for param, column := range map[string]string{"ruleId": "rule_id", "actionId": "action_id"} {
+		if f.Has(param) {
+			where = append(where, goqu.C(column).Eq(f.IntOrDefault(param, 0)))
+		}
+	}
+
+	if f.Has("state") {
+		where = append(where, goqu.C("state").Eq(f.MustString("state")))
+	}
+
+	selectSQL, selectArgs, err := dialect.From("webhook_deliveries").
+		Select(webhookDeliveryColumns...).
+		Where(where...).
+		Order(goqu.C("id").Desc()).
+		Limit(c.PerPage).
+		Offset(c.Offset()).
+		Prepared(true).ToSQL()
+	if err != nil {
+		return "", nil, "", nil, errors.Wrap(err, "invalid select SQL for webhook deliveries")
+	}
+
+	countSQL, countArgs, err := dialect.From("webhook_deliveries").
+		Select(goqu.COUNT("*")).
+		Where(where...).
+		Prepared(true).ToSQL()
+	if err != nil {
+		return "", nil, "", nil, errors.Wrap(err, "invalid count SQL for webhook deliveries")
+	}
+
+	return selectSQL, selectArgs, countSQL, countArgs, nil
+}
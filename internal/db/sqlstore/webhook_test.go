@@ -0,0 +1,54 @@
+package sqlstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_createWebhookDeliveriesQuery(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	// deliveries already made about the action with that status are skipped
+	tt := []struct {
+		dialect *Dialect
+		query   string
+	}{
+		{
+			MySQL8,
+			"INSERT IGNORE INTO `webhook_deliveries` (`action_id`, `action_status`, `attempts`, `created_at`, `event`, `next_attempt_at`, `payload`, `rule_id`, `state`) " +
+				"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		},
+		{
+			Postgres,
+			"INSERT INTO \"webhook_deliveries\" (\"action_id\", \"action_status\", \"attempts\", \"created_at\", \"event\", \"next_attempt_at\", \"payload\", \"rule_id\", \"state\") " +
+				"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT DO NOTHING",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.dialect.Name, func(t *testing.T) {
+			query, args, err := tc.dialect.createWebhookDeliveriesQuery([]model.WebhookDelivery{{
+				RuleID:        3,
+				ActionID:      7,
+				ActionStatus:  model.Failed,
+				Event:         model.ActionUpdated,
+				Payload:       []byte(`{"event":"updated"}`),
+				State:         model.DeliveryPending,
+				NextAttemptAt: model.JSONTime{Time: now},
+				CreatedAt:     model.JSONTime{Time: now},
+			}})
+			if !assert.NoError(t, err) {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, tc.query, query)
+			assert.Equal(t, []interface{}{int64(7), int64(6), int64(0), now, "updated", now, `{"event":"updated"}`, int64(3), "pending"}, args)
+
+			_, _, err = tc.dialect.createWebhookDeliveriesQuery([]model.WebhookDelivery{{ActionID: 7}})
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"strings"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/mysql"
+	"github.com/denismitr/auditbase/internal/db/postgres"
+	"github.com/denismitr/auditbase/internal/db/sqlite"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+)
+
+const (
+	MySQL    = "mysql"
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
+// Driver - resolves storage driver by DSN scheme and returns the DSN
+// in the form expected by that driver, DSN without a scheme is treated as MySQL
+func Driver(dsn string) (string, string) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return Postgres, dsn
+	case strings.HasPrefix(dsn, "sqlite://"):
+		return SQLite, strings.TrimPrefix(dsn, "sqlite://")
+	case strings.HasPrefix(dsn, "mysql://"):
+		return MySQL, strings.TrimPrefix(dsn, "mysql://")
+	default:
+		return MySQL, dsn
+	}
+}
+
+// ConnectAndMigrate - connects to the storage selected by DSN scheme,
+// applies migrations and returns the database ready for use
+func ConnectAndMigrate(
+	ctx context.Context,
+	lg logger.Logger,
+	dsn string,
+	maxOpenConnections, maxIdleConnections int,
+) (db.Database, error) {
+	driver, driverDSN := Driver(dsn)
+
+	switch driver {
+	case Postgres:
+		conn, err := postgres.ConnectAndMigrate(ctx, lg, driverDSN, maxOpenConnections, maxIdleConnections)
+		if err != nil {
+			return nil, err
+		}
+
+		return postgres.NewDatabase(conn, lg), nil
+	case SQLite:
+		conn, err := sqlite.ConnectAndMigrate(ctx, lg, driverDSN)
+		if err != nil {
+			return nil, err
+		}
+
+		return sqlite.NewDatabase(conn, lg), nil
+	default:
+		conn, err := mysql.ConnectAndMigrate(ctx, lg, driverDSN, maxOpenConnections, maxIdleConnections)
+		if err != nil {
+			return nil, err
+		}
+
+		return mysql.NewDatabase(conn, lg), nil
+	}
+}
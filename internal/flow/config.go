@@ -1,5 +1,7 @@
 package flow
 
+import "time"
+
 // Config of the event exchange
 type Config struct {
 	ExchangeName       string
@@ -9,4 +11,58 @@ type Config struct {
 	Concurrency        int
 	MaxRequeue         int
 	IsPeristent        bool
+
+	// DeadLetterExchange - exchange for actions that exceeded MaxRequeue,
+	// defaults to ExchangeName with .dead suffix
+	DeadLetterExchange string
+
+	// ActionEventsExchange - fanout exchange persisted actions are announced on,
+	// defaults to ExchangeName with .events suffix
+	ActionEventsExchange string
+
+	// ActionEventRetryDelay - pause before an event a durable consumer failed on is redelivered,
+	// defaults to 5 seconds
+	ActionEventRetryDelay time.Duration
+}
+
+const deadLetterSuffix = ".dead"
+const actionEventsSuffix = ".events"
+const defaultActionEventRetryDelay = 5 * time.Second
+
+func (c Config) deadLetterExchange() string {
+	if c.DeadLetterExchange != "" {
+		return c.DeadLetterExchange
+	}
+
+	return c.ExchangeName + deadLetterSuffix
+}
+
+func (c Config) actionEventsExchange() string {
+	if c.ActionEventsExchange != "" {
+		return c.ActionEventsExchange
+	}
+
+	return c.ExchangeName + actionEventsSuffix
+}
+
+func (c Config) actionEventRetryDelay() time.Duration {
+	if c.ActionEventRetryDelay > 0 {
+		return c.ActionEventRetryDelay
+	}
+
+	return defaultActionEventRetryDelay
+}
+
+// Queues - action queues along with their dead letter queues
+func (c Config) Queues() []string {
+	return []string{
+		c.ActionsCreateQueue,
+		c.ActionsUpdateQueue,
+		deadLetterQueue(c.ActionsCreateQueue),
+		deadLetterQueue(c.ActionsUpdateQueue),
+	}
+}
+
+func deadLetterQueue(queue string) string {
+	return queue + deadLetterSuffix
 }
@@ -0,0 +1,281 @@
+package flow
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/flow/queue"
+	"github.com/denismitr/auditbase/internal/metrics"
+	"github.com/pkg/errors"
+)
+
+// DeadLetterKind - identifies dead letter queue by the kind of actions in it
+type DeadLetterKind string
+
+const (
+	CreateActionsDeadLetters DeadLetterKind = "create"
+	UpdateActionsDeadLetters DeadLetterKind = "update"
+)
+
+// DeadLetter - action message that exceeded max requeue attempts
+type DeadLetter struct {
+	UID            string          `json:"uid"`
+	Queue          string          `json:"queue"`
+	Attempt        int             `json:"attempt"`
+	LastError      string          `json:"lastError"`
+	DeadLetteredAt string          `json:"deadLetteredAt,omitempty"`
+	Action         json.RawMessage `json:"action"`
+}
+
+// DeadLetters - inspection and recovery of dead lettered actions
+type DeadLetters interface {
+	InspectDeadLetters(kind DeadLetterKind) (queue.Inspection, error)
+	ListDeadLetters(kind DeadLetterKind, limit int) ([]*DeadLetter, error)
+	FindDeadLetter(kind DeadLetterKind, uid string) (*DeadLetter, error)
+	ReplayDeadLetters(kind DeadLetterKind, uid string) (int, error)
+	PurgeDeadLetters(kind DeadLetterKind) (int, error)
+}
+
+// deadLetter - publishes the message to the dead letter exchange
+// with the error details in headers and acks the original, when the message
+// cannot be published it is returned to its queue, so it is not lost
+func (af *MQActionFlow) deadLetter(rm queue.ReceivedMessage, queueName string, cause error) error {
+	b := make([]byte, len(rm.Body()))
+	copy(b, rm.Body())
+
+	lastError := ""
+	if cause != nil {
+		lastError = cause.Error()
+	}
+
+	msg := queue.NewJSONMessageWithHeaders(b, rm.Attempt(), queue.Headers{
+		queue.XLastError:      lastError,
+		queue.XOriginalQueue:  queueName,
+		queue.XDeadLetteredAt: af.clock.CurrentTime().UTC().Format(time.RFC3339),
+	})
+
+	dlq := deadLetterQueue(queueName)
+	if err := af.mq.Publish(msg, af.cfg.deadLetterExchange(), dlq); err != nil {
+		af.lg.Error(errors.Wrapf(err, "could not move message to dead letter queue [%s]", dlq))
+
+		// attempts are exhausted already, so the next failure dead letters it again
+		if err := af.mq.Reject(rm.ID(), true); err != nil {
+			return errors.Wrapf(err, "could not return message to queue [%s]", queueName)
+		}
+
+		metrics.Requeued.WithLabelValues(queueName).Inc()
+
+		return nil
+	}
+
+	metrics.DeadLettered.WithLabelValues(queueName).Inc()
+
+	return af.Ack(rm)
+}
+
+func (af *MQActionFlow) queueOf(kind DeadLetterKind) (string, error) {
+	switch kind {
+	case CreateActionsDeadLetters:
+		return af.cfg.ActionsCreateQueue, nil
+	case UpdateActionsDeadLetters:
+		return af.cfg.ActionsUpdateQueue, nil
+	default:
+		return "", errors.Wrapf(ErrUnknownDeadLetterKind, "kind [%s]", kind)
+	}
+}
+
+// InspectDeadLetters - number of dead lettered actions of given kind
+func (af *MQActionFlow) InspectDeadLetters(kind DeadLetterKind) (queue.Inspection, error) {
+	q, err := af.queueOf(kind)
+	if err != nil {
+		return queue.Inspection{}, err
+	}
+
+	return af.mq.Inspect(deadLetterQueue(q))
+}
+
+// ListDeadLetters - returns up to limit dead lettered actions,
+// all of them when limit is 0, messages remain in the queue
+func (af *MQActionFlow) ListDeadLetters(kind DeadLetterKind, limit int) ([]*DeadLetter, error) {
+	q, err := af.queueOf(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*DeadLetter, 0)
+
+	err = af.scanDeadLetters(deadLetterQueue(q), func(rm queue.ReceivedMessage) (bool, bool, error) {
+		result = append(result, newDeadLetter(q, rm))
+		return false, limit > 0 && len(result) >= limit, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// FindDeadLetter - finds dead lettered action by its UID,
+// the message remains in the queue
+func (af *MQActionFlow) FindDeadLetter(kind DeadLetterKind, uid string) (*DeadLetter, error) {
+	q, err := af.queueOf(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *DeadLetter
+
+	err = af.scanDeadLetters(deadLetterQueue(q), func(rm queue.ReceivedMessage) (bool, bool, error) {
+		dl := newDeadLetter(q, rm)
+		if dl.UID == uid {
+			found = dl
+			return false, true, nil
+		}
+
+		return false, false, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if found == nil {
+		return nil, errors.Wrapf(ErrDeadLetterNotFound, "uid [%s]", uid)
+	}
+
+	return found, nil
+}
+
+// ReplayDeadLetters - sends dead lettered actions back to their original queue
+// with attempts reset, replays only the action with given UID if it is not empty
+func (af *MQActionFlow) ReplayDeadLetters(kind DeadLetterKind, uid string) (int, error) {
+	q, err := af.queueOf(kind)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+
+	err = af.scanDeadLetters(deadLetterQueue(q), func(rm queue.ReceivedMessage) (bool, bool, error) {
+		dl := newDeadLetter(q, rm)
+		if uid != "" && dl.UID != uid {
+			return false, false, nil
+		}
+
+		msg := queue.NewJSONMessage(rm.Body(), 1)
+		if err := af.mq.Publish(msg, af.cfg.ExchangeName, dl.Queue); err != nil {
+			return false, true, errors.Wrapf(err, "could not replay dead letter [%s]", dl.UID)
+		}
+
+		if err := af.Ack(rm); err != nil {
+			return true, true, errors.Wrapf(err, "dead letter [%s] was replayed but could not be removed", dl.UID)
+		}
+
+		replayed++
+
+		return true, uid != "", nil
+	})
+
+	if err != nil {
+		return replayed, err
+	}
+
+	if uid != "" && replayed == 0 {
+		return 0, errors.Wrapf(ErrDeadLetterNotFound, "uid [%s]", uid)
+	}
+
+	return replayed, nil
+}
+
+// PurgeDeadLetters - removes all dead lettered actions of given kind
+func (af *MQActionFlow) PurgeDeadLetters(kind DeadLetterKind) (int, error) {
+	q, err := af.queueOf(kind)
+	if err != nil {
+		return 0, err
+	}
+
+	af.dlMu.Lock()
+	defer af.dlMu.Unlock()
+
+	return af.mq.Purge(deadLetterQueue(q))
+}
+
+// scanDeadLetters - fetches messages from dead letter queue one by one and passes them to fn,
+// fn reports whether it has handled (acked) the message and whether the scan should stop,
+// all messages that were not handled are returned to the queue
+func (af *MQActionFlow) scanDeadLetters(
+	dlq string,
+	fn func(rm queue.ReceivedMessage) (handled bool, stop bool, err error),
+) error {
+	af.dlMu.Lock()
+	defer af.dlMu.Unlock()
+
+	var held []queue.ReceivedMessage
+
+	defer func() {
+		// reverse order keeps the original order of the messages in queue
+		for i := len(held) - 1; i >= 0; i-- {
+			if err := af.mq.Reject(held[i].ID(), true); err != nil {
+				af.lg.Error(err)
+			}
+		}
+	}()
+
+	for {
+		rm, ok, err := af.mq.Get(dlq)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return nil
+		}
+
+		handled, stop, err := fn(rm)
+		if !handled {
+			held = append(held, rm)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if stop {
+			return nil
+		}
+	}
+}
+
+func newDeadLetter(actionsQueue string, rm queue.ReceivedMessage) *DeadLetter {
+	var payload struct {
+		UID string `json:"uid"`
+	}
+
+	// malformed action is still a dead letter, it just has no UID
+	_ = json.Unmarshal(rm.Body(), &payload)
+
+	h := rm.Headers()
+
+	originalQueue := h.Get(queue.XOriginalQueue)
+	if originalQueue == "" {
+		originalQueue = actionsQueue
+	}
+
+	b := make([]byte, len(rm.Body()))
+	copy(b, rm.Body())
+
+	if !json.Valid(b) {
+		// keep the raw payload readable as a JSON string
+		b, _ = json.Marshal(string(b))
+	}
+
+	return &DeadLetter{
+		UID:            payload.UID,
+		Queue:          originalQueue,
+		Attempt:        rm.Attempt(),
+		LastError:      h.Get(queue.XLastError),
+		DeadLetteredAt: h.Get(queue.XDeadLetteredAt),
+		Action:         b,
+	}
+}
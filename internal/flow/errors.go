@@ -4,3 +4,5 @@ import "github.com/denismitr/auditbase/internal/utils/errtype"
 
 const ErrTooManyAttempts = errtype.StringError("too many attempts")
 const ErrCannotRequeueAction = errtype.StringError("could not requeue action")
+const ErrUnknownDeadLetterKind = errtype.StringError("unknown dead letter kind")
+const ErrDeadLetterNotFound = errtype.StringError("dead letter not found")
@@ -0,0 +1,160 @@
+package flow
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/flow/queue"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/pkg/errors"
+)
+
+type ActionEventHandler func(*model.ActionEvent)
+
+// ActionEventProcessor - handles events of a durable consumer, an event it fails on is redelivered
+type ActionEventProcessor func(*model.ActionEvent) error
+
+// ActionEvents - announcements of persisted actions, fanned out to every consumer listening
+type ActionEvents interface {
+	SendActionEvent(e *model.ActionEvent) error
+	ReceiveActionEvents(consumer string, h ActionEventHandler)
+	ConsumeActionEvents(consumer string, p ActionEventProcessor)
+}
+
+// SendActionEvent - publishes the event to the action events exchange,
+// it is dropped when nobody listens
+func (af *MQActionFlow) SendActionEvent(e *model.ActionEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrapf(err, "could not convert event of action %d to json bytes", e.ActionID)
+	}
+
+	exchange := af.cfg.actionEventsExchange()
+	if err := af.mq.Publish(queue.NewJSONMessage(b, 1), exchange, ""); err != nil {
+		return errors.Wrapf(err, "could not publish event of action %d to [%s] exchange", e.ActionID, exchange)
+	}
+
+	return nil
+}
+
+// ReceiveActionEvents - passes events published while it runs to the handler, blocks until
+// the flow is stopped or the subscription ends. The queue of the consumer is transient and
+// named after it, so every consumer has to have a name of its own
+func (af *MQActionFlow) ReceiveActionEvents(consumer string, h ActionEventHandler) {
+	msgCh, ok := af.subscribeActionEvents(consumer, af.mq.DeclareTransientQueue)
+	if !ok {
+		return
+	}
+
+	for {
+		select {
+		case msg, ok := <-msgCh:
+			if !ok {
+				return
+			}
+
+			if e, err := parseActionEvent(msg); err != nil {
+				af.lg.Error(err)
+			} else {
+				h(e)
+			}
+
+			// events are not worth redelivering, the action is in the storage anyway
+			if err := af.Ack(msg); err != nil {
+				af.lg.Error(err)
+			}
+		case <-af.stopCh:
+			return
+		}
+	}
+}
+
+// ConsumeActionEvents - passes events to the processor one at a time through a durable queue
+// named after the consumer, so that events published while it is down wait for it.
+// An event the processor fails on is put back to the queue after a pause,
+// so the processor has to tolerate receiving an event more than once
+func (af *MQActionFlow) ConsumeActionEvents(consumer string, p ActionEventProcessor) {
+	msgCh, ok := af.subscribeActionEvents(consumer, af.mq.DeclareQueue)
+	if !ok {
+		return
+	}
+
+	for {
+		select {
+		case msg, ok := <-msgCh:
+			if !ok {
+				return
+			}
+
+			e, err := parseActionEvent(msg)
+			if err != nil {
+				// an event that cannot be parsed never will be
+				af.lg.Error(err)
+				if err := af.Reject(msg); err != nil {
+					af.lg.Error(err)
+				}
+				continue
+			}
+
+			if err := p(e); err != nil {
+				af.lg.Error(errors.Wrapf(err, "could not process %s event of action %d", e.Type, e.ActionID))
+
+				select {
+				case <-time.After(af.cfg.actionEventRetryDelay()):
+				case <-af.stopCh:
+				}
+
+				if err := af.mq.Reject(msg.ID(), true); err != nil {
+					af.lg.Error(err)
+				}
+				continue
+			}
+
+			if err := af.Ack(msg); err != nil {
+				af.lg.Error(err)
+			}
+		case <-af.stopCh:
+			return
+		}
+	}
+}
+
+// subscribeActionEvents - declares the queue of the consumer with the given func,
+// binds it to the action events exchange and subscribes to it
+func (af *MQActionFlow) subscribeActionEvents(
+	consumer string,
+	declare func(name string) error,
+) (<-chan queue.ReceivedMessage, bool) {
+	exchange := af.cfg.actionEventsExchange()
+	queueName := exchange + "." + consumer
+
+	if err := declare(queueName); err != nil {
+		af.lg.Error(errors.Wrapf(err, "could not declare [%s] queue", queueName))
+		return nil, false
+	}
+
+	if err := af.mq.Bind(queueName, exchange, ""); err != nil {
+		af.lg.Error(errors.Wrapf(err, "could not bind [%s] queue to [%s] exchange", queueName, exchange))
+		return nil, false
+	}
+
+	msgCh := make(chan queue.ReceivedMessage)
+
+	go func() {
+		if err := af.mq.Subscribe(queueName, consumer, msgCh); err != nil {
+			af.lg.Error(err)
+			close(msgCh)
+		}
+	}()
+
+	return msgCh, true
+}
+
+func parseActionEvent(msg queue.ReceivedMessage) (*model.ActionEvent, error) {
+	var e model.ActionEvent
+	if err := json.Unmarshal(msg.Body(), &e); err != nil {
+		return nil, errors.Wrap(err, "could not parse action event from received queue message bytes")
+	}
+
+	return &e, nil
+}
@@ -3,9 +3,12 @@ package flow
 import (
 	"encoding/json"
 	"sync"
+	"time"
 
 	"github.com/denismitr/auditbase/internal/flow/queue"
+	"github.com/denismitr/auditbase/internal/metrics"
 	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/clock"
 	"github.com/denismitr/auditbase/internal/utils/logger"
 	"github.com/pkg/errors"
 )
@@ -25,6 +28,8 @@ type ActionFlow interface {
 	Stop() error
 
 	Scaffolder
+	DeadLetters
+	ActionEvents
 }
 
 var _ ActionFlow = (*MQActionFlow)(nil)
@@ -35,23 +40,25 @@ type MQActionFlow struct {
 	cfg               Config
 	state             State
 	lg                logger.Logger
+	clock             clock.Clock
 	mu                sync.RWMutex
 	connLossListeners []chan<- struct{}
 	stopCh            chan struct{}
-	msgCh             chan queue.ReceivedMessage
+	stopOnce          sync.Once
+	dlMu              sync.Mutex
 }
 
 // New event flow
-func New(mq queue.MQ, lg logger.Logger, cfg Config) *MQActionFlow {
+func New(mq queue.MQ, lg logger.Logger, cl clock.Clock, cfg Config) *MQActionFlow {
 	return &MQActionFlow{
 		mq:                mq,
 		cfg:               cfg,
 		state:             Idle,
 		lg:                lg,
+		clock:             cl,
 		mu:                sync.RWMutex{},
 		connLossListeners: make([]chan<- struct{}, 0),
 		stopCh:            make(chan struct{}),
-		msgCh:             make(chan queue.ReceivedMessage),
 	}
 }
 
@@ -91,6 +98,7 @@ func (af *MQActionFlow) Start() {
 				af.updateState(queueStatusToFlowState(status))
 			case <-af.stopCh:
 				af.closeStateChangeListeners()
+				return
 			}
 		}
 	}()
@@ -106,12 +114,39 @@ func (af *MQActionFlow) Reject(rm queue.ReceivedMessage) error {
 	return af.mq.Reject(rm.ID(), false)
 }
 
-// Stop the actions flow
+// Stop the actions flow, safe to call more than once
 func (af *MQActionFlow) Stop() error {
-	close(af.stopCh)
+	af.stopOnce.Do(func() {
+		close(af.stopCh)
+	})
 	return nil
 }
 
+// Inspect - number of messages waiting in the queue and its consumers
+func (af *MQActionFlow) Inspect(queueName string) (queue.Inspection, error) {
+	return af.mq.Inspect(queueName)
+}
+
+// Status - state of the message queue connection along with the number
+// of messages waiting in the create queue and its consumers
+func (af *MQActionFlow) Status() Status {
+	s := Status{State: queueStatusToFlowState(af.mq.Status())}
+	if !s.OK() {
+		return s
+	}
+
+	i, err := af.mq.Inspect(af.cfg.ActionsCreateQueue)
+	if err != nil {
+		af.lg.Error(errors.Wrap(err, "could not inspect actions create queue"))
+		return s
+	}
+
+	s.Messages = i.Messages
+	s.Consumers = i.Consumers
+
+	return s
+}
+
 // NotifyOnConnectionLoss - registers a state change listener
 func (af *MQActionFlow) NotifyOnConnectionLoss(l chan<- struct{}) {
 	af.mu.Lock()
@@ -128,7 +163,7 @@ func (af *MQActionFlow) SendNewAction(newAction *model.NewAction) error {
 
 	msg := queue.NewJSONMessage(b, 1)
 
-	return af.mq.Publish(msg, af.cfg.ExchangeName, af.cfg.ActionsCreateQueue)
+	return af.publish(msg, af.cfg.ActionsCreateQueue)
 }
 
 // SendUpdateAction event to the event flow
@@ -140,7 +175,21 @@ func (af *MQActionFlow) SendUpdateAction(updateAction *model.UpdateAction) error
 
 	msg := queue.NewJSONMessage(b, 1)
 
-	return af.mq.Publish(msg, af.cfg.ExchangeName, af.cfg.ActionsCreateQueue)
+	return af.publish(msg, af.cfg.ActionsUpdateQueue)
+}
+
+// publish - publishes the message to the actions exchange, recording latency and errors
+func (af *MQActionFlow) publish(msg queue.Message, queueName string) error {
+	start := time.Now()
+
+	err := af.mq.Publish(msg, af.cfg.ExchangeName, queueName)
+
+	metrics.PublishDuration.WithLabelValues(queueName).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.PublishErrors.WithLabelValues(queueName).Inc()
+	}
+
+	return err
 }
 
 type ProcessFunc func(message queue.ReceivedMessage) error
@@ -190,9 +239,11 @@ func updateActionsProcessor(h UpdateActionHandler) ProcessFunc {
 }
 
 // receive actions from the flow of data
-func (af *MQActionFlow) receive(queue, consumer string, concurrency int, msgProcessor ProcessFunc) {
+func (af *MQActionFlow) receive(queueName, consumer string, concurrency int, msgProcessor ProcessFunc) {
+	msgCh := make(chan queue.ReceivedMessage)
+
 	go func() {
-		if err := af.mq.Subscribe(queue, consumer, af.msgCh); err != nil {
+		if err := af.mq.Subscribe(queueName, consumer, msgCh); err != nil {
 			panic(err)
 		}
 	}()
@@ -201,12 +252,12 @@ func (af *MQActionFlow) receive(queue, consumer string, concurrency int, msgProc
 
 	for {
 		select {
-		case msg, ok := <-af.msgCh:
+		case msg, ok := <-msgCh:
 			if !ok {
 				if err := af.Stop(); err != nil {
 					af.lg.Error(err)
 				}
-				continue
+				return
 			}
 
 			sem <- struct{}{}
@@ -215,8 +266,9 @@ func (af *MQActionFlow) receive(queue, consumer string, concurrency int, msgProc
 
 				if err := msgProcessor(msg); err != nil {
 					af.lg.Error(err)
-					if err := af.requeue(msg, queue); err != nil {
+					if err := af.requeue(msg, queueName, err); err != nil {
 						af.lg.Error(err)
+						metrics.Rejected.WithLabelValues(queueName).Inc()
 						if err := af.Reject(msg); err != nil {
 							af.lg.Error(err)
 						}
@@ -239,24 +291,28 @@ func (af *MQActionFlow) receive(queue, consumer string, concurrency int, msgProc
 	}
 }
 
-// Requeue previously received message
-func (af *MQActionFlow) requeue(rm queue.ReceivedMessage, queue string) error {
+// Requeue previously received message or move it
+// to the dead letter queue when attempts are exhausted
+func (af *MQActionFlow) requeue(rm queue.ReceivedMessage, queue string, cause error) error {
+	// create a copy
+	msg := rm.CloneToRequeue()
+	if msg.Attempt() > af.cfg.MaxRequeue {
+		af.lg.Error(errors.Wrapf(ErrTooManyAttempts, "moving message from [%s] to dead letter queue", queue))
+		return af.deadLetter(rm, queue, cause)
+	}
+
 	// reject original message version
 	if err := af.mq.Reject(rm.ID(), false); err != nil {
 		af.lg.Error(err)
 		return ErrCannotRequeueAction
 	}
 
-	// create a copy
-	msg := rm.CloneToRequeue()
-	if msg.Attempt() > af.cfg.MaxRequeue {
-		return ErrTooManyAttempts
-	}
-
 	// requeue
-	if err := af.mq.Publish(msg, af.cfg.ExchangeName, queue); err != nil {
+	if err := af.publish(msg, queue); err != nil {
 		return err
 	}
 
+	metrics.Requeued.WithLabelValues(queue).Inc()
+
 	return nil
 }
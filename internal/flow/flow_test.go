@@ -1,3 +1,375 @@
 package flow
 
-// todo
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/flow/queue"
+	"github.com/denismitr/auditbase/internal/metrics"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+var deadLetteredAt = time.Date(2020, 5, 17, 12, 30, 0, 0, time.UTC)
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c fixedClock) CurrentTimestamp() int64 {
+	return c.now.Unix()
+}
+
+func (c fixedClock) CurrentTime() time.Time {
+	return c.now
+}
+
+// unreachableDeadLetters - memory queue that fails to publish to the dead letter exchange while down is set
+type unreachableDeadLetters struct {
+	*queue.MemoryQueue
+	down int32
+}
+
+func (q *unreachableDeadLetters) Publish(msg queue.Message, exchange, routingKey string) error {
+	if atomic.LoadInt32(&q.down) == 1 && strings.HasSuffix(routingKey, ".dead") {
+		return errors.New("dead letter exchange is unreachable")
+	}
+
+	return q.MemoryQueue.Publish(msg, exchange, routingKey)
+}
+
+func newMemoryQueue(t *testing.T) *queue.MemoryQueue {
+	t.Helper()
+
+	mq := queue.Memory(logger.NewStdoutLogger(logger.Prod, "flow_test"))
+	if err := mq.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(mq.Stop)
+
+	return mq
+}
+
+func newMemoryFlow(t *testing.T, maxRequeue int) (*MQActionFlow, *queue.MemoryQueue) {
+	t.Helper()
+
+	mq := newMemoryQueue(t)
+
+	return newFlow(t, mq, maxRequeue), mq
+}
+
+func newFlow(t *testing.T, mq queue.MQ, maxRequeue int) *MQActionFlow {
+	t.Helper()
+
+	lg := logger.NewStdoutLogger(logger.Prod, "flow_test")
+
+	af := New(mq, lg, fixedClock{now: deadLetteredAt}, Config{
+		ExchangeName:       "auditbase.actions",
+		ExchangeType:       queue.DirectExchange,
+		ActionsCreateQueue: "auditbase.v1.actions.create",
+		ActionsUpdateQueue: "auditbase.v1.actions.update",
+		Concurrency:        2,
+		MaxRequeue:         maxRequeue,
+	})
+
+	if err := af.Scaffold(); err != nil {
+		t.Fatal(err)
+	}
+
+	af.Start()
+
+	t.Cleanup(func() {
+		_ = af.Stop()
+	})
+
+	return af
+}
+
+func TestMQActionFlow_WithMemoryQueue(t *testing.T) {
+	t.Run("new and update actions are routed to their own handlers", func(t *testing.T) {
+		af, _ := newMemoryFlow(t, 2)
+
+		newCh := make(chan *model.NewAction, 1)
+		updateCh := make(chan *model.UpdateAction, 1)
+
+		go af.ReceiveNewActions("test", func(na *model.NewAction) error {
+			newCh <- na
+			return nil
+		})
+
+		go af.ReceiveUpdateActions("test", func(ua *model.UpdateAction) error {
+			updateCh <- ua
+			return nil
+		})
+
+		assert.NoError(t, af.SendNewAction(&model.NewAction{UID: "76502edbf207452eae7ec258271ee9aa", Name: "foo"}))
+		assert.NoError(t, af.SendUpdateAction(&model.UpdateAction{UID: "76502edbf207452eae7ec258271ee9aa", Status: model.Success}))
+
+		select {
+		case na := <-newCh:
+			assert.Equal(t, "foo", na.Name)
+		case <-time.After(2 * time.Second):
+			t.Fatal("new action was not received")
+		}
+
+		select {
+		case ua := <-updateCh:
+			assert.Equal(t, model.Success, ua.Status)
+		case <-time.After(2 * time.Second):
+			t.Fatal("update action was not received")
+		}
+	})
+
+	t.Run("failed action is requeued until max requeue is reached and then dead lettered", func(t *testing.T) {
+		af, mq := newMemoryFlow(t, 2)
+		requeued := testutil.ToFloat64(metrics.Requeued.WithLabelValues("auditbase.v1.actions.create"))
+		deadLettered := testutil.ToFloat64(metrics.DeadLettered.WithLabelValues("auditbase.v1.actions.create"))
+
+		var mu sync.Mutex
+		attempts := 0
+		done := make(chan struct{})
+
+		go af.ReceiveNewActions("test", func(na *model.NewAction) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			attempts++
+			if attempts == 2 {
+				close(done)
+			}
+
+			return errors.New("storage is down")
+		})
+
+		assert.NoError(t, af.SendNewAction(&model.NewAction{UID: "76502edbf207452eae7ec258271ee9aa", Name: "foo"}))
+
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("action was not retried")
+		}
+
+		// give the flow a moment to reject the last attempt
+		time.Sleep(50 * time.Millisecond)
+
+		mu.Lock()
+		assert.Equal(t, 2, attempts)
+		mu.Unlock()
+
+		i, err := mq.Inspect("auditbase.v1.actions.create")
+		assert.NoError(t, err)
+		assert.Equal(t, 0, i.Messages)
+
+		dlq, err := af.InspectDeadLetters(CreateActionsDeadLetters)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, dlq.Messages)
+
+		assert.Equal(t, requeued+1, testutil.ToFloat64(metrics.Requeued.WithLabelValues("auditbase.v1.actions.create")))
+		assert.Equal(t, deadLettered+1, testutil.ToFloat64(metrics.DeadLettered.WithLabelValues("auditbase.v1.actions.create")))
+	})
+
+	t.Run("message stays in its queue when it cannot be dead lettered", func(t *testing.T) {
+		mq := &unreachableDeadLetters{MemoryQueue: newMemoryQueue(t), down: 1}
+		af := newFlow(t, mq, 0)
+
+		attempts := int32(0)
+		go af.ReceiveNewActions("test", func(na *model.NewAction) error {
+			if atomic.AddInt32(&attempts, 1) == 3 {
+				atomic.StoreInt32(&mq.down, 0)
+			}
+
+			return errors.New("storage is down")
+		})
+
+		assert.NoError(t, af.SendNewAction(&model.NewAction{UID: "76502edbf207452eae7ec258271ee9aa", Name: "foo"}))
+
+		assert.Eventually(t, func() bool {
+			i, err := af.InspectDeadLetters(CreateActionsDeadLetters)
+			return err == nil && i.Messages == 1
+		}, 2*time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+
+		dl, err := af.FindDeadLetter(CreateActionsDeadLetters, "76502edbf207452eae7ec258271ee9aa")
+		assert.NoError(t, err)
+		assert.Equal(t, "foo", actionName(t, dl.Action))
+	})
+
+	t.Run("dead letters can be listed, found, replayed and purged", func(t *testing.T) {
+		af, mq := newMemoryFlow(t, 1)
+
+		failing := true
+		var mu sync.Mutex
+		received := make(chan string, 10)
+
+		go af.ReceiveNewActions("test", func(na *model.NewAction) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if failing {
+				return errors.New("storage is down")
+			}
+
+			received <- na.UID
+			return nil
+		})
+
+		assert.NoError(t, af.SendNewAction(&model.NewAction{UID: "11111111111111111111111111111111", Name: "foo"}))
+		assert.NoError(t, af.SendNewAction(&model.NewAction{UID: "22222222222222222222222222222222", Name: "bar"}))
+		assert.NoError(t, af.SendNewAction(&model.NewAction{UID: "33333333333333333333333333333333", Name: "baz"}))
+
+		assert.Eventually(t, func() bool {
+			i, err := af.InspectDeadLetters(CreateActionsDeadLetters)
+			return err == nil && i.Messages == 3
+		}, 2*time.Second, 10*time.Millisecond)
+
+		dls, err := af.ListDeadLetters(CreateActionsDeadLetters, 2)
+		assert.NoError(t, err)
+		assert.Len(t, dls, 2)
+
+		dls, err = af.ListDeadLetters(CreateActionsDeadLetters, 0)
+		assert.NoError(t, err)
+		if assert.Len(t, dls, 3) {
+			// consumers run concurrently so the order is not guaranteed
+			uids := []string{dls[0].UID, dls[1].UID, dls[2].UID}
+			assert.ElementsMatch(t, []string{
+				"11111111111111111111111111111111",
+				"22222222222222222222222222222222",
+				"33333333333333333333333333333333",
+			}, uids)
+			assert.Equal(t, "storage is down", dls[0].LastError)
+			assert.Equal(t, "auditbase.v1.actions.create", dls[0].Queue)
+			assert.Equal(t, 1, dls[0].Attempt)
+			assert.Equal(t, "2020-05-17T12:30:00Z", dls[0].DeadLetteredAt)
+		}
+
+		dl, err := af.FindDeadLetter(CreateActionsDeadLetters, "22222222222222222222222222222222")
+		assert.NoError(t, err)
+		assert.Equal(t, "bar", actionName(t, dl.Action))
+
+		_, err = af.FindDeadLetter(CreateActionsDeadLetters, "44444444444444444444444444444444")
+		assert.Equal(t, ErrDeadLetterNotFound, errors.Cause(err))
+
+		_, err = af.ListDeadLetters("unknown", 0)
+		assert.Equal(t, ErrUnknownDeadLetterKind, errors.Cause(err))
+
+		mu.Lock()
+		failing = false
+		mu.Unlock()
+
+		n, err := af.ReplayDeadLetters(CreateActionsDeadLetters, "22222222222222222222222222222222")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		select {
+		case uid := <-received:
+			assert.Equal(t, "22222222222222222222222222222222", uid)
+		case <-time.After(2 * time.Second):
+			t.Fatal("replayed action was not received")
+		}
+
+		n, err = af.PurgeDeadLetters(CreateActionsDeadLetters)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		i, err := mq.Inspect("auditbase.v1.actions.create.dead")
+		assert.NoError(t, err)
+		assert.Equal(t, 0, i.Messages)
+	})
+
+	t.Run("status reflects the queue connection and the create queue", func(t *testing.T) {
+		af, mq := newMemoryFlow(t, 2)
+
+		assert.NoError(t, af.SendNewAction(&model.NewAction{UID: "76502edbf207452eae7ec258271ee9aa", Name: "foo"}))
+
+		s := af.Status()
+		assert.True(t, s.OK())
+		assert.Equal(t, 1, s.Messages)
+
+		mq.Stop()
+
+		assert.Eventually(t, func() bool {
+			return !af.Status().OK()
+		}, 2*time.Second, 10*time.Millisecond)
+		assert.Equal(t, "Actions flow is stopped", af.Status().Error())
+	})
+
+	t.Run("action events are fanned out to every consumer", func(t *testing.T) {
+		af, mq := newMemoryFlow(t, 2)
+
+		eventCh := make(chan string, 2)
+		for _, consumer := range []string{"replica1", "replica2"} {
+			consumer := consumer
+			go af.ReceiveActionEvents(consumer, func(e *model.ActionEvent) {
+				eventCh <- consumer + " " + string(e.Type)
+			})
+
+			assert.Eventually(t, func() bool {
+				i, err := mq.Inspect("auditbase.actions.events." + consumer)
+				return err == nil && i.Consumers == 1
+			}, 2*time.Second, 10*time.Millisecond)
+		}
+
+		assert.NoError(t, af.SendActionEvent(&model.ActionEvent{Type: model.ActionCreated, ActionID: 5}))
+
+		var received []string
+		for len(received) < 2 {
+			select {
+			case e := <-eventCh:
+				received = append(received, e)
+			case <-time.After(2 * time.Second):
+				t.Fatal("action event was not received by every consumer")
+			}
+		}
+
+		assert.ElementsMatch(t, []string{"replica1 created", "replica2 created"}, received)
+	})
+
+	t.Run("action events wait in the durable queue and failed ones are redelivered", func(t *testing.T) {
+		af, mq := newMemoryFlow(t, 2)
+		af.cfg.ActionEventRetryDelay = 10 * time.Millisecond
+
+		assert.NoError(t, mq.DeclareQueue("auditbase.actions.events.dispatcher"))
+		assert.NoError(t, mq.Bind("auditbase.actions.events.dispatcher", "auditbase.actions.events", ""))
+		assert.NoError(t, af.SendActionEvent(&model.ActionEvent{Type: model.ActionUpdated, ActionID: 7}))
+
+		var calls int32
+		received := make(chan model.ID, 2)
+		go af.ConsumeActionEvents("dispatcher", func(e *model.ActionEvent) error {
+			received <- e.ActionID
+			if atomic.AddInt32(&calls, 1) == 1 {
+				return errors.New("storage is down")
+			}
+			return nil
+		})
+
+		for i := 0; i < 2; i++ {
+			select {
+			case ID := <-received:
+				assert.Equal(t, model.ID(7), ID)
+			case <-time.After(2 * time.Second):
+				t.Fatal("action event was not redelivered")
+			}
+		}
+	})
+}
+
+func actionName(t *testing.T, b []byte) string {
+	t.Helper()
+
+	var na model.NewAction
+	if err := json.Unmarshal(b, &na); err != nil {
+		t.Fatal(err)
+	}
+
+	return na.Name
+}
@@ -0,0 +1,39 @@
+package queue
+
+import (
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+)
+
+const (
+	RabbitMQDriver = "rabbitmq"
+	MemoryDriver   = "memory"
+)
+
+// New - creates a message queue implementation by driver name,
+// dsn is ignored by the in-memory driver
+func New(driver, dsn string, lg logger.Logger, maxConnRetries int) (MQ, error) {
+	switch driver {
+	case RabbitMQDriver, "":
+		if dsn == "" {
+			return nil, errors.New("RabbitMQ DSN must not be empty")
+		}
+
+		return Rabbit(dsn, lg, maxConnRetries), nil
+	case MemoryDriver:
+		return Memory(lg), nil
+	default:
+		return nil, errors.Errorf("unknown message queue driver %s", driver)
+	}
+}
+
+// NewShared - creates a message queue implementation by driver name for binaries
+// that exchange messages with other processes, the in-memory queue lives inside a single
+// process, so messages published to it would never reach the other binaries
+func NewShared(driver, dsn string, lg logger.Logger, maxConnRetries int) (MQ, error) {
+	if driver == MemoryDriver {
+		return nil, errors.Wrap(ErrDriverNotShared, driver)
+	}
+
+	return New(driver, dsn, lg, maxConnRetries)
+}
@@ -5,3 +5,4 @@ import "github.com/denismitr/auditbase/internal/utils/errtype"
 const ErrNoAttemptInfo = errtype.StringError("no attempt information found in queue message")
 const ErrMalformedAttemptInfo = errtype.StringError("malformed attempt information in queue message")
 const ErrNoActionType = errtype.StringError("no action type")
+const ErrDriverNotShared = errtype.StringError("message queue driver cannot be shared between processes")
@@ -0,0 +1,460 @@
+package queue
+
+import (
+	"context"
+	"sync"
+
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+)
+
+const (
+	DirectExchange = "direct"
+	FanoutExchange = "fanout"
+)
+
+// MemoryQueue - in-process implementation of the MQ,
+// suitable for tests and single binary setups,
+// supports direct and fanout exchanges and the default exchange
+// that routes messages directly to a queue named as the routing key
+type MemoryQueue struct {
+	logger logger.Logger
+
+	exchanges map[string]string
+	bindings  map[string]map[string][]string
+	queues    map[string]*memoryQueueState
+	unacked   map[ReceivedMessageID]*memoryDelivery
+	lastTag   uint64
+
+	status          ConnectionStatus
+	statusListeners []chan ConnectionStatus
+	stopCh          chan struct{}
+	stopOnce        sync.Once
+
+	mu sync.Mutex
+}
+
+type memoryEnvelope struct {
+	body    []byte
+	attempt int
+	headers Headers
+}
+
+type memoryDelivery struct {
+	queue    string
+	envelope *memoryEnvelope
+}
+
+type memoryQueueState struct {
+	pending   []*memoryEnvelope
+	consumers int
+	notifyCh  chan struct{}
+}
+
+var _ MQ = (*MemoryQueue)(nil)
+
+// Memory - creates a new in-process message queue
+func Memory(logger logger.Logger) *MemoryQueue {
+	return &MemoryQueue{
+		logger:          logger,
+		exchanges:       make(map[string]string),
+		bindings:        make(map[string]map[string][]string),
+		queues:          make(map[string]*memoryQueueState),
+		unacked:         make(map[ReceivedMessageID]*memoryDelivery),
+		statusListeners: make([]chan ConnectionStatus, 0),
+		stopCh:          make(chan struct{}),
+	}
+}
+
+// DeclareExchange - declares an exchange of direct or fanout kind,
+// redeclaration with the same kind is a no-op
+func (q *MemoryQueue) DeclareExchange(name, kind string) error {
+	if kind != DirectExchange && kind != FanoutExchange {
+		return errors.Errorf("exchange kind %s is not supported by in-memory queue", kind)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if existing, ok := q.exchanges[name]; ok && existing != kind {
+		return errors.Errorf("exchange %s already declared with kind %s", name, existing)
+	}
+
+	q.exchanges[name] = kind
+	if _, ok := q.bindings[name]; !ok {
+		q.bindings[name] = make(map[string][]string)
+	}
+
+	return nil
+}
+
+// DeclareQueue - declares a new queue if not exists
+func (q *MemoryQueue) DeclareQueue(name string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.declareQueue(name)
+
+	return nil
+}
+
+// DeclareTransientQueue - there are no connections to outlive, so it is an ordinary queue
+func (q *MemoryQueue) DeclareTransientQueue(name string) error {
+	return q.DeclareQueue(name)
+}
+
+func (q *MemoryQueue) declareQueue(name string) *memoryQueueState {
+	state, ok := q.queues[name]
+	if !ok {
+		state = &memoryQueueState{notifyCh: make(chan struct{}, 1)}
+		q.queues[name] = state
+	}
+
+	return state
+}
+
+// Bind queue to exchange with routingKey
+func (q *MemoryQueue) Bind(queue, exchange, routingKey string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.exchanges[exchange]; !ok {
+		return errors.Errorf("failed to bind queue %s: exchange %s is not declared", queue, exchange)
+	}
+
+	if _, ok := q.queues[queue]; !ok {
+		return errors.Errorf("failed to bind queue %s to exchange %s: queue is not declared", queue, exchange)
+	}
+
+	for _, bound := range q.bindings[exchange][routingKey] {
+		if bound == queue {
+			return nil
+		}
+	}
+
+	q.bindings[exchange][routingKey] = append(q.bindings[exchange][routingKey], queue)
+
+	return nil
+}
+
+// Inspect queue, check number of messages waiting to be consumed
+// and a number of consumers for that given queue
+func (q *MemoryQueue) Inspect(queueName string) (Inspection, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	state, ok := q.queues[queueName]
+	if !ok {
+		return Inspection{}, errors.Errorf("could not inspect a queue %s: queue is not declared", queueName)
+	}
+
+	return Inspection{Messages: len(state.pending), Consumers: state.consumers}, nil
+}
+
+// Publish message to the exchange, unroutable messages are dropped
+// the same way RabbitMQ does it for non mandatory publishing
+func (q *MemoryQueue) Publish(msg Message, exchange, routingKey string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	targets, err := q.route(exchange, routingKey)
+	if err != nil {
+		return err
+	}
+
+	if len(targets) == 0 {
+		q.logger.Debugf("message to exchange %s with routing key %s is unroutable and dropped", exchange, routingKey)
+		return nil
+	}
+
+	for _, target := range targets {
+		b := make([]byte, len(msg.Body()))
+		copy(b, msg.Body())
+		q.push(q.queues[target], &memoryEnvelope{body: b, attempt: msg.Attempt(), headers: msg.Headers().clone()}, false)
+	}
+
+	return nil
+}
+
+func (q *MemoryQueue) route(exchange, routingKey string) ([]string, error) {
+	if exchange == "" {
+		if _, ok := q.queues[routingKey]; ok {
+			return []string{routingKey}, nil
+		}
+
+		return nil, nil
+	}
+
+	kind, ok := q.exchanges[exchange]
+	if !ok {
+		return nil, errors.Errorf("failed to send message to exchange %s: exchange is not declared", exchange)
+	}
+
+	if kind == DirectExchange {
+		return q.bindings[exchange][routingKey], nil
+	}
+
+	var targets []string
+	seen := make(map[string]bool)
+	for _, queues := range q.bindings[exchange] {
+		for _, queue := range queues {
+			if !seen[queue] {
+				seen[queue] = true
+				targets = append(targets, queue)
+			}
+		}
+	}
+
+	return targets, nil
+}
+
+// push must be called under lock
+func (q *MemoryQueue) push(state *memoryQueueState, env *memoryEnvelope, front bool) {
+	if front {
+		state.pending = append([]*memoryEnvelope{env}, state.pending...)
+	} else {
+		state.pending = append(state.pending, env)
+	}
+
+	select {
+	case state.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// Ack - removes delivered message for good
+func (q *MemoryQueue) Ack(ID ReceivedMessageID) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.unacked[ID]; !ok {
+		return errors.Errorf("could not ack tag %d: unknown delivery tag", ID.UInt64())
+	}
+
+	delete(q.unacked, ID)
+
+	return nil
+}
+
+// Reject - removes delivered message or puts it back
+// to the head of its queue if requeue is true
+func (q *MemoryQueue) Reject(ID ReceivedMessageID, requeue bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	d, ok := q.unacked[ID]
+	if !ok {
+		return errors.Errorf("could not reject tag %d: unknown delivery tag", ID.UInt64())
+	}
+
+	delete(q.unacked, ID)
+
+	if requeue {
+		q.push(q.declareQueue(d.queue), d.envelope, true)
+	}
+
+	return nil
+}
+
+// Get a single message from queue, it stays unacked until
+// Ack or Reject is called with its ID
+func (q *MemoryQueue) Get(queue string) (ReceivedMessage, bool, error) {
+	q.mu.Lock()
+	state, ok := q.queues[queue]
+	q.mu.Unlock()
+
+	if !ok {
+		return nil, false, errors.Errorf("could not get message from queue %s: queue is not declared", queue)
+	}
+
+	msg := q.next(queue, state)
+	if msg == nil {
+		return nil, false, nil
+	}
+
+	return msg, true, nil
+}
+
+// Purge all ready messages from the queue
+func (q *MemoryQueue) Purge(queue string) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	state, ok := q.queues[queue]
+	if !ok {
+		return 0, errors.Errorf("could not purge queue %s: queue is not declared", queue)
+	}
+
+	n := len(state.pending)
+	state.pending = nil
+
+	return n, nil
+}
+
+// Subscribe and consume messages sending them
+// to receiveCh, blocks until the queue is stopped
+func (q *MemoryQueue) Subscribe(queue, consumer string, receiveCh chan<- ReceivedMessage) error {
+	q.mu.Lock()
+	state, ok := q.queues[queue]
+	if !ok {
+		q.mu.Unlock()
+		return errors.Errorf("could not consume from queue %s: queue is not declared", queue)
+	}
+	state.consumers++
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		state.consumers--
+		q.mu.Unlock()
+		close(receiveCh)
+	}()
+
+	for {
+		msg := q.next(queue, state)
+		if msg == nil {
+			select {
+			case <-state.notifyCh:
+				continue
+			case <-q.stopCh:
+				return nil
+			}
+		}
+
+		q.logger.Debugf("consumer %s received message from queue %s on its %d attempt", consumer, queue, msg.Attempt())
+
+		select {
+		case receiveCh <- msg:
+		case <-q.stopCh:
+			return nil
+		}
+	}
+}
+
+func (q *MemoryQueue) next(queue string, state *memoryQueueState) *MemoryReceivedMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(state.pending) == 0 {
+		return nil
+	}
+
+	env := state.pending[0]
+	state.pending = state.pending[1:]
+
+	// wake up other consumers of the same queue if there is more work
+	if len(state.pending) > 0 {
+		select {
+		case state.notifyCh <- struct{}{}:
+		default:
+		}
+	}
+
+	q.lastTag++
+	tag := ReceivedMessageID(q.lastTag)
+	q.unacked[tag] = &memoryDelivery{queue: queue, envelope: env}
+
+	return &MemoryReceivedMessage{
+		queueName: queue,
+		body:      env.body,
+		attempt:   env.attempt,
+		headers:   env.headers,
+		tag:       tag,
+	}
+}
+
+// Connect - there is nothing to connect to, so it just marks the queue as connected
+func (q *MemoryQueue) Connect(ctx context.Context) error {
+	q.updateStatus(Connected)
+	return nil
+}
+
+// MaintainConnection - blocks until the queue is stopped
+// should be run as a goroutine
+func (q *MemoryQueue) MaintainConnection() {
+	<-q.stopCh
+	q.updateStatus(ConnectionClosed)
+	q.closeStatusListeners()
+}
+
+// Status - returns current connection status
+func (q *MemoryQueue) Status() ConnectionStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.status
+}
+
+// NotifyStatusChange - registers a listener for on connection status change
+func (q *MemoryQueue) NotifyStatusChange(listener chan ConnectionStatus) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.statusListeners = append(q.statusListeners, listener)
+}
+
+// Stop the MessageQueue
+func (q *MemoryQueue) Stop() {
+	q.stopOnce.Do(func() {
+		close(q.stopCh)
+	})
+}
+
+// updateStatus does not block on listeners that are not ready,
+// there is no real connection that could change its state concurrently
+func (q *MemoryQueue) updateStatus(s ConnectionStatus) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.status = s
+	for _, l := range q.statusListeners {
+		select {
+		case l <- s:
+		default:
+		}
+	}
+}
+
+func (q *MemoryQueue) closeStatusListeners() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, l := range q.statusListeners {
+		close(l)
+	}
+
+	q.statusListeners = nil
+}
+
+type MemoryReceivedMessage struct {
+	queueName string
+	body      []byte
+	attempt   int
+	headers   Headers
+	tag       ReceivedMessageID
+}
+
+func (m *MemoryReceivedMessage) Channel() string {
+	return m.queueName
+}
+
+func (m *MemoryReceivedMessage) Body() []byte {
+	return m.body
+}
+
+func (m *MemoryReceivedMessage) Attempt() int {
+	return m.attempt
+}
+
+func (m *MemoryReceivedMessage) ID() ReceivedMessageID {
+	return m.tag
+}
+
+func (m *MemoryReceivedMessage) Headers() Headers {
+	return m.headers
+}
+
+func (m *MemoryReceivedMessage) CloneToRequeue() Message {
+	b := make([]byte, len(m.body))
+	copy(b, m.body)
+	return NewJSONMessageWithHeaders(b, m.Attempt()+1, m.headers.clone())
+}
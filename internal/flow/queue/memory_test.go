@@ -0,0 +1,181 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func receiveOne(t *testing.T, ch <-chan ReceivedMessage) ReceivedMessage {
+	t.Helper()
+
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			t.Fatal("receive channel closed unexpectedly")
+		}
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a message")
+	}
+
+	return nil
+}
+
+func TestMemoryQueue(t *testing.T) {
+	lg := logger.NewStdoutLogger(logger.Prod, "memory_queue_test")
+
+	t.Run("direct routing", func(t *testing.T) {
+		q := Memory(lg)
+		defer q.Stop()
+
+		assert.NoError(t, q.Connect(context.Background()))
+		assert.Equal(t, ConnectionStatus(Connected), q.Status())
+
+		assert.NoError(t, q.DeclareExchange("actions", DirectExchange))
+		assert.NoError(t, q.DeclareQueue("create"))
+		assert.NoError(t, q.DeclareQueue("update"))
+		assert.NoError(t, q.Bind("create", "actions", "create"))
+		assert.NoError(t, q.Bind("update", "actions", "update"))
+
+		assert.NoError(t, q.Publish(NewJSONMessage([]byte(`{"a":1}`), 1), "actions", "create"))
+		assert.NoError(t, q.Publish(NewJSONMessage([]byte(`{"a":2}`), 1), "actions", "create"))
+		assert.NoError(t, q.Publish(NewJSONMessage([]byte(`{"b":1}`), 1), "actions", "update"))
+		assert.NoError(t, q.Publish(NewJSONMessage([]byte(`{"c":1}`), 1), "actions", "unbound"))
+
+		create, err := q.Inspect("create")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, create.Messages)
+
+		update, err := q.Inspect("update")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, update.Messages)
+
+		ch := make(chan ReceivedMessage)
+		go func() { _ = q.Subscribe("create", "test", ch) }()
+
+		first := receiveOne(t, ch)
+		assert.Equal(t, `{"a":1}`, string(first.Body()))
+		assert.Equal(t, "create", first.Channel())
+		assert.NoError(t, q.Ack(first.ID()))
+		assert.Error(t, q.Ack(first.ID()), "second ack of the same tag must fail")
+
+		second := receiveOne(t, ch)
+		assert.Equal(t, `{"a":2}`, string(second.Body()))
+
+		// rejected with requeue message comes back
+		assert.NoError(t, q.Reject(second.ID(), true))
+		redelivered := receiveOne(t, ch)
+		assert.Equal(t, `{"a":2}`, string(redelivered.Body()))
+		assert.NotEqual(t, second.ID(), redelivered.ID())
+		assert.NoError(t, q.Reject(redelivered.ID(), false))
+
+		create, err = q.Inspect("create")
+		assert.NoError(t, err)
+		assert.Equal(t, 0, create.Messages)
+		assert.Equal(t, 1, create.Consumers)
+	})
+
+	t.Run("fanout and default exchange", func(t *testing.T) {
+		q := Memory(lg)
+		defer q.Stop()
+
+		assert.NoError(t, q.DeclareExchange("persisted", FanoutExchange))
+		assert.NoError(t, q.DeclareQueue("tail-1"))
+		assert.NoError(t, q.DeclareQueue("tail-2"))
+		assert.NoError(t, q.Bind("tail-1", "persisted", ""))
+		assert.NoError(t, q.Bind("tail-2", "persisted", "whatever"))
+
+		assert.NoError(t, q.Publish(NewJSONMessage([]byte(`{}`), 1), "persisted", "ignored"))
+		assert.NoError(t, q.Publish(NewJSONMessage([]byte(`{}`), 1), "", "tail-2"))
+
+		i1, _ := q.Inspect("tail-1")
+		i2, _ := q.Inspect("tail-2")
+		assert.Equal(t, 1, i1.Messages)
+		assert.Equal(t, 2, i2.Messages)
+	})
+
+	t.Run("get, purge and headers", func(t *testing.T) {
+		q := Memory(lg)
+		defer q.Stop()
+
+		assert.NoError(t, q.DeclareQueue("dead"))
+
+		_, ok, err := q.Get("dead")
+		assert.NoError(t, err)
+		assert.False(t, ok)
+
+		h := Headers{XLastError: "boom"}
+		assert.NoError(t, q.Publish(NewJSONMessageWithHeaders([]byte(`{"a":1}`), 3, h), "", "dead"))
+		assert.NoError(t, q.Publish(NewJSONMessage([]byte(`{"a":2}`), 1), "", "dead"))
+
+		msg, ok, err := q.Get("dead")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, 3, msg.Attempt())
+		assert.Equal(t, "boom", msg.Headers().Get(XLastError))
+		assert.Equal(t, "boom", msg.CloneToRequeue().Headers().Get(XLastError))
+		assert.NoError(t, q.Reject(msg.ID(), true))
+
+		n, err := q.Purge("dead")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		_, err = q.Purge("unknown")
+		assert.Error(t, err)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		q := Memory(lg)
+		defer q.Stop()
+
+		assert.Error(t, q.DeclareExchange("topic", "topic"))
+		assert.Error(t, q.Publish(NewJSONMessage([]byte(`{}`), 1), "unknown", "key"))
+		assert.Error(t, q.Bind("unknown", "unknown", "key"))
+		_, err := q.Inspect("unknown")
+		assert.Error(t, err)
+	})
+
+	t.Run("stop closes subscriptions and listeners", func(t *testing.T) {
+		q := Memory(lg)
+		assert.NoError(t, q.DeclareQueue("create"))
+
+		listener := make(chan ConnectionStatus, 1)
+		q.NotifyStatusChange(listener)
+		go q.MaintainConnection()
+
+		ch := make(chan ReceivedMessage)
+		done := make(chan error)
+		go func() { done <- q.Subscribe("create", "test", ch) }()
+
+		q.Stop()
+		q.Stop()
+
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("subscription was not stopped")
+		}
+
+		_, ok := <-ch
+		assert.False(t, ok)
+
+		assert.Equal(t, ConnectionStatus(ConnectionClosed), <-listener)
+	})
+}
+
+func TestNewShared(t *testing.T) {
+	lg := logger.NewStdoutLogger(logger.Prod, "memory_queue_test")
+
+	_, err := NewShared(MemoryDriver, "", lg, 1)
+	assert.Equal(t, ErrDriverNotShared, errors.Cause(err))
+
+	mq, err := NewShared(RabbitMQDriver, "amqp://localhost:5672/", lg, 1)
+	assert.NoError(t, err)
+	assert.NotNil(t, mq)
+}
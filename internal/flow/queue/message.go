@@ -12,11 +12,38 @@ type Message interface {
 	Body() []byte
 	ContentType() string
 	Attempt() int
+	Headers() Headers
+}
+
+// Headers - custom string headers that travel along with the message
+type Headers map[string]string
+
+// Get header value or an empty string
+func (h Headers) Get(key string) string {
+	if h == nil {
+		return ""
+	}
+
+	return h[key]
+}
+
+func (h Headers) clone() Headers {
+	if h == nil {
+		return nil
+	}
+
+	c := make(Headers, len(h))
+	for k, v := range h {
+		c[k] = v
+	}
+
+	return c
 }
 
 type JSONMessage struct {
 	body       []byte
 	attempt    int
+	headers    Headers
 }
 
 func NewJSONMessage(b []byte, attempt int) *JSONMessage {
@@ -26,6 +53,15 @@ func NewJSONMessage(b []byte, attempt int) *JSONMessage {
 	}
 }
 
+// NewJSONMessageWithHeaders - creates a JSON message with custom headers
+func NewJSONMessageWithHeaders(b []byte, attempt int, h Headers) *JSONMessage {
+	return &JSONMessage{
+		body:       b,
+		attempt:    attempt,
+		headers:    h,
+	}
+}
+
 func (e *JSONMessage) Body() []byte {
 	return e.body
 }
@@ -38,6 +74,10 @@ func (e *JSONMessage) Attempt() int {
 	return e.attempt
 }
 
+func (e *JSONMessage) Headers() Headers {
+	return e.headers
+}
+
 type ReceivedMessageID uint64
 
 func (rmID ReceivedMessageID) UInt64() uint64 {
@@ -48,6 +88,7 @@ type ReceivedMessage interface {
 	Body() []byte
 	Channel() string
 	Attempt() int
+	Headers() Headers
 	CloneToRequeue() Message
 	ID() ReceivedMessageID
 }
@@ -56,6 +97,7 @@ type RabbitMQReceivedMessage struct {
 	queueName  string
 	body       []byte
 	attempt    int
+	headers    Headers
 	tag        ReceivedMessageID
 }
 
@@ -75,10 +117,14 @@ func (m RabbitMQReceivedMessage) ID() ReceivedMessageID {
 	return m.tag
 }
 
+func (m RabbitMQReceivedMessage) Headers() Headers {
+	return m.headers
+}
+
 func (m *RabbitMQReceivedMessage) CloneToRequeue() Message {
 	b := make([]byte, len(m.body))
 	copy(b, m.body)
-	return NewJSONMessage(b, m.Attempt()+1)
+	return NewJSONMessageWithHeaders(b, m.Attempt()+1, m.headers.clone())
 }
 
 func newRabbitMQReceivedMessage(queueName string, msg amqp.Delivery) (*RabbitMQReceivedMessage, error) {
@@ -92,9 +138,35 @@ func newRabbitMQReceivedMessage(queueName string, msg amqp.Delivery) (*RabbitMQR
 		body:       msg.Body,
 		tag:        ReceivedMessageID(msg.DeliveryTag),
 		attempt:    attempt,
+		headers:    extractCustomHeaders(msg.Headers),
 	}, nil
 }
 
+// extractCustomHeaders - collects string headers
+// except the ones used internally by the queue
+func extractCustomHeaders(h amqp.Table) Headers {
+	var out Headers
+
+	for k, v := range h {
+		if k == Attempt || k == XActionType {
+			continue
+		}
+
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+
+		if out == nil {
+			out = make(Headers)
+		}
+
+		out[k] = s
+	}
+
+	return out
+}
+
 func extractAttemptFromHeader(h amqp.Table) (int, error) {
 	v, ok := h["Attempt"]
 	if !ok {
@@ -26,11 +26,19 @@ const (
 const Attempt = "Attempt"
 const XActionType = "X-Action-Type"
 
+// Dead letter headers
+const XLastError = "X-Last-Error"
+const XOriginalQueue = "X-Original-Queue"
+const XDeadLetteredAt = "X-Dead-Lettered-At"
+
 // Scaffolder - scaffolds the Message Channel,
 // getting it ready for work
 type Scaffolder interface {
 	DeclareExchange(name, kind string) error
 	DeclareQueue(name string) error
+	// DeclareTransientQueue - declares a queue that lives only as long as the connection,
+	// for consumers that care only about messages published while they listen
+	DeclareTransientQueue(name string) error
 	Bind(queue, exchange, routingKey string) error
 }
 
@@ -40,6 +48,12 @@ type MQ interface {
 
 	Inspect(queueName string) (Inspection, error)
 
+	// Get - fetches a single message from the queue without consuming it,
+	// the message must be acked or rejected afterwards, ok is false when queue is empty
+	Get(queue string) (msg ReceivedMessage, ok bool, err error)
+	// Purge - removes all ready messages from queue and returns their number
+	Purge(queue string) (int, error)
+
 	// fixme: refactor following 4 methods to some sort of PubSub interface
 	Publish(msg Message, exchange, routingKey string) error
 	Reject(tag ReceivedMessageID, requeue bool) error
@@ -130,6 +144,10 @@ func (q *RabbitQueue) Publish(msg Message, exchange, routingKey string) error {
 		},
 	}
 
+	for k, v := range msg.Headers() {
+		p.Headers[k] = v
+	}
+
 	if msg.Attempt() != 1 {
 		q.logger.Debugf("Requing an errored message attempt %d", msg.Attempt())
 	}
@@ -160,6 +178,15 @@ func (q *RabbitQueue) DeclareQueue(name string) error {
 	return nil
 }
 
+// DeclareTransientQueue - declares an exclusive queue deleted once its consumer is gone
+func (q *RabbitQueue) DeclareTransientQueue(name string) error {
+	if _, err := q.channel.QueueDeclare(name, false, true, true, false, nil); err != nil {
+		return errors.Wrapf(err, "failed to declare transient queue %s", name)
+	}
+
+	return nil
+}
+
 // Bind queue to exchange with routingKey
 func (q *RabbitQueue) Bind(queue, exchange, routingKey string) error {
 	if err := q.channel.QueueBind(queue, routingKey, exchange, false, nil); err != nil {
@@ -191,6 +218,37 @@ func (q *RabbitQueue) Inspect(queueName string) (Inspection, error) {
 	return i, nil
 }
 
+// Get a single message from queue without auto-ack
+func (q *RabbitQueue) Get(queue string) (ReceivedMessage, bool, error) {
+	msg, ok, err := q.channel.Get(queue, false)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "could not get message from queue %s", queue)
+	}
+
+	if !ok {
+		return nil, false, nil
+	}
+
+	rMsg, err := newRabbitMQReceivedMessage(queue, msg)
+	if err != nil {
+		// message can not be handled without attempt header anyway
+		_ = q.channel.Reject(msg.DeliveryTag, true)
+		return nil, false, err
+	}
+
+	return rMsg, true, nil
+}
+
+// Purge all ready messages from the queue
+func (q *RabbitQueue) Purge(queue string) (int, error) {
+	n, err := q.channel.QueuePurge(queue, false)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not purge queue %s", queue)
+	}
+
+	return n, nil
+}
+
 // Subscribe and consume messages sending them
 // to receiveCh
 func (q *RabbitQueue) Subscribe(queue, consumer string, receiveCh chan<- ReceivedMessage) error {
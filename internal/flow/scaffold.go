@@ -1,6 +1,9 @@
 package flow
 
-import "github.com/pkg/errors"
+import (
+	"github.com/denismitr/auditbase/internal/flow/queue"
+	"github.com/pkg/errors"
+)
 
 // Scaffold the the exchange, queues and binding
 func (af *MQActionFlow) Scaffold() error {
@@ -30,5 +33,46 @@ func (af *MQActionFlow) Scaffold() error {
 			af.cfg.ActionsUpdateQueue, af.cfg.ExchangeName, af.cfg.ActionsUpdateQueue)
 	}
 
+	if err := af.scaffoldDeadLetters(); err != nil {
+		return err
+	}
+
+	return af.scaffoldActionEvents()
+}
+
+// scaffoldDeadLetters - declares dead letter exchange and
+// a dead letter queue for each of the action queues
+func (af *MQActionFlow) scaffoldDeadLetters() error {
+	dlx := af.cfg.deadLetterExchange()
+
+	if err := af.mq.DeclareExchange(dlx, "direct"); err != nil {
+		return errors.Wrapf(err, "could not declare [%s] dead letter exchange", dlx)
+	}
+
+	for _, q := range []string{af.cfg.ActionsCreateQueue, af.cfg.ActionsUpdateQueue} {
+		dlq := deadLetterQueue(q)
+
+		if err := af.mq.DeclareQueue(dlq); err != nil {
+			return errors.Wrapf(err, "could not declare [%s] dead letter queue", dlq)
+		}
+
+		if err := af.mq.Bind(dlq, dlx, dlq); err != nil {
+			return errors.Wrapf(
+				err, "could not bind [%s] queue to [%s] exchange with [%s] key", dlq, dlx, dlq)
+		}
+	}
+
+	return nil
+}
+
+// scaffoldActionEvents - declares the fanout exchange of action events, queues of its consumers
+// are declared by the consumers themselves
+func (af *MQActionFlow) scaffoldActionEvents() error {
+	exchange := af.cfg.actionEventsExchange()
+
+	if err := af.mq.DeclareExchange(exchange, queue.FanoutExchange); err != nil {
+		return errors.Wrapf(err, "could not declare [%s] action events exchange", exchange)
+	}
+
 	return nil
 }
@@ -0,0 +1,201 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/cache"
+	"github.com/denismitr/auditbase/internal/flow"
+	"github.com/denismitr/auditbase/internal/metrics"
+	"github.com/pkg/errors"
+)
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+
+	// DefaultTimeout - time every check gets to respond
+	DefaultTimeout = 2 * time.Second
+)
+
+// Check - reports whether a dependency of the service is reachable
+type Check func(ctx context.Context) error
+
+// FlowStatuser - anything that reports the state of the action flow
+type FlowStatuser interface {
+	Status() flow.Status
+}
+
+// Pinger - anything that can verify a connection to the storage
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Flow - the action flow is ready when its message queue connection is up
+func Flow(f FlowStatuser) Check {
+	return func(_ context.Context) error {
+		if status := f.Status(); !status.OK() {
+			return status
+		}
+
+		return nil
+	}
+}
+
+// Database - the storage is ready when it answers a ping
+func Database(p Pinger) Check {
+	return func(ctx context.Context) error {
+		return p.Ping(ctx)
+	}
+}
+
+// Cache - the cache is ready when it answers a ping
+func Cache(c cache.Cacher) Check {
+	return func(_ context.Context) error {
+		return c.Ping()
+	}
+}
+
+// Report - outcome of all readiness checks, every failed check
+// is reported with its error
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// OK - all checks have passed
+func (r Report) OK() bool {
+	return r.Status == StatusOK
+}
+
+// Checker - runs named readiness checks
+type Checker struct {
+	timeout time.Duration
+	names   []string
+	checks  map[string]Check
+}
+
+// NewChecker - timeout limits every check, zero means DefaultTimeout
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &Checker{
+		timeout: timeout,
+		checks:  make(map[string]Check),
+	}
+}
+
+// Add - registers a readiness check under the given name
+func (c *Checker) Add(name string, check Check) *Checker {
+	if _, ok := c.checks[name]; !ok {
+		c.names = append(c.names, name)
+	}
+
+	c.checks[name] = check
+
+	return c
+}
+
+// Ready - runs all checks concurrently, the service is ready
+// only when every one of them has passed
+func (c *Checker) Ready(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	report := Report{Status: StatusOK, Checks: make(map[string]string, len(c.names))}
+
+	for _, name := range c.names {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			status := StatusOK
+			if err := run(ctx, check); err != nil {
+				status = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			report.Checks[name] = status
+			if status != StatusOK {
+				report.Status = StatusUnavailable
+			}
+		}(name, c.checks[name])
+	}
+
+	wg.Wait()
+
+	return report
+}
+
+// run - a check that does not respect the context
+// still fails once the timeout is over
+func run(ctx context.Context, check Check) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- check(ctx)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "check did not respond in time")
+	}
+}
+
+// LiveHandler - the process is alive as long as it serves HTTP
+func LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, http.StatusOK, Report{Status: StatusOK})
+	})
+}
+
+// ReadyHandler - responds with 503 when any of the checks has failed,
+// so that the instance is taken out of load balancing
+func ReadyHandler(c *Checker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Ready(r.Context())
+
+		code := http.StatusOK
+		if !report.OK() {
+			code = http.StatusServiceUnavailable
+		}
+
+		writeReport(w, code, report)
+	})
+}
+
+func writeReport(w http.ResponseWriter, code int, report Report) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// Serve - starts a standalone server with /healthz, /readyz and /metrics
+// for binaries without an HTTP API
+func Serve(addr string, c *Checker, onError func(error)) func(context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", LiveHandler())
+	mux.Handle("/readyz", ReadyHandler(c))
+	mux.Handle("/metrics", metrics.Handler())
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			onError(err)
+		}
+	}()
+
+	return srv.Shutdown
+}
@@ -0,0 +1,82 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/flow"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type fixedFlow flow.Status
+
+func (f fixedFlow) Status() flow.Status {
+	return flow.Status(f)
+}
+
+func TestChecker_Ready(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+
+	t.Run("all checks pass", func(t *testing.T) {
+		hc := NewChecker(0).
+			Add("queue", Flow(fixedFlow{State: flow.Active})).
+			Add("database", ok)
+
+		r := hc.Ready(context.Background())
+
+		assert.True(t, r.OK())
+		assert.Equal(t, map[string]string{"queue": StatusOK, "database": StatusOK}, r.Checks)
+	})
+
+	t.Run("dropped queue connection", func(t *testing.T) {
+		hc := NewChecker(0).
+			Add("queue", Flow(fixedFlow{State: flow.Failed})).
+			Add("database", ok)
+
+		r := hc.Ready(context.Background())
+
+		assert.False(t, r.OK())
+		assert.Equal(t, StatusUnavailable, r.Status)
+		assert.Equal(t, "Actions flow has failed", r.Checks["queue"])
+		assert.Equal(t, StatusOK, r.Checks["database"])
+	})
+
+	t.Run("check that hangs", func(t *testing.T) {
+		block := make(chan struct{})
+		defer close(block)
+
+		hc := NewChecker(20*time.Millisecond).Add("cache", func(context.Context) error {
+			<-block
+			return nil
+		})
+
+		r := hc.Ready(context.Background())
+
+		assert.False(t, r.OK())
+		assert.Contains(t, r.Checks["cache"], "did not respond in time")
+	})
+}
+
+func TestReadyHandler(t *testing.T) {
+	hc := NewChecker(0).Add("database", func(context.Context) error {
+		return errors.New("connection refused")
+	})
+
+	rec := httptest.NewRecorder()
+	ReadyHandler(hc).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	var r Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, StatusUnavailable, r.Status)
+	assert.Equal(t, "connection refused", r.Checks["database"])
+}
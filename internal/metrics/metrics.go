@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "auditbase"
+
+var (
+	// ReceivedActions - actions received by the receiver API,
+	// kind is create, update or batch, status is accepted, duplicate, invalid, forbidden or failed
+	ReceivedActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "receiver",
+		Name:      "actions_total",
+		Help:      "Actions received by the receiver API by kind and status.",
+	}, []string{"kind", "status"})
+
+	// PublishDuration - time it takes to publish a message to the action flow
+	PublishDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "flow",
+		Name:      "publish_duration_seconds",
+		Help:      "Latency of publishing messages to the action flow by queue.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"queue"})
+
+	// PublishErrors - messages that could not be published to the action flow
+	PublishErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "flow",
+		Name:      "publish_errors_total",
+		Help:      "Messages that could not be published to the action flow by queue.",
+	}, []string{"queue"})
+
+	// Requeued - messages that failed to be processed and were sent back to the queue
+	Requeued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "flow",
+		Name:      "requeued_total",
+		Help:      "Messages requeued after a processing failure by queue.",
+	}, []string{"queue"})
+
+	// Rejected - messages dropped because they could neither be processed nor requeued
+	Rejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "flow",
+		Name:      "rejected_total",
+		Help:      "Messages rejected without requeue by queue.",
+	}, []string{"queue"})
+
+	// DeadLettered - messages moved to the dead letter queue after too many attempts
+	DeadLettered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "flow",
+		Name:      "dead_lettered_total",
+		Help:      "Messages moved to the dead letter queue by original queue.",
+	}, []string{"queue"})
+
+	// ProcessedActions - actions handled by the consumer, status is processed or failed
+	ProcessedActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "actions_total",
+		Help:      "Actions handled by the consumer by handler and status.",
+	}, []string{"handler", "status"})
+
+	// ProcessingDuration - time it takes the consumer handler to persist an action
+	ProcessingDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "consumer",
+		Name:      "processing_duration_seconds",
+		Help:      "Latency of action processing by handler.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"handler"})
+
+	// TxDuration - duration of database transactions from begin to commit or rollback
+	TxDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "db",
+		Name:      "transaction_duration_seconds",
+		Help:      "Duration of database transactions by driver, mode and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"driver", "mode", "status"})
+)
+
+const (
+	ReadOnly  = "read_only"
+	ReadWrite = "read_write"
+
+	Commit   = "commit"
+	Rollback = "rollback"
+)
+
+func init() {
+	prometheus.MustRegister(
+		ReceivedActions,
+		PublishDuration,
+		PublishErrors,
+		Requeued,
+		Rejected,
+		DeadLettered,
+		ProcessedActions,
+		ProcessingDuration,
+		TxDuration,
+	)
+}
+
+// ObserveTx - records duration of the transaction started at start,
+// failed transactions are counted as rolled back
+func ObserveTx(driver, mode string, start time.Time, err error) {
+	status := Commit
+	if err != nil {
+		status = Rollback
+	}
+
+	TxDuration.WithLabelValues(driver, mode, status).Observe(time.Since(start).Seconds())
+}
+
+// Handler - exposes all registered metrics in Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/flow/queue"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeInspector map[string]queue.Inspection
+
+func (f fakeInspector) Inspect(queueName string) (queue.Inspection, error) {
+	i, ok := f[queueName]
+	if !ok {
+		return queue.Inspection{}, errors.New("queue is not declared")
+	}
+
+	return i, nil
+}
+
+func TestQueueCollector(t *testing.T) {
+	c := NewQueueCollector(fakeInspector{
+		"actions.create":      {Messages: 12, Consumers: 4},
+		"actions.create.dead": {Messages: 3},
+	}, "actions.create", "actions.create.dead", "actions.unknown")
+
+	expected := `
+# HELP auditbase_queue_messages Messages waiting in the queue.
+# TYPE auditbase_queue_messages gauge
+auditbase_queue_messages{queue="actions.create"} 12
+auditbase_queue_messages{queue="actions.create.dead"} 3
+`
+
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected), "auditbase_queue_messages")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, testutil.CollectAndCount(c))
+}
+
+func TestObserveTx(t *testing.T) {
+	start := time.Now()
+
+	ObserveTx("sqlite3", ReadWrite, start, nil)
+	ObserveTx("sqlite3", ReadWrite, start, errors.New("constraint failed"))
+	ObserveTx("sqlite3", ReadWrite, start, errors.New("constraint failed"))
+
+	// one series for committed and one for rolled back transactions
+	assert.Equal(t, 2, testutil.CollectAndCount(TxDuration))
+}
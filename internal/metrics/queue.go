@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"github.com/denismitr/auditbase/internal/flow/queue"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Inspector - anything that can tell the depth of a queue, e.g. queue.MQ
+type Inspector interface {
+	Inspect(queueName string) (queue.Inspection, error)
+}
+
+// QueueCollector - reports depth and consumers of the queues at scrape time,
+// queues that cannot be inspected are left out of the scrape
+type QueueCollector struct {
+	inspector Inspector
+	queues    []string
+	messages  *prometheus.Desc
+	consumers *prometheus.Desc
+}
+
+var _ prometheus.Collector = (*QueueCollector)(nil)
+
+func NewQueueCollector(inspector Inspector, queues ...string) *QueueCollector {
+	return &QueueCollector{
+		inspector: inspector,
+		queues:    queues,
+		messages: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "queue", "messages"),
+			"Messages waiting in the queue.",
+			[]string{"queue"},
+			nil,
+		),
+		consumers: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "queue", "consumers"),
+			"Consumers subscribed to the queue.",
+			[]string{"queue"},
+			nil,
+		),
+	}
+}
+
+// RegisterQueues - registers collector of the queues depth
+func RegisterQueues(inspector Inspector, queues ...string) error {
+	return prometheus.Register(NewQueueCollector(inspector, queues...))
+}
+
+func (c *QueueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.messages
+	ch <- c.consumers
+}
+
+func (c *QueueCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, q := range c.queues {
+		i, err := c.inspector.Inspect(q)
+		if err != nil {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.messages, prometheus.GaugeValue, float64(i.Messages), q)
+		ch <- prometheus.MustNewConstMetric(c.consumers, prometheus.GaugeValue, float64(i.Consumers), q)
+	}
+}
@@ -0,0 +1,43 @@
+package model
+
+// AnonymousPrincipal - principal of requests made while authentication is disabled
+// or rejected before the user was authenticated
+const AnonymousPrincipal = "anonymous"
+
+// AccessedRecord - kind of audit records a back-office read returned
+type AccessedRecord string
+
+const (
+	AccessedAction AccessedRecord = "action"
+	AccessedEntity AccessedRecord = "entity"
+)
+
+// AccessLogEntry - who read what through the back-office API, entries are never changed
+type AccessLogEntry struct {
+	ID          ID                `json:"id"`
+	Principal   string            `json:"principal"`
+	Role        string            `json:"role"`
+	Method      string            `json:"method"`
+	Route       string            `json:"route"`
+	Path        string            `json:"path"`
+	Filters     map[string]string `json:"filters"`
+	Status      int               `json:"status"`
+	ActionIDs   []ID              `json:"actionIds"`
+	EntityIDs   []ID              `json:"entityIds"`
+	RequestedAt JSONTime          `json:"requestedAt"`
+}
+
+type AccessLogCollection struct {
+	Items []AccessLogEntry `json:"data"`
+	Meta  Meta             `json:"meta"`
+}
+
+// Add - appends IDs of the records of the kind, unknown kinds are ignored
+func (e *AccessLogEntry) Add(kind AccessedRecord, IDs ...ID) {
+	switch kind {
+	case AccessedAction:
+		e.ActionIDs = append(e.ActionIDs, IDs...)
+	case AccessedEntity:
+		e.EntityIDs = append(e.EntityIDs, IDs...)
+	}
+}
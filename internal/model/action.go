@@ -1,8 +1,12 @@
 package model
 
 import (
-	"github.com/denismitr/auditbase/internal/utils/validator"
+	"encoding/json"
+	"reflect"
 	"time"
+
+	"github.com/denismitr/auditbase/internal/utils/validator"
+	"github.com/pkg/errors"
 )
 
 type UpdateAction struct {
@@ -11,6 +15,9 @@ type UpdateAction struct {
 	Hash         string    `json:"hash"`
 	RegisteredAt time.Time `json:"registeredAt"`
 	Status       Status    `json:"status"`
+	// ActorService - service the client reports the update on behalf of,
+	// the consumer only updates the action when its actor belongs to it
+	ActorService string `json:"actorService"`
 }
 
 func (ua UpdateAction) Validate() *validator.ValidationErrors {
@@ -20,6 +27,10 @@ func (ua UpdateAction) Validate() *validator.ValidationErrors {
 		eb.Add("uid", ErrInvalidUID)
 	}
 
+	if !ua.Status.Reportable() {
+		eb.Add("status", ErrStatusNotReportable)
+	}
+
 	return eb
 }
 
@@ -38,6 +49,7 @@ type NewAction struct {
 	Status           Status      `json:"status"`
 	IsAsync          bool        `json:"isAsync"`
 	Details          interface{} `json:"details"`
+	Delta            Delta       `json:"delta"`
 	Hash             string      `json:"hash"`
 }
 
@@ -58,6 +70,9 @@ type Action struct {
 	EmittedAt      JSONTime    `json:"emittedAt"`
 	RegisteredAt   JSONTime    `json:"registeredAt"`
 	Details        interface{} `json:"details"`
+	Delta          Delta       `json:"delta"`
+	PrevChainHash  string      `json:"prevChainHash,omitempty"`
+	ChainHash      string      `json:"chainHash,omitempty"`
 }
 
 type ActionCollection struct {
@@ -65,6 +80,39 @@ type ActionCollection struct {
 	Meta  Meta     `json:"meta"`
 }
 
+// UnmarshalJSON - the delta is read from details.delta as well, where clients have been sending
+// it before it got a key of its own, details are kept as they are. A delta given in both places
+// has to be the same in both
+func (na *NewAction) UnmarshalJSON(b []byte) error {
+	type plain NewAction
+	if err := json.Unmarshal(b, (*plain)(na)); err != nil {
+		return err
+	}
+
+	details, ok := na.Details.(map[string]interface{})
+	if !ok || details["delta"] == nil {
+		return nil
+	}
+
+	raw, err := json.Marshal(details["delta"])
+	if err != nil {
+		return errors.Wrap(err, "could not read details.delta")
+	}
+
+	var delta Delta
+	if err := json.Unmarshal(raw, &delta); err != nil {
+		return errors.Wrap(err, "details.delta must be a list of property changes")
+	}
+
+	if len(na.Delta) == 0 {
+		na.Delta = delta
+	} else if !reflect.DeepEqual(na.Delta, delta) {
+		return ErrDeltaConflict
+	}
+
+	return nil
+}
+
 func (na *NewAction) Validate() *validator.ValidationErrors {
 	eb := validator.NewValidationError()
 
@@ -96,5 +144,11 @@ func (na *NewAction) Validate() *validator.ValidationErrors {
 		eb.Add("emittedAt", ErrEmittedAtEmpty)
 	}
 
+	if !na.Status.Reportable() {
+		eb.Add("status", ErrStatusNotReportable)
+	}
+
+	eb.Merge(na.Delta.Validate())
+
 	return eb
 }
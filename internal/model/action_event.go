@@ -0,0 +1,18 @@
+package model
+
+// ActionEventType - what happened to the action
+type ActionEventType string
+
+const (
+	ActionCreated ActionEventType = "created"
+	ActionUpdated ActionEventType = "updated"
+	// ActionTimedOut - the watchdog has moved the async action to TimedOut
+	ActionTimedOut ActionEventType = "timedOut"
+)
+
+// ActionEvent - announcement of an action the consumer or the watchdog has persisted,
+// the action itself is read from the storage by those interested in it
+type ActionEvent struct {
+	Type     ActionEventType `json:"type"`
+	ActionID ID              `json:"actionId"`
+}
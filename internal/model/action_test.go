@@ -2,6 +2,7 @@ package model
 
 import (
 	"encoding/json"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"testing"
 	"time"
@@ -31,7 +32,10 @@ func Test_ActionCanBeSerialized(t *testing.T) {
 			EmittedAt:      JSONTime{Time: emitted},
 			RegisteredAt:   JSONTime{Time: registered},
 			Details:        map[string]interface{}{"foo": 123, "bar": "baz"},
-			Delta:          map[string]interface{}{"foo": []string{"a", "b"}, "bar": []int{1, 2}},
+			Delta: Delta{
+				{PropertyName: "foo", CurrentPropertyType: StringProperty, From: nil, To: "a"},
+				{PropertyName: "bar", CurrentPropertyType: IntegerProperty, From: 1, To: 2},
+			},
 		}
 
 		b, err := json.Marshal(&a)
@@ -39,8 +43,57 @@ func Test_ActionCanBeSerialized(t *testing.T) {
 			panic(err)
 		}
 
-		expected := `{"id":10,"uid":"76502edbf207452eae7ec258271ee9aa","parentUid":"69502edbf207452eae7ec258271ee98c","parent":null,"childrenCount":0,"hash":"foo-hash-2","actorEntityId":45,"actor":null,"targetId":456,"target":null,"name":"foo-bar-2","status":6,"isAsync":true,"emittedAt":"2021-02-23 16:51:35","registeredAt":"2021-02-23 16:54:49","details":{"bar":"baz","foo":123},"delta":{"bar":[1,2],"foo":["a","b"]}}`
+		expected := `{"id":10,"uid":"76502edbf207452eae7ec258271ee9aa","parentUid":"69502edbf207452eae7ec258271ee98c","childrenCount":0,"hash":"foo-hash-2","actorEntityId":45,"targetId":456,"name":"foo-bar-2","status":6,"isAsync":true,"emittedAt":"2021-02-23 16:51:35","registeredAt":"2021-02-23 16:54:49","details":{"bar":"baz","foo":123},"delta":[{"propertyName":"foo","currentPropertyType":"string","from":null,"to":"a"},{"propertyName":"bar","currentPropertyType":"integer","from":1,"to":2}]}`
 
 		assert.Equal(t, expected, string(b))
 	})
 }
+
+func TestNewAction_UnmarshalJSON(t *testing.T) {
+	t.Run("delta is read from details", func(t *testing.T) {
+		var na NewAction
+		in := `{"name":"articlePublished","details":{"delta":[{"propertyName":"views","currentPropertyType":"integer","from":null,"to":1.5}]}}`
+		if !assert.NoError(t, json.Unmarshal([]byte(in), &na)) {
+			return
+		}
+
+		if assert.Len(t, na.Delta, 1) {
+			assert.Equal(t, "views", na.Delta[0].PropertyName)
+		}
+
+		assert.Contains(t, na.Details, "delta")
+		key, err := na.Delta.Validate().First()
+		assert.Equal(t, "delta.0.to", key)
+		assert.Equal(t, ErrPropertyValueMismatch, err)
+	})
+
+	t.Run("the same delta may be given in both places", func(t *testing.T) {
+		var na NewAction
+		in := `{"delta":[{"propertyName":"title","currentPropertyType":"string","from":null,"to":"a"}],"details":{"delta":[{"propertyName":"title","currentPropertyType":"string","from":null,"to":"a"}]}}`
+		assert.NoError(t, json.Unmarshal([]byte(in), &na))
+		assert.Len(t, na.Delta, 1)
+	})
+
+	t.Run("different deltas are rejected", func(t *testing.T) {
+		var na NewAction
+		in := `{"delta":[{"propertyName":"title","currentPropertyType":"string","from":null,"to":"a"}],"details":{"delta":[]}}`
+		assert.Equal(t, ErrDeltaConflict, errors.Cause(json.Unmarshal([]byte(in), &na)))
+	})
+
+	t.Run("details delta of another shape is rejected", func(t *testing.T) {
+		var na NewAction
+		assert.Error(t, json.Unmarshal([]byte(`{"details":{"delta":"title changed"}}`), &na))
+	})
+}
+
+func TestStatus_Reportable(t *testing.T) {
+	assert.True(t, Success.Reportable())
+	assert.True(t, Dynamic.Reportable())
+	assert.False(t, TimedOut.Reportable(), "only the watchdog times actions out")
+	assert.False(t, Status(42).Reportable())
+	assert.Equal(t, "TimedOut", TimedOut.String())
+
+	assert.True(t, (&NewAction{Status: TimedOut}).Validate().NotEmpty())
+	assert.True(t, UpdateAction{Status: TimedOut}.Validate().NotEmpty())
+	assert.True(t, UpdateAction{Status: Failed}.Validate().IsEmpty())
+}
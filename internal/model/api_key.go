@@ -0,0 +1,122 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/denismitr/auditbase/internal/utils/errtype"
+	"github.com/denismitr/auditbase/internal/utils/validator"
+	"github.com/pkg/errors"
+)
+
+const (
+	// APIKeyPrefixLen - number of leading characters of the key kept in clear text,
+	// so that keys can be told apart without revealing them
+	APIKeyPrefixLen = 8
+	// MaxAPIKeyNameLen - max length of the human readable name of the key
+	MaxAPIKeyNameLen = 64
+
+	apiKeyBytes = 32
+)
+
+const ErrAPIKeyServicesEmpty = errtype.StringError("at least one service is required")
+const ErrAPIKeyNameTooLong = errtype.StringError("api key name is too long")
+const ErrAPIKeyRevoked = errtype.StringError("api key is revoked")
+
+// APIKey - key issued to a client of the receiver API, it is only allowed
+// to report actions on behalf of the services it is bound to.
+// The key itself is never stored, only its SHA-256 hash
+type APIKey struct {
+	ID        ID             `json:"id"`
+	Name      string         `json:"name"`
+	Prefix    string         `json:"prefix"`
+	Hash      string         `json:"-"`
+	Services  []Microservice `json:"services"`
+	CreatedAt JSONTime       `json:"createdAt"`
+	RotatedAt JSONTime       `json:"rotatedAt"`
+	RevokedAt JSONTime       `json:"revokedAt"`
+}
+
+// Revoked - revoked keys are kept for the record but no longer authenticate
+func (k *APIKey) Revoked() bool {
+	return !k.RevokedAt.IsZero()
+}
+
+// Allows - whether the key may report actions on behalf of the service
+func (k *APIKey) Allows(service string) bool {
+	for i := range k.Services {
+		if k.Services[i].Name == service {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ServiceNames - names of the services the key is bound to
+func (k *APIKey) ServiceNames() []string {
+	names := make([]string, len(k.Services))
+	for i := range k.Services {
+		names[i] = k.Services[i].Name
+	}
+
+	return names
+}
+
+// IssuedAPIKey - newly issued or rotated key, the only time
+// the key is available in clear text
+type IssuedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// NewAPIKey - request to issue a key for the given services
+type NewAPIKey struct {
+	Name     string   `json:"name"`
+	Services []string `json:"services"`
+}
+
+func (nk *NewAPIKey) Validate() *validator.ValidationErrors {
+	eb := validator.NewValidationError()
+
+	if validator.IsEmptyString(nk.Name) {
+		eb.Add("name", ErrNameIsRequired)
+	}
+
+	if validator.StringLenGt(nk.Name, MaxAPIKeyNameLen) {
+		eb.Add("name", ErrAPIKeyNameTooLong)
+	}
+
+	if len(nk.Services) == 0 {
+		eb.Add("services", ErrAPIKeyServicesEmpty)
+	}
+
+	for _, s := range nk.Services {
+		if validator.IsEmptyString(s) || validator.StringLenGt(s, MaxServiceNameLen) {
+			eb.Add("services", ErrServiceNameInvalid)
+			break
+		}
+	}
+
+	return eb
+}
+
+// GenerateAPIKey - random key along with its clear text prefix and hash
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	b := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", errors.Wrap(err, "could not generate api key")
+	}
+
+	key = hex.EncodeToString(b)
+
+	return key, key[:APIKeyPrefixLen], HashAPIKey(key), nil
+}
+
+// HashAPIKey - hex encoded SHA-256 of the key, keys are random and long enough,
+// so there is no need for a slow password hash
+func HashAPIKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
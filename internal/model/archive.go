@@ -0,0 +1,109 @@
+package model
+
+import (
+	"time"
+
+	"github.com/denismitr/auditbase/internal/utils/errtype"
+)
+
+// ArchiveVersion - version of the archive layout written by the archiver
+const ArchiveVersion = 1
+
+// UnknownArchiveService - partition of actions that have neither an actor nor a target
+const UnknownArchiveService = "_unknown"
+
+const ErrArchiveChecksumMismatch = errtype.StringError("archive file does not match its checksum")
+const ErrArchiveCountMismatch = errtype.StringError("archive file does not hold as many actions as its manifest says")
+const ErrArchiveVersionUnsupported = errtype.StringError("archive version is not supported")
+
+// ArchivedEntity - actor or target of an archived action, kept by its natural key,
+// so that it can be restored into a database where its ID is different
+type ArchivedEntity struct {
+	Service    string `json:"service"`
+	EntityType string `json:"entityType"`
+	ExternalID string `json:"externalId"`
+}
+
+// ArchivedAction - action as written to an archive, one per line,
+// the ID and chain hashes are the ones it had in the database it was archived from
+type ArchivedAction struct {
+	ID            ID              `json:"id"`
+	UID           UID             `json:"uid"`
+	ParentUID     UID             `json:"parentUid,omitempty"`
+	Hash          string          `json:"hash,omitempty"`
+	Name          string          `json:"name"`
+	Status        Status          `json:"status"`
+	IsAsync       bool            `json:"isAsync"`
+	Actor         *ArchivedEntity `json:"actor,omitempty"`
+	Target        *ArchivedEntity `json:"target,omitempty"`
+	EmittedAt     time.Time       `json:"emittedAt"`
+	RegisteredAt  time.Time       `json:"registeredAt"`
+	Details       interface{}     `json:"details,omitempty"`
+	Delta         Delta           `json:"delta,omitempty"`
+	PrevChainHash string          `json:"prevChainHash,omitempty"`
+	ChainHash     string          `json:"chainHash,omitempty"`
+}
+
+// Service - service the action is archived under, the one of its actor
+// or of its target, when there is no actor
+func (a *ArchivedAction) Service() string {
+	switch {
+	case a.Actor != nil:
+		return a.Actor.Service
+	case a.Target != nil:
+		return a.Target.Service
+	default:
+		return UnknownArchiveService
+	}
+}
+
+// Day - day the action is archived under
+func (a *ArchivedAction) Day() string {
+	return a.RegisteredAt.UTC().Format("2006-01-02")
+}
+
+// ToAction - action to be stored anew, entities have to be resolved by the caller
+func (a *ArchivedAction) ToAction() *Action {
+	return &Action{
+		UID:          a.UID,
+		ParentUID:    a.ParentUID,
+		Hash:         a.Hash,
+		Name:         a.Name,
+		Status:       a.Status,
+		IsAsync:      a.IsAsync,
+		EmittedAt:    JSONTime{Time: a.EmittedAt},
+		RegisteredAt: JSONTime{Time: a.RegisteredAt},
+		Details:      a.Details,
+		Delta:        a.Delta,
+	}
+}
+
+// ArchiveFile - gzip compressed NDJSON file holding actions of one service registered on one day
+type ArchiveFile struct {
+	Path    string `json:"path"`
+	Day     string `json:"day"`
+	Service string `json:"service"`
+	Actions int    `json:"actions"`
+	FirstID ID     `json:"firstId"`
+	LastID  ID     `json:"lastId"`
+	Bytes   int64  `json:"bytes"`
+	SHA256  string `json:"sha256"`
+}
+
+// ArchiveManifest - contents of an archive, it is written last,
+// so an archive without one is incomplete
+type ArchiveManifest struct {
+	Version   int           `json:"version"`
+	CreatedAt time.Time     `json:"createdAt"`
+	From      time.Time     `json:"from"`
+	To        time.Time     `json:"to"`
+	Actions   int           `json:"actions"`
+	Files     []ArchiveFile `json:"files"`
+}
+
+// ArchiveImport - outcome of restoring an archive, actions already stored are skipped
+type ArchiveImport struct {
+	Files    int `json:"files"`
+	Restored int `json:"restored"`
+	Skipped  int `json:"skipped"`
+}
@@ -0,0 +1,232 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// GenesisChainHash - previous chain hash of the very first chained action
+const GenesisChainHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+const (
+	DefaultChainVerifyLimit = 10000
+	MaxChainVerifyLimit     = 100000
+	// ChainBatchSize - number of actions loaded at once while walking the chain
+	ChainBatchSize = 500
+)
+
+// ChainBreak - reason the chain is broken at some action
+type ChainBreak string
+
+const (
+	// ChainHashMissing - action stored after the chain was started has no chain hash
+	ChainHashMissing ChainBreak = "chain hash is missing"
+	// ChainHashMismatch - action content or its chain hash was altered
+	ChainHashMismatch ChainBreak = "action content does not match chain hash"
+	// ChainLinkMismatch - an action was removed, inserted or its chain hash rewritten
+	ChainLinkMismatch ChainBreak = "previous chain hash does not match the preceding action"
+)
+
+// chainPayload - action content committed to the chain, status is left out
+// since it legitimately changes after the action is stored
+type chainPayload struct {
+	UID            UID         `json:"uid"`
+	ParentUID      UID         `json:"parentUid"`
+	Name           string      `json:"name"`
+	Hash           string      `json:"hash"`
+	ActorEntityID  ID          `json:"actorEntityId"`
+	TargetEntityID ID          `json:"targetEntityId"`
+	IsAsync        bool        `json:"isAsync"`
+	EmittedAt      int64       `json:"emittedAt"`
+	RegisteredAt   int64       `json:"registeredAt"`
+	Details        interface{} `json:"details"`
+	Delta          Delta       `json:"delta"`
+}
+
+// ComputeChainHash - hex encoded SHA-256 of the previous chain hash
+// and the canonical JSON of the action content
+func ComputeChainHash(prevChainHash string, a *Action) (string, error) {
+	payload := chainPayload{
+		UID:            a.UID,
+		ParentUID:      a.ParentUID,
+		Name:           a.Name,
+		Hash:           normalizeHash(a.Hash),
+		ActorEntityID:  a.ActorEntityID,
+		TargetEntityID: a.TargetEntityID,
+		IsAsync:        a.IsAsync,
+		EmittedAt:      a.EmittedAt.Unix(),
+		RegisteredAt:   a.RegisteredAt.Unix(),
+		Details:        a.Details,
+	}
+
+	if len(a.Delta) > 0 {
+		payload.Delta = a.Delta
+	}
+
+	b, err := canonicalJSON(payload)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not encode chain payload of action [%s]", a.UID)
+	}
+
+	h := sha256.New()
+	h.Write([]byte(prevChainHash))
+	h.Write(b)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// SealChain - links the action to the chain after the given chain hash,
+// times are truncated to seconds, the precision every storage keeps,
+// so that the stored action hashes to the same value
+func (a *Action) SealChain(prevChainHash string) error {
+	a.EmittedAt = JSONTime{Time: a.EmittedAt.Truncate(time.Second)}
+	a.RegisteredAt = JSONTime{Time: a.RegisteredAt.Truncate(time.Second)}
+
+	chainHash, err := ComputeChainHash(prevChainHash, a)
+	if err != nil {
+		return err
+	}
+
+	a.PrevChainHash = prevChainHash
+	a.ChainHash = chainHash
+
+	return nil
+}
+
+// BrokenChainLink - the first action at which the chain does not hold
+type BrokenChainLink struct {
+	ActionID  ID         `json:"actionId"`
+	ActionUID UID        `json:"actionUid"`
+	Reason    ChainBreak `json:"reason"`
+	Expected  string     `json:"expected"`
+	Actual    string     `json:"actual"`
+}
+
+// ChainVerification - result of walking a range of the action chain
+type ChainVerification struct {
+	FromID     ID               `json:"fromId"`
+	ToID       ID               `json:"toId"`
+	Checked    int              `json:"checked"`
+	Unchained  int              `json:"unchained"`
+	Pruned     int              `json:"pruned"`
+	Valid      bool             `json:"valid"`
+	NextID     ID               `json:"nextId,omitempty"`
+	BrokenLink *BrokenChainLink `json:"brokenLink,omitempty"`
+
+	prevChainHash string
+}
+
+// NewChainVerification - prevChainHash is the chain hash of the action preceding the range,
+// empty when there is none, e.g. the range starts at the beginning of the chain
+// or the preceding actions were removed by retention
+func NewChainVerification(fromID ID, prevChainHash string) *ChainVerification {
+	return &ChainVerification{
+		FromID:        fromID,
+		Valid:         true,
+		prevChainHash: prevChainHash,
+	}
+}
+
+// Verify - checks the next action of the range, actions must be passed
+// in the order of their IDs with deltas loaded, returns false at the first broken link.
+// Actions stored before the chain was introduced have no chain hash and are only
+// accepted until the first chained action
+func (v *ChainVerification) Verify(a *Action) bool {
+	if !v.Valid {
+		return false
+	}
+
+	v.ToID = a.ID
+
+	if a.ChainHash == "" {
+		if v.prevChainHash != "" {
+			return v.broken(a, ChainHashMissing, v.prevChainHash, "")
+		}
+
+		v.Unchained++
+		return true
+	}
+
+	if v.prevChainHash != "" && a.PrevChainHash != v.prevChainHash {
+		return v.broken(a, ChainLinkMismatch, v.prevChainHash, a.PrevChainHash)
+	}
+
+	expected, err := ComputeChainHash(a.PrevChainHash, a)
+	if err != nil || expected != a.ChainHash {
+		return v.broken(a, ChainHashMismatch, expected, a.ChainHash)
+	}
+
+	v.prevChainHash = a.ChainHash
+	v.Checked++
+
+	return true
+}
+
+// Skip - steps over an action removed by retention, its content can no longer be checked,
+// but its tombstone must still link to the preceding action
+func (v *ChainVerification) Skip(t *ActionTombstone) bool {
+	if !v.Valid {
+		return false
+	}
+
+	v.ToID = t.ActionID
+
+	if t.ChainHash == "" {
+		v.Pruned++
+		return true
+	}
+
+	if v.prevChainHash != "" && t.PrevChainHash != v.prevChainHash {
+		return v.broken(&Action{ID: t.ActionID, UID: t.UID}, ChainLinkMismatch, v.prevChainHash, t.PrevChainHash)
+	}
+
+	v.prevChainHash = t.ChainHash
+	v.Pruned++
+
+	return true
+}
+
+func (v *ChainVerification) broken(a *Action, reason ChainBreak, expected, actual string) bool {
+	v.Valid = false
+	v.BrokenLink = &BrokenChainLink{
+		ActionID:  a.ID,
+		ActionUID: a.UID,
+		Reason:    reason,
+		Expected:  expected,
+		Actual:    actual,
+	}
+
+	return false
+}
+
+// normalizeHash - request hash as it comes back from storage,
+// where it is kept in binary form
+func normalizeHash(hash string) string {
+	if _, err := hex.DecodeString(hash); err != nil {
+		return ""
+	}
+
+	return strings.ToUpper(hash)
+}
+
+// canonicalJSON - encodes the value the same way regardless of whether it was
+// built in memory or decoded from storage: object keys are sorted
+// and every number goes through float64
+func canonicalJSON(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(generic)
+}
@@ -0,0 +1,203 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func chainedActions(t *testing.T, n int) []Action {
+	t.Helper()
+
+	emitted := time.Date(2021, 3, 1, 10, 0, 0, 500, time.UTC)
+	actions := make([]Action, n)
+	prev := GenesisChainHash
+
+	for i := range actions {
+		actions[i] = Action{
+			ID:           ID(i + 1),
+			UID:          UID("5a1b7e2a8e3d4b6c9f0a1b2c3d4e5f6" + string(rune('0'+i))),
+			Name:         "article updated",
+			Hash:         "9d5ed678fe57bcca610140957afab571",
+			EmittedAt:    JSONTime{Time: emitted.Add(time.Duration(i) * time.Minute)},
+			RegisteredAt: JSONTime{Time: emitted.Add(time.Duration(i)*time.Minute + 300*time.Millisecond)},
+			Details:      map[string]interface{}{"b": 1, "a": "foo"},
+			Delta: Delta{
+				{PropertyName: "title", CurrentPropertyType: StringProperty, From: "foo", To: "bar"},
+				{PropertyName: "views", CurrentPropertyType: IntegerProperty, From: nil, To: 10},
+			},
+		}
+
+		if err := actions[i].SealChain(prev); err != nil {
+			t.Fatal(err)
+		}
+
+		prev = actions[i].ChainHash
+	}
+
+	return actions
+}
+
+func verifyAll(actions []Action, prev string) *ChainVerification {
+	v := NewChainVerification(1, prev)
+	for i := range actions {
+		if !v.Verify(&actions[i]) {
+			break
+		}
+	}
+
+	return v
+}
+
+func TestComputeChainHash(t *testing.T) {
+	a := &Action{
+		UID:       "5a1b7e2a8e3d4b6c9f0a1b2c3d4e5f60",
+		Name:      "article created",
+		Hash:      "9d5ed678fe57bcca610140957afab571",
+		EmittedAt: JSONTime{Time: time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)},
+		Details:   map[string]interface{}{"b": 1, "a": "foo"},
+		Delta:     Delta{{PropertyName: "views", CurrentPropertyType: IntegerProperty, To: 10}},
+	}
+
+	h1, err := ComputeChainHash(GenesisChainHash, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, h1, 64)
+
+	t.Run("storage round trip gives the same hash", func(t *testing.T) {
+		stored := *a
+		stored.Hash = "9D5ED678FE57BCCA610140957AFAB571"
+		stored.Details = map[string]interface{}{"a": "foo", "b": float64(1)}
+		stored.Delta = Delta{{PropertyName: "views", CurrentPropertyType: IntegerProperty, To: float64(10)}}
+		stored.Status = Success
+
+		h2, err := ComputeChainHash(GenesisChainHash, &stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, h1, h2)
+	})
+
+	t.Run("previous hash is committed", func(t *testing.T) {
+		h2, err := ComputeChainHash(h1, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.NotEqual(t, h1, h2)
+	})
+
+	t.Run("content is committed", func(t *testing.T) {
+		changed := *a
+		changed.Name = "article deleted"
+
+		h2, err := ComputeChainHash(GenesisChainHash, &changed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.NotEqual(t, h1, h2)
+	})
+}
+
+func TestChainVerification(t *testing.T) {
+	t.Run("intact chain", func(t *testing.T) {
+		actions := chainedActions(t, 5)
+		v := verifyAll(actions, "")
+
+		assert.True(t, v.Valid)
+		assert.Equal(t, 5, v.Checked)
+		assert.Equal(t, ID(5), v.ToID)
+		assert.Nil(t, v.BrokenLink)
+		assert.Equal(t, GenesisChainHash, actions[0].PrevChainHash)
+		assert.Equal(t, 0, actions[0].RegisteredAt.Nanosecond())
+	})
+
+	t.Run("altered content", func(t *testing.T) {
+		actions := chainedActions(t, 5)
+		actions[2].Delta[0].To = "baz"
+
+		v := verifyAll(actions, "")
+
+		assert.False(t, v.Valid)
+		assert.Equal(t, 2, v.Checked)
+		assert.Equal(t, ID(3), v.BrokenLink.ActionID)
+		assert.Equal(t, ChainHashMismatch, v.BrokenLink.Reason)
+	})
+
+	t.Run("removed action", func(t *testing.T) {
+		actions := chainedActions(t, 5)
+		actions = append(actions[:2], actions[3:]...)
+
+		v := verifyAll(actions, "")
+
+		assert.False(t, v.Valid)
+		assert.Equal(t, ID(4), v.BrokenLink.ActionID)
+		assert.Equal(t, ChainLinkMismatch, v.BrokenLink.Reason)
+		assert.Equal(t, actions[1].ChainHash, v.BrokenLink.Expected)
+	})
+
+	t.Run("removed chain hash", func(t *testing.T) {
+		actions := chainedActions(t, 3)
+		actions[1].ChainHash = ""
+
+		v := verifyAll(actions, "")
+
+		assert.False(t, v.Valid)
+		assert.Equal(t, ID(2), v.BrokenLink.ActionID)
+		assert.Equal(t, ChainHashMissing, v.BrokenLink.Reason)
+	})
+
+	t.Run("unchained actions before the chain", func(t *testing.T) {
+		actions := append([]Action{{ID: 1}, {ID: 2}}, chainedActions(t, 2)...)
+
+		v := verifyAll(actions, "")
+
+		assert.True(t, v.Valid)
+		assert.Equal(t, 2, v.Unchained)
+		assert.Equal(t, 2, v.Checked)
+	})
+
+	t.Run("range in the middle of the chain", func(t *testing.T) {
+		actions := chainedActions(t, 4)
+
+		assert.True(t, verifyAll(actions[2:], actions[1].ChainHash).Valid)
+		assert.False(t, verifyAll(actions[2:], actions[0].ChainHash).Valid)
+	})
+
+	t.Run("pruned action", func(t *testing.T) {
+		actions := chainedActions(t, 5)
+		tombstone := NewActionTombstone(&RetentionCandidate{
+			ID:            actions[2].ID,
+			UID:           actions[2].UID,
+			PrevChainHash: actions[2].PrevChainHash,
+			ChainHash:     actions[2].ChainHash,
+		}, &RetentionRule{ID: 1}, time.Now())
+
+		v := NewChainVerification(1, "")
+		assert.True(t, v.Verify(&actions[0]))
+		assert.True(t, v.Verify(&actions[1]))
+		assert.True(t, v.Skip(&tombstone))
+		assert.True(t, v.Verify(&actions[3]))
+		assert.True(t, v.Verify(&actions[4]))
+
+		assert.True(t, v.Valid)
+		assert.Equal(t, 4, v.Checked)
+		assert.Equal(t, 1, v.Pruned)
+	})
+
+	t.Run("forged tombstone", func(t *testing.T) {
+		actions := chainedActions(t, 3)
+		tombstone := ActionTombstone{ActionID: 2, PrevChainHash: GenesisChainHash, ChainHash: actions[1].ChainHash}
+
+		v := NewChainVerification(1, "")
+		assert.True(t, v.Verify(&actions[0]))
+		assert.False(t, v.Skip(&tombstone))
+		assert.Equal(t, ID(2), v.BrokenLink.ActionID)
+		assert.Equal(t, ChainLinkMismatch, v.BrokenLink.Reason)
+	})
+}
@@ -0,0 +1,155 @@
+package model
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/denismitr/auditbase/internal/utils/errtype"
+	"github.com/denismitr/auditbase/internal/utils/validator"
+)
+
+const MaxPropertyNameLen = 64
+
+const ErrPropertyNameInvalid = errtype.StringError("propertyName must not be empty and must not exceed 64 characters")
+const ErrPropertyNameDuplicate = errtype.StringError("propertyName must be unique within delta")
+const ErrPropertyTypeInvalid = errtype.StringError("currentPropertyType must be one of string, integer, float, boolean")
+const ErrPropertyValueMismatch = errtype.StringError("value does not match currentPropertyType")
+const ErrPropertyValueNotScalar = errtype.StringError("value must be a string, number, boolean or null")
+
+// PropertyType - declared type of entity property
+type PropertyType string
+
+const (
+	StringProperty  PropertyType = "string"
+	IntegerProperty PropertyType = "integer"
+	FloatProperty   PropertyType = "float"
+	BooleanProperty PropertyType = "boolean"
+)
+
+// Valid - checks that property type is one of the known types
+func (pt PropertyType) Valid() bool {
+	switch pt {
+	case StringProperty, IntegerProperty, FloatProperty, BooleanProperty:
+		return true
+	default:
+		return false
+	}
+}
+
+// Accepts - checks that value is of the given property type,
+// null is acceptable for any type and means the property was unset
+func (pt PropertyType) Accepts(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+
+	switch pt {
+	case StringProperty:
+		_, ok := v.(string)
+		return ok
+	case BooleanProperty:
+		_, ok := v.(bool)
+		return ok
+	case FloatProperty:
+		_, ok := toFloat(v)
+		return ok
+	case IntegerProperty:
+		f, ok := toFloat(v)
+		return ok && f == math.Trunc(f)
+	default:
+		return false
+	}
+}
+
+// PropertyDelta - change of a single property of the target entity
+type PropertyDelta struct {
+	PropertyName        string       `json:"propertyName"`
+	CurrentPropertyType PropertyType `json:"currentPropertyType"`
+	From                interface{}  `json:"from"`
+	To                  interface{}  `json:"to"`
+}
+
+// Delta - all property changes made by an action
+type Delta []PropertyDelta
+
+// Validate - validates every property change, the current value must match
+// the declared type, the previous one may be of any scalar type
+// since the property type could have changed
+func (d Delta) Validate() *validator.ValidationErrors {
+	eb := validator.NewValidationError()
+	seen := make(map[string]bool, len(d))
+
+	for i := range d {
+		key := fmt.Sprintf("delta.%d", i)
+
+		if d[i].PropertyName == "" || len(d[i].PropertyName) > MaxPropertyNameLen {
+			eb.Add(key+".propertyName", ErrPropertyNameInvalid)
+		} else if seen[d[i].PropertyName] {
+			eb.Add(key+".propertyName", ErrPropertyNameDuplicate)
+		}
+
+		seen[d[i].PropertyName] = true
+
+		if !d[i].CurrentPropertyType.Valid() {
+			eb.Add(key+".currentPropertyType", ErrPropertyTypeInvalid)
+		} else if !d[i].CurrentPropertyType.Accepts(d[i].To) {
+			eb.Add(key+".to", ErrPropertyValueMismatch)
+		}
+
+		if !isScalar(d[i].From) {
+			eb.Add(key+".from", ErrPropertyValueNotScalar)
+		}
+	}
+
+	return eb
+}
+
+// PropertyChange - a single entry of the property history
+type PropertyChange struct {
+	ActionID     ID           `json:"actionId"`
+	ActionUID    UID          `json:"actionUid"`
+	ActionName   string       `json:"actionName"`
+	PropertyName string       `json:"propertyName"`
+	PropertyType PropertyType `json:"propertyType"`
+	From         interface{}  `json:"from"`
+	To           interface{}  `json:"to"`
+	EmittedAt    JSONTime     `json:"emittedAt"`
+}
+
+type PropertyChangeCollection struct {
+	Items []PropertyChange `json:"data"`
+	Meta  Meta             `json:"meta"`
+}
+
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case nil, string, bool:
+		return true
+	default:
+		_, ok := toFloat(v)
+		return ok
+	}
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
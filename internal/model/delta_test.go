@@ -0,0 +1,86 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelta_Validate(t *testing.T) {
+	tt := []struct {
+		name   string
+		in     string
+		errKey string
+		err    error
+	}{
+		{
+			name: "valid delta",
+			in: `[
+				{"propertyName":"text","currentPropertyType":"string","from":null,"to":"foo says bar"},
+				{"propertyName":"rating","currentPropertyType":"float","from":1,"to":1.1},
+				{"propertyName":"views","currentPropertyType":"integer","from":"many","to":1},
+				{"propertyName":"published","currentPropertyType":"boolean","from":false,"to":true},
+				{"propertyName":"title","currentPropertyType":"string","from":"baz title","to":null}
+			]`,
+		},
+		{
+			name:   "empty property name",
+			in:     `[{"propertyName":"","currentPropertyType":"string","from":null,"to":"foo"}]`,
+			errKey: "delta.0.propertyName",
+			err:    ErrPropertyNameInvalid,
+		},
+		{
+			name: "duplicate property name",
+			in: `[
+				{"propertyName":"text","currentPropertyType":"string","from":null,"to":"foo"},
+				{"propertyName":"text","currentPropertyType":"string","from":"foo","to":"bar"}
+			]`,
+			errKey: "delta.1.propertyName",
+			err:    ErrPropertyNameDuplicate,
+		},
+		{
+			name:   "unknown property type",
+			in:     `[{"propertyName":"text","currentPropertyType":"blob","from":null,"to":"foo"}]`,
+			errKey: "delta.0.currentPropertyType",
+			err:    ErrPropertyTypeInvalid,
+		},
+		{
+			name:   "float is not an integer",
+			in:     `[{"propertyName":"views","currentPropertyType":"integer","from":null,"to":1.5}]`,
+			errKey: "delta.0.to",
+			err:    ErrPropertyValueMismatch,
+		},
+		{
+			name:   "number is not a string",
+			in:     `[{"propertyName":"text","currentPropertyType":"string","from":null,"to":12}]`,
+			errKey: "delta.0.to",
+			err:    ErrPropertyValueMismatch,
+		},
+		{
+			name:   "previous value must be scalar",
+			in:     `[{"propertyName":"text","currentPropertyType":"string","from":{"a":1},"to":"foo"}]`,
+			errKey: "delta.0.from",
+			err:    ErrPropertyValueNotScalar,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var d Delta
+			if err := json.Unmarshal([]byte(tc.in), &d); err != nil {
+				t.Fatal(err)
+			}
+
+			errs := d.Validate()
+			if tc.err == nil {
+				assert.True(t, errs.IsEmpty(), errs.Error())
+				return
+			}
+
+			key, err := errs.First()
+			assert.Equal(t, tc.errKey, key)
+			assert.Equal(t, tc.err, err)
+		})
+	}
+}
@@ -19,6 +19,8 @@ const ErrActorServiceEmpty = errtype.StringError("actorService must not be empty
 const ErrTargetServiceEmpty = errtype.StringError("targetService must not be empty")
 const ErrInvalidUID = errtype.StringError("invalid uuid4")
 const ErrEmittedAtEmpty = errtype.StringError("emittedAt must not be empty")
+const ErrStatusNotReportable = errtype.StringError("status must be one of 0 to 6")
+const ErrDeltaConflict = errtype.StringError("delta and details.delta must not differ")
 
 type ErrField struct {
 	Name  string `json:"name"`
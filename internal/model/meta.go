@@ -1,7 +1,11 @@
 package model
 
+// Meta - pagination of a collection, the total is left out when it was not counted,
+// next and prev are continuation tokens of the neighbouring pages, when there are any
 type Meta struct {
-	Page        int `json:"page"`
-	PerPage     int `json:"perPage"`
-	Total       int `json:"total"`
+	Page        int    `json:"page"`
+	PerPage     int    `json:"perPage"`
+	Total       *int   `json:"total,omitempty"`
+	Next        string `json:"next,omitempty"`
+	Prev        string `json:"prev,omitempty"`
 }
@@ -0,0 +1,54 @@
+package model
+
+import (
+	"time"
+)
+
+// FutureActionPartition - last partition of the actions table, it holds everything
+// registered after the last month partition and is split when months are added
+const FutureActionPartition = "p_future"
+
+// ActionPartition - monthly partition of the actions table holding actions registered
+// during its month, the first one also holds everything registered earlier,
+// the future partition has zero Until
+type ActionPartition struct {
+	Name  string    `json:"name"`
+	Until time.Time `json:"until"`
+	Rows  int64     `json:"rows"`
+}
+
+// Bounded - whether it is a month partition rather than the future one
+func (p *ActionPartition) Bounded() bool {
+	return !p.Until.IsZero()
+}
+
+// ActionPartitionName - name of the partition of the month the time falls into
+func ActionPartitionName(t time.Time) string {
+	return "p" + t.UTC().Format("200601")
+}
+
+// NextActionPartitions - month partitions following the last bound up to and including
+// the month of until, when there is no bound yet they start with the month of now
+func NextActionPartitions(lastBound, now, until time.Time) []ActionPartition {
+	start := lastBound.UTC()
+	if lastBound.IsZero() {
+		start = startOfMonth(now)
+	}
+
+	end := startOfMonth(until).AddDate(0, 1, 0)
+
+	var partitions []ActionPartition
+	for month := start; month.Before(end); month = month.AddDate(0, 1, 0) {
+		partitions = append(partitions, ActionPartition{
+			Name:  ActionPartitionName(month),
+			Until: month.AddDate(0, 1, 0),
+		})
+	}
+
+	return partitions
+}
+
+func startOfMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextActionPartitions(t *testing.T) {
+	now := time.Date(2021, 11, 20, 10, 0, 0, 0, time.UTC)
+
+	t.Run("starts with the current month", func(t *testing.T) {
+		partitions := NextActionPartitions(time.Time{}, now, now.AddDate(0, 2, 0))
+
+		assert.Equal(t, []ActionPartition{
+			{Name: "p202111", Until: time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC)},
+			{Name: "p202112", Until: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+			{Name: "p202201", Until: time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)},
+		}, partitions)
+	})
+
+	t.Run("follows the last bound", func(t *testing.T) {
+		lastBound := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		partitions := NextActionPartitions(lastBound, now, now.AddDate(0, 2, 0))
+
+		assert.Equal(t, []ActionPartition{
+			{Name: "p202201", Until: time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)},
+		}, partitions)
+	})
+
+	t.Run("nothing is missing", func(t *testing.T) {
+		lastBound := time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)
+
+		assert.Empty(t, NextActionPartitions(lastBound, now, now.AddDate(0, 2, 0)))
+	})
+}
@@ -0,0 +1,211 @@
+package model
+
+import (
+	"time"
+
+	"github.com/denismitr/auditbase/internal/utils/errtype"
+	"github.com/denismitr/auditbase/internal/utils/validator"
+)
+
+const (
+	// MaxRetentionDays - longest period a rule may keep actions for, a hundred years
+	MaxRetentionDays = 36500
+	// MaxActionNameLen - max length of an action name
+	MaxActionNameLen = 36
+)
+
+const ErrRetentionKeepDaysInvalid = errtype.StringError("keepDays must be between 1 and 36500")
+const ErrActionNameInvalid = errtype.StringError("action name failed validation")
+
+// RetentionRule - how long actions of a service, actions with a name or both are kept.
+// Service is the service of the actor of the action, or of the target when there is no actor.
+// A rule with neither of them applies to every action no other rule matches,
+// actions no rule matches at all are kept forever
+type RetentionRule struct {
+	ID         ID       `json:"id"`
+	Service    string   `json:"service"`
+	ActionName string   `json:"actionName"`
+	KeepDays   int      `json:"keepDays"`
+	CreatedAt  JSONTime `json:"createdAt"`
+}
+
+func (r *RetentionRule) Validate() *validator.ValidationErrors {
+	eb := validator.NewValidationError()
+
+	if validator.StringLenGt(r.Service, MaxServiceNameLen) {
+		eb.Add("service", ErrServiceNameInvalid)
+	}
+
+	if validator.StringLenGt(r.ActionName, MaxActionNameLen) {
+		eb.Add("actionName", ErrActionNameInvalid)
+	}
+
+	if r.KeepDays < 1 || r.KeepDays > MaxRetentionDays {
+		eb.Add("keepDays", ErrRetentionKeepDaysInvalid)
+	}
+
+	return eb
+}
+
+// Matches - whether the rule applies to an action of the service with the name
+func (r *RetentionRule) Matches(service, actionName string) bool {
+	return (r.Service == "" || r.Service == service) &&
+		(r.ActionName == "" || r.ActionName == actionName)
+}
+
+// Cutoff - actions registered before it are expired
+func (r *RetentionRule) Cutoff(now time.Time) time.Time {
+	return now.AddDate(0, 0, -r.KeepDays)
+}
+
+// specificity - rules naming the action win over the ones naming the service,
+// which win over the catch-all rule
+func (r *RetentionRule) specificity() int {
+	s := 0
+	if r.ActionName != "" {
+		s += 2
+	}
+
+	if r.Service != "" {
+		s++
+	}
+
+	return s
+}
+
+type RetentionRules []RetentionRule
+
+// Match - the most specific rule applying to an action of the service with the name,
+// nil when none does
+func (rs RetentionRules) Match(service, actionName string) *RetentionRule {
+	var match *RetentionRule
+	for i := range rs {
+		if !rs[i].Matches(service, actionName) {
+			continue
+		}
+
+		if match == nil || rs[i].specificity() > match.specificity() {
+			match = &rs[i]
+		}
+	}
+
+	return match
+}
+
+// EarliestCutoff - no action registered after it can be expired by any of the rules,
+// zero time when there are no rules
+func (rs RetentionRules) EarliestCutoff(now time.Time) time.Time {
+	var cutoff time.Time
+	for i := range rs {
+		if c := rs[i].Cutoff(now); cutoff.IsZero() || c.After(cutoff) {
+			cutoff = c
+		}
+	}
+
+	return cutoff
+}
+
+// ExpiredBefore - every action registered before it is expired by the rule matching it,
+// zero time when there is no catch-all rule, since the actions no rule matches are kept forever
+func (rs RetentionRules) ExpiredBefore(now time.Time) time.Time {
+	var cutoff time.Time
+	catchAll := false
+	for i := range rs {
+		if rs[i].Service == "" && rs[i].ActionName == "" {
+			catchAll = true
+		}
+
+		if c := rs[i].Cutoff(now); cutoff.IsZero() || c.Before(cutoff) {
+			cutoff = c
+		}
+	}
+
+	if !catchAll {
+		return time.Time{}
+	}
+
+	return cutoff
+}
+
+// RetentionCandidate - action old enough to possibly be expired by a retention rule
+type RetentionCandidate struct {
+	ID            ID
+	UID           UID
+	Name          string
+	Service       string
+	RegisteredAt  time.Time
+	PrevChainHash string
+	ChainHash     string
+}
+
+// ActionTombstone - what is left of an action removed by a retention rule or deleted
+// by an admin, in which case there is no rule, its chain hashes keep the hash chain
+// verifiable across the gap
+type ActionTombstone struct {
+	ActionID      ID       `json:"actionId"`
+	UID           UID      `json:"uid"`
+	Name          string   `json:"name"`
+	PrevChainHash string   `json:"prevChainHash"`
+	ChainHash     string   `json:"chainHash"`
+	RuleID        ID       `json:"ruleId"`
+	PrunedAt      JSONTime `json:"prunedAt"`
+}
+
+// NewActionTombstone - tombstone of the candidate removed by the rule
+func NewActionTombstone(c *RetentionCandidate, rule *RetentionRule, at time.Time) ActionTombstone {
+	return ActionTombstone{
+		ActionID:      c.ID,
+		UID:           c.UID,
+		Name:          c.Name,
+		PrevChainHash: c.PrevChainHash,
+		ChainHash:     c.ChainHash,
+		RuleID:        rule.ID,
+		PrunedAt:      JSONTime{Time: at},
+	}
+}
+
+// RuleCleanup - number of actions a rule removed
+type RuleCleanup struct {
+	RetentionRule
+	Removed int `json:"removed"`
+}
+
+// CleanupReport - what a run of the cleaner removed, nothing is removed on a dry run
+type CleanupReport struct {
+	StartedAt   JSONTime      `json:"startedAt"`
+	FinishedAt  JSONTime      `json:"finishedAt"`
+	DryRun      bool          `json:"dryRun"`
+	Scanned     int           `json:"scanned"`
+	Actions     int           `json:"actions"`
+	Rules       []RuleCleanup `json:"rules"`
+	Entities    int           `json:"entities"`
+	EntityTypes int           `json:"entityTypes"`
+	Partitions  []string      `json:"partitions"`
+}
+
+// NewCleanupReport - empty report of a run enforcing the rules
+func NewCleanupReport(rules RetentionRules, dryRun bool, at time.Time) *CleanupReport {
+	r := &CleanupReport{
+		StartedAt:  JSONTime{Time: at},
+		DryRun:     dryRun,
+		Rules:      make([]RuleCleanup, len(rules)),
+		Partitions: make([]string, 0),
+	}
+
+	for i := range rules {
+		r.Rules[i] = RuleCleanup{RetentionRule: rules[i]}
+	}
+
+	return r
+}
+
+// Removed - counts an action removed by the rule
+func (r *CleanupReport) Removed(rule *RetentionRule) {
+	r.Actions++
+	for i := range r.Rules {
+		if r.Rules[i].ID == rule.ID {
+			r.Rules[i].Removed++
+			return
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetentionRules_Match(t *testing.T) {
+	rules := RetentionRules{
+		{ID: 1, KeepDays: 365},
+		{ID: 2, Service: "billing", KeepDays: 2555},
+		{ID: 3, ActionName: "pageViewed", KeepDays: 30},
+		{ID: 4, Service: "billing", ActionName: "pageViewed", KeepDays: 90},
+	}
+
+	tt := []struct {
+		service string
+		name    string
+		ruleID  ID
+	}{
+		{service: "billing", name: "pageViewed", ruleID: 4},
+		{service: "articles", name: "pageViewed", ruleID: 3},
+		{service: "billing", name: "invoicePaid", ruleID: 2},
+		{service: "articles", name: "articleCreated", ruleID: 1},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.service+" "+tc.name, func(t *testing.T) {
+			r := rules.Match(tc.service, tc.name)
+			if assert.NotNil(t, r) {
+				assert.Equal(t, tc.ruleID, r.ID)
+			}
+		})
+	}
+
+	t.Run("no catch-all rule keeps unmatched actions", func(t *testing.T) {
+		assert.Nil(t, rules[1:].Match("articles", "articleCreated"))
+	})
+
+	t.Run("earliest cutoff is given by the shortest rule", func(t *testing.T) {
+		now := time.Date(2021, 3, 31, 0, 0, 0, 0, time.UTC)
+
+		assert.Equal(t, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), rules.EarliestCutoff(now))
+		assert.True(t, RetentionRules{}.EarliestCutoff(now).IsZero())
+	})
+}
+
+func TestRetentionRules_ExpiredBefore(t *testing.T) {
+	now := time.Date(2021, 3, 15, 10, 0, 0, 0, time.UTC)
+
+	t.Run("longest kept rule bounds it", func(t *testing.T) {
+		rules := RetentionRules{
+			{ID: 1, KeepDays: 365},
+			{ID: 2, Service: "billing", KeepDays: 730},
+			{ID: 3, ActionName: "pageViewed", KeepDays: 30},
+		}
+
+		assert.Equal(t, now.AddDate(0, 0, -730), rules.ExpiredBefore(now))
+	})
+
+	t.Run("without a catch-all rule some actions are kept forever", func(t *testing.T) {
+		rules := RetentionRules{{ID: 2, Service: "billing", KeepDays: 730}}
+
+		assert.True(t, rules.ExpiredBefore(now).IsZero())
+	})
+}
+
+func TestRetentionRule_Validate(t *testing.T) {
+	assert.True(t, (&RetentionRule{Service: "billing", KeepDays: 2555}).Validate().IsEmpty())
+	assert.True(t, (&RetentionRule{KeepDays: 0}).Validate().NotEmpty())
+	assert.True(t, (&RetentionRule{KeepDays: MaxRetentionDays + 1}).Validate().NotEmpty())
+	assert.True(t, (&RetentionRule{ActionName: "a very long action name that is too long", KeepDays: 1}).Validate().NotEmpty())
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultSigningGrace - how long the previous secret keeps working after rotation
+	DefaultSigningGrace = 24 * time.Hour
+	// MaxSigningGrace - longest grace window a rotation may ask for
+	MaxSigningGrace = 7 * 24 * time.Hour
+
+	signingSecretBytes = 32
+)
+
+// SigningSecrets - shared secrets a microservice signs its action submissions with,
+// the previous secret is still accepted until PreviousExpiresAt,
+// so that clients can pick up a rotated secret without downtime
+type SigningSecrets struct {
+	ServiceID         ID
+	Secret            string
+	PreviousSecret    string
+	PreviousExpiresAt time.Time
+	RotatedAt         time.Time
+}
+
+// Accepted - secrets a signature may be made with at the given time
+func (s *SigningSecrets) Accepted(now time.Time) []string {
+	secrets := []string{s.Secret}
+	if s.PreviousSecret != "" && now.Before(s.PreviousExpiresAt) {
+		secrets = append(secrets, s.PreviousSecret)
+	}
+
+	return secrets
+}
+
+// Rotate - the current secret becomes the previous one for the grace window
+func (s *SigningSecrets) Rotate(secret string, now time.Time, grace time.Duration) {
+	s.PreviousSecret = s.Secret
+	s.PreviousExpiresAt = now.Add(grace)
+	s.Secret = secret
+	s.RotatedAt = now
+}
+
+// IssuedSigningSecret - newly issued secret, the only time it is returned by the API
+type IssuedSigningSecret struct {
+	ServiceID          ID       `json:"serviceId"`
+	Secret             string   `json:"secret"`
+	RotatedAt          JSONTime `json:"rotatedAt"`
+	PreviousValidUntil JSONTime `json:"previousValidUntil"`
+}
+
+// GenerateSigningSecret - random hex encoded secret
+func GenerateSigningSecret() (string, error) {
+	b := make([]byte, signingSecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "could not generate signing secret")
+	}
+
+	return hex.EncodeToString(b), nil
+}
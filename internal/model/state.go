@@ -0,0 +1,57 @@
+package model
+
+import "time"
+
+// PropertyState - value of entity property and the action that set it
+type PropertyState struct {
+	Value     interface{}  `json:"value"`
+	Type      PropertyType `json:"type"`
+	ActionID  ID           `json:"actionId"`
+	ActionUID UID          `json:"actionUid"`
+	ChangedAt JSONTime     `json:"changedAt"`
+}
+
+// EntityState - properties of entity as of some moment
+// reconstructed from the deltas of actions targeting it
+type EntityState struct {
+	EntityID   ID                       `json:"entityId"`
+	At         JSONTime                 `json:"at"`
+	Properties map[string]PropertyState `json:"properties"`
+	ActionIDs  []ID                     `json:"actionIds"`
+}
+
+// ReconstructEntityState - replays property changes, which must be ordered
+// by emitted at time, null value unsets the property
+func ReconstructEntityState(entityID ID, at time.Time, changes []PropertyChange) *EntityState {
+	state := &EntityState{
+		EntityID:   entityID,
+		At:         JSONTime{Time: at},
+		Properties: make(map[string]PropertyState),
+		ActionIDs:  make([]ID, 0),
+	}
+
+	for i := range changes {
+		if changes[i].EmittedAt.After(at) {
+			continue
+		}
+
+		if n := len(state.ActionIDs); n == 0 || state.ActionIDs[n-1] != changes[i].ActionID {
+			state.ActionIDs = append(state.ActionIDs, changes[i].ActionID)
+		}
+
+		if changes[i].To == nil {
+			delete(state.Properties, changes[i].PropertyName)
+			continue
+		}
+
+		state.Properties[changes[i].PropertyName] = PropertyState{
+			Value:     changes[i].To,
+			Type:      changes[i].PropertyType,
+			ActionID:  changes[i].ActionID,
+			ActionUID: changes[i].ActionUID,
+			ChangedAt: changes[i].EmittedAt,
+		}
+	}
+
+	return state
+}
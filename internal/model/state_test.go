@@ -0,0 +1,44 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconstructEntityState(t *testing.T) {
+	created := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	edited := time.Date(2021, 3, 3, 12, 0, 0, 0, time.UTC)
+	unpublished := time.Date(2021, 3, 5, 9, 0, 0, 0, time.UTC)
+
+	changes := []PropertyChange{
+		{ActionID: 1, ActionUID: "a1", PropertyName: "title", PropertyType: StringProperty, To: "foo", EmittedAt: JSONTime{Time: created}},
+		{ActionID: 1, ActionUID: "a1", PropertyName: "published", PropertyType: BooleanProperty, To: true, EmittedAt: JSONTime{Time: created}},
+		{ActionID: 2, ActionUID: "a2", PropertyName: "title", PropertyType: StringProperty, From: "foo", To: "bar", EmittedAt: JSONTime{Time: edited}},
+		{ActionID: 3, ActionUID: "a3", PropertyName: "published", PropertyType: BooleanProperty, From: true, To: nil, EmittedAt: JSONTime{Time: unpublished}},
+	}
+
+	t.Run("before any action", func(t *testing.T) {
+		state := ReconstructEntityState(5, created.Add(-time.Second), changes)
+		assert.Equal(t, ID(5), state.EntityID)
+		assert.Empty(t, state.Properties)
+		assert.Empty(t, state.ActionIDs)
+	})
+
+	t.Run("between actions", func(t *testing.T) {
+		state := ReconstructEntityState(5, edited, changes)
+		assert.Equal(t, []ID{1, 2}, state.ActionIDs)
+		assert.Equal(t, map[string]PropertyState{
+			"title":     {Value: "bar", Type: StringProperty, ActionID: 2, ActionUID: "a2", ChangedAt: JSONTime{Time: edited}},
+			"published": {Value: true, Type: BooleanProperty, ActionID: 1, ActionUID: "a1", ChangedAt: JSONTime{Time: created}},
+		}, state.Properties)
+	})
+
+	t.Run("unset property", func(t *testing.T) {
+		state := ReconstructEntityState(5, unpublished, changes)
+		assert.Equal(t, []ID{1, 2, 3}, state.ActionIDs)
+		assert.Len(t, state.Properties, 1)
+		assert.Equal(t, "bar", state.Properties["title"].Value)
+	})
+}
@@ -0,0 +1,210 @@
+package model
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/utils/errtype"
+	"github.com/denismitr/auditbase/internal/utils/validator"
+)
+
+// MaxStatsBuckets - most buckets a time series may have: a day by the minute,
+// almost three months by the hour or more than five years by the day
+const MaxStatsBuckets = 2000
+
+const ErrStatsIntervalInvalid = errtype.StringError("interval must be one of minute, hour or day")
+const ErrStatsGroupInvalid = errtype.StringError("groupBy must be one of name, status, actorService, targetService, actorEntityType or targetEntityType")
+const ErrStatsRangeInvalid = errtype.StringError("from must precede to")
+const ErrStatsTooManyBuckets = errtype.StringError("the time range holds more than 2000 intervals")
+
+// StatsInterval - width of the buckets actions are counted in
+type StatsInterval string
+
+const (
+	MinuteInterval StatsInterval = "minute"
+	HourInterval   StatsInterval = "hour"
+	DayInterval    StatsInterval = "day"
+)
+
+var statsIntervals = map[StatsInterval]time.Duration{
+	MinuteInterval: time.Minute,
+	HourInterval:   time.Hour,
+	DayInterval:    24 * time.Hour,
+}
+
+// Duration - width of the bucket, zero for an unknown interval
+func (i StatsInterval) Duration() time.Duration {
+	return statsIntervals[i]
+}
+
+// StatsGroup - attribute of actions counted in series of their own,
+// an empty one counts all the actions in a single series
+type StatsGroup string
+
+const (
+	NoGroup                 StatsGroup = ""
+	GroupByName             StatsGroup = "name"
+	GroupByStatus           StatsGroup = "status"
+	GroupByActorService     StatsGroup = "actorService"
+	GroupByTargetService    StatsGroup = "targetService"
+	GroupByActorEntityType  StatsGroup = "actorEntityType"
+	GroupByTargetEntityType StatsGroup = "targetEntityType"
+)
+
+var statsGroups = map[StatsGroup]bool{
+	NoGroup:                 true,
+	GroupByName:             true,
+	GroupByStatus:           true,
+	GroupByActorService:     true,
+	GroupByTargetService:    true,
+	GroupByActorEntityType:  true,
+	GroupByTargetEntityType: true,
+}
+
+// ActionStatsQuery - actions registered from From up to but not including To are counted
+// per interval and group, buckets start at multiples of the interval in UTC
+type ActionStatsQuery struct {
+	From     time.Time
+	To       time.Time
+	Interval StatsInterval
+	GroupBy  StatsGroup
+}
+
+func (q *ActionStatsQuery) Validate() *validator.ValidationErrors {
+	eb := validator.NewValidationError()
+
+	if q.Interval.Duration() == 0 {
+		eb.Add("interval", ErrStatsIntervalInvalid)
+	}
+
+	if !statsGroups[q.GroupBy] {
+		eb.Add("groupBy", ErrStatsGroupInvalid)
+	}
+
+	if !q.From.Before(q.To) {
+		eb.Add("from", ErrStatsRangeInvalid)
+	} else if q.Interval.Duration() != 0 && len(q.Buckets()) > MaxStatsBuckets {
+		eb.Add("interval", ErrStatsTooManyBuckets)
+	}
+
+	return eb
+}
+
+// Buckets - starts of the buckets of the time range, the first one is the bucket From falls into
+func (q *ActionStatsQuery) Buckets() []time.Time {
+	d := q.Interval.Duration()
+	if d == 0 {
+		return nil
+	}
+
+	var buckets []time.Time
+	for b := q.From.UTC().Truncate(d); b.Before(q.To); b = b.Add(d) {
+		buckets = append(buckets, b)
+		if len(buckets) > MaxStatsBuckets {
+			break
+		}
+	}
+
+	return buckets
+}
+
+// ActionStatsCount - number of actions of the group registered during the bucket,
+// the group is empty when actions are not grouped or the actions have no actor or target
+type ActionStatsCount struct {
+	Bucket time.Time
+	Group  string
+	Count  int
+}
+
+type ActionStatsPoint struct {
+	At    JSONTime `json:"at"`
+	Count int      `json:"count"`
+}
+
+// ActionStatsSeries - counts of the actions of a group in every bucket of the time range
+type ActionStatsSeries struct {
+	Group  string             `json:"group"`
+	Total  int                `json:"total"`
+	Points []ActionStatsPoint `json:"points"`
+}
+
+type ActionStats struct {
+	From     JSONTime            `json:"from"`
+	To       JSONTime            `json:"to"`
+	Interval StatsInterval       `json:"interval"`
+	GroupBy  StatsGroup          `json:"groupBy,omitempty"`
+	Series   []ActionStatsSeries `json:"series"`
+}
+
+// NewActionStats - time series of the counts, buckets without actions count zero,
+// series go from the largest total to the smallest, statuses are named
+func NewActionStats(q *ActionStatsQuery, counts []ActionStatsCount) *ActionStats {
+	buckets := q.Buckets()
+	index := make(map[int64]int, len(buckets))
+	for i, b := range buckets {
+		index[b.Unix()] = i
+	}
+
+	stats := &ActionStats{
+		From:     JSONTime{Time: q.From.UTC()},
+		To:       JSONTime{Time: q.To.UTC()},
+		Interval: q.Interval,
+		GroupBy:  q.GroupBy,
+		Series:   []ActionStatsSeries{},
+	}
+
+	series := make(map[string]*ActionStatsSeries)
+	var groups []string
+
+	newSeries := func(group string) *ActionStatsSeries {
+		s := &ActionStatsSeries{Group: group, Points: make([]ActionStatsPoint, len(buckets))}
+		for i, b := range buckets {
+			s.Points[i].At = JSONTime{Time: b}
+		}
+
+		series[group] = s
+		groups = append(groups, group)
+		return s
+	}
+
+	if q.GroupBy == NoGroup {
+		newSeries("")
+	}
+
+	for _, c := range counts {
+		i, ok := index[c.Bucket.UTC().Truncate(q.Interval.Duration()).Unix()]
+		if !ok {
+			continue
+		}
+
+		group := c.Group
+		if q.GroupBy == GroupByStatus {
+			if code, err := strconv.Atoi(group); err == nil {
+				group = Status(code).String()
+			}
+		}
+
+		s, ok := series[group]
+		if !ok {
+			s = newSeries(group)
+		}
+
+		s.Points[i].Count += c.Count
+		s.Total += c.Count
+	}
+
+	for _, group := range groups {
+		stats.Series = append(stats.Series, *series[group])
+	}
+
+	sort.SliceStable(stats.Series, func(i, j int) bool {
+		if stats.Series[i].Total != stats.Series[j].Total {
+			return stats.Series[i].Total > stats.Series[j].Total
+		}
+
+		return stats.Series[i].Group < stats.Series[j].Group
+	})
+
+	return stats
+}
@@ -0,0 +1,81 @@
+package model
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActionStatsQuery_Validate(t *testing.T) {
+	from := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	tt := []struct {
+		name  string
+		query ActionStatsQuery
+		key   string
+		err   error
+	}{
+		{name: "unknown interval", query: ActionStatsQuery{From: from, To: from.Add(time.Hour), Interval: "week"}, key: "interval", err: ErrStatsIntervalInvalid},
+		{name: "unknown group", query: ActionStatsQuery{From: from, To: from.Add(time.Hour), Interval: HourInterval, GroupBy: "uid"}, key: "groupBy", err: ErrStatsGroupInvalid},
+		{name: "empty range", query: ActionStatsQuery{From: from, To: from, Interval: HourInterval}, key: "from", err: ErrStatsRangeInvalid},
+		{name: "too many buckets", query: ActionStatsQuery{From: from, To: from.AddDate(0, 0, 2), Interval: MinuteInterval}, key: "interval", err: ErrStatsTooManyBuckets},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := tc.query.Validate()
+			if assert.True(t, errs.NotEmpty()) {
+				key, err := errs.First()
+				assert.Equal(t, tc.key, key)
+				assert.Equal(t, tc.err, err)
+			}
+		})
+	}
+
+	q := ActionStatsQuery{From: from, To: from.AddDate(0, 0, 1), Interval: MinuteInterval, GroupBy: GroupByActorService}
+	assert.True(t, q.Validate().IsEmpty())
+}
+
+func TestNewActionStats(t *testing.T) {
+	from := time.Date(2020, 5, 1, 10, 30, 0, 0, time.UTC)
+	hour := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	t.Run("buckets without actions count zero", func(t *testing.T) {
+		q := &ActionStatsQuery{From: from, To: from.Add(2 * time.Hour), Interval: HourInterval}
+
+		stats := NewActionStats(q, []ActionStatsCount{{Bucket: hour.Add(time.Hour), Count: 3}})
+
+		assert.Equal(t, []ActionStatsSeries{{
+			Total: 3,
+			Points: []ActionStatsPoint{
+				{At: JSONTime{Time: hour}},
+				{At: JSONTime{Time: hour.Add(time.Hour)}, Count: 3},
+				{At: JSONTime{Time: hour.Add(2 * time.Hour)}},
+			},
+		}}, stats.Series)
+	})
+
+	t.Run("series of the largest groups go first and statuses are named", func(t *testing.T) {
+		q := &ActionStatsQuery{From: hour, To: hour.Add(time.Hour), Interval: HourInterval, GroupBy: GroupByStatus}
+
+		stats := NewActionStats(q, []ActionStatsCount{
+			{Bucket: hour, Group: strconv.Itoa(int(Failed)), Count: 1},
+			{Bucket: hour, Group: strconv.Itoa(int(Success)), Count: 5},
+		})
+
+		if assert.Len(t, stats.Series, 2) {
+			assert.Equal(t, "Success", stats.Series[0].Group)
+			assert.Equal(t, 5, stats.Series[0].Total)
+			assert.Equal(t, "Failed", stats.Series[1].Group)
+			assert.Equal(t, 1, stats.Series[1].Points[0].Count)
+		}
+	})
+
+	t.Run("no series of groups without actions", func(t *testing.T) {
+		q := &ActionStatsQuery{From: hour, To: hour.Add(time.Hour), Interval: HourInterval, GroupBy: GroupByName}
+
+		assert.Empty(t, NewActionStats(q, nil).Series)
+	})
+}
@@ -16,8 +16,11 @@ const (
 	Success        Status = 5
 	Failed         Status = 6
 	Incorrect      Status = 7
+	// TimedOut - the async action has not reported its completion within its timeout
+	TimedOut Status = 8
 )
 
+// statusMap - statuses clients may report
 var statusMap = map[string]Status{
 	"Dynamic":        Dynamic,
 	"Pending":        Pending,
@@ -28,6 +31,11 @@ var statusMap = map[string]Status{
 	"Failed":         Failed,
 }
 
+// watchdogStatusMap - statuses only the watchdog sets
+var watchdogStatusMap = map[string]Status{
+	"TimedOut": TimedOut,
+}
+
 func MapStringToStatus(status string) (Status, error) {
 	if v, ok := statusMap[status]; ok {
 		return v, nil
@@ -37,11 +45,37 @@ func MapStringToStatus(status string) (Status, error) {
 }
 
 func MapStatusToString(status Status) (string, error) {
-	for k, v := range statusMap {
-		if v == status {
-			return k, nil
+	for _, m := range []map[string]Status{statusMap, watchdogStatusMap} {
+		for k, v := range m {
+			if v == status {
+				return k, nil
+			}
 		}
 	}
 
 	return "", errors.Wrapf(ErrIncorrectStatusCode, "%#v", status)
-}
\ No newline at end of file
+}
+
+// Reportable - whether clients may report the status of their actions
+func (s Status) Reportable() bool {
+	for _, v := range statusMap {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// InProgress - the action is yet to report its completion
+func (s Status) InProgress() bool {
+	return s == Pending || s == Processing || s == Retrying
+}
+
+func (s Status) String() string {
+	if name, err := MapStatusToString(s); err == nil {
+		return name
+	}
+
+	return "Incorrect"
+}
@@ -0,0 +1,156 @@
+package model
+
+import (
+	"time"
+
+	"github.com/denismitr/auditbase/internal/utils/errtype"
+	"github.com/denismitr/auditbase/internal/utils/validator"
+)
+
+// MaxTimeoutSeconds - longest time an async action may be given to complete, 30 days
+const MaxTimeoutSeconds = 30 * 24 * 60 * 60
+
+const ErrTimeoutSecondsInvalid = errtype.StringError("timeoutSeconds must be between 1 and 2592000")
+
+// TimeoutRule - how long async actions of a service, actions with a name or both are given
+// to report their completion since they were registered. Service is the service of the actor
+// of the action, or of the target when there is no actor. The most specific rule wins,
+// as with the retention rules, actions no rule matches never time out
+type TimeoutRule struct {
+	ID             ID       `json:"id"`
+	Service        string   `json:"service"`
+	ActionName     string   `json:"actionName"`
+	TimeoutSeconds int      `json:"timeoutSeconds"`
+	CreatedAt      JSONTime `json:"createdAt"`
+}
+
+func (r *TimeoutRule) Validate() *validator.ValidationErrors {
+	eb := validator.NewValidationError()
+
+	if validator.StringLenGt(r.Service, MaxServiceNameLen) {
+		eb.Add("service", ErrServiceNameInvalid)
+	}
+
+	if validator.StringLenGt(r.ActionName, MaxActionNameLen) {
+		eb.Add("actionName", ErrActionNameInvalid)
+	}
+
+	if r.TimeoutSeconds < 1 || r.TimeoutSeconds > MaxTimeoutSeconds {
+		eb.Add("timeoutSeconds", ErrTimeoutSecondsInvalid)
+	}
+
+	return eb
+}
+
+// Matches - whether the rule applies to an action of the service with the name
+func (r *TimeoutRule) Matches(service, actionName string) bool {
+	return (r.Service == "" || r.Service == service) &&
+		(r.ActionName == "" || r.ActionName == actionName)
+}
+
+// Cutoff - actions still in progress registered before it are timed out
+func (r *TimeoutRule) Cutoff(now time.Time) time.Time {
+	return now.Add(-time.Duration(r.TimeoutSeconds) * time.Second)
+}
+
+// specificity - rules naming the action win over the ones naming the service,
+// which win over the catch-all rule
+func (r *TimeoutRule) specificity() int {
+	s := 0
+	if r.ActionName != "" {
+		s += 2
+	}
+
+	if r.Service != "" {
+		s++
+	}
+
+	return s
+}
+
+type TimeoutRules []TimeoutRule
+
+// Match - the most specific rule applying to an action of the service with the name,
+// nil when none does
+func (rs TimeoutRules) Match(service, actionName string) *TimeoutRule {
+	var match *TimeoutRule
+	for i := range rs {
+		if !rs[i].Matches(service, actionName) {
+			continue
+		}
+
+		if match == nil || rs[i].specificity() > match.specificity() {
+			match = &rs[i]
+		}
+	}
+
+	return match
+}
+
+// EarliestCutoff - no action registered after it can be timed out by any of the rules,
+// zero time when there are no rules
+func (rs TimeoutRules) EarliestCutoff(now time.Time) time.Time {
+	var cutoff time.Time
+	for i := range rs {
+		if c := rs[i].Cutoff(now); cutoff.IsZero() || c.After(cutoff) {
+			cutoff = c
+		}
+	}
+
+	return cutoff
+}
+
+// TimeoutCandidate - async action still in progress, registered long enough ago
+// to possibly be timed out by a rule
+type TimeoutCandidate struct {
+	ID           ID
+	UID          UID
+	Name         string
+	Service      string
+	Status       Status
+	RegisteredAt time.Time
+}
+
+// ActionTimeout - async action moved to TimedOut by a sweep of the watchdog
+type ActionTimeout struct {
+	ID             ID       `json:"id"`
+	SweepID        ID       `json:"sweepId"`
+	ActionID       ID       `json:"actionId"`
+	ActionUID      UID      `json:"actionUid"`
+	ActionName     string   `json:"actionName"`
+	Service        string   `json:"service"`
+	RuleID         ID       `json:"ruleId"`
+	PreviousStatus Status   `json:"previousStatus"`
+	RegisteredAt   JSONTime `json:"registeredAt"`
+	TimedOutAt     JSONTime `json:"timedOutAt"`
+}
+
+func NewActionTimeout(c *TimeoutCandidate, rule *TimeoutRule, sweepID ID, now time.Time) ActionTimeout {
+	return ActionTimeout{
+		SweepID:        sweepID,
+		ActionID:       c.ID,
+		ActionUID:      c.UID,
+		ActionName:     c.Name,
+		Service:        c.Service,
+		RuleID:         rule.ID,
+		PreviousStatus: c.Status,
+		RegisteredAt:   JSONTime{Time: c.RegisteredAt},
+		TimedOutAt:     JSONTime{Time: now},
+	}
+}
+
+// TimeoutSweep - run of the watchdog that timed out at least one action,
+// scanned are the actions in progress it looked at
+type TimeoutSweep struct {
+	ID         ID              `json:"id"`
+	StartedAt  JSONTime        `json:"startedAt"`
+	FinishedAt JSONTime        `json:"finishedAt"`
+	Scanned    int             `json:"scanned"`
+	TimedOut   int             `json:"timedOut"`
+	Timeouts   []ActionTimeout `json:"timeouts,omitempty"`
+}
+
+type TimeoutSweepCollection struct {
+	Items []TimeoutSweep `json:"data"`
+	Meta  Meta           `json:"meta"`
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeoutRules_Match(t *testing.T) {
+	rules := TimeoutRules{
+		{ID: 1, TimeoutSeconds: 3600},
+		{ID: 2, Service: "billing", TimeoutSeconds: 600},
+		{ID: 3, ActionName: "reportGenerated", TimeoutSeconds: 86400},
+		{ID: 4, Service: "billing", ActionName: "refundIssued", TimeoutSeconds: 120},
+	}
+
+	tt := []struct {
+		service string
+		name    string
+		ruleID  ID
+	}{
+		{service: "billing", name: "refundIssued", ruleID: 4},
+		{service: "billing", name: "reportGenerated", ruleID: 3},
+		{service: "billing", name: "invoicePaid", ruleID: 2},
+		{service: "articles", name: "articleImported", ruleID: 1},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.service+" "+tc.name, func(t *testing.T) {
+			r := rules.Match(tc.service, tc.name)
+			if assert.NotNil(t, r) {
+				assert.Equal(t, tc.ruleID, r.ID)
+			}
+		})
+	}
+
+	t.Run("actions no rule matches never time out", func(t *testing.T) {
+		assert.Nil(t, rules[1:].Match("articles", "articleImported"))
+	})
+
+	t.Run("earliest cutoff is given by the shortest rule", func(t *testing.T) {
+		now := time.Date(2021, 3, 31, 12, 0, 0, 0, time.UTC)
+
+		assert.Equal(t, time.Date(2021, 3, 31, 11, 58, 0, 0, time.UTC), rules.EarliestCutoff(now))
+		assert.True(t, TimeoutRules{}.EarliestCutoff(now).IsZero())
+	})
+}
+
+func TestTimeoutRule_Validate(t *testing.T) {
+	assert.True(t, (&TimeoutRule{Service: "billing", TimeoutSeconds: 600}).Validate().IsEmpty())
+	assert.True(t, (&TimeoutRule{TimeoutSeconds: 0}).Validate().NotEmpty())
+	assert.True(t, (&TimeoutRule{TimeoutSeconds: MaxTimeoutSeconds + 1}).Validate().NotEmpty())
+	assert.True(t, (&TimeoutRule{ActionName: "a very long action name that is too long", TimeoutSeconds: 1}).Validate().NotEmpty())
+}
+
+func TestStatus_InProgress(t *testing.T) {
+	for _, s := range []Status{Pending, Processing, Retrying} {
+		assert.True(t, s.InProgress(), s.String())
+	}
+
+	for _, s := range []Status{Dynamic, PartialSuccess, Success, Failed, Incorrect, TimedOut} {
+		assert.False(t, s.InProgress(), s.String())
+	}
+}
@@ -0,0 +1,116 @@
+package model
+
+const (
+	DefaultTreeDepth  = 5
+	MaxTreeDepth      = 20
+	DefaultTreeFanOut = 50
+	MaxTreeFanOut     = 500
+	// MaxTreeNodes - hard limit of descendants loaded for a single tree
+	MaxTreeNodes = 5000
+	// MaxAncestors - hard limit of the ancestor chain, protects from endless parent chains
+	MaxAncestors = 100
+)
+
+// TreeOptions - limits of the action tree
+type TreeOptions struct {
+	Depth  int
+	FanOut int
+	// Services - when not empty, only actions whose actor or target belongs
+	// to one of the services are kept in the tree
+	Services []string
+}
+
+// ActionNode - action with its descendants
+type ActionNode struct {
+	Action    *Action       `json:"action"`
+	Depth     int           `json:"depth"`
+	Children  []*ActionNode `json:"children"`
+	Truncated bool          `json:"truncated"`
+}
+
+// ActionTree - causal chain of the action, ancestors are ordered
+// from the root of the chain to the direct parent of the action
+type ActionTree struct {
+	Ancestors     []Action       `json:"ancestors"`
+	Root          *ActionNode    `json:"root"`
+	Status        Status         `json:"status"`
+	StatusName    string         `json:"statusName"`
+	StatusCounts  map[string]int `json:"statusCounts"`
+	Nodes         int            `json:"nodes"`
+	Truncated     bool           `json:"truncated"`
+	CycleDetected bool           `json:"cycleDetected"`
+}
+
+// Walk - visits the node and all its descendants depth first
+func (n *ActionNode) Walk(fn func(*ActionNode)) {
+	fn(n)
+
+	for i := range n.Children {
+		n.Children[i].Walk(fn)
+	}
+}
+
+// Prune - drops children, along with their descendants, the filter does not keep
+func (n *ActionNode) Prune(keep func(*Action) bool) {
+	children := n.Children[:0]
+	for _, c := range n.Children {
+		if keep(c.Action) {
+			c.Prune(keep)
+			children = append(children, c)
+		}
+	}
+
+	n.Children = children
+}
+
+// Aggregate - counts nodes of the tree and aggregates their statuses
+func (t *ActionTree) Aggregate() {
+	var statuses []Status
+
+	t.StatusCounts = make(map[string]int)
+	t.Root.Walk(func(n *ActionNode) {
+		statuses = append(statuses, n.Action.Status)
+		t.StatusCounts[n.Action.Status.String()]++
+		if n.Truncated {
+			t.Truncated = true
+		}
+	})
+
+	t.Nodes = len(statuses)
+	t.Status = AggregateStatus(statuses)
+	t.StatusName = t.Status.String()
+}
+
+// AggregateStatus - status of a group of actions, e.g. an async saga:
+// in progress while any action is pending, processing or retrying,
+// success or failure when all finished actions agree, partial success otherwise.
+// Dynamic actions do not report a status and are ignored
+func AggregateStatus(statuses []Status) Status {
+	var succeeded, failed, finished int
+
+	for _, s := range statuses {
+		switch s {
+		case Pending, Processing, Retrying:
+			return Processing
+		case Success:
+			succeeded++
+			finished++
+		case Failed, Incorrect, TimedOut:
+			failed++
+			finished++
+		case PartialSuccess:
+			finished++
+		}
+	}
+
+	switch {
+	case finished == 0:
+		return Dynamic
+	case succeeded == finished:
+		return Success
+	case failed == finished:
+		return Failed
+	default:
+		return PartialSuccess
+	}
+}
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregateStatus(t *testing.T) {
+	tt := []struct {
+		name     string
+		statuses []Status
+		expected Status
+	}{
+		{"all succeeded", []Status{Success, Success, Dynamic}, Success},
+		{"all failed", []Status{Failed, Incorrect}, Failed},
+		{"timed out counts as failed", []Status{Failed, TimedOut}, Failed},
+		{"still running", []Status{Success, Retrying, Failed}, Processing},
+		{"mixed", []Status{Success, Failed, Success}, PartialSuccess},
+		{"partial child", []Status{Success, PartialSuccess}, PartialSuccess},
+		{"nothing reported", []Status{Dynamic, Dynamic}, Dynamic},
+		{"empty", nil, Dynamic},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, AggregateStatus(tc.statuses))
+		})
+	}
+}
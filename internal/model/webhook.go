@@ -0,0 +1,331 @@
+package model
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/search"
+	"github.com/denismitr/auditbase/internal/utils/errtype"
+	"github.com/denismitr/auditbase/internal/utils/validator"
+	"github.com/pkg/errors"
+)
+
+const (
+	// MaxWebhookRuleNameLen - max length of the human readable name of the rule
+	MaxWebhookRuleNameLen = 64
+	// MaxWebhookURLLen - max length of the url deliveries are posted to
+	MaxWebhookURLLen = 2048
+	// MaxWebhookEntityTypeLen - max length of an entity type name
+	MaxWebhookEntityTypeLen = 64
+	// MaxWebhookAttempts - attempts to deliver a webhook before it is given up on
+	MaxWebhookAttempts = 8
+
+	webhookFirstBackoff = 30 * time.Second
+	webhookMaxBackoff   = time.Hour
+)
+
+const ErrWebhookRuleNameInvalid = errtype.StringError("webhook rule name must be between 1 and 64 characters long")
+const ErrWebhookURLInvalid = errtype.StringError("webhook url must be an absolute http or https url")
+const ErrWebhookEntityTypeInvalid = errtype.StringError("entity type name is too long")
+const ErrWebhookNamePatternInvalid = errtype.StringError("name pattern must be an action name, * stands for any characters")
+const ErrWebhookStatusInvalid = errtype.StringError("status is not a known action status")
+const ErrWebhookConditionInvalid = errtype.StringError("condition must only compare values of action details")
+
+var webhookNamePatternRegex = regexp.MustCompile(`^[A-Za-z0-9_\-.*]+$`)
+
+// WebhookRule - posts actions matching all of its criteria to the url, a criterion left empty
+// matches any action. Service and entity type are the ones of the actor or of the target,
+// when both are given the same entity has to be of both. Condition compares values of
+// the details in the search query language, e.g. details.amount > 100 details.currency = EUR
+type WebhookRule struct {
+	ID          ID      `json:"id"`
+	Name        string  `json:"name"`
+	URL         string  `json:"url"`
+	Service     string  `json:"service"`
+	EntityType  string  `json:"entityType"`
+	NamePattern string  `json:"namePattern"`
+	Status      *Status `json:"status"`
+	Condition   string  `json:"condition"`
+	Enabled     bool    `json:"enabled"`
+	// Secret - key the deliveries are signed with, only returned when the rule is created
+	Secret    string   `json:"-"`
+	CreatedAt JSONTime `json:"createdAt"`
+	UpdatedAt JSONTime `json:"updatedAt"`
+}
+
+// IssuedWebhookRule - newly created rule, the only time its secret is returned by the API
+type IssuedWebhookRule struct {
+	WebhookRule
+	Secret string `json:"secret"`
+}
+
+func (r *WebhookRule) Validate() *validator.ValidationErrors {
+	eb := validator.NewValidationError()
+
+	if validator.IsEmptyString(r.Name) || validator.StringLenGt(r.Name, MaxWebhookRuleNameLen) {
+		eb.Add("name", ErrWebhookRuleNameInvalid)
+	}
+
+	if u, err := url.Parse(r.URL); err != nil ||
+		(u.Scheme != "http" && u.Scheme != "https") ||
+		u.Host == "" ||
+		validator.StringLenGt(r.URL, MaxWebhookURLLen) {
+		eb.Add("url", ErrWebhookURLInvalid)
+	}
+
+	if validator.StringLenGt(r.Service, MaxServiceNameLen) {
+		eb.Add("service", ErrServiceNameInvalid)
+	}
+
+	if validator.StringLenGt(r.EntityType, MaxWebhookEntityTypeLen) {
+		eb.Add("entityType", ErrWebhookEntityTypeInvalid)
+	}
+
+	if r.NamePattern != "" && (validator.StringLenGt(r.NamePattern, MaxActionNameLen) || !webhookNamePatternRegex.MatchString(r.NamePattern)) {
+		eb.Add("namePattern", ErrWebhookNamePatternInvalid)
+	}
+
+	if r.Status != nil {
+		if _, err := MapStatusToString(*r.Status); err != nil {
+			eb.Add("status", ErrWebhookStatusInvalid)
+		}
+	}
+
+	if r.Condition != "" {
+		if _, err := r.conditions(); err != nil {
+			eb.Add("condition", err)
+		}
+	}
+
+	return eb
+}
+
+// conditions - the parsed condition, details comparisons only
+func (r *WebhookRule) conditions() ([]search.Condition, error) {
+	if r.Condition == "" {
+		return nil, nil
+	}
+
+	q, err := search.Parse(r.Condition)
+	if err != nil {
+		return nil, errors.Wrap(ErrWebhookConditionInvalid, err.Error())
+	}
+
+	if len(q.Words) > 0 {
+		return nil, errors.Wrapf(ErrWebhookConditionInvalid, "%q is not a comparison", q.Words[0])
+	}
+
+	for _, c := range q.Conditions {
+		if c.Source != search.Details {
+			return nil, errors.Wrapf(ErrWebhookConditionInvalid, "delta of %s cannot be compared", c.Property())
+		}
+	}
+
+	return q.Conditions, nil
+}
+
+// matches - whether the action meets every criterion of the rule,
+// details are the decoded details of the action
+func (r *WebhookRule) matches(a *Action, details interface{}) bool {
+	if r.Status != nil && *r.Status != a.Status {
+		return false
+	}
+
+	if r.NamePattern != "" && !matchNamePattern(r.NamePattern, a.Name) {
+		return false
+	}
+
+	if r.Service != "" || r.EntityType != "" {
+		found := false
+		for _, e := range []*Entity{a.Actor, a.Target} {
+			if e != nil && e.EntityType != nil &&
+				(r.EntityType == "" || e.EntityType.Name == r.EntityType) &&
+				(r.Service == "" || (e.EntityType.Service != nil && e.EntityType.Service.Name == r.Service)) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	conditions, err := r.conditions()
+	if err != nil {
+		return false
+	}
+
+	for _, c := range conditions {
+		if !c.Matches(details) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type WebhookRules []WebhookRule
+
+// Match - enabled rules the action matches, the action is expected
+// to come with its actor and target along with their types and services
+func (rs WebhookRules) Match(a *Action) WebhookRules {
+	details := decodeDetails(a.Details)
+
+	var matched WebhookRules
+	for i := range rs {
+		if rs[i].Enabled && rs[i].matches(a, details) {
+			matched = append(matched, rs[i])
+		}
+	}
+
+	return matched
+}
+
+// decodeDetails - details as decoded from JSON, whatever form they are kept in
+func decodeDetails(details interface{}) interface{} {
+	var b []byte
+	switch d := details.(type) {
+	case nil:
+		return nil
+	case string:
+		b = []byte(d)
+	case []byte:
+		b = d
+	case json.RawMessage:
+		b = d
+	default:
+		var err error
+		if b, err = json.Marshal(d); err != nil {
+			return nil
+		}
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return nil
+	}
+
+	return decoded
+}
+
+// matchNamePattern - whether the name matches the pattern, in which * stands for any characters
+func matchNamePattern(pattern, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == name
+	}
+
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+
+	name = name[len(parts[0]):]
+	last := parts[len(parts)-1]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+
+		name = name[i+len(part):]
+	}
+
+	return strings.HasSuffix(name, last)
+}
+
+// WebhookDeliveryState - where a delivery is in its lifecycle
+type WebhookDeliveryState string
+
+const (
+	// DeliveryPending - the delivery is yet to be attempted or retried at NextAttemptAt
+	DeliveryPending WebhookDeliveryState = "pending"
+	// DeliveryDelivered - the receiver responded with a 2xx status
+	DeliveryDelivered WebhookDeliveryState = "delivered"
+	// DeliveryFailed - every attempt failed, the delivery is not retried anymore
+	DeliveryFailed WebhookDeliveryState = "failed"
+)
+
+// WebhookDelivery - webhook of the rule about the action, there is at most one for every
+// status the action reaches. The payload is taken when the delivery is created,
+// so that retries post the action as it was at that time
+type WebhookDelivery struct {
+	ID            ID                   `json:"id"`
+	RuleID        ID                   `json:"ruleId"`
+	ActionID      ID                   `json:"actionId"`
+	ActionStatus  Status               `json:"actionStatus"`
+	Event         ActionEventType      `json:"event"`
+	Payload       json.RawMessage      `json:"payload"`
+	State         WebhookDeliveryState `json:"state"`
+	Attempts      int                  `json:"attempts"`
+	ResponseCode  int                  `json:"responseCode"`
+	LastError     string               `json:"lastError"`
+	NextAttemptAt JSONTime             `json:"nextAttemptAt"`
+	CreatedAt     JSONTime             `json:"createdAt"`
+	DeliveredAt   JSONTime             `json:"deliveredAt"`
+}
+
+type WebhookDeliveryCollection struct {
+	Items []WebhookDelivery `json:"data"`
+	Meta  Meta              `json:"meta"`
+}
+
+// WebhookPayload - body of the webhook posted about an action
+type WebhookPayload struct {
+	Event  ActionEventType    `json:"event"`
+	Rule   WebhookPayloadRule `json:"rule"`
+	Action *Action            `json:"action"`
+}
+
+type WebhookPayloadRule struct {
+	ID   ID     `json:"id"`
+	Name string `json:"name"`
+}
+
+// Delivered - the receiver accepted the delivery
+func (d *WebhookDelivery) Delivered(now time.Time, code int) {
+	d.Attempts++
+	d.State = DeliveryDelivered
+	d.ResponseCode = code
+	d.LastError = ""
+	d.DeliveredAt = JSONTime{Time: now}
+}
+
+// Failed - the attempt failed, the delivery is retried with exponential backoff,
+// starting at 30 seconds and up to an hour, until MaxWebhookAttempts are made
+func (d *WebhookDelivery) Failed(now time.Time, code int, reason string) {
+	d.Attempts++
+	d.ResponseCode = code
+	d.LastError = reason
+
+	if d.Attempts >= MaxWebhookAttempts {
+		d.State = DeliveryFailed
+		return
+	}
+
+	backoff := webhookFirstBackoff << uint(d.Attempts-1)
+	if backoff > webhookMaxBackoff {
+		backoff = webhookMaxBackoff
+	}
+
+	d.State = DeliveryPending
+	d.NextAttemptAt = JSONTime{Time: now.Add(backoff)}
+}
+
+// Abandoned - the delivery is given up on without an attempt, e.g. its rule was disabled
+// after it was created, it can still be retried
+func (d *WebhookDelivery) Abandoned(reason string) {
+	d.State = DeliveryFailed
+	d.LastError = reason
+}
+
+// Retry - a delivery given up on is attempted again as soon as possible
+func (d *WebhookDelivery) Retry(now time.Time) {
+	d.State = DeliveryPending
+	d.Attempts = 0
+	d.NextAttemptAt = JSONTime{Time: now}
+}
@@ -0,0 +1,117 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookRule_Validate(t *testing.T) {
+	status := Status(42)
+
+	tt := []struct {
+		name string
+		rule WebhookRule
+		key  string
+		err  error
+	}{
+		{name: "no name", rule: WebhookRule{URL: "https://incidents.local/hooks"}, key: "name", err: ErrWebhookRuleNameInvalid},
+		{name: "relative url", rule: WebhookRule{Name: "incidents", URL: "/hooks"}, key: "url", err: ErrWebhookURLInvalid},
+		{name: "not an http url", rule: WebhookRule{Name: "incidents", URL: "ftp://incidents.local"}, key: "url", err: ErrWebhookURLInvalid},
+		{name: "invalid name pattern", rule: WebhookRule{Name: "incidents", URL: "https://incidents.local", NamePattern: "invoice paid"}, key: "namePattern", err: ErrWebhookNamePatternInvalid},
+		{name: "unknown status", rule: WebhookRule{Name: "incidents", URL: "https://incidents.local", Status: &status}, key: "status", err: ErrWebhookStatusInvalid},
+		{name: "words in condition", rule: WebhookRule{Name: "incidents", URL: "https://incidents.local", Condition: "refund"}, key: "condition", err: ErrWebhookConditionInvalid},
+		{name: "delta in condition", rule: WebhookRule{Name: "incidents", URL: "https://incidents.local", Condition: "delta.price.to > 1"}, key: "condition", err: ErrWebhookConditionInvalid},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := tc.rule.Validate()
+			if assert.True(t, errs.NotEmpty()) {
+				key, err := errs.First()
+				assert.Equal(t, tc.key, key)
+				assert.Contains(t, err.Error(), tc.err.Error())
+			}
+		})
+	}
+
+	failed := Failed
+	rule := WebhookRule{
+		Name:        "incidents",
+		URL:         "https://incidents.local/hooks?team=billing",
+		Service:     "billing",
+		NamePattern: "*Deleted",
+		Status:      &failed,
+		Condition:   "details.amount > 100",
+	}
+	assert.True(t, rule.Validate().IsEmpty())
+}
+
+func TestWebhookRules_Match(t *testing.T) {
+	invoice := &Entity{EntityType: &EntityType{Name: "invoice", Service: &Microservice{Name: "billing"}}}
+	user := &Entity{EntityType: &EntityType{Name: "user", Service: &Microservice{Name: "accounts"}}}
+	action := &Action{
+		Name:    "invoiceDeleted",
+		Status:  Failed,
+		Actor:   user,
+		Target:  invoice,
+		Details: `{"amount":250,"currency":"EUR"}`,
+	}
+
+	failed, success := Failed, Success
+
+	tt := []struct {
+		name    string
+		rule    WebhookRule
+		matches bool
+	}{
+		{name: "catch-all", rule: WebhookRule{}, matches: true},
+		{name: "service of the target", rule: WebhookRule{Service: "billing"}, matches: true},
+		{name: "entity type of the actor", rule: WebhookRule{EntityType: "user"}, matches: true},
+		{name: "service and entity type of different entities", rule: WebhookRule{Service: "billing", EntityType: "user"}, matches: false},
+		{name: "name suffix", rule: WebhookRule{NamePattern: "*Deleted"}, matches: true},
+		{name: "name prefix and suffix", rule: WebhookRule{NamePattern: "invoice*ted"}, matches: true},
+		{name: "other name", rule: WebhookRule{NamePattern: "*Created"}, matches: false},
+		{name: "status", rule: WebhookRule{Status: &failed}, matches: true},
+		{name: "other status", rule: WebhookRule{Status: &success}, matches: false},
+		{name: "details condition", rule: WebhookRule{Condition: "details.amount > 100 details.currency = EUR"}, matches: true},
+		{name: "failing details condition", rule: WebhookRule{Condition: "details.amount > 1000"}, matches: false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.rule.Enabled = true
+			assert.Equal(t, tc.matches, len(WebhookRules{tc.rule}.Match(action)) == 1)
+		})
+	}
+
+	assert.Empty(t, WebhookRules{{}}.Match(action), "disabled rules never match")
+}
+
+func TestWebhookDelivery_Failed(t *testing.T) {
+	now := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	d := &WebhookDelivery{State: DeliveryPending}
+
+	d.Failed(now, 503, "service unavailable")
+	assert.Equal(t, DeliveryPending, d.State)
+	assert.Equal(t, now.Add(30*time.Second), d.NextAttemptAt.Time)
+
+	d.Failed(now, 503, "service unavailable")
+	assert.Equal(t, now.Add(time.Minute), d.NextAttemptAt.Time)
+
+	for d.Attempts < MaxWebhookAttempts-1 {
+		d.Failed(now, 0, "connection refused")
+	}
+
+	assert.Equal(t, DeliveryPending, d.State)
+	assert.Equal(t, now.Add(32*time.Minute), d.NextAttemptAt.Time)
+
+	d.Failed(now, 0, "connection refused")
+	assert.Equal(t, DeliveryFailed, d.State)
+	assert.Equal(t, "connection refused", d.LastError)
+
+	d.Retry(now)
+	assert.Equal(t, DeliveryPending, d.State)
+	assert.Equal(t, 0, d.Attempts)
+}
@@ -0,0 +1,152 @@
+package receiver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/denismitr/auditbase/internal/metrics"
+	"github.com/denismitr/auditbase/internal/utils/validator"
+	"github.com/pkg/errors"
+)
+
+// MaxBatchSize - max number of actions accepted in one batch request
+const MaxBatchSize = 1000
+
+var ErrBatchTooLarge = errors.New("batch is too large")
+var ErrEmptyBatch = errors.New("batch is empty")
+
+// BatchItemStatus - outcome of processing of a single batch item
+type BatchItemStatus string
+
+const (
+	BatchItemAccepted  BatchItemStatus = "accepted"
+	BatchItemDuplicate BatchItemStatus = "duplicate"
+	BatchItemInvalid   BatchItemStatus = "invalid"
+	BatchItemForbidden BatchItemStatus = "forbidden"
+	BatchItemFailed    BatchItemStatus = "failed"
+)
+
+// BatchItemResult - result of a single batch item,
+// Reg is only present for accepted items and Err for all the others
+type BatchItemResult struct {
+	Index  int
+	Status BatchItemStatus
+	Reg    *Reg
+	Err    error
+}
+
+// ReceiveBatchForCreate - receives either a JSON array or NDJSON stream of new actions,
+// each item is validated, hashed and deduplicated on its own, so one bad item
+// does not fail the whole batch, neither does an item of a service the client is not allowed to report for
+func (rc *Receiver) ReceiveBatchForCreate(r io.Reader, ndjson bool, auth Authorizer) ([]BatchItemResult, error) {
+	b, err := readBytes(r)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := splitBatch(b, ndjson)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchItemResult, len(items))
+	for i := range items {
+		results[i] = rc.receiveBatchItem(i, items[i], auth)
+	}
+
+	return results, nil
+}
+
+func (rc *Receiver) receiveBatchItem(index int, item []byte, auth Authorizer) BatchItemResult {
+	result := BatchItemResult{Index: index}
+
+	reg, err := rc.receiveNewAction(item, auth)
+	result.Status = receiveStatus(err)
+	metrics.ReceivedActions.WithLabelValues(batchKind, string(result.Status)).Inc()
+
+	if err == nil {
+		result.Reg = reg
+		return result
+	}
+
+	result.Err = err
+
+	if result.Status == BatchItemFailed {
+		rc.lg.Error(errors.Wrapf(err, "batch item %d failed", index))
+	}
+
+	return result
+}
+
+// receiveStatus - outcome of receiving a single action
+func receiveStatus(err error) BatchItemStatus {
+	if err == nil {
+		return BatchItemAccepted
+	}
+
+	if _, ok := err.(*validator.ValidationErrors); ok {
+		return BatchItemInvalid
+	}
+
+	switch errors.Cause(err) {
+	case ErrActionAlreadyProcessed:
+		return BatchItemDuplicate
+	case ErrInvalidInput:
+		return BatchItemInvalid
+	case ErrServiceNotAllowed:
+		return BatchItemForbidden
+	default:
+		return BatchItemFailed
+	}
+}
+
+// splitBatch - splits raw batch payload into separate action payloads
+// JSON array is expected unless ndjson flag is set, but a payload
+// that does not start with [ is treated as NDJSON stream anyway
+func splitBatch(b []byte, ndjson bool) ([][]byte, error) {
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) == 0 {
+		return nil, ErrEmptyBatch
+	}
+
+	var items [][]byte
+	if !ndjson && trimmed[0] == '[' {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(trimmed, &raw); err != nil {
+			return nil, errors.Wrapf(ErrInvalidInput, "could not parse batch payload as JSON array: %s", err.Error())
+		}
+
+		for i := range raw {
+			items = append(items, raw[i])
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+		scanner.Buffer(make([]byte, 64*1024), len(trimmed)+1)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+
+			item := make([]byte, len(line))
+			copy(item, line)
+			items = append(items, item)
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, errors.Wrapf(ErrInvalidInput, "could not read NDJSON batch payload: %s", err.Error())
+		}
+	}
+
+	if len(items) == 0 {
+		return nil, ErrEmptyBatch
+	}
+
+	if len(items) > MaxBatchSize {
+		return nil, errors.Wrapf(ErrBatchTooLarge, "%d items given, max is %d", len(items), MaxBatchSize)
+	}
+
+	return items, nil
+}
@@ -0,0 +1,182 @@
+package receiver
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/flow"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeCache struct {
+	keys map[string]bool
+}
+
+func (c *fakeCache) Has(key string) (bool, error) {
+	return c.keys[key], nil
+}
+
+func (c *fakeCache) CreateKey(key string, ttl time.Duration) error {
+	c.keys[key] = true
+	return nil
+}
+
+func (c *fakeCache) Ping() error {
+	return nil
+}
+
+type fakeFlow struct {
+	flow.ActionFlow
+	sent    []*model.NewAction
+	updates []*model.UpdateAction
+}
+
+func (f *fakeFlow) SendNewAction(na *model.NewAction) error {
+	f.sent = append(f.sent, na)
+	return nil
+}
+
+func (f *fakeFlow) SendUpdateAction(ua *model.UpdateAction) error {
+	f.updates = append(f.updates, ua)
+	return nil
+}
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c fixedClock) CurrentTimestamp() int64 {
+	return c.now.Unix()
+}
+
+func (c fixedClock) CurrentTime() time.Time {
+	return c.now
+}
+
+type fixedUUID struct{}
+
+func (fixedUUID) Generate() string {
+	return "76502edbf207452eae7ec258271ee9aa"
+}
+
+func Test_splitBatch(t *testing.T) {
+	t.Run("json array", func(t *testing.T) {
+		items, err := splitBatch([]byte(` [{"name":"a"}, {"name":"b"}] `), false)
+		assert.NoError(t, err)
+		assert.Len(t, items, 2)
+		assert.Equal(t, `{"name":"a"}`, string(items[0]))
+		assert.Equal(t, `{"name":"b"}`, string(items[1]))
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		items, err := splitBatch([]byte("{\"name\":\"a\"}\n\n{\"name\":\"b\"}\r\n{\"name\":\"c\"}"), true)
+		assert.NoError(t, err)
+		assert.Len(t, items, 3)
+		assert.Equal(t, `{"name":"c"}`, string(items[2]))
+	})
+
+	t.Run("ndjson without content type", func(t *testing.T) {
+		items, err := splitBatch([]byte("{\"name\":\"a\"}\n{\"name\":\"b\"}"), false)
+		assert.NoError(t, err)
+		assert.Len(t, items, 2)
+	})
+
+	t.Run("malformed array", func(t *testing.T) {
+		_, err := splitBatch([]byte(`[{"name":"a"},`), false)
+		assert.Equal(t, ErrInvalidInput, errors.Cause(err))
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		_, err := splitBatch([]byte(`  `), false)
+		assert.Equal(t, ErrEmptyBatch, err)
+
+		_, err = splitBatch([]byte(`[]`), false)
+		assert.Equal(t, ErrEmptyBatch, err)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		var buf bytes.Buffer
+		for i := 0; i <= MaxBatchSize; i++ {
+			buf.WriteString("{}\n")
+		}
+
+		_, err := splitBatch(buf.Bytes(), true)
+		assert.Equal(t, ErrBatchTooLarge, errors.Cause(err))
+	})
+}
+
+func TestReceiver_ReceiveBatchForCreate(t *testing.T) {
+	valid := `{"actorService":"foo","targetService":"bar","name":"fooBarred","emittedAt":"2021-01-02 15:04:05"}`
+	invalid := `{"actorService":"","targetService":"bar","name":"fooBarred","emittedAt":"2021-01-02 15:04:05"}`
+	malformed := `{"actorService":"foo","emittedAt":12}`
+
+	c := &fakeCache{keys: make(map[string]bool)}
+	f := &fakeFlow{}
+	rc := New(logger.NewStdoutLogger(logger.Prod, "test"), fixedClock{now: time.Now()}, f, fixedUUID{}, c)
+
+	payload := valid + "\n" + invalid + "\n" + valid + "\n" + malformed
+	results, err := rc.ReceiveBatchForCreate(bytes.NewBufferString(payload), true, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.Len(t, results, 4)
+	assert.Equal(t, BatchItemAccepted, results[0].Status)
+	assert.NotNil(t, results[0].Reg)
+	assert.Equal(t, BatchItemInvalid, results[1].Status)
+	assert.Error(t, results[1].Err)
+	assert.Equal(t, BatchItemDuplicate, results[2].Status)
+	assert.Equal(t, BatchItemInvalid, results[3].Status)
+	assert.Len(t, f.sent, 1)
+}
+
+func TestReceiver_ReceiveBatchForCreate_ValidatesDelta(t *testing.T) {
+	valid := `{"actorService":"foo","targetService":"bar","name":"titleChanged","emittedAt":"2021-01-02 15:04:05",` +
+		`"delta":[{"propertyName":"title","currentPropertyType":"string","from":"foo","to":"bar"}]}`
+	mismatch := `{"actorService":"foo","targetService":"bar","name":"viewsChanged","emittedAt":"2021-01-02 15:04:05",` +
+		`"delta":[{"propertyName":"views","currentPropertyType":"integer","from":1,"to":"many"}]}`
+
+	f := &fakeFlow{}
+	rc := New(logger.NewStdoutLogger(logger.Prod, "test"), fixedClock{now: time.Now()}, f, fixedUUID{}, &fakeCache{keys: make(map[string]bool)})
+
+	results, err := rc.ReceiveBatchForCreate(bytes.NewBufferString(valid+"\n"+mismatch), true, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, BatchItemAccepted, results[0].Status)
+	assert.Equal(t, BatchItemInvalid, results[1].Status)
+	if assert.Len(t, f.sent, 1) {
+		assert.Equal(t, model.Delta{
+			{PropertyName: "title", CurrentPropertyType: model.StringProperty, From: "foo", To: "bar"},
+		}, f.sent[0].Delta)
+	}
+}
+
+func TestReceiver_ReceiveBatchForCreate_AuthorizesActorService(t *testing.T) {
+	allowed := `{"actorService":"foo","targetService":"bar","name":"fooBarred","emittedAt":"2021-01-02 15:04:05"}`
+	forbidden := `{"actorService":"baz","targetService":"bar","name":"bazBarred","emittedAt":"2021-01-02 15:04:05"}`
+
+	f := &fakeFlow{}
+	c := &fakeCache{keys: make(map[string]bool)}
+	rc := New(logger.NewStdoutLogger(logger.Prod, "test"), fixedClock{now: time.Now()}, f, fixedUUID{}, c)
+
+	key := &model.APIKey{Services: []model.Microservice{{Name: "foo"}}}
+
+	results, err := rc.ReceiveBatchForCreate(bytes.NewBufferString(allowed+"\n"+forbidden), true, key)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, BatchItemAccepted, results[0].Status)
+	assert.Equal(t, BatchItemForbidden, results[1].Status)
+	assert.Equal(t, ErrServiceNotAllowed, errors.Cause(results[1].Err))
+	assert.Len(t, f.sent, 1)
+
+	// forbidden action is not remembered as received
+	assert.Len(t, c.keys, 1)
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"github.com/denismitr/auditbase/internal/cache"
 	"github.com/denismitr/auditbase/internal/flow"
+	"github.com/denismitr/auditbase/internal/metrics"
 	"github.com/denismitr/auditbase/internal/model"
 	"github.com/denismitr/auditbase/internal/utils"
 	"github.com/denismitr/auditbase/internal/utils/clock"
@@ -20,6 +21,13 @@ import (
 var ErrInvalidInput = errors.New("invalid input")
 var ErrActionAlreadyProcessed = errors.New("action already processed")
 var ErrDataPipelineFailed = errors.New("data pipelined could not accept the new action")
+var ErrServiceNotAllowed = errors.New("actor service is not allowed for the client")
+
+// Authorizer - decides whether the client may report actions
+// on behalf of the actor service, nil authorizer allows any service
+type Authorizer interface {
+	Allows(service string) bool
+}
 
 type Receiver struct {
 	lg    logger.Logger
@@ -45,7 +53,19 @@ type Reg struct {
 	RegisteredAt time.Time
 }
 
-func (rc *Receiver) ReceiveOneForUpdate(r io.Reader) (*Reg, error) {
+const (
+	createKind = "create"
+	updateKind = "update"
+	batchKind  = "batch"
+)
+
+// ReceiveOneForUpdate - the client is authorized for the actor service stated by the update,
+// whether the action was reported by that service is checked when the update is consumed
+func (rc *Receiver) ReceiveOneForUpdate(r io.Reader, auth Authorizer) (reg *Reg, err error) {
+	defer func() {
+		metrics.ReceivedActions.WithLabelValues(updateKind, string(receiveStatus(err))).Inc()
+	}()
+
 	b, err := readBytes(r)
 	if err != nil {
 		return nil, err
@@ -67,6 +87,10 @@ func (rc *Receiver) ReceiveOneForUpdate(r io.Reader) (*Reg, error) {
 		return nil, err
 	}
 
+	if auth != nil && !auth.Allows(updateAction.ActorService) {
+		return nil, errors.Wrapf(ErrServiceNotAllowed, "actor service [%s]", updateAction.ActorService)
+	}
+
 	if err := rc.c.CreateKey(hash, 5*time.Minute); err != nil {
 		rc.lg.Error(errors.Wrap(err, "receiver cache failed"))
 	}
@@ -82,12 +106,22 @@ func (rc *Receiver) ReceiveOneForUpdate(r io.Reader) (*Reg, error) {
 	}, nil
 }
 
-func (rc *Receiver) ReceiveOneForCreate(r io.Reader) (*Reg, error) {
+func (rc *Receiver) ReceiveOneForCreate(r io.Reader, auth Authorizer) (reg *Reg, err error) {
+	defer func() {
+		metrics.ReceivedActions.WithLabelValues(createKind, string(receiveStatus(err))).Inc()
+	}()
+
 	b, err := readBytes(r)
 	if err != nil {
 		return nil, err
 	}
 
+	return rc.receiveNewAction(b, auth)
+}
+
+// receiveNewAction - validates, authorizes, hashes and deduplicates a single new action payload
+// and sends it into the action flow
+func (rc *Receiver) receiveNewAction(b []byte, auth Authorizer) (*Reg, error) {
 	hash := createHash(b)
 
 	found, err := rc.c.Has(hash)
@@ -104,6 +138,10 @@ func (rc *Receiver) ReceiveOneForCreate(r io.Reader) (*Reg, error) {
 		return nil, err
 	}
 
+	if auth != nil && !auth.Allows(newAction.ActorService) {
+		return nil, errors.Wrapf(ErrServiceNotAllowed, "actor service [%s]", newAction.ActorService)
+	}
+
 	if err := rc.c.CreateKey(hash, 5*time.Minute); err != nil {
 		rc.lg.Error(errors.Wrap(err, "receiver cache failed"))
 	}
@@ -122,7 +160,7 @@ func (rc *Receiver) ReceiveOneForCreate(r io.Reader) (*Reg, error) {
 func readBytes(r io.Reader) ([]byte, error) {
 	b, err := ioutil.ReadAll(r)
 	if err != nil {
-		return nil, errors.Wrapf(ErrInvalidInput, "could not read incoming action payload", err.Error())
+		return nil, errors.Wrapf(ErrInvalidInput, "could not read incoming action payload: %s", err.Error())
 	}
 
 	if b == nil || len(b) == 0 {
@@ -134,7 +172,7 @@ func readBytes(r io.Reader) ([]byte, error) {
 func (rc *Receiver) createNewAction(in []byte, hash string) (*model.NewAction, error) {
 	newAction := new(model.NewAction)
 	if err := json.Unmarshal(in, newAction); err != nil {
-		return nil, errors.Wrapf(ErrInvalidInput, "could not parse incoming action payload", err.Error())
+		return nil, errors.Wrapf(ErrInvalidInput, "could not parse incoming action payload: %s", err.Error())
 	}
 
 	if errorBag := newAction.Validate(); errorBag.NotEmpty() {
@@ -153,7 +191,7 @@ func (rc *Receiver) createNewAction(in []byte, hash string) (*model.NewAction, e
 func (rc *Receiver) createUpdateAction(in []byte, hash string) (*model.UpdateAction, error) {
 	updateAction := new(model.UpdateAction)
 	if err := json.Unmarshal(in, updateAction); err != nil {
-		return nil, errors.Wrapf(ErrInvalidInput, "could not parse incoming action payload", err.Error())
+		return nil, errors.Wrapf(ErrInvalidInput, "could not parse incoming action payload: %s", err.Error())
 	}
 
 	if errorBag := updateAction.Validate(); errorBag.NotEmpty() {
@@ -0,0 +1,70 @@
+package receiver
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/metrics"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReceiver_ReceiveOneForCreate_CountsReceivedActions(t *testing.T) {
+	valid := `{"actorService":"foo","targetService":"bar","name":"fooBarred","emittedAt":"2021-01-02 15:04:05"}`
+
+	rc := New(
+		logger.NewStdoutLogger(logger.Prod, "test"),
+		fixedClock{now: time.Now()},
+		&fakeFlow{},
+		fixedUUID{},
+		&fakeCache{keys: make(map[string]bool)},
+	)
+
+	count := func(status BatchItemStatus) float64 {
+		return testutil.ToFloat64(metrics.ReceivedActions.WithLabelValues(createKind, string(status)))
+	}
+
+	accepted, duplicate, invalid := count(BatchItemAccepted), count(BatchItemDuplicate), count(BatchItemInvalid)
+
+	_, err := rc.ReceiveOneForCreate(bytes.NewBufferString(valid), nil)
+	assert.NoError(t, err)
+
+	_, err = rc.ReceiveOneForCreate(bytes.NewBufferString(valid), nil)
+	assert.Equal(t, ErrActionAlreadyProcessed, err)
+
+	_, err = rc.ReceiveOneForCreate(bytes.NewBufferString(""), nil)
+	assert.Error(t, err)
+
+	assert.Equal(t, accepted+1, count(BatchItemAccepted))
+	assert.Equal(t, duplicate+1, count(BatchItemDuplicate))
+	assert.Equal(t, invalid+1, count(BatchItemInvalid))
+}
+
+func TestReceiver_ReceiveOneForUpdate_AuthorizesActorService(t *testing.T) {
+	f := &fakeFlow{}
+	c := &fakeCache{keys: make(map[string]bool)}
+	rc := New(logger.NewStdoutLogger(logger.Prod, "test"), fixedClock{now: time.Now()}, f, fixedUUID{}, c)
+
+	key := &model.APIKey{Services: []model.Microservice{{Name: "foo"}}}
+
+	for _, body := range []string{
+		`{"uid":"76502edbf207452eae7ec258271ee9aa","status":1,"actorService":"bar"}`,
+		`{"uid":"76502edbf207452eae7ec258271ee9aa","status":1}`,
+	} {
+		_, err := rc.ReceiveOneForUpdate(bytes.NewBufferString(body), key)
+		assert.Equal(t, ErrServiceNotAllowed, errors.Cause(err), body)
+	}
+
+	assert.Empty(t, f.updates)
+	assert.Empty(t, c.keys)
+
+	_, err := rc.ReceiveOneForUpdate(bytes.NewBufferString(`{"uid":"76502edbf207452eae7ec258271ee9aa","status":1,"actorService":"foo"}`), key)
+	assert.NoError(t, err)
+	if assert.Len(t, f.updates, 1) {
+		assert.Equal(t, "foo", f.updates[0].ActorService)
+	}
+}
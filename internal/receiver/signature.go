@@ -0,0 +1,142 @@
+package receiver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/clock"
+	"github.com/pkg/errors"
+)
+
+// Headers of a signed action submission
+const (
+	HeaderSignatureService   = "X-Auditbase-Service"
+	HeaderSignatureTimestamp = "X-Auditbase-Timestamp"
+	HeaderSignature          = "X-Auditbase-Signature"
+)
+
+const signatureScheme = "sha256="
+
+// DefaultSignatureTolerance - how far the signature timestamp may be from the receiver clock
+const DefaultSignatureTolerance = 5 * time.Minute
+
+var ErrSignatureMissing = errors.New("request signature is missing")
+var ErrSignatureInvalid = errors.New("request signature is invalid")
+var ErrSignatureExpired = errors.New("request signature timestamp is outside of the allowed window")
+
+// SigningSecretStore - provides secrets microservices sign their submissions with
+type SigningSecretStore interface {
+	SigningSecrets(ctx context.Context, service string) (*model.SigningSecrets, error)
+}
+
+// Sign - signature of the body the service sends at the given unix timestamp,
+// the timestamp is signed along with the body, so that it cannot be replaced
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return signatureScheme + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignatureVerifier - verifies HMAC signatures of action submissions,
+// secrets are kept in memory for ttl, so a rotated secret reaches
+// the receiver no later than ttl after rotation
+type SignatureVerifier struct {
+	secrets   SigningSecretStore
+	clock     clock.Clock
+	tolerance time.Duration
+	ttl       time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedSecrets
+}
+
+type cachedSecrets struct {
+	secrets   *model.SigningSecrets
+	expiresAt time.Time
+}
+
+func NewSignatureVerifier(
+	secrets SigningSecretStore,
+	clock clock.Clock,
+	tolerance, ttl time.Duration,
+) *SignatureVerifier {
+	return &SignatureVerifier{
+		secrets:   secrets,
+		clock:     clock,
+		tolerance: tolerance,
+		ttl:       ttl,
+		cache:     make(map[string]cachedSecrets),
+	}
+}
+
+// Verify - checks that the body was signed by the service with its current secret,
+// or the previous one during the grace window, at a time close enough to now.
+// Identical bodies replayed within the window are caught by deduplication
+func (v *SignatureVerifier) Verify(ctx context.Context, service, timestamp, signature string, body []byte) error {
+	if service == "" || timestamp == "" || signature == "" {
+		return ErrSignatureMissing
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.Wrapf(ErrSignatureInvalid, "timestamp [%s] is not a unix timestamp", timestamp)
+	}
+
+	now := v.clock.CurrentTime()
+	if skew := now.Sub(time.Unix(ts, 0)); skew > v.tolerance || skew < -v.tolerance {
+		return errors.Wrapf(ErrSignatureExpired, "timestamp is %s away from now", skew.Round(time.Second))
+	}
+
+	if !strings.HasPrefix(signature, signatureScheme) {
+		return errors.Wrapf(ErrSignatureInvalid, "signature must start with %s", signatureScheme)
+	}
+
+	secrets, err := v.lookup(ctx, service, now)
+	if err != nil {
+		if errors.Cause(err) == db.ErrNotFound {
+			return errors.Wrapf(ErrSignatureInvalid, "service [%s] has no signing secret", service)
+		}
+
+		return err
+	}
+
+	for _, secret := range secrets.Accepted(now) {
+		if hmac.Equal([]byte(Sign(secret, ts, body)), []byte(signature)) {
+			return nil
+		}
+	}
+
+	return ErrSignatureInvalid
+}
+
+func (v *SignatureVerifier) lookup(ctx context.Context, service string, now time.Time) (*model.SigningSecrets, error) {
+	v.mu.Lock()
+	entry, ok := v.cache[service]
+	v.mu.Unlock()
+
+	if ok && now.Before(entry.expiresAt) {
+		return entry.secrets, nil
+	}
+
+	secrets, err := v.secrets.SigningSecrets(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	v.cache[service] = cachedSecrets{secrets: secrets, expiresAt: now.Add(v.ttl)}
+	v.mu.Unlock()
+
+	return secrets, nil
+}
@@ -0,0 +1,109 @@
+package receiver
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSecretStore struct {
+	secrets map[string]*model.SigningSecrets
+	lookups int
+}
+
+func (s *fakeSecretStore) SigningSecrets(ctx context.Context, service string) (*model.SigningSecrets, error) {
+	s.lookups++
+
+	secrets, ok := s.secrets[service]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+
+	return secrets, nil
+}
+
+func TestSignatureVerifier_Verify(t *testing.T) {
+	now := time.Date(2021, 4, 1, 10, 0, 0, 0, time.UTC)
+	body := []byte(`{"name":"created"}`)
+
+	store := &fakeSecretStore{secrets: map[string]*model.SigningSecrets{
+		"billing": {
+			Secret:            "current",
+			PreviousSecret:    "previous",
+			PreviousExpiresAt: now.Add(time.Hour),
+		},
+	}}
+
+	ts := func(at time.Time) string {
+		return strconv.FormatInt(at.Unix(), 10)
+	}
+
+	tt := []struct {
+		name      string
+		at        time.Time
+		service   string
+		timestamp string
+		signature string
+		err       error
+	}{
+		{name: "current secret", at: now, service: "billing", timestamp: ts(now), signature: Sign("current", now.Unix(), body)},
+		{name: "previous secret within grace", at: now, service: "billing", timestamp: ts(now), signature: Sign("previous", now.Unix(), body)},
+		{
+			name:      "previous secret after grace",
+			at:        now.Add(2 * time.Hour),
+			service:   "billing",
+			timestamp: ts(now.Add(2 * time.Hour)),
+			signature: Sign("previous", now.Add(2*time.Hour).Unix(), body),
+			err:       ErrSignatureInvalid,
+		},
+		{
+			name:      "stale timestamp",
+			at:        now,
+			service:   "billing",
+			timestamp: ts(now.Add(-10 * time.Minute)),
+			signature: Sign("current", now.Add(-10*time.Minute).Unix(), body),
+			err:       ErrSignatureExpired,
+		},
+		{
+			name:      "timestamp not signed",
+			at:        now,
+			service:   "billing",
+			timestamp: ts(now.Add(time.Minute)),
+			signature: Sign("current", now.Unix(), body),
+			err:       ErrSignatureInvalid,
+		},
+		{name: "wrong secret", at: now, service: "billing", timestamp: ts(now), signature: Sign("other", now.Unix(), body), err: ErrSignatureInvalid},
+		{name: "unknown service", at: now, service: "orders", timestamp: ts(now), signature: Sign("current", now.Unix(), body), err: ErrSignatureInvalid},
+		{name: "no signature", at: now, service: "billing", timestamp: ts(now), err: ErrSignatureMissing},
+		{name: "malformed timestamp", at: now, service: "billing", timestamp: "yesterday", signature: Sign("current", now.Unix(), body), err: ErrSignatureInvalid},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			v := NewSignatureVerifier(store, fixedClock{now: tc.at}, DefaultSignatureTolerance, time.Minute)
+
+			err := v.Verify(context.Background(), tc.service, tc.timestamp, tc.signature, body)
+			assert.Equal(t, tc.err, errors.Cause(err))
+		})
+	}
+}
+
+func TestSignatureVerifier_CachesSecrets(t *testing.T) {
+	now := time.Date(2021, 4, 1, 10, 0, 0, 0, time.UTC)
+	body := []byte(`{}`)
+	store := &fakeSecretStore{secrets: map[string]*model.SigningSecrets{"billing": {Secret: "current"}}}
+	v := NewSignatureVerifier(store, fixedClock{now: now}, DefaultSignatureTolerance, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		err := v.Verify(context.Background(), "billing", strconv.FormatInt(now.Unix(), 10), Sign("current", now.Unix(), body), body)
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, 1, store.lookups)
+}
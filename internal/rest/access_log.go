@@ -0,0 +1,138 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/service"
+	"github.com/denismitr/auditbase/internal/utils/clock"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/labstack/echo"
+)
+
+const (
+	accessLogContextKey      = "accessLog"
+	accessLogFlushContextKey = "accessLogFlush"
+	// accessLogMaxRecords - most records an entry lists, a stream returning more is logged
+	// in several entries
+	accessLogMaxRecords = 1000
+)
+
+// accessLog - records who read what through the back-office API,
+// handlers report the records they returned with accessed
+func accessLog(lg logger.Logger, log service.AccessLogService, clock clock.Clock) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(rCtx echo.Context) error {
+			req := rCtx.Request()
+			if req.Method != http.MethodGet {
+				return next(rCtx)
+			}
+
+			record := func(entry *model.AccessLogEntry) {
+				// claims are only known after authentication, which runs further down the chain
+				if c := claims(rCtx); c != nil {
+					entry.Principal = c.Subject
+					entry.Role = string(c.Role)
+				}
+
+				entry.Route = rCtx.Path()
+				entry.Status = rCtx.Response().Status
+
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				defer cancel()
+
+				if err := log.Record(ctx, entry); err != nil {
+					lg.Error(err)
+				}
+			}
+
+			entry := &model.AccessLogEntry{
+				Principal:   model.AnonymousPrincipal,
+				Method:      req.Method,
+				Path:        req.URL.Path,
+				Filters:     make(map[string]string),
+				RequestedAt: model.JSONTime{Time: clock.CurrentTime()},
+			}
+
+			for k, v := range req.URL.Query() {
+				entry.Filters[k] = strings.Join(v, ",")
+			}
+
+			rCtx.Set(accessLogContextKey, entry)
+
+			flushed := false
+			rCtx.Set(accessLogFlushContextKey, func() {
+				full := rCtx.Get(accessLogContextKey).(*model.AccessLogEntry)
+				record(full)
+
+				// the rest of the response goes to an entry of its own
+				rest := *full
+				rest.ID, rest.ActionIDs, rest.EntityIDs = 0, nil, nil
+				rCtx.Set(accessLogContextKey, &rest)
+				flushed = true
+			})
+
+			err := next(rCtx)
+
+			last := rCtx.Get(accessLogContextKey).(*model.AccessLogEntry)
+			if !flushed || len(last.ActionIDs)+len(last.EntityIDs) > 0 {
+				record(last)
+			}
+
+			return err
+		}
+	}
+}
+
+// accessed - reports records returned by the handler to the access log, an entry listing
+// accessLogMaxRecords of them is recorded right away
+func accessed(rCtx echo.Context, kind model.AccessedRecord, IDs ...model.ID) {
+	entry, ok := rCtx.Get(accessLogContextKey).(*model.AccessLogEntry)
+	if !ok {
+		return
+	}
+
+	entry.Add(kind, IDs...)
+
+	if len(entry.ActionIDs)+len(entry.EntityIDs) >= accessLogMaxRecords {
+		if flush, ok := rCtx.Get(accessLogFlushContextKey).(func()); ok {
+			flush()
+		}
+	}
+}
+
+type accessLogController struct {
+	lg  logger.Logger
+	log service.AccessLogService
+}
+
+func newAccessLogController(lg logger.Logger, log service.AccessLogService) *accessLogController {
+	return &accessLogController{
+		lg:  lg,
+		log: log,
+	}
+}
+
+func (ac *accessLogController) index(rCtx echo.Context) error {
+	q := rCtx.Request().URL.Query()
+
+	f := createFilter(q, []string{"principal", "method", "route", "actionId", "entityId"})
+	c := createCursor(q, 50, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	entries, err := ac.log.Select(ctx, c, f)
+	if err != nil {
+		ac.lg.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	return rCtx.JSON(200, collectionResource{
+		Data: entries.Items,
+		Meta: entries.Meta,
+	})
+}
@@ -0,0 +1,120 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/auth"
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/clock"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAccessLog struct {
+	entries []*model.AccessLogEntry
+}
+
+func (f *fakeAccessLog) Record(ctx context.Context, entry *model.AccessLogEntry) error {
+	f.entries = append(f.entries, entry)
+	return nil
+}
+
+func (f *fakeAccessLog) Select(ctx context.Context, c *db.Cursor, filter *db.Filter) (*model.AccessLogCollection, error) {
+	return &model.AccessLogCollection{}, nil
+}
+
+func TestAccessLog(t *testing.T) {
+	tokens, err := auth.NewTokens([]byte(strings.Repeat("k", auth.MinKeyLength)), clock.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := tokens.Issue("alice", auth.RoleAuditor, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := &fakeAccessLog{}
+
+	e := echo.New()
+	api := e.Group("/api/v1")
+	api.Use(accessLog(logger.NewStdoutLogger(logger.Prod, "test"), log, clock.New()), tokenAuth(tokens))
+
+	api.GET("/actions/:id", func(rCtx echo.Context) error {
+		accessed(rCtx, model.AccessedAction, 5)
+		accessed(rCtx, model.AccessedEntity, 7, 8)
+		return rCtx.NoContent(http.StatusOK)
+	})
+	api.PUT("/microservices/:id", func(rCtx echo.Context) error {
+		return rCtx.NoContent(http.StatusOK)
+	})
+
+	serve := func(method, target, authorization string) int {
+		req := httptest.NewRequest(method, target, nil)
+		if authorization != "" {
+			req.Header.Set(echo.HeaderAuthorization, authorization)
+		}
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/v1/actions/5?depth=2", "Bearer "+token))
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/v1/actions/6", ""))
+	assert.Equal(t, http.StatusOK, serve(http.MethodPut, "/api/v1/microservices/1", "Bearer "+token))
+
+	if !assert.Len(t, log.entries, 2) {
+		return
+	}
+
+	read := log.entries[0]
+	assert.Equal(t, "alice", read.Principal)
+	assert.Equal(t, "auditor", read.Role)
+	assert.Equal(t, "/api/v1/actions/:id", read.Route)
+	assert.Equal(t, "/api/v1/actions/5", read.Path)
+	assert.Equal(t, map[string]string{"depth": "2"}, read.Filters)
+	assert.Equal(t, http.StatusOK, read.Status)
+	assert.Equal(t, []model.ID{5}, read.ActionIDs)
+	assert.Equal(t, []model.ID{7, 8}, read.EntityIDs)
+
+	rejected := log.entries[1]
+	assert.Equal(t, model.AnonymousPrincipal, rejected.Principal)
+	assert.Equal(t, http.StatusUnauthorized, rejected.Status)
+	assert.Empty(t, rejected.ActionIDs)
+}
+
+func TestAccessLog_LongResponse(t *testing.T) {
+	log := &fakeAccessLog{}
+
+	e := echo.New()
+	e.Use(accessLog(logger.NewStdoutLogger(logger.Prod, "test"), log, clock.New()))
+	e.GET("/api/v1/actions/stream", func(rCtx echo.Context) error {
+		rCtx.Response().WriteHeader(http.StatusOK)
+		for i := 1; i <= accessLogMaxRecords+1; i++ {
+			accessed(rCtx, model.AccessedAction, model.ID(i))
+		}
+
+		return nil
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/actions/stream?name=invoicePaid", nil))
+
+	// a stream is logged in entries of a bounded size as it goes
+	if assert.Len(t, log.entries, 2) {
+		assert.Len(t, log.entries[0].ActionIDs, accessLogMaxRecords)
+		assert.Equal(t, []model.ID{accessLogMaxRecords + 1}, log.entries[1].ActionIDs)
+		assert.Equal(t, "/api/v1/actions/stream", log.entries[1].Route)
+		assert.Equal(t, map[string]string{"name": "invoicePaid"}, log.entries[1].Filters)
+		assert.Equal(t, http.StatusOK, log.entries[0].Status)
+	}
+}
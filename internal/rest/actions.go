@@ -3,11 +3,16 @@ package rest
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"github.com/denismitr/auditbase/internal/db"
 	"github.com/denismitr/auditbase/internal/flow"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/search"
 	"github.com/denismitr/auditbase/internal/service"
 	"github.com/denismitr/auditbase/internal/utils/clock"
 	"github.com/denismitr/auditbase/internal/utils/logger"
 	"github.com/labstack/echo"
+	"github.com/pkg/errors"
 	"time"
 )
 
@@ -35,24 +40,79 @@ func newActionsController(
 func (ec *actionsController) index(rCtx echo.Context) error {
 	q := rCtx.Request().URL.Query()
 
-	f := createFilter(q, []string{
-		"name",
-		"parentUid",
-		"status",
-		"actorEntityId",
-		"targetEntityId",
-	})
+	f, err := createActionFilter(q)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	c, err := keysetCursor(q, 25, []string{"name","emittedAt","registeredAt","status","actorEntityId","targetEntityId"})
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
 
-	c := createCursor(q, 25, []string{"name","emittedAt","registeredAt","status","actorEntityId","targetEntityId"})
+	// users scoped to some services only see actions of those services
+	f.ByServices(scope(rCtx))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
 	defer cancel()
 
 	actions, err := ec.actions.Select(ctx, c, f)
 	if err != nil {
+		if errors.Cause(err) == db.ErrInvalidQueryInput {
+			return rCtx.JSON(badRequest(err))
+		}
+
+		return rCtx.JSON(internalError(err))
+	}
+
+	for i := range actions.Items {
+		accessed(rCtx, model.AccessedAction, actions.Items[i].ID)
+	}
+
+	return rCtx.JSON(200, collectionResource{
+		Data: actions.Items,
+		Meta: actions.Meta,
+	})
+}
+
+// search - actions matching the search query of the q parameter, narrowed by the filters of the index
+func (ec *actionsController) search(rCtx echo.Context) error {
+	q := rCtx.Request().URL.Query()
+
+	sq, err := search.Parse(q.Get("q"))
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	f, err := createActionFilter(q)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	c, err := keysetCursor(q, 25, []string{"name","emittedAt","registeredAt","status","actorEntityId","targetEntityId"})
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	// users scoped to some services only find actions of those services
+	f.ByServices(scope(rCtx))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+	defer cancel()
+
+	actions, err := ec.actions.Search(ctx, sq, c, f)
+	if err != nil {
+		if errors.Cause(err) == db.ErrInvalidQueryInput {
+			return rCtx.JSON(badRequest(err))
+		}
+
 		return rCtx.JSON(internalError(err))
 	}
 
+	for i := range actions.Items {
+		accessed(rCtx, model.AccessedAction, actions.Items[i].ID)
+	}
+
 	return rCtx.JSON(200, collectionResource{
 		Data: actions.Items,
 		Meta: actions.Meta,
@@ -68,11 +128,28 @@ func (ec *actionsController) show(rCtx echo.Context) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
 	defer cancel()
 
-	action, err := ec.actions.FirstByID(ctx, ID)
+	var action *model.Action
+	if services := scope(rCtx); services != nil {
+		action, err = ec.actions.FirstVisibleByID(ctx, ID, services)
+	} else {
+		action, err = ec.actions.FirstByID(ctx, ID)
+	}
+
 	if err != nil {
 		return rCtx.JSON(notFound(err))
 	}
 
+	accessed(rCtx, model.AccessedAction, action.ID)
+	if action.Parent != nil {
+		accessed(rCtx, model.AccessedAction, action.Parent.ID)
+	}
+
+	for _, e := range []*model.Entity{action.Actor, action.Target} {
+		if e != nil {
+			accessed(rCtx, model.AccessedEntity, e.ID)
+		}
+	}
+
 	return rCtx.JSON(200, itemResource{
 		Data: action,
 	})
@@ -82,9 +159,22 @@ func (ec *actionsController) count(rCtx echo.Context) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
 	defer cancel()
 
-	count, err := ec.actions.Count(ctx)
-	if err != nil {
-		return rCtx.JSON(notFound(err))
+	var count int
+	if services := scope(rCtx); services != nil {
+		c := &db.Cursor{Page: 1, PerPage: 1, Sort: db.NewSort(nil)}
+		actions, err := ec.actions.Select(ctx, c, db.NewFilter(nil).ByServices(services))
+		if err != nil {
+			return rCtx.JSON(internalError(err))
+		}
+
+		if actions.Meta.Total != nil {
+			count = *actions.Meta.Total
+		}
+	} else {
+		var err error
+		if count, err = ec.actions.Count(ctx); err != nil {
+			return rCtx.JSON(notFound(err))
+		}
 	}
 
 	return rCtx.JSON(200, itemResource{
@@ -92,6 +182,113 @@ func (ec *actionsController) count(rCtx echo.Context) error {
 	})
 }
 
+// stats - numbers of the actions matching the filters of the index per interval and group,
+// over the last day unless from and to are given
+func (ec *actionsController) stats(rCtx echo.Context) error {
+	q := rCtx.Request().URL.Query()
+
+	sq := &model.ActionStatsQuery{
+		To:       ec.clock.CurrentTime().UTC(),
+		Interval: model.HourInterval,
+		GroupBy:  model.StatsGroup(q.Get("groupBy")),
+	}
+
+	if v := q.Get("interval"); v != "" {
+		sq.Interval = model.StatsInterval(v)
+	}
+
+	if v := q.Get("to"); v != "" {
+		to, err := parseTimeRangeParam(v, true)
+		if err != nil {
+			return rCtx.JSON(badRequest(err))
+		}
+
+		sq.To = to
+	}
+
+	sq.From = sq.To.Add(-24 * time.Hour)
+	if v := q.Get("from"); v != "" {
+		from, err := parseTimeRangeParam(v, true)
+		if err != nil {
+			return rCtx.JSON(badRequest(err))
+		}
+
+		sq.From = from
+	}
+
+	if errs := sq.Validate(); errs.NotEmpty() {
+		return rCtx.JSON(validationFailed(errs.All()...))
+	}
+
+	f, err := createActionFilter(q)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	// users scoped to some services only count actions of those services
+	f.ByServices(scope(rCtx))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+	defer cancel()
+
+	stats, err := ec.actions.Stats(ctx, sq, f)
+	if err != nil {
+		if errors.Cause(err) == db.ErrInvalidQueryInput {
+			return rCtx.JSON(badRequest(err))
+		}
+
+		return rCtx.JSON(internalError(err))
+	}
+
+	return rCtx.JSON(200, itemResource{
+		Data: stats,
+	})
+}
+
+func (ec *actionsController) tree(rCtx echo.Context) error {
+	ID, err := extractIDParamFrom(rCtx)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	depth, err := intQueryParam(rCtx, "depth", model.DefaultTreeDepth, model.MaxTreeDepth)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	fanOut, err := intQueryParam(rCtx, "fanOut", model.DefaultTreeFanOut, model.MaxTreeFanOut)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+	defer cancel()
+
+	opts := model.TreeOptions{Depth: depth, FanOut: fanOut, Services: scope(rCtx)}
+
+	tree, err := ec.actions.Tree(ctx, ID, opts)
+	if err != nil {
+		if errors.Cause(err) == db.ErrNotFound {
+			return rCtx.JSON(notFound(errors.Errorf("action with ID %d not found", ID)))
+		}
+
+		ec.logger.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	for i := range tree.Ancestors {
+		accessed(rCtx, model.AccessedAction, tree.Ancestors[i].ID)
+	}
+
+	tree.Root.Walk(func(n *model.ActionNode) {
+		accessed(rCtx, model.AccessedAction, n.Action.ID)
+	})
+
+	return rCtx.JSON(200, itemResource{
+		Data: tree,
+	})
+}
+
 //func (ec *actionsController) inspect(ctx echo.Context) error {
 //	state, err := ec.af.Inspect()
 //	if err != nil {
@@ -115,19 +312,28 @@ func (ec *actionsController) count(rCtx echo.Context) error {
 //	return ctx.JSON(200, i.ToJSON())
 //}
 
-//func (ec *actionsController) delete(ctx echo.Context) error {
-//	ID := extractIDParamFrom(ctx)
-//	if errBag := ID.Validate(); errBag.NotEmpty() {
-//		return ctx.JSON(validationFailed(errBag.All()...))
-//	}
-//
-//	err := ec.actions.Delete(ID)
-//	if err != nil {
-//		return ctx.JSON(notFound(err))
-//	}
-//
-//	return ctx.JSON(204, nil)
-//}
+// delete - the action is replaced by a tombstone, deleting it does not break the hash chain
+func (ec *actionsController) delete(rCtx echo.Context) error {
+	ID, err := extractIDParamFrom(rCtx)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := ec.actions.Delete(ctx, ID, scope(rCtx), ec.clock.CurrentTime()); err != nil {
+		switch errors.Cause(err) {
+		case db.ErrNotFound, db.ErrActionNotFound:
+			return rCtx.JSON(notFound(errors.Errorf("action with ID %d not found", ID)))
+		}
+
+		ec.logger.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	return rCtx.NoContent(http.StatusNoContent)
+}
 
 func hashKey(hash string) string {
 	return fmt.Sprintf("hash_key_%s", hash)
@@ -0,0 +1,115 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/service"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+type apiKeysController struct {
+	lg   logger.Logger
+	keys service.APIKeyService
+}
+
+func newAPIKeysController(lg logger.Logger, keys service.APIKeyService) *apiKeysController {
+	return &apiKeysController{
+		lg:   lg,
+		keys: keys,
+	}
+}
+
+func (kc *apiKeysController) index(rCtx echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	keys, err := kc.keys.Select(ctx)
+	if err != nil {
+		kc.lg.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	return rCtx.JSON(200, itemResource{
+		Data: keys,
+	})
+}
+
+// create - issues a new key, the key itself is only returned in this response
+func (kc *apiKeysController) create(rCtx echo.Context) error {
+	nk := new(model.NewAPIKey)
+	if err := rCtx.Bind(nk); err != nil {
+		return rCtx.JSON(badRequest(errors.Wrap(err, "could not parse request payload")))
+	}
+
+	if errs := nk.Validate(); errs.NotEmpty() {
+		return rCtx.JSON(validationFailed(errs.All()...))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	issued, err := kc.keys.Issue(ctx, nk)
+	if err != nil {
+		kc.lg.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	return rCtx.JSON(201, itemResource{
+		Data: issued,
+	})
+}
+
+// rotate - replaces the key, the new one is only returned in this response
+func (kc *apiKeysController) rotate(rCtx echo.Context) error {
+	ID, err := extractIDParamFrom(rCtx)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	issued, err := kc.keys.Rotate(ctx, ID)
+	if err != nil {
+		return rCtx.JSON(kc.apiKeyError(ID, err))
+	}
+
+	return rCtx.JSON(200, itemResource{
+		Data: issued,
+	})
+}
+
+func (kc *apiKeysController) revoke(rCtx echo.Context) error {
+	ID, err := extractIDParamFrom(rCtx)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := kc.keys.Revoke(ctx, ID); err != nil {
+		return rCtx.JSON(kc.apiKeyError(ID, err))
+	}
+
+	return rCtx.NoContent(http.StatusNoContent)
+}
+
+func (kc *apiKeysController) apiKeyError(ID model.ID, err error) (int, *errorResponse) {
+	switch errors.Cause(err) {
+	case db.ErrNotFound:
+		return notFound(errors.Errorf("api key with ID %d not found", ID))
+	case model.ErrAPIKeyRevoked:
+		return badRequest(err)
+	}
+
+	kc.lg.Error(err)
+
+	return internalError(err)
+}
@@ -1,7 +1,9 @@
 package rest
 
 import (
+	"github.com/denismitr/auditbase/internal/auth"
 	"github.com/denismitr/auditbase/internal/flow"
+	"github.com/denismitr/auditbase/internal/health"
 	"github.com/denismitr/auditbase/internal/service"
 	"github.com/denismitr/auditbase/internal/utils/clock"
 	"github.com/denismitr/auditbase/internal/utils/logger"
@@ -13,6 +15,13 @@ type BackOfficeServices struct {
 	Microservices service.MicroserviceService
 	Actions       service.ActionService
 	Entities      service.EntityService
+	Integrity     service.IntegrityService
+	APIKeys       service.APIKeyService
+	Signing       service.SigningService
+	AccessLog     service.AccessLogService
+	Retention     service.RetentionService
+	Webhooks      service.WebhookService
+	Timeouts      service.TimeoutService
 }
 
 func BackOfficeAPI(
@@ -21,6 +30,8 @@ func BackOfficeAPI(
 	log logger.Logger,
 	ef flow.ActionFlow,
 	services BackOfficeServices,
+	tokens *auth.Tokens,
+	hc *health.Checker,
 ) *API {
 	e.Use(middleware.Logger())
 	e.Use(middleware.BodyLimit(cfg.BodyLimit))
@@ -31,23 +42,101 @@ func BackOfficeAPI(
 	microservicesController := newMicroservicesController(log, services.Microservices)
 	eventsController := newActionsController(log, clock.New(), services.Actions, ef)
 	entitiesController := newEntitiesController(log, clock.New(), services.Entities)
+	deadLettersController := newDeadLettersController(log, ef)
+	integrityController := newIntegrityController(log, services.Integrity)
+	apiKeysController := newAPIKeysController(log, services.APIKeys)
+	signingController := newSigningController(log, services.Signing)
+	accessLogController := newAccessLogController(log, services.AccessLog)
+	retentionController := newRetentionController(log, services.Retention)
+	webhooksController := newWebhooksController(log, services.Webhooks)
+	timeoutsController := newTimeoutsController(log, services.Timeouts)
+
+	tail := newActionTail()
+	tailController := newTailController(log, services.Actions, tail)
+	if cfg.TailConsumer != "" {
+		go ef.ReceiveActionEvents(cfg.TailConsumer, tail.publish)
+	}
+
+	// every user has to present an access token, unless authentication is disabled,
+	// viewers read, auditors also verify and inspect, admins also change
+	api := e.Group("/api/v1")
+
+	// every read is recorded, rejected ones included
+	api.Use(accessLog(log, services.AccessLog, clock.New()))
+
+	if tokens != nil {
+		api.Use(tokenAuth(tokens), requireRole(auth.RoleViewer))
+	}
+
+	auditor := requireRole(auth.RoleAuditor)
+	admin := requireRole(auth.RoleAdmin)
 
 	// Microservices
-	e.GET("/api/v1/microservices", microservicesController.index)
-	e.POST("/api/v1/microservices", microservicesController.create)
-	e.PUT("/api/v1/microservices/:id", microservicesController.update)
-	e.GET("/api/v1/microservices/:id", microservicesController.show)
+	api.GET("/microservices", microservicesController.index)
+	api.POST("/microservices", microservicesController.create, admin)
+	api.PUT("/microservices/:id", microservicesController.update, admin)
+	api.GET("/microservices/:id", microservicesController.show)
+	api.POST("/microservices/:id/signing-secret/rotate", signingController.rotate, admin)
 
 	// Events
-	e.GET("/api/v1/actions", eventsController.index)
-	e.GET("/api/v1/actions/count", eventsController.count)
-	//e.GET("/api/v1/actions/queue", eventsController.inspect)
-	//e.DELETE("/api/v1/actions/:id", eventsController.delete)
-	e.GET("/api/v1/actions/:id", eventsController.show)
+	api.GET("/actions", eventsController.index)
+	api.GET("/actions/count", eventsController.count)
+	api.GET("/actions/search", eventsController.search)
+	api.GET("/actions/stats", eventsController.stats)
+	api.GET("/actions/stream", tailController.stream)
+	//api.GET("/actions/queue", eventsController.inspect, auditor)
+	api.GET("/actions/:id", eventsController.show)
+	api.DELETE("/actions/:id", eventsController.delete, admin)
+	api.GET("/actions/:id/tree", eventsController.tree)
 
 	// Entities
-	e.GET("/api/v1/entitiesController", entitiesController.index)
-	e.GET("/api/v1/entitiesController/:id", entitiesController.show)
+	api.GET("/entities", entitiesController.index)
+	api.GET("/entities/:id", entitiesController.show)
+	api.GET("/entities/:id/state", entitiesController.state)
+	api.GET("/entities/:id/properties/:name/history", entitiesController.propertyHistory)
+
+	// Integrity of the action hash chain
+	api.GET("/integrity/chain", integrityController.verifyChain, auditor)
+
+	// API keys of the receiver clients
+	api.GET("/api-keys", apiKeysController.index, admin)
+	api.POST("/api-keys", apiKeysController.create, admin)
+	api.POST("/api-keys/:id/rotate", apiKeysController.rotate, admin)
+	api.DELETE("/api-keys/:id", apiKeysController.revoke, admin)
+
+	// Access log of the back-office reads
+	api.GET("/access-log", accessLogController.index, admin)
+
+	// Retention rules enforced by the cleaner
+	api.GET("/retention-rules", retentionController.index, admin)
+	api.POST("/retention-rules", retentionController.create, admin)
+	api.DELETE("/retention-rules/:id", retentionController.delete, admin)
+
+	// Webhook rules matched by the dispatcher and the log of its deliveries
+	api.GET("/webhook-rules", webhooksController.index, admin)
+	api.POST("/webhook-rules", webhooksController.create, admin)
+	api.GET("/webhook-rules/:id", webhooksController.show, admin)
+	api.PUT("/webhook-rules/:id", webhooksController.update, admin)
+	api.DELETE("/webhook-rules/:id", webhooksController.delete, admin)
+	api.GET("/webhook-deliveries", webhooksController.deliveries, admin)
+	api.POST("/webhook-deliveries/:id/retry", webhooksController.retry, admin)
+
+	// Timeout rules of async actions applied by the watchdog and its sweeps
+	api.GET("/timeout-rules", timeoutsController.index, admin)
+	api.POST("/timeout-rules", timeoutsController.create, admin)
+	api.DELETE("/timeout-rules/:id", timeoutsController.delete, admin)
+	api.GET("/timeout-sweeps", timeoutsController.sweeps, auditor)
+	api.GET("/timeout-sweeps/:id", timeoutsController.sweep, auditor)
+
+	// Dead letters, kind is either create or update, payloads of every service are in the same queue
+	unscoped := requireUnscoped()
+	api.GET("/dead-letters/:kind", deadLettersController.index, auditor, unscoped)
+	api.DELETE("/dead-letters/:kind", deadLettersController.purge, admin, unscoped)
+	api.POST("/dead-letters/:kind/replay", deadLettersController.replay, admin, unscoped)
+	api.GET("/dead-letters/:kind/:uid", deadLettersController.show, auditor, unscoped)
+	api.POST("/dead-letters/:kind/:uid/replay", deadLettersController.replay, admin, unscoped)
+
+	registerProbes(e, hc)
 
 	return &API{
 		e:   e,
@@ -3,8 +3,13 @@ package rest
 import "strings"
 
 type Config struct {
-	Port      string
-	BodyLimit string
+	Port           string
+	BodyLimit      string
+	BatchBodyLimit string
+
+	// TailConsumer - name the back-office receives action events under, it has to be unique
+	// among the replicas, without it action streams only catch up from the storage
+	TailConsumer string
 }
 
 func ResolvePort(port string) string {
@@ -0,0 +1,104 @@
+package rest
+
+import (
+	"github.com/denismitr/auditbase/internal/flow"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+const defaultDeadLettersLimit = 25
+const maxDeadLettersLimit = 500
+
+type deadLettersController struct {
+	lg logger.Logger
+	dl flow.DeadLetters
+}
+
+func newDeadLettersController(lg logger.Logger, dl flow.DeadLetters) *deadLettersController {
+	return &deadLettersController{
+		lg: lg,
+		dl: dl,
+	}
+}
+
+type deadLettersMeta struct {
+	Total int `json:"total"`
+	Limit int `json:"limit"`
+}
+
+type deadLettersAffectedResource struct {
+	Affected int `json:"affected"`
+}
+
+func (dc *deadLettersController) index(rCtx echo.Context) error {
+	kind := flow.DeadLetterKind(rCtx.Param("kind"))
+
+	limit, err := intQueryParam(rCtx, "limit", defaultDeadLettersLimit, maxDeadLettersLimit)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	i, err := dc.dl.InspectDeadLetters(kind)
+	if err != nil {
+		return rCtx.JSON(deadLettersError(err))
+	}
+
+	items, err := dc.dl.ListDeadLetters(kind, limit)
+	if err != nil {
+		return rCtx.JSON(deadLettersError(err))
+	}
+
+	return rCtx.JSON(200, collectionResource{
+		Data: items,
+		Meta: deadLettersMeta{Total: i.Messages, Limit: limit},
+	})
+}
+
+func (dc *deadLettersController) show(rCtx echo.Context) error {
+	kind := flow.DeadLetterKind(rCtx.Param("kind"))
+
+	dl, err := dc.dl.FindDeadLetter(kind, rCtx.Param("uid"))
+	if err != nil {
+		return rCtx.JSON(deadLettersError(err))
+	}
+
+	return rCtx.JSON(200, itemResource{
+		Data: dl,
+	})
+}
+
+func (dc *deadLettersController) replay(rCtx echo.Context) error {
+	kind := flow.DeadLetterKind(rCtx.Param("kind"))
+
+	n, err := dc.dl.ReplayDeadLetters(kind, rCtx.Param("uid"))
+	if err != nil {
+		return rCtx.JSON(deadLettersError(err))
+	}
+
+	return rCtx.JSON(202, itemResource{
+		Data: deadLettersAffectedResource{Affected: n},
+	})
+}
+
+func (dc *deadLettersController) purge(rCtx echo.Context) error {
+	kind := flow.DeadLetterKind(rCtx.Param("kind"))
+
+	n, err := dc.dl.PurgeDeadLetters(kind)
+	if err != nil {
+		return rCtx.JSON(deadLettersError(err))
+	}
+
+	return rCtx.JSON(200, itemResource{
+		Data: deadLettersAffectedResource{Affected: n},
+	})
+}
+
+func deadLettersError(err error) (int, *errorResponse) {
+	switch errors.Cause(err) {
+	case flow.ErrUnknownDeadLetterKind, flow.ErrDeadLetterNotFound:
+		return notFound(err)
+	default:
+		return internalError(err)
+	}
+}
@@ -0,0 +1,89 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/denismitr/auditbase/internal/flow"
+	"github.com/denismitr/auditbase/internal/flow/queue"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeDeadLetters struct {
+	items     []*flow.DeadLetter
+	lastLimit int
+	replayed  string
+}
+
+func (f *fakeDeadLetters) InspectDeadLetters(kind flow.DeadLetterKind) (queue.Inspection, error) {
+	if kind != flow.CreateActionsDeadLetters {
+		return queue.Inspection{}, errors.Wrap(flow.ErrUnknownDeadLetterKind, string(kind))
+	}
+
+	return queue.Inspection{Messages: len(f.items)}, nil
+}
+
+func (f *fakeDeadLetters) ListDeadLetters(kind flow.DeadLetterKind, limit int) ([]*flow.DeadLetter, error) {
+	f.lastLimit = limit
+	return f.items, nil
+}
+
+func (f *fakeDeadLetters) FindDeadLetter(kind flow.DeadLetterKind, uid string) (*flow.DeadLetter, error) {
+	for _, dl := range f.items {
+		if dl.UID == uid {
+			return dl, nil
+		}
+	}
+
+	return nil, errors.Wrap(flow.ErrDeadLetterNotFound, uid)
+}
+
+func (f *fakeDeadLetters) ReplayDeadLetters(kind flow.DeadLetterKind, uid string) (int, error) {
+	f.replayed = uid
+	return 1, nil
+}
+
+func (f *fakeDeadLetters) PurgeDeadLetters(kind flow.DeadLetterKind) (int, error) {
+	return len(f.items), nil
+}
+
+func TestDeadLettersController(t *testing.T) {
+	e := echo.New()
+	f := &fakeDeadLetters{items: []*flow.DeadLetter{
+		{UID: "11111111111111111111111111111111", Queue: "create", Attempt: 3, LastError: "boom", Action: []byte(`{}`)},
+	}}
+
+	dc := newDeadLettersController(logger.NewStdoutLogger(logger.Prod, "test"), f)
+	e.GET("/api/v1/dead-letters/:kind", dc.index)
+	e.GET("/api/v1/dead-letters/:kind/:uid", dc.show)
+	e.POST("/api/v1/dead-letters/:kind/:uid/replay", dc.replay)
+
+	tt := []struct {
+		name   string
+		method string
+		target string
+		status int
+	}{
+		{"list", http.MethodGet, "/api/v1/dead-letters/create?limit=10", http.StatusOK},
+		{"invalid limit", http.MethodGet, "/api/v1/dead-letters/create?limit=abc", http.StatusBadRequest},
+		{"unknown kind", http.MethodGet, "/api/v1/dead-letters/delete", http.StatusNotFound},
+		{"show", http.MethodGet, "/api/v1/dead-letters/create/11111111111111111111111111111111", http.StatusOK},
+		{"show missing", http.MethodGet, "/api/v1/dead-letters/create/22222222222222222222222222222222", http.StatusNotFound},
+		{"replay one", http.MethodPost, "/api/v1/dead-letters/create/11111111111111111111111111111111/replay", http.StatusAccepted},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.target, nil))
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+		})
+	}
+
+	assert.Equal(t, 10, f.lastLimit)
+	assert.Equal(t, "11111111111111111111111111111111", f.replayed)
+}
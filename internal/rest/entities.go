@@ -2,6 +2,7 @@ package rest
 
 import (
 	"context"
+	"github.com/denismitr/auditbase/internal/db"
 	"github.com/denismitr/auditbase/internal/model"
 	"github.com/denismitr/auditbase/internal/service"
 	"github.com/denismitr/auditbase/internal/utils/clock"
@@ -33,16 +34,28 @@ func newEntitiesController(
 func (e *entitiesController) index(rCtx echo.Context) error {
 	q := rCtx.Request().URL.Query()
 	f := createFilter(q, []string{"externalId", "entityTypeId"})
-	c := createCursor(q, 50, []string{"externalId", "entityTypeId", "updatedAt", "createdAt"})
+	f.ByServices(scope(rCtx))
+	c, err := keysetCursor(q, 50, []string{"externalId", "entityTypeId", "updatedAt", "createdAt"})
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	entities, err := e.entities.Select(ctx, f, c)
 	if err != nil {
+		if errors.Cause(err) == db.ErrInvalidQueryInput {
+			return rCtx.JSON(badRequest(err))
+		}
+
 		return rCtx.JSON(internalError(err))
 	}
 
+	for i := range entities.Items {
+		accessed(rCtx, model.AccessedEntity, entities.Items[i].ID)
+	}
+
 	return rCtx.JSON(200, collectionResource{
 		Data: entities.Items,
 		Meta: entities.Meta,
@@ -65,13 +78,120 @@ func (e *entitiesController) show(rCtx echo.Context) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	entity, err := e.entities.FirstByID(ctx, model.ID(numericID))
+	var entity *model.Entity
+	if services := scope(rCtx); services != nil {
+		entity, err = e.entities.FirstVisibleByID(ctx, model.ID(numericID), services)
+	} else {
+		entity, err = e.entities.FirstByID(ctx, model.ID(numericID))
+	}
+
 	if err != nil {
 		e.logger.Error(err)
 		return rCtx.JSON(notFound(err))
 	}
 
+	accessed(rCtx, model.AccessedEntity, entity.ID)
+
 	return rCtx.JSON(200, itemResource{
 		Data: entity,
 	})
 }
+
+func (e *entitiesController) propertyHistory(rCtx echo.Context) error {
+	ID, err := extractIDParamFrom(rCtx)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	name := rCtx.Param("name")
+	if name == "" || len(name) > model.MaxPropertyNameLen {
+		return rCtx.JSON(badRequest(model.ErrPropertyNameInvalid))
+	}
+
+	c := createCursor(rCtx.Request().URL.Query(), 100, []string{"emittedAt"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := e.visible(ctx, rCtx, ID); err != nil {
+		if errors.Cause(err) == db.ErrNotFound {
+			return rCtx.JSON(notFound(errors.Errorf("entity with ID %d not found", ID)))
+		}
+
+		e.logger.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	history, err := e.entities.PropertyHistory(ctx, ID, name, c)
+	if err != nil {
+		if errors.Cause(err) == db.ErrNotFound {
+			return rCtx.JSON(notFound(errors.Errorf("entity with ID %d not found", ID)))
+		}
+
+		e.logger.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	accessed(rCtx, model.AccessedEntity, ID)
+	for i := range history.Items {
+		accessed(rCtx, model.AccessedAction, history.Items[i].ActionID)
+	}
+
+	return rCtx.JSON(200, collectionResource{
+		Data: history.Items,
+		Meta: history.Meta,
+	})
+}
+
+func (e *entitiesController) state(rCtx echo.Context) error {
+	ID, err := extractIDParamFrom(rCtx)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	at := e.clock.CurrentTime()
+	if v := rCtx.QueryParam("at"); v != "" {
+		if at, err = parseTimeParam(v); err != nil {
+			return rCtx.JSON(badRequest(err))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := e.visible(ctx, rCtx, ID); err != nil {
+		if errors.Cause(err) == db.ErrNotFound {
+			return rCtx.JSON(notFound(errors.Errorf("entity with ID %d not found", ID)))
+		}
+
+		e.logger.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	state, err := e.entities.StateAt(ctx, ID, at)
+	if err != nil {
+		if errors.Cause(err) == db.ErrNotFound {
+			return rCtx.JSON(notFound(errors.Errorf("entity with ID %d not found", ID)))
+		}
+
+		e.logger.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	accessed(rCtx, model.AccessedEntity, ID)
+	accessed(rCtx, model.AccessedAction, state.ActionIDs...)
+
+	return rCtx.JSON(200, itemResource{
+		Data: state,
+	})
+}
+
+// visible - entities of other services are not found for a scoped token
+func (e *entitiesController) visible(ctx context.Context, rCtx echo.Context, ID model.ID) error {
+	if services := scope(rCtx); services != nil {
+		_, err := e.entities.FirstVisibleByID(ctx, ID, services)
+		return err
+	}
+
+	return nil
+}
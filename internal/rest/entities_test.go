@@ -0,0 +1,258 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/auth"
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/clock"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeEntities struct {
+	changes  map[model.ID][]model.PropertyChange
+	services map[model.ID]string
+}
+
+func (f *fakeEntities) Select(ctx context.Context, fl *db.Filter, c *db.Cursor) (*model.EntityCollection, error) {
+	entities := &model.EntityCollection{Items: []model.Entity{}}
+	for ID, service := range f.services {
+		if !fl.HasServices() || fl.Services()[0] == service {
+			entities.Items = append(entities.Items, model.Entity{ID: ID})
+		}
+	}
+
+	return entities, nil
+}
+
+func (f *fakeEntities) FirstVisibleByID(ctx context.Context, ID model.ID, services []string) (*model.Entity, error) {
+	if f.services[ID] != services[0] {
+		return nil, db.ErrNotFound
+	}
+
+	return f.FirstByID(ctx, ID)
+}
+
+func (f *fakeEntities) FirstByID(ctx context.Context, ID model.ID) (*model.Entity, error) {
+	if _, ok := f.changes[ID]; !ok {
+		return nil, db.ErrNotFound
+	}
+
+	return &model.Entity{ID: ID}, nil
+}
+
+func (f *fakeEntities) StateAt(ctx context.Context, ID model.ID, at time.Time) (*model.EntityState, error) {
+	changes, ok := f.changes[ID]
+	if !ok {
+		return nil, errors.Wrapf(db.ErrNotFound, "entity %d", ID)
+	}
+
+	return model.ReconstructEntityState(ID, at, changes), nil
+}
+
+func (f *fakeEntities) PropertyHistory(
+	ctx context.Context,
+	ID model.ID,
+	propertyName string,
+	c *db.Cursor,
+) (*model.PropertyChangeCollection, error) {
+	changes, ok := f.changes[ID]
+	if !ok {
+		return nil, errors.Wrapf(db.ErrNotFound, "entity %d", ID)
+	}
+
+	var items []model.PropertyChange
+	for i := range changes {
+		if changes[i].PropertyName == propertyName {
+			items = append(items, changes[i])
+		}
+	}
+
+	total := len(items)
+
+	return &model.PropertyChangeCollection{
+		Items: items,
+		Meta:  model.Meta{Page: int(c.Page), PerPage: int(c.PerPage), Total: &total},
+	}, nil
+}
+
+func newFakeEntities() *fakeEntities {
+	created := model.JSONTime{Time: time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)}
+	updated := model.JSONTime{Time: time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)}
+
+	return &fakeEntities{
+		changes: map[model.ID][]model.PropertyChange{
+			5: {
+				{ActionID: 1, ActionName: "article_created", PropertyName: "title", PropertyType: model.StringProperty, From: nil, To: "foo", EmittedAt: created},
+				{ActionID: 2, ActionName: "article_updated", PropertyName: "title", PropertyType: model.StringProperty, From: "foo", To: "bar", EmittedAt: updated},
+			},
+			7: {},
+		},
+		services: map[model.ID]string{5: "articles", 7: "billing"},
+	}
+}
+
+func TestEntitiesController_PropertyHistory(t *testing.T) {
+	e := echo.New()
+	ec := newEntitiesController(logger.NewStdoutLogger(logger.Prod, "test"), clock.New(), newFakeEntities())
+	e.GET("/api/v1/entities/:id/properties/:name/history", ec.propertyHistory)
+
+	t.Run("history", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/entities/5/properties/title/history", nil))
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var body struct {
+			Data []model.PropertyChange `json:"data"`
+			Meta model.Meta             `json:"meta"`
+		}
+
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 2, *body.Meta.Total)
+		assert.Equal(t, 100, body.Meta.PerPage)
+		if assert.Len(t, body.Data, 2) {
+			assert.Equal(t, "foo", body.Data[1].From)
+			assert.Equal(t, "bar", body.Data[1].To)
+		}
+	})
+
+	tt := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"unknown property", "/api/v1/entities/5/properties/body/history", http.StatusOK},
+		{"missing entity", "/api/v1/entities/6/properties/title/history", http.StatusNotFound},
+		{"invalid id", "/api/v1/entities/abc/properties/title/history", http.StatusBadRequest},
+		{"zero id", "/api/v1/entities/0/properties/title/history", http.StatusBadRequest},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.target, nil))
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+		})
+	}
+}
+
+func TestEntitiesController_State(t *testing.T) {
+	e := echo.New()
+	ec := newEntitiesController(logger.NewStdoutLogger(logger.Prod, "test"), clock.New(), newFakeEntities())
+	e.GET("/api/v1/entities/:id/state", ec.state)
+
+	tt := []struct {
+		name      string
+		target    string
+		status    int
+		title     interface{}
+		actionIDs []model.ID
+	}{
+		{"on march 3rd", "/api/v1/entities/5/state?at=2021-03-03", http.StatusOK, "foo", []model.ID{1}},
+		{"current", "/api/v1/entities/5/state", http.StatusOK, "bar", []model.ID{1, 2}},
+		{"before creation", "/api/v1/entities/5/state?at=2021-02-01%2000:00:00", http.StatusOK, nil, []model.ID{}},
+		{"invalid time", "/api/v1/entities/5/state?at=yesterday", http.StatusBadRequest, nil, nil},
+		{"missing entity", "/api/v1/entities/6/state", http.StatusNotFound, nil, nil},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.target, nil))
+			if !assert.Equal(t, tc.status, rec.Code, rec.Body.String()) || tc.status != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Data model.EntityState `json:"data"`
+			}
+
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, tc.actionIDs, body.Data.ActionIDs)
+			assert.Equal(t, tc.title, body.Data.Properties["title"].Value)
+		})
+	}
+}
+
+func TestEntitiesController_ScopedToken(t *testing.T) {
+	tokens, err := auth.NewTokens([]byte(strings.Repeat("k", auth.MinKeyLength)), clock.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := tokens.Issue("alice", auth.RoleViewer, []string{"billing"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	ec := newEntitiesController(logger.NewStdoutLogger(logger.Prod, "test"), clock.New(), newFakeEntities())
+	api := e.Group("/api/v1", tokenAuth(tokens))
+	api.GET("/entities", ec.index)
+	api.GET("/entities/:id", ec.show)
+	api.GET("/entities/:id/state", ec.state)
+	api.GET("/entities/:id/properties/:name/history", ec.propertyHistory)
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	t.Run("entities of other services are not listed", func(t *testing.T) {
+		rec := get("/api/v1/entities")
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var body struct {
+			Data []model.Entity `json:"data"`
+		}
+
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+
+		if assert.Len(t, body.Data, 1) {
+			assert.Equal(t, model.ID(7), body.Data[0].ID)
+		}
+	})
+
+	tt := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"entity of the service", "/api/v1/entities/7", http.StatusOK},
+		{"state of the service", "/api/v1/entities/7/state", http.StatusOK},
+		{"entity of another service", "/api/v1/entities/5", http.StatusNotFound},
+		{"state of another service", "/api/v1/entities/5/state", http.StatusNotFound},
+		{"history of another service", "/api/v1/entities/5/properties/title/history", http.StatusNotFound},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := get(tc.target)
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+			assert.NotContains(t, rec.Body.String(), "article_")
+		})
+	}
+}
@@ -12,6 +12,12 @@ const msgBadRequest = "Bad request"
 const msgInternalError = "Auditbase internal error"
 const msgNotFound = "Entities not found"
 const msgValidationFailed = "Validation failed"
+const msgUnauthorized = "Unauthorized"
+const msgForbidden = "Forbidden"
+const msgInvalidSignature = "Invalid request signature"
+
+// codeInvalidSignature - lets clients tell signing failures apart from api key failures
+const codeInvalidSignature = "INVALID_SIGNATURE"
 
 type errorResource struct {
 	Title   string `json:"title"`
@@ -76,6 +82,24 @@ func notFound(errors ...error) (int, *errorResponse) {
 	return http.StatusNotFound, newErrorResponse(http.StatusNotFound, resources)
 }
 
+func unauthorized(err error) (int, *errorResponse) {
+	resources := []errorResource{newErrorResourceWithDetails("", msgUnauthorized, err.Error())}
+
+	return http.StatusUnauthorized, newErrorResponse(http.StatusUnauthorized, resources)
+}
+
+func forbidden(err error) (int, *errorResponse) {
+	resources := []errorResource{newErrorResourceWithDetails("", msgForbidden, err.Error())}
+
+	return http.StatusForbidden, newErrorResponse(http.StatusForbidden, resources)
+}
+
+func signatureFailed(err error) (int, *errorResponse) {
+	resources := []errorResource{newErrorResourceWithDetails(codeInvalidSignature, msgInvalidSignature, err.Error())}
+
+	return http.StatusUnauthorized, newErrorResponse(http.StatusUnauthorized, resources)
+}
+
 // fixme
 func validationFailed(errors ...error) (int, *errorResponse) {
 	resources := make([]errorResource, len(errors))
@@ -2,8 +2,10 @@ package rest
 
 import (
 	"github.com/denismitr/auditbase/internal/db"
+	"github.com/pkg/errors"
 	"net/url"
 	"strconv"
+	"time"
 )
 
 func createFilter(q url.Values, allowedKeys []string) *db.Filter {
@@ -18,6 +20,77 @@ func createFilter(q url.Values, allowedKeys []string) *db.Filter {
 	return f
 }
 
+// actionFilterOperators - filters of the action listing with the operators they accept,
+// given as operator:values, e.g. status=in:1,5,6 or name=prefix:invoice_
+var actionFilterOperators = map[string][]db.Operator{
+	"name":             {db.EqOperator, db.InOperator, db.PrefixOperator},
+	"parentUid":        {db.EqOperator},
+	"status":           {db.EqOperator, db.InOperator},
+	"isAsync":          {db.EqOperator},
+	"actorEntityId":    {db.EqOperator, db.InOperator},
+	"targetEntityId":   {db.EqOperator, db.InOperator},
+	"actorService":     {db.EqOperator, db.InOperator},
+	"targetService":    {db.EqOperator, db.InOperator},
+	"actorEntityType":  {db.EqOperator, db.InOperator},
+	"targetEntityType": {db.EqOperator, db.InOperator},
+}
+
+// actionTimeRanges - time range parameters of the action listing, both ends are inclusive
+var actionTimeRanges = []struct {
+	param string
+	key   string
+	op    db.Operator
+}{
+	{param: "emittedFrom", key: "emittedAt", op: db.GteOperator},
+	{param: "emittedTo", key: "emittedAt", op: db.LteOperator},
+	{param: "registeredFrom", key: "registeredAt", op: db.GteOperator},
+	{param: "registeredTo", key: "registeredAt", op: db.LteOperator},
+}
+
+func createActionFilter(q url.Values) (*db.Filter, error) {
+	f := db.NewFilter(nil)
+
+	for k, operators := range actionFilterOperators {
+		v := q.Get(k)
+		if v == "" {
+			continue
+		}
+
+		c := db.ParseCondition(v)
+		if !acceptsOperator(operators, c.Operator) {
+			return nil, errors.Errorf("%s filter does not accept the %s operator", k, c.Operator)
+		}
+
+		f.Where(k, c.Operator, c.Values...)
+	}
+
+	for _, r := range actionTimeRanges {
+		v := q.Get(r.param)
+		if v == "" {
+			continue
+		}
+
+		t, err := parseTimeRangeParam(v, r.op == db.GteOperator)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", r.param)
+		}
+
+		f.Where(r.key, r.op, t.Format(time.RFC3339Nano))
+	}
+
+	return f, nil
+}
+
+func acceptsOperator(operators []db.Operator, op db.Operator) bool {
+	for i := range operators {
+		if operators[i] == op {
+			return true
+		}
+	}
+
+	return false
+}
+
 func createCursor(q url.Values, maxPerPage int, allowedSortColumns []string) *db.Cursor {
 	cursor := new(db.Cursor)
 
@@ -47,3 +120,44 @@ func createCursor(q url.Values, maxPerPage int, allowedSortColumns []string) *db
 	return cursor
 }
 
+
+// keysetCursor - a cursor continuing from the page token in the after or before parameter, if any.
+// Lists followed by tokens are not counted, unless asked for with total=true,
+// total=false leaves the count out of any page
+func keysetCursor(q url.Values, maxPerPage int, allowedSortColumns []string) (*db.Cursor, error) {
+	cursor := createCursor(q, maxPerPage, allowedSortColumns)
+
+	if q.Get("after") != "" && q.Get("before") != "" {
+		return nil, errors.New("only one of after and before page tokens can be given")
+	}
+
+	if token := q.Get("after"); token != "" {
+		k, err := db.ParseKeyset(token)
+		if err != nil {
+			return nil, err
+		}
+
+		cursor.After = k
+	}
+
+	if token := q.Get("before"); token != "" {
+		k, err := db.ParseKeyset(token)
+		if err != nil {
+			return nil, err
+		}
+
+		cursor.Before = k
+	}
+
+	cursor.SkipTotal = cursor.Keyed()
+	if v := q.Get("total"); v != "" {
+		total, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errors.Errorf("total must be a boolean, %q given", v)
+		}
+
+		cursor.SkipTotal = !total
+	}
+
+	return cursor, nil
+}
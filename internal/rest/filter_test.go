@@ -1,9 +1,11 @@
 package rest
 
 import (
+	"github.com/denismitr/auditbase/internal/db"
 	"github.com/stretchr/testify/assert"
 	"net/url"
 	"testing"
+	"time"
 )
 
 func TestCreateFilter(t *testing.T) {
@@ -30,4 +32,96 @@ func TestCreateFilter(t *testing.T) {
 		assert.False(t, f.Has("bar"))
 		assert.Equal(t, "", f.StringOrDefault("bar", ""))
 	})
-}
\ No newline at end of file
+}
+
+func TestKeysetCursor(t *testing.T) {
+	token := db.Keyset{At: time.Date(2021, 2, 23, 16, 54, 49, 0, time.UTC), ID: 42}.Token()
+
+	t.Run("without-token-total-is-counted", func(t *testing.T) {
+		c, err := keysetCursor(url.Values{}, 25, nil)
+
+		assert.NoError(t, err)
+		assert.False(t, c.Keyed())
+		assert.False(t, c.SkipTotal)
+	})
+
+	t.Run("after-token-skips-total", func(t *testing.T) {
+		c, err := keysetCursor(url.Values{"after": {token}}, 25, nil)
+
+		if assert.NoError(t, err) && assert.NotNil(t, c.After) {
+			assert.Equal(t, 42, c.After.ID.Int())
+			assert.Nil(t, c.Before)
+			assert.True(t, c.SkipTotal)
+		}
+	})
+
+	t.Run("total-can-be-asked-for", func(t *testing.T) {
+		c, err := keysetCursor(url.Values{"before": {token}, "total": {"true"}}, 25, nil)
+
+		if assert.NoError(t, err) {
+			assert.NotNil(t, c.Before)
+			assert.False(t, c.SkipTotal)
+		}
+	})
+
+	t.Run("invalid-token", func(t *testing.T) {
+		_, err := keysetCursor(url.Values{"after": {"not-a-token"}}, 25, nil)
+
+		assert.Equal(t, db.ErrInvalidPageToken, err)
+	})
+
+	t.Run("both-tokens", func(t *testing.T) {
+		_, err := keysetCursor(url.Values{"after": {token}, "before": {token}}, 25, nil)
+
+		assert.Error(t, err)
+	})
+}
+
+func TestCreateActionFilter(t *testing.T) {
+	t.Run("operators", func(t *testing.T) {
+		q, err := url.ParseQuery("status=in:1,5,6&name=prefix:invoice_&isAsync=true&targetService=billing")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := createActionFilter(q)
+		if !assert.NoError(t, err) {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []db.Condition{{Operator: db.InOperator, Values: []string{"1", "5", "6"}}}, f.Conditions("status"))
+		assert.Equal(t, []db.Condition{{Operator: db.PrefixOperator, Values: []string{"invoice_"}}}, f.Conditions("name"))
+		assert.Equal(t, "true", f.StringOrDefault("isAsync", ""))
+		assert.Equal(t, "billing", f.StringOrDefault("targetService", ""))
+	})
+
+	t.Run("time ranges", func(t *testing.T) {
+		q, err := url.ParseQuery("emittedFrom=2021-03-01&emittedTo=2021-03-02&registeredFrom=2021-03-01T10:00:00%2B02:00")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := createActionFilter(q)
+		if !assert.NoError(t, err) {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []db.Condition{
+			{Operator: db.GteOperator, Values: []string{"2021-03-01T00:00:00Z"}},
+			{Operator: db.LteOperator, Values: []string{"2021-03-02T23:59:59Z"}},
+		}, f.Conditions("emittedAt"))
+		assert.Equal(t, []db.Condition{
+			{Operator: db.GteOperator, Values: []string{"2021-03-01T08:00:00Z"}},
+		}, f.Conditions("registeredAt"))
+	})
+
+	t.Run("unsupported operator", func(t *testing.T) {
+		_, err := createActionFilter(url.Values{"parentUid": {"prefix:abc"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid time", func(t *testing.T) {
+		_, err := createActionFilter(url.Values{"registeredTo": {"yesterday"}})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,14 @@
+package rest
+
+import (
+	"github.com/denismitr/auditbase/internal/health"
+	"github.com/denismitr/auditbase/internal/metrics"
+	"github.com/labstack/echo"
+)
+
+// registerProbes - liveness and readiness probes and Prometheus metrics
+func registerProbes(e *echo.Echo, hc *health.Checker) {
+	e.GET("/healthz", echo.WrapHandler(health.LiveHandler()))
+	e.GET("/readyz", echo.WrapHandler(health.ReadyHandler(hc)))
+	e.GET("/metrics", echo.WrapHandler(metrics.Handler()))
+}
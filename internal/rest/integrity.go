@@ -0,0 +1,60 @@
+package rest
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/service"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+type integrityController struct {
+	logger    logger.Logger
+	integrity service.IntegrityService
+}
+
+func newIntegrityController(l logger.Logger, integrity service.IntegrityService) *integrityController {
+	return &integrityController{
+		logger:    l,
+		integrity: integrity,
+	}
+}
+
+// verifyChain - walks the action hash chain and reports the first broken link
+func (ic *integrityController) verifyChain(rCtx echo.Context) error {
+	from, err := intQueryParam(rCtx, "from", 1, math.MaxInt32)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	to, err := intQueryParam(rCtx, "to", 0, math.MaxInt32)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	if to != 0 && to < from {
+		return rCtx.JSON(badRequest(errors.Errorf("to must not be less than from")))
+	}
+
+	limit, err := intQueryParam(rCtx, "limit", model.DefaultChainVerifyLimit, model.MaxChainVerifyLimit)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	verification, err := ic.integrity.VerifyChain(ctx, model.ID(from), model.ID(to), limit)
+	if err != nil {
+		ic.logger.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	return rCtx.JSON(200, itemResource{
+		Data: verification,
+	})
+}
@@ -0,0 +1,78 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeIntegrity struct {
+	fromID, toID model.ID
+	limit        int
+}
+
+func (f *fakeIntegrity) VerifyChain(ctx context.Context, fromID, toID model.ID, limit int) (*model.ChainVerification, error) {
+	f.fromID, f.toID, f.limit = fromID, toID, limit
+
+	v := model.NewChainVerification(fromID, "")
+	v.Valid = false
+	v.BrokenLink = &model.BrokenChainLink{ActionID: 7, Reason: model.ChainLinkMismatch}
+
+	return v, nil
+}
+
+func TestIntegrityController_VerifyChain(t *testing.T) {
+	e := echo.New()
+	fake := &fakeIntegrity{}
+	ic := newIntegrityController(logger.NewStdoutLogger(logger.Prod, "test"), fake)
+	e.GET("/api/v1/integrity/chain", ic.verifyChain)
+
+	t.Run("report", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/integrity/chain?from=5&to=10", nil))
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var body struct {
+			Data model.ChainVerification `json:"data"`
+		}
+
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, model.ID(5), fake.fromID)
+		assert.Equal(t, model.ID(10), fake.toID)
+		assert.Equal(t, model.DefaultChainVerifyLimit, fake.limit)
+		assert.False(t, body.Data.Valid)
+		if assert.NotNil(t, body.Data.BrokenLink) {
+			assert.Equal(t, model.ID(7), body.Data.BrokenLink.ActionID)
+			assert.Equal(t, model.ChainLinkMismatch, body.Data.BrokenLink.Reason)
+		}
+	})
+
+	tt := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"defaults", "/api/v1/integrity/chain", http.StatusOK},
+		{"to before from", "/api/v1/integrity/chain?from=10&to=5", http.StatusBadRequest},
+		{"invalid from", "/api/v1/integrity/chain?from=abc", http.StatusBadRequest},
+		{"limit too big", "/api/v1/integrity/chain?limit=1000000", http.StatusBadRequest},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.target, nil))
+			assert.Equal(t, tc.status, rec.Code, rec.Body.String())
+		})
+	}
+}
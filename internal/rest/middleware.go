@@ -1,3 +1,200 @@
 package rest
 
-// TODO
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/auth"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/receiver"
+	"github.com/denismitr/auditbase/internal/service"
+	"github.com/denismitr/auditbase/internal/utils/errtype"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+// HeaderAPIKey - header the receiver API expects the api key in
+const HeaderAPIKey = "X-Api-Key"
+
+const ErrAPIKeyMissing = errtype.StringError("api key is missing, expected in " + HeaderAPIKey + " header")
+const ErrTokenMissing = errtype.StringError("access token is missing, expected as Bearer in Authorization header")
+
+const apiKeyContextKey = "apiKey"
+const signerContextKey = "signer"
+const claimsContextKey = "claims"
+
+// apiKeyAuth - rejects requests without a valid api key, the key is kept
+// in the request context, so that handlers can authorize actor services
+func apiKeyAuth(lg logger.Logger, keys service.APIKeyAuthenticator) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(rCtx echo.Context) error {
+			key := rCtx.Request().Header.Get(HeaderAPIKey)
+			if key == "" {
+				return rCtx.JSON(unauthorized(ErrAPIKeyMissing))
+			}
+
+			ctx, cancel := context.WithTimeout(rCtx.Request().Context(), 2*time.Second)
+			defer cancel()
+
+			k, err := keys.Authenticate(ctx, key)
+			if err != nil {
+				if errors.Cause(err) == service.ErrInvalidAPIKey {
+					return rCtx.JSON(unauthorized(err))
+				}
+
+				lg.Error(err)
+				return rCtx.JSON(internalError(err))
+			}
+
+			rCtx.Set(apiKeyContextKey, k)
+
+			return next(rCtx)
+		}
+	}
+}
+
+// verifySignature - rejects requests whose body is not signed by the service
+// named in the signature headers, the body is restored for the handler
+func verifySignature(lg logger.Logger, verifier *receiver.SignatureVerifier) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(rCtx echo.Context) error {
+			req := rCtx.Request()
+
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				return rCtx.JSON(badRequest(errors.Wrap(err, "could not read request body")))
+			}
+
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			signer := req.Header.Get(receiver.HeaderSignatureService)
+
+			ctx, cancel := context.WithTimeout(req.Context(), 2*time.Second)
+			defer cancel()
+
+			if err := verifier.Verify(
+				ctx,
+				signer,
+				req.Header.Get(receiver.HeaderSignatureTimestamp),
+				req.Header.Get(receiver.HeaderSignature),
+				body,
+			); err != nil {
+				switch errors.Cause(err) {
+				case receiver.ErrSignatureMissing, receiver.ErrSignatureInvalid, receiver.ErrSignatureExpired:
+					return rCtx.JSON(signatureFailed(err))
+				}
+
+				lg.Error(err)
+				return rCtx.JSON(internalError(err))
+			}
+
+			if k := authorizer(rCtx); k != nil && !k.Allows(signer) {
+				return rCtx.JSON(forbidden(errors.Wrapf(receiver.ErrServiceNotAllowed, "signer [%s]", signer)))
+			}
+
+			rCtx.Set(signerContextKey, signer)
+
+			return next(rCtx)
+		}
+	}
+}
+
+// signedAuthorizer - a signed request may only submit actions of its signer
+type signedAuthorizer struct {
+	key    receiver.Authorizer
+	signer string
+}
+
+func (a signedAuthorizer) Allows(service string) bool {
+	if service != a.signer {
+		return false
+	}
+
+	return a.key == nil || a.key.Allows(service)
+}
+
+// authorizer - api key and signer of the request, nil when both are disabled
+func authorizer(rCtx echo.Context) receiver.Authorizer {
+	var key receiver.Authorizer
+	if k, ok := rCtx.Get(apiKeyContextKey).(*model.APIKey); ok && k != nil {
+		key = k
+	}
+
+	if signer, ok := rCtx.Get(signerContextKey).(string); ok && signer != "" {
+		return signedAuthorizer{key: key, signer: signer}
+	}
+
+	return key
+}
+
+// tokenAuth - rejects back-office requests without a valid access token,
+// claims of the token are kept in the request context for role checks and scoping
+func tokenAuth(tokens *auth.Tokens) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(rCtx echo.Context) error {
+			header := rCtx.Request().Header.Get(echo.HeaderAuthorization)
+			if !strings.HasPrefix(header, "Bearer ") {
+				return rCtx.JSON(unauthorized(ErrTokenMissing))
+			}
+
+			claims, err := tokens.Parse(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+			if err != nil {
+				return rCtx.JSON(unauthorized(err))
+			}
+
+			rCtx.Set(claimsContextKey, claims)
+
+			return next(rCtx)
+		}
+	}
+}
+
+// requireRole - rejects requests of users whose role does not include the given one,
+// every request passes when authentication is disabled
+func requireRole(role auth.Role) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(rCtx echo.Context) error {
+			if c := claims(rCtx); c != nil && !c.Role.Includes(role) {
+				return rCtx.JSON(forbidden(errors.Errorf("%s role is required, user has %s", role, c.Role)))
+			}
+
+			return next(rCtx)
+		}
+	}
+}
+
+// requireUnscoped - rejects requests of users scoped to some services, for the routes
+// returning records that are not told apart by service
+func requireUnscoped() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(rCtx echo.Context) error {
+			if services := scope(rCtx); services != nil {
+				return rCtx.JSON(forbidden(errors.Errorf("user is scoped to %s, an unscoped token is required", strings.Join(services, ", "))))
+			}
+
+			return next(rCtx)
+		}
+	}
+}
+
+// claims - claims of the access token, nil when authentication is disabled
+func claims(rCtx echo.Context) *auth.Claims {
+	if c, ok := rCtx.Get(claimsContextKey).(*auth.Claims); ok {
+		return c
+	}
+
+	return nil
+}
+
+// scope - services the user is restricted to, nil means all of them
+func scope(rCtx echo.Context) []string {
+	if c := claims(rCtx); c != nil && c.Scoped() {
+		return c.Services
+	}
+
+	return nil
+}
@@ -0,0 +1,271 @@
+package rest
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/auth"
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/receiver"
+	"github.com/denismitr/auditbase/internal/service"
+	"github.com/denismitr/auditbase/internal/utils/clock"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAuthenticator map[string]*model.APIKey
+
+func (f fakeAuthenticator) Authenticate(ctx context.Context, key string) (*model.APIKey, error) {
+	if key == "broken" {
+		return nil, errors.New("database is down")
+	}
+
+	k, ok := f[key]
+	if !ok {
+		return nil, service.ErrInvalidAPIKey
+	}
+
+	return k, nil
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	e := echo.New()
+	keys := fakeAuthenticator{
+		"secret": {Name: "billing", Services: []model.Microservice{{Name: "billing"}}},
+	}
+
+	e.POST("/actions", func(rCtx echo.Context) error {
+		if authorizer(rCtx).Allows("billing") && !authorizer(rCtx).Allows("orders") {
+			return rCtx.NoContent(http.StatusAccepted)
+		}
+
+		return rCtx.NoContent(http.StatusForbidden)
+	}, apiKeyAuth(logger.NewStdoutLogger(logger.Prod, "test"), keys))
+
+	tt := []struct {
+		key  string
+		code int
+	}{
+		{key: "", code: http.StatusUnauthorized},
+		{key: "unknown", code: http.StatusUnauthorized},
+		{key: "broken", code: http.StatusInternalServerError},
+		{key: "secret", code: http.StatusAccepted},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.key, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/actions", nil)
+			if tc.key != "" {
+				req.Header.Set(HeaderAPIKey, tc.key)
+			}
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.code, rec.Code, rec.Body.String())
+		})
+	}
+}
+
+func TestAuthorizer_WithoutAuthentication(t *testing.T) {
+	e := echo.New()
+	rCtx := e.NewContext(httptest.NewRequest(http.MethodPost, "/actions", nil), httptest.NewRecorder())
+
+	assert.Nil(t, authorizer(rCtx))
+}
+
+type fakeSecretStore map[string]*model.SigningSecrets
+
+func (f fakeSecretStore) SigningSecrets(ctx context.Context, service string) (*model.SigningSecrets, error) {
+	s, ok := f[service]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+
+	return s, nil
+}
+
+func TestVerifySignature(t *testing.T) {
+	e := echo.New()
+	lg := logger.NewStdoutLogger(logger.Prod, "test")
+	keys := fakeAuthenticator{
+		"secret": {Name: "billing", Services: []model.Microservice{{Name: "billing"}, {Name: "orders"}}},
+	}
+	store := fakeSecretStore{
+		"billing":  {Secret: "billing-secret"},
+		"payments": {Secret: "payments-secret"},
+	}
+	verifier := receiver.NewSignatureVerifier(store, clock.New(), receiver.DefaultSignatureTolerance, time.Minute)
+
+	e.POST("/actions", func(rCtx echo.Context) error {
+		body, err := ioutil.ReadAll(rCtx.Request().Body)
+		if err != nil || string(body) != `{"name":"created"}` {
+			return rCtx.NoContent(http.StatusBadRequest)
+		}
+
+		// signed requests may only submit actions of the signer
+		if authorizer(rCtx).Allows("billing") && !authorizer(rCtx).Allows("orders") {
+			return rCtx.NoContent(http.StatusAccepted)
+		}
+
+		return rCtx.NoContent(http.StatusForbidden)
+	}, apiKeyAuth(lg, keys), verifySignature(lg, verifier))
+
+	now := time.Now().Unix()
+	body := `{"name":"created"}`
+
+	tt := []struct {
+		name      string
+		service   string
+		timestamp int64
+		signature string
+		code      int
+		errCode   string
+	}{
+		{name: "valid", service: "billing", timestamp: now, signature: receiver.Sign("billing-secret", now, []byte(body)), code: http.StatusAccepted},
+		{name: "missing", service: "billing", timestamp: now, code: http.StatusUnauthorized, errCode: codeInvalidSignature},
+		{
+			name:      "tampered",
+			service:   "billing",
+			timestamp: now,
+			signature: receiver.Sign("billing-secret", now, []byte(`{"name":"deleted"}`)),
+			code:      http.StatusUnauthorized,
+			errCode:   codeInvalidSignature,
+		},
+		{
+			name:      "replayed later",
+			service:   "billing",
+			timestamp: now - 3600,
+			signature: receiver.Sign("billing-secret", now-3600, []byte(body)),
+			code:      http.StatusUnauthorized,
+			errCode:   codeInvalidSignature,
+		},
+		{
+			name:      "signer not allowed by api key",
+			service:   "payments",
+			timestamp: now,
+			signature: receiver.Sign("payments-secret", now, []byte(body)),
+			code:      http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/actions", strings.NewReader(body))
+			req.Header.Set(HeaderAPIKey, "secret")
+			req.Header.Set(receiver.HeaderSignatureService, tc.service)
+			req.Header.Set(receiver.HeaderSignatureTimestamp, strconv.FormatInt(tc.timestamp, 10))
+			if tc.signature != "" {
+				req.Header.Set(receiver.HeaderSignature, tc.signature)
+			}
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.code, rec.Code, rec.Body.String())
+			if tc.errCode != "" {
+				assert.Contains(t, rec.Body.String(), `"code":"`+tc.errCode+`"`)
+			}
+		})
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	tokens, err := auth.NewTokens([]byte(strings.Repeat("k", auth.MinKeyLength)), clock.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(role auth.Role, services ...string) string {
+		token, err := tokens.Issue("alice", role, services, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return token
+	}
+
+	e := echo.New()
+	api := e.Group("/api/v1")
+	api.Use(tokenAuth(tokens), requireRole(auth.RoleViewer))
+
+	api.GET("/actions", func(rCtx echo.Context) error {
+		return rCtx.JSON(http.StatusOK, scope(rCtx))
+	})
+	api.POST("/microservices", func(rCtx echo.Context) error {
+		return rCtx.NoContent(http.StatusCreated)
+	}, requireRole(auth.RoleAdmin))
+	api.GET("/dead-letters/create", func(rCtx echo.Context) error {
+		return rCtx.NoContent(http.StatusOK)
+	}, requireRole(auth.RoleAuditor), requireUnscoped())
+
+	tt := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		code   int
+		body   string
+	}{
+		{name: "no token", method: http.MethodGet, path: "/api/v1/actions", code: http.StatusUnauthorized},
+		{name: "not a bearer", method: http.MethodGet, path: "/api/v1/actions", header: "Basic Zm9vOmJhcg==", code: http.StatusUnauthorized},
+		{name: "garbage token", method: http.MethodGet, path: "/api/v1/actions", header: "Bearer foo.bar.baz", code: http.StatusUnauthorized},
+		{name: "viewer reads", method: http.MethodGet, path: "/api/v1/actions", header: "Bearer " + issue(auth.RoleViewer), code: http.StatusOK, body: "null"},
+		{
+			name:   "scoped viewer reads",
+			method: http.MethodGet,
+			path:   "/api/v1/actions",
+			header: "Bearer " + issue(auth.RoleViewer, "billing"),
+			code:   http.StatusOK,
+			body:   `["billing"]`,
+		},
+		{name: "auditor cannot create", method: http.MethodPost, path: "/api/v1/microservices", header: "Bearer " + issue(auth.RoleAuditor), code: http.StatusForbidden},
+		{name: "admin creates", method: http.MethodPost, path: "/api/v1/microservices", header: "Bearer " + issue(auth.RoleAdmin), code: http.StatusCreated},
+		{name: "auditor reads dead letters", method: http.MethodGet, path: "/api/v1/dead-letters/create", header: "Bearer " + issue(auth.RoleAuditor), code: http.StatusOK},
+		{
+			name:   "scoped auditor cannot read dead letters",
+			method: http.MethodGet,
+			path:   "/api/v1/dead-letters/create",
+			header: "Bearer " + issue(auth.RoleAuditor, "billing"),
+			code:   http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.header != "" {
+				req.Header.Set(echo.HeaderAuthorization, tc.header)
+			}
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.code, rec.Code, rec.Body.String())
+			if tc.body != "" {
+				assert.Equal(t, tc.body, strings.TrimSpace(rec.Body.String()))
+			}
+		})
+	}
+}
+
+func TestRequireRole_WithoutAuthentication(t *testing.T) {
+	e := echo.New()
+	e.DELETE("/dead-letters/create", func(rCtx echo.Context) error {
+		return rCtx.NoContent(http.StatusNoContent)
+	}, requireRole(auth.RoleAdmin))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/dead-letters/create", nil))
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+}
@@ -1,12 +1,18 @@
 package rest
 
 import (
+	"net/http"
+	"strings"
+
+	"github.com/denismitr/auditbase/internal/health"
 	"github.com/denismitr/auditbase/internal/receiver"
+	"github.com/denismitr/auditbase/internal/service"
 	"github.com/denismitr/auditbase/internal/utils/clock"
 	"github.com/denismitr/auditbase/internal/utils/logger"
 	"github.com/denismitr/auditbase/internal/utils/validator"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
+	"github.com/pkg/errors"
 )
 
 func NewReceiverAPI(
@@ -14,8 +20,10 @@ func NewReceiverAPI(
 	cfg Config,
 	lg logger.Logger,
 	rc *receiver.Receiver,
+	keys service.APIKeyAuthenticator,
+	verifier *receiver.SignatureVerifier,
+	hc *health.Checker,
 ) *API {
-	e.Use(middleware.BodyLimit(cfg.BodyLimit))
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 
@@ -25,8 +33,29 @@ func NewReceiverAPI(
 		rc:    rc,
 	}
 
-	e.POST("/api/v1/actions", receiverController.create)
-	e.PATCH("/api/v1/actions", receiverController.update)
+	bodyLimit := middleware.BodyLimit(cfg.BodyLimit)
+	batchBodyLimit := middleware.BodyLimit(resolveBatchBodyLimit(cfg))
+
+	// every client has to present an api key, unless authentication is disabled
+	actions := e.Group("/api/v1/actions")
+	if keys != nil {
+		actions.Use(apiKeyAuth(lg, keys))
+	}
+
+	// bodies are verified after the size limit, before the receiver hashes them
+	signed := func(limit echo.MiddlewareFunc) []echo.MiddlewareFunc {
+		if verifier == nil {
+			return []echo.MiddlewareFunc{limit}
+		}
+
+		return []echo.MiddlewareFunc{limit, verifySignature(lg, verifier)}
+	}
+
+	actions.POST("", receiverController.create, signed(bodyLimit)...)
+	actions.POST("/batch", receiverController.createBatch, signed(batchBodyLimit)...)
+	actions.PATCH("", receiverController.update, signed(bodyLimit)...)
+
+	registerProbes(e, hc)
 
 	return &API{
 		e:   e,
@@ -41,17 +70,19 @@ type receiverController struct {
 }
 
 func (rc *receiverController) create(ctx echo.Context) error {
-	reg, err := rc.rc.ReceiveOneForCreate(ctx.Request().Body);
+	reg, err := rc.rc.ReceiveOneForCreate(ctx.Request().Body, authorizer(ctx));
 	if err != nil {
 		if vErr, ok := err.(*validator.ValidationErrors); ok {
 			return ctx.JSON(validationFailed(vErr.All()...))
 		}
 
-		switch err {
+		switch errors.Cause(err) {
 		case receiver.ErrActionAlreadyProcessed:
 			return ctx.JSON(conflict(err, "action already processed"))
 		case receiver.ErrInvalidInput:
 			return ctx.JSON(badRequest(err))
+		case receiver.ErrServiceNotAllowed:
+			return ctx.JSON(forbidden(err))
 		}
 
 		return ctx.JSON(internalError(err))
@@ -68,17 +99,19 @@ func (rc *receiverController) create(ctx echo.Context) error {
 }
 
 func (rc *receiverController) update(ctx echo.Context) error {
-	reg, err := rc.rc.ReceiveOneForUpdate(ctx.Request().Body);
+	reg, err := rc.rc.ReceiveOneForUpdate(ctx.Request().Body, authorizer(ctx));
 	if err != nil {
 		if vErr, ok := err.(*validator.ValidationErrors); ok {
 			return ctx.JSON(validationFailed(vErr.All()...))
 		}
 
-		switch err {
+		switch errors.Cause(err) {
 		case receiver.ErrActionAlreadyProcessed:
 			return ctx.JSON(conflict(err, "action already processed"))
 		case receiver.ErrInvalidInput:
 			return ctx.JSON(badRequest(err))
+		case receiver.ErrServiceNotAllowed:
+			return ctx.JSON(forbidden(err))
 		}
 
 		return ctx.JSON(internalError(err))
@@ -93,3 +126,85 @@ func (rc *receiverController) update(ctx echo.Context) error {
 		},
 	})
 }
+
+type batchItemResource struct {
+	Index        int    `json:"index"`
+	Status       string `json:"status"`
+	UID          string `json:"uid,omitempty"`
+	Hash         string `json:"hash,omitempty"`
+	RegisteredAt string `json:"registeredAt,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+type batchResource struct {
+	Accepted  int                 `json:"accepted"`
+	Duplicate int                 `json:"duplicate"`
+	Invalid   int                 `json:"invalid"`
+	Forbidden int                 `json:"forbidden"`
+	Failed    int                 `json:"failed"`
+	Items     []batchItemResource `json:"items"`
+}
+
+func (rc *receiverController) createBatch(ctx echo.Context) error {
+	ndjson := isNDJSON(ctx.Request().Header.Get(echo.HeaderContentType))
+
+	results, err := rc.rc.ReceiveBatchForCreate(ctx.Request().Body, ndjson, authorizer(ctx))
+	if err != nil {
+		switch errors.Cause(err) {
+		case receiver.ErrInvalidInput, receiver.ErrEmptyBatch:
+			return ctx.JSON(badRequest(err))
+		case receiver.ErrBatchTooLarge:
+			return ctx.JSON(http.StatusRequestEntityTooLarge, newErrorResponse(
+				http.StatusRequestEntityTooLarge,
+				[]errorResource{newErrorResourceWithDetails("BATCH_TOO_LARGE", msgBadRequest, err.Error())},
+			))
+		}
+
+		return ctx.JSON(internalError(err))
+	}
+
+	batch := batchResource{Items: make([]batchItemResource, len(results))}
+	for i, r := range results {
+		item := batchItemResource{Index: r.Index, Status: string(r.Status)}
+
+		switch r.Status {
+		case receiver.BatchItemAccepted:
+			batch.Accepted++
+			item.UID = r.Reg.UID
+			item.Hash = r.Reg.Hash
+			item.RegisteredAt = r.Reg.RegisteredAt.String()
+		case receiver.BatchItemDuplicate:
+			batch.Duplicate++
+		case receiver.BatchItemInvalid:
+			batch.Invalid++
+		case receiver.BatchItemForbidden:
+			batch.Forbidden++
+		default:
+			batch.Failed++
+		}
+
+		if r.Err != nil {
+			item.Error = r.Err.Error()
+		}
+
+		batch.Items[i] = item
+	}
+
+	return ctx.JSON(http.StatusMultiStatus, itemResource{
+		Status: "processed",
+		Data:   batch,
+	})
+}
+
+func isNDJSON(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/x-ndjson") ||
+		strings.HasPrefix(contentType, "application/ndjson")
+}
+
+func resolveBatchBodyLimit(cfg Config) string {
+	if cfg.BatchBodyLimit == "" {
+		return cfg.BodyLimit
+	}
+
+	return cfg.BatchBodyLimit
+}
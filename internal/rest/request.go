@@ -5,6 +5,7 @@ import (
 	"github.com/pkg/errors"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/denismitr/auditbase/internal/model"
 	"github.com/labstack/echo"
@@ -17,12 +18,64 @@ func extractIDParamFrom(ctx echo.Context) (model.ID, error) {
 		return 0, errors.Wrapf(err, "invalid numeric ID value [%s]", id)
 	}
 	if numericID <= 0 {
-		return 0, errors.Wrapf(err, "numeric ID must be positive, instead got [%s]", id)
+		return 0, errors.Errorf("numeric ID must be positive, instead got [%s]", id)
 	}
 
 	return model.ID(numericID), nil
 }
 
+// intQueryParam - positive integer query parameter not greater than max, def when it is missing
+func intQueryParam(ctx echo.Context, name string, def, max int) (int, error) {
+	v := ctx.QueryParam(name)
+	if v == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 || n > max {
+		return 0, errors.Errorf("%s must be an integer between 1 and %d", name, max)
+	}
+
+	return n, nil
+}
+
+const dateFormat = "2006-01-02"
+
+// parseTimeParam - parses time query parameter given in default time format,
+// RFC 3339 or as a date, a date means the end of that day
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(model.DefaultTimeFormat, value); err == nil {
+		return t, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+
+	if t, err := time.Parse(dateFormat, value); err == nil {
+		return t.Add(24*time.Hour - time.Second), nil
+	}
+
+	return time.Time{}, errors.Errorf(
+		"invalid time value [%s], expected %s, %s or RFC 3339",
+		value,
+		model.DefaultTimeFormat,
+		dateFormat,
+	)
+}
+
+// parseTimeRangeParam - parses time range end given as parseTimeParam accepts it,
+// except that a date the range starts from means the beginning of that day
+func parseTimeRangeParam(value string, start bool) (time.Time, error) {
+	if start {
+		if t, err := time.Parse(dateFormat, value); err == nil {
+			return t, nil
+		}
+	}
+
+	return parseTimeParam(value)
+}
+
 func interfaceToStringPointer(value interface{}) *string {
 	var out string
 
@@ -3,6 +3,7 @@ package rest
 import (
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestInterfaceToPointer(t *testing.T) {
@@ -63,3 +64,29 @@ func TestInterfaceToPointer(t *testing.T) {
 		})
 	}
 }
+
+func TestParseTimeParam(t *testing.T) {
+	tt := []struct {
+		in  string
+		out time.Time
+		err bool
+	}{
+		{in: "2021-03-03 10:15:00", out: time.Date(2021, 3, 3, 10, 15, 0, 0, time.UTC)},
+		{in: "2021-03-03T10:15:00+02:00", out: time.Date(2021, 3, 3, 8, 15, 0, 0, time.UTC)},
+		{in: "2021-03-03", out: time.Date(2021, 3, 3, 23, 59, 59, 0, time.UTC)},
+		{in: "March 3rd", err: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.in, func(t *testing.T) {
+			out, err := parseTimeParam(tc.in)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.True(t, tc.out.Equal(out), out.String())
+		})
+	}
+}
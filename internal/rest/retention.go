@@ -0,0 +1,91 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/service"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+type retentionController struct {
+	lg        logger.Logger
+	retention service.RetentionService
+}
+
+func newRetentionController(lg logger.Logger, retention service.RetentionService) *retentionController {
+	return &retentionController{
+		lg:        lg,
+		retention: retention,
+	}
+}
+
+func (rc *retentionController) index(rCtx echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rules, err := rc.retention.Rules(ctx)
+	if err != nil {
+		rc.lg.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	return rCtx.JSON(200, itemResource{
+		Data: rules,
+	})
+}
+
+// create - adds a rule, it is enforced by the next run of the cleaner
+func (rc *retentionController) create(rCtx echo.Context) error {
+	rule := new(model.RetentionRule)
+	if err := rCtx.Bind(rule); err != nil {
+		return rCtx.JSON(badRequest(errors.Wrap(err, "could not parse request payload")))
+	}
+
+	if errs := rule.Validate(); errs.NotEmpty() {
+		return rCtx.JSON(validationFailed(errs.All()...))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	created, err := rc.retention.CreateRule(ctx, rule)
+	if err != nil {
+		if errors.Cause(err) == service.ErrRetentionRuleExists {
+			return rCtx.JSON(badRequest(err))
+		}
+
+		rc.lg.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	return rCtx.JSON(201, itemResource{
+		Data: created,
+	})
+}
+
+func (rc *retentionController) delete(rCtx echo.Context) error {
+	ID, err := extractIDParamFrom(rCtx)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := rc.retention.DeleteRule(ctx, ID); err != nil {
+		if errors.Cause(err) == db.ErrNotFound {
+			return rCtx.JSON(notFound(errors.Errorf("retention rule with ID %d not found", ID)))
+		}
+
+		rc.lg.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	return rCtx.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,72 @@
+package rest
+
+import (
+	"context"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/service"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+type signingController struct {
+	lg      logger.Logger
+	signing service.SigningService
+}
+
+func newSigningController(lg logger.Logger, signing service.SigningService) *signingController {
+	return &signingController{
+		lg:      lg,
+		signing: signing,
+	}
+}
+
+type rotateSigningSecretRequest struct {
+	GraceSeconds *int `json:"graceSeconds"`
+}
+
+// rotate - issues a new signing secret of the microservice, the secret itself is only
+// returned in this response, the previous one keeps working for the grace window
+func (sc *signingController) rotate(rCtx echo.Context) error {
+	ID, err := extractIDParamFrom(rCtx)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	req := new(rotateSigningSecretRequest)
+	if rCtx.Request().ContentLength != 0 {
+		if err := rCtx.Bind(req); err != nil {
+			return rCtx.JSON(badRequest(errors.Wrap(err, "could not parse request payload")))
+		}
+	}
+
+	grace := model.DefaultSigningGrace
+	if req.GraceSeconds != nil {
+		grace = time.Duration(*req.GraceSeconds) * time.Second
+		if grace < 0 || grace > model.MaxSigningGrace {
+			return rCtx.JSON(badRequest(errors.Errorf(
+				"graceSeconds must be between 0 and %d", int(model.MaxSigningGrace.Seconds()),
+			)))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	issued, err := sc.signing.RotateSecret(ctx, ID, grace)
+	if err != nil {
+		if errors.Cause(err) == db.ErrNotFound {
+			return rCtx.JSON(notFound(errors.Errorf("microservice with ID %d not found", ID)))
+		}
+
+		sc.lg.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	return rCtx.JSON(200, itemResource{
+		Data: issued,
+	})
+}
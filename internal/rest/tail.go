@@ -0,0 +1,322 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/service"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+const (
+	// tailBuffer - number of events a stream may fall behind by before it is dropped
+	tailBuffer = 256
+	// tailBatch - most actions selected at once, both live and replayed
+	tailBatch = 100
+	// tailReplayLimit - most actions replayed to a client resuming the stream
+	tailReplayLimit = 1000
+	tailKeepAlive   = 15 * time.Second
+)
+
+// actionTail - fans the action events the back-office receives out to the streams following them.
+// A stream falling behind is dropped, its client reconnects and catches up from the storage
+type actionTail struct {
+	mu        sync.Mutex
+	followers map[chan *model.ActionEvent]struct{}
+}
+
+func newActionTail() *actionTail {
+	return &actionTail{followers: make(map[chan *model.ActionEvent]struct{})}
+}
+
+// follow - events published from now on and the func to stop following them,
+// the channel is closed when the follower is dropped
+func (t *actionTail) follow() (<-chan *model.ActionEvent, func()) {
+	ch := make(chan *model.ActionEvent, tailBuffer)
+
+	t.mu.Lock()
+	t.followers[ch] = struct{}{}
+	t.mu.Unlock()
+
+	return ch, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		if _, ok := t.followers[ch]; ok {
+			delete(t.followers, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish - hands the event to every follower, never blocks
+func (t *actionTail) publish(e *model.ActionEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for ch := range t.followers {
+		select {
+		case ch <- e:
+		default:
+			delete(t.followers, ch)
+			close(ch)
+		}
+	}
+}
+
+type tailController struct {
+	lg      logger.Logger
+	actions service.ActionService
+	tail    *actionTail
+}
+
+func newTailController(lg logger.Logger, actions service.ActionService, tail *actionTail) *tailController {
+	return &tailController{
+		lg:      lg,
+		actions: actions,
+		tail:    tail,
+	}
+}
+
+// stream - Server-Sent Events of the actions matching the filters of the index as they are
+// created or updated. Created events carry the action ID as the event ID, so that a client
+// resuming with Last-Event-ID is first sent the actions created since then. Actions commit
+// out of ID order when several consumers write them, so live creations are only checked
+// against the ones replayed, never against the highest ID sent
+func (tc *tailController) stream(rCtx echo.Context) error {
+	q := rCtx.Request().URL.Query()
+	services := scope(rCtx)
+
+	filter := func() (*db.Filter, error) {
+		f, err := createActionFilter(q)
+		if err != nil {
+			return nil, err
+		}
+
+		// users scoped to some services only follow actions of those services
+		return f.ByServices(services), nil
+	}
+
+	if _, err := filter(); err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	lastID, err := lastEventID(rCtx.Request())
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	events, unfollow := tc.tail.follow()
+	defer unfollow()
+
+	res := rCtx.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	ctx := rCtx.Request().Context()
+
+	var replayed map[model.ID]bool
+	if lastID > 0 {
+		if replayed, err = tc.replay(ctx, rCtx, filter, lastID); err != nil {
+			tc.lg.Error(err)
+			return nil
+		}
+	}
+
+	keepAlive := time.NewTicker(tailKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+
+			batch, more := []*model.ActionEvent{e}, true
+			for more && len(batch) < tailBatch {
+				select {
+				case e, ok := <-events:
+					if ok {
+						batch = append(batch, e)
+					} else {
+						more = false
+					}
+				default:
+					more = false
+				}
+			}
+
+			if err := tc.deliver(ctx, rCtx, filter, batch, replayed); err != nil {
+				tc.lg.Error(err)
+				return nil
+			}
+		case <-keepAlive.C:
+			if _, err := res.Write([]byte(": keep-alive\n\n")); err != nil {
+				return nil
+			}
+
+			res.Flush()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// replay - actions matching the filters created after the given one, oldest first,
+// returns the IDs of the actions the client has been sent
+func (tc *tailController) replay(
+	ctx context.Context,
+	rCtx echo.Context,
+	filter func() (*db.Filter, error),
+	afterID model.ID,
+) (map[model.ID]bool, error) {
+	c := &db.Cursor{Page: 1, PerPage: tailBatch, Sort: db.NewSort(nil).Add("registered_at", db.ASCOrder), SkipTotal: true}
+	sent := make(map[model.ID]bool)
+
+	for replayed := 0; replayed < tailReplayLimit; {
+		f, err := filter()
+		if err != nil {
+			return sent, err
+		}
+
+		f.Where("id", db.GteOperator, strconv.FormatInt(afterID.Int64()+1, 10))
+
+		actions, err := tc.actions.Select(ctx, c, f)
+		if err != nil {
+			return sent, errors.Wrap(err, "could not replay actions to the stream")
+		}
+
+		for i := range actions.Items {
+			if err := writeActionEvent(rCtx, model.ActionCreated, &actions.Items[i]); err != nil {
+				return sent, err
+			}
+
+			sent[actions.Items[i].ID] = true
+		}
+
+		replayed += len(actions.Items)
+		if actions.Meta.Next == "" {
+			break
+		}
+
+		last := actions.Items[len(actions.Items)-1]
+		c.After = &db.Keyset{At: last.RegisteredAt.Time, ID: last.ID}
+	}
+
+	return sent, nil
+}
+
+// deliver - the actions of the events that match the filters as they are now, creations
+// the client has already been sent while catching up are skipped once
+func (tc *tailController) deliver(
+	ctx context.Context,
+	rCtx echo.Context,
+	filter func() (*db.Filter, error),
+	batch []*model.ActionEvent,
+	replayed map[model.ID]bool,
+) error {
+	var IDs []string
+	seen := make(map[model.ID]bool)
+	for _, e := range batch {
+		if !seen[e.ActionID] {
+			seen[e.ActionID] = true
+			IDs = append(IDs, strconv.FormatInt(e.ActionID.Int64(), 10))
+		}
+	}
+
+	f, err := filter()
+	if err != nil {
+		return err
+	}
+
+	f.Where("id", db.InOperator, IDs...)
+
+	c := &db.Cursor{Page: 1, PerPage: uint(len(IDs)), Sort: db.NewSort(nil), SkipTotal: true}
+	actions, err := tc.actions.Select(ctx, c, f)
+	if err != nil {
+		return errors.Wrap(err, "could not select streamed actions")
+	}
+
+	found := make(map[model.ID]*model.Action, len(actions.Items))
+	for i := range actions.Items {
+		found[actions.Items[i].ID] = &actions.Items[i]
+	}
+
+	for _, e := range batch {
+		if e.Type == model.ActionCreated && replayed[e.ActionID] {
+			delete(replayed, e.ActionID)
+			continue
+		}
+
+		action, ok := found[e.ActionID]
+		if !ok {
+			continue
+		}
+
+		if err := writeActionEvent(rCtx, e.Type, action); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeActionEvent - writes the action as a Server-Sent Event named after the event type,
+// only created events have an ID, so that updates of older actions do not move the client back
+func writeActionEvent(rCtx echo.Context, t model.ActionEventType, action *model.Action) error {
+	data, err := json.Marshal(action)
+	if err != nil {
+		return errors.Wrapf(err, "could not convert action %d to json bytes", action.ID)
+	}
+
+	var b bytes.Buffer
+	if t == model.ActionCreated {
+		_, _ = fmt.Fprintf(&b, "id: %d\n", action.ID)
+	}
+
+	_, _ = fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", t, data)
+
+	res := rCtx.Response()
+	if _, err := res.Write(b.Bytes()); err != nil {
+		return errors.Wrap(err, "could not write action to the stream")
+	}
+
+	res.Flush()
+	accessed(rCtx, model.AccessedAction, action.ID)
+
+	return nil
+}
+
+// lastEventID - ID of the last event the client has seen, sent by EventSource in the Last-Event-ID
+// header when it reconnects, or given as lastEventId parameter on the first connection
+func lastEventID(r *http.Request) (model.ID, error) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("lastEventId")
+	}
+
+	if v == "" {
+		return 0, nil
+	}
+
+	ID, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ID < 0 {
+		return 0, errors.Errorf("last event ID must be an action ID, %q given", v)
+	}
+
+	return model.ID(ID), nil
+}
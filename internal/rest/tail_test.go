@@ -0,0 +1,149 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/service"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeActions - selects actions by the id conditions of the filter only
+type fakeActions struct {
+	service.ActionService
+	actions []model.Action
+}
+
+func (f *fakeActions) Select(ctx context.Context, c *db.Cursor, fl *db.Filter) (*model.ActionCollection, error) {
+	result := &model.ActionCollection{}
+
+	for _, a := range f.actions {
+		matches := true
+		for _, cond := range fl.Conditions("id") {
+			switch cond.Operator {
+			case db.GteOperator:
+				min, _ := strconv.Atoi(cond.Value())
+				matches = matches && a.ID >= model.ID(min)
+			case db.InOperator:
+				matches = matches && strings.Contains(","+strings.Join(cond.Values, ",")+",", ","+strconv.Itoa(int(a.ID))+",")
+			}
+		}
+
+		if matches {
+			result.Items = append(result.Items, a)
+		}
+	}
+
+	return result, nil
+}
+
+func TestActionTail(t *testing.T) {
+	t.Run("followers falling behind are dropped", func(t *testing.T) {
+		tail := newActionTail()
+
+		slow, unfollowSlow := tail.follow()
+		defer unfollowSlow()
+
+		for i := 1; i <= tailBuffer+1; i++ {
+			tail.publish(&model.ActionEvent{Type: model.ActionCreated, ActionID: model.ID(i)})
+		}
+
+		n := 0
+		for range slow {
+			n++
+		}
+
+		assert.Equal(t, tailBuffer, n)
+
+		fast, unfollowFast := tail.follow()
+		defer unfollowFast()
+
+		tail.publish(&model.ActionEvent{Type: model.ActionUpdated, ActionID: 1})
+		assert.Equal(t, model.ActionUpdated, (<-fast).Type)
+	})
+}
+
+func TestTailController_Stream(t *testing.T) {
+	actions := &fakeActions{}
+	for i := 1; i <= 5; i++ {
+		actions.actions = append(actions.actions, model.Action{ID: model.ID(i), Name: "invoice_paid"})
+	}
+
+	tail := newActionTail()
+	controller := newTailController(logger.NewStdoutLogger(logger.Prod, "test"), actions, tail)
+
+	e := echo.New()
+	e.GET("/api/v1/actions/stream", controller.stream)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/actions/stream", nil).WithContext(ctx)
+	req.Header.Set("Last-Event-ID", "3")
+	rec := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		e.ServeHTTP(rec, req)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		tail.mu.Lock()
+		defer tail.mu.Unlock()
+		return len(tail.followers) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// the creation of 4 has been replayed already, 9 does not exist,
+	// 2 committed after 4 and 5 were replayed
+	tail.publish(&model.ActionEvent{Type: model.ActionCreated, ActionID: 4})
+	tail.publish(&model.ActionEvent{Type: model.ActionCreated, ActionID: 2})
+	tail.publish(&model.ActionEvent{Type: model.ActionUpdated, ActionID: 2})
+	tail.publish(&model.ActionEvent{Type: model.ActionCreated, ActionID: 9})
+
+	assert.Eventually(t, func() bool {
+		tail.mu.Lock()
+		defer tail.mu.Unlock()
+		for ch := range tail.followers {
+			return len(ch) == 0
+		}
+		return false
+	}, 2*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+
+	assert.Equal(t, "text/event-stream", rec.Header().Get(echo.HeaderContentType))
+
+	body := rec.Body.String()
+	assert.Contains(t, body, "id: 4\nevent: created\n")
+	assert.Contains(t, body, "id: 5\nevent: created\n")
+	assert.Contains(t, body, "event: updated\ndata: {\"id\":2")
+	assert.NotContains(t, body, "id: 3\n")
+	assert.Contains(t, body, "id: 2\nevent: created\n")
+	assert.Equal(t, 1, strings.Count(body, "id: 4\n"))
+	assert.Less(t, strings.Index(body, "id: 5\n"), strings.Index(body, "event: updated"))
+}
+
+func TestLastEventID(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/actions/stream?lastEventId=7", nil)
+	ID, err := lastEventID(req)
+	assert.NoError(t, err)
+	assert.Equal(t, model.ID(7), ID)
+
+	req.Header.Set("Last-Event-ID", "12")
+	ID, err = lastEventID(req)
+	assert.NoError(t, err)
+	assert.Equal(t, model.ID(12), ID)
+
+	req.Header.Set("Last-Event-ID", "abc")
+	_, err = lastEventID(req)
+	assert.Error(t, err)
+}
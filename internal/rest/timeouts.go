@@ -0,0 +1,141 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/service"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+type timeoutsController struct {
+	lg       logger.Logger
+	timeouts service.TimeoutService
+}
+
+func newTimeoutsController(lg logger.Logger, timeouts service.TimeoutService) *timeoutsController {
+	return &timeoutsController{
+		lg:       lg,
+		timeouts: timeouts,
+	}
+}
+
+func (tc *timeoutsController) index(rCtx echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rules, err := tc.timeouts.Rules(ctx)
+	if err != nil {
+		tc.lg.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	return rCtx.JSON(200, itemResource{
+		Data: rules,
+	})
+}
+
+// create - adds a rule, it is applied by the next sweep of the watchdog
+func (tc *timeoutsController) create(rCtx echo.Context) error {
+	rule := new(model.TimeoutRule)
+	if err := rCtx.Bind(rule); err != nil {
+		return rCtx.JSON(badRequest(errors.Wrap(err, "could not parse request payload")))
+	}
+
+	if errs := rule.Validate(); errs.NotEmpty() {
+		return rCtx.JSON(validationFailed(errs.All()...))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	created, err := tc.timeouts.CreateRule(ctx, rule)
+	if err != nil {
+		if errors.Cause(err) == service.ErrTimeoutRuleExists {
+			return rCtx.JSON(badRequest(err))
+		}
+
+		tc.lg.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	return rCtx.JSON(201, itemResource{
+		Data: created,
+	})
+}
+
+func (tc *timeoutsController) delete(rCtx echo.Context) error {
+	ID, err := extractIDParamFrom(rCtx)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := tc.timeouts.DeleteRule(ctx, ID); err != nil {
+		if errors.Cause(err) == db.ErrNotFound {
+			return rCtx.JSON(notFound(errors.Errorf("timeout rule with ID %d not found", ID)))
+		}
+
+		tc.lg.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	return rCtx.NoContent(http.StatusNoContent)
+}
+
+// sweeps - sweeps of the watchdog that timed out actions, latest first, scoped tokens
+// only see the sweeps that timed out actions of their services
+func (tc *timeoutsController) sweeps(rCtx echo.Context) error {
+	c := createCursor(rCtx.Request().URL.Query(), 50, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sweeps, err := tc.timeouts.Sweeps(ctx, c, scope(rCtx))
+	if err != nil {
+		tc.lg.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	return rCtx.JSON(200, collectionResource{
+		Data: sweeps.Items,
+		Meta: sweeps.Meta,
+	})
+}
+
+// sweep - the sweep along with the actions it timed out, scoped tokens
+// only see the actions of their services
+func (tc *timeoutsController) sweep(rCtx echo.Context) error {
+	ID, err := extractIDParamFrom(rCtx)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	sweep, err := tc.timeouts.Sweep(ctx, ID, scope(rCtx))
+	if err != nil {
+		if errors.Cause(err) == db.ErrNotFound {
+			return rCtx.JSON(notFound(errors.Errorf("timeout sweep with ID %d not found", ID)))
+		}
+
+		tc.lg.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	for i := range sweep.Timeouts {
+		accessed(rCtx, model.AccessedAction, sweep.Timeouts[i].ActionID)
+	}
+
+	return rCtx.JSON(200, itemResource{
+		Data: sweep,
+	})
+}
@@ -0,0 +1,209 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/service"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+type webhooksController struct {
+	lg       logger.Logger
+	webhooks service.WebhookService
+}
+
+func newWebhooksController(lg logger.Logger, webhooks service.WebhookService) *webhooksController {
+	return &webhooksController{
+		lg:       lg,
+		webhooks: webhooks,
+	}
+}
+
+// webhookRuleRequest - rules are enabled unless told otherwise
+type webhookRuleRequest struct {
+	model.WebhookRule
+	Enabled *bool `json:"enabled"`
+}
+
+func (wc *webhooksController) index(rCtx echo.Context) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rules, err := wc.webhooks.Rules(ctx)
+	if err != nil {
+		wc.lg.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	return rCtx.JSON(200, itemResource{
+		Data: rules,
+	})
+}
+
+func (wc *webhooksController) show(rCtx echo.Context) error {
+	ID, err := extractIDParamFrom(rCtx)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rule, err := wc.webhooks.Rule(ctx, ID)
+	if err != nil {
+		return wc.ruleError(rCtx, ID, err)
+	}
+
+	return rCtx.JSON(200, itemResource{
+		Data: rule,
+	})
+}
+
+// create - adds a rule, the secret its deliveries are signed with is only returned now
+func (wc *webhooksController) create(rCtx echo.Context) error {
+	rule, err := bindWebhookRule(rCtx)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	if errs := rule.Validate(); errs.NotEmpty() {
+		return rCtx.JSON(validationFailed(errs.All()...))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	issued, err := wc.webhooks.CreateRule(ctx, rule)
+	if err != nil {
+		wc.lg.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	return rCtx.JSON(201, itemResource{
+		Data: issued,
+	})
+}
+
+// update - replaces the rule, its secret is kept
+func (wc *webhooksController) update(rCtx echo.Context) error {
+	ID, err := extractIDParamFrom(rCtx)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	rule, err := bindWebhookRule(rCtx)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	if errs := rule.Validate(); errs.NotEmpty() {
+		return rCtx.JSON(validationFailed(errs.All()...))
+	}
+
+	rule.ID = ID
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	updated, err := wc.webhooks.UpdateRule(ctx, rule)
+	if err != nil {
+		return wc.ruleError(rCtx, ID, err)
+	}
+
+	return rCtx.JSON(200, itemResource{
+		Data: updated,
+	})
+}
+
+func (wc *webhooksController) delete(rCtx echo.Context) error {
+	ID, err := extractIDParamFrom(rCtx)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := wc.webhooks.DeleteRule(ctx, ID); err != nil {
+		return wc.ruleError(rCtx, ID, err)
+	}
+
+	return rCtx.NoContent(http.StatusNoContent)
+}
+
+// deliveries - the delivery log, latest deliveries first, filtered by ruleId, actionId and state
+func (wc *webhooksController) deliveries(rCtx echo.Context) error {
+	q := rCtx.Request().URL.Query()
+
+	f := createFilter(q, []string{"ruleId", "actionId", "state"})
+	c := createCursor(q, 50, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	deliveries, err := wc.webhooks.Deliveries(ctx, c, f)
+	if err != nil {
+		wc.lg.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	return rCtx.JSON(200, collectionResource{
+		Data: deliveries.Items,
+		Meta: deliveries.Meta,
+	})
+}
+
+// retry - a delivery given up on is made again by the dispatcher
+func (wc *webhooksController) retry(rCtx echo.Context) error {
+	ID, err := extractIDParamFrom(rCtx)
+	if err != nil {
+		return rCtx.JSON(badRequest(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	d, err := wc.webhooks.RetryDelivery(ctx, ID)
+	if err != nil {
+		switch errors.Cause(err) {
+		case db.ErrNotFound:
+			return rCtx.JSON(notFound(errors.Errorf("webhook delivery with ID %d not found", ID)))
+		case service.ErrWebhookDeliveryNotFailed:
+			return rCtx.JSON(badRequest(err))
+		}
+
+		wc.lg.Error(err)
+		return rCtx.JSON(internalError(err))
+	}
+
+	return rCtx.JSON(200, itemResource{
+		Data: d,
+	})
+}
+
+func (wc *webhooksController) ruleError(rCtx echo.Context, ID model.ID, err error) error {
+	if errors.Cause(err) == db.ErrNotFound {
+		return rCtx.JSON(notFound(errors.Errorf("webhook rule with ID %d not found", ID)))
+	}
+
+	wc.lg.Error(err)
+	return rCtx.JSON(internalError(err))
+}
+
+func bindWebhookRule(rCtx echo.Context) (*model.WebhookRule, error) {
+	req := new(webhookRuleRequest)
+	if err := rCtx.Bind(req); err != nil {
+		return nil, errors.Wrap(err, "could not parse request payload")
+	}
+
+	rule := req.WebhookRule
+	rule.Enabled = req.Enabled == nil || *req.Enabled
+
+	return &rule, nil
+}
@@ -0,0 +1,51 @@
+package search
+
+// Matches - whether the value at the path of the condition within the decoded JSON document
+// compares with the value of the condition as the search does. Missing values equal null,
+// only numbers are ordered, values of different types are never equal
+func (c Condition) Matches(document interface{}) bool {
+	actual := document
+	for _, k := range c.Path {
+		m, ok := actual.(map[string]interface{})
+		if !ok {
+			actual = nil
+			break
+		}
+
+		actual = m[k]
+	}
+
+	if c.Value == nil {
+		return (actual == nil) == (c.Operator == Eq)
+	}
+
+	switch c.Value.(type) {
+	case string, float64, bool:
+	default:
+		return false
+	}
+
+	if actual == nil {
+		return false
+	}
+
+	a, aIsNumber := actual.(float64)
+	e, eIsNumber := c.Value.(float64)
+
+	switch c.Operator {
+	case Eq:
+		return actual == c.Value
+	case Neq:
+		return actual != c.Value
+	case Gt:
+		return aIsNumber && eIsNumber && a > e
+	case Gte:
+		return aIsNumber && eIsNumber && a >= e
+	case Lt:
+		return aIsNumber && eIsNumber && a < e
+	case Lte:
+		return aIsNumber && eIsNumber && a <= e
+	default:
+		return false
+	}
+}
@@ -0,0 +1,40 @@
+package search
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCondition_Matches(t *testing.T) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(`{"order":{"id":48213,"state":"paid","gift":false}}`), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		query   string
+		matches bool
+	}{
+		{query: "details.order.id = 48213", matches: true},
+		{query: "details.order.id >= 48214", matches: false},
+		{query: "details.order.id < 50000", matches: true},
+		{query: "details.order.state = paid", matches: true},
+		{query: "details.order.state > 1", matches: false},
+		{query: "details.order.gift != true", matches: true},
+		{query: "details.order.coupon = null", matches: true},
+		{query: "details.order.coupon != null", matches: false},
+		{query: "details.order.id = '48213'", matches: false},
+		{query: "details.order.id.x = 1", matches: false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.query, func(t *testing.T) {
+			q, err := Parse(tc.query)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.matches, q.Conditions[0].Matches(doc))
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package search
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/denismitr/auditbase/internal/utils/errtype"
+	"github.com/pkg/errors"
+)
+
+const ErrInvalidQuery = errtype.StringError("invalid search query")
+
+// MaxTerms - conditions and words a query can have at most
+const MaxTerms = 10
+
+// Operator - comparison of a JSON value with the value of a condition
+type Operator string
+
+const (
+	Eq  Operator = "="
+	Neq Operator = "!="
+	Gt  Operator = ">"
+	Gte Operator = ">="
+	Lt  Operator = "<"
+	Lte Operator = "<="
+)
+
+// Source - JSON value a condition compares
+type Source string
+
+const (
+	// Details - value at the path within action details
+	Details Source = "details"
+	// DeltaFrom - value of the property before the action
+	DeltaFrom Source = "from"
+	// DeltaTo - value of the property after the action
+	DeltaTo Source = "to"
+)
+
+var keyRegex = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+
+// Condition - comparison of a JSON value of actions with a string, a number, a boolean or null
+type Condition struct {
+	Source Source
+	// Path - keys leading to the value within details, for delta sources the property name only
+	Path     []string
+	Operator Operator
+	// Value - string, float64, bool or nil
+	Value interface{}
+}
+
+// Property - name of the property compared by a delta condition
+func (c Condition) Property() string {
+	return c.Path[0]
+}
+
+// Query - conditions and words all searched actions must match,
+// words are looked up in the text of action details
+type Query struct {
+	Conditions []Condition
+	Words      []string
+}
+
+// Parse - parses a query of space separated terms, all of which have to match, the optional
+// and between them is ignored. A term is either a comparison
+//
+//	details.order.id = 48213
+//	delta.price.to > 100
+//
+// or a word or a quoted phrase mentioned in details. Values are numbers, true, false, null
+// or strings, which have to be quoted when they contain spaces or operators.
+// Only = and != compare strings, booleans and null
+func Parse(s string) (*Query, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+
+	q := Query{}
+
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+
+		if t.kind == operatorToken {
+			return nil, errors.Wrapf(ErrInvalidQuery, "unexpected %s at %d", t.text, t.pos)
+		}
+
+		if t.kind == wordToken && strings.EqualFold(t.text, "and") {
+			continue
+		}
+
+		if i+1 < len(tokens) && tokens[i+1].kind == operatorToken {
+			if i+2 >= len(tokens) || tokens[i+2].kind == operatorToken {
+				return nil, errors.Wrapf(ErrInvalidQuery, "value expected after %s at %d", tokens[i+1].text, tokens[i+1].pos)
+			}
+
+			c, err := condition(t, tokens[i+1], tokens[i+2])
+			if err != nil {
+				return nil, err
+			}
+
+			q.Conditions = append(q.Conditions, *c)
+			i += 2
+			continue
+		}
+
+		q.Words = append(q.Words, t.text)
+	}
+
+	if len(q.Conditions)+len(q.Words) == 0 {
+		return nil, errors.Wrap(ErrInvalidQuery, "query is empty")
+	}
+
+	if len(q.Conditions)+len(q.Words) > MaxTerms {
+		return nil, errors.Wrapf(ErrInvalidQuery, "query cannot have more than %d terms", MaxTerms)
+	}
+
+	return &q, nil
+}
+
+func condition(path, op, value token) (*Condition, error) {
+	if path.kind != wordToken {
+		return nil, errors.Wrapf(ErrInvalidQuery, "quoted %s at %d cannot be compared", path.text, path.pos)
+	}
+
+	keys := strings.Split(path.text, ".")
+	for _, k := range keys {
+		if !keyRegex.MatchString(k) {
+			return nil, errors.Wrapf(ErrInvalidQuery, "invalid key %q in %s at %d", k, path.text, path.pos)
+		}
+	}
+
+	c := Condition{Operator: Operator(op.text)}
+
+	switch {
+	case keys[0] == string(Details) && len(keys) > 1:
+		c.Source, c.Path = Details, keys[1:]
+	case keys[0] == "delta" && len(keys) == 3 && (keys[2] == string(DeltaFrom) || keys[2] == string(DeltaTo)):
+		c.Source, c.Path = Source(keys[2]), keys[1:2]
+	default:
+		return nil, errors.Wrapf(
+			ErrInvalidQuery,
+			"%s at %d is neither details.<path> nor delta.<property>.from or .to",
+			path.text,
+			path.pos,
+		)
+	}
+
+	c.Value = value.text
+	if value.kind == wordToken {
+		switch value.text {
+		case "true":
+			c.Value = true
+		case "false":
+			c.Value = false
+		case "null":
+			c.Value = nil
+		default:
+			if n, err := strconv.ParseFloat(value.text, 64); err == nil {
+				c.Value = n
+			}
+		}
+	}
+
+	if _, isNumber := c.Value.(float64); !isNumber && c.Operator != Eq && c.Operator != Neq {
+		return nil, errors.Wrapf(ErrInvalidQuery, "%s at %d compares numbers only", c.Operator, op.pos)
+	}
+
+	return &c, nil
+}
+
+type tokenKind int
+
+const (
+	wordToken tokenKind = iota
+	quotedToken
+	operatorToken
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	runes := []rune(s)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			i++
+		case r == '"' || r == '\'':
+			var b strings.Builder
+			start := i
+			i++
+
+			for ; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				b.WriteRune(runes[i])
+			}
+
+			if i >= len(runes) {
+				return nil, errors.Wrapf(ErrInvalidQuery, "unterminated quote at %d", start)
+			}
+
+			tokens = append(tokens, token{kind: quotedToken, text: b.String(), pos: start})
+			i++
+		case isOperatorRune(r):
+			start := i
+			for i < len(runes) && isOperatorRune(runes[i]) {
+				i++
+			}
+
+			op := string(runes[start:i])
+			switch Operator(op) {
+			case Eq, Neq, Gt, Gte, Lt, Lte:
+				tokens = append(tokens, token{kind: operatorToken, text: op, pos: start})
+			default:
+				return nil, errors.Wrapf(ErrInvalidQuery, "unknown operator %s at %d", op, start)
+			}
+		default:
+			start := i
+			for i < len(runes) && !isOperatorRune(runes[i]) && !strings.ContainsRune(" \t\n\r\"'", runes[i]) {
+				i++
+			}
+
+			tokens = append(tokens, token{kind: wordToken, text: string(runes[start:i]), pos: start})
+		}
+	}
+
+	return tokens, nil
+}
+
+func isOperatorRune(r rune) bool {
+	return r == '=' || r == '!' || r == '>' || r == '<'
+}
@@ -0,0 +1,81 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tt := []struct {
+		query      string
+		conditions []Condition
+		words      []string
+	}{
+		{
+			query: "48213",
+			words: []string{"48213"},
+		},
+		{
+			query:      `details.order.id = 48213 and "paid in full"`,
+			conditions: []Condition{{Source: Details, Path: []string{"order", "id"}, Operator: Eq, Value: float64(48213)}},
+			words:      []string{"paid in full"},
+		},
+		{
+			query: "delta.price.to>100 delta.price.from<=99.5",
+			conditions: []Condition{
+				{Source: DeltaTo, Path: []string{"price"}, Operator: Gt, Value: float64(100)},
+				{Source: DeltaFrom, Path: []string{"price"}, Operator: Lte, Value: 99.5},
+			},
+		},
+		{
+			query: `details.status != 'on hold' details.paid = true details.note = null details.currency = EUR`,
+			conditions: []Condition{
+				{Source: Details, Path: []string{"status"}, Operator: Neq, Value: "on hold"},
+				{Source: Details, Path: []string{"paid"}, Operator: Eq, Value: true},
+				{Source: Details, Path: []string{"note"}, Operator: Eq, Value: nil},
+				{Source: Details, Path: []string{"currency"}, Operator: Eq, Value: "EUR"},
+			},
+		},
+		{
+			query:      `details.id = "48213"`,
+			conditions: []Condition{{Source: Details, Path: []string{"id"}, Operator: Eq, Value: "48213"}},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.query, func(t *testing.T) {
+			q, err := Parse(tc.query)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			assert.Equal(t, tc.conditions, q.Conditions)
+			assert.Equal(t, tc.words, q.Words)
+		})
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, query := range []string{
+		"",
+		"  and ",
+		"details.id =",
+		"= 5",
+		"details.id => 5",
+		"details.name > 'abc'",
+		"details = 5",
+		"delta.price = 5",
+		"delta.price.before = 5",
+		"actor.id = 5",
+		"details.a$b = 5",
+		`"unterminated`,
+		"a b c d e f g h i j k",
+	} {
+		t.Run(query, func(t *testing.T) {
+			_, err := Parse(query)
+			assert.Equal(t, ErrInvalidQuery, errors.Cause(err))
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+)
+
+type AccessLogService interface {
+	Record(ctx context.Context, entry *model.AccessLogEntry) error
+	Select(ctx context.Context, c *db.Cursor, f *db.Filter) (*model.AccessLogCollection, error)
+}
+
+var _ AccessLogService = (*BaseAccessLogService)(nil)
+
+type BaseAccessLogService struct {
+	db db.Database
+	lg logger.Logger
+}
+
+func NewAccessLogService(db db.Database, lg logger.Logger) *BaseAccessLogService {
+	return &BaseAccessLogService{
+		db: db,
+		lg: lg,
+	}
+}
+
+// Record - appends the entry to the access log
+func (s *BaseAccessLogService) Record(ctx context.Context, entry *model.AccessLogEntry) error {
+	_, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return tx.AccessLog().Create(ctx, entry)
+	})
+
+	return err
+}
+
+func (s *BaseAccessLogService) Select(
+	ctx context.Context,
+	c *db.Cursor,
+	f *db.Filter,
+) (*model.AccessLogCollection, error) {
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return tx.AccessLog().Select(ctx, c, f)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries, ok := result.(*model.AccessLogCollection)
+	if !ok {
+		panic("how could result not be of type *model.AccessLogCollection")
+	}
+
+	return entries, nil
+}
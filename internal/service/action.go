@@ -5,16 +5,27 @@ import (
 	"fmt"
 	"github.com/denismitr/auditbase/internal/db"
 	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/search"
+	"github.com/denismitr/auditbase/internal/utils/errtype"
 	"github.com/denismitr/auditbase/internal/utils/logger"
 	"github.com/pkg/errors"
+	"time"
 )
 
+const ErrActorServiceMismatch = errtype.StringError("action was not reported by the actor service of the update")
+const ErrActionTimedOut = errtype.StringError("action has timed out, its status can no longer be updated")
+
 type ActionService interface {
 	Select(context.Context, *db.Cursor, *db.Filter) (*model.ActionCollection, error)
+	Search(context.Context, *search.Query, *db.Cursor, *db.Filter) (*model.ActionCollection, error)
 	Create(context.Context, *model.NewAction) (*model.Action, error)
 	FirstByID(context.Context, model.ID) (*model.Action, error)
+	FirstVisibleByID(ctx context.Context, ID model.ID, services []string) (*model.Action, error)
 	Count(ctx context.Context) (int, error)
+	Stats(ctx context.Context, q *model.ActionStatsQuery, f *db.Filter) (*model.ActionStats, error)
 	Update(ctx context.Context, ua *model.UpdateAction) (*model.Action, error)
+	Delete(ctx context.Context, ID model.ID, services []string, at time.Time) error
+	Tree(ctx context.Context, ID model.ID, opts model.TreeOptions) (*model.ActionTree, error)
 }
 
 type BaseActionService struct {
@@ -52,6 +63,40 @@ func (s *BaseActionService) Select(ctx context.Context, c *db.Cursor, f *db.Filt
 	}
 }
 
+// Search - actions matching the search query among the actions the filter leaves
+func (s *BaseActionService) Search(ctx context.Context, q *search.Query, c *db.Cursor, f *db.Filter) (*model.ActionCollection, error) {
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return tx.Actions().Search(ctx, q, c, f)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if actions, ok := result.(*model.ActionCollection); !ok {
+		panic("how could result not be of type *model.ActionCollection")
+	} else {
+		return actions, nil
+	}
+}
+
+// Stats - time series of the numbers of actions matching the filter per interval and group
+func (s *BaseActionService) Stats(ctx context.Context, q *model.ActionStatsQuery, f *db.Filter) (*model.ActionStats, error) {
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return tx.Actions().Stats(ctx, q, f)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if counts, ok := result.([]model.ActionStatsCount); !ok {
+		panic("how could result not be of type []model.ActionStatsCount")
+	} else {
+		return model.NewActionStats(q, counts), nil
+	}
+}
+
 func (s *BaseActionService) Count(ctx context.Context) (int, error) {
 	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
 		count, err := tx.Actions().CountAll(ctx)
@@ -81,6 +126,20 @@ func (s *BaseActionService) FirstByID(ctx context.Context, ID model.ID) (*model.
 			return nil, err
 		}
 
+		delta, err := tx.Deltas().SelectByActionID(ctx, action.ID)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not join delta to action")
+		}
+
+		action.Delta = delta
+
+		counts, err := actions.CountChildren(ctx, []model.UID{action.UID})
+		if err != nil {
+			return nil, errors.Wrap(err, "could not count children of action")
+		}
+
+		action.ChildrenCount = counts[action.UID]
+
 		if !action.ParentUID.Empty() {
 			parent, err := actions.FirstByUID(ctx, action.ParentUID)
 			if err != nil {
@@ -142,6 +201,17 @@ func (s *BaseActionService) Update(ctx context.Context, ua *model.UpdateAction)
 			panic("how can update action have both uid and id empty?")
 		}
 
+		if ua.ActorService != "" {
+			if err := checkActorService(ctx, tx, action, ua.ActorService); err != nil {
+				return nil, err
+			}
+		}
+
+		// a timeout is final, its record and the timedOut event it was announced with stay true
+		if action.Status == model.TimedOut {
+			return nil, errors.Wrapf(ErrActionTimedOut, "action %d", action.ID)
+		}
+
 		if err := tx.Actions().UpdateStatus(ctx, action.ID, ua.Status); err != nil {
 			return nil, err
 		}
@@ -163,6 +233,66 @@ func (s *BaseActionService) Update(ctx context.Context, ua *model.UpdateAction)
 	return action, nil
 }
 
+// Delete - removes the action along with its deltas leaving a tombstone in its place,
+// so the hash chain stays verifiable, actions of other services are not found when scoped
+func (s *BaseActionService) Delete(ctx context.Context, ID model.ID, services []string, at time.Time) error {
+	_, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		action, err := tx.Actions().FirstByID(ctx, ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if services != nil {
+			visible, err := visibleActions(ctx, tx, []model.ID{action.ID}, services)
+			if err != nil {
+				return nil, err
+			}
+
+			if !visible[action.ID] {
+				return nil, db.ErrNotFound
+			}
+		}
+
+		return nil, tx.Actions().Prune(ctx, []model.ActionTombstone{{
+			ActionID:      action.ID,
+			UID:           action.UID,
+			Name:          action.Name,
+			PrevChainHash: action.PrevChainHash,
+			ChainHash:     action.ChainHash,
+			PrunedAt:      model.JSONTime{Time: at},
+		}})
+	})
+
+	return err
+}
+
+// checkActorService - a service may only update the actions it reported itself
+func checkActorService(ctx context.Context, tx db.Tx, action *model.Action, service string) error {
+	if action.ActorEntityID == 0 {
+		return errors.Wrapf(ErrActorServiceMismatch, "action %d has no actor", action.ID)
+	}
+
+	actor, err := tx.Entities().FirstByIDWithEntityType(ctx, action.ActorEntityID)
+	if err != nil {
+		return errors.Wrap(err, "could not get actor of action")
+	}
+
+	ms, err := tx.Microservices().FirstByName(ctx, service)
+	if err != nil {
+		if errors.Cause(err) == db.ErrNotFound {
+			return errors.Wrapf(ErrActorServiceMismatch, "service [%s] is unknown", service)
+		}
+
+		return err
+	}
+
+	if actor.EntityType == nil || actor.EntityType.ServiceID != ms.ID {
+		return errors.Wrapf(ErrActorServiceMismatch, "action %d, service [%s]", action.ID, service)
+	}
+
+	return nil
+}
+
 func (s *BaseActionService) Create(ctx context.Context, newAction *model.NewAction) (*model.Action, error) {
 	action := new(model.Action)
 
@@ -172,6 +302,7 @@ func (s *BaseActionService) Create(ctx context.Context, newAction *model.NewActi
 	action.Status = newAction.Status
 	action.IsAsync = newAction.IsAsync
 	action.Details = newAction.Details
+	action.Delta = newAction.Delta
 	action.Hash = newAction.Hash
 	action.UID = model.UID(newAction.UID)
 
@@ -226,12 +357,18 @@ func (s *BaseActionService) Create(ctx context.Context, newAction *model.NewActi
 			action.TargetEntityID = targetEntity.ID
 		}
 
-		action, err = tx.Actions().Create(ctx, action)
+		created, err := tx.Actions().Create(ctx, action)
 		if err != nil {
 			return nil, err
 		}
 
-		return action, nil
+		if err := tx.Deltas().Create(ctx, created.ID, created.TargetEntityID, action.Delta); err != nil {
+			return nil, err
+		}
+
+		created.Delta = action.Delta
+
+		return created, nil
 	})
 
 	if err != nil {
@@ -246,4 +383,314 @@ func (s *BaseActionService) Create(ctx context.Context, newAction *model.NewActi
 	return action, nil
 }
 
+// FirstVisibleByID - action as seen by a user scoped to the services, db.ErrNotFound
+// when neither its actor nor its target belongs to one of them, the parent is hidden the same way,
+// the actor or the target of another service is left out, only its ID is kept
+func (s *BaseActionService) FirstVisibleByID(ctx context.Context, ID model.ID, services []string) (*model.Action, error) {
+	action, err := s.FirstByID(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	IDs := []model.ID{action.ID}
+	if action.Parent != nil {
+		IDs = append(IDs, action.Parent.ID)
+	}
+
+	_, err = s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		visible, err := visibleActions(ctx, tx, IDs, services)
+		if err != nil {
+			return nil, err
+		}
+
+		if !visible[action.ID] {
+			return nil, db.ErrNotFound
+		}
+
+		if action.Parent != nil && !visible[action.Parent.ID] {
+			action.Parent = nil
+		}
+
+		for _, e := range []**model.Entity{&action.Actor, &action.Target} {
+			if *e == nil {
+				continue
+			}
+
+			if ok, err := entityVisible(ctx, tx, *e, services); err != nil {
+				return nil, err
+			} else if !ok {
+				*e = nil
+			}
+		}
+
+		return nil, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return action, nil
+}
+
+// Tree - ancestor chain and descendants of the action, descendants are loaded
+// level by level within depth and fan-out limits, every action is visited only once
+// so parent uid cycles cannot make the walk endless
+func (s *BaseActionService) Tree(ctx context.Context, ID model.ID, opts model.TreeOptions) (*model.ActionTree, error) {
+	opts = normalizeTreeOptions(opts)
+
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		action, err := tx.Actions().FirstByID(ctx, ID)
+		if err != nil {
+			return nil, err
+		}
 
+		tree := &model.ActionTree{
+			Ancestors:    make([]model.Action, 0),
+			StatusCounts: make(map[string]int),
+		}
+
+		visited := map[model.UID]bool{action.UID: true}
+
+		if err := loadAncestors(ctx, tx, tree, action, visited); err != nil {
+			return nil, err
+		}
+
+		root := &model.ActionNode{Action: action, Children: make([]*model.ActionNode, 0)}
+		if err := loadDescendants(ctx, tx, tree, root, opts, visited); err != nil {
+			return nil, err
+		}
+
+		tree.Root = root
+
+		if len(opts.Services) > 0 {
+			if err := scopeTree(ctx, tx, tree, opts.Services); err != nil {
+				return nil, err
+			}
+		}
+
+		tree.Aggregate()
+
+		return tree, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	tree, ok := result.(*model.ActionTree)
+	if !ok {
+		panic(fmt.Sprintf("how result could have of different type than ActionTree? %#v", result))
+	}
+
+	return tree, nil
+}
+
+func normalizeTreeOptions(opts model.TreeOptions) model.TreeOptions {
+	if opts.Depth <= 0 {
+		opts.Depth = model.DefaultTreeDepth
+	} else if opts.Depth > model.MaxTreeDepth {
+		opts.Depth = model.MaxTreeDepth
+	}
+
+	if opts.FanOut <= 0 {
+		opts.FanOut = model.DefaultTreeFanOut
+	} else if opts.FanOut > model.MaxTreeFanOut {
+		opts.FanOut = model.MaxTreeFanOut
+	}
+
+	return opts
+}
+
+// scopeTree - removes ancestors and descendants the services do not see,
+// db.ErrNotFound when the root itself is not visible
+func scopeTree(ctx context.Context, tx db.Tx, tree *model.ActionTree, services []string) error {
+	IDs := make([]model.ID, 0, len(tree.Ancestors)+1)
+	for i := range tree.Ancestors {
+		IDs = append(IDs, tree.Ancestors[i].ID)
+	}
+
+	tree.Root.Walk(func(n *model.ActionNode) {
+		IDs = append(IDs, n.Action.ID)
+	})
+
+	visible, err := visibleActions(ctx, tx, IDs, services)
+	if err != nil {
+		return err
+	}
+
+	if !visible[tree.Root.Action.ID] {
+		return db.ErrNotFound
+	}
+
+	ancestors := make([]model.Action, 0, len(tree.Ancestors))
+	for i := range tree.Ancestors {
+		if visible[tree.Ancestors[i].ID] {
+			ancestors = append(ancestors, tree.Ancestors[i])
+		}
+	}
+
+	tree.Ancestors = ancestors
+	tree.Root.Prune(func(a *model.Action) bool {
+		return visible[a.ID]
+	})
+
+	return nil
+}
+
+func visibleActions(ctx context.Context, tx db.Tx, IDs []model.ID, services []string) (map[model.ID]bool, error) {
+	ids, err := tx.Actions().SelectVisibleIDs(ctx, IDs, services)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := make(map[model.ID]bool, len(ids))
+	for _, ID := range ids {
+		visible[ID] = true
+	}
+
+	return visible, nil
+}
+
+// loadAncestors - walks parent uids up to the root of the chain,
+// a parent that was not registered yet ends the chain
+func loadAncestors(
+	ctx context.Context,
+	tx db.Tx,
+	tree *model.ActionTree,
+	action *model.Action,
+	visited map[model.UID]bool,
+) error {
+	for parentUID := action.ParentUID; !parentUID.Empty(); {
+		if visited[parentUID] {
+			tree.CycleDetected = true
+			break
+		}
+
+		if len(tree.Ancestors) >= model.MaxAncestors {
+			tree.Truncated = true
+			break
+		}
+
+		parent, err := tx.Actions().FirstByUID(ctx, parentUID)
+		if err == db.ErrNotFound {
+			break
+		} else if err != nil {
+			return errors.Wrapf(err, "could not get parent action with uid %s", parentUID)
+		}
+
+		visited[parent.UID] = true
+		tree.Ancestors = append(tree.Ancestors, *parent)
+		parentUID = parent.ParentUID
+	}
+
+	if len(tree.Ancestors) == 0 {
+		return nil
+	}
+
+	// root of the chain goes first
+	for i, j := 0, len(tree.Ancestors)-1; i < j; i, j = i+1, j-1 {
+		tree.Ancestors[i], tree.Ancestors[j] = tree.Ancestors[j], tree.Ancestors[i]
+	}
+
+	uids := make([]model.UID, len(tree.Ancestors))
+	for i := range tree.Ancestors {
+		uids[i] = tree.Ancestors[i].UID
+	}
+
+	counts, err := tx.Actions().CountChildren(ctx, uids)
+	if err != nil {
+		return err
+	}
+
+	for i := range tree.Ancestors {
+		tree.Ancestors[i].ChildrenCount = counts[tree.Ancestors[i].UID]
+	}
+
+	return nil
+}
+
+// loadDescendants - loads descendants of the root breadth first
+func loadDescendants(
+	ctx context.Context,
+	tx db.Tx,
+	tree *model.ActionTree,
+	root *model.ActionNode,
+	opts model.TreeOptions,
+	visited map[model.UID]bool,
+) error {
+	nodes := 1
+	level := []*model.ActionNode{root}
+
+	for depth := 0; len(level) > 0; depth++ {
+		uids := make([]model.UID, len(level))
+		byUID := make(map[model.UID]*model.ActionNode, len(level))
+		for i := range level {
+			uids[i] = level[i].Action.UID
+			byUID[level[i].Action.UID] = level[i]
+		}
+
+		counts, err := tx.Actions().CountChildren(ctx, uids)
+		if err != nil {
+			return err
+		}
+
+		for i := range level {
+			level[i].Action.ChildrenCount = counts[level[i].Action.UID]
+		}
+
+		limit := len(level) * opts.FanOut
+		if model.MaxTreeNodes-nodes < limit {
+			limit = model.MaxTreeNodes - nodes
+		}
+
+		if len(counts) == 0 {
+			return nil
+		}
+
+		if depth >= opts.Depth || limit <= 0 {
+			for i := range level {
+				level[i].Truncated = level[i].Action.ChildrenCount > 0
+			}
+
+			return nil
+		}
+
+		children, err := tx.Actions().SelectByParentUIDs(ctx, uids, limit)
+		if err != nil {
+			return err
+		}
+
+		cycles := make(map[model.UID]int)
+		next := make([]*model.ActionNode, 0, len(children))
+		for i := range children {
+			child := &children[i]
+			parent := byUID[child.ParentUID]
+
+			if visited[child.UID] {
+				tree.CycleDetected = true
+				cycles[parent.Action.UID]++
+				continue
+			}
+
+			if len(parent.Children) >= opts.FanOut {
+				continue
+			}
+
+			visited[child.UID] = true
+			node := &model.ActionNode{Action: child, Depth: depth + 1, Children: make([]*model.ActionNode, 0)}
+			parent.Children = append(parent.Children, node)
+			next = append(next, node)
+		}
+
+		for i := range level {
+			loaded := len(level[i].Children) + cycles[level[i].Action.UID]
+			level[i].Truncated = loaded < level[i].Action.ChildrenCount
+		}
+
+		nodes += len(next)
+		level = next
+	}
+
+	return nil
+}
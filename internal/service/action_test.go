@@ -0,0 +1,380 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/sqlite"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func uid(c string) model.UID {
+	return model.UID(strings.Repeat(c, 32))
+}
+
+func newActionServiceWithActions(t *testing.T, actions ...model.Action) (*BaseActionService, map[model.UID]model.ID) {
+	t.Helper()
+
+	lg := logger.NewStdoutLogger(logger.Prod, "service_test")
+	conn, err := sqlite.ConnectAndMigrate(context.Background(), lg, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	database := sqlite.NewDatabase(conn, lg)
+	ids := make(map[model.UID]model.ID)
+
+	_, err = database.ReadWrite(context.Background(), func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		emittedAt := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+		for i := range actions {
+			actions[i].Name = "step"
+			actions[i].EmittedAt = model.JSONTime{Time: emittedAt.Add(time.Duration(i) * time.Second)}
+			actions[i].RegisteredAt = actions[i].EmittedAt
+
+			created, err := tx.Actions().Create(ctx, &actions[i])
+			if err != nil {
+				return nil, err
+			}
+
+			ids[created.UID] = created.ID
+		}
+
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewActionService(database, lg), ids
+}
+
+func TestBaseActionService_Tree(t *testing.T) {
+	s, ids := newActionServiceWithActions(
+		t,
+		model.Action{UID: uid("a"), Status: model.Success},
+		model.Action{UID: uid("b"), ParentUID: uid("a"), Status: model.Success},
+		model.Action{UID: uid("c"), ParentUID: uid("a"), Status: model.Failed},
+		model.Action{UID: uid("d"), ParentUID: uid("b"), Status: model.Success},
+		// e and f are parents of each other
+		model.Action{UID: uid("e"), ParentUID: uid("f"), Status: model.Success},
+		model.Action{UID: uid("f"), ParentUID: uid("e"), Status: model.Success},
+	)
+
+	ctx := context.Background()
+
+	t.Run("whole tree", func(t *testing.T) {
+		tree, err := s.Tree(ctx, ids[uid("a")], model.TreeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Empty(t, tree.Ancestors)
+		assert.Equal(t, 4, tree.Nodes)
+		assert.False(t, tree.Truncated)
+		assert.False(t, tree.CycleDetected)
+		assert.Equal(t, model.PartialSuccess, tree.Status)
+		assert.Equal(t, map[string]int{"Success": 3, "Failed": 1}, tree.StatusCounts)
+		assert.Equal(t, 2, tree.Root.Action.ChildrenCount)
+		if assert.Len(t, tree.Root.Children, 2) {
+			assert.Equal(t, uid("b"), tree.Root.Children[0].Action.UID)
+			assert.Equal(t, 1, tree.Root.Children[0].Depth)
+			assert.Equal(t, uid("d"), tree.Root.Children[0].Children[0].Action.UID)
+			assert.Equal(t, 2, tree.Root.Children[0].Children[0].Depth)
+		}
+	})
+
+	t.Run("ancestors", func(t *testing.T) {
+		tree, err := s.Tree(ctx, ids[uid("d")], model.TreeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if assert.Len(t, tree.Ancestors, 2) {
+			assert.Equal(t, uid("a"), tree.Ancestors[0].UID)
+			assert.Equal(t, 2, tree.Ancestors[0].ChildrenCount)
+			assert.Equal(t, uid("b"), tree.Ancestors[1].UID)
+		}
+
+		assert.Equal(t, 1, tree.Nodes)
+		assert.Equal(t, model.Success, tree.Status)
+	})
+
+	t.Run("depth limit", func(t *testing.T) {
+		tree, err := s.Tree(ctx, ids[uid("a")], model.TreeOptions{Depth: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 3, tree.Nodes)
+		assert.True(t, tree.Truncated)
+		assert.True(t, tree.Root.Children[0].Truncated)
+		assert.Equal(t, 1, tree.Root.Children[0].Action.ChildrenCount)
+		assert.Empty(t, tree.Root.Children[0].Children)
+	})
+
+	t.Run("fan-out limit", func(t *testing.T) {
+		tree, err := s.Tree(ctx, ids[uid("a")], model.TreeOptions{FanOut: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.True(t, tree.Truncated)
+		assert.True(t, tree.Root.Truncated)
+		assert.Len(t, tree.Root.Children, 1)
+		assert.Equal(t, model.Success, tree.Status)
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		tree, err := s.Tree(ctx, ids[uid("e")], model.TreeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.True(t, tree.CycleDetected)
+		assert.False(t, tree.Truncated)
+		if assert.Len(t, tree.Ancestors, 1) {
+			assert.Equal(t, uid("f"), tree.Ancestors[0].UID)
+		}
+		assert.Empty(t, tree.Root.Children)
+		assert.Equal(t, 1, tree.Nodes)
+	})
+
+	t.Run("missing action", func(t *testing.T) {
+		_, err := s.Tree(ctx, model.ID(1000), model.TreeOptions{})
+		assert.Equal(t, db.ErrNotFound, err)
+	})
+}
+
+func TestBaseActionService_ScopedByServices(t *testing.T) {
+	lg := logger.NewStdoutLogger(logger.Prod, "service_test")
+	conn, err := sqlite.ConnectAndMigrate(context.Background(), lg, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	database := sqlite.NewDatabase(conn, lg)
+	ids := make(map[model.UID]model.ID)
+
+	_, err = database.ReadWrite(context.Background(), func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		targets := make(map[string]model.ID)
+		for _, service := range []string{"billing", "orders"} {
+			ms, err := tx.Microservices().Create(ctx, &model.Microservice{Name: service})
+			if err != nil {
+				return nil, err
+			}
+
+			et, err := tx.EntityTypes().FirstOrCreateByNameAndServiceID(ctx, "document", ms.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			e, err := tx.Entities().FirstOrCreateByExternalIDAndEntityTypeID(ctx, "1", et.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			targets[service] = e.ID
+		}
+
+		emittedAt := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+		for i, a := range []model.Action{
+			{UID: uid("a"), TargetEntityID: targets["billing"], Status: model.Success},
+			{UID: uid("b"), ParentUID: uid("a"), TargetEntityID: targets["orders"], Status: model.Failed},
+			{UID: uid("c"), ParentUID: uid("a"), TargetEntityID: targets["billing"], Status: model.Success},
+			{UID: uid("d"), ParentUID: uid("b"), ActorEntityID: targets["orders"], TargetEntityID: targets["billing"], Status: model.Success},
+		} {
+			a.Name = "step"
+			a.EmittedAt = model.JSONTime{Time: emittedAt.Add(time.Duration(i) * time.Second)}
+			a.RegisteredAt = a.EmittedAt
+
+			created, err := tx.Actions().Create(ctx, &a)
+			if err != nil {
+				return nil, err
+			}
+
+			ids[created.UID] = created.ID
+		}
+
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewActionService(database, lg)
+	ctx := context.Background()
+	billing := []string{"billing"}
+
+	t.Run("tree hides branches of other services", func(t *testing.T) {
+		tree, err := s.Tree(ctx, ids[uid("a")], model.TreeOptions{Services: billing})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 2, tree.Nodes)
+		assert.Equal(t, model.Success, tree.Status)
+		if assert.Len(t, tree.Root.Children, 1) {
+			assert.Equal(t, uid("c"), tree.Root.Children[0].Action.UID)
+		}
+	})
+
+	t.Run("tree hides ancestors of other services", func(t *testing.T) {
+		tree, err := s.Tree(ctx, ids[uid("d")], model.TreeOptions{Services: billing})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if assert.Len(t, tree.Ancestors, 1) {
+			assert.Equal(t, uid("a"), tree.Ancestors[0].UID)
+		}
+	})
+
+	t.Run("tree of an invisible action", func(t *testing.T) {
+		_, err := s.Tree(ctx, ids[uid("b")], model.TreeOptions{Services: billing})
+		assert.Equal(t, db.ErrNotFound, err)
+	})
+
+	t.Run("visible action with invisible parent and actor", func(t *testing.T) {
+		action, err := s.FirstVisibleByID(ctx, ids[uid("d")], billing)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, uid("d"), action.UID)
+		assert.Nil(t, action.Parent)
+		assert.Nil(t, action.Actor)
+		assert.NotZero(t, action.ActorEntityID)
+		assert.NotNil(t, action.Target)
+	})
+
+	t.Run("invisible action", func(t *testing.T) {
+		_, err := s.FirstVisibleByID(ctx, ids[uid("b")], billing)
+		assert.Equal(t, db.ErrNotFound, err)
+	})
+
+	t.Run("invisible action is not deleted", func(t *testing.T) {
+		err := s.Delete(ctx, ids[uid("b")], billing, time.Now())
+		assert.Equal(t, db.ErrNotFound, err)
+
+		_, err = s.FirstByID(ctx, ids[uid("b")])
+		assert.NoError(t, err)
+	})
+
+	t.Run("deleted action leaves a tombstone", func(t *testing.T) {
+		if !assert.NoError(t, s.Delete(ctx, ids[uid("d")], billing, time.Now())) {
+			return
+		}
+
+		_, err := s.FirstByID(ctx, ids[uid("d")])
+		assert.Equal(t, db.ErrNotFound, errors.Cause(err))
+
+		_, err = database.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+			tombstones, err := tx.Actions().SelectTombstones(ctx, ids[uid("d")], ids[uid("d")])
+			if err != nil {
+				return nil, err
+			}
+
+			if assert.Len(t, tombstones, 1) {
+				assert.Equal(t, uid("d"), tombstones[0].UID)
+				assert.False(t, tombstones[0].RuleID.Valid())
+			}
+
+			return nil, nil
+		})
+		assert.NoError(t, err)
+	})
+}
+
+func TestBaseActionService_Update_ChecksActorService(t *testing.T) {
+	lg := logger.NewStdoutLogger(logger.Prod, "service_test")
+	conn, err := sqlite.ConnectAndMigrate(context.Background(), lg, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	database := sqlite.NewDatabase(conn, lg)
+	ids := make(map[model.UID]model.ID)
+
+	_, err = database.ReadWrite(context.Background(), func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		actors := make(map[string]model.ID)
+		for _, service := range []string{"billing", "orders"} {
+			ms, err := tx.Microservices().Create(ctx, &model.Microservice{Name: service})
+			if err != nil {
+				return nil, err
+			}
+
+			et, err := tx.EntityTypes().FirstOrCreateByNameAndServiceID(ctx, "user", ms.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			e, err := tx.Entities().FirstOrCreateByExternalIDAndEntityTypeID(ctx, "1", et.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			actors[service] = e.ID
+		}
+
+		emittedAt := model.JSONTime{Time: time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)}
+		for _, a := range []struct {
+			uid     model.UID
+			service string
+		}{
+			{uid("a"), "billing"},
+			{uid("b"), "orders"},
+		} {
+			created, err := tx.Actions().Create(ctx, &model.Action{
+				UID:           a.uid,
+				Name:          "exportRequested",
+				ActorEntityID: actors[a.service],
+				Status:        model.Pending,
+				IsAsync:       true,
+				EmittedAt:     emittedAt,
+				RegisteredAt:  emittedAt,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			ids[created.UID] = created.ID
+		}
+
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewActionService(database, lg)
+	ctx := context.Background()
+
+	t.Run("action of another service is not updated", func(t *testing.T) {
+		_, err := s.Update(ctx, &model.UpdateAction{UID: uid("b").String(), Status: model.Success, ActorService: "billing"})
+		assert.Equal(t, ErrActorServiceMismatch, errors.Cause(err))
+
+		_, err = s.Update(ctx, &model.UpdateAction{UID: uid("b").String(), Status: model.Success, ActorService: "unknown"})
+		assert.Equal(t, ErrActorServiceMismatch, errors.Cause(err))
+
+		action, err := s.FirstByID(ctx, ids[uid("b")])
+		if assert.NoError(t, err) {
+			assert.Equal(t, model.Pending, action.Status)
+		}
+	})
+
+	t.Run("action of the service is updated", func(t *testing.T) {
+		action, err := s.Update(ctx, &model.UpdateAction{UID: uid("a").String(), Status: model.Success, ActorService: "billing"})
+		if assert.NoError(t, err) {
+			assert.Equal(t, model.Success, action.Status)
+		}
+	})
+}
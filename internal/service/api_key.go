@@ -0,0 +1,243 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/clock"
+	"github.com/denismitr/auditbase/internal/utils/errtype"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+)
+
+const ErrInvalidAPIKey = errtype.StringError("invalid api key")
+
+// APIKeyAuthenticator - resolves a key presented by a client
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, key string) (*model.APIKey, error)
+}
+
+type APIKeyService interface {
+	APIKeyAuthenticator
+
+	Issue(ctx context.Context, nk *model.NewAPIKey) (*model.IssuedAPIKey, error)
+	Select(ctx context.Context) ([]model.APIKey, error)
+	Rotate(ctx context.Context, ID model.ID) (*model.IssuedAPIKey, error)
+	Revoke(ctx context.Context, ID model.ID) error
+}
+
+var _ APIKeyService = (*BaseAPIKeyService)(nil)
+
+type BaseAPIKeyService struct {
+	db    db.Database
+	lg    logger.Logger
+	clock clock.Clock
+}
+
+func NewAPIKeyService(db db.Database, lg logger.Logger, clock clock.Clock) *BaseAPIKeyService {
+	return &BaseAPIKeyService{
+		db:    db,
+		lg:    lg,
+		clock: clock,
+	}
+}
+
+// Issue - creates a key bound to the given services, services that were not seen yet
+// are created, so that keys can be issued before the first action of the service arrives
+func (s *BaseAPIKeyService) Issue(ctx context.Context, nk *model.NewAPIKey) (*model.IssuedAPIKey, error) {
+	key, prefix, hash, err := model.GenerateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		k := &model.APIKey{Name: nk.Name, Prefix: prefix, Hash: hash}
+
+		for _, name := range nk.Services {
+			m, err := tx.Microservices().FirstOrCreateByName(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+
+			k.Services = append(k.Services, *m)
+		}
+
+		return tx.APIKeys().Create(ctx, k)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	k, ok := result.(*model.APIKey)
+	if !ok {
+		panic("how could result not be of type *model.APIKey")
+	}
+
+	return &model.IssuedAPIKey{APIKey: *k, Key: key}, nil
+}
+
+// Select - all issued keys, revoked ones included
+func (s *BaseAPIKeyService) Select(ctx context.Context) ([]model.APIKey, error) {
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return tx.APIKeys().Select(ctx)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	keys, ok := result.([]model.APIKey)
+	if !ok {
+		panic("how could result not be of type []model.APIKey")
+	}
+
+	return keys, nil
+}
+
+// Rotate - issues a new key in place of the old one, which stops working at once
+func (s *BaseAPIKeyService) Rotate(ctx context.Context, ID model.ID) (*model.IssuedAPIKey, error) {
+	key, prefix, hash, err := model.GenerateAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		k, err := tx.APIKeys().FirstByID(ctx, ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if k.Revoked() {
+			return nil, errors.Wrapf(model.ErrAPIKeyRevoked, "could not rotate api key %d", ID)
+		}
+
+		if err := tx.APIKeys().Rotate(ctx, ID, prefix, hash, s.clock.CurrentTime()); err != nil {
+			return nil, err
+		}
+
+		return tx.APIKeys().FirstByID(ctx, ID)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	k, ok := result.(*model.APIKey)
+	if !ok {
+		panic("how could result not be of type *model.APIKey")
+	}
+
+	return &model.IssuedAPIKey{APIKey: *k, Key: key}, nil
+}
+
+// Revoke - the key never authenticates again, revoking a revoked key is an error
+func (s *BaseAPIKeyService) Revoke(ctx context.Context, ID model.ID) error {
+	_, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		k, err := tx.APIKeys().FirstByID(ctx, ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if k.Revoked() {
+			return nil, errors.Wrapf(model.ErrAPIKeyRevoked, "api key %d", ID)
+		}
+
+		return nil, tx.APIKeys().Revoke(ctx, ID, s.clock.CurrentTime())
+	})
+
+	return err
+}
+
+// Authenticate - unknown and revoked keys are reported as ErrInvalidAPIKey
+func (s *BaseAPIKeyService) Authenticate(ctx context.Context, key string) (*model.APIKey, error) {
+	if key == "" {
+		return nil, ErrInvalidAPIKey
+	}
+
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return tx.APIKeys().FirstByHash(ctx, model.HashAPIKey(key))
+	})
+
+	if err != nil {
+		if errors.Cause(err) == db.ErrNotFound {
+			return nil, ErrInvalidAPIKey
+		}
+
+		return nil, err
+	}
+
+	k, ok := result.(*model.APIKey)
+	if !ok {
+		panic("how could result not be of type *model.APIKey")
+	}
+
+	if k.Revoked() {
+		return nil, ErrInvalidAPIKey
+	}
+
+	return k, nil
+}
+
+// CachingAuthenticator - keeps authenticated keys in memory for ttl,
+// so that the receiver does not hit the database on every request,
+// rotated and revoked keys keep working until their entry expires
+type CachingAuthenticator struct {
+	next  APIKeyAuthenticator
+	clock clock.Clock
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedAPIKey
+}
+
+type cachedAPIKey struct {
+	key       *model.APIKey
+	expiresAt time.Time
+}
+
+var _ APIKeyAuthenticator = (*CachingAuthenticator)(nil)
+
+func NewCachingAuthenticator(next APIKeyAuthenticator, clock clock.Clock, ttl time.Duration) *CachingAuthenticator {
+	return &CachingAuthenticator{
+		next:    next,
+		clock:   clock,
+		ttl:     ttl,
+		entries: make(map[string]cachedAPIKey),
+	}
+}
+
+// Authenticate - only successful lookups are cached
+func (a *CachingAuthenticator) Authenticate(ctx context.Context, key string) (*model.APIKey, error) {
+	hash := model.HashAPIKey(key)
+	now := a.clock.CurrentTime()
+
+	a.mu.Lock()
+	entry, ok := a.entries[hash]
+	a.mu.Unlock()
+
+	if ok && now.Before(entry.expiresAt) {
+		return entry.key, nil
+	}
+
+	k, err := a.next.Authenticate(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for h, e := range a.entries {
+		if !now.Before(e.expiresAt) {
+			delete(a.entries, h)
+		}
+	}
+
+	a.entries[hash] = cachedAPIKey{key: k, expiresAt: now.Add(a.ttl)}
+
+	return k, nil
+}
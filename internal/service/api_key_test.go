@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/sqlite"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) CurrentTimestamp() int64 {
+	return c.now.Unix()
+}
+
+func (c *fakeClock) CurrentTime() time.Time {
+	return c.now
+}
+
+func newAPIKeyService(t *testing.T) *BaseAPIKeyService {
+	t.Helper()
+
+	lg := logger.NewStdoutLogger(logger.Prod, "service_test")
+	conn, err := sqlite.ConnectAndMigrate(context.Background(), lg, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return NewAPIKeyService(sqlite.NewDatabase(conn, lg), lg, &fakeClock{now: time.Date(2021, 4, 1, 10, 0, 0, 0, time.UTC)})
+}
+
+func TestBaseAPIKeyService(t *testing.T) {
+	ctx := context.Background()
+	s := newAPIKeyService(t)
+
+	issued, err := s.Issue(ctx, &model.NewAPIKey{Name: "billing", Services: []string{"billing", "invoices"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, issued.Key, 64)
+	assert.Equal(t, issued.Key[:model.APIKeyPrefixLen], issued.Prefix)
+	assert.Equal(t, []string{"billing", "invoices"}, issued.ServiceNames())
+
+	k, err := s.Authenticate(ctx, issued.Key)
+	assert.NoError(t, err)
+	assert.True(t, k.Allows("invoices"))
+
+	_, err = s.Authenticate(ctx, "not-a-key")
+	assert.Equal(t, ErrInvalidAPIKey, err)
+
+	rotated, err := s.Rotate(ctx, issued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NotEqual(t, issued.Key, rotated.Key)
+	assert.False(t, rotated.RotatedAt.IsZero())
+
+	_, err = s.Authenticate(ctx, issued.Key)
+	assert.Equal(t, ErrInvalidAPIKey, err)
+
+	assert.NoError(t, s.Revoke(ctx, issued.ID))
+
+	_, err = s.Authenticate(ctx, rotated.Key)
+	assert.Equal(t, ErrInvalidAPIKey, err)
+
+	assert.Equal(t, model.ErrAPIKeyRevoked, errors.Cause(s.Revoke(ctx, issued.ID)))
+	_, err = s.Rotate(ctx, issued.ID)
+	assert.Equal(t, model.ErrAPIKeyRevoked, errors.Cause(err))
+	assert.Equal(t, db.ErrNotFound, errors.Cause(s.Revoke(ctx, 100)))
+
+	keys, err := s.Select(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, keys, 1) {
+		assert.True(t, keys[0].Revoked())
+	}
+}
+
+type countingAuthenticator struct {
+	calls int
+}
+
+func (a *countingAuthenticator) Authenticate(ctx context.Context, key string) (*model.APIKey, error) {
+	a.calls++
+	if key != "secret" {
+		return nil, ErrInvalidAPIKey
+	}
+
+	return &model.APIKey{Name: "billing"}, nil
+}
+
+func TestCachingAuthenticator(t *testing.T) {
+	ctx := context.Background()
+	next := &countingAuthenticator{}
+	clock := &fakeClock{now: time.Now()}
+	a := NewCachingAuthenticator(next, clock, 30*time.Second)
+
+	for i := 0; i < 3; i++ {
+		k, err := a.Authenticate(ctx, "secret")
+		assert.NoError(t, err)
+		assert.Equal(t, "billing", k.Name)
+	}
+
+	assert.Equal(t, 1, next.calls)
+
+	for i := 0; i < 2; i++ {
+		_, err := a.Authenticate(ctx, "wrong")
+		assert.Equal(t, ErrInvalidAPIKey, err)
+	}
+
+	assert.Equal(t, 3, next.calls)
+
+	clock.now = clock.now.Add(31 * time.Second)
+	_, err := a.Authenticate(ctx, "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, next.calls)
+}
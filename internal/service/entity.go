@@ -5,11 +5,15 @@ import (
 	"github.com/denismitr/auditbase/internal/db"
 	"github.com/denismitr/auditbase/internal/model"
 	"github.com/denismitr/auditbase/internal/utils/logger"
+	"time"
 )
 
 type EntityService interface {
 	Select(ctx context.Context, f *db.Filter, c *db.Cursor) (*model.EntityCollection, error)
 	FirstByID(ctx context.Context, ID model.ID) (*model.Entity, error)
+	FirstVisibleByID(ctx context.Context, ID model.ID, services []string) (*model.Entity, error)
+	PropertyHistory(ctx context.Context, ID model.ID, propertyName string, c *db.Cursor) (*model.PropertyChangeCollection, error)
+	StateAt(ctx context.Context, ID model.ID, at time.Time) (*model.EntityState, error)
 }
 
 var _ EntityService = (*BaseEntityService)(nil)
@@ -25,7 +29,7 @@ func (s *BaseEntityService) Select(
 	c *db.Cursor,
 ) (*model.EntityCollection, error) {
 	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
-		panic("not implemented yet")
+		return tx.Entities().Select(ctx, c, f)
 	})
 
 	if err != nil {
@@ -60,6 +64,112 @@ func (s *BaseEntityService) FirstByID(ctx context.Context, ID model.ID) (*model.
 	}
 }
 
+// FirstVisibleByID - the entity when its entity type belongs to one of the services,
+// otherwise it is reported as not found
+func (s *BaseEntityService) FirstVisibleByID(ctx context.Context, ID model.ID, services []string) (*model.Entity, error) {
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		entity, err := tx.Entities().FirstByID(ctx, ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if visible, err := entityVisible(ctx, tx, entity, services); err != nil {
+			return nil, err
+		} else if !visible {
+			return nil, db.ErrNotFound
+		}
+
+		return entity, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if entity, ok := result.(*model.Entity); !ok {
+		panic("how could result not be of type model.Entity")
+	} else {
+		return entity, nil
+	}
+}
+
+// entityVisible - whether the entity type of the entity belongs to one of the services
+func entityVisible(ctx context.Context, tx db.Tx, entity *model.Entity, services []string) (bool, error) {
+	entityType := entity.EntityType
+	if entityType == nil {
+		var err error
+		if entityType, err = tx.EntityTypes().FirstByID(ctx, entity.EntityTypeID); err != nil {
+			return false, err
+		}
+	}
+
+	ms, err := tx.Microservices().FirstByID(ctx, entityType.ServiceID)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range services {
+		if services[i] == ms.Name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// PropertyHistory - changes of one property of the entity over time
+func (s *BaseEntityService) PropertyHistory(
+	ctx context.Context,
+	ID model.ID,
+	propertyName string,
+	c *db.Cursor,
+) (*model.PropertyChangeCollection, error) {
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		if _, err := tx.Entities().FirstByID(ctx, ID); err != nil {
+			return nil, err
+		}
+
+		return tx.Deltas().PropertyHistory(ctx, ID, propertyName, c)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if history, ok := result.(*model.PropertyChangeCollection); !ok {
+		panic("how could result not be of type model.PropertyChangeCollection")
+	} else {
+		return history, nil
+	}
+}
+
+// StateAt - replays property changes of all actions targeting the entity
+// that were emitted not later than at
+func (s *BaseEntityService) StateAt(ctx context.Context, ID model.ID, at time.Time) (*model.EntityState, error) {
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		if _, err := tx.Entities().FirstByID(ctx, ID); err != nil {
+			return nil, err
+		}
+
+		changes, err := tx.Deltas().SelectByEntityID(ctx, ID, at)
+		if err != nil {
+			return nil, err
+		}
+
+		return model.ReconstructEntityState(ID, at, changes), nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if state, ok := result.(*model.EntityState); !ok {
+		panic("how could result not be of type model.EntityState")
+	} else {
+		return state, nil
+	}
+}
+
 func NewEntityService(db db.Database, lg logger.Logger) *BaseEntityService {
 	return &BaseEntityService{
 		db: db,
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+)
+
+type IntegrityService interface {
+	VerifyChain(ctx context.Context, fromID, toID model.ID, limit int) (*model.ChainVerification, error)
+}
+
+var _ IntegrityService = (*BaseIntegrityService)(nil)
+
+type BaseIntegrityService struct {
+	db db.Database
+	lg logger.Logger
+}
+
+func NewIntegrityService(db db.Database, lg logger.Logger) *BaseIntegrityService {
+	return &BaseIntegrityService{
+		db: db,
+		lg: lg,
+	}
+}
+
+// VerifyChain - walks the action hash chain from fromID up to toID, zero toID means
+// up to the last action, and stops at the first broken link. At most limit actions
+// are checked, when there are more of them NextID of the result tells where to continue.
+// Actions pruned by retention are accepted as long as their tombstones keep the chain linked
+func (s *BaseIntegrityService) VerifyChain(
+	ctx context.Context,
+	fromID, toID model.ID,
+	limit int,
+) (*model.ChainVerification, error) {
+	if !fromID.Valid() {
+		fromID = 1
+	}
+
+	if limit <= 0 || limit > model.MaxChainVerifyLimit {
+		limit = model.DefaultChainVerifyLimit
+	}
+
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		prevChainHash, err := tx.Actions().ChainHashBefore(ctx, fromID)
+		if err != nil {
+			return nil, err
+		}
+
+		v := model.NewChainVerification(fromID, prevChainHash)
+		nextID := fromID
+
+		for walked := 0; walked < limit; {
+			batch := model.ChainBatchSize
+			if limit-walked < batch {
+				batch = limit - walked
+			}
+
+			actions, err := tx.Actions().SelectChain(ctx, nextID, toID, batch)
+			if err != nil {
+				return nil, err
+			}
+
+			// actions pruned by retention are stepped over through their tombstones
+			upperID := toID
+			if len(actions) == batch {
+				upperID = actions[len(actions)-1].ID
+			}
+
+			tombstones, err := tx.Actions().SelectTombstones(ctx, nextID, upperID)
+			if err != nil {
+				return nil, err
+			}
+
+			t := 0
+			for i := range actions {
+				for ; t < len(tombstones) && tombstones[t].ActionID < actions[i].ID; t++ {
+					if !v.Skip(&tombstones[t]) {
+						return v, nil
+					}
+				}
+
+				delta, err := tx.Deltas().SelectByActionID(ctx, actions[i].ID)
+				if err != nil {
+					return nil, errors.Wrapf(err, "could not load delta of action %d", actions[i].ID)
+				}
+
+				actions[i].Delta = delta
+
+				if !v.Verify(&actions[i]) {
+					return v, nil
+				}
+			}
+
+			for ; t < len(tombstones); t++ {
+				if !v.Skip(&tombstones[t]) {
+					return v, nil
+				}
+			}
+
+			if len(actions) < batch {
+				return v, nil
+			}
+
+			walked += len(actions)
+			nextID = actions[len(actions)-1].ID + 1
+		}
+
+		rest, err := tx.Actions().SelectChain(ctx, nextID, toID, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(rest) > 0 {
+			v.NextID = rest[0].ID
+		}
+
+		return v, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	v, ok := result.(*model.ChainVerification)
+	if !ok {
+		panic("how could result not be of type *model.ChainVerification")
+	}
+
+	return v, nil
+}
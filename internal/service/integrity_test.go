@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/sqlite"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+func newIntegrityServiceWithChain(t *testing.T, n int) (*BaseIntegrityService, *sqlx.DB) {
+	t.Helper()
+
+	lg := logger.NewStdoutLogger(logger.Prod, "service_test")
+	conn, err := sqlite.ConnectAndMigrate(context.Background(), lg, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	database := sqlite.NewDatabase(conn, lg)
+
+	_, err = database.ReadWrite(context.Background(), func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		emittedAt := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+		for i := 0; i < n; i++ {
+			action := &model.Action{
+				UID:          uid(string(rune('a' + i))),
+				Name:         "article_updated",
+				EmittedAt:    model.JSONTime{Time: emittedAt.Add(time.Duration(i) * time.Second)},
+				RegisteredAt: model.JSONTime{Time: emittedAt.Add(time.Duration(i) * time.Second)},
+				Delta: model.Delta{
+					{PropertyName: "views", CurrentPropertyType: model.IntegerProperty, From: i, To: i + 1},
+				},
+			}
+
+			created, err := tx.Actions().Create(ctx, action)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := tx.Deltas().Create(ctx, created.ID, created.TargetEntityID, action.Delta); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewIntegrityService(database, lg), conn
+}
+
+func TestBaseIntegrityService_VerifyChain(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("intact chain", func(t *testing.T) {
+		s, _ := newIntegrityServiceWithChain(t, 5)
+
+		v, err := s.VerifyChain(ctx, 0, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.True(t, v.Valid)
+		assert.Equal(t, 5, v.Checked)
+		assert.Equal(t, model.ID(1), v.FromID)
+		assert.Equal(t, model.ID(5), v.ToID)
+		assert.Equal(t, model.ID(0), v.NextID)
+	})
+
+	t.Run("range and limit", func(t *testing.T) {
+		s, _ := newIntegrityServiceWithChain(t, 5)
+
+		v, err := s.VerifyChain(ctx, 2, 4, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.True(t, v.Valid)
+		assert.Equal(t, 2, v.Checked)
+		assert.Equal(t, model.ID(3), v.ToID)
+		assert.Equal(t, model.ID(4), v.NextID)
+	})
+
+	t.Run("altered delta", func(t *testing.T) {
+		s, conn := newIntegrityServiceWithChain(t, 5)
+
+		if _, err := conn.Exec("UPDATE action_deltas SET to_value = '100' WHERE action_id = 3"); err != nil {
+			t.Fatal(err)
+		}
+
+		v, err := s.VerifyChain(ctx, 0, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.False(t, v.Valid)
+		assert.Equal(t, 2, v.Checked)
+		if assert.NotNil(t, v.BrokenLink) {
+			assert.Equal(t, model.ID(3), v.BrokenLink.ActionID)
+			assert.Equal(t, model.ChainHashMismatch, v.BrokenLink.Reason)
+		}
+	})
+
+	t.Run("removed action", func(t *testing.T) {
+		s, conn := newIntegrityServiceWithChain(t, 5)
+
+		if _, err := conn.Exec("DELETE FROM actions WHERE id = 2"); err != nil {
+			t.Fatal(err)
+		}
+
+		v, err := s.VerifyChain(ctx, 0, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.False(t, v.Valid)
+		if assert.NotNil(t, v.BrokenLink) {
+			assert.Equal(t, model.ID(3), v.BrokenLink.ActionID)
+			assert.Equal(t, model.ChainLinkMismatch, v.BrokenLink.Reason)
+		}
+	})
+
+	t.Run("removed head of the chain", func(t *testing.T) {
+		s, conn := newIntegrityServiceWithChain(t, 5)
+
+		if _, err := conn.Exec("DELETE FROM actions WHERE id < 3"); err != nil {
+			t.Fatal(err)
+		}
+
+		v, err := s.VerifyChain(ctx, 0, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.True(t, v.Valid)
+		assert.Equal(t, 3, v.Checked)
+	})
+}
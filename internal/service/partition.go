@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/clock"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+)
+
+// DefaultPartitionsAhead - number of months following the current one partitions are created for
+const DefaultPartitionsAhead = 3
+
+type PartitionService interface {
+	Partitions(ctx context.Context) ([]model.ActionPartition, error)
+	Maintain(ctx context.Context, monthsAhead int) ([]model.ActionPartition, error)
+}
+
+var _ PartitionService = (*BasePartitionService)(nil)
+
+type BasePartitionService struct {
+	db    db.Database
+	lg    logger.Logger
+	clock clock.Clock
+}
+
+func NewPartitionService(db db.Database, lg logger.Logger, clock clock.Clock) *BasePartitionService {
+	return &BasePartitionService{
+		db:    db,
+		lg:    lg,
+		clock: clock,
+	}
+}
+
+// Partitions - partitions of the actions table, db.ErrNotPartitioned when the storage does not partition it
+func (s *BasePartitionService) Partitions(ctx context.Context) ([]model.ActionPartition, error) {
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return tx.ActionPartitions().Select(ctx)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	partitions, ok := result.([]model.ActionPartition)
+	if !ok {
+		panic("how could result not be of type []model.ActionPartition")
+	}
+
+	return partitions, nil
+}
+
+// Maintain - creates the month partitions missing up to monthsAhead months after the current one,
+// so that actions never land in the future partition, which would have to be split then.
+// Returns the partitions created, none when the storage does not partition actions
+func (s *BasePartitionService) Maintain(ctx context.Context, monthsAhead int) ([]model.ActionPartition, error) {
+	if monthsAhead < 0 {
+		monthsAhead = DefaultPartitionsAhead
+	}
+
+	result, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		partitions, err := tx.ActionPartitions().Select(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var lastBound model.ActionPartition
+		for i := range partitions {
+			if partitions[i].Bounded() && partitions[i].Until.After(lastBound.Until) {
+				lastBound = partitions[i]
+			}
+		}
+
+		now := s.clock.CurrentTime()
+		next := model.NextActionPartitions(lastBound.Until, now, now.AddDate(0, monthsAhead, 0))
+
+		if err := tx.ActionPartitions().Create(ctx, next); err != nil {
+			return nil, err
+		}
+
+		return next, nil
+	})
+
+	if errors.Cause(err) == db.ErrNotPartitioned {
+		s.lg.Debugf("actions are not partitioned, nothing to maintain")
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	created, ok := result.([]model.ActionPartition)
+	if !ok {
+		panic("how could result not be of type []model.ActionPartition")
+	}
+
+	for i := range created {
+		s.lg.Debugf("created action partition %s", created[i].Name)
+	}
+
+	return created, nil
+}
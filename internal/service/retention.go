@@ -0,0 +1,412 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/clock"
+	"github.com/denismitr/auditbase/internal/utils/errtype"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+)
+
+const ErrRetentionRuleExists = errtype.StringError("retention rule for the service and action name already exists")
+
+const (
+	DefaultCleanupBatchSize = 500
+	// maxPartitionDropAttempts - a partition is entombed again this many times at most,
+	// when actions land in it while it is entombed
+	maxPartitionDropAttempts = 3
+	// DefaultOrphanGrace - entities and entity types younger than that are never removed,
+	// so that the ones just created for an action being stored are left alone
+	DefaultOrphanGrace = 24 * time.Hour
+)
+
+// CleanupOptions - how the retention rules are enforced, every batch
+// is removed in a transaction of its own, so that no transaction is held for long
+type CleanupOptions struct {
+	BatchSize   int
+	OrphanGrace time.Duration
+	DryRun      bool
+	// Archive - when set, receives the IDs of every batch of actions before they are pruned,
+	// the batch is kept when it fails
+	Archive func(ctx context.Context, IDs []model.ID) error
+}
+
+type RetentionService interface {
+	Rules(ctx context.Context) (model.RetentionRules, error)
+	CreateRule(ctx context.Context, r *model.RetentionRule) (*model.RetentionRule, error)
+	DeleteRule(ctx context.Context, ID model.ID) error
+	Enforce(ctx context.Context, opts CleanupOptions) (*model.CleanupReport, error)
+}
+
+var _ RetentionService = (*BaseRetentionService)(nil)
+
+type BaseRetentionService struct {
+	db    db.Database
+	lg    logger.Logger
+	clock clock.Clock
+}
+
+func NewRetentionService(db db.Database, lg logger.Logger, clock clock.Clock) *BaseRetentionService {
+	return &BaseRetentionService{
+		db:    db,
+		lg:    lg,
+		clock: clock,
+	}
+}
+
+func (s *BaseRetentionService) Rules(ctx context.Context) (model.RetentionRules, error) {
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return tx.RetentionRules().Select(ctx)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	rules, ok := result.(model.RetentionRules)
+	if !ok {
+		panic("how could result not be of type model.RetentionRules")
+	}
+
+	return rules, nil
+}
+
+// CreateRule - there is at most one rule for every service and action name pair
+func (s *BaseRetentionService) CreateRule(ctx context.Context, r *model.RetentionRule) (*model.RetentionRule, error) {
+	result, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		rules, err := tx.RetentionRules().Select(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range rules {
+			if rules[i].Service == r.Service && rules[i].ActionName == r.ActionName {
+				return nil, errors.Wrapf(ErrRetentionRuleExists, "rule %d", rules[i].ID)
+			}
+		}
+
+		r.CreatedAt = model.JSONTime{Time: s.clock.CurrentTime()}
+
+		return tx.RetentionRules().Create(ctx, r)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	rule, ok := result.(*model.RetentionRule)
+	if !ok {
+		panic("how could result not be of type *model.RetentionRule")
+	}
+
+	return rule, nil
+}
+
+func (s *BaseRetentionService) DeleteRule(ctx context.Context, ID model.ID) error {
+	_, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return nil, tx.RetentionRules().Delete(ctx, ID)
+	})
+
+	return err
+}
+
+// Enforce - removes the actions expired by the rules, leaving tombstones in their place,
+// and then the entities and entity types nothing refers to anymore. Month partitions
+// holding expired actions only are dropped whole, when the storage partitions actions.
+// On a dry run expired actions are only counted and orphans are left alone
+func (s *BaseRetentionService) Enforce(ctx context.Context, opts CleanupOptions) (*model.CleanupReport, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultCleanupBatchSize
+	}
+
+	rules, err := s.Rules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock.CurrentTime()
+	report := model.NewCleanupReport(rules, opts.DryRun, now)
+
+	if !opts.DryRun {
+		if err := s.dropPartitions(ctx, rules, now, opts, report); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.pruneActions(ctx, rules, now, opts, report); err != nil {
+		return nil, err
+	}
+
+	if !opts.DryRun {
+		if err := s.removeOrphans(ctx, now.Add(-opts.OrphanGrace), opts.BatchSize, report); err != nil {
+			return nil, err
+		}
+	}
+
+	report.FinishedAt = model.JSONTime{Time: s.clock.CurrentTime()}
+
+	return report, nil
+}
+
+// dropPartitions - drops the month partitions registered before the time every action is expired by,
+// oldest first, tombstones of their actions are stored beforehand, so that the chain stays verifiable,
+// actions stored in the partition in the meantime, replayed dead letters for one, are entombed before it is dropped
+func (s *BaseRetentionService) dropPartitions(
+	ctx context.Context,
+	rules model.RetentionRules,
+	now time.Time,
+	opts CleanupOptions,
+	report *model.CleanupReport,
+) error {
+	expired := rules.ExpiredBefore(now)
+	if expired.IsZero() {
+		return nil
+	}
+
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return tx.ActionPartitions().Select(ctx)
+	})
+
+	if errors.Cause(err) == db.ErrNotPartitioned {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	partitions, ok := result.([]model.ActionPartition)
+	if !ok {
+		panic("how could result not be of type []model.ActionPartition")
+	}
+
+	for _, p := range partitions {
+		if !p.Bounded() || p.Until.After(expired) {
+			return nil
+		}
+
+		if err := s.dropPartition(ctx, rules, now, p, opts, report); err != nil {
+			return err
+		}
+
+		report.Partitions = append(report.Partitions, p.Name)
+		s.lg.Debugf("dropped action partition %s", p.Name)
+	}
+
+	return nil
+}
+
+// dropPartition - entombs the actions of the partition and drops it, the drop is refused while
+// some of its actions have no tombstone, these are stored later than the ones entombed already,
+// so that the partition is entombed again from the last action entombed
+func (s *BaseRetentionService) dropPartition(
+	ctx context.Context,
+	rules model.RetentionRules,
+	now time.Time,
+	p model.ActionPartition,
+	opts CleanupOptions,
+	report *model.CleanupReport,
+) error {
+	var afterID model.ID
+	for attempt := 1; ; attempt++ {
+		lastID, err := s.entombPartition(ctx, rules, now, p, afterID, opts, report)
+		if err != nil {
+			return err
+		}
+
+		afterID = lastID
+
+		_, err = s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+			return nil, tx.ActionPartitions().Drop(ctx, p.Name)
+		})
+
+		if errors.Cause(err) == db.ErrPartitionNotEntombed && attempt < maxPartitionDropAttempts {
+			s.lg.Debugf("actions were stored in partition %s while it was entombed: %s", p.Name, err)
+			continue
+		}
+
+		return err
+	}
+}
+
+// entombPartition - stores tombstones of the actions of the partition after the given ID, the partitions
+// before it are dropped already, so these are all the actions registered before its bound,
+// the ID of the last action entombed is returned, afterID when there was none
+func (s *BaseRetentionService) entombPartition(
+	ctx context.Context,
+	rules model.RetentionRules,
+	now time.Time,
+	p model.ActionPartition,
+	afterID model.ID,
+	opts CleanupOptions,
+	report *model.CleanupReport,
+) (model.ID, error) {
+	for {
+		result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+			return tx.Actions().SelectExpiring(ctx, afterID, p.Until, opts.BatchSize)
+		})
+
+		if err != nil {
+			return afterID, err
+		}
+
+		candidates, ok := result.([]model.RetentionCandidate)
+		if !ok {
+			panic("how could result not be of type []model.RetentionCandidate")
+		}
+
+		if len(candidates) == 0 {
+			return afterID, nil
+		}
+
+		tombstones := make([]model.ActionTombstone, len(candidates))
+		IDs := make([]model.ID, len(candidates))
+		for i := range candidates {
+			// there is a catch-all rule, so every action is matched
+			rule := rules.Match(candidates[i].Service, candidates[i].Name)
+			tombstones[i] = model.NewActionTombstone(&candidates[i], rule, now)
+			IDs[i] = candidates[i].ID
+			report.Removed(rule)
+		}
+
+		report.Scanned += len(candidates)
+
+		if opts.Archive != nil {
+			if err := opts.Archive(ctx, IDs); err != nil {
+				return afterID, errors.Wrapf(err, "could not archive actions of partition %s", p.Name)
+			}
+		}
+
+		if _, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+			return nil, tx.ActionPartitions().Entomb(ctx, tombstones)
+		}); err != nil {
+			return afterID, errors.Wrapf(err, "could not entomb actions of partition %s", p.Name)
+		}
+
+		afterID = candidates[len(candidates)-1].ID
+
+		if len(candidates) < opts.BatchSize {
+			return afterID, nil
+		}
+	}
+}
+
+func (s *BaseRetentionService) pruneActions(
+	ctx context.Context,
+	rules model.RetentionRules,
+	now time.Time,
+	opts CleanupOptions,
+	report *model.CleanupReport,
+) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	before := rules.EarliestCutoff(now)
+
+	for afterID := model.ID(0); ; {
+		result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+			return tx.Actions().SelectExpiring(ctx, afterID, before, opts.BatchSize)
+		})
+
+		if err != nil {
+			return err
+		}
+
+		candidates, ok := result.([]model.RetentionCandidate)
+		if !ok {
+			panic("how could result not be of type []model.RetentionCandidate")
+		}
+
+		if len(candidates) == 0 {
+			return nil
+		}
+
+		var tombstones []model.ActionTombstone
+		for i := range candidates {
+			rule := rules.Match(candidates[i].Service, candidates[i].Name)
+			if rule == nil || !candidates[i].RegisteredAt.Before(rule.Cutoff(now)) {
+				continue
+			}
+
+			tombstones = append(tombstones, model.NewActionTombstone(&candidates[i], rule, now))
+			report.Removed(rule)
+		}
+
+		report.Scanned += len(candidates)
+
+		if !opts.DryRun && len(tombstones) > 0 {
+			if opts.Archive != nil {
+				IDs := make([]model.ID, len(tombstones))
+				for i := range tombstones {
+					IDs[i] = tombstones[i].ActionID
+				}
+
+				if err := opts.Archive(ctx, IDs); err != nil {
+					return errors.Wrapf(err, "could not archive actions after ID %d", afterID)
+				}
+			}
+
+			if _, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+				return nil, tx.Actions().Prune(ctx, tombstones)
+			}); err != nil {
+				return errors.Wrapf(err, "could not prune actions after ID %d", afterID)
+			}
+
+			s.lg.Debugf("pruned %d actions up to ID %d", len(tombstones), candidates[len(candidates)-1].ID)
+		}
+
+		if len(candidates) < opts.BatchSize {
+			return nil
+		}
+
+		afterID = candidates[len(candidates)-1].ID
+	}
+}
+
+func (s *BaseRetentionService) removeOrphans(ctx context.Context, before time.Time, limit int, report *model.CleanupReport) error {
+	removeInBatches := func(remove func(ctx context.Context, tx db.Tx) (int, error)) (int, error) {
+		total := 0
+		for {
+			result, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+				return remove(ctx, tx)
+			})
+
+			if err != nil {
+				return total, err
+			}
+
+			n, ok := result.(int)
+			if !ok {
+				panic("how could result not be of type int")
+			}
+
+			total += n
+			if n < limit {
+				return total, nil
+			}
+		}
+	}
+
+	entities, err := removeInBatches(func(ctx context.Context, tx db.Tx) (int, error) {
+		return tx.Entities().DeleteOrphans(ctx, before, limit)
+	})
+
+	report.Entities = entities
+	if err != nil {
+		return errors.Wrap(err, "could not remove orphaned entities")
+	}
+
+	entityTypes, err := removeInBatches(func(ctx context.Context, tx db.Tx) (int, error) {
+		return tx.EntityTypes().DeleteOrphans(ctx, before, limit)
+	})
+
+	report.EntityTypes = entityTypes
+	if err != nil {
+		return errors.Wrap(err, "could not remove orphaned entity types")
+	}
+
+	return nil
+}
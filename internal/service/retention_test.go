@@ -0,0 +1,353 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/sqlite"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBaseRetentionService_Enforce(t *testing.T) {
+	lg := logger.NewStdoutLogger(logger.Prod, "service_test")
+	conn, err := sqlite.ConnectAndMigrate(context.Background(), lg, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	database := sqlite.NewDatabase(conn, lg)
+	// entities are created with the wall clock, so the clock runs slightly ahead of it
+	now := time.Now().UTC().Add(time.Minute).Truncate(time.Second)
+	ids := make(map[model.UID]model.ID)
+
+	_, err = database.ReadWrite(context.Background(), func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		targets := make(map[string]model.ID)
+		for _, target := range []struct{ service, entityType string }{
+			{"articles", "page"},
+			{"articles", "article"},
+			{"billing", "invoice"},
+		} {
+			ms, err := tx.Microservices().FirstOrCreateByName(ctx, target.service)
+			if err != nil {
+				return nil, err
+			}
+
+			et, err := tx.EntityTypes().FirstOrCreateByNameAndServiceID(ctx, target.entityType, ms.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			e, err := tx.Entities().FirstOrCreateByExternalIDAndEntityTypeID(ctx, "1", et.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			targets[target.entityType] = e.ID
+		}
+
+		for _, a := range []struct {
+			uid     model.UID
+			name    string
+			target  string
+			daysAgo int
+		}{
+			{uid("a"), "pageViewed", "page", 40},
+			{uid("b"), "invoicePaid", "invoice", 400},
+			{uid("c"), "pageViewed", "page", 35},
+			{uid("d"), "articleCreated", "article", 40},
+			{uid("e"), "pageViewed", "article", 5},
+		} {
+			at := model.JSONTime{Time: now.AddDate(0, 0, -a.daysAgo)}
+			created, err := tx.Actions().Create(ctx, &model.Action{
+				UID:            a.uid,
+				Name:           a.name,
+				TargetEntityID: targets[a.target],
+				EmittedAt:      at,
+				RegisteredAt:   at,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			ids[created.UID] = created.ID
+		}
+
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewRetentionService(database, lg, &fakeClock{now: now})
+	ctx := context.Background()
+
+	for _, r := range []model.RetentionRule{
+		{ActionName: "pageViewed", KeepDays: 30},
+		{Service: "billing", KeepDays: 2555},
+	} {
+		if _, err := s.CreateRule(ctx, &r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("duplicate rule", func(t *testing.T) {
+		_, err := s.CreateRule(ctx, &model.RetentionRule{ActionName: "pageViewed", KeepDays: 7})
+		assert.Equal(t, ErrRetentionRuleExists, errors.Cause(err))
+	})
+
+	t.Run("dry run only counts", func(t *testing.T) {
+		report, err := s.Enforce(ctx, CleanupOptions{BatchSize: 2, DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 4, report.Scanned)
+		assert.Equal(t, 2, report.Actions)
+		assert.Equal(t, 0, report.Entities)
+
+		count, err := NewActionService(database, lg).Count(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 5, count)
+	})
+
+	t.Run("expired actions and orphans are removed", func(t *testing.T) {
+		report, err := s.Enforce(ctx, CleanupOptions{BatchSize: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 2, report.Actions)
+		assert.Equal(t, 2, report.Rules[0].Removed)
+		assert.Equal(t, 0, report.Rules[1].Removed)
+		assert.Equal(t, 1, report.Entities)
+		assert.Equal(t, 1, report.EntityTypes)
+
+		actions := NewActionService(database, lg)
+		for _, pruned := range []model.UID{uid("a"), uid("c")} {
+			_, err := actions.FirstByID(ctx, ids[pruned])
+			assert.Error(t, err)
+		}
+
+		for _, kept := range []model.UID{uid("b"), uid("d"), uid("e")} {
+			_, err := actions.FirstByID(ctx, ids[kept])
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("chain stays verifiable across pruned actions", func(t *testing.T) {
+		integrity := NewIntegrityService(database, lg)
+
+		v, err := integrity.VerifyChain(ctx, 0, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.True(t, v.Valid)
+		assert.Equal(t, 3, v.Checked)
+		assert.Equal(t, 2, v.Pruned)
+
+		v, err = integrity.VerifyChain(ctx, ids[uid("d")], 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.True(t, v.Valid)
+		assert.Equal(t, 2, v.Checked)
+	})
+}
+
+// partitionedDatabase - sqlite database whose actions are pretended to be partitioned
+type partitionedDatabase struct {
+	db.Database
+	partitions *fakeActionPartitions
+}
+
+func (d *partitionedDatabase) ReadOnly(ctx context.Context, cb db.TxCallback) (interface{}, error) {
+	return d.Database.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return cb(ctx, &partitionedTx{Tx: tx, partitions: d.partitions})
+	})
+}
+
+func (d *partitionedDatabase) ReadWrite(ctx context.Context, cb db.TxCallback) (interface{}, error) {
+	return d.Database.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return cb(ctx, &partitionedTx{Tx: tx, partitions: d.partitions})
+	})
+}
+
+type partitionedTx struct {
+	db.Tx
+	partitions *fakeActionPartitions
+}
+
+func (tx *partitionedTx) ActionPartitions() db.ActionPartitionRepository {
+	return &fakeActionPartitionRepository{tx: tx.Tx, partitions: tx.partitions}
+}
+
+// fakeActionPartitions - a single month partition before until, its actions are never removed,
+// afterEntomb runs along with every entomb and can store actions in the partition
+type fakeActionPartitions struct {
+	until       time.Time
+	entombed    map[model.ID]bool
+	entombs     int
+	dropped     []string
+	drops       int
+	afterEntomb func(ctx context.Context, tx db.Tx) error
+}
+
+type fakeActionPartitionRepository struct {
+	tx         db.Tx
+	partitions *fakeActionPartitions
+}
+
+func (r *fakeActionPartitionRepository) Select(ctx context.Context) ([]model.ActionPartition, error) {
+	return []model.ActionPartition{
+		{Name: model.ActionPartitionName(r.partitions.until.AddDate(0, -1, 0)), Until: r.partitions.until},
+		{Name: model.FutureActionPartition},
+	}, nil
+}
+
+func (r *fakeActionPartitionRepository) Create(ctx context.Context, partitions []model.ActionPartition) error {
+	return nil
+}
+
+func (r *fakeActionPartitionRepository) Entomb(ctx context.Context, tombstones []model.ActionTombstone) error {
+	r.partitions.entombs++
+
+	for i := range tombstones {
+		r.partitions.entombed[tombstones[i].ActionID] = true
+	}
+
+	if r.partitions.afterEntomb != nil {
+		return r.partitions.afterEntomb(ctx, r.tx)
+	}
+
+	return nil
+}
+
+func (r *fakeActionPartitionRepository) Drop(ctx context.Context, name string) error {
+	r.partitions.drops++
+
+	candidates, err := r.tx.Actions().SelectExpiring(ctx, 0, r.partitions.until, 100)
+	if err != nil {
+		return err
+	}
+
+	for i := range candidates {
+		if !r.partitions.entombed[candidates[i].ID] {
+			return errors.Wrapf(db.ErrPartitionNotEntombed, "action %d", candidates[i].ID)
+		}
+	}
+
+	r.partitions.dropped = append(r.partitions.dropped, name)
+
+	return nil
+}
+
+func TestBaseRetentionService_dropPartitions(t *testing.T) {
+	lg := logger.NewStdoutLogger(logger.Prod, "service_test")
+	conn, err := sqlite.ConnectAndMigrate(context.Background(), lg, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	ctx := context.Background()
+	now := time.Date(2021, 6, 15, 12, 0, 0, 0, time.UTC)
+	until := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	var targetID model.ID
+	createAction := func(ctx context.Context, tx db.Tx, u model.UID, registeredAt time.Time) error {
+		at := model.JSONTime{Time: registeredAt}
+		_, err := tx.Actions().Create(ctx, &model.Action{
+			UID:            u,
+			Name:           "pageViewed",
+			TargetEntityID: targetID,
+			EmittedAt:      at,
+			RegisteredAt:   at,
+		})
+
+		return err
+	}
+
+	partitions := &fakeActionPartitions{until: until, entombed: make(map[model.ID]bool)}
+	database := &partitionedDatabase{Database: sqlite.NewDatabase(conn, lg), partitions: partitions}
+
+	_, err = database.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		ms, err := tx.Microservices().FirstOrCreateByName(ctx, "articles")
+		if err != nil {
+			return nil, err
+		}
+
+		et, err := tx.EntityTypes().FirstOrCreateByNameAndServiceID(ctx, "page", ms.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := tx.Entities().FirstOrCreateByExternalIDAndEntityTypeID(ctx, "1", et.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		targetID = e.ID
+
+		for i, u := range []model.UID{uid("a"), uid("b"), uid("c")} {
+			if err := createAction(ctx, tx, u, until.AddDate(0, 0, -10+i)); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a replayed dead letter lands in the partition along with its last batch of tombstones
+	partitions.afterEntomb = func(ctx context.Context, tx db.Tx) error {
+		if partitions.entombs != 2 {
+			return nil
+		}
+
+		return createAction(ctx, tx, uid("d"), until.AddDate(0, 0, -20))
+	}
+
+	s := NewRetentionService(database, lg, &fakeClock{now: now})
+	rules := model.RetentionRules{{ID: 1, KeepDays: 30}}
+	report := model.NewCleanupReport(rules, false, now)
+
+	if err := s.dropPartitions(ctx, rules, now, CleanupOptions{BatchSize: 2}, report); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 2, partitions.drops)
+	assert.Equal(t, []string{"p202104"}, partitions.dropped)
+	assert.Equal(t, []string{"p202104"}, report.Partitions)
+	assert.Len(t, partitions.entombed, 4)
+	assert.Equal(t, 4, report.Scanned)
+	assert.Equal(t, 4, report.Actions)
+	assert.Equal(t, 4, report.Rules[0].Removed)
+
+	t.Run("partition is kept while actions keep landing in it", func(t *testing.T) {
+		partitions.dropped = nil
+		partitions.drops = 0
+		partitions.afterEntomb = func(ctx context.Context, tx db.Tx) error {
+			return createAction(ctx, tx, model.UID(fmt.Sprintf("%032d", partitions.entombs)), until.AddDate(0, 0, -5))
+		}
+
+		err := s.dropPartitions(ctx, rules, now, CleanupOptions{BatchSize: 2}, model.NewCleanupReport(rules, false, now))
+
+		assert.Equal(t, db.ErrPartitionNotEntombed, errors.Cause(err))
+		assert.Equal(t, maxPartitionDropAttempts, partitions.drops)
+		assert.Empty(t, partitions.dropped)
+	})
+}
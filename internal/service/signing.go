@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/clock"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+)
+
+type SigningService interface {
+	RotateSecret(ctx context.Context, serviceID model.ID, grace time.Duration) (*model.IssuedSigningSecret, error)
+	SigningSecrets(ctx context.Context, service string) (*model.SigningSecrets, error)
+}
+
+var _ SigningService = (*BaseSigningService)(nil)
+
+type BaseSigningService struct {
+	db    db.Database
+	lg    logger.Logger
+	clock clock.Clock
+}
+
+func NewSigningService(db db.Database, lg logger.Logger, clock clock.Clock) *BaseSigningService {
+	return &BaseSigningService{
+		db:    db,
+		lg:    lg,
+		clock: clock,
+	}
+}
+
+// RotateSecret - issues a new signing secret for the microservice, the previous one,
+// if any, is still accepted during the grace window
+func (s *BaseSigningService) RotateSecret(
+	ctx context.Context,
+	serviceID model.ID,
+	grace time.Duration,
+) (*model.IssuedSigningSecret, error) {
+	secret, err := model.GenerateSigningSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		if _, err := tx.Microservices().FirstByID(ctx, serviceID); err != nil {
+			return nil, err
+		}
+
+		secrets, err := tx.SigningSecrets().FirstByServiceID(ctx, serviceID)
+		if err != nil {
+			if errors.Cause(err) != db.ErrNotFound {
+				return nil, err
+			}
+
+			secrets = &model.SigningSecrets{ServiceID: serviceID}
+		}
+
+		secrets.Rotate(secret, s.clock.CurrentTime(), grace)
+
+		if err := tx.SigningSecrets().Save(ctx, secrets); err != nil {
+			return nil, err
+		}
+
+		return secrets, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	secrets, ok := result.(*model.SigningSecrets)
+	if !ok {
+		panic("how could result not be of type *model.SigningSecrets")
+	}
+
+	issued := &model.IssuedSigningSecret{
+		ServiceID: serviceID,
+		Secret:    secrets.Secret,
+		RotatedAt: model.JSONTime{Time: secrets.RotatedAt},
+	}
+
+	if secrets.PreviousSecret != "" {
+		issued.PreviousValidUntil = model.JSONTime{Time: secrets.PreviousExpiresAt}
+	}
+
+	return issued, nil
+}
+
+// SigningSecrets - secrets of the microservice by its name,
+// db.ErrNotFound when the microservice has none
+func (s *BaseSigningService) SigningSecrets(ctx context.Context, service string) (*model.SigningSecrets, error) {
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return tx.SigningSecrets().FirstByServiceName(ctx, service)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	secrets, ok := result.(*model.SigningSecrets)
+	if !ok {
+		panic("how could result not be of type *model.SigningSecrets")
+	}
+
+	return secrets, nil
+}
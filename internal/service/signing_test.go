@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/sqlite"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBaseSigningService_RotateSecret(t *testing.T) {
+	ctx := context.Background()
+	lg := logger.NewStdoutLogger(logger.Prod, "service_test")
+	conn, err := sqlite.ConnectAndMigrate(ctx, lg, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	database := sqlite.NewDatabase(conn, lg)
+	clock := &fakeClock{now: time.Date(2021, 4, 1, 10, 0, 0, 0, time.UTC)}
+	s := NewSigningService(database, lg, clock)
+
+	ms, err := NewMicroserviceService(database, lg).Create(ctx, &model.Microservice{Name: "billing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := s.RotateSecret(ctx, ms.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, first.Secret, 64)
+	assert.True(t, first.PreviousValidUntil.IsZero())
+
+	clock.now = clock.now.Add(time.Minute)
+
+	second, err := s.RotateSecret(ctx, ms.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NotEqual(t, first.Secret, second.Secret)
+	assert.Equal(t, clock.now.Add(time.Hour), second.PreviousValidUntil.Time)
+
+	secrets, err := s.SigningSecrets(ctx, "billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []string{second.Secret, first.Secret}, secrets.Accepted(clock.now))
+	assert.Equal(t, []string{second.Secret}, secrets.Accepted(clock.now.Add(2*time.Hour)))
+
+	_, err = s.RotateSecret(ctx, ms.ID+100, time.Hour)
+	assert.Equal(t, db.ErrNotFound, errors.Cause(err))
+}
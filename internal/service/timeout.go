@@ -0,0 +1,342 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/clock"
+	"github.com/denismitr/auditbase/internal/utils/errtype"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+)
+
+const ErrTimeoutRuleExists = errtype.StringError("timeout rule for the service and action name already exists")
+
+// DefaultTimeoutBatchSize - number of actions in progress a sweep looks at in a single transaction
+const DefaultTimeoutBatchSize = 500
+
+type TimeoutService interface {
+	Rules(ctx context.Context) (model.TimeoutRules, error)
+	CreateRule(ctx context.Context, r *model.TimeoutRule) (*model.TimeoutRule, error)
+	DeleteRule(ctx context.Context, ID model.ID) error
+	Sweeps(ctx context.Context, c *db.Cursor, services []string) (*model.TimeoutSweepCollection, error)
+	Sweep(ctx context.Context, ID model.ID, services []string) (*model.TimeoutSweep, error)
+	TimeOutStuck(ctx context.Context, batchSize int) (*model.TimeoutSweep, error)
+}
+
+var _ TimeoutService = (*BaseTimeoutService)(nil)
+
+type BaseTimeoutService struct {
+	db    db.Database
+	lg    logger.Logger
+	clock clock.Clock
+}
+
+func NewTimeoutService(db db.Database, lg logger.Logger, clock clock.Clock) *BaseTimeoutService {
+	return &BaseTimeoutService{
+		db:    db,
+		lg:    lg,
+		clock: clock,
+	}
+}
+
+func (s *BaseTimeoutService) Rules(ctx context.Context) (model.TimeoutRules, error) {
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return tx.Timeouts().SelectRules(ctx)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	rules, ok := result.(model.TimeoutRules)
+	if !ok {
+		panic("how could result not be of type model.TimeoutRules")
+	}
+
+	return rules, nil
+}
+
+// CreateRule - there is at most one rule for every service and action name pair
+func (s *BaseTimeoutService) CreateRule(ctx context.Context, r *model.TimeoutRule) (*model.TimeoutRule, error) {
+	result, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		rules, err := tx.Timeouts().SelectRules(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range rules {
+			if rules[i].Service == r.Service && rules[i].ActionName == r.ActionName {
+				return nil, errors.Wrapf(ErrTimeoutRuleExists, "rule %d", rules[i].ID)
+			}
+		}
+
+		r.CreatedAt = model.JSONTime{Time: s.clock.CurrentTime()}
+
+		return tx.Timeouts().CreateRule(ctx, r)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	rule, ok := result.(*model.TimeoutRule)
+	if !ok {
+		panic("how could result not be of type *model.TimeoutRule")
+	}
+
+	return rule, nil
+}
+
+func (s *BaseTimeoutService) DeleteRule(ctx context.Context, ID model.ID) error {
+	_, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return nil, tx.Timeouts().DeleteRule(ctx, ID)
+	})
+
+	return err
+}
+
+// Sweeps - latest sweeps first, nil services means all of them, otherwise only the sweeps
+// that timed out actions visible to the services are listed and only those actions are counted
+func (s *BaseTimeoutService) Sweeps(ctx context.Context, c *db.Cursor, services []string) (*model.TimeoutSweepCollection, error) {
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return tx.Timeouts().SelectSweeps(ctx, c, services)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sweeps, ok := result.(*model.TimeoutSweepCollection)
+	if !ok {
+		panic("how could result not be of type *model.TimeoutSweepCollection")
+	}
+
+	return sweeps, nil
+}
+
+// Sweep - the sweep along with the actions it timed out, unless services are nil only the actions
+// visible to the services are listed, a sweep that timed out none of them is not found
+func (s *BaseTimeoutService) Sweep(ctx context.Context, ID model.ID, services []string) (*model.TimeoutSweep, error) {
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		sweep, err := tx.Timeouts().FirstSweepByID(ctx, ID)
+		if err != nil || services == nil {
+			return sweep, err
+		}
+
+		IDs := make([]model.ID, len(sweep.Timeouts))
+		for i := range sweep.Timeouts {
+			IDs[i] = sweep.Timeouts[i].ActionID
+		}
+
+		if len(IDs) == 0 {
+			return nil, db.ErrNotFound
+		}
+
+		visible, err := visibleActions(ctx, tx, IDs, services)
+		if err != nil {
+			return nil, err
+		}
+
+		timeouts := make([]model.ActionTimeout, 0, len(visible))
+		for i := range sweep.Timeouts {
+			if visible[sweep.Timeouts[i].ActionID] {
+				timeouts = append(timeouts, sweep.Timeouts[i])
+			}
+		}
+
+		if len(timeouts) == 0 {
+			return nil, db.ErrNotFound
+		}
+
+		sweep.Timeouts = timeouts
+		sweep.TimedOut = len(timeouts)
+
+		return sweep, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sweep, ok := result.(*model.TimeoutSweep)
+	if !ok {
+		panic("how could result not be of type *model.TimeoutSweep")
+	}
+
+	return sweep, nil
+}
+
+// TimeOutStuck - moves the async actions in progress for longer than the rule matching them allows
+// to TimedOut, batch by batch, every batch in a transaction of its own. The sweep is only stored
+// once it times out an action, the returned one lists the actions timed out by it. A sweep that
+// fails or is interrupted part way is still finished, stored and returned along with the error,
+// since the batches committed before stay timed out
+func (s *BaseTimeoutService) TimeOutStuck(ctx context.Context, batchSize int) (*model.TimeoutSweep, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultTimeoutBatchSize
+	}
+
+	rules, err := s.Rules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock.CurrentTime()
+	sweep := &model.TimeoutSweep{StartedAt: model.JSONTime{Time: now}}
+	if len(rules) == 0 {
+		sweep.FinishedAt = sweep.StartedAt
+		return sweep, nil
+	}
+
+	if err := s.sweepBatches(ctx, rules, now, batchSize, sweep); err != nil {
+		// ctx may be the cause, so the sweep is finished with a context of its own
+		finishCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if finishErr := s.finish(finishCtx, sweep); finishErr != nil {
+			s.lg.Error(finishErr)
+		}
+
+		return sweep, err
+	}
+
+	if err := s.finish(ctx, sweep); err != nil {
+		return sweep, err
+	}
+
+	return sweep, nil
+}
+
+// sweepBatches - selects the candidates in batches of batchSize and times out the due ones,
+// until a batch comes out short
+func (s *BaseTimeoutService) sweepBatches(
+	ctx context.Context,
+	rules model.TimeoutRules,
+	now time.Time,
+	batchSize int,
+	sweep *model.TimeoutSweep,
+) error {
+	before := rules.EarliestCutoff(now)
+
+	for afterID := model.ID(0); ; {
+		result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+			return tx.Timeouts().SelectCandidates(ctx, afterID, before, batchSize)
+		})
+
+		if err != nil {
+			return err
+		}
+
+		candidates, ok := result.([]model.TimeoutCandidate)
+		if !ok {
+			panic("how could result not be of type []model.TimeoutCandidate")
+		}
+
+		sweep.Scanned += len(candidates)
+
+		if err := s.timeOut(ctx, rules, candidates, now, sweep); err != nil {
+			return errors.Wrapf(err, "could not time out actions after ID %d", afterID)
+		}
+
+		if len(candidates) < batchSize {
+			return nil
+		}
+
+		afterID = candidates[len(candidates)-1].ID
+	}
+}
+
+// finish - records when the sweep finished, a stored sweep is updated with its totals
+func (s *BaseTimeoutService) finish(ctx context.Context, sweep *model.TimeoutSweep) error {
+	sweep.FinishedAt = model.JSONTime{Time: s.clock.CurrentTime()}
+
+	if !sweep.ID.Valid() {
+		return nil
+	}
+
+	if _, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return nil, tx.Timeouts().UpdateSweep(ctx, sweep)
+	}); err != nil {
+		return errors.Wrapf(err, "could not finish timeout sweep %d", sweep.ID)
+	}
+
+	s.lg.Debugf("timeout sweep %d timed out %d of %d actions in progress", sweep.ID, sweep.TimedOut, sweep.Scanned)
+
+	return nil
+}
+
+// timeOut - times out the candidates past the cutoff of the rule matching them, the sweep
+// is stored along with the first of them. Actions that have reported their completion
+// since they were selected are left alone
+func (s *BaseTimeoutService) timeOut(
+	ctx context.Context,
+	rules model.TimeoutRules,
+	candidates []model.TimeoutCandidate,
+	now time.Time,
+	sweep *model.TimeoutSweep,
+) error {
+	var due []*model.TimeoutCandidate
+	var dueRules []*model.TimeoutRule
+	for i := range candidates {
+		rule := rules.Match(candidates[i].Service, candidates[i].Name)
+		if rule == nil || !candidates[i].RegisteredAt.Before(rule.Cutoff(now)) {
+			continue
+		}
+
+		due = append(due, &candidates[i])
+		dueRules = append(dueRules, rule)
+	}
+
+	if len(due) == 0 {
+		return nil
+	}
+
+	type batch struct {
+		sweepID  model.ID
+		timeouts []model.ActionTimeout
+	}
+
+	result, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		b := batch{sweepID: sweep.ID}
+		if !b.sweepID.Valid() {
+			ID, err := tx.Timeouts().CreateSweep(ctx, sweep)
+			if err != nil {
+				return nil, err
+			}
+
+			b.sweepID = ID
+		}
+
+		for i := range due {
+			t := model.NewActionTimeout(due[i], dueRules[i], b.sweepID, now)
+			timedOut, err := tx.Timeouts().TimeOut(ctx, &t)
+			if err != nil {
+				return nil, err
+			}
+
+			if timedOut {
+				b.timeouts = append(b.timeouts, t)
+			}
+		}
+
+		return b, nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	b, ok := result.(batch)
+	if !ok {
+		panic("how could result not be of type batch")
+	}
+
+	sweep.ID = b.sweepID
+	sweep.TimedOut += len(b.timeouts)
+	sweep.Timeouts = append(sweep.Timeouts, b.timeouts...)
+
+	return nil
+}
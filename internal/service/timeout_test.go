@@ -0,0 +1,254 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/sqlite"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBaseTimeoutService_TimeOutStuck(t *testing.T) {
+	lg := logger.NewStdoutLogger(logger.Prod, "service_test")
+	conn, err := sqlite.ConnectAndMigrate(context.Background(), lg, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	database := sqlite.NewDatabase(conn, lg)
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := &fakeClock{now: now}
+	ids := make(map[model.UID]model.ID)
+	targets := make(map[string]model.ID)
+
+	_, err = database.ReadWrite(context.Background(), func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		for _, service := range []string{"billing", "reports"} {
+			ms, err := tx.Microservices().FirstOrCreateByName(ctx, service)
+			if err != nil {
+				return nil, err
+			}
+
+			et, err := tx.EntityTypes().FirstOrCreateByNameAndServiceID(ctx, "job", ms.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			e, err := tx.Entities().FirstOrCreateByExternalIDAndEntityTypeID(ctx, "1", et.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			targets[service] = e.ID
+		}
+
+		for _, a := range []struct {
+			uid        model.UID
+			name       string
+			service    string
+			status     model.Status
+			minutesAgo int
+		}{
+			{uid("a"), "refundIssued", "billing", model.Pending, 15},
+			{uid("b"), "refundIssued", "billing", model.Processing, 5},
+			{uid("c"), "refundIssued", "billing", model.Success, 60},
+			{uid("d"), "reportGenerated", "reports", model.Retrying, 90},
+			{uid("e"), "reportGenerated", "billing", model.Pending, 90},
+			{uid("f"), "reportGenerated", "reports", model.Pending, 150},
+		} {
+			at := model.JSONTime{Time: now.Add(-time.Duration(a.minutesAgo) * time.Minute)}
+			created, err := tx.Actions().Create(ctx, &model.Action{
+				UID:            a.uid,
+				Name:           a.name,
+				TargetEntityID: targets[a.service],
+				Status:         a.status,
+				IsAsync:        true,
+				EmittedAt:      at,
+				RegisteredAt:   at,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			ids[created.UID] = created.ID
+		}
+
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timeouts := NewTimeoutService(database, lg, clk)
+
+	t.Run("without rules nothing times out", func(t *testing.T) {
+		sweep, err := timeouts.TimeOutStuck(context.Background(), 10)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.False(t, sweep.ID.Valid())
+		assert.Equal(t, 0, sweep.TimedOut)
+	})
+
+	ctx := context.Background()
+	billing, err := timeouts.CreateRule(ctx, &model.TimeoutRule{Service: "billing", TimeoutSeconds: 600})
+	assert.NoError(t, err)
+	reports, err := timeouts.CreateRule(ctx, &model.TimeoutRule{ActionName: "reportGenerated", TimeoutSeconds: 7200})
+	assert.NoError(t, err)
+
+	_, err = timeouts.CreateRule(ctx, &model.TimeoutRule{Service: "billing", TimeoutSeconds: 60})
+	assert.Equal(t, ErrTimeoutRuleExists, errors.Cause(err))
+
+	t.Run("actions in progress longer than their rule allows are timed out", func(t *testing.T) {
+		// batches of one make the sweep span several transactions
+		sweep, err := timeouts.TimeOutStuck(ctx, 1)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.True(t, sweep.ID.Valid())
+		assert.Equal(t, 4, sweep.Scanned)
+		assert.Equal(t, 2, sweep.TimedOut)
+		if assert.Len(t, sweep.Timeouts, 2) {
+			assert.Equal(t, ids[uid("a")], sweep.Timeouts[0].ActionID)
+			assert.Equal(t, billing.ID, sweep.Timeouts[0].RuleID)
+			assert.Equal(t, model.Pending, sweep.Timeouts[0].PreviousStatus)
+			assert.Equal(t, ids[uid("f")], sweep.Timeouts[1].ActionID)
+			assert.Equal(t, reports.ID, sweep.Timeouts[1].RuleID)
+		}
+
+		stored, err := timeouts.Sweep(ctx, sweep.ID, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Equal(t, 2, stored.TimedOut)
+		assert.Len(t, stored.Timeouts, 2)
+
+		// scoped tokens only see the actions of their services
+		scoped, err := timeouts.Sweep(ctx, sweep.ID, []string{"reports"})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Equal(t, 1, scoped.TimedOut)
+		if assert.Len(t, scoped.Timeouts, 1) {
+			assert.Equal(t, ids[uid("f")], scoped.Timeouts[0].ActionID)
+		}
+
+		_, err = timeouts.Sweep(ctx, sweep.ID, []string{"orders"})
+		assert.Equal(t, db.ErrNotFound, errors.Cause(err))
+
+		sweeps, err := timeouts.Sweeps(ctx, &db.Cursor{Page: 1, PerPage: 10}, []string{"reports"})
+		if assert.NoError(t, err) && assert.Len(t, sweeps.Items, 1) {
+			assert.Equal(t, 1, sweeps.Items[0].TimedOut)
+		}
+
+		for u, expected := range map[model.UID]model.Status{
+			uid("a"): model.TimedOut,
+			uid("b"): model.Processing,
+			uid("c"): model.Success,
+			// the action name wins over the service
+			uid("d"): model.Retrying,
+			uid("e"): model.Pending,
+			uid("f"): model.TimedOut,
+		} {
+			_, err := database.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+				a, err := tx.Actions().FirstByID(ctx, ids[u])
+				if err != nil {
+					return nil, err
+				}
+
+				assert.Equal(t, expected, a.Status, u.String())
+				return nil, nil
+			})
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("sweeps timing nothing out are not stored", func(t *testing.T) {
+		sweep, err := timeouts.TimeOutStuck(ctx, 10)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.False(t, sweep.ID.Valid())
+		assert.Equal(t, 2, sweep.Scanned)
+
+		sweeps, err := timeouts.Sweeps(ctx, &db.Cursor{Page: 1, PerPage: 10}, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Len(t, sweeps.Items, 1)
+	})
+	t.Run("an interrupted sweep is stored with the actions it has timed out", func(t *testing.T) {
+		_, err := database.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+			for _, u := range []model.UID{uid("g"), uid("h")} {
+				at := model.JSONTime{Time: now.Add(-30 * time.Minute)}
+				created, err := tx.Actions().Create(ctx, &model.Action{
+					UID:            u,
+					Name:           "refundIssued",
+					TargetEntityID: targets["billing"],
+					Status:         model.Pending,
+					IsAsync:        true,
+					EmittedAt:      at,
+					RegisteredAt:   at,
+				})
+				if err != nil {
+					return nil, err
+				}
+
+				ids[created.UID] = created.ID
+			}
+
+			return nil, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sweepCtx, stop := context.WithCancel(ctx)
+		defer stop()
+
+		// the sweep is interrupted once its first batch timing out an action is committed
+		interrupted := NewTimeoutService(&afterFirstWriteDatabase{Database: database, after: stop}, lg, clk)
+
+		sweep, err := interrupted.TimeOutStuck(sweepCtx, 1)
+		assert.Equal(t, context.Canceled, errors.Cause(err))
+		if !assert.NotNil(t, sweep) {
+			return
+		}
+
+		assert.True(t, sweep.ID.Valid())
+		assert.False(t, sweep.FinishedAt.IsZero())
+		if assert.Len(t, sweep.Timeouts, 1) {
+			assert.Equal(t, ids[uid("g")], sweep.Timeouts[0].ActionID)
+		}
+
+		stored, err := timeouts.Sweep(ctx, sweep.ID, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Equal(t, 1, stored.TimedOut)
+		assert.False(t, stored.FinishedAt.IsZero())
+	})
+	t.Run("a completion reported after the timeout is rejected", func(t *testing.T) {
+		actions := NewActionService(database, lg)
+
+		_, err := actions.Update(ctx, &model.UpdateAction{UID: uid("a").String(), Status: model.Success})
+		assert.Equal(t, ErrActionTimedOut, errors.Cause(err))
+
+		action, err := actions.FirstByID(ctx, ids[uid("a")])
+		if assert.NoError(t, err) {
+			assert.Equal(t, model.TimedOut, action.Status)
+		}
+	})
+}
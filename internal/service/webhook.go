@@ -0,0 +1,517 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/receiver"
+	"github.com/denismitr/auditbase/internal/utils/clock"
+	"github.com/denismitr/auditbase/internal/utils/errtype"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+)
+
+const ErrWebhookDeliveryNotFailed = errtype.StringError("only failed webhook deliveries can be retried")
+
+// Headers of a webhook delivery, along with the signature headers of the receiver
+const (
+	HeaderWebhookDelivery = "X-Auditbase-Delivery"
+	HeaderWebhookEvent    = "X-Auditbase-Event"
+)
+
+const (
+	DefaultWebhookBatchSize = 50
+	// DefaultWebhookTimeout - how long a receiver has to respond to a delivery
+	DefaultWebhookTimeout = 10 * time.Second
+
+	// webhookLease - a delivery being attempted is not due again for that long,
+	// so that a dispatcher stopped in the middle of an attempt makes it later
+	webhookLease = 5 * time.Minute
+	// maxWebhookErrorLen - longest part of an error or a response body kept in the delivery log
+	maxWebhookErrorLen = 1024
+)
+
+type WebhookService interface {
+	Rules(ctx context.Context) (model.WebhookRules, error)
+	Rule(ctx context.Context, ID model.ID) (*model.WebhookRule, error)
+	CreateRule(ctx context.Context, r *model.WebhookRule) (*model.IssuedWebhookRule, error)
+	UpdateRule(ctx context.Context, r *model.WebhookRule) (*model.WebhookRule, error)
+	DeleteRule(ctx context.Context, ID model.ID) error
+	Deliveries(ctx context.Context, c *db.Cursor, f *db.Filter) (*model.WebhookDeliveryCollection, error)
+	RetryDelivery(ctx context.Context, ID model.ID) (*model.WebhookDelivery, error)
+	Dispatch(ctx context.Context, e *model.ActionEvent) (int, error)
+	DeliverDue(ctx context.Context, limit int) (int, error)
+}
+
+var _ WebhookService = (*BaseWebhookService)(nil)
+
+// BaseWebhookService - rules are matched by the dispatcher against every persisted action,
+// the webhooks of the ones matching are stored as pending deliveries and posted
+// by DeliverDue, retrying the failed ones with backoff
+type BaseWebhookService struct {
+	db     db.Database
+	lg     logger.Logger
+	clock  clock.Clock
+	client *http.Client
+}
+
+func NewWebhookService(db db.Database, lg logger.Logger, clock clock.Clock, client *http.Client) *BaseWebhookService {
+	if client == nil {
+		client = &http.Client{Timeout: DefaultWebhookTimeout}
+	}
+
+	return &BaseWebhookService{
+		db:     db,
+		lg:     lg,
+		clock:  clock,
+		client: client,
+	}
+}
+
+// Rules - every rule, without the secrets
+func (s *BaseWebhookService) Rules(ctx context.Context) (model.WebhookRules, error) {
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return tx.Webhooks().SelectRules(ctx)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	rules, ok := result.(model.WebhookRules)
+	if !ok {
+		panic("how could result not be of type model.WebhookRules")
+	}
+
+	for i := range rules {
+		rules[i].Secret = ""
+	}
+
+	return rules, nil
+}
+
+// Rule - the rule without its secret
+func (s *BaseWebhookService) Rule(ctx context.Context, ID model.ID) (*model.WebhookRule, error) {
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return tx.Webhooks().FirstRuleByID(ctx, ID)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	rule, ok := result.(*model.WebhookRule)
+	if !ok {
+		panic("how could result not be of type *model.WebhookRule")
+	}
+
+	rule.Secret = ""
+
+	return rule, nil
+}
+
+// CreateRule - stores the rule with a newly generated secret, the only time the secret is returned
+func (s *BaseWebhookService) CreateRule(ctx context.Context, r *model.WebhookRule) (*model.IssuedWebhookRule, error) {
+	secret, err := model.GenerateSigningSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	now := model.JSONTime{Time: s.clock.CurrentTime()}
+	r.Secret = secret
+	r.CreatedAt = now
+	r.UpdatedAt = now
+
+	result, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return tx.Webhooks().CreateRule(ctx, r)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	rule, ok := result.(*model.WebhookRule)
+	if !ok {
+		panic("how could result not be of type *model.WebhookRule")
+	}
+
+	rule.Secret = ""
+
+	return &model.IssuedWebhookRule{WebhookRule: *rule, Secret: secret}, nil
+}
+
+// UpdateRule - replaces the criteria, the url and the name of the rule, its secret is kept
+func (s *BaseWebhookService) UpdateRule(ctx context.Context, r *model.WebhookRule) (*model.WebhookRule, error) {
+	result, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		existing, err := tx.Webhooks().FirstRuleByID(ctx, r.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		r.CreatedAt = existing.CreatedAt
+		r.UpdatedAt = model.JSONTime{Time: s.clock.CurrentTime()}
+
+		if err := tx.Webhooks().UpdateRule(ctx, r); err != nil {
+			return nil, err
+		}
+
+		return r, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	rule, ok := result.(*model.WebhookRule)
+	if !ok {
+		panic("how could result not be of type *model.WebhookRule")
+	}
+
+	rule.Secret = ""
+
+	return rule, nil
+}
+
+// DeleteRule - removes the rule along with its delivery log, pending deliveries are never made
+func (s *BaseWebhookService) DeleteRule(ctx context.Context, ID model.ID) error {
+	_, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return nil, tx.Webhooks().DeleteRule(ctx, ID)
+	})
+
+	return err
+}
+
+// Deliveries - the delivery log, latest deliveries first
+func (s *BaseWebhookService) Deliveries(ctx context.Context, c *db.Cursor, f *db.Filter) (*model.WebhookDeliveryCollection, error) {
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return tx.Webhooks().SelectDeliveries(ctx, c, f)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, ok := result.(*model.WebhookDeliveryCollection)
+	if !ok {
+		panic("how could result not be of type *model.WebhookDeliveryCollection")
+	}
+
+	return deliveries, nil
+}
+
+// RetryDelivery - a delivery given up on is made again by the next run of the dispatcher
+func (s *BaseWebhookService) RetryDelivery(ctx context.Context, ID model.ID) (*model.WebhookDelivery, error) {
+	result, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		d, err := tx.Webhooks().FirstDeliveryByID(ctx, ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if d.State != model.DeliveryFailed {
+			return nil, errors.Wrapf(ErrWebhookDeliveryNotFailed, "delivery %d is %s", ID, d.State)
+		}
+
+		d.Retry(s.clock.CurrentTime())
+
+		if err := tx.Webhooks().UpdateDelivery(ctx, d); err != nil {
+			return nil, err
+		}
+
+		return d, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	d, ok := result.(*model.WebhookDelivery)
+	if !ok {
+		panic("how could result not be of type *model.WebhookDelivery")
+	}
+
+	return d, nil
+}
+
+// Dispatch - stores a pending delivery for every enabled rule the action of the event matches,
+// unless the rule has been delivered about the action with its current status already,
+// so that an event received more than once is dispatched once. Returns the number of rules matched
+func (s *BaseWebhookService) Dispatch(ctx context.Context, e *model.ActionEvent) (int, error) {
+	result, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		rules, err := tx.Webhooks().SelectRules(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		enabled := 0
+		for i := range rules {
+			if rules[i].Enabled {
+				enabled++
+			}
+		}
+
+		if enabled == 0 {
+			return 0, nil
+		}
+
+		action, err := webhookAction(ctx, tx, e.ActionID)
+		if err != nil {
+			if errors.Cause(err) == db.ErrNotFound {
+				// pruned before it was dispatched, there is nothing to post
+				return 0, nil
+			}
+
+			return nil, err
+		}
+
+		matched := rules.Match(action)
+		if len(matched) == 0 {
+			return 0, nil
+		}
+
+		now := s.clock.CurrentTime()
+		deliveries := make([]model.WebhookDelivery, len(matched))
+		for i := range matched {
+			payload, err := json.Marshal(model.WebhookPayload{
+				Event:  e.Type,
+				Rule:   model.WebhookPayloadRule{ID: matched[i].ID, Name: matched[i].Name},
+				Action: action,
+			})
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not convert webhook of action %d to json bytes", action.ID)
+			}
+
+			deliveries[i] = model.WebhookDelivery{
+				RuleID:        matched[i].ID,
+				ActionID:      action.ID,
+				ActionStatus:  action.Status,
+				Event:         e.Type,
+				Payload:       payload,
+				State:         model.DeliveryPending,
+				NextAttemptAt: model.JSONTime{Time: now},
+				CreatedAt:     model.JSONTime{Time: now},
+			}
+		}
+
+		if err := tx.Webhooks().CreateDeliveries(ctx, deliveries); err != nil {
+			return nil, err
+		}
+
+		return len(matched), nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, ok := result.(int)
+	if !ok {
+		panic("how could result not be of type int")
+	}
+
+	return n, nil
+}
+
+// webhookAction - the action with its delta, actor and target along with their types and services
+func webhookAction(ctx context.Context, tx db.Tx, ID model.ID) (*model.Action, error) {
+	action, err := tx.Actions().FirstByID(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	delta, err := tx.Deltas().SelectByActionID(ctx, action.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not join delta to action")
+	}
+
+	action.Delta = delta
+
+	services := make(map[model.ID]*model.Microservice)
+	entity := func(ID model.ID) (*model.Entity, error) {
+		if ID == 0 {
+			return nil, nil
+		}
+
+		e, err := tx.Entities().FirstByIDWithEntityType(ctx, ID)
+		if err != nil {
+			return nil, err
+		}
+
+		serviceID := e.EntityType.ServiceID
+		if _, ok := services[serviceID]; !ok {
+			if services[serviceID], err = tx.Microservices().FirstByID(ctx, serviceID); err != nil {
+				return nil, errors.Wrapf(err, "could not join service to entity type %d", e.EntityTypeID)
+			}
+		}
+
+		e.EntityType.Service = services[serviceID]
+
+		return e, nil
+	}
+
+	if action.Actor, err = entity(action.ActorEntityID); err != nil {
+		return nil, errors.Wrap(err, "could not join actor to action")
+	}
+
+	if action.Target, err = entity(action.TargetEntityID); err != nil {
+		return nil, errors.Wrap(err, "could not join target to action")
+	}
+
+	return action, nil
+}
+
+// DeliverDue - posts at most limit deliveries due now, returns the number of attempts made.
+// Deliveries are leased before they are attempted, so a dispatcher stopped in the middle of
+// an attempt makes it again later, but two dispatchers running at once may both make it.
+// Deliveries of a rule deleted in the meantime are skipped, the rule takes them along,
+// the ones of a disabled rule are abandoned without an attempt
+func (s *BaseWebhookService) DeliverDue(ctx context.Context, limit int) (int, error) {
+	if limit <= 0 {
+		limit = DefaultWebhookBatchSize
+	}
+
+	now := s.clock.CurrentTime()
+
+	result, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		due, err := tx.Webhooks().SelectDueDeliveries(ctx, now, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range due {
+			leased := due[i]
+			leased.NextAttemptAt = model.JSONTime{Time: now.Add(webhookLease)}
+
+			if err := tx.Webhooks().UpdateDelivery(ctx, &leased); err != nil {
+				return nil, err
+			}
+		}
+
+		return due, nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	due, ok := result.([]model.WebhookDelivery)
+	if !ok {
+		panic("how could result not be of type []model.WebhookDelivery")
+	}
+
+	attempts := 0
+	rules := make(map[model.ID]*model.WebhookRule)
+	for i := range due {
+		d := &due[i]
+
+		rule, ok := rules[d.RuleID]
+		if !ok {
+			if rule, err = s.rule(ctx, d.RuleID); err != nil && errors.Cause(err) != db.ErrNotFound {
+				return attempts, err
+			}
+
+			rules[d.RuleID] = rule
+		}
+
+		if rule == nil {
+			s.lg.Debugf("webhook delivery %d skipped, rule %d is deleted", d.ID, d.RuleID)
+			continue
+		}
+
+		if rule.Enabled {
+			s.attempt(ctx, rule, d)
+			attempts++
+		} else {
+			d.Abandoned("webhook rule is disabled")
+		}
+
+		if _, err := s.db.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+			return nil, tx.Webhooks().UpdateDelivery(ctx, d)
+		}); err != nil {
+			return attempts, errors.Wrapf(err, "could not record attempt of webhook delivery %d", d.ID)
+		}
+	}
+
+	return attempts, nil
+}
+
+// rule - the rule along with its secret
+func (s *BaseWebhookService) rule(ctx context.Context, ID model.ID) (*model.WebhookRule, error) {
+	result, err := s.db.ReadOnly(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		return tx.Webhooks().FirstRuleByID(ctx, ID)
+	})
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not get webhook rule %d", ID)
+	}
+
+	rule, ok := result.(*model.WebhookRule)
+	if !ok {
+		panic("how could result not be of type *model.WebhookRule")
+	}
+
+	return rule, nil
+}
+
+// attempt - posts the payload of the delivery signed with the secret of the rule,
+// the same way services sign their submissions, and records the outcome in the delivery
+func (s *BaseWebhookService) attempt(ctx context.Context, rule *model.WebhookRule, d *model.WebhookDelivery) {
+	req, err := http.NewRequest(http.MethodPost, rule.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		d.Failed(s.clock.CurrentTime(), 0, truncateWebhookError(err.Error()))
+		return
+	}
+
+	ts := s.clock.CurrentTime().Unix()
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookDelivery, strconv.FormatInt(d.ID.Int64(), 10))
+	req.Header.Set(HeaderWebhookEvent, string(d.Event))
+	req.Header.Set(receiver.HeaderSignatureTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(receiver.HeaderSignature, receiver.Sign(rule.Secret, ts, d.Payload))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		s.lg.Debugf("webhook delivery %d to %s failed: %s", d.ID, rule.URL, err)
+		d.Failed(s.clock.CurrentTime(), 0, truncateWebhookError(err.Error()))
+		return
+	}
+
+	defer func() { _ = res.Body.Close() }()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxWebhookErrorLen))
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		d.Delivered(s.clock.CurrentTime(), res.StatusCode)
+		return
+	}
+
+	reason := res.Status
+	if len(body) > 0 {
+		reason += ": " + string(body)
+	}
+
+	d.Failed(s.clock.CurrentTime(), res.StatusCode, truncateWebhookError(reason))
+}
+
+// truncateWebhookError - at most maxWebhookErrorLen bytes of the reason, never splitting a character
+func truncateWebhookError(s string) string {
+	if len(s) <= maxWebhookErrorLen {
+		return s
+	}
+
+	end := maxWebhookErrorLen
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+
+	return s[:end]
+}
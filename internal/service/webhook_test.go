@@ -0,0 +1,287 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/denismitr/auditbase/internal/db"
+	"github.com/denismitr/auditbase/internal/db/sqlite"
+	"github.com/denismitr/auditbase/internal/model"
+	"github.com/denismitr/auditbase/internal/receiver"
+	"github.com/denismitr/auditbase/internal/utils/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBaseWebhookService(t *testing.T) {
+	lg := logger.NewStdoutLogger(logger.Prod, "service_test")
+	conn, err := sqlite.ConnectAndMigrate(context.Background(), lg, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	database := sqlite.NewDatabase(conn, lg)
+	clk := &fakeClock{now: time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)}
+	ctx := context.Background()
+
+	var actionID, entityID model.ID
+	_, err = database.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+		ms, err := tx.Microservices().FirstOrCreateByName(ctx, "billing")
+		if err != nil {
+			return nil, err
+		}
+
+		et, err := tx.EntityTypes().FirstOrCreateByNameAndServiceID(ctx, "invoice", ms.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := tx.Entities().FirstOrCreateByExternalIDAndEntityTypeID(ctx, "42", et.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		created, err := tx.Actions().Create(ctx, &model.Action{
+			UID:            uid("a"),
+			Name:           "invoiceDeleted",
+			Status:         model.Failed,
+			TargetEntityID: e.ID,
+			EmittedAt:      model.JSONTime{Time: clk.now},
+			RegisteredAt:   model.JSONTime{Time: clk.now},
+			Details:        map[string]interface{}{"amount": 250},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		actionID, entityID = created.ID, e.ID
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var received []*http.Request
+	var bodies [][]byte
+	status := http.StatusServiceUnavailable
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, r)
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	s := NewWebhookService(database, lg, clk, server.Client())
+
+	issued, err := s.CreateRule(ctx, &model.WebhookRule{
+		Name:        "incidents",
+		URL:         server.URL,
+		Service:     "billing",
+		NamePattern: "*Deleted",
+		Condition:   "details.amount > 100",
+		Enabled:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NotEmpty(t, issued.Secret)
+
+	paid, err := s.CreateRule(ctx, &model.WebhookRule{Name: "paid", URL: server.URL, NamePattern: "*Paid", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := s.Rules(ctx)
+	if assert.NoError(t, err) && assert.Len(t, rules, 2) {
+		assert.Empty(t, rules[0].Secret)
+	}
+
+	event := &model.ActionEvent{Type: model.ActionCreated, ActionID: actionID}
+
+	n, err := s.Dispatch(ctx, event)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// an event received twice is delivered once
+	_, err = s.Dispatch(ctx, event)
+	assert.NoError(t, err)
+
+	t.Run("failed attempts are retried with backoff", func(t *testing.T) {
+		attempted, err := s.DeliverDue(ctx, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempted)
+
+		attempted, err = s.DeliverDue(ctx, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, attempted, "delivery is not due before its backoff passes")
+
+		log, err := s.Deliveries(ctx, &db.Cursor{Page: 1, PerPage: 10}, db.NewFilter(nil))
+		if assert.NoError(t, err) && assert.Len(t, log.Items, 1) {
+			d := log.Items[0]
+			assert.Equal(t, model.DeliveryPending, d.State)
+			assert.Equal(t, 1, d.Attempts)
+			assert.Equal(t, http.StatusServiceUnavailable, d.ResponseCode)
+			assert.Equal(t, clk.now.Add(30*time.Second), d.NextAttemptAt.UTC())
+		}
+	})
+
+	t.Run("deliveries are signed with the secret of the rule", func(t *testing.T) {
+		mu.Lock()
+		status = http.StatusNoContent
+		mu.Unlock()
+
+		clk.now = clk.now.Add(time.Minute)
+
+		attempted, err := s.DeliverDue(ctx, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempted)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if assert.Len(t, received, 2) {
+			r, body := received[1], bodies[1]
+			ts, _ := strconv.ParseInt(r.Header.Get(receiver.HeaderSignatureTimestamp), 10, 64)
+			assert.Equal(t, clk.now.Unix(), ts)
+			assert.Equal(t, receiver.Sign(issued.Secret, ts, body), r.Header.Get(receiver.HeaderSignature))
+			assert.Equal(t, "created", r.Header.Get(HeaderWebhookEvent))
+
+			var payload struct {
+				Event  string                   `json:"event"`
+				Rule   model.WebhookPayloadRule `json:"rule"`
+				Action model.Action             `json:"action"`
+			}
+			if assert.NoError(t, json.Unmarshal(body, &payload)) {
+				assert.Equal(t, issued.ID, payload.Rule.ID)
+				assert.Equal(t, actionID, payload.Action.ID)
+				assert.Equal(t, "billing", payload.Action.Target.EntityType.Service.Name)
+			}
+		}
+
+		log, err := s.Deliveries(ctx, &db.Cursor{Page: 1, PerPage: 10}, db.NewFilter(nil))
+		if assert.NoError(t, err) && assert.Len(t, log.Items, 1) {
+			assert.Equal(t, model.DeliveryDelivered, log.Items[0].State)
+			assert.Equal(t, 2, log.Items[0].Attempts)
+
+			_, err := s.RetryDelivery(ctx, log.Items[0].ID)
+			assert.Equal(t, ErrWebhookDeliveryNotFailed, errors.Cause(err))
+		}
+	})
+	dispatch := func(t *testing.T, actionUID model.UID, name string, amount int) {
+		result, err := database.ReadWrite(ctx, func(ctx context.Context, tx db.Tx) (interface{}, error) {
+			return tx.Actions().Create(ctx, &model.Action{
+				UID:            actionUID,
+				Name:           name,
+				Status:         model.Failed,
+				TargetEntityID: entityID,
+				EmittedAt:      model.JSONTime{Time: clk.now},
+				RegisteredAt:   model.JSONTime{Time: clk.now},
+				Details:        map[string]interface{}{"amount": amount},
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := s.Dispatch(ctx, &model.ActionEvent{Type: model.ActionCreated, ActionID: result.(*model.Action).ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, 1, n)
+	}
+
+	t.Run("deliveries of a rule deleted after they are leased are skipped", func(t *testing.T) {
+		dispatch(t, uid("b"), "invoicePaid", 10)
+		dispatch(t, uid("c"), "invoiceDeleted", 300)
+
+		deleting := NewWebhookService(&afterFirstWriteDatabase{Database: database, after: func() {
+			if err := s.DeleteRule(ctx, paid.ID); err != nil {
+				t.Fatal(err)
+			}
+		}}, lg, clk, server.Client())
+
+		attempted, err := deleting.DeliverDue(ctx, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempted, "the delivery of the remaining rule is still made")
+
+		mu.Lock()
+		assert.Len(t, received, 3)
+		mu.Unlock()
+
+		log, err := s.Deliveries(ctx, &db.Cursor{Page: 1, PerPage: 10}, db.NewFilter(nil))
+		if assert.NoError(t, err) && assert.Len(t, log.Items, 2) {
+			assert.Equal(t, issued.ID, log.Items[0].RuleID)
+			assert.Equal(t, model.DeliveryDelivered, log.Items[0].State)
+		}
+	})
+
+	t.Run("deliveries of a disabled rule are abandoned without an attempt", func(t *testing.T) {
+		dispatch(t, uid("d"), "invoiceDeleted", 500)
+
+		rule, err := s.Rule(ctx, issued.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rule.Enabled = false
+		if _, err := s.UpdateRule(ctx, rule); err != nil {
+			t.Fatal(err)
+		}
+
+		attempted, err := s.DeliverDue(ctx, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, attempted)
+
+		mu.Lock()
+		assert.Len(t, received, 3)
+		mu.Unlock()
+
+		log, err := s.Deliveries(ctx, &db.Cursor{Page: 1, PerPage: 10}, db.NewFilter(nil))
+		if assert.NoError(t, err) && assert.Len(t, log.Items, 3) {
+			d := log.Items[0]
+			assert.Equal(t, model.DeliveryFailed, d.State)
+			assert.Equal(t, 0, d.Attempts)
+			assert.Equal(t, "webhook rule is disabled", d.LastError)
+
+			retried, err := s.RetryDelivery(ctx, d.ID)
+			if assert.NoError(t, err) {
+				assert.Equal(t, model.DeliveryPending, retried.State)
+			}
+		}
+	})
+}
+
+// afterFirstWriteDatabase - calls after once the first read-write transaction is over,
+// e.g. once due deliveries are leased or the first batch of a sweep is committed
+type afterFirstWriteDatabase struct {
+	db.Database
+	after func()
+}
+
+func (d *afterFirstWriteDatabase) ReadWrite(ctx context.Context, cb db.TxCallback) (interface{}, error) {
+	result, err := d.Database.ReadWrite(ctx, cb)
+
+	if d.after != nil {
+		after := d.after
+		d.after = nil
+		after()
+	}
+
+	return result, err
+}
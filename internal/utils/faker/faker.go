@@ -54,3 +54,21 @@ func ChangeValue(nullable bool) interface{} {
 }
 
 
+
+// TypedChangeValue - random property value together with the name of its type
+func TypedChangeValue() (string, interface{}) {
+	formats := []string{"string", "integer", "float", "boolean"}
+
+	format := formats[random.Int(0, len(formats) - 1)]
+
+	switch format {
+	case "integer":
+		return format, random.Int(0, 100000)
+	case "float":
+		return format, float64(random.Int(0, 100000)) / 100
+	case "boolean":
+		return format, random.Bool()
+	default:
+		return format, random.String(random.Int(5, 100))
+	}
+}
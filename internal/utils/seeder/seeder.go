@@ -20,6 +20,16 @@ type Result struct {
 	Elapsed    time.Duration
 }
 
+// Crud - kind of property changes generated actions carry
+type Crud int
+
+const (
+	AnyAction Crud = iota
+	CreateAction
+	UpdateAction
+	DeleteAction
+)
+
 type Sender struct {
 	endpoint string
 	client   *http.Client
@@ -36,7 +46,7 @@ func NewSender(endpoint string, lg *log.Logger) *Sender {
 	}
 }
 
-func GenerateNewActions(n int, crud model.Crud) <-chan model.NewAction {
+func GenerateNewActions(n int, crud Crud) <-chan model.NewAction {
 	c := make(chan model.NewAction)
 
 	go func() {
@@ -61,22 +71,33 @@ func GenerateNewActions(n int, crud model.Crud) <-chan model.NewAction {
 				Name:             faker.WrappedString("event", "name", 2),
 			}
 
-			detailsCount := random.Int(3, 15)
-			details := make(map[string]interface{})
-			for i := 0; i < detailsCount; i++ {
+			deltaCount := random.Int(3, 15)
+			seen := make(map[string]bool)
+			for i := 0; i < deltaCount; i++ {
 				key := faker.WrappedString("property", "name", 2)
+				if seen[key] {
+					continue
+				}
+
+				seen[key] = true
+
+				propertyType, to := faker.TypedChangeValue()
+				_, from := faker.TypedChangeValue()
 
 				switch crud {
-				case model.UpdateAction, model.AnyAction:
-					details[key] = [2]interface{}{faker.ChangeValue(false), faker.ChangeValue(true)}
-				case model.CreateAction:
-					details[key] = [2]interface{}{nil, faker.ChangeValue(true)}
-				case model.DeleteAction:
-					details[key] = [2]interface{}{faker.ChangeValue(true), nil}
+				case CreateAction:
+					from = nil
+				case DeleteAction:
+					from, to = to, nil
 				}
-			}
 
-			e.Details = details
+				e.Delta = append(e.Delta, model.PropertyDelta{
+					PropertyName:        key,
+					CurrentPropertyType: model.PropertyType(propertyType),
+					From:                from,
+					To:                  to,
+				})
+			}
 
 			c <- e
 		}
@@ -29,6 +29,13 @@ func (ve *ValidationErrors) Add(key string, err error) {
 	ve.errors[key] = append(ve.errors[key], err)
 }
 
+// Merge all errors of other into ve
+func (ve *ValidationErrors) Merge(other *ValidationErrors) {
+	for key, bag := range other.errors {
+		ve.errors[key] = append(ve.errors[key], bag...)
+	}
+}
+
 func (ve *ValidationErrors) First() (string, error) {
 	for key, bag := range ve.errors {
 		for i := range bag {